@@ -0,0 +1,466 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package cdd
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// ValidationErrors lists every problem found by Validate, each prefixed
+// with the JSON path of the offending field, like
+// printer.duplex.option[1].type.
+type ValidationErrors []string
+
+func (ve ValidationErrors) Error() string {
+	return fmt.Sprintf("%d CDD validation error(s): %s", len(ve), strings.Join(ve, "; "))
+}
+
+// validator accumulates errors while walking a description.
+type validator struct {
+	errs ValidationErrors
+}
+
+func (v *validator) errorf(path, format string, a ...interface{}) {
+	v.errs = append(v.errs, path+": "+fmt.Sprintf(format, a...))
+}
+
+func (v *validator) required(path, value string) {
+	if value == "" {
+		v.errorf(path, "required")
+	}
+}
+
+// enum checks that value is one of allowed.
+func (v *validator) enum(path, value string, allowed ...string) {
+	for _, a := range allowed {
+		if value == a {
+			return
+		}
+	}
+	v.errorf(path, "%q is not one of %s", value, strings.Join(allowed, ", "))
+}
+
+// options checks that a capability has at least one option, and at most
+// one default.
+func (v *validator) options(path string, count int, isDefault func(int) bool) {
+	if count == 0 {
+		v.errorf(path+".option", "at least one option required")
+		return
+	}
+	defaults := 0
+	for i := 0; i < count; i++ {
+		if isDefault(i) {
+			defaults++
+		}
+	}
+	if defaults > 1 {
+		v.errorf(path+".option", "%d options are default; at most one may be", defaults)
+	}
+}
+
+func (v *validator) localizedString(path string, ls *[]LocalizedString) {
+	if ls == nil {
+		return
+	}
+	for i, l := range *ls {
+		v.required(fmt.Sprintf("%s[%d].locale", path, i), l.Locale)
+	}
+}
+
+// Validate checks the description for missing required fields, unknown
+// enum values and out-of-range numbers, which Google Cloud Print would
+// otherwise reject without saying which field is wrong.
+//
+// Returns nil, or ValidationErrors.
+func (cdd *CloudDeviceDescription) Validate() error {
+	var v validator
+	v.required("version", cdd.Version)
+	if cdd.Printer == nil {
+		v.errorf("printer", "required")
+	} else {
+		v.printer("printer", cdd.Printer)
+	}
+
+	if len(v.errs) > 0 {
+		return v.errs
+	}
+	return nil
+}
+
+// Validate checks the description as CloudDeviceDescription.Validate does.
+func (pds *PrinterDescriptionSection) Validate() error {
+	var v validator
+	v.printer("printer", pds)
+
+	if len(v.errs) > 0 {
+		return v.errs
+	}
+	return nil
+}
+
+// DropInvalid copies the description without the capabilities that
+// Validate finds invalid, so that one bad translation doesn't keep the whole
+// printer out of Google Cloud Print. Invalid items of lists, like one vendor
+// capability, are dropped from their lists; other invalid capabilities are
+// dropped entirely.
+//
+// Returns the copy, and the errors of what was dropped; the description
+// itself when nothing was.
+func (pds *PrinterDescriptionSection) DropInvalid() (*PrinterDescriptionSection, ValidationErrors) {
+	var v validator
+	v.printer("printer", pds)
+	if len(v.errs) == 0 {
+		return pds, nil
+	}
+
+	t := reflect.TypeOf(*pds)
+	fields := make(map[string]int, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		fields[strings.Split(t.Field(i).Tag.Get("json"), ",")[0]] = i
+	}
+
+	// The fields to drop, each with the indices of the items to drop from
+	// it; nil to drop all of it.
+	drop := make(map[int]map[int]struct{})
+	for _, e := range v.errs {
+		path := strings.TrimPrefix(e, "printer.")
+		end := strings.IndexAny(path, ".[:")
+		if end < 0 {
+			continue
+		}
+		field, exists := fields[path[:end]]
+		if !exists {
+			continue
+		}
+		if indices, exists := drop[field]; exists && indices == nil {
+			continue
+		}
+		if path[end] == '[' {
+			if close := strings.IndexByte(path[end:], ']'); close > 0 {
+				if i, err := strconv.Atoi(path[end+1 : end+close]); err == nil {
+					if drop[field] == nil {
+						drop[field] = make(map[int]struct{})
+					}
+					drop[field][i] = struct{}{}
+					continue
+				}
+			}
+		}
+		drop[field] = nil
+	}
+
+	c := *pds
+	cv := reflect.ValueOf(&c).Elem()
+	for field, indices := range drop {
+		f := cv.Field(field)
+		if indices == nil || f.Elem().Kind() != reflect.Slice {
+			f.Set(reflect.Zero(f.Type()))
+			continue
+		}
+		items := f.Elem()
+		kept := reflect.MakeSlice(items.Type(), 0, items.Len())
+		for i := 0; i < items.Len(); i++ {
+			if _, exists := indices[i]; !exists {
+				kept = reflect.Append(kept, items.Index(i))
+			}
+		}
+		if kept.Len() == 0 {
+			f.Set(reflect.Zero(f.Type()))
+			continue
+		}
+		f.Set(reflect.New(items.Type()))
+		f.Elem().Set(kept)
+	}
+	return &c, v.errs
+}
+
+func (v *validator) printer(path string, pds *PrinterDescriptionSection) {
+	if pds.SupportedContentType != nil {
+		for i, sct := range *pds.SupportedContentType {
+			v.required(fmt.Sprintf("%s.supported_content_type[%d].content_type", path, i), sct.ContentType)
+		}
+	}
+	if pds.PrintingSpeed != nil {
+		for i, o := range pds.PrintingSpeed.Option {
+			p := fmt.Sprintf("%s.printing_speed.option[%d]", path, i)
+			if o.SpeedPPM <= 0 {
+				v.errorf(p+".speed_ppm", "%g is not positive", o.SpeedPPM)
+			}
+			if o.ColorType != nil {
+				for j, ct := range *o.ColorType {
+					v.colorType(fmt.Sprintf("%s.color_type[%d]", p, j), ct)
+				}
+			}
+		}
+	}
+	if pds.InputTrayUnit != nil {
+		for i, u := range *pds.InputTrayUnit {
+			p := fmt.Sprintf("%s.input_tray_unit[%d]", path, i)
+			v.required(p+".vendor_id", u.VendorID)
+			v.enum(p+".type", string(u.Type),
+				string(InputTrayUnitCustom), string(InputTrayUnitInputTray), string(InputTrayUnitBypassTray),
+				string(InputTrayUnitManualFeedTray), string(InputTrayUnitLCT), string(InputTrayUnitEnvelopeTray),
+				string(InputTrayUnitRoll))
+		}
+	}
+	if pds.OutputBinUnit != nil {
+		for i, u := range *pds.OutputBinUnit {
+			p := fmt.Sprintf("%s.output_bin_unit[%d]", path, i)
+			v.required(p+".vendor_id", u.VendorID)
+			v.enum(p+".type", string(u.Type),
+				string(OutputBinUnitCustom), string(OutputBinUnitOutputBin), string(OutputBinUnitMailbox),
+				string(OutputBinUnitStacker))
+		}
+	}
+	if pds.Marker != nil {
+		for i, m := range *pds.Marker {
+			p := fmt.Sprintf("%s.marker[%d]", path, i)
+			v.required(p+".vendor_id", m.VendorID)
+			v.enum(p+".type", string(m.Type),
+				string(MarkerCustom), string(MarkerToner), string(MarkerInk), string(MarkerStaples))
+			if m.Color != nil {
+				v.markerColor(p+".color", m.Color)
+			}
+			v.localizedString(p+".custom_display_name_localized", m.CustomDisplayNameLocalized)
+		}
+	}
+	if pds.Cover != nil {
+		for i, c := range *pds.Cover {
+			p := fmt.Sprintf("%s.cover[%d]", path, i)
+			v.required(p+".vendor_id", c.VendorID)
+			v.enum(p+".type", string(c.Type), string(CoverTypeCustom), string(CoverTypeDoor), string(CoverTypeCover))
+		}
+	}
+	if pds.MediaPath != nil {
+		for i, mp := range *pds.MediaPath {
+			v.required(fmt.Sprintf("%s.media_path[%d].vendor_id", path, i), mp.VendorID)
+		}
+	}
+	if pds.VendorCapability != nil {
+		ids := make(map[string]struct{}, len(*pds.VendorCapability))
+		for i := range *pds.VendorCapability {
+			vc := &(*pds.VendorCapability)[i]
+			p := fmt.Sprintf("%s.vendor_capability[%d]", path, i)
+			if _, exists := ids[vc.ID]; exists {
+				v.errorf(p+".id", "%q is not unique", vc.ID)
+			}
+			ids[vc.ID] = struct{}{}
+			v.vendorCapability(p, vc)
+		}
+	}
+	if pds.Color != nil {
+		p := path + ".color"
+		v.options(p, len(pds.Color.Option), func(i int) bool { return pds.Color.Option[i].IsDefault })
+		for i, o := range pds.Color.Option {
+			v.colorType(fmt.Sprintf("%s.option[%d].type", p, i), o.Type)
+			v.localizedString(fmt.Sprintf("%s.option[%d].custom_display_name_localized", p, i), o.CustomDisplayNameLocalized)
+		}
+	}
+	if pds.Duplex != nil {
+		p := path + ".duplex"
+		v.options(p, len(pds.Duplex.Option), func(i int) bool { return pds.Duplex.Option[i].IsDefault })
+		for i, o := range pds.Duplex.Option {
+			v.enum(fmt.Sprintf("%s.option[%d].type", p, i), string(o.Type),
+				string(DuplexNoDuplex), string(DuplexLongEdge), string(DuplexShortEdge))
+		}
+	}
+	if pds.PageOrientation != nil {
+		p := path + ".page_orientation"
+		v.options(p, len(pds.PageOrientation.Option), func(i int) bool { return pds.PageOrientation.Option[i].IsDefault })
+		for i, o := range pds.PageOrientation.Option {
+			v.enum(fmt.Sprintf("%s.option[%d].type", p, i), string(o.Type),
+				string(PageOrientationPortrait), string(PageOrientationLandscape), string(PageOrientationAuto))
+		}
+	}
+	if pds.Copies != nil {
+		p := path + ".copies"
+		if pds.Copies.Default < 1 {
+			v.errorf(p+".default", "%d is less than 1", pds.Copies.Default)
+		}
+		if pds.Copies.Max < pds.Copies.Default {
+			v.errorf(p+".max", "%d is less than default %d", pds.Copies.Max, pds.Copies.Default)
+		}
+	}
+	if pds.Margins != nil {
+		p := path + ".margins"
+		v.options(p, len(pds.Margins.Option), func(i int) bool { return pds.Margins.Option[i].IsDefault })
+		for i, o := range pds.Margins.Option {
+			op := fmt.Sprintf("%s.option[%d]", p, i)
+			v.enum(op+".type", string(o.Type), string(MarginsBorderless), string(MarginsStandard), string(MarginsCustom))
+			if o.TopMicrons < 0 || o.RightMicrons < 0 || o.BottomMicrons < 0 || o.LeftMicrons < 0 {
+				v.errorf(op, "margins must not be negative")
+			}
+		}
+	}
+	if pds.DPI != nil {
+		p := path + ".dpi"
+		v.options(p, len(pds.DPI.Option), func(i int) bool { return pds.DPI.Option[i].IsDefault })
+		for i, o := range pds.DPI.Option {
+			op := fmt.Sprintf("%s.option[%d]", p, i)
+			if o.HorizontalDPI <= 0 {
+				v.errorf(op+".horizontal_dpi", "%d is not positive", o.HorizontalDPI)
+			}
+			if o.VerticalDPI <= 0 {
+				v.errorf(op+".vertical_dpi", "%d is not positive", o.VerticalDPI)
+			}
+			v.localizedString(op+".custom_display_name_localized", o.CustomDisplayNameLocalized)
+		}
+	}
+	if pds.FitToPage != nil {
+		p := path + ".fit_to_page"
+		v.options(p, len(pds.FitToPage.Option), func(i int) bool { return pds.FitToPage.Option[i].IsDefault })
+		for i, o := range pds.FitToPage.Option {
+			v.enum(fmt.Sprintf("%s.option[%d].type", p, i), string(o.Type),
+				string(FitToPageNoFitting), string(FitToPageFitToPage), string(FitToPageGrowToPage),
+				string(FitToPageShrinkToPage), string(FitToPageFillPage))
+		}
+	}
+	if pds.PageRange != nil {
+		for i, interval := range pds.PageRange.Interval {
+			p := fmt.Sprintf("%s.page_range.interval[%d]", path, i)
+			if interval.Start < 1 {
+				v.errorf(p+".start", "%d is less than 1", interval.Start)
+			}
+			if interval.End != 0 && interval.End < interval.Start {
+				v.errorf(p+".end", "%d is less than start %d", interval.End, interval.Start)
+			}
+		}
+	}
+	if pds.MediaSize != nil {
+		p := path + ".media_size"
+		v.options(p, len(pds.MediaSize.Option), func(i int) bool { return pds.MediaSize.Option[i].IsDefault })
+		for i, o := range pds.MediaSize.Option {
+			op := fmt.Sprintf("%s.option[%d]", p, i)
+			if o.WidthMicrons < 0 {
+				v.errorf(op+".width_microns", "%d is negative", o.WidthMicrons)
+			}
+			if o.HeightMicrons < 0 {
+				v.errorf(op+".height_microns", "%d is negative", o.HeightMicrons)
+			}
+			if (o.Name == "" || o.Name == MediaSizeCustom) && o.WidthMicrons == 0 {
+				v.errorf(op, "custom media sizes require width_microns")
+			}
+			if (o.Name == "" || o.Name == MediaSizeCustom) && o.HeightMicrons == 0 && !o.IsContinuousFeed {
+				v.errorf(op, "custom media sizes require height_microns unless continuous feed")
+			}
+			v.localizedString(op+".custom_display_name_localized", o.CustomDisplayNameLocalized)
+		}
+	}
+}
+
+func (v *validator) colorType(path string, ct ColorType) {
+	v.enum(path, string(ct),
+		string(ColorTypeStandardColor), string(ColorTypeStandardMonochrome), string(ColorTypeCustomColor),
+		string(ColorTypeCustomMonochrome), string(ColorTypeAuto))
+}
+
+func (v *validator) markerColor(path string, mc *MarkerColor) {
+	v.enum(path+".type", string(mc.Type),
+		string(MarkerColorCustom), string(MarkerColorBlack), string(MarkerColorColor), string(MarkerColorCyan),
+		string(MarkerColorMagenta), string(MarkerColorYellow), string(MarkerColorLightCyan),
+		string(MarkerColorLightMagenta), string(MarkerColorGray), string(MarkerColorLightGray),
+		string(MarkerColorPigmentBlack), string(MarkerColorMatteBlack), string(MarkerColorPhotoCyan),
+		string(MarkerColorPhotoMagenta), string(MarkerColorPhotoYellow), string(MarkerColorPhotoGray),
+		string(MarkerColorRed), string(MarkerColorGreen), string(MarkerColorBlue))
+	if mc.Type == MarkerColorCustom && mc.CustomDisplayName == "" && mc.CustomDisplayNameLocalized == nil {
+		v.errorf(path, "CUSTOM marker colors require a custom display name")
+	}
+}
+
+func (v *validator) vendorCapability(path string, vc *VendorCapability) {
+	v.required(path+".id", vc.ID)
+	v.localizedString(path+".display_name_localized", vc.DisplayNameLocalized)
+
+	switch vc.Type {
+	case VendorCapabilityRange:
+		if vc.RangeCap == nil {
+			v.errorf(path+".range_cap", "required for type %s", vc.Type)
+			return
+		}
+		v.rangeCapability(path+".range_cap", vc.RangeCap)
+
+	case VendorCapabilitySelect:
+		if vc.SelectCap == nil {
+			v.errorf(path+".select_cap", "required for type %s", vc.Type)
+			return
+		}
+		p := path + ".select_cap"
+		options := vc.SelectCap.Option
+		v.options(p, len(options), func(i int) bool { return options[i].IsDefault })
+		values := make(map[string]struct{}, len(options))
+		for i, o := range options {
+			op := fmt.Sprintf("%s.option[%d]", p, i)
+			if _, exists := values[o.Value]; exists {
+				v.errorf(op+".value", "%q is not unique", o.Value)
+			}
+			values[o.Value] = struct{}{}
+			v.localizedString(op+".display_name_localized", o.DisplayNameLocalized)
+		}
+
+	case VendorCapabilityTypedValue:
+		if vc.TypedValueCap == nil {
+			v.errorf(path+".typed_value_cap", "required for type %s", vc.Type)
+			return
+		}
+		v.enum(path+".typed_value_cap.value_type", string(vc.TypedValueCap.ValueType),
+			string(TypedValueCapabilityTypeBoolean), string(TypedValueCapabilityTypeFloat),
+			string(TypedValueCapabilityTypeInteger), string(TypedValueCapabilityTypeString))
+
+	default:
+		v.enum(path+".type", string(vc.Type),
+			string(VendorCapabilityRange), string(VendorCapabilitySelect), string(VendorCapabilityTypedValue))
+	}
+}
+
+func (v *validator) rangeCapability(path string, rc *RangeCapability) {
+	var bitSize int
+	switch rc.ValueType {
+	case RangeCapabilityValueFloat:
+		bitSize = 64
+	case RangeCapabilityValueInteger:
+		bitSize = 0
+	default:
+		v.enum(path+".value_type", string(rc.ValueType),
+			string(RangeCapabilityValueFloat), string(RangeCapabilityValueInteger))
+		return
+	}
+
+	parse := func(field, s string) (float64, bool) {
+		if s == "" {
+			return 0, false
+		}
+		var f float64
+		var err error
+		if bitSize == 0 {
+			var i int64
+			i, err = strconv.ParseInt(s, 10, 64)
+			f = float64(i)
+		} else {
+			f, err = strconv.ParseFloat(s, bitSize)
+		}
+		if err != nil {
+			v.errorf(path+"."+field, "%q is not a valid %s", s, rc.ValueType)
+			return 0, false
+		}
+		return f, true
+	}
+
+	min, hasMin := parse("min", rc.Min)
+	max, hasMax := parse("max", rc.Max)
+	def, hasDef := parse("default", rc.Default)
+	if hasMin && hasMax && min > max {
+		v.errorf(path, "min %s is greater than max %s", rc.Min, rc.Max)
+	}
+	if hasDef && ((hasMin && def < min) || (hasMax && def > max)) {
+		v.errorf(path+".default", "%s is outside of the range", rc.Default)
+	}
+}
@@ -0,0 +1,176 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package cdd
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	for _, c := range []struct {
+		name   string
+		cdd    CloudDeviceDescription
+		errors []string
+	}{
+		{
+			"valid",
+			CloudDeviceDescription{Version: "1.0", Printer: &PrinterDescriptionSection{
+				SupportedContentType: NewSupportedContentType("application/pdf"),
+				Color:                &Color{Option: []ColorOption{{Type: ColorTypeStandardColor, IsDefault: true}, {Type: ColorTypeStandardMonochrome}}},
+				Copies:               &Copies{Default: 1, Max: 100},
+				MediaSize:            &MediaSize{Option: []MediaSizeOption{{Name: MediaSizeISOA4, IsDefault: true}, {WidthMicrons: 100000, IsContinuousFeed: true}}},
+			}},
+			nil,
+		},
+		{
+			"required fields",
+			CloudDeviceDescription{},
+			[]string{"version: required", "printer: required"},
+		},
+		{
+			"required content type",
+			CloudDeviceDescription{Version: "1.0", Printer: &PrinterDescriptionSection{
+				SupportedContentType: NewSupportedContentType(""),
+			}},
+			[]string{"printer.supported_content_type[0].content_type: required"},
+		},
+		{
+			"enums",
+			CloudDeviceDescription{Version: "1.0", Printer: &PrinterDescriptionSection{
+				Color:  &Color{Option: []ColorOption{{Type: "SEPIA"}}},
+				Duplex: &Duplex{Option: []DuplexOption{{Type: DuplexLongEdge}, {Type: "TOP_EDGE"}}},
+			}},
+			[]string{
+				`printer.color.option[0].type: "SEPIA" is not one of STANDARD_COLOR, STANDARD_MONOCHROME, CUSTOM_COLOR, CUSTOM_MONOCHROME, AUTO`,
+				`printer.duplex.option[1].type: "TOP_EDGE" is not one of NO_DUPLEX, LONG_EDGE, SHORT_EDGE`,
+			},
+		},
+		{
+			"option defaults",
+			CloudDeviceDescription{Version: "1.0", Printer: &PrinterDescriptionSection{
+				Color:  &Color{},
+				Duplex: &Duplex{Option: []DuplexOption{{Type: DuplexNoDuplex, IsDefault: true}, {Type: DuplexLongEdge, IsDefault: true}}},
+			}},
+			[]string{
+				"printer.color.option: at least one option required",
+				"printer.duplex.option: 2 options are default; at most one may be",
+			},
+		},
+		{
+			"ranges",
+			CloudDeviceDescription{Version: "1.0", Printer: &PrinterDescriptionSection{
+				VendorCapability: &[]VendorCapability{
+					{ID: "a", Type: VendorCapabilityRange, RangeCap: &RangeCapability{ValueType: RangeCapabilityValueInteger, Min: "5", Max: "1"}},
+					{ID: "b", Type: VendorCapabilityRange, RangeCap: &RangeCapability{ValueType: RangeCapabilityValueFloat, Min: "0.5", Max: "1.5", Default: "2"}},
+					{ID: "c", Type: VendorCapabilityRange, RangeCap: &RangeCapability{ValueType: RangeCapabilityValueInteger, Min: "0.5"}},
+					{ID: "d", Type: VendorCapabilityRange},
+					{ID: "d", Type: VendorCapabilityRange, RangeCap: &RangeCapability{ValueType: "DATE"}},
+				},
+			}},
+			[]string{
+				"printer.vendor_capability[0].range_cap: min 5 is greater than max 1",
+				"printer.vendor_capability[1].range_cap.default: 2 is outside of the range",
+				`printer.vendor_capability[2].range_cap.min: "0.5" is not a valid INTEGER`,
+				"printer.vendor_capability[3].range_cap: required for type RANGE",
+				`printer.vendor_capability[4].id: "d" is not unique`,
+				`printer.vendor_capability[4].range_cap.value_type: "DATE" is not one of FLOAT, INTEGER`,
+			},
+		},
+		{
+			"copies",
+			CloudDeviceDescription{Version: "1.0", Printer: &PrinterDescriptionSection{
+				Copies: &Copies{Default: 0, Max: -1},
+			}},
+			[]string{
+				"printer.copies.default: 0 is less than 1",
+				"printer.copies.max: -1 is less than default 0",
+			},
+		},
+		{
+			"media sizes",
+			CloudDeviceDescription{Version: "1.0", Printer: &PrinterDescriptionSection{
+				MediaSize: &MediaSize{Option: []MediaSizeOption{
+					{Name: MediaSizeISOA4, WidthMicrons: -1},
+					{Name: MediaSizeCustom, HeightMicrons: 100000},
+					{WidthMicrons: 100000},
+				}},
+			}},
+			[]string{
+				"printer.media_size.option[0].width_microns: -1 is negative",
+				"printer.media_size.option[1]: custom media sizes require width_microns",
+				"printer.media_size.option[2]: custom media sizes require height_microns unless continuous feed",
+			},
+		},
+	} {
+		err := c.cdd.Validate()
+		if c.errors == nil {
+			if err != nil {
+				t.Logf("%s: expected no errors, got %s", c.name, err)
+				t.Fail()
+			}
+			continue
+		}
+		ve, ok := err.(ValidationErrors)
+		if !ok || !reflect.DeepEqual([]string(ve), c.errors) {
+			t.Logf("%s: expected %q, got %v", c.name, c.errors, err)
+			t.Fail()
+		}
+	}
+}
+
+func TestDropInvalid(t *testing.T) {
+	valid := &PrinterDescriptionSection{
+		Copies: &Copies{Default: 1, Max: 10},
+	}
+	if pds, errs := valid.DropInvalid(); pds != valid || errs != nil {
+		t.Logf("expected a valid description to be kept, got %+v, %s", pds, errs)
+		t.Fail()
+	}
+
+	pds := &PrinterDescriptionSection{
+		Copies: &Copies{Default: 1, Max: 10},
+		Color:  &Color{Option: []ColorOption{{Type: "SEPIA"}}},
+		VendorCapability: &[]VendorCapability{
+			{ID: "a", Type: VendorCapabilitySelect, SelectCap: &SelectCapability{Option: []SelectCapabilityOption{{Value: "1"}}}},
+			{ID: "b", Type: VendorCapabilityRange},
+			{ID: "c", Type: VendorCapabilityTypedValue, TypedValueCap: &TypedValueCapability{ValueType: TypedValueCapabilityTypeBoolean}},
+		},
+	}
+	dropped, errs := pds.DropInvalid()
+	if len(errs) != 2 {
+		t.Logf("expected 2 errors, got %q", errs)
+		t.Fail()
+	}
+	if dropped.Color != nil || dropped.Copies != pds.Copies {
+		t.Logf("expected only the invalid color to be dropped, got %+v", dropped)
+		t.Fail()
+	}
+	if dropped.VendorCapability == nil || len(*dropped.VendorCapability) != 2 ||
+		(*dropped.VendorCapability)[0].ID != "a" || (*dropped.VendorCapability)[1].ID != "c" {
+		t.Logf("expected only the invalid vendor capability to be dropped, got %+v", dropped.VendorCapability)
+		t.Fail()
+	}
+	if err := dropped.Validate(); err != nil {
+		t.Logf("expected the copy to be valid, got %s", err)
+		t.Fail()
+	}
+	if pds.Color == nil || len(*pds.VendorCapability) != 3 {
+		t.Log("expected the description itself to be unchanged")
+		t.Fail()
+	}
+
+	all := &PrinterDescriptionSection{
+		Cover: &[]Cover{{Type: CoverTypeDoor}},
+	}
+	if dropped, _ := all.DropInvalid(); dropped.Cover != nil {
+		t.Logf("expected a list without valid items to be dropped, got %+v", dropped.Cover)
+		t.Fail()
+	}
+}
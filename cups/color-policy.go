@@ -0,0 +1,153 @@
+// Copyright 2015 Google Inc. All rights reserved.
+
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+// +build linux darwin freebsd
+
+package cups
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"time"
+
+	"github.com/google/cloud-print-connector/cdd"
+	"github.com/google/cloud-print-connector/lib"
+	"github.com/google/cloud-print-connector/log"
+)
+
+// colorRule limits color printing on the printers whose names match
+// Printer, to control the cost of color. Users in AllowedUsers, which may
+// name groups, print in color at any time; other users print in color only within ColorHours,
+// like "09:00-17:00" in local time, and otherwise in grayscale. A rule
+// with neither prints every job in grayscale, unless AllowColor is set:
+// then everyone prints in color, which exempts printers from the rules
+// after it.
+type colorRule struct {
+	Printer      string   `json:"printer"`
+	AllowColor   bool     `json:"allow_color,omitempty"`
+	AllowedUsers []string `json:"allowed_users,omitempty"`
+	ColorHours   string   `json:"color_hours,omitempty"`
+
+	printer      *regexp.Regexp
+	allowedUsers *lib.UserList
+	// colorFrom and colorUntil are minutes after midnight; colorUntil is
+	// before colorFrom when the hours span midnight.
+	colorFrom, colorUntil int
+}
+
+// colorPolicy is a list of colorRules; the first rule that matches a
+// printer applies to its jobs.
+type colorPolicy []colorRule
+
+// rColorHours matches the color hours of a colorRule.
+var rColorHours = regexp.MustCompile(`^([01]?\d|2[0-3]):([0-5]\d)-([01]?\d|2[0-3]):([0-5]\d)$`)
+
+// newColorPolicy reads the color policy in filename, a JSON array of
+// colorRule objects, whose groups are resolved by groups. An empty
+// filename reads no file, and allows color.
+func newColorPolicy(filename string, groups *lib.GroupResolver) (colorPolicy, error) {
+	if filename == "" {
+		return colorPolicy{}, nil
+	}
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var p colorPolicy
+	if err = json.Unmarshal(b, &p); err != nil {
+		return nil, fmt.Errorf("Failed to parse color policy file %s: %s", filename, err)
+	}
+
+	for i := range p {
+		if p[i].printer, err = regexp.Compile(p[i].Printer); err != nil {
+			return nil, fmt.Errorf("Color rule %d printer is not valid: %s", i, err)
+		}
+		if p[i].AllowColor && (len(p[i].AllowedUsers) > 0 || p[i].ColorHours != "") {
+			return nil, fmt.Errorf("Color rule %d allows color to everyone, so it should have no allowed_users or color_hours", i)
+		}
+		if p[i].allowedUsers, err = lib.NewUserList(p[i].AllowedUsers, groups); err != nil {
+			return nil, fmt.Errorf("Color rule %d allowed_users are not valid: %s", i, err)
+		}
+		if p[i].ColorHours != "" {
+			parts := rColorHours.FindStringSubmatch(p[i].ColorHours)
+			if parts == nil {
+				return nil, fmt.Errorf("Color rule %d color_hours %q is not like 09:00-17:00", i, p[i].ColorHours)
+			}
+			p[i].colorFrom = minutesOfDay(parts[1], parts[2])
+			p[i].colorUntil = minutesOfDay(parts[3], parts[4])
+		}
+	}
+
+	return p, nil
+}
+
+func minutesOfDay(hours, minutes string) int {
+	var h, m int
+	fmt.Sscan(hours, &h)
+	fmt.Sscan(minutes, &m)
+	return h*60 + m
+}
+
+// allowsColor tells whether a rule lets a user print in color at a time.
+func (r *colorRule) allowsColor(user string, now time.Time) bool {
+	if r.AllowColor {
+		return true
+	}
+	allowed, err := r.allowedUsers.Contains(user)
+	if err != nil {
+		log.Warning(err)
+	}
+	if allowed {
+		return true
+	}
+	if r.ColorHours == "" {
+		return false
+	}
+	minute := now.Hour()*60 + now.Minute()
+	if r.colorFrom <= r.colorUntil {
+		return minute >= r.colorFrom && minute < r.colorUntil
+	}
+	return minute >= r.colorFrom || minute < r.colorUntil
+}
+
+// enforce sets the color options of a job to the printer's monochrome
+// option, when the policy doesn't let the user print in color on the
+// printer now. Returns a note of why, for the job audit, or the empty
+// string when the job prints as it is.
+func (p colorPolicy) enforce(printer *lib.Printer, user string, now time.Time, options map[string]string) (string, error) {
+	for i := range p {
+		rule := &p[i]
+		if !rule.printer.MatchString(printer.Name) {
+			continue
+		}
+		if rule.allowsColor(user, now) {
+			return "", nil
+		}
+		if printer.Description == nil || printer.Description.Color == nil {
+			// The printer doesn't choose color; likely it's monochrome.
+			return "", nil
+		}
+
+		for _, o := range printer.Description.Color.Option {
+			if o.Type != cdd.ColorTypeStandardMonochrome && o.Type != cdd.ColorTypeCustomMonochrome {
+				continue
+			}
+			parts := rVendorIDKeyValue.FindStringSubmatch(o.VendorID)
+			if parts == nil || parts[2] == "" {
+				continue
+			}
+			options[parts[1]] = parts[2]
+			if rule.ColorHours == "" {
+				return fmt.Sprintf("Printed in grayscale: %s may not print in color on %s", user, printer.Name), nil
+			}
+			return fmt.Sprintf("Printed in grayscale: %s may print in color on %s only %s", user, printer.Name, rule.ColorHours), nil
+		}
+		return "", fmt.Errorf("Color printing on %s is limited by policy, but the printer has no grayscale option", printer.Name)
+	}
+	return "", nil
+}
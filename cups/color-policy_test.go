@@ -0,0 +1,110 @@
+// Copyright 2015 Google Inc. All rights reserved.
+
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+// +build linux darwin freebsd
+
+package cups
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/cloud-print-connector/cdd"
+	"github.com/google/cloud-print-connector/lib"
+)
+
+func TestColorPolicy(t *testing.T) {
+	f, err := ioutil.TempFile("", "color-policy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`[
+		{"printer": "^lobby-9$", "allow_color": true},
+		{"printer": "^lobby-", "allowed_users": ["Boss@example.com"]},
+		{"printer": "^office-", "allowed_users": ["boss@example.com"], "color_hours": "09:00-17:00"},
+		{"printer": "^night-", "color_hours": "22:00-06:00"}
+	]`)
+	f.Close()
+
+	p, err := newColorPolicy(f.Name(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	color := &cdd.Color{Option: []cdd.ColorOption{
+		{VendorID: "ColorModel:RGB", Type: cdd.ColorTypeStandardColor, IsDefault: true},
+		{VendorID: "ColorModel:Gray", Type: cdd.ColorTypeStandardMonochrome},
+	}}
+	noon := time.Date(2016, 3, 1, 12, 0, 0, 0, time.Local)
+	evening := time.Date(2016, 3, 1, 19, 30, 0, 0, time.Local)
+	midnight := time.Date(2016, 3, 1, 0, 0, 0, 0, time.Local)
+
+	testCases := []struct {
+		printer   string
+		user      string
+		now       time.Time
+		grayscale bool
+	}{
+		{"lobby-1", "boss@example.com", noon, false},
+		{"lobby-1", "intern@example.com", noon, true},
+		{"lobby-9", "intern@example.com", noon, false},
+		{"office-1", "intern@example.com", noon, false},
+		{"office-1", "intern@example.com", evening, true},
+		{"office-1", "boss@example.com", evening, false},
+		{"night-1", "intern@example.com", midnight, false},
+		{"night-1", "intern@example.com", noon, true},
+		{"other", "intern@example.com", midnight, false},
+	}
+	for _, tc := range testCases {
+		printer := lib.Printer{Name: tc.printer, Description: &cdd.PrinterDescriptionSection{Color: color}}
+		options := map[string]string{"ColorModel": "RGB"}
+		note, err := p.enforce(&printer, tc.user, tc.now, options)
+		if err != nil {
+			t.Logf("%s on %s at %s: %s", tc.user, tc.printer, tc.now, err)
+			t.Fail()
+			continue
+		}
+		if grayscale := options["ColorModel"] == "Gray"; grayscale != tc.grayscale || (note != "") != tc.grayscale {
+			t.Logf("%s on %s at %s: expected grayscale %t, got options %v and note %q",
+				tc.user, tc.printer, tc.now, tc.grayscale, options, note)
+			t.Fail()
+		}
+	}
+
+	printer := lib.Printer{Name: "lobby-2", Description: &cdd.PrinterDescriptionSection{
+		Color: &cdd.Color{Option: []cdd.ColorOption{color.Option[0]}},
+	}}
+	if _, err := p.enforce(&printer, "intern@example.com", noon, map[string]string{}); err == nil {
+		t.Log("expected an error for a color printer without a grayscale option")
+		t.Fail()
+	}
+}
+
+func TestColorPolicyBadHours(t *testing.T) {
+	f, err := ioutil.TempFile("", "color-policy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`[{"printer": ".", "color_hours": "9am-5pm"}]`)
+	f.Close()
+
+	if _, err := newColorPolicy(f.Name(), nil); err == nil {
+		t.Log("expected an error for color hours 9am-5pm")
+		t.Fail()
+	}
+
+	if err = ioutil.WriteFile(f.Name(), []byte(`[{"printer": ".", "allow_color": true, "color_hours": "09:00-17:00"}]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newColorPolicy(f.Name(), nil); err == nil {
+		t.Log("expected an error for color hours in a rule that allows color")
+		t.Fail()
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright 2015 Google Inc. All rights reserved.
+
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+// +build linux darwin freebsd
+
+package cups
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/google/cloud-print-connector/lib"
+)
+
+const (
+	// sniffBytes is as much as http.DetectContentType considers.
+	sniffBytes = 512
+
+	mimeOctetStream = "application/octet-stream"
+)
+
+// contentSignatures are the formats that print jobs come in, which
+// http.DetectContentType doesn't know, by their first bytes.
+var contentSignatures = []struct {
+	prefix      []byte
+	contentType string
+}{
+	{[]byte("%PDF-"), mimePDF},
+	{[]byte("%!"), "application/postscript"},
+	{[]byte("\x04%!"), "application/postscript"},
+	{[]byte("RaS2"), "image/pwg-raster"},
+	{[]byte("UNIRAST\x00"), "image/urf"},
+	{[]byte("\x1bE"), "application/vnd.hp-PCL"},
+}
+
+// sniffContentType guesses the MIME type of a file from its first bytes.
+// Returns application/octet-stream when the type is unknown.
+func sniffContentType(filename string) (string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	head := make([]byte, sniffBytes)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	head = head[:n]
+
+	for _, s := range contentSignatures {
+		if bytes.HasPrefix(head, s.prefix) {
+			return s.contentType, nil
+		}
+	}
+
+	contentType, _, err := mime.ParseMediaType(http.DetectContentType(head))
+	if err != nil {
+		return mimeOctetStream, nil
+	}
+	return contentType, nil
+}
+
+// chooseDocumentFormat compares a job's content type with the formats that
+// a queue accepts, directly or by conversion. Returns the empty string when
+// the content type is unknown, so that cupsd should detect it.
+func chooseDocumentFormat(contentType string, supported []string) (string, error) {
+	if contentType == mimeOctetStream || len(supported) == 0 {
+		return "", nil
+	}
+	for _, s := range supported {
+		if strings.EqualFold(s, contentType) {
+			return s, nil
+		}
+	}
+	return "", fmt.Errorf("Job content type %s is not among the queue's supported formats %s",
+		contentType, strings.Join(supported, ","))
+}
+
+// documentFormat gets the document-format option for a job, rather than
+// leave cupsd to detect it, which misfires on some files.
+func documentFormat(printer *lib.Printer, filename string) (string, error) {
+	supported := strings.Split(printer.Tags[attrDocumentFormatSupported], ",")
+	if supported[0] == "" {
+		return "", nil
+	}
+
+	contentType, err := sniffContentType(filename)
+	if err != nil {
+		return "", err
+	}
+	return chooseDocumentFormat(contentType, supported)
+}
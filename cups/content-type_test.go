@@ -0,0 +1,99 @@
+// Copyright 2015 Google Inc. All rights reserved.
+
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+// +build linux darwin freebsd
+
+package cups
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/google/cloud-print-connector/lib"
+)
+
+func TestSniffContentType(t *testing.T) {
+	testCases := []struct {
+		content     string
+		contentType string
+	}{
+		{"%PDF-1.4\n", "application/pdf"},
+		{"%!PS-Adobe-3.0\n", "application/postscript"},
+		{"RaS2PwgRaster\x00", "image/pwg-raster"},
+		{"UNIRAST\x00\x01", "image/urf"},
+		{"\x1bE\x1b&l0O", "application/vnd.hp-PCL"},
+		{"\x89PNG\x0d\x0a\x1a\x0a", "image/png"},
+		{"\xff\xd8\xff\xe0", "image/jpeg"},
+		{"Hello, printer\n", "text/plain"},
+		{"\x00\x01\x02\x03", "application/octet-stream"},
+	}
+
+	for _, tc := range testCases {
+		f, err := ioutil.TempFile("", "content-type")
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.WriteString(tc.content)
+		f.Close()
+
+		contentType, err := sniffContentType(f.Name())
+		os.Remove(f.Name())
+		if err != nil || contentType != tc.contentType {
+			t.Logf("%q: expected %s, got %s %v", tc.content, tc.contentType, contentType, err)
+			t.Fail()
+		}
+	}
+}
+
+func TestChooseDocumentFormat(t *testing.T) {
+	supported := []string{"application/octet-stream", "application/pdf", "image/jpeg", "text/plain"}
+
+	if format, err := chooseDocumentFormat("application/pdf", supported); err != nil || format != "application/pdf" {
+		t.Logf("expected application/pdf, got %q %v", format, err)
+		t.Fail()
+	}
+	if format, err := chooseDocumentFormat("application/octet-stream", supported); err != nil || format != "" {
+		t.Logf("expected unknown content to be left to cupsd, got %q %v", format, err)
+		t.Fail()
+	}
+	if format, err := chooseDocumentFormat("image/pwg-raster", supported); err == nil {
+		t.Logf("expected unsupported content to fail, got %q", format)
+		t.Fail()
+	}
+	if format, err := chooseDocumentFormat("image/pwg-raster", nil); err != nil || format != "" {
+		t.Logf("expected no supported formats to leave detection to cupsd, got %q %v", format, err)
+		t.Fail()
+	}
+}
+
+func TestDocumentFormat(t *testing.T) {
+	f, err := ioutil.TempFile("", "content-type")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("%PDF-1.4\n")
+	f.Close()
+
+	printer := lib.Printer{Tags: map[string]string{}}
+	if format, err := documentFormat(&printer, f.Name()); err != nil || format != "" {
+		t.Logf("expected no format without document-format-supported, got %q %v", format, err)
+		t.Fail()
+	}
+
+	printer.Tags[attrDocumentFormatSupported] = "application/octet-stream,application/pdf"
+	if format, err := documentFormat(&printer, f.Name()); err != nil || format != "application/pdf" {
+		t.Logf("expected application/pdf, got %q %v", format, err)
+		t.Fail()
+	}
+
+	printer.Tags[attrDocumentFormatSupported] = "application/octet-stream,image/urf"
+	if format, err := documentFormat(&printer, f.Name()); err == nil {
+		t.Logf("expected PDF to be rejected by a URF-only queue, got %q", format)
+		t.Fail()
+	}
+}
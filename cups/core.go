@@ -9,17 +9,24 @@
 package cups
 
 /*
+#cgo LDFLAGS: -lcups
 #cgo freebsd CFLAGS: -I/usr/local/include
 #cgo freebsd LDFLAGS: -L/usr/local/lib
 #include "cups.h"
 */
 import "C"
 import (
+	"crypto/rand"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"runtime"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 	"unsafe"
 
@@ -32,28 +39,123 @@ const (
 	// to do things like query the state of a job.
 	jobURIFormat = "/jobs/%d"
 
+	// printerURIFormat is the printer-uri resource of a printer, to create
+	// and find its jobs.
+	printerURIFormat = "/printers/%s"
+
+	// printFileAttempts is how many times a job is submitted when
+	// submitting fails ambiguously, like on a timeout.
+	printFileAttempts = 2
+
+	// serverURIResource is the printer-uri resource of the whole server,
+	// to request the jobs of every queue.
+	serverURIResource = "/"
+
 	// filePathMaxLength varies by operating system and file system.
 	// This value should be large enough to be useful and small enough
 	// to work on any platform.
 	filePathMaxLength = 1024
+
+	// Attribute names and values repeat across printers; this bounds the
+	// number of distinct strings kept between polls.
+	attrInternMaxEntries = 10000
+
+	// The connection pool is resized this often, and shrinks once it has
+	// been mostly idle for connectionPoolShrinkAfter resizes.
+	connectionPoolResizeInterval = time.Second
+	connectionPoolShrinkAfter    = 60
+
+	// connectionKeepAlive is how long an idle connection is kept open for
+	// the next request; less than cupsd's KeepAliveTimeout, so that cupsd
+	// doesn't close it first.
+	connectionKeepAlive = 10 * time.Second
 )
 
+// RequestTimeouts bound each kind of CUPS request, so that a slow PPD
+// download doesn't get the same deadline as a quick job state poll. Zero is
+// no limit.
+type RequestTimeouts struct {
+	GetPrinters      time.Duration
+	GetPPD           time.Duration
+	PrintFile        time.Duration
+	GetJobAttributes time.Duration
+	// PPD is the deadline for fetching and translating one printer's PPD,
+	// from the cache or CUPS; it bounds how long one PPD can hold up a sync.
+	PPD time.Duration
+}
+
 // cupsCore handles CUPS API interaction and connection management.
 type cupsCore struct {
+	// connOpened and connReused count the connections opened and reused,
+	// to tell how well keep-alive works. They're first, so that they're
+	// aligned for atomic access on 32-bit platforms.
+	connOpened, connReused uint64
+
 	host           *C.char
 	port           C.int
 	encryption     C.http_encryption_t
 	connectTimeout C.int
+	// timeouts bound each kind of request, after connecting.
+	timeouts RequestTimeouts
+	// ppdMaxBytes is the size of the largest PPD to read; zero is no limit.
+	ppdMaxBytes int64
 	// connectionSemaphore limits the quantity of open CUPS connections.
 	connectionSemaphore *lib.Semaphore
+	// connectionPoolSizer grows connectionSemaphore under bursts of
+	// requests, and shrinks it when idle.
+	connectionPoolSizer *lib.PoolSizer
 	// connectionPool allows a connection to be reused instead of closed.
 	connectionPool chan *C.http_t
-	hostIsLocal    bool
+	// jobConnections are idle connections kept for the next request about
+	// each job, by CUPS job ID, so that printing a job and polling its
+	// state don't open a connection for each request. Together with the
+	// connections in use, they stay within connectionSemaphore's size.
+	jobConnections      map[uint32]*jobConnection
+	jobConnectionsMutex sync.Mutex
+	// hostIsLocal is true when the server is the default server, and on
+	// this host, so that PPDs can be read without a connection.
+	hostIsLocal  bool
+	attrInterner *lib.StringInterner
+}
+
+// jobConnection is an idle connection kept for a job.
+type jobConnection struct {
+	http *C.http_t
+}
+
+// newCUPSCore connects to the CUPS server at address, which is host, host:port
+// or the path of a domain socket. An empty address is the server specified by
+// environment variables, client.conf, etc.
+//
+// The quantity of open connections adapts to the load, between
+// minConnections and maxConnections.
+func newCUPSCore(address string, minConnections, maxConnections uint, connectTimeout time.Duration, timeouts RequestTimeouts, ppdMaxBytes int64) (*cupsCore, error) {
+	cc, err := newUncheckedCUPSCore(address, minConnections, maxConnections, connectTimeout, timeouts, ppdMaxBytes)
+	if err != nil {
+		return nil, err
+	}
+	if err = cc.checkConnection(); err != nil {
+		return nil, err
+	}
+	return cc, nil
 }
 
-func newCUPSCore(maxConnections uint, connectTimeout time.Duration) (*cupsCore, error) {
+// newUncheckedCUPSCore creates a cupsCore, like newCUPSCore, without
+// checking that the server answers.
+func newUncheckedCUPSCore(address string, minConnections, maxConnections uint, connectTimeout time.Duration, timeouts RequestTimeouts, ppdMaxBytes int64) (*cupsCore, error) {
 	host := C.cupsServer()
 	port := C.ippPort()
+	if address != "" {
+		h, p, err := splitServerAddress(address)
+		if err != nil {
+			return nil, err
+		}
+		// The host is used for the life of the connector, so isn't freed.
+		host = C.CString(h)
+		if p > 0 {
+			port = C.int(p)
+		}
+	}
 	encryption := C.cupsEncryption()
 	timeout := C.int(connectTimeout / time.Millisecond)
 
@@ -72,86 +174,385 @@ func newCUPSCore(maxConnections uint, connectTimeout time.Duration) (*cupsCore,
 		e = "encrypting REQUIRED"
 	}
 
+	// Without a connection, cupsGetPPD3 asks the default server, so only
+	// that one may be read from without connecting.
 	var hostIsLocal bool
-	if h := C.GoString(host); strings.HasPrefix(h, "/") || h == "localhost" {
+	if h := C.GoString(host); address == "" && (strings.HasPrefix(h, "/") || h == "localhost") {
 		hostIsLocal = true
 	}
 
 	cs := lib.NewSemaphore(maxConnections)
+	ps := lib.NewPoolSizer(cs, minConnections, maxConnections, connectionPoolShrinkAfter)
 	cp := make(chan *C.http_t)
 
-	cc := &cupsCore{host, port, encryption, timeout, cs, cp, hostIsLocal}
+	cc := &cupsCore{
+		host:                host,
+		port:                port,
+		encryption:          encryption,
+		connectTimeout:      timeout,
+		timeouts:            timeouts,
+		ppdMaxBytes:         ppdMaxBytes,
+		connectionSemaphore: cs,
+		connectionPoolSizer: ps,
+		connectionPool:      cp,
+		jobConnections:      make(map[uint32]*jobConnection),
+		hostIsLocal:         hostIsLocal,
+		attrInterner:        lib.NewStringInterner(attrInternMaxEntries),
+	}
 
 	log.Infof("Connecting to CUPS server at %s:%d %s", C.GoString(host), int(port), e)
 
-	// This connection isn't used, just checks that a connection is possible
-	// before returning from the constructor.
-	http, err := cc.connect()
+	lib.Go(lib.SubsystemCUPS, cc.resizeConnectionPool)
+
+	return cc, nil
+}
+
+// checkConnection checks that a connection to the server is possible.
+func (cc *cupsCore) checkConnection() error {
+	// This connection isn't used.
+	http, err := cc.connect(0)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	cc.disconnect(http)
 
 	log.Info("Connected to CUPS server successfully")
-
-	return cc, nil
+	return nil
 }
 
-// printFile prints by calling C.cupsPrintFile2().
-// Returns the CUPS job ID, which is 0 (and meaningless) when err
-// is not nil.
-func (cc *cupsCore) printFile(user, printername, filename, title *C.char, numOptions C.int, options *C.cups_option_t) (C.int, error) {
-	http, err := cc.connect()
+// printFile prints a file as a new job by calling Create-Job, then
+// Send-Document. When either fails ambiguously, so that CUPS may have done
+// it anyway, printFile looks for the job by its job-uuid, which is new to
+// each call, so that a retry finishes that job rather than print another.
+// Returns the CUPS job ID, which is 0 (and meaningless) when err is not nil.
+func (cc *cupsCore) printFile(user, printername, filename, title string, options map[string]string) (uint32, error) {
+	jobUUID, err := newJobUUID()
 	if err != nil {
 		return 0, err
 	}
-	defer cc.disconnect(http)
+	start := time.Now()
 
-	C.cupsSetUser(user)
-	jobID := C.cupsPrintFile2(http, printername, filename, title, numOptions, options)
-	if jobID == 0 {
-		return 0, fmt.Errorf("Failed to call cupsPrintFile2() for file %s: %d %s",
-			C.GoString(filename), int(C.cupsLastError()), C.GoString(C.cupsLastErrorString()))
+	for attempt := 1; ; attempt++ {
+		var jobID uint32
+		var documents int
+		if attempt > 1 {
+			jobID, documents, err = cc.findJob(user, printername, title, jobUUID, start)
+			if err != nil {
+				return 0, fmt.Errorf("Failed to find the job of a failed submission: %s", err)
+			}
+			if jobID != 0 && documents > 0 {
+				log.InfoPrinterf(printername, "CUPS job %d was submitted, despite the error", jobID)
+				return jobID, nil
+			}
+		}
+
+		var ambiguous bool
+		if jobID == 0 {
+			jobID, ambiguous, err = cc.createJob(user, printername, title, jobUUID, options)
+		}
+		if err == nil {
+			if ambiguous, err = cc.sendDocument(user, jobID, filename, options[attrDocumentFormat]); err == nil {
+				return jobID, nil
+			}
+			if !ambiguous {
+				// Don't leave the job waiting for its document.
+				cc.cancelJob(printername, jobID)
+			}
+		}
+
+		if !ambiguous || attempt == printFileAttempts {
+			return 0, err
+		}
+		log.WarningPrinterf(printername, "Retrying job submission: %s", err)
+	}
+}
+
+// createJob creates a job without documents by calling C.cupsDoRequest
+// (IPP_OP_CREATE_JOB). Returns whether a failure is ambiguous.
+func (cc *cupsCore) createJob(user, printername, title, jobUUID string, options map[string]string) (uint32, bool, error) {
+	uri, err := cc.createURI(fmt.Sprintf(printerURIFormat, printername))
+	if err != nil {
+		return 0, false, err
+	}
+	defer C.free(unsafe.Pointer(uri))
+	u := C.CString(user)
+	defer C.free(unsafe.Pointer(u))
+	t := C.CString(title)
+	defer C.free(unsafe.Pointer(t))
+	ju := C.CString(jobUUID)
+	defer C.free(unsafe.Pointer(ju))
+
+	numOptions := C.int(0)
+	var o *C.cups_option_t = nil
+	for key, value := range options {
+		k, v := C.CString(key), C.CString(value)
+		numOptions = C.cupsAddOption(k, v, numOptions, &o)
+		C.free(unsafe.Pointer(k))
+		C.free(unsafe.Pointer(v))
+	}
+	defer C.cupsFreeOptions(numOptions, o)
+
+	// ippNewRequest() returns ipp_t pointer which does not need explicit free.
+	request := C.ippNewRequest(C.IPP_OP_CREATE_JOB)
+	C.ippAddString(request, C.IPP_TAG_OPERATION, C.IPP_TAG_URI, C.PRINTER_URI_ATTRIBUTE, nil, uri)
+	C.ippAddString(request, C.IPP_TAG_OPERATION, C.IPP_TAG_NAME, C.REQUESTING_USER_NAME, nil, u)
+	C.ippAddString(request, C.IPP_TAG_OPERATION, C.IPP_TAG_NAME, C.JOB_NAME, nil, t)
+	C.cupsEncodeOptions2(request, numOptions, o, C.IPP_TAG_JOB)
+	C.ippAddString(request, C.IPP_TAG_JOB, C.IPP_TAG_URI, C.JOB_UUID, nil, ju)
+
+	response, ambiguous, err := cc.doPrintRequest(0, request, nil)
+	if err != nil {
+		return 0, ambiguous, prefixError("Failed to create a job: ", err)
+	}
+	defer C.ippDelete(response)
+
+	id := C.ippFindAttribute(response, C.JOB_ID, C.IPP_TAG_INTEGER)
+	if id == nil {
+		return 0, false, errors.New("Failed to create a job: CUPS returned no job-id")
+	}
+	return uint32(C.getAttributeIntegerValue(id, 0)), false, nil
+}
+
+// sendDocument sends a file as the only document of a job by calling
+// C.cupsDoFileRequest (IPP_OP_SEND_DOCUMENT). CUPS detects the file's format
+// when format is empty. Returns whether a failure is ambiguous.
+func (cc *cupsCore) sendDocument(user string, jobID uint32, filename, format string) (bool, error) {
+	uri, err := cc.createJobURI(C.int(jobID))
+	if err != nil {
+		return false, err
+	}
+	defer C.free(unsafe.Pointer(uri))
+	if format == "" {
+		format = ippDefaultDocumentFormat
+	}
+	u := C.CString(user)
+	defer C.free(unsafe.Pointer(u))
+	f := C.CString(format)
+	defer C.free(unsafe.Pointer(f))
+	fn := C.CString(filename)
+	defer C.free(unsafe.Pointer(fn))
+
+	// ippNewRequest() returns ipp_t pointer which does not need explicit free.
+	request := C.ippNewRequest(C.IPP_OP_SEND_DOCUMENT)
+	C.ippAddString(request, C.IPP_TAG_OPERATION, C.IPP_TAG_URI, C.JOB_URI_ATTRIBUTE, nil, uri)
+	C.ippAddString(request, C.IPP_TAG_OPERATION, C.IPP_TAG_NAME, C.REQUESTING_USER_NAME, nil, u)
+	C.ippAddString(request, C.IPP_TAG_OPERATION, C.IPP_TAG_MIMETYPE, C.DOCUMENT_FORMAT, nil, f)
+	C.ippAddBoolean(request, C.IPP_TAG_OPERATION, C.LAST_DOCUMENT, 1)
+
+	// Keep the connection for the first poll of the job's state.
+	response, ambiguous, err := cc.doPrintRequest(jobID, request, fn)
+	if err != nil {
+		return ambiguous, prefixError(fmt.Sprintf("Failed to send file %s to job %d: ", filename, jobID), err)
+	}
+	C.ippDelete(response)
+	return false, nil
+}
+
+// findJob finds a job of user on a printer, created since the first
+// attempt to submit it, by calling C.doRequest (IPP_OP_GET_JOBS). Returns
+// the job's ID, zero when there's no such job, and its quantity of
+// documents.
+func (cc *cupsCore) findJob(user, printername, title, jobUUID string, since time.Time) (uint32, int, error) {
+	uri, err := cc.createURI(fmt.Sprintf(printerURIFormat, printername))
+	if err != nil {
+		return 0, 0, err
+	}
+	defer C.free(unsafe.Pointer(uri))
+	u := C.CString(user)
+	defer C.free(unsafe.Pointer(u))
+
+	attributes := []string{attrJobID, attrJobUUID, attrJobName, attrNumberOfDocuments, attrTimeAtCreation}
+	a := C.newArrayOfStrings(C.int(len(attributes)))
+	defer C.freeStringArrayAndStrings(a, C.int(len(attributes)))
+	for i, attribute := range attributes {
+		C.setStringArrayValue(a, C.int(i), C.CString(attribute))
+	}
+
+	// ippNewRequest() returns ipp_t pointer which does not need explicit free.
+	request := C.ippNewRequest(C.IPP_OP_GET_JOBS)
+	C.ippAddString(request, C.IPP_TAG_OPERATION, C.IPP_TAG_URI, C.PRINTER_URI_ATTRIBUTE, nil, uri)
+	C.ippAddString(request, C.IPP_TAG_OPERATION, C.IPP_TAG_NAME, C.REQUESTING_USER_NAME, nil, u)
+	C.ippAddBoolean(request, C.IPP_TAG_OPERATION, C.MY_JOBS, 1)
+	C.ippAddString(request, C.IPP_TAG_OPERATION, C.IPP_TAG_KEYWORD, C.WHICH_JOBS, nil, C.ALL)
+	C.ippAddStrings(request, C.IPP_TAG_OPERATION, C.IPP_TAG_KEYWORD, C.REQUESTED_ATTRIBUTES,
+		C.int(len(attributes)), nil, a)
+
+	response, err := cc.doRequest(request,
+		[]C.ipp_status_t{C.IPP_STATUS_OK, C.IPP_STATUS_ERROR_NOT_FOUND}, cc.timeouts.GetJobAttributes)
+	if err != nil {
+		return 0, 0, fmt.Errorf("Failed to call cupsDoRequest() [IPP_OP_GET_JOBS]: %s", err)
+	}
+	// cupsDoRequest() returns ipp_t pointer which needs explicit free.
+	defer C.ippDelete(response)
+
+	var jobs []map[string][]string
+	jobAttributes := make([]*C.ipp_attribute_t, 0, len(attributes))
+	for a := response.attrs; a != nil; a = a.next {
+		if a.group_tag != C.IPP_TAG_JOB {
+			continue
+		}
+
+		jobAttributes = jobAttributes[:0]
+		for ; a != nil && a.group_tag == C.IPP_TAG_JOB; a = a.next {
+			jobAttributes = append(jobAttributes, a)
+		}
+		jobs = append(jobs, attributesToMap(jobAttributes, cc.attrInterner))
+
+		if a == nil {
+			break
+		}
+	}
+
+	jobID, documents := matchSubmittedJob(jobs, title, jobUUID, since)
+	return jobID, documents, nil
+}
+
+// matchSubmittedJob finds the job, among the jobs of a printer, that an
+// earlier attempt to submit a job created: the job with its job-uuid, or,
+// when CUPS assigned the job-uuid itself, the newest job with its title,
+// created since the first attempt. Returns the job's ID, zero when there's
+// no such job, and its quantity of documents.
+func matchSubmittedJob(jobs []map[string][]string, title, jobUUID string, since time.Time) (uint32, int) {
+	var jobID uint32
+	var documents int
+	for _, job := range jobs {
+		id, err := strconv.ParseUint(firstValue(job[attrJobID]), 10, 32)
+		if err != nil {
+			continue
+		}
+		n, _ := strconv.Atoi(firstValue(job[attrNumberOfDocuments]))
+		if firstValue(job[attrJobUUID]) == jobUUID {
+			return uint32(id), n
+		}
+
+		created, err := strconv.ParseInt(firstValue(job[attrTimeAtCreation]), 10, 64)
+		if err != nil || created < since.Unix() || firstValue(job[attrJobName]) != title {
+			continue
+		}
+		if uint32(id) > jobID {
+			jobID, documents = uint32(id), n
+		}
+	}
+	return jobID, documents
+}
+
+// newJobUUID makes a random job-uuid.
+func newJobUUID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", fmt.Errorf("Failed to make a job-uuid: %s", err)
+	}
+	// Version 4, variant RFC 4122.
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("urn:uuid:%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
+
+// cancelJob cancels a job by calling C.cupsCancelJob2().
+func (cc *cupsCore) cancelJob(printername string, jobID uint32) error {
+	pn := C.CString(printername)
+	defer C.free(unsafe.Pointer(pn))
+
+	http, err := cc.connectForJob(jobID, cc.timeouts.PrintFile)
+	if err != nil {
+		return err
 	}
+	defer cc.disconnect(http)
 
-	return jobID, nil
+	if C.cupsCancelJob2(http, pn, C.int(jobID), 0) != C.IPP_STATUS_OK {
+		return fmt.Errorf("Failed to call cupsCancelJob2() for job %d: %d %s",
+			jobID, int(C.cupsLastError()), C.GoString(C.cupsLastErrorString()))
+	}
+	return nil
 }
 
 // getPrinters gets the current list and state of printers by calling
-// C.doRequest (IPP_OP_CUPS_GET_PRINTERS).
+// C.doRequest (IPP_OP_CUPS_GET_PRINTERS). Each printer is returned as
+// a map of attribute names to values.
 //
-// The caller is responsible to C.ippDelete the returned *C.ipp_t response.
-func (cc *cupsCore) getPrinters(attributes **C.char, attrSize C.int) (*C.ipp_t, error) {
+// When limit is greater than zero, at most limit printers are returned.
+// When firstPrinterName is not empty, the list starts at that printer,
+// inclusive.
+func (cc *cupsCore) getPrinters(attributes []string, limit uint, firstPrinterName string) ([]map[string][]string, error) {
+	a := C.newArrayOfStrings(C.int(len(attributes)))
+	defer C.freeStringArrayAndStrings(a, C.int(len(attributes)))
+	for i, attribute := range attributes {
+		C.setStringArrayValue(a, C.int(i), C.CString(attribute))
+	}
+
 	// ippNewRequest() returns ipp_t pointer which does not need explicit free.
 	request := C.ippNewRequest(C.IPP_OP_CUPS_GET_PRINTERS)
 	C.ippAddStrings(request, C.IPP_TAG_OPERATION, C.IPP_TAG_KEYWORD, C.REQUESTED_ATTRIBUTES,
-		attrSize, nil, attributes)
+		C.int(len(attributes)), nil, a)
+	if limit > 0 {
+		C.ippAddInteger(request, C.IPP_TAG_OPERATION, C.IPP_TAG_INTEGER, C.LIMIT, C.int(limit))
+	}
+	if firstPrinterName != "" {
+		fpn := C.CString(firstPrinterName)
+		defer C.free(unsafe.Pointer(fpn))
+		C.ippAddString(request, C.IPP_TAG_OPERATION, C.IPP_TAG_NAME, C.FIRST_PRINTER_NAME, nil, fpn)
+	}
 
 	response, err := cc.doRequest(request,
-		[]C.ipp_status_t{C.IPP_STATUS_OK, C.IPP_STATUS_ERROR_NOT_FOUND})
+		[]C.ipp_status_t{C.IPP_STATUS_OK, C.IPP_STATUS_ERROR_NOT_FOUND}, cc.timeouts.GetPrinters)
 	if err != nil {
 		err = fmt.Errorf("Failed to call cupsDoRequest() [IPP_OP_CUPS_GET_PRINTERS]: %s", err)
 		return nil, err
 	}
 
-	return response, nil
+	// cupsDoRequest() returns ipp_t pointer which needs explicit free.
+	defer C.ippDelete(response)
+
+	if C.getIPPRequestStatusCode(response) == C.IPP_STATUS_ERROR_NOT_FOUND {
+		// Normal error when there are no printers.
+		return make([]map[string][]string, 0), nil
+	}
+
+	printers := make([]map[string][]string, 0, 1)
+
+	// Reused for every printer in the response.
+	printerAttributes := make([]*C.ipp_attribute_t, 0, len(attributes))
+
+	for a := response.attrs; a != nil; a = a.next {
+		if a.group_tag != C.IPP_TAG_PRINTER {
+			continue
+		}
+
+		printerAttributes = printerAttributes[:0]
+		for ; a != nil && a.group_tag == C.IPP_TAG_PRINTER; a = a.next {
+			printerAttributes = append(printerAttributes, a)
+		}
+		printers = append(printers, attributesToMap(printerAttributes, cc.attrInterner))
+
+		if a == nil {
+			break
+		}
+	}
+
+	return printers, nil
 }
 
-// getPPD gets the filename of the PPD for a printer by calling
+// getPPD gets the contents of the PPD for a printer by calling
 // C.cupsGetPPD3. If the PPD hasn't changed since the time indicated
-// by modtime, then the returned filename is a nil pointer.
+// by modtime, then the returned contents are empty.
 //
 // Note that modtime is a pointer whose value is changed by this
 // function.
-//
-// The caller is responsible to C.free the returned *C.char filename
-// if the returned filename is not nil.
-func (cc *cupsCore) getPPD(printername *C.char, modtime *C.time_t) (*C.char, error) {
+func (cc *cupsCore) getPPD(printername string, modtime *time.Time) (string, error) {
+	pn := C.CString(printername)
+	defer C.free(unsafe.Pointer(pn))
+
+	var mt C.time_t
+	if !modtime.IsZero() {
+		mt = C.time_t(modtime.Unix())
+	}
+
 	bufsize := C.size_t(filePathMaxLength)
 	buffer := (*C.char)(C.malloc(bufsize))
 	if buffer == nil {
-		return nil, errors.New("Failed to malloc; out of memory?")
+		return "", errors.New("Failed to malloc; out of memory?")
 	}
+	defer C.free(unsafe.Pointer(buffer))
 	C.memset(unsafe.Pointer(buffer), 0, bufsize)
 
 	var http *C.http_t
@@ -160,9 +561,9 @@ func (cc *cupsCore) getPPD(printername *C.char, modtime *C.time_t) (*C.char, err
 		// is on the local filesystem.
 		// Still need OS thread lock; see else.
 		var err error
-		http, err = cc.connect()
+		http, err = cc.connect(cc.timeouts.GetPPD)
 		if err != nil {
-			return nil, err
+			return "", err
 		}
 		defer cc.disconnect(http)
 
@@ -173,87 +574,180 @@ func (cc *cupsCore) getPPD(printername *C.char, modtime *C.time_t) (*C.char, err
 		defer runtime.UnlockOSThread()
 	}
 
-	httpStatus := C.cupsGetPPD3(http, printername, modtime, buffer, bufsize)
+	httpStatus := C.cupsGetPPD3(http, pn, &mt, buffer, bufsize)
+	if mt != 0 {
+		*modtime = time.Unix(int64(mt), 0)
+	}
+
+	// CUPS may have created a temporary file even when it didn't fill it.
+	filename := C.GoString(buffer)
+	if len(filename) > 0 {
+		defer os.Remove(filename)
+	}
 
 	switch httpStatus {
 	case C.HTTP_STATUS_NOT_MODIFIED:
 		// Cache hit.
-		if len(C.GoString(buffer)) > 0 {
-			os.Remove(C.GoString(buffer))
-		}
-		C.free(unsafe.Pointer(buffer))
-		return nil, nil
+		return "", nil
 
 	case C.HTTP_STATUS_OK:
 		// Cache miss.
-		return buffer, nil
+		if fi, err := os.Stat(filename); err != nil {
+			return "", err
+		} else if err = checkPPDSize(fi.Size(), cc.ppdMaxBytes); err != nil {
+			// Don't read it into memory.
+			return "", err
+		}
+		ppd, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return "", err
+		}
+		if len(ppd) == 0 {
+			return "", fmt.Errorf("CUPS returned an empty PPD for %s", printername)
+		}
+		return string(ppd), nil
 
 	default:
-		if len(C.GoString(buffer)) > 0 {
-			os.Remove(C.GoString(buffer))
-		}
-		C.free(unsafe.Pointer(buffer))
 		cupsLastError := C.cupsLastError()
 		if cupsLastError != C.IPP_STATUS_OK {
-			return nil, fmt.Errorf("Failed to call cupsGetPPD3(): %d %s",
+			return "", fmt.Errorf("Failed to call cupsGetPPD3(): %d %s",
 				int(cupsLastError), C.GoString(C.cupsLastErrorString()))
 		}
 
-		return nil, fmt.Errorf("Failed to call cupsGetPPD3(); HTTP status: %d", int(httpStatus))
+		return "", fmt.Errorf("Failed to call cupsGetPPD3(); HTTP status: %d", int(httpStatus))
 	}
 }
 
 // getJobAttributes gets the requested attributes for a job by calling
-// C.doRequest (IPP_OP_GET_JOB_ATTRIBUTES).
-//
-// The caller is responsible to C.ippDelete the returned *C.ipp_t response.
-func (cc *cupsCore) getJobAttributes(jobID C.int, attributes **C.char) (*C.ipp_t, error) {
-	uri, err := createJobURI(jobID)
+// C.doRequest (IPP_OP_GET_JOB_ATTRIBUTES). Job IDs are unique to the server,
+// so printername is unused.
+func (cc *cupsCore) getJobAttributes(printername string, jobID uint32, attributes []string) (map[string][]string, error) {
+	uri, err := cc.createJobURI(C.int(jobID))
 	if err != nil {
 		return nil, err
 	}
 	defer C.free(unsafe.Pointer(uri))
 
+	a := C.newArrayOfStrings(C.int(len(attributes)))
+	defer C.freeStringArrayAndStrings(a, C.int(len(attributes)))
+	for i, attribute := range attributes {
+		C.setStringArrayValue(a, C.int(i), C.CString(attribute))
+	}
+
 	// ippNewRequest() returns ipp_t pointer does not need explicit free.
 	request := C.ippNewRequest(C.IPP_OP_GET_JOB_ATTRIBUTES)
 
 	C.ippAddString(request, C.IPP_TAG_OPERATION, C.IPP_TAG_URI, C.JOB_URI_ATTRIBUTE, nil, uri)
 	C.ippAddStrings(request, C.IPP_TAG_OPERATION, C.IPP_TAG_KEYWORD, C.REQUESTED_ATTRIBUTES,
-		C.int(0), nil, attributes)
+		C.int(len(attributes)), nil, a)
 
-	response, err := cc.doRequest(request, []C.ipp_status_t{C.IPP_STATUS_OK})
+	response, err := cc.doJobRequest(jobID, request, []C.ipp_status_t{C.IPP_STATUS_OK}, cc.timeouts.GetJobAttributes)
 	if err != nil {
 		err = fmt.Errorf("Failed to call cupsDoRequest() [IPP_OP_GET_JOB_ATTRIBUTES]: %s", err)
 		return nil, err
 	}
 
-	return response, nil
+	// cupsDoRequest() returned ipp_t pointer needs explicit free.
+	defer C.ippDelete(response)
+
+	jobAttributes := make([]*C.ipp_attribute_t, 0, len(attributes))
+	for a := response.attrs; a != nil; a = a.next {
+		if a.group_tag == C.IPP_TAG_JOB {
+			jobAttributes = append(jobAttributes, a)
+		}
+	}
+
+	m := attributesToMap(jobAttributes, cc.attrInterner)
+	if state := m[attrJobState]; len(state) > 0 {
+		// Job states 7 and up are completed. Nobody polls a completed job,
+		// so its connection is for anyone.
+		if s, err := strconv.Atoi(state[0]); err == nil && s >= 7 {
+			cc.releaseJobConnection(jobID)
+		}
+	}
+	return m, nil
+}
+
+// countPendingJobs counts the jobs, on every queue of the CUPS server, that
+// are not completed, by calling C.doRequest (IPP_OP_GET_JOBS).
+func (cc *cupsCore) countPendingJobs() (uint, error) {
+	uri, err := cc.createURI(serverURIResource)
+	if err != nil {
+		return 0, err
+	}
+	defer C.free(unsafe.Pointer(uri))
+
+	// ippNewRequest() returns ipp_t pointer does not need explicit free.
+	request := C.ippNewRequest(C.IPP_OP_GET_JOBS)
+
+	C.ippAddString(request, C.IPP_TAG_OPERATION, C.IPP_TAG_URI, C.PRINTER_URI_ATTRIBUTE, nil, uri)
+	C.ippAddString(request, C.IPP_TAG_OPERATION, C.IPP_TAG_KEYWORD, C.WHICH_JOBS, nil, C.NOT_COMPLETED)
+	C.ippAddString(request, C.IPP_TAG_OPERATION, C.IPP_TAG_KEYWORD, C.REQUESTED_ATTRIBUTES, nil, C.JOB_ID)
+
+	response, err := cc.doRequest(request,
+		[]C.ipp_status_t{C.IPP_STATUS_OK, C.IPP_STATUS_ERROR_NOT_FOUND}, cc.timeouts.GetPrinters)
+	if err != nil {
+		err = fmt.Errorf("Failed to call cupsDoRequest() [IPP_OP_GET_JOBS]: %s", err)
+		return 0, err
+	}
+
+	// cupsDoRequest() returned ipp_t pointer needs explicit free.
+	defer C.ippDelete(response)
+
+	// Each job is a group of attributes, which are separated by attributes
+	// without a name.
+	var jobs uint
+	inJob := false
+	for a := response.attrs; a != nil; a = a.next {
+		if a.group_tag == C.IPP_TAG_JOB && a.name != nil {
+			if !inJob {
+				jobs++
+				inJob = true
+			}
+		} else {
+			inJob = false
+		}
+	}
+
+	return jobs, nil
 }
 
 // createJobURI creates a uri string for the job-uri attribute, used to get the
 // state of a CUPS job.
-func createJobURI(jobID C.int) (*C.char, error) {
+func (cc *cupsCore) createJobURI(jobID C.int) (*C.char, error) {
+	return cc.createURI(fmt.Sprintf(jobURIFormat, uint32(jobID)))
+}
+
+// createURI creates a uri string for a resource of the CUPS server.
+func (cc *cupsCore) createURI(r string) (*C.char, error) {
 	length := C.size_t(urlMaxLength)
 	uri := (*C.char)(C.malloc(length))
 	if uri == nil {
 		return nil, errors.New("Failed to malloc; out of memory?")
 	}
 
-	resource := C.CString(fmt.Sprintf(jobURIFormat, uint32(jobID)))
+	resource := C.CString(r)
 	defer C.free(unsafe.Pointer(resource))
 	C.httpAssembleURI(C.HTTP_URI_CODING_ALL,
-		uri, C.int(length), C.IPP, nil, C.cupsServer(), C.ippPort(), resource)
+		uri, C.int(length), C.IPP, nil, cc.host, cc.port, resource)
 
 	return uri, nil
 }
 
-// doRequest calls cupsDoRequest().
-func (cc *cupsCore) doRequest(request *C.ipp_t, acceptableStatusCodes []C.ipp_status_t) (*C.ipp_t, error) {
-	http, err := cc.connect()
+// doRequest calls cupsDoRequest(), which fails when the CUPS server is
+// silent for longer than timeout.
+func (cc *cupsCore) doRequest(request *C.ipp_t, acceptableStatusCodes []C.ipp_status_t, timeout time.Duration) (*C.ipp_t, error) {
+	return cc.doJobRequest(0, request, acceptableStatusCodes, timeout)
+}
+
+// doJobRequest calls cupsDoRequest(), like doRequest, on the connection kept
+// for a job, which it keeps for the job's next request.
+func (cc *cupsCore) doJobRequest(jobID uint32, request *C.ipp_t, acceptableStatusCodes []C.ipp_status_t, timeout time.Duration) (*C.ipp_t, error) {
+	http, err := cc.connectForJob(jobID, timeout)
 	if err != nil {
 		return nil, err
 	}
-	defer cc.disconnect(http)
+	defer cc.disconnectForJob(http, jobID)
 
 	if C.ippValidateAttributes(request) != 1 {
 		return nil, fmt.Errorf("Bad IPP request: %s", C.GoString(C.cupsLastErrorString()))
@@ -273,28 +767,68 @@ func (cc *cupsCore) doRequest(request *C.ipp_t, acceptableStatusCodes []C.ipp_st
 	return nil, fmt.Errorf("IPP status code %d", int(statusCode))
 }
 
+// doPrintRequest calls cupsDoFileRequest(), with the file of filename, or no
+// file when nil, on the connection kept for a job, like doJobRequest.
+// Returns whether a failure is ambiguous: whether CUPS may have done the
+// request anyway, because there was no response, or the response was a
+// server error.
+func (cc *cupsCore) doPrintRequest(jobID uint32, request *C.ipp_t, filename *C.char) (*C.ipp_t, bool, error) {
+	http, err := cc.connectForJob(jobID, cc.timeouts.PrintFile)
+	if err != nil {
+		C.ippDelete(request)
+		return nil, false, err
+	}
+	defer cc.disconnectForJob(http, jobID)
+
+	response := C.cupsDoFileRequest(http, request, C.POST_RESOURCE, filename)
+	if response == nil {
+		return nil, true, fmt.Errorf("cupsDoFileRequest failed: %d %s", int(C.cupsLastError()), C.GoString(C.cupsLastErrorString()))
+	}
+	if statusCode := C.getIPPRequestStatusCode(response); statusCode >= C.IPP_STATUS_ERROR_BAD_REQUEST {
+		C.ippDelete(response)
+		return nil, statusCode >= C.IPP_STATUS_ERROR_INTERNAL, &ippStatusError{uint16(statusCode),
+			fmt.Sprintf("IPP status code %d: %s", int(statusCode), C.GoString(C.cupsLastErrorString()))}
+	}
+	return response, false, nil
+}
+
 // connect calls C.httpConnect2 to create a new, open connection to
 // the CUPS server specified by environment variables, client.conf, etc.
 //
 // connect also acquires the connection semaphore and locks the OS
 // thread to allow the CUPS API to use thread-local storage cleanly.
 //
+// Requests on the connection fail when the CUPS server is silent for longer
+// than timeout; zero is no limit.
+//
 // The caller is responsible to close the connection when finished
 // using cupsCore.disconnect.
-func (cc *cupsCore) connect() (*C.http_t, error) {
+func (cc *cupsCore) connect(timeout time.Duration) (*C.http_t, error) {
+	return cc.connectForJob(0, timeout)
+}
+
+// connectForJob gets a connection, like connect, preferring the connection
+// kept for a job. A jobID of zero is no job.
+func (cc *cupsCore) connectForJob(jobID uint32, timeout time.Duration) (*C.http_t, error) {
 	cc.connectionSemaphore.Acquire()
 
 	// Lock the OS thread so that thread-local storage is available to
 	// cupsLastError() and cupsLastErrorString().
 	runtime.LockOSThread()
 
-	var http *C.http_t
+	http := cc.takeJobConnection(jobID)
+	if http == nil {
+		select {
+		case h := <-cc.connectionPool:
+			// Reuse another connection.
+			http = h
+		default:
+		}
+	}
 
-	select {
-	case h := <-cc.connectionPool:
-		// Reuse another connection.
-		http = h
-	default:
+	if http != nil {
+		atomic.AddUint64(&cc.connReused, 1)
+	} else {
 		// No connection available for reuse; create a new one.
 		http = C.httpConnect2(cc.host, cc.port, nil, C.AF_UNSPEC, cc.encryption, 1, cc.connectTimeout, nil)
 		if http == nil {
@@ -302,6 +836,19 @@ func (cc *cupsCore) connect() (*C.http_t, error) {
 			return nil, fmt.Errorf("Failed to connect to CUPS server %s:%d because %d %s",
 				C.GoString(cc.host), int(cc.port), int(C.cupsLastError()), C.GoString(C.cupsLastErrorString()))
 		}
+		atomic.AddUint64(&cc.connOpened, 1)
+		// HTTP/1.1 keeps connections alive by default, but say so, so that
+		// CUPS doesn't close the connection after each request.
+		C.httpSetKeepAlive(http, C.HTTP_KEEPALIVE_ON)
+	}
+
+	// A reused connection still has the timeout of its last request, so
+	// set it every time.
+	if timeout > 0 {
+		// Without a callback, CUPS gives up when the timeout passes.
+		C.httpSetTimeout(http, C.double(timeout.Seconds()), nil, nil)
+	} else {
+		C.setNoTimeout(http)
 	}
 
 	return http, nil
@@ -313,17 +860,111 @@ func (cc *cupsCore) connect() (*C.http_t, error) {
 // The http argument may be nil; the OS thread and semaphore are still
 // treated the same as described above.
 func (cc *cupsCore) disconnect(http *C.http_t) {
-	go func() {
+	cc.disconnectForJob(http, 0)
+}
+
+// disconnectForJob releases a connection, like disconnect, but keeps it
+// open for the next request about a job. A jobID of zero is no job.
+func (cc *cupsCore) disconnectForJob(http *C.http_t, jobID uint32) {
+	if http != nil {
+		if jobID != 0 {
+			cc.keepJobConnection(http, jobID)
+		} else {
+			cc.keepAlive(http)
+		}
+	}
+	runtime.UnlockOSThread()
+	cc.connectionSemaphore.Release()
+}
+
+// keepAlive hands an idle connection to the next caller of connect, or
+// closes it when nobody calls for it while cupsd would keep it open.
+func (cc *cupsCore) keepAlive(http *C.http_t) {
+	lib.Go(lib.SubsystemCUPS, func() {
 		select {
 		case cc.connectionPool <- http:
 			// Hand this connection to the next guy who needs it.
-		case <-time.After(time.Second):
-			// Don't wait very long; stale connections are no fun.
-			C.httpClose(http)
+		case <-time.After(connectionKeepAlive):
+			// Don't wait too long; stale connections are no fun.
+			closeIdleConnection(http)
 		}
-	}()
-	runtime.UnlockOSThread()
-	cc.connectionSemaphore.Release()
+	})
+}
+
+// closeIdleConnection closes a connection that was kept open, but wasn't
+// called for.
+func closeIdleConnection(http *C.http_t) {
+	C.httpClose(http)
+}
+
+// keepJobConnection keeps an idle connection for the next request about a
+// job, or closes it when there's no request while cupsd would keep it open.
+// The connection is still counted by connectionSemaphore. When the kept
+// connections and the connections in use would outgrow its size, the
+// connection goes to the next caller of connect instead.
+func (cc *cupsCore) keepJobConnection(http *C.http_t, jobID uint32) {
+	kept := &jobConnection{http}
+
+	cc.jobConnectionsMutex.Lock()
+	replaced := cc.jobConnections[jobID]
+	others := uint(len(cc.jobConnections))
+	if replaced != nil {
+		others--
+	}
+	if others+cc.connectionSemaphore.Count() > cc.connectionSemaphore.Size() {
+		cc.jobConnectionsMutex.Unlock()
+		cc.keepAlive(http)
+		return
+	}
+	cc.jobConnections[jobID] = kept
+	cc.jobConnectionsMutex.Unlock()
+
+	if replaced != nil {
+		cc.keepAlive(replaced.http)
+	}
+	time.AfterFunc(connectionKeepAlive, func() {
+		cc.jobConnectionsMutex.Lock()
+		defer cc.jobConnectionsMutex.Unlock()
+		if cc.jobConnections[jobID] == kept {
+			delete(cc.jobConnections, jobID)
+			closeIdleConnection(http)
+		}
+	})
+}
+
+// takeJobConnection takes the connection kept for a job, or returns nil.
+func (cc *cupsCore) takeJobConnection(jobID uint32) *C.http_t {
+	if jobID == 0 {
+		return nil
+	}
+
+	cc.jobConnectionsMutex.Lock()
+	defer cc.jobConnectionsMutex.Unlock()
+	kept, exists := cc.jobConnections[jobID]
+	if !exists {
+		return nil
+	}
+	delete(cc.jobConnections, jobID)
+	return kept.http
+}
+
+// releaseJobConnection hands the connection kept for a job to the next
+// caller of connect.
+func (cc *cupsCore) releaseJobConnection(jobID uint32) {
+	if http := cc.takeJobConnection(jobID); http != nil {
+		cc.keepAlive(http)
+	}
+}
+
+// resizeConnectionPool adapts the quantity of open connections to the load,
+// forever.
+func (cc *cupsCore) resizeConnectionPool() {
+	for range time.Tick(connectionPoolResizeInterval) {
+		if before, after := cc.connectionPoolSizer.Adjust(); before != after {
+			log.Infof("Resized the CUPS connection pool of %s:%d from %d to %d connections",
+				C.GoString(cc.host), int(cc.port), before, after)
+		}
+	}
 }
 
 func (cc *cupsCore) connQtyOpen() uint {
@@ -333,3 +974,116 @@ func (cc *cupsCore) connQtyOpen() uint {
 func (cc *cupsCore) connQtyMax() uint {
 	return cc.connectionSemaphore.Size()
 }
+
+func (cc *cupsCore) connReuse() (uint64, uint64) {
+	return atomic.LoadUint64(&cc.connOpened), atomic.LoadUint64(&cc.connReused)
+}
+
+func (cc *cupsCore) connPoolResizes() (uint, uint) {
+	return cc.connectionPoolSizer.Resizes()
+}
+
+// uname returns strings similar to the Unix uname command:
+// sysname, nodename, release, version, machine
+func uname() (string, string, string, string, string, error) {
+	var name C.struct_utsname
+	_, err := C.uname(&name)
+	if err != nil {
+		var errno syscall.Errno = err.(syscall.Errno)
+		return "", "", "", "", "", fmt.Errorf("Failed to call uname: %s", errno)
+	}
+
+	return C.GoString(&name.sysname[0]), C.GoString(&name.nodename[0]),
+		C.GoString(&name.release[0]), C.GoString(&name.version[0]),
+		C.GoString(&name.machine[0]), nil
+}
+
+// cupsClientVersion returns the version of the CUPS library that the
+// connector was compiled against.
+func cupsClientVersion() string {
+	return fmt.Sprintf("%d.%d.%d", C.CUPS_VERSION_MAJOR, C.CUPS_VERSION_MINOR, C.CUPS_VERSION_PATCH)
+}
+
+// cStringBytes returns the bytes of a C string without copying them.
+// The result is only valid while the C string is.
+func cStringBytes(s *C.char) []byte {
+	if s == nil {
+		return nil
+	}
+	n := int(C.strlen(s))
+	return (*[1 << 30]byte)(unsafe.Pointer(s))[:n:n]
+}
+
+// attributesToMap converts a slice of C.ipp_attribute_t to a
+// string:string "tag" map.
+//
+// Names and string values are interned, so printers that share
+// attribute values share the underlying strings.
+func attributesToMap(attributes []*C.ipp_attribute_t, interner *lib.StringInterner) map[string][]string {
+	m := make(map[string][]string, len(attributes))
+	var buf []byte
+
+	for _, a := range attributes {
+		key := interner.InternBytes(cStringBytes(a.name))
+		count := int(a.num_values)
+		values := make([]string, count)
+
+		switch a.value_tag {
+		case C.IPP_TAG_NOVALUE, C.IPP_TAG_NOTSETTABLE:
+			// No value means no value.
+
+		case C.IPP_TAG_INTEGER, C.IPP_TAG_ENUM:
+			for i := 0; i < count; i++ {
+				buf = strconv.AppendInt(buf[:0], int64(C.getAttributeIntegerValue(a, C.int(i))), 10)
+				values[i] = interner.InternBytes(buf)
+			}
+
+		case C.IPP_TAG_BOOLEAN:
+			for i := 0; i < count; i++ {
+				if int(C.getAttributeIntegerValue(a, C.int(i))) == 0 {
+					values[i] = "false"
+				} else {
+					values[i] = "true"
+				}
+			}
+
+		case C.IPP_TAG_TEXTLANG, C.IPP_TAG_NAMELANG, C.IPP_TAG_TEXT, C.IPP_TAG_NAME, C.IPP_TAG_KEYWORD, C.IPP_TAG_URI, C.IPP_TAG_URISCHEME, C.IPP_TAG_CHARSET, C.IPP_TAG_LANGUAGE, C.IPP_TAG_MIMETYPE:
+			for i := 0; i < count; i++ {
+				values[i] = interner.InternBytes(cStringBytes(C.getAttributeStringValue(a, C.int(i))))
+			}
+
+		case C.IPP_TAG_DATE:
+			for i := 0; i < count; i++ {
+				date := C.getAttributeDateValue(a, C.int(i))
+				t := convertIPPDateToTime(C.GoBytes(unsafe.Pointer(date), 11))
+				values[i] = strconv.FormatInt(t.Unix(), 10)
+			}
+
+		case C.IPP_TAG_RESOLUTION:
+			for i := 0; i < count; i++ {
+				xres, yres := C.int(0), C.int(0)
+				C.getAttributeValueResolution(a, C.int(i), &xres, &yres)
+				values[i] = fmt.Sprintf("%dx%dppi", int(xres), int(yres))
+			}
+
+		case C.IPP_TAG_RANGE:
+			for i := 0; i < count; i++ {
+				upper, lower := C.int(0), C.int(0)
+				C.getAttributeValueRange(a, C.int(i), &lower, &upper)
+				values[i] = fmt.Sprintf("%d~%d", int(lower), int(upper))
+			}
+
+		default:
+			if count > 0 {
+				values = []string{"unknown or unsupported type"}
+			}
+		}
+
+		if len(values) == 1 && (values[0] == "none" || len(values[0]) == 0) {
+			values = []string{}
+		}
+		m[key] = values
+	}
+
+	return m
+}
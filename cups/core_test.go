@@ -0,0 +1,124 @@
+// Copyright 2015 Google Inc. All rights reserved.
+
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+// +build linux darwin freebsd
+
+package cups
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/google/cloud-print-connector/lib"
+)
+
+func TestMatchSubmittedJob(t *testing.T) {
+	since := time.Unix(1000, 0)
+	job := func(id, uuid, name, created, documents string) map[string][]string {
+		return map[string][]string{
+			attrJobID:             {id},
+			attrJobUUID:           {uuid},
+			attrJobName:           {name},
+			attrTimeAtCreation:    {created},
+			attrNumberOfDocuments: {documents},
+		}
+	}
+	jobs := []map[string][]string{
+		job("5", "urn:uuid:other", "Report", "999", "1"),
+		job("6", "urn:uuid:ours", "Report", "1001", "0"),
+		job("7", "urn:uuid:cups", "Report", "1002", "1"),
+	}
+
+	if id, documents := matchSubmittedJob(jobs, "Report", "urn:uuid:ours", since); id != 6 || documents != 0 {
+		t.Logf("expected job 6 with no documents by job-uuid, got job %d with %d", id, documents)
+		t.Fail()
+	}
+	// CUPS replaced the job-uuid, so the newest job with the title since the first attempt.
+	if id, documents := matchSubmittedJob(jobs, "Report", "urn:uuid:missing", since); id != 7 || documents != 1 {
+		t.Logf("expected job 7 with 1 document by job-name, got job %d with %d", id, documents)
+		t.Fail()
+	}
+	if id, _ := matchSubmittedJob(jobs, "Other", "urn:uuid:missing", since); id != 0 {
+		t.Logf("expected no job, got job %d", id)
+		t.Fail()
+	}
+	if id, _ := matchSubmittedJob(jobs[:1], "Report", "urn:uuid:missing", since); id != 0 {
+		t.Logf("expected no job created before the first attempt, got job %d", id)
+		t.Fail()
+	}
+}
+
+func TestNewJobUUID(t *testing.T) {
+	a, err := newJobUUID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := newJobUUID()
+	if !regexp.MustCompile(`^urn:uuid:[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`).MatchString(a) {
+		t.Logf("expected a version 4 UUID URN, got %s", a)
+		t.Fail()
+	}
+	if a == b {
+		t.Logf("expected different job-uuids, got %s twice", a)
+		t.Fail()
+	}
+}
+
+func TestJobConnections(t *testing.T) {
+	cc := &cupsCore{
+		connectionSemaphore: lib.NewSemaphore(2),
+		jobConnections:      make(map[uint32]*jobConnection),
+	}
+	// keepJobConnection is called before the semaphore is released.
+	cc.connectionSemaphore.Acquire()
+	defer cc.connectionSemaphore.Release()
+
+	cc.keepJobConnection(nil, 5)
+	kept, exists := cc.jobConnections[5]
+	if !exists || len(cc.jobConnections) != 1 {
+		t.Fatalf("expected a connection kept for job 5, got %+v", cc.jobConnections)
+	}
+
+	// Another job, or no job, doesn't get it.
+	cc.takeJobConnection(6)
+	cc.takeJobConnection(0)
+	if cc.jobConnections[5] != kept {
+		t.Logf("expected the connection of job 5 to be kept, got %+v", cc.jobConnections)
+		t.Fail()
+	}
+
+	// A newer connection for the same job replaces it.
+	cc.keepJobConnection(nil, 5)
+	if newer := cc.jobConnections[5]; newer == kept || len(cc.jobConnections) != 1 {
+		t.Logf("expected one newer connection kept for job 5, got %+v", cc.jobConnections)
+		t.Fail()
+	}
+
+	cc.takeJobConnection(5)
+	if _, exists := cc.jobConnections[5]; exists {
+		t.Logf("expected the connection of job 5 to be taken, got %+v", cc.jobConnections)
+		t.Fail()
+	}
+
+	cc.keepJobConnection(nil, 7)
+	cc.releaseJobConnection(7)
+	if len(cc.jobConnections) != 0 {
+		t.Logf("expected the connection of job 7 to be released, got %+v", cc.jobConnections)
+		t.Fail()
+	}
+
+	// With one connection kept and another in use, a third would outgrow
+	// the semaphore.
+	cc.keepJobConnection(nil, 8)
+	cc.connectionSemaphore.Acquire()
+	defer cc.connectionSemaphore.Release()
+	cc.keepJobConnection(nil, 9)
+	if _, exists := cc.jobConnections[9]; exists || len(cc.jobConnections) != 1 {
+		t.Logf("expected only the connection of job 8 to be kept, got %+v", cc.jobConnections)
+		t.Fail()
+	}
+}
@@ -14,6 +14,19 @@ const char
 	*POST_RESOURCE              = "/",
 	*REQUESTED_ATTRIBUTES       = "requested-attributes",
 	*JOB_URI_ATTRIBUTE          = "job-uri",
+	*LIMIT                      = "limit",
+	*FIRST_PRINTER_NAME         = "first-printer-name",
+	*PRINTER_URI_ATTRIBUTE      = "printer-uri",
+	*WHICH_JOBS                 = "which-jobs",
+	*NOT_COMPLETED              = "not-completed",
+	*JOB_ID                     = "job-id",
+	*JOB_NAME                   = "job-name",
+	*JOB_UUID                   = "job-uuid",
+	*REQUESTING_USER_NAME       = "requesting-user-name",
+	*DOCUMENT_FORMAT            = "document-format",
+	*LAST_DOCUMENT              = "last-document",
+	*MY_JOBS                    = "my-jobs",
+	*ALL                        = "all",
 	*IPP                        = "ipp";
 
 // Allocates a new char**, initializes the values to NULL.
@@ -77,6 +90,17 @@ void getAttributeValueResolution(ipp_attribute_t *attr, int i, int *xres,
 	}
 }
 
+// keepWaiting is a timeout callback that never gives up on the server.
+static int keepWaiting(http_t *http, void *user_data) {
+	return 1;
+}
+
+// setNoTimeout lets requests on http wait for the server for as long as it
+// takes. Without a callback, CUPS gives up after its default timeout.
+void setNoTimeout(http_t *http) {
+	httpSetTimeout(http, 60.0, keepWaiting, NULL);
+}
+
 #ifndef _CUPS_API_1_7
 // Skip attribute validation with older clients.
 int ippValidateAttributes(ipp_t *ipp) {
@@ -91,3 +115,9 @@ http_t *httpConnect2(const char *host, int port, http_addrlist_t *addrlist, int
 	return httpConnectEncrypt(host, port, encryption);
 }
 #endif
+
+#ifndef _CUPS_API_2_0
+// Older clients keep HTTP/1.1 connections alive without being asked.
+void httpSetKeepAlive(http_t *http, http_keepalive_t keep_alive) {
+}
+#endif
@@ -8,23 +8,15 @@
 
 package cups
 
-/*
-#cgo LDFLAGS: -lcups
-#include "cups.h"
-*/
-import "C"
 import (
-	"bytes"
-	"encoding/binary"
+	"errors"
 	"fmt"
 	"os"
 	"runtime"
 	"strconv"
 	"strings"
 	"sync"
-	"syscall"
 	"time"
-	"unsafe"
 
 	"github.com/google/cloud-print-connector/cdd"
 	"github.com/google/cloud-print-connector/lib"
@@ -35,12 +27,19 @@ const (
 	// CUPS "URL" length are always less than 40. For example: /job/1234567
 	urlMaxLength = 100
 
+	// ppdUnavailableDescription is the vendor state of a printer whose PPD
+	// couldn't be fetched or translated, so that GCP still has its old
+	// capabilities.
+	ppdUnavailableDescription = "Capabilities unavailable: the printer's PPD couldn't be fetched"
+
 	// Attributes that CUPS uses to describe printers.
 	attrCUPSVersion                   = "cups-version"
 	attrCopiesDefault                 = "copies-default"
 	attrCopiesSupported               = "copies-supported"
 	attrDeviceURI                     = "device-uri"
 	attrDocumentFormatSupported       = "document-format-supported"
+	attrJobPriorityDefault            = "job-priority-default"
+	attrJobPrioritySupported          = "job-priority-supported"
 	attrMarkerLevels                  = "marker-levels"
 	attrMarkerNames                   = "marker-names"
 	attrMarkerTypes                   = "marker-types"
@@ -52,16 +51,22 @@ const (
 	attrPrintColorModeDefault         = "print-color-mode-default"
 	attrPrintColorModeSupported       = "print-color-mode-supported"
 	attrPrinterInfo                   = "printer-info"
+	attrPrinterIsAcceptingJobs        = "printer-is-accepting-jobs"
+	attrPrinterMakeAndModel           = "printer-make-and-model"
 	attrPrinterName                   = "printer-name"
+	attrPrinterResolutionDefault      = "printer-resolution-default"
+	attrPrinterResolutionSupported    = "printer-resolution-supported"
 	attrPrinterState                  = "printer-state"
 	attrPrinterStateReasons           = "printer-state-reasons"
 	attrPrinterUUID                   = "printer-uuid"
 
 	// Attributes that the connector uses to describe print jobs to CUPS.
 	attrCopies               = "copies"
+	attrDocumentFormat       = "document-format"
 	attrCollate              = "collate"
 	attrFalse                = "false"
 	attrFitToPage            = "fit-to-page"
+	attrJobPriority          = "job-priority"
 	attrMediaBottomMargin    = "media-bottom-margin"
 	attrMediaLeftMargin      = "media-left-margin"
 	attrMediaRightMargin     = "media-right-margin"
@@ -71,12 +76,24 @@ const (
 	attrOrientationRequested = "orientation-requested"
 	attrOutputOrder          = "outputorder"
 	attrPrintColorMode       = "print-color-mode"
+	attrPrinterResolution    = "printer-resolution"
 	attrReverse              = "reverse"
 	attrTrue                 = "true"
 
+	// The range of job-priority, and the priority of jobs that don't say.
+	jobPriorityMin     = 1
+	jobPriorityMax     = 100
+	jobPriorityDefault = 50
+
 	// Attributes that CUPS uses to describe job state.
-	attrJobMediaSheetsCompleted = "job-media-sheets-completed"
+	attrJobImpressionsCompleted = "job-impressions-completed"
 	attrJobState                = "job-state"
+
+	// Attributes that identify a job, to find the job of a failed submission.
+	attrJobName           = "job-name"
+	attrJobUUID           = "job-uuid"
+	attrNumberOfDocuments = "number-of-documents"
+	attrTimeAtCreation    = "time-at-creation"
 )
 
 var (
@@ -96,6 +113,7 @@ var (
 		attrPrintColorModeDefault,
 		attrPrintColorModeSupported,
 		attrPrinterInfo,
+		attrPrinterIsAcceptingJobs,
 		attrPrinterName,
 		attrPrinterState,
 		attrPrinterStateReasons,
@@ -104,7 +122,7 @@ var (
 
 	jobAttributes []string = []string{
 		attrJobState,
-		attrJobMediaSheetsCompleted,
+		attrJobImpressionsCompleted,
 	}
 
 	// cupsPDS represents capabilities that CUPS always provides.
@@ -121,14 +139,31 @@ var (
 				},
 			},
 		},
-		ReverseOrder: &cdd.ReverseOrder{Default: false},
-		Collate:      &cdd.Collate{Default: true},
 	}
 )
 
+// cupsClient is the subset of the CUPS API that the connector uses.
+// cupsCore implements it with the CUPS library; tests substitute a fake.
+type cupsClient interface {
+	getPrinters(attributes []string, limit uint, firstPrinterName string) ([]map[string][]string, error)
+	getPPD(printername string, modtime *time.Time) (string, error)
+	printFile(user, printername, filename, title string, options map[string]string) (uint32, error)
+	getJobAttributes(printername string, jobID uint32, attributes []string) (map[string][]string, error)
+	countPendingJobs() (uint, error)
+	cancelJob(printername string, jobID uint32) error
+	connQtyOpen() uint
+	connQtyMax() uint
+	connPoolResizes() (uint, uint)
+	connReuse() (uint64, uint64)
+}
+
+func init() {
+	lib.AddBuildFeature("cups")
+}
+
 // Interface between Go and the CUPS API.
 type CUPS struct {
-	cc                    *cupsCore
+	cc                    cupsClient
 	pc                    *ppdCache
 	infoToDisplayName     bool
 	prefixJobIDToJobTitle bool
@@ -139,20 +174,111 @@ type CUPS struct {
 	printerWhitelist      map[string]interface{}
 	ignoreRawPrinters     bool
 	ignoreClassPrinters   bool
+	printerPageSize       uint
+	// ppdWorkers limits the quantity of PPDs translated concurrently.
+	ppdWorkers   *lib.Semaphore
+	ppdDurations lib.DurationStats
+	// ppdTimeout is the deadline for fetching and translating one PPD;
+	// zero is no limit.
+	ppdTimeout time.Duration
+	// ppdFetching has the printers whose PPD is being fetched by a worker
+	// that outlived its deadline, so that a slow PPD doesn't start another.
+	ppdFetching      map[string]struct{}
+	ppdFetchingMutex sync.Mutex
+	// audit records the ticket and options of each job.
+	audit *lib.JobTicketAudit
+	// overrides holds options to merge into the next jobs of each printer.
+	overrides *optionsOverrides
+	// jobPriorityUsers may raise job-priority above the printer's default.
+	jobPriorityUsers *lib.UserList
+	// autoRotatePrinters get orientation-requested from the PDF of each job.
+	autoRotatePrinters map[string]interface{}
+	// fitToPageBrokenDrivers ignore fit-to-page, so their jobs are scaled here.
+	fitToPageBrokenDrivers []string
+	// normalizeMixedOrientation turns the pages of duplex jobs that mix
+	// portrait and landscape to one orientation, so that they bind on the
+	// requested edge.
+	normalizeMixedOrientation bool
+	// paperSubstitution prints Letter jobs on A4, and A4 jobs on Letter,
+	// on printers without the size that a job asks for.
+	paperSubstitution paperSubstitution
+	// formats are the document formats that the jobs of some printers are
+	// fetched and submitted in.
+	formats formatPreferences
+	// jobSheets adds cover sheets and separator sheets to jobs.
+	jobSheets jobSheets
+	// printerCache shares the printer list among callers other than the sync.
+	printerCache *printerCache
+	// colorPolicy limits who prints in color on which printers, and when.
+	colorPolicy colorPolicy
+	// quirks fix the attributes, capabilities and options of printers with
+	// misbehaving drivers.
+	quirks quirks
+	// direct prints to IPP Everywhere printers without CUPS; nil when none
+	// are configured.
+	direct *directClient
+	// devices talks IPP to the devices of CUPS queues, for requests that
+	// cupsd doesn't pass on.
+	devices *directClient
+	// raw prints to the JetDirect and LPD devices of raw queues without
+	// CUPS; nil when disabled.
+	raw *rawBackend
+	// missing notes printer attributes that the server doesn't report.
+	missing missingAttributes
+	// stateReasons are the messages that replace printer-state-reasons.
+	stateReasons stateReasonMessages
+	// deviceInfo tags printers with the firmware, serial number and page
+	// count of their devices; nil when disabled.
+	deviceInfo *deviceInfoCache
 }
 
 func NewCUPS(infoToDisplayName, prefixJobIDToJobTitle bool, displayNamePrefix string,
-	printerAttributes, vendorPPDOptions []string, maxConnections uint, connectTimeout time.Duration,
-	printerBlacklist, printerWhitelist []string, ignoreRawPrinters bool, ignoreClassPrinters bool) (*CUPS, error) {
-	if err := checkPrinterAttributes(printerAttributes); err != nil {
+	printerAttributes, vendorPPDOptions []string, ppdMaxBytes int64, minConnections, maxConnections uint, connectTimeout time.Duration,
+	servers []lib.CUPSServer, printerBlacklist, printerWhitelist []string, ignoreRawPrinters bool, ignoreClassPrinters bool,
+	printerPageSize uint, audit *lib.JobTicketAudit, optionsOverrideDir string,
+	jobPriorityUsers, autoRotatePrinters, fitToPageBrokenDrivers []string, normalizeMixedOrientation bool,
+	coverSheetPrinters, separatorSheetPrinters []string, jobSheetOptions bool,
+	paperSizeSubstitution bool, stockedPaperSizes map[string][]string,
+	fetchDocumentFormats, submitDocumentFormats map[string]string, quirksFile string,
+	colorPolicyFile, stateReasonMessagesFile string, requestTimeouts RequestTimeouts, directPrinters, directPrinterTLS map[string]string, directTOFUFile string, ippUSB, rawDirect bool,
+	rawPJLPrinters []string, deviceInfo bool, snmpCommunity string, throttle *lib.LoadThrottle, groups *lib.GroupResolver) (*CUPS, error) {
+	printerAttributes = addRequiredPrinterAttributes(printerAttributes)
+
+	q, err := newQuirks(quirksFile)
+	if err != nil {
+		return nil, err
+	}
+
+	fp, err := newFormatPreferences(fetchDocumentFormats, submitDocumentFormats)
+	if err != nil {
+		return nil, err
+	}
+
+	cp, err := newColorPolicy(colorPolicyFile, groups)
+	if err != nil {
+		return nil, err
+	}
+
+	srm, err := newStateReasonMessages(stateReasonMessagesFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var cc cupsClient
+	if len(servers) > 0 {
+		cc, err = newMultiServerClient(servers, minConnections, maxConnections, connectTimeout, requestTimeouts, ppdMaxBytes)
+	} else {
+		cc, err = newCUPSCore("", minConnections, maxConnections, connectTimeout, requestTimeouts, ppdMaxBytes)
+	}
+	if err != nil {
 		return nil, err
 	}
+	pc := newPPDCache(cc, vendorPPDOptions, ppdMaxBytes, throttle)
 
-	cc, err := newCUPSCore(maxConnections, connectTimeout)
+	direct, err := newDirectClient(directPrinters, directPrinterTLS, directTOFUFile, ippUSB, requestTimeouts)
 	if err != nil {
 		return nil, err
 	}
-	pc := newPPDCache(cc, vendorPPDOptions)
 
 	systemTags, err := getSystemTags()
 	if err != nil {
@@ -169,17 +295,64 @@ func NewCUPS(infoToDisplayName, prefixJobIDToJobTitle bool, displayNamePrefix st
 		pw[p] = struct{}{}
 	}
 
+	jpu, err := lib.NewUserList(jobPriorityUsers, groups)
+	if err != nil {
+		return nil, fmt.Errorf("Job priority users are not valid: %s", err)
+	}
+
+	arp := map[string]interface{}{}
+	for _, p := range autoRotatePrinters {
+		arp[p] = struct{}{}
+	}
+
+	var raw *rawBackend
+	if rawDirect {
+		raw = newRawBackend(rawTimeout, rawPJLPrinters)
+	}
+
+	var dic *deviceInfoCache
+	if deviceInfo {
+		dic = newDeviceInfoCache(snmpCommunity)
+	}
+
+	if printerPageSize == 1 {
+		// Each page after the first repeats the last printer of the
+		// previous page, so a page of one would never advance.
+		printerPageSize = 2
+	}
+
 	c := &CUPS{
-		cc:                  cc,
-		pc:                  pc,
-		infoToDisplayName:   infoToDisplayName,
-		displayNamePrefix:   displayNamePrefix,
-		printerAttributes:   printerAttributes,
-		systemTags:          systemTags,
-		printerBlacklist:    pb,
-		printerWhitelist:    pw,
-		ignoreRawPrinters:   ignoreRawPrinters,
-		ignoreClassPrinters: ignoreClassPrinters,
+		cc:                        cc,
+		pc:                        pc,
+		infoToDisplayName:         infoToDisplayName,
+		displayNamePrefix:         displayNamePrefix,
+		printerAttributes:         printerAttributes,
+		systemTags:                systemTags,
+		printerBlacklist:          pb,
+		printerWhitelist:          pw,
+		jobPriorityUsers:          jpu,
+		autoRotatePrinters:        arp,
+		fitToPageBrokenDrivers:    fitToPageBrokenDrivers,
+		normalizeMixedOrientation: normalizeMixedOrientation,
+		paperSubstitution:         newPaperSubstitution(paperSizeSubstitution, stockedPaperSizes),
+		formats:                   fp,
+		jobSheets:                 newJobSheets(coverSheetPrinters, separatorSheetPrinters, jobSheetOptions),
+		ignoreRawPrinters:         ignoreRawPrinters,
+		ignoreClassPrinters:       ignoreClassPrinters,
+		printerPageSize:           printerPageSize,
+		ppdWorkers:                lib.NewSemaphore(uint(runtime.GOMAXPROCS(0))),
+		ppdTimeout:                requestTimeouts.PPD,
+		ppdFetching:               make(map[string]struct{}),
+		audit:                     audit,
+		overrides:                 newOptionsOverrides(optionsOverrideDir),
+		quirks:                    q,
+		colorPolicy:               cp,
+		devices:                   &directClient{timeouts: requestTimeouts},
+		stateReasons:              srm,
+		printerCache:              newPrinterCache(printerCacheTTL),
+		direct:                    direct,
+		raw:                       raw,
+		deviceInfo:                dic,
 	}
 
 	return c, nil
@@ -194,33 +367,144 @@ func (c *CUPS) ConnQtyOpen() uint {
 	return c.cc.connQtyOpen()
 }
 
-// ConnQtyOpen gets the maximum quantity of open CUPS connections.
+// ConnQtyOpen gets the maximum quantity of open CUPS connections, which the
+// connection pool grows and shrinks with the load.
 func (c *CUPS) ConnQtyMax() uint {
 	return c.cc.connQtyMax()
 }
 
+// ConnReuse gets how many CUPS connections have been opened, and how many
+// times one has been reused, kept alive, instead.
+func (c *CUPS) ConnReuse() (uint64, uint64) {
+	return c.cc.connReuse()
+}
+
+// ConnPoolResizes gets how many times the CUPS connection pool has grown
+// and shrunk.
+func (c *CUPS) ConnPoolResizes() (uint, uint) {
+	return c.cc.connPoolResizes()
+}
+
+// ServerStats returns the CUPS server's version, its quantity of queues,
+// including those that the connector ignores, and the quantity of jobs
+// that are not completed on all of them. These tell problems with the CUPS
+// server from problems with the connector.
+func (c *CUPS) ServerStats() (string, uint, uint, error) {
+	queues, err := c.cc.getPrinters([]string{attrPrinterName, attrCUPSVersion}, 0, "")
+	if err != nil {
+		return "", 0, 0, err
+	}
+	version := "unknown"
+	for _, q := range queues {
+		if v := q[attrCUPSVersion]; len(v) > 0 && v[0] != "" {
+			version = v[0]
+			break
+		}
+	}
+
+	jobs, err := c.cc.countPendingJobs()
+	if err != nil {
+		return "", 0, 0, err
+	}
+
+	return version, uint(len(queues)), jobs, nil
+}
+
+// PPDStats returns the mean and maximum durations of fetching and
+// translating one PPD.
+func (c *CUPS) PPDStats() (time.Duration, time.Duration) {
+	_, _, mean, max := c.ppdDurations.Get()
+	return mean, max
+}
+
 // GetPrinters gets all CUPS printers found on the CUPS server.
+//
+// When printerPageSize is set, printers are requested in pages of that
+// size, and each page's PPDs are processed while the next page is fetched.
 func (c *CUPS) GetPrinters() ([]lib.Printer, error) {
-	pa := C.newArrayOfStrings(C.int(len(c.printerAttributes)))
-	defer C.freeStringArrayAndStrings(pa, C.int(len(c.printerAttributes)))
-	for i, a := range c.printerAttributes {
-		C.setStringArrayValue(pa, C.int(i), C.CString(a))
+	ch := make(chan []lib.Printer)
+	var pages int
+	var firstPrinterName string
+
+	for {
+		page, more, err := c.getPrintersPage(firstPrinterName)
+		if err != nil {
+			// Wait for pages already in flight.
+			for ; pages > 0; pages-- {
+				<-ch
+			}
+			return nil, err
+		}
+		if len(page) > 0 {
+			firstPrinterName = page[len(page)-1].Name
+		}
+
+		pages++
+		printers := page
+		lib.Go(lib.SubsystemCUPS, func() {
+			ch <- c.addPPDDescriptionToPrinters(c.filterPrinters(printers))
+		})
+
+		if !more {
+			break
+		}
+	}
+
+	printers := make([]lib.Printer, 0)
+	for ; pages > 0; pages-- {
+		printers = append(printers, <-ch...)
+	}
+	c.addDeviceInfoToPrinters(printers)
+	printers = c.addDirectPrinters(printers)
+	if c.raw != nil {
+		c.raw.update(printers)
 	}
+	printers = addStaticDescriptionToPrinters(printers)
+	c.jobSheets.addCapabilities(printers)
+	c.formats.applyToDescriptions(printers)
+	printers = c.addSystemTagsToPrinters(printers)
+
+	return printers, nil
+}
 
-	response, err := c.cc.getPrinters(pa, C.int(len(c.printerAttributes)))
+// GetCachedPrinters gets all CUPS printers, like GetPrinters, but shares
+// one recent response among callers. The printers must not be modified.
+func (c *CUPS) GetCachedPrinters() ([]lib.Printer, error) {
+	return c.printerCache.get(c.GetPrinters)
+}
+
+// RefreshCachedPrinters discards the printers held for GetCachedPrinters,
+// and gets them again.
+func (c *CUPS) RefreshCachedPrinters() ([]lib.Printer, error) {
+	c.printerCache.invalidate()
+	return c.GetCachedPrinters()
+}
+
+// getPrintersPage gets one page of printers, starting after firstPrinterName.
+// When firstPrinterName is empty, gets the first page. When paging is
+// disabled, the first page contains all printers.
+//
+// Returns true when there may be more printers after this page.
+func (c *CUPS) getPrintersPage(firstPrinterName string) ([]lib.Printer, bool, error) {
+	attributes, err := c.cc.getPrinters(c.printerAttributes, c.printerPageSize, firstPrinterName)
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
 
-	// cupsDoRequest() returns ipp_t pointer which needs explicit free.
-	defer C.ippDelete(response)
+	c.missing.check(attributes)
+	printers := c.attributesToPrinters(attributes)
+	more := c.printerPageSize > 0 && uint(len(printers)) >= c.printerPageSize
 
-	if C.getIPPRequestStatusCode(response) == C.IPP_STATUS_ERROR_NOT_FOUND {
-		// Normal error when there are no printers.
-		return make([]lib.Printer, 0), nil
+	if firstPrinterName != "" && len(printers) > 0 && printers[0].Name == firstPrinterName {
+		// first-printer-name is inclusive; this printer was on the last page.
+		printers = printers[1:]
 	}
 
-	printers := c.responseToPrinters(response)
+	return printers, more, nil
+}
+
+// filterPrinters removes printers that are excluded by configuration.
+func (c *CUPS) filterPrinters(printers []lib.Printer) []lib.Printer {
 	printers = lib.FilterBlacklistPrinters(printers, c.printerBlacklist)
 	printers = lib.FilterWhitelistPrinters(printers, c.printerWhitelist)
 
@@ -230,32 +514,25 @@ func (c *CUPS) GetPrinters() ([]lib.Printer, error) {
 	if c.ignoreClassPrinters {
 		printers = filterClassPrinters(printers)
 	}
-	printers = c.addPPDDescriptionToPrinters(printers)
-	printers = addStaticDescriptionToPrinters(printers)
-	printers = c.addSystemTagsToPrinters(printers)
-
-	return printers, nil
+	return printers
 }
 
-// responseToPrinters converts a C.ipp_t to a slice of lib.Printers.
-func (c *CUPS) responseToPrinters(response *C.ipp_t) []lib.Printer {
-	printers := make([]lib.Printer, 0, 1)
+// attributesToPrinters converts CUPS printer attributes to a slice of lib.Printers.
+func (c *CUPS) attributesToPrinters(attributes []map[string][]string) []lib.Printer {
+	printers := make([]lib.Printer, 0, len(attributes))
 
-	for a := response.attrs; a != nil; a = a.next {
-		if a.group_tag != C.IPP_TAG_PRINTER {
+	for _, mAttributes := range attributes {
+		c.quirks.applyAttributes(mAttributes)
+		pds, pss, name, defaultDisplayName, uuid, tags := translateAttrs(mAttributes)
+		if name == "" {
+			log.Warningf("Ignoring a printer without %s", attrPrinterName)
 			continue
 		}
-
-		attributes := make([]*C.ipp_attribute_t, 0, C.int(len(c.printerAttributes)))
-		for ; a != nil && a.group_tag == C.IPP_TAG_PRINTER; a = a.next {
-			attributes = append(attributes, a)
-		}
-		mAttributes := attributesToMap(attributes)
-		pds, pss, name, defaultDisplayName, uuid, tags := translateAttrs(mAttributes)
 		if !c.infoToDisplayName || defaultDisplayName == "" {
 			defaultDisplayName = name
 		}
 		defaultDisplayName = c.displayNamePrefix + defaultDisplayName
+		c.stateReasons.describe(pss.VendorState)
 		p := lib.Printer{
 			Name:               name,
 			DefaultDisplayName: defaultDisplayName,
@@ -266,14 +543,56 @@ func (c *CUPS) responseToPrinters(response *C.ipp_t) []lib.Printer {
 		}
 
 		printers = append(printers, p)
-		if a == nil {
-			break
-		}
 	}
 
 	return printers
 }
 
+// addDirectPrinters adds the direct printers, including IPP-over-USB
+// printers plugged in now, to printers, in place of CUPS queues of the same
+// names. A direct printer that doesn't answer is left out, like a CUPS queue
+// whose PPD can't be fetched.
+func (c *CUPS) addDirectPrinters(printers []lib.Printer) []lib.Printer {
+	if c.direct == nil {
+		return printers
+	}
+
+	if err := c.direct.refreshUSB(); err != nil {
+		log.Warning(err)
+	}
+
+	names := c.direct.names()
+	result := make([]lib.Printer, 0, len(printers)+len(names))
+	for _, p := range printers {
+		if !c.direct.isDirect(p.Name) {
+			result = append(result, p)
+		}
+	}
+
+	attributes := append(append([]string{}, c.printerAttributes...), directPrinterAttributes...)
+	if c.deviceInfo != nil {
+		attributes = append(attributes, deviceInfoAttributes...)
+	}
+	for _, name := range names {
+		attrs, err := c.direct.getPrinterAttributes(name, attributes)
+		if err != nil {
+			log.ErrorPrinter(name, err)
+			continue
+		}
+		p := c.attributesToPrinters([]map[string][]string{attrs})[0]
+		addIPPEverywhereDescription(&p, attrs)
+		if c.deviceInfo != nil {
+			for k, v := range deviceInfoFromAttributes(attrs) {
+				p.Tags[k] = v
+			}
+		}
+		c.quirks.applyCapabilities(&p)
+		result = append(result, p)
+	}
+
+	return result
+}
+
 // filterClassPrinters removes class printers from the slice.
 func filterClassPrinters(printers []lib.Printer) []lib.Printer {
 	result := make([]lib.Printer, 0, len(printers))
@@ -304,20 +623,33 @@ func (c *CUPS) addPPDDescriptionToPrinters(printers []lib.Printer) []lib.Printer
 
 	for i := range printers {
 		wg.Add(1)
-		go func(p *lib.Printer) {
-			if description, manufacturer, model, duplexMap, err := c.pc.getPPDCacheEntry(p.Name); err == nil {
+		p := &printers[i]
+		lib.Go(lib.SubsystemCUPS, func() {
+			if _, ok := rawDeviceURI(p); ok && c.raw != nil {
+				// Raw queues have no PPD; their IPP attributes describe them.
+				ch <- p
+			} else if description, manufacturer, model, duplexMap, marginsMap, err := c.getPPDCacheEntry(p.Name); err == nil {
+				copies := mergeCopies(p.Description.Copies, description.Copies)
+				dpi := mergeDPI(p.Description.DPI, description.DPI)
 				p.Description.Absorb(description)
+				p.Description.Copies = copies
+				p.Description.DPI = dpi
 				p.Manufacturer = manufacturer
 				p.Model = model
 				if duplexMap != nil {
 					p.DuplexMap = duplexMap
 				}
+				p.MarginsMap = marginsMap
+				c.quirks.applyCapabilities(p)
 				ch <- p
 			} else {
-				log.ErrorPrinter(p.Name, err)
+				// Sync the printer anyway, rather than drop it from GCP.
+				log.ErrorPrinterf(p.Name, "Syncing without the capabilities of its PPD: %s", err)
+				markCapsUnavailable(p)
+				ch <- p
 			}
 			wg.Done()
-		}(&printers[i])
+		})
 	}
 
 	wg.Wait()
@@ -331,6 +663,138 @@ func (c *CUPS) addPPDDescriptionToPrinters(printers []lib.Printer) []lib.Printer
 	return result
 }
 
+// mergeCopies combines the copies capability from IPP attributes with
+// the one from the PPD. The lower maximum wins, because either the CUPS
+// server or the driver may be the one that limits copies.
+func mergeCopies(ipp, ppd *cdd.Copies) *cdd.Copies {
+	if ipp == nil {
+		return ppd
+	}
+	if ppd == nil || ppd.Max >= ipp.Max {
+		return ipp
+	}
+
+	copies := cdd.Copies{Default: ipp.Default, Max: ppd.Max}
+	if copies.Default > copies.Max {
+		copies.Default = copies.Max
+	}
+	return &copies
+}
+
+// mergeDPI combines the resolutions from IPP attributes with those from the
+// PPD. The PPD's options come first and win over IPP options with the same
+// resolution, because the driver knows them by name. The PPD's default wins.
+func mergeDPI(ipp, ppd *cdd.DPI) *cdd.DPI {
+	if ipp == nil {
+		return ppd
+	}
+	if ppd == nil {
+		return ipp
+	}
+
+	dpi := cdd.DPI{Option: make([]cdd.DPIOption, 0, len(ppd.Option)+len(ipp.Option))}
+	var defaultDPI *cdd.DPIOption
+	for _, options := range [][]cdd.DPIOption{ppd.Option, ipp.Option} {
+		for i := range options {
+			if options[i].IsDefault && defaultDPI == nil {
+				defaultDPI = &options[i]
+			}
+			o := options[i]
+			o.IsDefault = false
+			dpi.Option = appendDPIOption(dpi.Option, o)
+		}
+	}
+	markDefaultDPI(dpi.Option, defaultDPI)
+
+	return &dpi
+}
+
+type ppdCacheResult struct {
+	description  *cdd.PrinterDescriptionSection
+	manufacturer string
+	model        string
+	duplexMap    lib.DuplexVendorMap
+	marginsMap   lib.MarginsVendorMap
+	err          error
+}
+
+// markCapsUnavailable marks a printer whose PPD couldn't be fetched, so
+// that the capabilities it's registered with are kept, and says why in its
+// state.
+func markCapsUnavailable(p *lib.Printer) {
+	p.CapsUnavailable = true
+	if p.State == nil {
+		p.State = &cdd.PrinterStateSection{State: cdd.CloudDeviceStateIdle}
+	}
+	if p.State.VendorState == nil {
+		p.State.VendorState = &cdd.VendorState{}
+	}
+	p.State.VendorState.Item = append(p.State.VendorState.Item, cdd.VendorStateItem{
+		State:                cdd.VendorStateWarning,
+		DescriptionLocalized: cdd.NewLocalizedString(ppdUnavailableDescription),
+	})
+}
+
+// getPPDCacheEntry calls ppdCache.getPPDCacheEntry on one of a limited
+// quantity of workers, so that a large sync doesn't starve the rest of the
+// connector of CPU. Gives up after ppdTimeout, not counting time spent
+// waiting for a worker.
+//
+// A worker that outlives the deadline gives up its place to the other
+// printers, and carries on, so that the PPD is in the cache for the next
+// sync; until it's done, the printer's PPD isn't fetched again.
+func (c *CUPS) getPPDCacheEntry(printername string) (*cdd.PrinterDescriptionSection, string, string, lib.DuplexVendorMap, lib.MarginsVendorMap, error) {
+	c.ppdFetchingMutex.Lock()
+	_, fetching := c.ppdFetching[printername]
+	c.ppdFetchingMutex.Unlock()
+	if fetching {
+		return nil, "", "", nil, nil, errors.New("Still fetching and translating PPD since an earlier sync")
+	}
+
+	c.ppdWorkers.Acquire()
+	var release sync.Once
+	// done and abandoned are guarded by ppdFetchingMutex.
+	var done, abandoned bool
+
+	// Buffered so that the worker can finish after a timeout.
+	ch := make(chan ppdCacheResult, 1)
+	lib.Go(lib.SubsystemCUPS, func() {
+		defer release.Do(c.ppdWorkers.Release)
+		defer c.ppdDurations.Since(time.Now())
+		var r ppdCacheResult
+		r.description, r.manufacturer, r.model, r.duplexMap, r.marginsMap, r.err = c.pc.getPPDCacheEntry(printername)
+
+		c.ppdFetchingMutex.Lock()
+		done = true
+		if abandoned {
+			delete(c.ppdFetching, printername)
+		}
+		c.ppdFetchingMutex.Unlock()
+		ch <- r
+	})
+
+	var timeout <-chan time.Time
+	if c.ppdTimeout > 0 {
+		timer := time.NewTimer(c.ppdTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case r := <-ch:
+		return r.description, r.manufacturer, r.model, r.duplexMap, r.marginsMap, r.err
+	case <-timeout:
+		c.ppdFetchingMutex.Lock()
+		if !done {
+			abandoned = true
+			c.ppdFetching[printername] = struct{}{}
+		}
+		c.ppdFetchingMutex.Unlock()
+		release.Do(c.ppdWorkers.Release)
+		return nil, "", "", nil, nil, fmt.Errorf("Timed out after %s while fetching and translating PPD", c.ppdTimeout)
+	}
+}
+
 // addStaticDescriptionToPrinters adds information that is true for all
 // printers to printers.
 func addStaticDescriptionToPrinters(printers []lib.Printer) []lib.Printer {
@@ -354,21 +818,6 @@ func (c *CUPS) addSystemTagsToPrinters(printers []lib.Printer) []lib.Printer {
 	return printers
 }
 
-// uname returns strings similar to the Unix uname command:
-// sysname, nodename, release, version, machine
-func uname() (string, string, string, string, string, error) {
-	var name C.struct_utsname
-	_, err := C.uname(&name)
-	if err != nil {
-		var errno syscall.Errno = err.(syscall.Errno)
-		return "", "", "", "", "", fmt.Errorf("Failed to call uname: %s", errno)
-	}
-
-	return C.GoString(&name.sysname[0]), C.GoString(&name.nodename[0]),
-		C.GoString(&name.release[0]), C.GoString(&name.version[0]),
-		C.GoString(&name.machine[0]), nil
-}
-
 func getSystemTags() (map[string]string, error) {
 	tags := make(map[string]string)
 
@@ -391,8 +840,10 @@ func getSystemTags() (map[string]string, error) {
 	tags["system-uname-version"] = version
 	tags["system-uname-machine"] = machine
 
-	tags["connector-cups-client-version"] = fmt.Sprintf("%d.%d.%d",
-		C.CUPS_VERSION_MAJOR, C.CUPS_VERSION_MINOR, C.CUPS_VERSION_PATCH)
+	tags["connector-cups-client-version"] = cupsClientVersion()
+	for k, v := range lib.BuildInfoTags() {
+		tags[k] = v
+	}
 
 	return tags, nil
 }
@@ -403,25 +854,94 @@ func (c *CUPS) RemoveCachedPPD(printername string) {
 }
 
 // GetJobState gets the current state of the job indicated by jobID.
-func (c *CUPS) GetJobState(_ string, jobID uint32) (*cdd.PrintJobStateDiff, error) {
-	ja := C.newArrayOfStrings(C.int(len(jobAttributes)))
-	defer C.freeStringArrayAndStrings(ja, C.int(len(jobAttributes)))
-	for i, attribute := range jobAttributes {
-		C.setStringArrayValue(ja, C.int(i), C.CString(attribute))
+func (c *CUPS) GetJobState(printerName string, jobID uint32) (*cdd.PrintJobStateDiff, error) {
+	if c.raw.isRaw(printerName) {
+		// Jobs are done once they are sent.
+		return convertJobState(9), nil
 	}
 
-	response, err := c.cc.getJobAttributes(C.int(jobID), ja)
+	var attributes map[string][]string
+	var err error
+	if c.direct.isDirect(printerName) {
+		attributes, err = c.direct.getJobAttributes(printerName, jobID, jobAttributes)
+	} else {
+		attributes, err = c.cc.getJobAttributes(printerName, jobID, jobAttributes)
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	// cupsDoRequest() returned ipp_t pointer needs explicit free.
-	defer C.ippDelete(response)
+	var state int32
+	if s, exists := attributes[attrJobState]; exists && len(s) > 0 {
+		if i, err := strconv.ParseInt(s[0], 10, 32); err == nil {
+			state = int32(i)
+		}
+	}
 
-	s := C.ippFindAttribute(response, C.JOB_STATE, C.IPP_TAG_ENUM)
-	state := int32(C.getAttributeIntegerValue(s, C.int(0)))
+	jobState := convertJobState(state)
+	// Impressions are pages, like the job's pages that they are compared
+	// with; a sheet printed on both sides is two.
+	if s, exists := attributes[attrJobImpressionsCompleted]; exists && len(s) > 0 {
+		if i, err := strconv.ParseInt(s[0], 10, 32); err == nil && i > 0 {
+			pages := int32(i)
+			jobState.PagesPrinted = &pages
+		}
+	}
+	return jobState, nil
+}
 
-	return convertJobState(state), nil
+// CancelJob cancels a job that is printing.
+func (c *CUPS) CancelJob(printerName string, jobID uint32) error {
+	if c.raw.isRaw(printerName) {
+		// Jobs are done once they are sent.
+		return nil
+	}
+	if c.direct.isDirect(printerName) {
+		return c.direct.cancelJob(printerName, jobID)
+	}
+	return c.cc.cancelJob(printerName, jobID)
+}
+
+// identifyActions are the ways that printers can show where they are.
+var identifyActions = map[string]struct{}{
+	"display": struct{}{},
+	"flash":   struct{}{},
+	"sound":   struct{}{},
+	"speak":   struct{}{},
+}
+
+// IdentifyPrinter asks a printer to show where it is, by actions, like
+// flash and sound, or by its default actions when actions is empty, so
+// that it can be found among identical printers. CUPS queues are asked
+// through their devices, which must speak IPP, because cupsd doesn't pass
+// Identify-Printer on.
+func (c *CUPS) IdentifyPrinter(printerName string, actions []string) error {
+	for _, action := range actions {
+		if _, exists := identifyActions[action]; !exists {
+			return fmt.Errorf("%s is not an identify action; try display, flash, sound or speak", action)
+		}
+	}
+
+	if c.direct.isDirect(printerName) {
+		uri, transport, _ := c.direct.lookup(printerName)
+		return c.direct.identify(printerName, uri, transport, actions)
+	}
+
+	printers, err := c.GetCachedPrinters()
+	if err != nil {
+		return err
+	}
+	for _, p := range printers {
+		if p.Name != printerName {
+			continue
+		}
+		deviceURI := p.Tags[attrDeviceURI]
+		if _, err := ippHTTPURL(deviceURI); err != nil {
+			return fmt.Errorf("Printer %s can't be identified, because its device %q doesn't speak IPP", printerName, deviceURI)
+		}
+		return c.devices.identify(printerName, deviceURI, nil, actions)
+	}
+	return fmt.Errorf("Printer %s does not exist", printerName)
 }
 
 // convertJobState converts CUPS job state to cdd.PrintJobStateDiff.
@@ -459,147 +979,159 @@ func (c *CUPS) Print(printer *lib.Printer, filename, title, user, gcpJobID strin
 	printer.NativeJobSemaphore.Acquire()
 	defer printer.NativeJobSemaphore.Release()
 
-	pn := C.CString(printer.Name)
-	defer C.free(unsafe.Pointer(pn))
-	fn := C.CString(filename)
-	defer C.free(unsafe.Pointer(fn))
-	var t *C.char
-
 	if c.prefixJobIDToJobTitle {
 		title = fmt.Sprintf("gcp:%s %s", gcpJobID, title)
 	}
 	if len(title) > 255 {
-		t = C.CString(title[:255])
-	} else {
-		t = C.CString(title)
+		title = title[:255]
+	}
+
+	record := lib.JobTicketRecord{
+		GCPJobID:    gcpJobID,
+		PrinterName: printer.Name,
+		Ticket:      ticket,
+	}
+
+	ticket, substitute := c.paperSubstitution.substitute(printer, ticket)
+	if substitute != "" {
+		log.InfoPrinterf(printer.Name, "Printing job %s on %s paper, because the printer doesn't stock the size it asks for",
+			gcpJobID, substitute)
 	}
-	defer C.free(unsafe.Pointer(t))
 
 	options, err := translateTicket(printer, ticket)
 	if err != nil {
-		return 0, err
+		record.Error = err.Error()
+		c.audit.Add(record)
+		return 0, &lib.JobError{Kind: lib.JobErrorTicketUnsupported, Stage: lib.JobErrorStageTranslate, Err: err}
 	}
-	numOptions := C.int(0)
-	var o *C.cups_option_t = nil
-	for key, value := range options {
-		k, v := C.CString(key), C.CString(value)
-		numOptions = C.cupsAddOption(k, v, numOptions, &o)
-		C.free(unsafe.Pointer(k))
-		C.free(unsafe.Pointer(v))
+	format, err := documentFormat(printer, filename)
+	if err != nil {
+		record.Error = err.Error()
+		c.audit.Add(record)
+		return 0, &lib.JobError{Kind: lib.JobErrorDocumentUnsupported, Stage: lib.JobErrorStageTranslate, Err: err}
+	}
+	if format != "" {
+		options[attrDocumentFormat] = format
 	}
-	defer C.cupsFreeOptions(numOptions, o)
-
-	u := C.CString(user)
-	defer C.free(unsafe.Pointer(u))
 
-	cupsJobID, err := c.cc.printFile(u, pn, fn, t, numOptions, o)
+	c.limitJobPriority(printer, user, options)
+	note, err := c.colorPolicy.enforce(printer, user, time.Now(), options)
 	if err != nil {
-		return 0, err
-	}
-
-	return uint32(cupsJobID), nil
-}
-
-// convertIPPDateToTime converts an RFC 2579 date to a time.Time object.
-func convertIPPDateToTime(date *C.ipp_uchar_t) time.Time {
-	r := bytes.NewReader(C.GoBytes(unsafe.Pointer(date), 11))
-	var year uint16
-	var month, day, hour, min, sec, dsec uint8
-	binary.Read(r, binary.BigEndian, &year)
-	binary.Read(r, binary.BigEndian, &month)
-	binary.Read(r, binary.BigEndian, &day)
-	binary.Read(r, binary.BigEndian, &hour)
-	binary.Read(r, binary.BigEndian, &min)
-	binary.Read(r, binary.BigEndian, &sec)
-	binary.Read(r, binary.BigEndian, &dsec)
-
-	var utcDirection, utcHour, utcMin uint8
-	binary.Read(r, binary.BigEndian, &utcDirection)
-	binary.Read(r, binary.BigEndian, &utcHour)
-	binary.Read(r, binary.BigEndian, &utcMin)
-
-	var utcOffset time.Duration
-	utcOffset += time.Duration(utcHour) * time.Hour
-	utcOffset += time.Duration(utcMin) * time.Minute
-	var loc *time.Location
-	if utcDirection == '-' {
-		loc = time.FixedZone("", -int(utcOffset.Seconds()))
-	} else {
-		loc = time.FixedZone("", int(utcOffset.Seconds()))
+		record.Error = err.Error()
+		c.audit.Add(record)
+		return 0, &lib.JobError{Kind: lib.JobErrorNotAllowed, Stage: lib.JobErrorStageTranslate, Err: err}
 	}
-
-	nsec := int(dsec) * 100 * int(time.Millisecond)
-
-	return time.Date(int(year), time.Month(month), int(day), int(hour), int(min), int(sec), nsec, loc)
-}
-
-// attributesToMap converts a slice of C.ipp_attribute_t to a
-// string:string "tag" map.
-func attributesToMap(attributes []*C.ipp_attribute_t) map[string][]string {
-	m := make(map[string][]string)
-
-	for _, a := range attributes {
-		key := C.GoString(a.name)
-		count := int(a.num_values)
-		values := make([]string, count)
-
-		switch a.value_tag {
-		case C.IPP_TAG_NOVALUE, C.IPP_TAG_NOTSETTABLE:
-			// No value means no value.
-
-		case C.IPP_TAG_INTEGER, C.IPP_TAG_ENUM:
-			for i := 0; i < count; i++ {
-				values[i] = strconv.FormatInt(int64(C.getAttributeIntegerValue(a, C.int(i))), 10)
-			}
-
-		case C.IPP_TAG_BOOLEAN:
-			for i := 0; i < count; i++ {
-				if int(C.getAttributeIntegerValue(a, C.int(i))) == 0 {
-					values[i] = "false"
-				} else {
-					values[i] = "true"
-				}
-			}
-
-		case C.IPP_TAG_TEXTLANG, C.IPP_TAG_NAMELANG, C.IPP_TAG_TEXT, C.IPP_TAG_NAME, C.IPP_TAG_KEYWORD, C.IPP_TAG_URI, C.IPP_TAG_URISCHEME, C.IPP_TAG_CHARSET, C.IPP_TAG_LANGUAGE, C.IPP_TAG_MIMETYPE:
-			for i := 0; i < count; i++ {
-				values[i] = C.GoString(C.getAttributeStringValue(a, C.int(i)))
+	if note != "" {
+		log.InfoPrinterf(printer.Name, "%s, job %s", note, gcpJobID)
+		record.ColorPolicy = note
+	}
+	if _, exists := c.autoRotatePrinters[printer.Name]; exists && options[attrOrientationRequested] == "" {
+		// Some drivers print landscape pages sideways unless told.
+		if orientation, ok := detectPDFOrientation(filename); ok {
+			options[attrOrientationRequested] = orientation
+		}
+	}
+	c.quirks.applyOptions(printer, options)
+	c.overrides.apply(printer.Name, options)
+	// A job on substitute paper is scaled to fit it, like fit-to-page.
+	fit := substitute != "" || (options[attrFitToPage] == attrTrue && ignoresFitToPage(printer, c.fitToPageBrokenDrivers))
+	if c.normalizeMixedOrientation && isDuplex(ticket) {
+		if normalized, ok := normalizeMixedOrientation(printer, ticket, filename, options); ok {
+			defer os.Remove(normalized)
+			filename = normalized
+			fit = false
+		}
+	}
+	if fit {
+		if width, height, margins, ok := fitToPageArea(printer, ticket); ok {
+			if scaled, err := fitPDFToPage(filename, width, height, margins); err != nil {
+				log.WarningPrinterf(printer.Name, "Failed to fit job to page, so printing it unscaled: %s", err)
+			} else {
+				defer os.Remove(scaled)
+				filename = scaled
+				delete(options, attrFitToPage)
 			}
+		}
+	}
+	if withSheets, ok := c.jobSheets.insert(printer, ticket, filename, title, user, gcpJobID, options); ok {
+		defer os.Remove(withSheets)
+		filename = withSheets
+	}
+	if converted, ok := c.formats.convert(printer, ticket, filename, options); ok {
+		defer os.Remove(converted)
+		filename = converted
+	}
+	record.Options = options
+
+	var jobID uint32
+	switch {
+	case lib.FaultPercent(lib.FaultCUPSSubmitFail):
+		err = fmt.Errorf("Injected fault %s", lib.FaultCUPSSubmitFail)
+	case c.direct.isDirect(printer.Name):
+		jobID, err = c.direct.printFile(user, printer.Name, filename, title, gcpJobID, options)
+	case c.raw.isRaw(printer.Name):
+		copies, _ := strconv.Atoi(options[attrCopies])
+		jobID, err = c.raw.printFile(user, printer.Name, filename, title, copies)
+	default:
+		jobID, err = c.cc.printFile(user, printer.Name, filename, title, options)
+	}
+	record.NativeJobID = jobID
+	if err != nil {
+		record.Error = err.Error()
+		c.audit.Add(record)
+		return jobID, submitJobError(err)
+	}
+	c.audit.Add(record)
 
-		case C.IPP_TAG_DATE:
-			for i := 0; i < count; i++ {
-				date := C.getAttributeDateValue(a, C.int(i))
-				t := convertIPPDateToTime(date)
-				values[i] = strconv.FormatInt(t.Unix(), 10)
-			}
+	return jobID, nil
+}
 
-		case C.IPP_TAG_RESOLUTION:
-			for i := 0; i < count; i++ {
-				xres, yres := C.int(0), C.int(0)
-				C.getAttributeValueResolution(a, C.int(i), &xres, &yres)
-				values[i] = fmt.Sprintf("%dx%dppi", int(xres), int(yres))
-			}
+// isDuplex tells whether a ticket prints on both sides.
+func isDuplex(ticket *cdd.CloudJobTicket) bool {
+	return ticket != nil && ticket.Print.Duplex != nil && ticket.Print.Duplex.Type != cdd.DuplexNoDuplex
+}
 
-		case C.IPP_TAG_RANGE:
-			for i := 0; i < count; i++ {
-				upper, lower := C.int(0), C.int(0)
-				C.getAttributeValueRange(a, C.int(i), &lower, &upper)
-				values[i] = fmt.Sprintf("%d~%d", int(lower), int(upper))
-			}
+// limitJobPriority keeps users who may not jump the queue from raising the
+// priority of their jobs above the printer's default.
+func (c *CUPS) limitJobPriority(printer *lib.Printer, user string, options map[string]string) {
+	priority, exists := options[attrJobPriority]
+	if !exists {
+		return
+	}
+	allowed, err := c.jobPriorityUsers.Contains(user)
+	if err != nil {
+		log.WarningPrinter(printer.Name, err)
+	}
+	if allowed {
+		return
+	}
 
-		default:
-			if count > 0 {
-				values = []string{"unknown or unsupported type"}
+	def := strconv.Itoa(jobPriorityDefault)
+	if printer.Description.VendorCapability != nil {
+		for _, vc := range *printer.Description.VendorCapability {
+			if vc.ID == attrJobPriority && vc.RangeCap != nil {
+				def = vc.RangeCap.Default
 			}
 		}
+	}
 
-		if len(values) == 1 && (values[0] == "none" || len(values[0]) == 0) {
-			values = []string{}
-		}
-		m[key] = values
+	p, err := strconv.Atoi(priority)
+	if d, _ := strconv.Atoi(def); err != nil || p > d {
+		log.WarningPrinterf(printer.Name, "User %s may not raise job-priority to %s; using %s", user, priority, def)
+		options[attrJobPriority] = def
 	}
+}
 
-	return m
+// OverrideJobOptions merges options into the next jobs of one printer,
+// replacing any earlier override. Zero jobs clears the override.
+func (c *CUPS) OverrideJobOptions(printerName string, o OptionsOverride) {
+	c.overrides.set(printerName, o)
+}
+
+// JobTicketRecords gets the audit records of one GCP job, or of every job
+// when gcpJobID is empty.
+func (c *CUPS) JobTicketRecords(gcpJobID string) []lib.JobTicketRecord {
+	return c.audit.Records(gcpJobID)
 }
 
 func contains(haystack []string, needle string) bool {
@@ -621,16 +1153,17 @@ func findMissing(haystack, needles []string) []string {
 	return missing
 }
 
-func checkPrinterAttributes(printerAttributes []string) error {
-	if !contains(printerAttributes, "all") {
-		missing := findMissing(printerAttributes, requiredPrinterAttributes)
-		if len(missing) > 0 {
-			return fmt.Errorf("Printer attributes missing from config file: %s",
-				strings.Join(missing, ","))
-		}
+// addRequiredPrinterAttributes adds the attributes that the connector
+// needs to the configured printer attributes.
+func addRequiredPrinterAttributes(printerAttributes []string) []string {
+	if contains(printerAttributes, "all") {
+		return printerAttributes
 	}
-
-	return nil
+	missing := findMissing(printerAttributes, requiredPrinterAttributes)
+	if len(missing) > 0 {
+		log.Infof("Requesting printer attributes missing from config file: %s", strings.Join(missing, ","))
+	}
+	return append(append([]string{}, printerAttributes...), missing...)
 }
 
 // The following functions are not relevant to CUPS printing, but are required by the NativePrintSystem interface.
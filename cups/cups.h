@@ -15,6 +15,7 @@ https://developers.google.com/open-source/licenses/bsd
 #include <cups/ppd.h>
 #include <stddef.h>      // size_t
 #include <stdlib.h>      // free, calloc, malloc
+#include <string.h>      // strlen
 #include <sys/socket.h>  // AF_UNSPEC
 #include <sys/utsname.h> // uname
 #include <time.h>        // time_t
@@ -25,6 +26,19 @@ extern const char
 	*POST_RESOURCE,
 	*REQUESTED_ATTRIBUTES,
 	*JOB_URI_ATTRIBUTE,
+	*LIMIT,
+	*FIRST_PRINTER_NAME,
+	*PRINTER_URI_ATTRIBUTE,
+	*WHICH_JOBS,
+	*NOT_COMPLETED,
+	*JOB_ID,
+	*JOB_NAME,
+	*JOB_UUID,
+	*REQUESTING_USER_NAME,
+	*DOCUMENT_FORMAT,
+	*LAST_DOCUMENT,
+	*MY_JOBS,
+	*ALL,
 	*IPP;
 
 char **newArrayOfStrings(int size);
@@ -37,6 +51,7 @@ int getAttributeIntegerValue(ipp_attribute_t *attr, int i);
 const char *getAttributeStringValue(ipp_attribute_t *attr, int i);
 void getAttributeValueRange(ipp_attribute_t *attr, int i, int *lower, int *upper);
 void getAttributeValueResolution(ipp_attribute_t *attr, int i, int *xres, int *yres);
+void setNoTimeout(http_t *http);
 
 #ifndef _CUPS_API_1_7
 int ippValidateAttributes(ipp_t *ipp);
@@ -50,7 +65,16 @@ http_t *httpConnect2(const char *host, int port, http_addrlist_t *addrlist, int
 # define HTTP_STATUS_OK               HTTP_OK
 # define HTTP_STATUS_NOT_MODIFIED     HTTP_NOT_MODIFIED
 # define IPP_OP_CUPS_GET_PRINTERS     CUPS_GET_PRINTERS
+# define IPP_OP_CREATE_JOB            IPP_CREATE_JOB
+# define IPP_OP_SEND_DOCUMENT         IPP_SEND_DOCUMENT
 # define IPP_OP_GET_JOB_ATTRIBUTES    IPP_GET_JOB_ATTRIBUTES
+# define IPP_OP_GET_JOBS              IPP_GET_JOBS
 # define IPP_STATUS_OK                IPP_OK
 # define IPP_STATUS_ERROR_NOT_FOUND   IPP_NOT_FOUND
+# define IPP_STATUS_ERROR_BAD_REQUEST IPP_BAD_REQUEST
+# define IPP_STATUS_ERROR_INTERNAL    IPP_INTERNAL_ERROR
+#endif
+
+#ifndef _CUPS_API_2_0
+void httpSetKeepAlive(http_t *http, http_keepalive_t keep_alive);
 #endif
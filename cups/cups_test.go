@@ -0,0 +1,681 @@
+// Copyright 2015 Google Inc. All rights reserved.
+
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+// +build linux darwin freebsd
+
+package cups
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/cloud-print-connector/cdd"
+	"github.com/google/cloud-print-connector/lib"
+)
+
+// fakeCUPSClient is an in-memory cupsClient.
+type fakeCUPSClient struct {
+	// printers are returned by getPrinters, sorted by printer-name.
+	printers []map[string][]string
+	// ppds maps printer name to PPD contents.
+	ppds map[string]string
+	// slowPPDs maps printer name to a channel that getPPD waits for to
+	// close before it returns the printer's PPD.
+	slowPPDs map[string]chan struct{}
+	// jobs maps CUPS job ID to job attributes.
+	jobs map[uint32]map[string][]string
+
+	// printed records the arguments of each call to printFile.
+	printed   []fakePrintedJob
+	nextJobID uint32
+	// getPrintersCalls records the limit and firstPrinterName of each call.
+	getPrintersCalls []string
+	// getPrintersErr, when not nil, fails getPrinters.
+	getPrintersErr error
+	getPPDCalls    int
+	mutex          sync.Mutex
+}
+
+type fakePrintedJob struct {
+	user, printername, filename, title string
+	options                            map[string]string
+}
+
+func newFakeCUPSClient() *fakeCUPSClient {
+	return &fakeCUPSClient{
+		ppds:      make(map[string]string),
+		slowPPDs:  make(map[string]chan struct{}),
+		jobs:      make(map[uint32]map[string][]string),
+		nextJobID: 1,
+	}
+}
+
+// addPrinter adds a printer with the minimum attributes required for
+// translation, plus extra, and a PPD.
+func (f *fakeCUPSClient) addPrinter(name string, extra map[string][]string, ppd string) {
+	attributes := map[string][]string{
+		attrPrinterName:  []string{name},
+		attrPrinterState: []string{"3"},
+		attrPrinterUUID:  []string{"urn:uuid:" + name},
+		attrPrinterInfo:  []string{name + " info"},
+		attrDeviceURI:    []string{"ipp://" + name},
+	}
+	for k, v := range extra {
+		attributes[k] = v
+	}
+
+	f.printers = append(f.printers, attributes)
+	sort.Sort(byPrinterName(f.printers))
+	f.ppds[name] = ppd
+}
+
+type byPrinterName []map[string][]string
+
+func (b byPrinterName) Len() int      { return len(b) }
+func (b byPrinterName) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b byPrinterName) Less(i, j int) bool {
+	return b[i][attrPrinterName][0] < b[j][attrPrinterName][0]
+}
+
+func (f *fakeCUPSClient) getPrinters(attributes []string, limit uint, firstPrinterName string) ([]map[string][]string, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.getPrintersCalls = append(f.getPrintersCalls, fmt.Sprintf("%d:%s", limit, firstPrinterName))
+	if f.getPrintersErr != nil {
+		return nil, f.getPrintersErr
+	}
+
+	result := make([]map[string][]string, 0, len(f.printers))
+	for _, p := range f.printers {
+		if firstPrinterName != "" && p[attrPrinterName][0] < firstPrinterName {
+			continue
+		}
+		if limit > 0 && uint(len(result)) >= limit {
+			break
+		}
+		// Copy so that translation can't modify the fake's state.
+		c := make(map[string][]string, len(p))
+		for k, v := range p {
+			c[k] = v
+		}
+		result = append(result, c)
+	}
+	return result, nil
+}
+
+func (f *fakeCUPSClient) getPPD(printername string, modtime *time.Time) (string, error) {
+	f.mutex.Lock()
+	f.getPPDCalls++
+	slow := f.slowPPDs[printername]
+	f.mutex.Unlock()
+	if slow != nil {
+		<-slow
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	ppd, exists := f.ppds[printername]
+	if !exists {
+		return "", fmt.Errorf("no PPD for printer %s", printername)
+	}
+	if !modtime.IsZero() {
+		return "", nil
+	}
+	*modtime = time.Unix(1, 0)
+	return ppd, nil
+}
+
+func (f *fakeCUPSClient) printFile(user, printername, filename, title string, options map[string]string) (uint32, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if _, exists := f.ppds[printername]; !exists {
+		return 0, fmt.Errorf("no such printer %s", printername)
+	}
+
+	f.printed = append(f.printed, fakePrintedJob{user, printername, filename, title, options})
+	jobID := f.nextJobID
+	f.nextJobID++
+	f.jobs[jobID] = map[string][]string{attrJobState: []string{"3"}}
+	return jobID, nil
+}
+
+func (f *fakeCUPSClient) getJobAttributes(printername string, jobID uint32, attributes []string) (map[string][]string, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	job, exists := f.jobs[jobID]
+	if !exists {
+		return nil, errors.New("IPP status code 1030")
+	}
+	return job, nil
+}
+
+func (f *fakeCUPSClient) countPendingJobs() (uint, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	var pending uint
+	for _, job := range f.jobs {
+		// Job states 7 and up are completed.
+		if state, err := strconv.Atoi(job[attrJobState][0]); err == nil && state < 7 {
+			pending++
+		}
+	}
+	return pending, nil
+}
+
+func (f *fakeCUPSClient) cancelJob(printername string, jobID uint32) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if _, exists := f.jobs[jobID]; !exists {
+		return errors.New("IPP status code 1030")
+	}
+	f.jobs[jobID] = map[string][]string{attrJobState: []string{"7"}}
+	return nil
+}
+
+func (f *fakeCUPSClient) connQtyOpen() uint             { return 0 }
+func (f *fakeCUPSClient) connQtyMax() uint              { return 1 }
+func (f *fakeCUPSClient) connPoolResizes() (uint, uint) { return 0, 0 }
+func (f *fakeCUPSClient) connReuse() (uint64, uint64)   { return 0, 0 }
+
+const fakePPD = `*PPD-Adobe: "4.3"
+*Manufacturer: "Acme"
+*NickName: "Acme LaserWriter 9000"
+*OpenUI *Duplex/2-Sided Printing: PickOne
+*DefaultDuplex: None
+*Duplex None/Off: ""
+*Duplex DuplexNoTumble/Long Edge: ""
+*Duplex DuplexTumble/Short Edge: ""
+*CloseUI: *Duplex`
+
+// newTestCUPS creates a CUPS that talks to f rather than a CUPS server.
+func newTestCUPS(f *fakeCUPSClient, printerPageSize uint) *CUPS {
+	return &CUPS{
+		cc:                f,
+		pc:                newPPDCache(f, []string{}, 0, nil),
+		infoToDisplayName: true,
+		displayNamePrefix: "test-",
+		printerAttributes: []string{"all"},
+		systemTags:        map[string]string{"system-arch": "test"},
+		printerBlacklist:  map[string]interface{}{},
+		printerWhitelist:  map[string]interface{}{},
+		printerPageSize:   printerPageSize,
+		ppdWorkers:        lib.NewSemaphore(2),
+		ppdFetching:       make(map[string]struct{}),
+		audit:             lib.NewJobTicketAudit(10, 0),
+		overrides:         newOptionsOverrides(""),
+	}
+}
+
+func printerNames(printers []lib.Printer) []string {
+	names := make([]string, len(printers))
+	for i := range printers {
+		names[i] = printers[i].Name
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestGetPrinters(t *testing.T) {
+	f := newFakeCUPSClient()
+	f.addPrinter("alpha", nil, fakePPD)
+	f.addPrinter("beta", nil, fakePPD)
+	c := newTestCUPS(f, 0)
+
+	printers, err := c.GetPrinters()
+	if err != nil {
+		t.Fatalf("GetPrinters failed: %s", err)
+	}
+	if len(printers) != 2 {
+		t.Fatalf("expected 2 printers, got %d", len(printers))
+	}
+
+	var p lib.Printer
+	for i := range printers {
+		if printers[i].Name == "alpha" {
+			p = printers[i]
+		}
+	}
+	if p.DefaultDisplayName != "test-alpha info" {
+		t.Logf("expected display name test-alpha info, got %s", p.DefaultDisplayName)
+		t.Fail()
+	}
+	if p.UUID != "alpha" {
+		t.Logf("expected UUID alpha, got %s", p.UUID)
+		t.Fail()
+	}
+	if p.Manufacturer != "Acme" || p.Model != "LaserWriter 9000" {
+		t.Logf("expected Acme LaserWriter 9000, got %s %s", p.Manufacturer, p.Model)
+		t.Fail()
+	}
+	if p.Description.Duplex == nil {
+		t.Logf("expected duplex from PPD")
+		t.Fail()
+	}
+	if p.Description.Collate == nil || p.Description.ReverseOrder == nil {
+		t.Logf("expected collate and reverse order from PPD")
+		t.Fail()
+	}
+	if p.Tags["system-arch"] != "test" {
+		t.Logf("expected system tags, got %v", p.Tags)
+		t.Fail()
+	}
+	if p.Tags[attrDeviceURI] != "ipp://alpha" {
+		t.Logf("expected printer attribute tags, got %v", p.Tags)
+		t.Fail()
+	}
+	if p.GCPVersion != lib.GCPAPIVersion {
+		t.Logf("expected GCP version %s, got %s", lib.GCPAPIVersion, p.GCPVersion)
+		t.Fail()
+	}
+
+	// The second call hits the PPD cache, which asks the client whether the PPD changed.
+	if _, err = c.GetPrinters(); err != nil {
+		t.Fatalf("GetPrinters failed: %s", err)
+	}
+	if f.getPPDCalls != 4 {
+		t.Logf("expected 4 getPPD calls, got %d", f.getPPDCalls)
+		t.Fail()
+	}
+}
+
+func TestGetPrintersPaging(t *testing.T) {
+	f := newFakeCUPSClient()
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		f.addPrinter(name, nil, fakePPD)
+	}
+	c := newTestCUPS(f, 2)
+
+	printers, err := c.GetPrinters()
+	if err != nil {
+		t.Fatalf("GetPrinters failed: %s", err)
+	}
+
+	expected := []string{"a", "b", "c", "d", "e"}
+	if names := printerNames(printers); !reflect.DeepEqual(expected, names) {
+		t.Logf("expected %v, got %v", expected, names)
+		t.Fail()
+	}
+
+	// first-printer-name is inclusive, so each page after the first
+	// repeats the last printer of the previous page.
+	expectedCalls := []string{"2:", "2:b", "2:c", "2:d", "2:e"}
+	if !reflect.DeepEqual(expectedCalls, f.getPrintersCalls) {
+		t.Logf("expected calls %v, got %v", expectedCalls, f.getPrintersCalls)
+		t.Fail()
+	}
+}
+
+func TestGetPrintersFilters(t *testing.T) {
+	f := newFakeCUPSClient()
+	f.addPrinter("blacklisted", nil, fakePPD)
+	f.addPrinter("raw", map[string][]string{"printer-make-and-model": []string{"Local Raw Printer"}}, fakePPD)
+	f.addPrinter("badppd", nil, "not a PPD")
+	f.addPrinter("good", nil, fakePPD)
+	c := newTestCUPS(f, 0)
+	c.printerBlacklist["blacklisted"] = struct{}{}
+	c.ignoreRawPrinters = true
+
+	printers, err := c.GetPrinters()
+	if err != nil {
+		t.Fatalf("GetPrinters failed: %s", err)
+	}
+
+	// The printer with a bad PPD is synced without its capabilities.
+	expected := []string{"badppd", "good"}
+	if names := printerNames(printers); !reflect.DeepEqual(expected, names) {
+		t.Fatalf("expected %v, got %v", expected, names)
+	}
+	for _, p := range printers {
+		if p.CapsUnavailable != (p.Name == "badppd") {
+			t.Logf("expected capabilities unavailable only for badppd, got %t for %s", p.CapsUnavailable, p.Name)
+			t.Fail()
+		}
+	}
+}
+
+func TestGetPrintersSlowPPD(t *testing.T) {
+	f := newFakeCUPSClient()
+	f.addPrinter("good", nil, fakePPD)
+	f.addPrinter("slow", nil, fakePPD)
+	release := make(chan struct{})
+	f.slowPPDs["slow"] = release
+	c := newTestCUPS(f, 0)
+	c.ppdTimeout = 50 * time.Millisecond
+
+	capsUnavailable := func() map[string]bool {
+		printers, err := c.GetPrinters()
+		if err != nil {
+			t.Fatalf("GetPrinters failed: %s", err)
+		}
+		result := make(map[string]bool, len(printers))
+		for _, p := range printers {
+			result[p.Name] = p.CapsUnavailable
+		}
+		return result
+	}
+
+	expected := map[string]bool{"good": false, "slow": true}
+	if result := capsUnavailable(); !reflect.DeepEqual(expected, result) {
+		t.Logf("expected %v, got %v", expected, result)
+		t.Fail()
+	}
+
+	// The PPD that timed out isn't fetched again while it's still being fetched.
+	if result := capsUnavailable(); !reflect.DeepEqual(expected, result) {
+		t.Logf("expected %v while still fetching, got %v", expected, result)
+		t.Fail()
+	}
+	f.mutex.Lock()
+	calls := f.getPPDCalls
+	f.mutex.Unlock()
+	if calls != 3 {
+		t.Logf("expected 3 getPPD calls, got %d", calls)
+		t.Fail()
+	}
+
+	close(release)
+	for i := 0; ; i++ {
+		c.ppdFetchingMutex.Lock()
+		fetching := len(c.ppdFetching)
+		c.ppdFetchingMutex.Unlock()
+		if fetching == 0 {
+			break
+		}
+		if i == 100 {
+			t.Fatal("the slow PPD was never done")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	expected["slow"] = false
+	if result := capsUnavailable(); !reflect.DeepEqual(expected, result) {
+		t.Logf("expected %v once fetched, got %v", expected, result)
+		t.Fail()
+	}
+}
+
+func TestGetPrintersNone(t *testing.T) {
+	c := newTestCUPS(newFakeCUPSClient(), 0)
+
+	printers, err := c.GetPrinters()
+	if err != nil {
+		t.Fatalf("GetPrinters failed: %s", err)
+	}
+	if len(printers) != 0 {
+		t.Logf("expected no printers, got %d", len(printers))
+		t.Fail()
+	}
+}
+
+func TestAddRequiredPrinterAttributes(t *testing.T) {
+	attributes := addRequiredPrinterAttributes([]string{attrPrinterName, "printer-location"})
+	if missing := findMissing(attributes, requiredPrinterAttributes); len(missing) != 0 || !contains(attributes, "printer-location") {
+		t.Logf("expected the configured and required attributes, got %v", attributes)
+		t.Fail()
+	}
+	if attributes := addRequiredPrinterAttributes([]string{"all"}); len(attributes) != 1 {
+		t.Logf("expected all to stay alone, got %v", attributes)
+		t.Fail()
+	}
+}
+
+func TestGetPrintersMissingAttributes(t *testing.T) {
+	f := newFakeCUPSClient()
+	f.addPrinter("p1", nil, fakePPD)
+	delete(f.printers[0], attrPrinterUUID)
+	c := newTestCUPS(f, 0)
+
+	printers, err := c.GetPrinters()
+	if err != nil {
+		t.Fatalf("GetPrinters failed: %s", err)
+	}
+	if len(printers) != 1 || printers[0].UUID != "p1" {
+		t.Logf("expected a printer identified by name without %s, got %+v", attrPrinterUUID, printers)
+		t.Fail()
+	}
+	if _, noted := c.missing.noted[attrPrinterUUID]; !noted {
+		t.Logf("expected a note about %s", attrPrinterUUID)
+		t.Fail()
+	}
+	if _, noted := c.missing.noted[attrPrinterName]; noted {
+		t.Logf("expected no note about %s", attrPrinterName)
+		t.Fail()
+	}
+}
+
+func TestPrint(t *testing.T) {
+	f := newFakeCUPSClient()
+	f.addPrinter("alpha", map[string][]string{
+		attrCopiesDefault:   []string{"1"},
+		attrCopiesSupported: []string{"1~99"},
+	}, fakePPD)
+	c := newTestCUPS(f, 0)
+	c.prefixJobIDToJobTitle = true
+
+	printers, err := c.GetPrinters()
+	if err != nil {
+		t.Fatalf("GetPrinters failed: %s", err)
+	}
+	printer := printers[0]
+	printer.NativeJobSemaphore = lib.NewSemaphore(1)
+
+	ticket := &cdd.CloudJobTicket{
+		Print: cdd.PrintTicketSection{
+			Copies: &cdd.CopiesTicketItem{Copies: 2},
+		},
+	}
+	title := string(make([]byte, 300))
+	jobID, err := c.Print(&printer, "/tmp/job.pdf", title, "user@example.com", "gcp-123", ticket)
+	if err != nil {
+		t.Fatalf("Print failed: %s", err)
+	}
+	if jobID != 1 {
+		t.Logf("expected job ID 1, got %d", jobID)
+		t.Fail()
+	}
+
+	if len(f.printed) != 1 {
+		t.Fatalf("expected 1 printed job, got %d", len(f.printed))
+	}
+	job := f.printed[0]
+	if job.user != "user@example.com" || job.printername != "alpha" || job.filename != "/tmp/job.pdf" {
+		t.Logf("unexpected job %+v", job)
+		t.Fail()
+	}
+	if len(job.title) != 255 || job.title[:13] != "gcp:gcp-123 \x00" {
+		t.Logf("expected title prefixed with job ID and truncated to 255, got %q", job.title)
+		t.Fail()
+	}
+	if job.options[attrCopies] != "2" {
+		t.Logf("expected copies option 2, got %v", job.options)
+		t.Fail()
+	}
+
+	records := c.JobTicketRecords("gcp-123")
+	if len(records) != 1 {
+		t.Fatalf("expected 1 job ticket record, got %d", len(records))
+	}
+	if r := records[0]; r.PrinterName != "alpha" || r.NativeJobID != 1 || r.Ticket == nil ||
+		r.Options[attrCopies] != "2" || r.Error != "" {
+		t.Logf("unexpected job ticket record %+v", r)
+		t.Fail()
+	}
+}
+
+func TestPrintJobPriority(t *testing.T) {
+	f := newFakeCUPSClient()
+	f.addPrinter("alpha", map[string][]string{
+		attrJobPriorityDefault:   []string{"50"},
+		attrJobPrioritySupported: []string{"100"},
+	}, fakePPD)
+	c := newTestCUPS(f, 0)
+	c.jobPriorityUsers, _ = lib.NewUserList([]string{"boss"}, nil)
+
+	printers, err := c.GetPrinters()
+	if err != nil {
+		t.Fatalf("GetPrinters failed: %s", err)
+	}
+	printer := printers[0]
+	printer.NativeJobSemaphore = lib.NewSemaphore(1)
+
+	testCases := []struct {
+		user, priority, expected string
+	}{
+		{"boss", "90", "90"},
+		{"intern", "90", "50"},
+		{"intern", "10", "10"},
+	}
+	for i, tc := range testCases {
+		ticket := &cdd.CloudJobTicket{
+			Print: cdd.PrintTicketSection{
+				VendorTicketItem: []cdd.VendorTicketItem{{ID: attrJobPriority, Value: tc.priority}},
+			},
+		}
+		if _, err := c.Print(&printer, "/tmp/job.pdf", "title", tc.user, "gcp-123", ticket); err != nil {
+			t.Fatalf("Print failed: %s", err)
+		}
+		if actual := f.printed[i].options[attrJobPriority]; actual != tc.expected {
+			t.Logf("%s asked for job-priority %s; expected %s, got %s", tc.user, tc.priority, tc.expected, actual)
+			t.Fail()
+		}
+	}
+}
+
+func TestPrintAutoRotate(t *testing.T) {
+	f := newFakeCUPSClient()
+	f.addPrinter("alpha", map[string][]string{}, fakePPD)
+	f.addPrinter("beta", map[string][]string{}, fakePPD)
+	c := newTestCUPS(f, 0)
+	c.autoRotatePrinters = map[string]interface{}{"alpha": struct{}{}}
+
+	pdf, err := ioutil.TempFile("", "auto-rotate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(pdf.Name())
+	if _, err = pdf.WriteString("%PDF-1.1\n3 0 obj << /Type /Page /MediaBox [0 0 792 612] >> endobj\n"); err != nil {
+		t.Fatal(err)
+	}
+	pdf.Close()
+
+	printers, err := c.GetPrinters()
+	if err != nil {
+		t.Fatalf("GetPrinters failed: %s", err)
+	}
+	for i := range printers {
+		printers[i].NativeJobSemaphore = lib.NewSemaphore(1)
+		if _, err = c.Print(&printers[i], pdf.Name(), "title", "user", "gcp-123", &cdd.CloudJobTicket{}); err != nil {
+			t.Fatalf("Print failed: %s", err)
+		}
+	}
+
+	for _, job := range f.printed {
+		expected := ""
+		if job.printername == "alpha" {
+			expected = "4"
+		}
+		if actual := job.options[attrOrientationRequested]; actual != expected {
+			t.Logf("%s: expected orientation-requested %q, got %q", job.printername, expected, actual)
+			t.Fail()
+		}
+	}
+}
+
+func TestGetJobState(t *testing.T) {
+	f := newFakeCUPSClient()
+	f.jobs[7] = map[string][]string{attrJobState: []string{"9"}}
+	f.jobs[8] = map[string][]string{attrJobState: []string{"7"}}
+	c := newTestCUPS(f, 0)
+
+	state, err := c.GetJobState("", 7)
+	if err != nil {
+		t.Fatalf("GetJobState failed: %s", err)
+	}
+	if state.State == nil || state.State.Type != cdd.JobStateDone {
+		t.Logf("expected DONE, got %+v", state.State)
+		t.Fail()
+	}
+
+	state, err = c.GetJobState("", 8)
+	if err != nil {
+		t.Fatalf("GetJobState failed: %s", err)
+	}
+	if state.State == nil || state.State.Type != cdd.JobStateAborted || state.State.UserActionCause == nil {
+		t.Logf("expected ABORTED by user, got %+v", state.State)
+		t.Fail()
+	}
+
+	if _, err = c.GetJobState("", 9); err == nil {
+		t.Log("expected error for unknown job")
+		t.Fail()
+	}
+
+	f.jobs[10] = map[string][]string{attrJobState: []string{"5"}, attrJobImpressionsCompleted: []string{"4"}}
+	state, err = c.GetJobState("", 10)
+	if err != nil {
+		t.Fatalf("GetJobState failed: %s", err)
+	}
+	if state.PagesPrinted == nil || *state.PagesPrinted != 4 {
+		t.Logf("expected 4 pages printed, got %v", state.PagesPrinted)
+		t.Fail()
+	}
+}
+
+func TestCancelJob(t *testing.T) {
+	f := newFakeCUPSClient()
+	f.jobs[7] = map[string][]string{attrJobState: []string{"5"}}
+	c := newTestCUPS(f, 0)
+
+	if err := c.CancelJob("", 7); err != nil {
+		t.Fatalf("CancelJob failed: %s", err)
+	}
+	state, err := c.GetJobState("", 7)
+	if err != nil {
+		t.Fatalf("GetJobState failed: %s", err)
+	}
+	if state.State == nil || state.State.Type != cdd.JobStateAborted {
+		t.Logf("expected ABORTED, got %+v", state.State)
+		t.Fail()
+	}
+
+	if err = c.CancelJob("", 9); err == nil {
+		t.Log("expected error for unknown job")
+		t.Fail()
+	}
+}
+
+func TestServerStats(t *testing.T) {
+	f := newFakeCUPSClient()
+	f.addPrinter("a", map[string][]string{attrCUPSVersion: []string{"2.2.1"}}, fakePPD)
+	f.addPrinter("b", nil, fakePPD)
+	f.jobs[1] = map[string][]string{attrJobState: []string{"3"}}
+	f.jobs[2] = map[string][]string{attrJobState: []string{"5"}}
+	f.jobs[3] = map[string][]string{attrJobState: []string{"9"}}
+	c := newTestCUPS(f, 0)
+
+	version, queues, jobs, err := c.ServerStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != "2.2.1" || queues != 2 || jobs != 2 {
+		t.Logf("expected version 2.2.1, 2 queues, 2 pending jobs; got %s, %d, %d", version, queues, jobs)
+		t.Fail()
+	}
+}
@@ -0,0 +1,227 @@
+// Copyright 2015 Google Inc. All rights reserved.
+
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+// +build linux darwin freebsd
+
+package cups
+
+import (
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/cloud-print-connector/lib"
+	"github.com/google/cloud-print-connector/log"
+)
+
+const (
+	// Attributes of IPP printers that describe the device for inventory.
+	attrPrinterDeviceID              = "printer-device-id"
+	attrPrinterFirmwareStringVersion = "printer-firmware-string-version"
+	attrPrinterImpressionsCompleted  = "printer-impressions-completed"
+
+	// Printer MIB (RFC 3805) and Entity MIB (RFC 6933) objects of the first
+	// device of a printer.
+	oidEntPhysicalFirmwareRev = "1.3.6.1.2.1.47.1.1.1.1.9.1"
+	oidPrtGeneralSerialNumber = "1.3.6.1.2.1.43.5.1.1.17.1"
+	oidPrtMarkerLifeCount     = "1.3.6.1.2.1.43.10.2.1.4.1.1"
+
+	// Tags of the device behind a printer.
+	tagDeviceFirmwareVersion = "device-firmware-version"
+	tagDeviceSerialNumber    = "device-serial-number"
+	tagDevicePageCount       = "device-page-count"
+
+	// deviceInfoTTL is how long the device info of a printer is kept before
+	// it is asked for again. Page counts are as old as this.
+	deviceInfoTTL = time.Hour
+	// deviceInfoTimeout limits each request to a device.
+	deviceInfoTimeout = 10 * time.Second
+	// defaultSNMPCommunity is the read community of most printers.
+	defaultSNMPCommunity = "public"
+)
+
+// deviceInfoAttributes are requested of IPP printers for their device info.
+var deviceInfoAttributes = []string{
+	attrPrinterDeviceID,
+	attrPrinterFirmwareStringVersion,
+	attrPrinterImpressionsCompleted,
+}
+
+// deviceIDSerialNumberKeys are the keys of IEEE 1284 device IDs that
+// manufacturers use for the serial number.
+var deviceIDSerialNumberKeys = []string{"SN", "SERN", "SERIALNUMBER"}
+
+// deviceInfoFromAttributes gets the device info tags of an IPP printer from
+// its attributes.
+func deviceInfoFromAttributes(attributes map[string][]string) map[string]string {
+	info := make(map[string]string)
+	if v := attributes[attrPrinterFirmwareStringVersion]; len(v) > 0 {
+		info[tagDeviceFirmwareVersion] = strings.Join(v, ",")
+	}
+	if v := attributes[attrPrinterImpressionsCompleted]; len(v) > 0 {
+		info[tagDevicePageCount] = v[0]
+	}
+	if v := attributes[attrPrinterDeviceID]; len(v) > 0 {
+		if sn := deviceIDSerialNumber(v[0]); sn != "" {
+			info[tagDeviceSerialNumber] = sn
+		}
+	}
+	return info
+}
+
+// deviceIDSerialNumber gets the serial number of an IEEE 1284 device ID,
+// like MFG:Acme;MDL:Laser 9000;SN:X123;
+func deviceIDSerialNumber(deviceID string) string {
+	fields := make(map[string]string)
+	for _, field := range strings.Split(deviceID, ";") {
+		if kv := strings.SplitN(field, ":", 2); len(kv) == 2 {
+			fields[strings.ToUpper(strings.TrimSpace(kv[0]))] = strings.TrimSpace(kv[1])
+		}
+	}
+	for _, key := range deviceIDSerialNumberKeys {
+		if sn := fields[key]; sn != "" {
+			return sn
+		}
+	}
+	return ""
+}
+
+// deviceInfoFromSNMP gets the device info tags of a printer from the values
+// of its Printer MIB, by OID.
+func deviceInfoFromSNMP(values map[string]string) map[string]string {
+	info := make(map[string]string)
+	if v := values[oidEntPhysicalFirmwareRev]; v != "" {
+		info[tagDeviceFirmwareVersion] = v
+	}
+	if v := values[oidPrtGeneralSerialNumber]; v != "" {
+		info[tagDeviceSerialNumber] = v
+	}
+	if v := values[oidPrtMarkerLifeCount]; v != "" {
+		info[tagDevicePageCount] = v
+	}
+	return info
+}
+
+type deviceInfoEntry struct {
+	info     map[string]string
+	fetched  time.Time
+	fetching bool
+}
+
+// deviceInfoCache holds the device info of the devices behind CUPS queues,
+// by device URI. Devices are asked in the background, so that a slow device
+// never delays a printer sync; their tags appear in the next sync.
+type deviceInfoCache struct {
+	// ipp sends requests to IPP devices; it has no printers.
+	ipp           *directClient
+	snmpCommunity string
+
+	mutex   sync.Mutex
+	entries map[string]*deviceInfoEntry
+}
+
+func newDeviceInfoCache(snmpCommunity string) *deviceInfoCache {
+	if snmpCommunity == "" {
+		snmpCommunity = defaultSNMPCommunity
+	}
+	return &deviceInfoCache{
+		ipp:           &directClient{},
+		snmpCommunity: snmpCommunity,
+		entries:       make(map[string]*deviceInfoEntry),
+	}
+}
+
+// get gets the device info tags of deviceURI, and starts asking the device
+// again when they are older than deviceInfoTTL.
+func (dc *deviceInfoCache) get(deviceURI string) map[string]string {
+	dc.mutex.Lock()
+	defer dc.mutex.Unlock()
+
+	e, exists := dc.entries[deviceURI]
+	if !exists {
+		e = &deviceInfoEntry{}
+		dc.entries[deviceURI] = e
+	}
+	if !e.fetching && time.Since(e.fetched) > deviceInfoTTL {
+		e.fetching = true
+		lib.Go(lib.SubsystemCUPS, func() { dc.refresh(deviceURI, e) })
+	}
+	return e.info
+}
+
+func (dc *deviceInfoCache) refresh(deviceURI string, e *deviceInfoEntry) {
+	info, err := dc.fetch(deviceURI)
+	if err != nil {
+		log.Infof("Failed to get device info of %s: %s", deviceURI, err)
+	}
+
+	dc.mutex.Lock()
+	defer dc.mutex.Unlock()
+
+	if err == nil || e.info == nil {
+		e.info = info
+	}
+	e.fetched = time.Now()
+	e.fetching = false
+}
+
+// fetch asks a device for its info: IPP devices with IPP, and network
+// devices with SNMP. Other devices, like USB devices, have no info.
+func (dc *deviceInfoCache) fetch(deviceURI string) (map[string]string, error) {
+	u, err := url.Parse(deviceURI)
+	if err != nil {
+		return nil, err
+	}
+
+	switch u.Scheme {
+	case "ipp", "ipps":
+		uri := *u
+		uri.User = nil
+		request := dc.ipp.newRequest(ippOpGetPrinterAttributes, uri.String())
+		request.operationAttributes = append(request.operationAttributes,
+			ippAttribute{ippTagKeyword, attrRequestedAttributes, deviceInfoAttributes})
+		response, err := dc.ipp.doRequest("", "", uri.String(), nil, request, nil, deviceInfoTimeout)
+		if err == nil {
+			if info := deviceInfoFromAttributes(response.group(ippTagPrinter)); len(info) > 0 {
+				return info, nil
+			}
+		}
+		// Older IPP printers have no device attributes, but have SNMP.
+		fallthrough
+	case rawSchemeSocket, rawSchemeLPD:
+		host := u.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		values, err := snmpGet(net.JoinHostPort(strings.Trim(host, "[]"), snmpPort), dc.snmpCommunity,
+			[]string{oidEntPhysicalFirmwareRev, oidPrtGeneralSerialNumber, oidPrtMarkerLifeCount}, deviceInfoTimeout)
+		if err != nil {
+			return nil, err
+		}
+		return deviceInfoFromSNMP(values), nil
+	}
+
+	return nil, nil
+}
+
+// addDeviceInfoToPrinters adds the device info tags of the devices behind
+// printers to the tags of printers.
+func (c *CUPS) addDeviceInfoToPrinters(printers []lib.Printer) {
+	if c.deviceInfo == nil {
+		return
+	}
+	for i := range printers {
+		deviceURI := printers[i].Tags[attrDeviceURI]
+		if deviceURI == "" {
+			continue
+		}
+		for k, v := range c.deviceInfo.get(deviceURI) {
+			printers[i].Tags[k] = v
+		}
+	}
+}
@@ -0,0 +1,172 @@
+// Copyright 2015 Google Inc. All rights reserved.
+
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+// +build linux darwin freebsd
+
+package cups
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/google/cloud-print-connector/lib"
+	"github.com/google/cloud-print-connector/log"
+)
+
+// How the certificates of ipps:// direct printers are validated.
+const (
+	// directTLSSystem validates with the system's root certificates, like
+	// any HTTPS client; the default.
+	directTLSSystem = "system"
+	// directTLSPinPrefix, followed by the SHA-256 of the printer's
+	// certificate in hex, accepts that certificate only.
+	directTLSPinPrefix = "pin:"
+	// directTLSTOFU accepts the first certificate that the printer
+	// presents, and only that certificate after.
+	directTLSTOFU = "tofu"
+)
+
+// newDirectTransports creates the transports of the ipps:// printers whose
+// certificates are validated by pin or on first use, by name. modes maps
+// printer names to validation modes; tofuFilename remembers the
+// certificates that were trusted on first use.
+func newDirectTransports(printers, modes map[string]string, tofuFilename string) (map[string]http.RoundTripper, error) {
+	var tofu *tofuStore
+	transports := make(map[string]http.RoundTripper, len(modes))
+	for name, mode := range modes {
+		uri, exists := printers[name]
+		if !exists {
+			return nil, fmt.Errorf("Direct printer TLS for %s, which is not a direct printer", name)
+		}
+		if u, err := url.Parse(uri); err != nil || (u.Scheme != "ipps" && u.Scheme != "https") {
+			return nil, fmt.Errorf("Direct printer TLS for %s, whose URI %s is not ipps://", name, uri)
+		}
+
+		switch {
+		case mode == "" || mode == directTLSSystem:
+			continue
+
+		case strings.HasPrefix(mode, directTLSPinPrefix):
+			pin := normalizeFingerprint(strings.TrimPrefix(mode, directTLSPinPrefix))
+			if b, err := hex.DecodeString(pin); err != nil || len(b) != sha256.Size {
+				return nil, fmt.Errorf("Direct printer %s pin %q is not a SHA-256 in hex", name, mode)
+			}
+			transports[name] = newPinnedTransport(func(fingerprint string) error {
+				if fingerprint != pin {
+					return fmt.Errorf("certificate %s is not the pinned certificate", fingerprint)
+				}
+				return nil
+			})
+
+		case mode == directTLSTOFU:
+			if tofu == nil {
+				if tofuFilename == "" {
+					return nil, fmt.Errorf("Direct printer %s is trusted on first use, which requires cups_direct_tofu_file", name)
+				}
+				var err error
+				if tofu, err = newTOFUStore(tofuFilename); err != nil {
+					return nil, err
+				}
+			}
+			name := name
+			transports[name] = newPinnedTransport(func(fingerprint string) error {
+				return tofu.verify(name, fingerprint)
+			})
+
+		default:
+			return nil, fmt.Errorf("Direct printer %s TLS %q is not system, pin:<SHA-256> or tofu", name, mode)
+		}
+	}
+	return transports, nil
+}
+
+// newPinnedTransport creates a transport that accepts the certificates
+// whose SHA-256 fingerprints verify accepts, instead of validating them
+// with the system's roots. Printers usually have self-signed certificates.
+func newPinnedTransport(verify func(fingerprint string) error) *http.Transport {
+	config := lib.TLSConfig()
+	// The chain is not checked, but the leaf certificate is.
+	config.InsecureSkipVerify = true
+	config.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return errors.New("printer presented no certificate")
+		}
+		sum := sha256.Sum256(rawCerts[0])
+		return verify(hex.EncodeToString(sum[:]))
+	}
+	return &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
+		DialContext:     dialDirect,
+		TLSClientConfig: config,
+	}
+}
+
+// normalizeFingerprint formats a fingerprint like the output of openssl
+// x509 -fingerprint -sha256, AB:CD:..., as lower case hex without colons.
+func normalizeFingerprint(fingerprint string) string {
+	return strings.ToLower(strings.Replace(fingerprint, ":", "", -1))
+}
+
+// tofuStore remembers the certificates of printers that are trusted on
+// first use, in a file.
+type tofuStore struct {
+	filename     string
+	mutex        sync.Mutex
+	fingerprints map[string]string
+}
+
+func newTOFUStore(filename string) (*tofuStore, error) {
+	t := tofuStore{filename: filename, fingerprints: make(map[string]string)}
+	b, _, err := lib.ReadStateFile(filename, func(b []byte) error {
+		return json.Unmarshal(b, &map[string]string{})
+	})
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &t, nil
+		}
+		return nil, err
+	}
+	if err = json.Unmarshal(b, &t.fingerprints); err != nil {
+		return nil, fmt.Errorf("Failed to read trusted printer certificates from %s: %s", filename, err)
+	}
+	return &t, nil
+}
+
+// verify accepts the fingerprint of a printer's certificate when it is the
+// one that was trusted first, or when there is none yet.
+func (t *tofuStore) verify(printer, fingerprint string) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if trusted, exists := t.fingerprints[printer]; exists {
+		if trusted != fingerprint {
+			return fmt.Errorf("certificate %s is not the certificate %s that was trusted on first use; remove it from %s to trust the new one",
+				fingerprint, trusted, t.filename)
+		}
+		return nil
+	}
+
+	t.fingerprints[printer] = fingerprint
+	b, err := json.MarshalIndent(t.fingerprints, "", "  ")
+	if err == nil {
+		err = lib.WriteStateFile(t.filename, b, 0600)
+	}
+	if err != nil {
+		delete(t.fingerprints, printer)
+		return fmt.Errorf("failed to remember certificate %s: %s", fingerprint, err)
+	}
+	log.WarningPrinterf(printer, "Trusting certificate %s on first use", fingerprint)
+	return nil
+}
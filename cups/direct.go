@@ -0,0 +1,505 @@
+// Copyright 2015 Google Inc. All rights reserved.
+
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+// +build linux darwin freebsd
+
+package cups
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/cloud-print-connector/cdd"
+	"github.com/google/cloud-print-connector/lib"
+)
+
+const (
+	// Attributes of IPP Everywhere printers that CUPS queues describe with PPDs.
+	attrIdentifyActions      = "identify-actions"
+	attrJobID                = "job-id"
+	attrMedia                = "media"
+	attrMediaDefault         = "media-default"
+	attrMediaSupported       = "media-supported"
+	attrPrintScaling         = "print-scaling"
+	attrRequestedAttributes  = "requested-attributes"
+	attrSides                = "sides"
+	attrSidesDefault         = "sides-default"
+	attrSidesSupported       = "sides-supported"
+	attrStatusMessage        = "status-message"
+	ippDefaultPort           = "631"
+	ippContentType           = "application/ipp"
+	ippDefaultDocumentFormat = "application/octet-stream"
+)
+
+// directPrinterAttributes are requested of IPP Everywhere printers, in
+// addition to the configured printer attributes, to describe what a PPD
+// describes for a CUPS queue.
+var directPrinterAttributes = []string{
+	attrMediaDefault,
+	attrMediaSupported,
+	attrPrinterMakeAndModel,
+	attrPrinterResolutionDefault,
+	attrPrinterResolutionSupported,
+	attrSidesDefault,
+	attrSidesSupported,
+}
+
+// directJobAttributeTags are the job options that IPP Everywhere printers
+// understand, with their IPP value tags. Other options are for CUPS filters
+// and PPDs, so they are not sent to printers directly.
+var directJobAttributeTags = map[string]byte{
+	attrCopies:               ippTagInteger,
+	attrJobPriority:          ippTagInteger,
+	attrMedia:                ippTagKeyword,
+	attrNumberUp:             ippTagInteger,
+	attrOrientationRequested: ippTagEnum,
+	attrPrintColorMode:       ippTagKeyword,
+	attrPrinterResolution:    ippTagResolution,
+	attrPrintScaling:         ippTagKeyword,
+	attrSides:                ippTagKeyword,
+}
+
+// directSides maps the sides keywords of IPP printers to CDD duplex types.
+var directSides = map[string]cdd.DuplexType{
+	"one-sided":            cdd.DuplexNoDuplex,
+	"two-sided-long-edge":  cdd.DuplexLongEdge,
+	"two-sided-short-edge": cdd.DuplexShortEdge,
+}
+
+// directTransport is the default transport of direct printers, which
+// dials addresses of the configured address family.
+var directTransport = &http.Transport{
+	Proxy:       http.ProxyFromEnvironment,
+	DialContext: dialDirect,
+}
+
+// directTransportTLS limits the TLS of directTransport by the TLS policy,
+// which is set at startup, before its first request.
+var directTransportTLS sync.Once
+
+// dialDirect dials direct printers.
+func dialDirect(ctx context.Context, network, address string) (net.Conn, error) {
+	return lib.DialContext(ctx, &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}, network, address)
+}
+
+// directClient talks IPP to driverless printers, without CUPS.
+type directClient struct {
+	// printers maps printer names to printer URIs, like
+	// ipp://printer.example.com/ipp/print.
+	printers map[string]string
+	// transports are those of the ipps:// printers whose certificates are
+	// pinned or trusted on first use, by name; others use the default.
+	transports map[string]http.RoundTripper
+	timeouts   RequestTimeouts
+	requestID  uint32
+
+	// ippUSB enables printers found by USB enumeration.
+	ippUSB bool
+	// usbPrinters are the IPP-over-USB printers found by the last
+	// enumeration, by name.
+	usbPrinters map[string]*ippUSBPrinter
+	usbMutex    sync.RWMutex
+}
+
+// newDirectClient creates a directClient for printers, by name, and for
+// IPP-over-USB printers when ippUSB is true. The certificates of ipps://
+// printers are validated as tlsModes says, by name; see
+// newDirectTransports.
+// Returns nil when there are no printers.
+func newDirectClient(printers, tlsModes map[string]string, tofuFilename string, ippUSB bool, timeouts RequestTimeouts) (*directClient, error) {
+	if len(printers) == 0 && !ippUSB {
+		return nil, nil
+	}
+	for name, uri := range printers {
+		if _, err := ippHTTPURL(uri); err != nil {
+			return nil, fmt.Errorf("Direct printer %s: %s", name, err)
+		}
+	}
+	transports, err := newDirectTransports(printers, tlsModes, tofuFilename)
+	if err != nil {
+		return nil, err
+	}
+	return &directClient{
+		printers:    printers,
+		transports:  transports,
+		timeouts:    timeouts,
+		ippUSB:      ippUSB,
+		usbPrinters: make(map[string]*ippUSBPrinter),
+	}, nil
+}
+
+// isDirect returns true when name is a direct printer. Safe to call on nil.
+func (dc *directClient) isDirect(name string) bool {
+	if dc == nil {
+		return false
+	}
+	_, _, exists := dc.lookup(name)
+	return exists
+}
+
+// lookup gets the printer URI of a direct printer, and the transport that
+// reaches it; nil is the default transport.
+func (dc *directClient) lookup(name string) (string, http.RoundTripper, bool) {
+	if uri, exists := dc.printers[name]; exists {
+		return uri, dc.transports[name], true
+	}
+
+	dc.usbMutex.RLock()
+	defer dc.usbMutex.RUnlock()
+
+	if p, exists := dc.usbPrinters[name]; exists {
+		return ippUSBPrinterURI, p.transport, true
+	}
+	return "", nil, false
+}
+
+// names gets the names of the direct printers, configured and found on USB.
+func (dc *directClient) names() []string {
+	names := make([]string, 0, len(dc.printers))
+	for name := range dc.printers {
+		names = append(names, name)
+	}
+
+	dc.usbMutex.RLock()
+	defer dc.usbMutex.RUnlock()
+
+	for name := range dc.usbPrinters {
+		if _, exists := dc.printers[name]; !exists {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// refreshUSB enumerates IPP-over-USB printers, adding new ones and
+// forgetting unplugged ones. Printers that are still plugged in keep their
+// connections.
+func (dc *directClient) refreshUSB() error {
+	if !dc.ippUSB {
+		return nil
+	}
+	devices, err := findIPPUSBDevices()
+	if err != nil {
+		return fmt.Errorf("Failed to find IPP-over-USB printers: %s", err)
+	}
+
+	dc.usbMutex.Lock()
+	defer dc.usbMutex.Unlock()
+
+	usbPrinters := make(map[string]*ippUSBPrinter, len(devices))
+	for _, d := range devices {
+		name := d.name()
+		if p, exists := dc.usbPrinters[name]; exists && p.device.busnum == d.busnum && p.device.devnum == d.devnum {
+			usbPrinters[name] = p
+		} else {
+			usbPrinters[name] = newIPPUSBPrinter(d)
+		}
+	}
+	dc.usbPrinters = usbPrinters
+	return nil
+}
+
+// ippHTTPURL gets the HTTP URL of an IPP printer URI.
+func ippHTTPURL(uri string) (string, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", err
+	}
+	switch u.Scheme {
+	case "ipp", "http":
+		u.Scheme = "http"
+	case "ipps", "https":
+		u.Scheme = "https"
+	default:
+		return "", fmt.Errorf("printer URI %s is not ipp:// or ipps://", uri)
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("printer URI %s has no host", uri)
+	}
+	if _, _, err := net.SplitHostPort(u.Host); err != nil {
+		u.Host = net.JoinHostPort(strings.Trim(u.Host, "[]"), ippDefaultPort)
+	}
+	return u.String(), nil
+}
+
+// doRequest sends an IPP request, followed by document when not nil, to
+// printerURI, the URI of printerName. The exchange is traced as one of
+// printerName and jobID, the GCP or Privet job ID, which may be empty.
+func (dc *directClient) doRequest(printerName, jobID, printerURI string, transport http.RoundTripper, request *ippRequest, document io.Reader, timeout time.Duration) (*ippResponse, error) {
+	start := time.Now()
+	response, err := dc.exchange(printerURI, transport, request, document, timeout)
+	if lib.Tracing(printerName, jobID) {
+		record := lib.TraceRecord{
+			Time:     start,
+			Protocol: lib.TraceProtocolIPP,
+			Printer:  printerName,
+			JobID:    jobID,
+			Request:  printerURI + "\n" + request.dump(),
+		}
+		if response != nil {
+			record.Response = response.dump()
+		}
+		if err != nil {
+			record.Error = err.Error()
+		}
+		lib.Trace(record)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if response.status > ippStatusSuccessfulMaximum {
+		message := ""
+		if m := response.group(ippTagOperation)[attrStatusMessage]; len(m) > 0 {
+			message = ": " + m[0]
+		}
+		return nil, &ippStatusError{response.status,
+			fmt.Sprintf("Printer %s failed IPP request with status 0x%04x%s", printerURI, response.status, message)}
+	}
+	return response, nil
+}
+
+// exchange sends an IPP request, and decodes the response, whatever its
+// status.
+func (dc *directClient) exchange(printerURI string, transport http.RoundTripper, request *ippRequest, document io.Reader, timeout time.Duration) (*ippResponse, error) {
+	u, err := ippHTTPURL(printerURI)
+	if err != nil {
+		return nil, err
+	}
+	b, err := request.encode()
+	if err != nil {
+		return nil, err
+	}
+	var body io.Reader = bytes.NewReader(b)
+	if document != nil {
+		body = io.MultiReader(body, document)
+	}
+
+	if transport == nil {
+		directTransportTLS.Do(func() { directTransport.TLSClientConfig = lib.TLSConfig() })
+		transport = directTransport
+	}
+	client := http.Client{Transport: &lib.UserAgentTransport{Base: transport}, Timeout: timeout}
+	r, err := client.Post(u, ippContentType, body)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Body.Close()
+	if r.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Printer %s answered %s", printerURI, r.Status)
+	}
+
+	return decodeIPPResponse(r.Body)
+}
+
+func (dc *directClient) newRequest(operation uint16, printerURI string) *ippRequest {
+	return newIPPRequest(operation, atomic.AddUint32(&dc.requestID, 1), printerURI)
+}
+
+// getPrinterAttributes gets the attributes of one printer, like those of a
+// CUPS queue, with printer-name set to the printer's configured name.
+func (dc *directClient) getPrinterAttributes(name string, attributes []string) (map[string][]string, error) {
+	uri, transport, _ := dc.lookup(name)
+	request := dc.newRequest(ippOpGetPrinterAttributes, uri)
+	request.operationAttributes = append(request.operationAttributes,
+		ippAttribute{ippTagKeyword, attrRequestedAttributes, attributes})
+
+	response, err := dc.doRequest(name, "", uri, transport, request, nil, dc.timeouts.GetPrinters)
+	if err != nil {
+		return nil, err
+	}
+	attrs := response.group(ippTagPrinter)
+	if attrs == nil {
+		return nil, fmt.Errorf("Printer %s returned no printer attributes", uri)
+	}
+	attrs[attrPrinterName] = []string{name}
+	return attrs, nil
+}
+
+// printFile prints a file on a direct printer. Options that IPP Everywhere
+// printers don't understand are dropped. Returns the printer's job ID.
+func (dc *directClient) printFile(user, printername, filename, title, gcpJobID string, options map[string]string) (uint32, error) {
+	uri, transport, _ := dc.lookup(printername)
+	f, err := os.Open(filename)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	format := options[attrDocumentFormat]
+	if format == "" {
+		format = ippDefaultDocumentFormat
+	}
+	request := dc.newRequest(ippOpPrintJob, uri)
+	request.operationAttributes = append(request.operationAttributes,
+		ippAttribute{ippTagName, "requesting-user-name", []string{user}},
+		ippAttribute{ippTagName, "job-name", []string{title}},
+		ippAttribute{ippTagMimeType, attrDocumentFormat, []string{format}})
+	jobOptions := directJobOptions(options)
+	names := make([]string, 0, len(jobOptions))
+	for name := range jobOptions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		request.jobAttributes = append(request.jobAttributes,
+			ippAttribute{directJobAttributeTags[name], name, []string{jobOptions[name]}})
+	}
+
+	response, err := dc.doRequest(printername, gcpJobID, uri, transport, request, f, dc.timeouts.PrintFile)
+	if err != nil {
+		return 0, err
+	}
+	id := response.group(ippTagJob)[attrJobID]
+	if len(id) == 0 {
+		return 0, fmt.Errorf("Printer %s returned no job-id", uri)
+	}
+	jobID, err := strconv.ParseUint(id[0], 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("Printer %s returned bad job-id %q", uri, id[0])
+	}
+	return uint32(jobID), nil
+}
+
+// directJobOptions gets the options that IPP Everywhere printers understand,
+// with the PPD PageSize option as media and fit-to-page as print-scaling.
+func directJobOptions(options map[string]string) map[string]string {
+	result := make(map[string]string, len(options))
+	for k, v := range options {
+		if k == ppdPageSize {
+			k = attrMedia
+		}
+		if _, exists := directJobAttributeTags[k]; exists {
+			result[k] = v
+		}
+	}
+	if _, exists := result[attrPrintScaling]; !exists && options[attrFitToPage] == attrTrue {
+		result[attrPrintScaling] = "fit"
+	}
+	return result
+}
+
+// getJobAttributes gets attributes of one job of a direct printer.
+func (dc *directClient) getJobAttributes(printername string, jobID uint32, attributes []string) (map[string][]string, error) {
+	uri, transport, _ := dc.lookup(printername)
+	request := dc.newRequest(ippOpGetJobAttributes, uri)
+	request.operationAttributes = append(request.operationAttributes,
+		ippAttribute{ippTagInteger, attrJobID, []string{strconv.FormatUint(uint64(jobID), 10)}},
+		ippAttribute{ippTagKeyword, attrRequestedAttributes, attributes})
+
+	response, err := dc.doRequest(printername, "", uri, transport, request, nil, dc.timeouts.GetJobAttributes)
+	if err != nil {
+		return nil, err
+	}
+	return response.group(ippTagJob), nil
+}
+
+// cancelJob cancels one job of a direct printer.
+func (dc *directClient) cancelJob(printername string, jobID uint32) error {
+	uri, transport, _ := dc.lookup(printername)
+	request := dc.newRequest(ippOpCancelJob, uri)
+	request.operationAttributes = append(request.operationAttributes,
+		ippAttribute{ippTagInteger, attrJobID, []string{strconv.FormatUint(uint64(jobID), 10)}})
+
+	_, err := dc.doRequest(printername, "", uri, transport, request, nil, dc.timeouts.GetJobAttributes)
+	return err
+}
+
+// identify asks the printer at uri, the device of printerName, to show
+// where it is by actions, like flash and sound, or by its default actions
+// when actions is empty.
+func (dc *directClient) identify(printerName, uri string, transport http.RoundTripper, actions []string) error {
+	request := dc.newRequest(ippOpIdentifyPrinter, uri)
+	request.operationAttributes = append(request.operationAttributes,
+		ippAttribute{ippTagName, "requesting-user-name", []string{lib.ShortName}})
+	if len(actions) > 0 {
+		request.operationAttributes = append(request.operationAttributes,
+			ippAttribute{ippTagKeyword, attrIdentifyActions, actions})
+	}
+
+	_, err := dc.doRequest(printerName, "", uri, transport, request, nil, dc.timeouts.GetJobAttributes)
+	return err
+}
+
+// addIPPEverywhereDescription describes, from the attributes of an IPP
+// Everywhere printer, what a PPD describes for a CUPS queue.
+func addIPPEverywhereDescription(p *lib.Printer, attributes map[string][]string) {
+	if m := attributes[attrPrinterMakeAndModel]; len(m) > 0 {
+		p.Model = m[0]
+		if parts := strings.SplitN(m[0], " ", 2); len(parts) == 2 {
+			p.Manufacturer = parts[0]
+		}
+	}
+	p.Description.MediaSize = convertMediaSupported(attributes)
+
+	var duplex cdd.Duplex
+	duplexMap := lib.DuplexVendorMap{}
+	def := firstValue(attributes[attrSidesDefault])
+	for _, s := range attributes[attrSidesSupported] {
+		if t, exists := directSides[s]; exists {
+			duplex.Option = append(duplex.Option, cdd.DuplexOption{Type: t, IsDefault: s == def})
+			duplexMap[t] = attrSides + internalKeySeparator + s
+		}
+	}
+	if len(duplex.Option) > 1 {
+		p.Description.Duplex = &duplex
+		p.DuplexMap = duplexMap
+	}
+}
+
+// convertMediaSupported converts the PWG media names of media-supported to
+// media sizes, with the PWG name as vendor ID.
+func convertMediaSupported(attributes map[string][]string) *cdd.MediaSize {
+	var ms cdd.MediaSize
+	def := firstValue(attributes[attrMediaDefault])
+	for _, m := range attributes[attrMediaSupported] {
+		if strings.HasPrefix(m, "custom_") {
+			// Bounds of custom sizes, not sizes.
+			continue
+		}
+		width, height, ok := pwgMediaNameDimensions(m)
+		if !ok {
+			continue
+		}
+		o := cdd.MediaSizeOption{
+			Name:          cdd.MediaSizeCustom,
+			WidthMicrons:  width,
+			HeightMicrons: height,
+			IsDefault:     m == def,
+			VendorID:      m,
+		}
+		if s, exists := pwgMediaSizesByName[m]; exists {
+			o.Name = s.name
+		} else {
+			o.CustomDisplayName = m
+		}
+		ms.Option = append(ms.Option, o)
+	}
+	if len(ms.Option) == 0 {
+		return nil
+	}
+	return &ms
+}
+
+func firstValue(values []string) string {
+	if len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
@@ -0,0 +1,403 @@
+// Copyright 2015 Google Inc. All rights reserved.
+
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+// +build linux darwin freebsd
+
+package cups
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/cloud-print-connector/cdd"
+	"github.com/google/cloud-print-connector/lib"
+)
+
+// encodeIPPTestResponse encodes a response with one group of attributes.
+func encodeIPPTestResponse(t *testing.T, requestID uint32, tag byte, attributes []ippAttribute) []byte {
+	var b bytes.Buffer
+	b.Write([]byte{ippVersionMajor, ippVersionMinor, 0, 0})
+	binary.Write(&b, binary.BigEndian, requestID)
+	b.WriteByte(tag)
+	for _, a := range attributes {
+		for i, v := range a.values {
+			value, err := encodeIPPValue(a.tag, v)
+			if err != nil {
+				t.Fatal(err)
+			}
+			name := a.name
+			if i > 0 {
+				name = ""
+			}
+			b.WriteByte(a.tag)
+			binary.Write(&b, binary.BigEndian, uint16(len(name)))
+			b.WriteString(name)
+			binary.Write(&b, binary.BigEndian, uint16(len(value)))
+			b.Write(value)
+		}
+	}
+	b.WriteByte(ippTagEnd)
+	return b.Bytes()
+}
+
+func TestIPPRequestRoundTrip(t *testing.T) {
+	request := newIPPRequest(ippOpPrintJob, 42, "ipp://printer/ipp/print")
+	request.jobAttributes = []ippAttribute{
+		{ippTagInteger, attrCopies, []string{"2"}},
+		{ippTagEnum, attrOrientationRequested, []string{"4"}},
+		{ippTagBoolean, "collate", []string{attrTrue}},
+		{ippTagResolution, attrPrinterResolution, []string{"600x300ppi"}},
+		{ippTagKeyword, attrSidesSupported, []string{"one-sided", "two-sided-long-edge"}},
+	}
+	b, err := request.encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A request has the same layout as a response, with the operation in
+	// place of the status.
+	decoded, err := decodeIPPResponse(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.status != ippOpPrintJob || decoded.requestID != 42 {
+		t.Logf("expected operation 0x0002 request 42, got 0x%04x request %d", decoded.status, decoded.requestID)
+		t.Fail()
+	}
+	if uri := decoded.group(ippTagOperation)["printer-uri"]; !reflect.DeepEqual(uri, []string{"ipp://printer/ipp/print"}) {
+		t.Logf("expected printer-uri in the operation group, got %v", uri)
+		t.Fail()
+	}
+	expected := map[string][]string{
+		attrCopies:               []string{"2"},
+		attrOrientationRequested: []string{"4"},
+		"collate":                []string{attrTrue},
+		attrPrinterResolution:    []string{"600x300ppi"},
+		attrSidesSupported:       []string{"one-sided", "two-sided-long-edge"},
+	}
+	if job := decoded.group(ippTagJob); !reflect.DeepEqual(job, expected) {
+		t.Logf("expected job attributes %v, got %v", expected, job)
+		t.Fail()
+	}
+}
+
+func TestDecodeIPPSkipsCollections(t *testing.T) {
+	b := encodeIPPTestResponse(t, 1, ippTagPrinter, []ippAttribute{
+		{ippTagBeginCollection, "media-col-default", []string{""}},
+		{ippTagMemberName, "", []string{"media-size"}},
+		{ippTagBeginCollection, "", []string{""}},
+		{ippTagEndCollection, "", []string{""}},
+		{ippTagEndCollection, "", []string{""}},
+		{ippTagKeyword, attrMediaDefault, []string{"iso_a4_210x297mm"}},
+	})
+	response, err := decodeIPPResponse(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	attrs := response.group(ippTagPrinter)
+	if m := attrs[attrMediaDefault]; !reflect.DeepEqual(m, []string{"iso_a4_210x297mm"}) {
+		t.Logf("expected media-default after a collection, got %v", attrs)
+		t.Fail()
+	}
+	if _, exists := attrs["media-size"]; exists {
+		t.Logf("expected collection members to be skipped, got %v", attrs)
+		t.Fail()
+	}
+}
+
+func TestIPPHTTPURL(t *testing.T) {
+	testCases := map[string]string{
+		"ipp://printer/ipp/print":       "http://printer:631/ipp/print",
+		"ipps://printer:443/ipp/print":  "https://printer:443/ipp/print",
+		"ipp://[fe80::1]/ipp/print":     "http://[fe80::1]:631/ipp/print",
+		"http://printer:8631/ipp/print": "http://printer:8631/ipp/print",
+	}
+	for uri, expected := range testCases {
+		if u, err := ippHTTPURL(uri); err != nil || u != expected {
+			t.Logf("expected %s to be %s, got %s %v", uri, expected, u, err)
+			t.Fail()
+		}
+	}
+	if _, err := ippHTTPURL("lpd://printer/queue"); err == nil {
+		t.Log("expected an error for an lpd:// URI")
+		t.Fail()
+	}
+}
+
+// fakeIPPPrinter serves IPP like an IPP Everywhere printer.
+type fakeIPPPrinter struct {
+	t *testing.T
+	// jobAttributes and document record the last Print-Job request.
+	jobAttributes map[string][]string
+	document      string
+	// identified counts Identify-Printer requests, and identifyActions
+	// records the actions of the last one.
+	identified      int
+	identifyActions []string
+}
+
+func (f *fakeIPPPrinter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	request, err := decodeIPPResponse(r.Body)
+	if err != nil {
+		f.t.Errorf("Failed to decode IPP request: %s", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", ippContentType)
+	switch request.status {
+	case ippOpGetPrinterAttributes:
+		w.Write(encodeIPPTestResponse(f.t, request.requestID, ippTagPrinter, []ippAttribute{
+			{ippTagName, attrPrinterName, []string{"ipp-name"}},
+			{ippTagEnum, attrPrinterState, []string{"3"}},
+			{ippTagURI, attrPrinterUUID, []string{"urn:uuid:office"}},
+			{ippTagText, attrPrinterMakeAndModel, []string{"Acme Everywhere 9"}},
+			{ippTagKeyword, attrMediaSupported, []string{"na_letter_8.5x11in", "iso_a4_210x297mm", "custom_min_3x5in"}},
+			{ippTagKeyword, attrMediaDefault, []string{"iso_a4_210x297mm"}},
+			{ippTagKeyword, attrSidesSupported, []string{"one-sided", "two-sided-long-edge"}},
+			{ippTagKeyword, attrSidesDefault, []string{"one-sided"}},
+		}))
+	case ippOpPrintJob:
+		f.jobAttributes = request.group(ippTagJob)
+		document, _ := ioutil.ReadAll(r.Body)
+		f.document = string(document)
+		w.Write(encodeIPPTestResponse(f.t, request.requestID, ippTagJob, []ippAttribute{
+			{ippTagInteger, attrJobID, []string{"7"}},
+		}))
+	case ippOpGetJobAttributes:
+		w.Write(encodeIPPTestResponse(f.t, request.requestID, ippTagJob, []ippAttribute{
+			{ippTagEnum, attrJobState, []string{"9"}},
+		}))
+	case ippOpIdentifyPrinter:
+		f.identified++
+		f.identifyActions = request.group(ippTagOperation)[attrIdentifyActions]
+		w.Write(encodeIPPTestResponse(f.t, request.requestID, ippTagOperation, nil))
+	default:
+		f.t.Errorf("Unexpected IPP operation 0x%04x", request.status)
+	}
+}
+
+func TestDirectPrinter(t *testing.T) {
+	ipp := &fakeIPPPrinter{t: t}
+	server := httptest.NewServer(ipp)
+	defer server.Close()
+
+	f := newFakeCUPSClient()
+	f.addPrinter("office", nil, fakePPD)
+	f.addPrinter("other", nil, fakePPD)
+	c := newTestCUPS(f, 0)
+	uri := "ipp://" + strings.TrimPrefix(server.URL, "http://") + "/ipp/print"
+	c.direct, _ = newDirectClient(map[string]string{"office": uri}, nil, "", false, RequestTimeouts{})
+
+	printers, err := c.GetPrinters()
+	if err != nil {
+		t.Fatalf("GetPrinters failed: %s", err)
+	}
+	if names := printerNames(printers); !reflect.DeepEqual(names, []string{"office", "other"}) {
+		t.Fatalf("expected printers office and other, got %v", names)
+	}
+	var printer lib.Printer
+	for _, p := range printers {
+		if p.Name == "office" {
+			printer = p
+		}
+	}
+	if printer.UUID != "office" || printer.Model != "Acme Everywhere 9" || printer.Manufacturer != "Acme" {
+		t.Logf("expected the direct printer's own UUID and model, got %+v", printer)
+		t.Fail()
+	}
+	if ms := printer.Description.MediaSize; ms == nil || len(ms.Option) != 2 ||
+		ms.Option[1].Name != cdd.MediaSizeISOA4 || !ms.Option[1].IsDefault {
+		t.Logf("expected letter and default A4 media sizes, got %+v", ms)
+		t.Fail()
+	}
+	if printer.DuplexMap[cdd.DuplexLongEdge] != "sides:two-sided-long-edge" {
+		t.Logf("expected long edge duplex to be sides:two-sided-long-edge, got %v", printer.DuplexMap)
+		t.Fail()
+	}
+
+	document, err := ioutil.TempFile("", "direct-job")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(document.Name())
+	document.WriteString("%PDF-1.4 job")
+	document.Close()
+
+	printer.NativeJobSemaphore = lib.NewSemaphore(1)
+	ticket := &cdd.CloudJobTicket{
+		Print: cdd.PrintTicketSection{
+			Duplex:    &cdd.DuplexTicketItem{Type: cdd.DuplexLongEdge},
+			MediaSize: &cdd.MediaSizeTicketItem{VendorID: "iso_a4_210x297mm"},
+			FitToPage: &cdd.FitToPageTicketItem{Type: cdd.FitToPageFitToPage},
+		},
+	}
+	jobID, err := c.Print(&printer, document.Name(), "title", "user@example.com", "gcp-123", ticket)
+	if err != nil {
+		t.Fatalf("Print failed: %s", err)
+	}
+	if jobID != 7 {
+		t.Logf("expected the printer's job ID 7, got %d", jobID)
+		t.Fail()
+	}
+	if len(f.printed) != 0 {
+		t.Logf("expected no job to go through CUPS, got %+v", f.printed)
+		t.Fail()
+	}
+	expected := map[string][]string{
+		attrSides:        []string{"two-sided-long-edge"},
+		attrMedia:        []string{"iso_a4_210x297mm"},
+		attrPrintScaling: []string{"fit"},
+	}
+	if !reflect.DeepEqual(ipp.jobAttributes, expected) {
+		t.Logf("expected job attributes %v, got %v", expected, ipp.jobAttributes)
+		t.Fail()
+	}
+	if ipp.document != "%PDF-1.4 job" {
+		t.Logf("expected the document after the request, got %q", ipp.document)
+		t.Fail()
+	}
+
+	state, err := c.GetJobState("office", jobID)
+	if err != nil {
+		t.Fatalf("GetJobState failed: %s", err)
+	}
+	if state.State == nil || state.State.Type != cdd.JobStateDone {
+		t.Logf("expected job state DONE, got %+v", state.State)
+		t.Fail()
+	}
+}
+
+func TestIdentifyPrinter(t *testing.T) {
+	ipp := &fakeIPPPrinter{t: t}
+	server := httptest.NewServer(ipp)
+	defer server.Close()
+	uri := "ipp://" + strings.TrimPrefix(server.URL, "http://") + "/ipp/print"
+
+	f := newFakeCUPSClient()
+	f.addPrinter("queue", map[string][]string{attrDeviceURI: []string{uri}}, fakePPD)
+	f.addPrinter("usb", map[string][]string{attrDeviceURI: []string{"usb://Acme/LaserWriter"}}, fakePPD)
+	c := newTestCUPS(f, 0)
+	c.printerCache = newPrinterCache(time.Minute)
+	c.devices = &directClient{}
+	c.direct, _ = newDirectClient(map[string]string{"office": uri}, nil, "", false, RequestTimeouts{})
+
+	if err := c.IdentifyPrinter("office", []string{"flash", "sound"}); err != nil {
+		t.Fatalf("Failed to identify direct printer: %s", err)
+	}
+	if ipp.identified != 1 || !reflect.DeepEqual(ipp.identifyActions, []string{"flash", "sound"}) {
+		t.Logf("expected one identify request to flash and sound, got %d %v", ipp.identified, ipp.identifyActions)
+		t.Fail()
+	}
+
+	if err := c.IdentifyPrinter("queue", nil); err != nil {
+		t.Fatalf("Failed to identify the device of a CUPS queue: %s", err)
+	}
+	if ipp.identified != 2 || ipp.identifyActions != nil {
+		t.Logf("expected a second identify request without actions, got %d %v", ipp.identified, ipp.identifyActions)
+		t.Fail()
+	}
+
+	if err := c.IdentifyPrinter("usb", nil); err == nil {
+		t.Log("expected an error for a USB device")
+		t.Fail()
+	}
+	if err := c.IdentifyPrinter("missing", nil); err == nil {
+		t.Log("expected an error for a missing printer")
+		t.Fail()
+	}
+	if err := c.IdentifyPrinter("office", []string{"dance"}); err == nil {
+		t.Log("expected an error for an unknown action")
+		t.Fail()
+	}
+}
+
+func TestDirectPrinterTLS(t *testing.T) {
+	server := httptest.NewTLSServer(&fakeIPPPrinter{t: t})
+	defer server.Close()
+	uri := "ipps://" + strings.TrimPrefix(server.URL, "https://") + "/ipp/print"
+	sum := sha256.Sum256(server.TLS.Certificates[0].Certificate[0])
+	fingerprint := hex.EncodeToString(sum[:])
+
+	dir, err := ioutil.TempDir("", "direct-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tofuFilename := filepath.Join(dir, "tofu.json")
+	ioutil.WriteFile(tofuFilename, []byte(`{"changed": "`+strings.Repeat("0", 64)+`"}`), 0600)
+
+	printers := map[string]string{"system": uri, "pinned": uri, "mispinned": uri, "tofu": uri, "changed": uri}
+	dc, err := newDirectClient(printers, map[string]string{
+		"pinned":    "pin:" + strings.ToUpper(fingerprint),
+		"mispinned": "pin:" + strings.Repeat("0", 64),
+		"tofu":      directTLSTOFU,
+		"changed":   directTLSTOFU,
+	}, tofuFilename, false, RequestTimeouts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, ok := range map[string]bool{
+		// The test server's certificate is not signed by a system root.
+		"system":    false,
+		"pinned":    true,
+		"mispinned": false,
+		"tofu":      true,
+		"changed":   false,
+	} {
+		if _, err := dc.getPrinterAttributes(name, []string{attrPrinterMakeAndModel}); (err == nil) != ok {
+			t.Logf("expected printer %s to be reachable: %v, got error %v", name, ok, err)
+			t.Fail()
+		}
+	}
+
+	b, err := ioutil.ReadFile(tofuFilename)
+	if err != nil || !strings.Contains(string(b), `"tofu": "`+fingerprint+`"`) {
+		t.Logf("expected the tofu printer's certificate to be remembered, got %s, %v", b, err)
+		t.Fail()
+	}
+
+	if _, err = newDirectClient(map[string]string{"plain": "ipp://printer/ipp/print"},
+		map[string]string{"plain": directTLSTOFU}, tofuFilename, false, RequestTimeouts{}); err == nil {
+		t.Log("expected TLS validation of an ipp:// printer to fail")
+		t.Fail()
+	}
+}
+
+func TestSubmitJobError(t *testing.T) {
+	err := prefixError("Failed to create a job: ", &ippStatusError{ippStatusNotAcceptingJobs, "IPP status code 1286: not accepting jobs"})
+	jobErr := submitJobError(err)
+	if jobErr.Kind != lib.JobErrorPrinterUnavailable || jobErr.IPPStatus != 0x0506 || jobErr.Stage != lib.JobErrorStageSubmit ||
+		jobErr.Error() != "Failed to create a job: IPP status code 1286: not accepting jobs" {
+		t.Logf("unexpected job error %+v: %s", jobErr, jobErr)
+		t.Fail()
+	}
+
+	jobErr = submitJobError(prefixError("Failed to create a job: ", &ippStatusError{ippStatusDocumentFormatNotSupported, "bad format"}))
+	if jobErr.Kind != lib.JobErrorDocumentUnsupported {
+		t.Logf("expected document-unsupported, got %s", jobErr.Kind)
+		t.Fail()
+	}
+
+	jobErr = submitJobError(prefixError("Failed to create a job: ", errors.New("connection refused")))
+	if jobErr.Kind != lib.JobErrorPrintFailed || jobErr.IPPStatus != 0 {
+		t.Logf("expected print-failed without an IPP status, got %+v", jobErr)
+		t.Fail()
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright 2015 Google Inc. All rights reserved.
+
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+// +build linux darwin freebsd
+
+package cups
+
+import (
+	"errors"
+	"os"
+	"time"
+
+	"github.com/google/cloud-print-connector/lib"
+	"github.com/google/cloud-print-connector/log"
+)
+
+const (
+	// discoveryServiceType is the DNS-SD service type of CUPS queues.
+	discoveryServiceType = "_ipp._tcp"
+	// discoveryCUPSKey is a TXT record key that only CUPS servers advertise,
+	// which tells them apart from IPP printers.
+	discoveryCUPSKey = "printer-type"
+	// discoveryTimeout is how long to wait for CUPS servers to answer.
+	discoveryTimeout = 3 * time.Second
+)
+
+// DiscoverServer finds the CUPS servers that advertise queues via DNS-SD,
+// and points the CUPS client library at the first that
+// satisfies rules, by the order of the rules. With no rules, any CUPS
+// server will do. Returns the server's host:port.
+//
+// Call DiscoverServer before NewCUPS.
+func DiscoverServer(rules []lib.DNSSDRule) (string, error) {
+	services, err := lib.BrowseDNSSD(discoveryServiceType, discoveryTimeout)
+	if err != nil {
+		return "", err
+	}
+
+	server, found := chooseServer(services, rules)
+	if !found {
+		return "", errors.New("Found no CUPS server via DNS-SD that satisfies the discovery rules")
+	}
+	log.Infof("Discovered CUPS server %s, which advertises %s", server.Address(), server.Name)
+
+	// The CUPS client library reads CUPS_SERVER when each thread first
+	// connects.
+	if err = os.Setenv("CUPS_SERVER", server.Address()); err != nil {
+		return "", err
+	}
+	return server.Address(), nil
+}
+
+// chooseServer picks the first CUPS queue that satisfies rules, by the
+// order of the rules.
+func chooseServer(services []lib.DNSSDService, rules []lib.DNSSDRule) (lib.DNSSDService, bool) {
+	if len(rules) == 0 {
+		rules = []lib.DNSSDRule{{}}
+	}
+
+	for i := range rules {
+		for j := range services {
+			if _, exists := services[j].TXT[discoveryCUPSKey]; !exists {
+				continue
+			}
+			if rules[i].Matches(&services[j]) {
+				return services[j], true
+			}
+		}
+	}
+	return lib.DNSSDService{}, false
+}
@@ -0,0 +1,39 @@
+// Copyright 2015 Google Inc. All rights reserved.
+
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+// +build linux darwin freebsd
+
+package cups
+
+import (
+	"testing"
+
+	"github.com/google/cloud-print-connector/lib"
+)
+
+func TestChooseServer(t *testing.T) {
+	services := []lib.DNSSDService{
+		{Name: "Lab Inkjet", Host: "lab.local", Port: 631, TXT: map[string]string{"printer-type": "0x1046"}},
+		{Name: "Office IPP", Host: "printer.local", Port: 631, TXT: map[string]string{}},
+		{Name: "Office Laser", Host: "office.local", Port: 631, TXT: map[string]string{"printer-type": "0x1046"}},
+	}
+
+	if s, found := chooseServer(services, nil); !found || s.Host != "lab.local" {
+		t.Logf("expected any CUPS server to do without rules, got %+v %v", s, found)
+		t.Fail()
+	}
+
+	rules := []lib.DNSSDRule{{ServiceName: "Office *"}, {ServiceName: "Lab *"}}
+	if s, found := chooseServer(services, rules); !found || s.Host != "office.local" {
+		t.Logf("expected the CUPS server of the first rule, not the IPP printer, got %+v %v", s, found)
+		t.Fail()
+	}
+
+	if _, found := chooseServer(services, []lib.DNSSDRule{{Host: "*.example.com"}}); found {
+		t.Log("expected no CUPS server to satisfy the rules")
+		t.Fail()
+	}
+}
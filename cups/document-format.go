@@ -0,0 +1,201 @@
+// Copyright 2015 Google Inc. All rights reserved.
+
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+// +build linux darwin freebsd
+
+package cups
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/google/cloud-print-connector/cdd"
+	"github.com/google/cloud-print-connector/lib"
+	"github.com/google/cloud-print-connector/log"
+)
+
+const (
+	mimePostScript = "application/postscript"
+	mimePWGRaster  = "image/pwg-raster"
+
+	// Rasterize at this resolution when neither the ticket nor the printer
+	// has one.
+	defaultRasterDPI = 300
+
+	// Ghostscript's cupsColorSpace values for 8-bit gray and sRGB.
+	ghostscriptColorSpaceGray = 18
+	ghostscriptColorSpaceRGB  = 19
+)
+
+// formatPreferences are the document formats that the jobs of some printers
+// come from GCP in, and are sent to CUPS in, for queues whose PDF
+// interpreters are flaky.
+type formatPreferences struct {
+	// fetch is the format that GCP converts jobs to, by printer name.
+	fetch map[string]string
+	// submit is the format that jobs are converted to before they are
+	// sent to CUPS, by printer name.
+	submit map[string]string
+}
+
+func newFormatPreferences(fetch, submit map[string]string) (formatPreferences, error) {
+	for printerName, format := range fetch {
+		if _, exists := mimeTypesAllowed[format]; !exists {
+			return formatPreferences{}, fmt.Errorf("Printer %s can't fetch documents as %s", printerName, format)
+		}
+	}
+	for printerName, format := range submit {
+		if format != mimePDF && format != mimePWGRaster {
+			return formatPreferences{}, fmt.Errorf("Printer %s can't submit documents as %s; only as %s or %s",
+				printerName, format, mimePDF, mimePWGRaster)
+		}
+	}
+	return formatPreferences{fetch, submit}, nil
+}
+
+// applyToDescriptions makes each printer that prefers a format accept only
+// that format, so that GCP converts every job to it.
+func (f formatPreferences) applyToDescriptions(printers []lib.Printer) {
+	for i := range printers {
+		format, exists := f.fetch[printers[i].Name]
+		if !exists {
+			continue
+		}
+		description := printers[i].Description
+		description.SupportedContentType = cdd.NewSupportedContentType(format)
+		if format == mimePWGRaster && description.PWGRasterConfig == nil {
+			description.PWGRasterConfig = pwgRasterConfig(description)
+		}
+	}
+}
+
+// pwgRasterConfig describes the PWG raster that GCP renders for a printer:
+// at the printer's resolutions, in color when the printer prints it.
+func pwgRasterConfig(description *cdd.PrinterDescriptionSection) *cdd.PWGRasterConfig {
+	var resolutions []cdd.PWGRasterConfigResolution
+	if description.DPI != nil {
+		for _, o := range description.DPI.Option {
+			resolutions = append(resolutions, cdd.PWGRasterConfigResolution{CrossFeedDir: o.HorizontalDPI, FeedDir: o.VerticalDPI})
+		}
+	}
+	if len(resolutions) == 0 {
+		resolutions = []cdd.PWGRasterConfigResolution{{CrossFeedDir: defaultRasterDPI, FeedDir: defaultRasterDPI}}
+	}
+
+	types := []string{"SGRAY_8"}
+	if description.Color != nil {
+		for _, o := range description.Color.Option {
+			if o.Type == cdd.ColorTypeStandardColor || o.Type == cdd.ColorTypeCustomColor {
+				types = append(types, "SRGB_8")
+				break
+			}
+		}
+	}
+
+	return &cdd.PWGRasterConfig{
+		DocumentResolutionSupported: &resolutions,
+		DocumentTypeSupported:       &types,
+	}
+}
+
+// convert writes a copy of a job, in the format that its printer submits
+// jobs in, to a temporary file. PDF and PostScript convert to PWG raster,
+// and PostScript converts to PDF. The caller removes the file.
+//
+// Returns false when the job is in the format already, or can't be
+// converted; then the job prints as it is.
+func (f formatPreferences) convert(printer *lib.Printer, ticket *cdd.CloudJobTicket, filename string, options map[string]string) (string, bool) {
+	format, exists := f.submit[printer.Name]
+	if !exists {
+		return "", false
+	}
+	contentType, err := sniffContentType(filename)
+	if err != nil || contentType == format || (contentType != mimePDF && contentType != mimePostScript) {
+		return "", false
+	}
+
+	var args func(outFilename string) []string
+	if format == mimePWGRaster {
+		dpi := rasterDPI(printer, ticket)
+		colorSpace := ghostscriptColorSpaceRGB
+		if isMonochrome(printer, ticket) {
+			colorSpace = ghostscriptColorSpaceGray
+		}
+		width, height, _, sized := fitToPageArea(printer, ticket)
+		args = func(outFilename string) []string {
+			return ghostscriptRasterArgs(filename, outFilename, dpi, colorSpace, width, height, sized)
+		}
+	} else {
+		args = func(outFilename string) []string {
+			return ghostscriptJoinArgs(outFilename, []string{filename})
+		}
+	}
+
+	converted, err := runGhostscript("cloud-print-connector-format-", args)
+	if err != nil {
+		log.WarningPrinterf(printer.Name, "Failed to convert a job to %s, so printing it as %s: %s", format, contentType, err)
+		return "", false
+	}
+	options[attrDocumentFormat] = format
+	return converted, true
+}
+
+// ghostscriptRasterArgs gets the arguments that make Ghostscript render
+// inFilename as 8-bit PWG raster at dpi, in a cupsColorSpace, to
+// outFilename. When sized, the pages are scaled to fit width by height.
+func ghostscriptRasterArgs(inFilename, outFilename string, dpi int32, colorSpace int, width, height int32, sized bool) []string {
+	args := []string{
+		"-q", "-dBATCH", "-dNOPAUSE", "-dSAFER",
+		"-sDEVICE=pwgraster",
+		"-r" + strconv.Itoa(int(dpi)),
+		"-dcupsColorSpace=" + strconv.Itoa(colorSpace),
+		"-dcupsBitsPerColor=8",
+	}
+	if sized {
+		args = append(args,
+			"-dFIXEDMEDIA", "-dPDFFitPage",
+			"-dDEVICEWIDTHPOINTS="+micronsToPoints(width),
+			"-dDEVICEHEIGHTPOINTS="+micronsToPoints(height))
+	}
+	return append(args, "-sOutputFile="+outFilename, "-f", inFilename)
+}
+
+// rasterDPI finds the resolution that a job prints at: the ticket's, or
+// else the printer's default.
+func rasterDPI(printer *lib.Printer, ticket *cdd.CloudJobTicket) int32 {
+	if ticket != nil && ticket.Print.DPI != nil && ticket.Print.DPI.HorizontalDPI > 0 {
+		return ticket.Print.DPI.HorizontalDPI
+	}
+	if printer.Description != nil && printer.Description.DPI != nil {
+		for _, o := range printer.Description.DPI.Option {
+			if o.IsDefault && o.HorizontalDPI > 0 {
+				return o.HorizontalDPI
+			}
+		}
+	}
+	return defaultRasterDPI
+}
+
+// isMonochrome tells whether a job prints in monochrome: as its ticket
+// asks, or else by the printer's default color option.
+func isMonochrome(printer *lib.Printer, ticket *cdd.CloudJobTicket) bool {
+	if printer.Description == nil || printer.Description.Color == nil {
+		return true
+	}
+	var colorType cdd.ColorType
+	for _, o := range printer.Description.Color.Option {
+		if ticket != nil && ticket.Print.Color != nil {
+			if ticket.Print.Color.VendorID == o.VendorID ||
+				(ticket.Print.Color.VendorID == "" && ticket.Print.Color.Type == o.Type) {
+				colorType = o.Type
+				break
+			}
+		} else if o.IsDefault {
+			colorType = o.Type
+		}
+	}
+	return colorType != cdd.ColorTypeStandardColor && colorType != cdd.ColorTypeCustomColor
+}
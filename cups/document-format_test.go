@@ -0,0 +1,106 @@
+// Copyright 2015 Google Inc. All rights reserved.
+
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+// +build linux darwin freebsd
+
+package cups
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/google/cloud-print-connector/cdd"
+	"github.com/google/cloud-print-connector/lib"
+)
+
+func TestFormatPreferencesDescriptions(t *testing.T) {
+	if _, err := newFormatPreferences(nil, map[string]string{"alpha": "image/urf"}); err == nil {
+		t.Log("expected an error for submitting image/urf")
+		t.Fail()
+	}
+	if _, err := newFormatPreferences(map[string]string{"alpha": "application/x-perl"}, nil); err == nil {
+		t.Log("expected an error for fetching application/x-perl")
+		t.Fail()
+	}
+
+	f, err := newFormatPreferences(map[string]string{"alpha": mimePWGRaster}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	printers := []lib.Printer{
+		{Name: "alpha", Description: &cdd.PrinterDescriptionSection{
+			SupportedContentType: &[]cdd.SupportedContentType{{ContentType: mimePDF}, {ContentType: mimePostScript}},
+			Color: &cdd.Color{Option: []cdd.ColorOption{
+				{VendorID: "ColorModel:Gray", Type: cdd.ColorTypeStandardMonochrome, IsDefault: true},
+				{VendorID: "ColorModel:RGB", Type: cdd.ColorTypeStandardColor},
+			}},
+			DPI: &cdd.DPI{Option: []cdd.DPIOption{{HorizontalDPI: 600, VerticalDPI: 600, IsDefault: true}}},
+		}},
+		{Name: "beta", Description: &cdd.PrinterDescriptionSection{
+			SupportedContentType: &[]cdd.SupportedContentType{{ContentType: mimePDF}},
+		}},
+	}
+	f.applyToDescriptions(printers)
+
+	alpha := printers[0].Description
+	if !reflect.DeepEqual(*alpha.SupportedContentType, []cdd.SupportedContentType{{ContentType: mimePWGRaster}}) {
+		t.Logf("expected alpha to accept only PWG raster, got %+v", *alpha.SupportedContentType)
+		t.Fail()
+	}
+	expectedConfig := cdd.PWGRasterConfig{
+		DocumentResolutionSupported: &[]cdd.PWGRasterConfigResolution{{CrossFeedDir: 600, FeedDir: 600}},
+		DocumentTypeSupported:       &[]string{"SGRAY_8", "SRGB_8"},
+	}
+	if alpha.PWGRasterConfig == nil || !reflect.DeepEqual(*alpha.PWGRasterConfig, expectedConfig) {
+		t.Logf("expected PWG raster config %+v, got %+v", expectedConfig, alpha.PWGRasterConfig)
+		t.Fail()
+	}
+	if beta := printers[1].Description; len(*beta.SupportedContentType) != 1 || beta.PWGRasterConfig != nil {
+		t.Log("expected beta to be unchanged")
+		t.Fail()
+	}
+}
+
+func TestGhostscriptRasterArgs(t *testing.T) {
+	expected := []string{
+		"-q", "-dBATCH", "-dNOPAUSE", "-dSAFER",
+		"-sDEVICE=pwgraster", "-r300", "-dcupsColorSpace=18", "-dcupsBitsPerColor=8",
+		"-dFIXEDMEDIA", "-dPDFFitPage", "-dDEVICEWIDTHPOINTS=612", "-dDEVICEHEIGHTPOINTS=792",
+		"-sOutputFile=out.pwg", "-f", "in.pdf",
+	}
+	args := ghostscriptRasterArgs("in.pdf", "out.pwg", 300, ghostscriptColorSpaceGray, 215900, 279400, true)
+	if !reflect.DeepEqual(args, expected) {
+		t.Logf("expected %v, got %v", expected, args)
+		t.Fail()
+	}
+}
+
+func TestIsMonochrome(t *testing.T) {
+	printer := lib.Printer{Description: &cdd.PrinterDescriptionSection{
+		Color: &cdd.Color{Option: []cdd.ColorOption{
+			{VendorID: "ColorModel:Gray", Type: cdd.ColorTypeStandardMonochrome},
+			{VendorID: "ColorModel:RGB", Type: cdd.ColorTypeStandardColor, IsDefault: true},
+		}},
+	}}
+	if isMonochrome(&printer, nil) {
+		t.Log("expected the default color option to print in color")
+		t.Fail()
+	}
+	ticket := &cdd.CloudJobTicket{Print: cdd.PrintTicketSection{Color: &cdd.ColorTicketItem{VendorID: "ColorModel:Gray"}}}
+	if !isMonochrome(&printer, ticket) {
+		t.Log("expected the ticket's vendor ID to print in monochrome")
+		t.Fail()
+	}
+	ticket = &cdd.CloudJobTicket{Print: cdd.PrintTicketSection{Color: &cdd.ColorTicketItem{Type: cdd.ColorTypeStandardMonochrome}}}
+	if !isMonochrome(&printer, ticket) {
+		t.Log("expected the ticket's color type to print in monochrome")
+		t.Fail()
+	}
+	if !isMonochrome(&lib.Printer{Description: &cdd.PrinterDescriptionSection{}}, nil) {
+		t.Log("expected a printer without color options to print in monochrome")
+		t.Fail()
+	}
+}
@@ -0,0 +1,175 @@
+// Copyright 2015 Google Inc. All rights reserved.
+
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+// +build linux darwin freebsd
+
+package cups
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/google/cloud-print-connector/cdd"
+	"github.com/google/cloud-print-connector/lib"
+	"github.com/google/cloud-print-connector/log"
+)
+
+const (
+	// ghostscriptCommand rescales PDFs for drivers that ignore fit-to-page,
+	// and joins job sheets to PDFs.
+	ghostscriptCommand = "gs"
+	// Give up on rewriting one PDF after this long.
+	fitToPageTimeout = 2 * time.Minute
+)
+
+// ignoresFitToPage reports whether a printer's driver is on the list of
+// drivers that ignore the fit-to-page option. Drivers are matched by
+// case-insensitive substrings of printer-make-and-model.
+func ignoresFitToPage(printer *lib.Printer, brokenDrivers []string) bool {
+	makeAndModel, exists := printer.Tags[attrPrinterMakeAndModel]
+	if !exists {
+		makeAndModel = printer.Manufacturer + " " + printer.Model
+	}
+	makeAndModel = strings.ToLower(makeAndModel)
+
+	for _, driver := range brokenDrivers {
+		if driver != "" && strings.Contains(makeAndModel, strings.ToLower(driver)) {
+			return true
+		}
+	}
+	return false
+}
+
+// fitToPageArea finds the media size and margins that a job prints on: the
+// ticket's, or else the printer's defaults. Returns false when the media
+// size is unknown, or has no height, like a roll.
+func fitToPageArea(printer *lib.Printer, ticket *cdd.CloudJobTicket) (int32, int32, cdd.MarginsTicketItem, bool) {
+	var width, height int32
+	if ticket != nil && ticket.Print.MediaSize != nil {
+		width, height = ticket.Print.MediaSize.WidthMicrons, ticket.Print.MediaSize.HeightMicrons
+	} else if printer.Description.MediaSize != nil {
+		for _, o := range printer.Description.MediaSize.Option {
+			if o.IsDefault {
+				width, height = o.WidthMicrons, o.HeightMicrons
+			}
+		}
+	}
+	if width <= 0 || height <= 0 {
+		return 0, 0, cdd.MarginsTicketItem{}, false
+	}
+
+	var margins cdd.MarginsTicketItem
+	if ticket != nil && ticket.Print.Margins != nil {
+		margins = *ticket.Print.Margins
+	}
+	if printer.Description.Margins != nil {
+		margins = reconcileMargins(margins, printer.Description.Margins, mediaMargins(printer, ticket))
+	}
+	if margins.LeftMicrons+margins.RightMicrons >= width || margins.TopMicrons+margins.BottomMicrons >= height {
+		return 0, 0, cdd.MarginsTicketItem{}, false
+	}
+
+	return width, height, margins, true
+}
+
+// ghostscriptFitToPageArgs gets the arguments that make Ghostscript scale
+// each page of inFilename to fit inside the margins of a page of the given
+// size, and write the result to outFilename.
+func ghostscriptFitToPageArgs(inFilename, outFilename string, width, height int32, margins cdd.MarginsTicketItem) []string {
+	return []string{
+		"-q", "-dBATCH", "-dNOPAUSE", "-dSAFER",
+		"-sDEVICE=pdfwrite",
+		"-dFIXEDMEDIA", "-dPDFFitPage",
+		"-dDEVICEWIDTHPOINTS=" + micronsToPoints(width),
+		"-dDEVICEHEIGHTPOINTS=" + micronsToPoints(height),
+		"-sOutputFile=" + outFilename,
+		"-c", fmt.Sprintf("<</.HWMargins [%s %s %s %s]>> setpagedevice",
+			micronsToPoints(margins.LeftMicrons), micronsToPoints(margins.BottomMicrons),
+			micronsToPoints(margins.RightMicrons), micronsToPoints(margins.TopMicrons)),
+		"-f", inFilename,
+	}
+}
+
+// fitPDFToPage writes a copy of a PDF, scaled to fit inside the margins of
+// the given page size, to a temporary file. The caller removes the file.
+func fitPDFToPage(filename string, width, height int32, margins cdd.MarginsTicketItem) (string, error) {
+	return runGhostscript("cloud-print-connector-fit-", func(outFilename string) []string {
+		return ghostscriptFitToPageArgs(filename, outFilename, width, height, margins)
+	})
+}
+
+// runGhostscript runs Ghostscript with the arguments that args gets for a
+// temporary output file, whose name starts with prefix. Returns the name of
+// the output file, which the caller removes.
+func runGhostscript(prefix string, args func(outFilename string) []string) (string, error) {
+	out, err := ioutil.TempFile("", prefix)
+	if err != nil {
+		return "", err
+	}
+	out.Close()
+
+	var stderr bytes.Buffer
+	cmd := exec.Command(ghostscriptCommand, args(out.Name())...)
+	cmd.Stderr = &stderr
+	if err = cmd.Start(); err != nil {
+		os.Remove(out.Name())
+		return "", err
+	}
+
+	timer := time.AfterFunc(fitToPageTimeout, func() { cmd.Process.Kill() })
+	err = cmd.Wait()
+	timer.Stop()
+	if err != nil {
+		os.Remove(out.Name())
+		return "", fmt.Errorf("%s failed: %s: %s", ghostscriptCommand, err, strings.TrimSpace(stderr.String()))
+	}
+
+	return out.Name(), nil
+}
+
+// normalizeMixedOrientation writes a copy of a PDF that has both portrait
+// and landscape pages, with every page turned and scaled to the orientation
+// of most of its pages, to a temporary file, so that duplex binds every
+// sheet on the requested edge. Sets orientation-requested to match, and
+// removes fit-to-page, which is done. The caller removes the file.
+//
+// Returns false when the PDF isn't mixed, or can't be normalized; then the
+// job prints as it is.
+func normalizeMixedOrientation(printer *lib.Printer, ticket *cdd.CloudJobTicket, filename string, options map[string]string) (string, bool) {
+	orientation, mixed := detectMixedOrientation(filename)
+	if !mixed {
+		return "", false
+	}
+	width, height, margins, ok := fitToPageArea(printer, ticket)
+	if !ok {
+		return "", false
+	}
+	if orientation == cdd.PageOrientationLandscape {
+		// The paper turns a quarter counterclockwise under landscape pages.
+		width, height = height, width
+		margins = cdd.MarginsTicketItem{
+			TopMicrons:    margins.LeftMicrons,
+			RightMicrons:  margins.TopMicrons,
+			BottomMicrons: margins.RightMicrons,
+			LeftMicrons:   margins.BottomMicrons,
+		}
+	}
+
+	normalized, err := fitPDFToPage(filename, width, height, margins)
+	if err != nil {
+		log.WarningPrinterf(printer.Name, "Failed to turn the pages of a mixed-orientation job, so printing it as it is: %s", err)
+		return "", false
+	}
+	log.DebugPrinterf(printer.Name, "Turned the pages of a mixed-orientation job to %s", orientation)
+	options[attrOrientationRequested] = orientationValueByType[orientation]
+	delete(options, attrFitToPage)
+	return normalized, true
+}
@@ -0,0 +1,102 @@
+// Copyright 2015 Google Inc. All rights reserved.
+
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+// +build linux darwin freebsd
+
+package cups
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/google/cloud-print-connector/cdd"
+	"github.com/google/cloud-print-connector/lib"
+)
+
+func TestIgnoresFitToPage(t *testing.T) {
+	broken := []string{"", "acme laserwriter"}
+
+	p := lib.Printer{Tags: map[string]string{attrPrinterMakeAndModel: "Acme LaserWriter 100, 1.2"}}
+	if !ignoresFitToPage(&p, broken) {
+		t.Log("expected Acme LaserWriter to ignore fit-to-page")
+		t.Fail()
+	}
+
+	p = lib.Printer{Manufacturer: "Acme", Model: "LaserWriter 200"}
+	if !ignoresFitToPage(&p, broken) {
+		t.Log("expected Acme LaserWriter without printer-make-and-model to ignore fit-to-page")
+		t.Fail()
+	}
+
+	p = lib.Printer{Tags: map[string]string{attrPrinterMakeAndModel: "Acme ColorJet 200"}}
+	if ignoresFitToPage(&p, broken) {
+		t.Log("expected Acme ColorJet to honor fit-to-page")
+		t.Fail()
+	}
+}
+
+func TestFitToPageArea(t *testing.T) {
+	printer := lib.Printer{
+		Description: &cdd.PrinterDescriptionSection{
+			MediaSize: &cdd.MediaSize{
+				Option: []cdd.MediaSizeOption{
+					{Name: cdd.MediaSizeNALetter, WidthMicrons: 215900, HeightMicrons: 279400, IsDefault: true},
+					{Name: cdd.MediaSizeISOA4, WidthMicrons: 210000, HeightMicrons: 297000},
+				},
+			},
+			Margins: &cdd.Margins{
+				Option: []cdd.MarginsOption{
+					{Type: cdd.MarginsStandard, TopMicrons: 4000, RightMicrons: 4000, BottomMicrons: 4000, LeftMicrons: 4000},
+				},
+			},
+		},
+	}
+	standard := cdd.MarginsTicketItem{TopMicrons: 4000, RightMicrons: 4000, BottomMicrons: 4000, LeftMicrons: 4000}
+
+	width, height, margins, ok := fitToPageArea(&printer, &cdd.CloudJobTicket{})
+	if !ok || width != 215900 || height != 279400 || margins != standard {
+		t.Logf("expected the default media and standard margins, got %d %d %+v %t", width, height, margins, ok)
+		t.Fail()
+	}
+
+	ticket := &cdd.CloudJobTicket{
+		Print: cdd.PrintTicketSection{
+			MediaSize: &cdd.MediaSizeTicketItem{WidthMicrons: 210000, HeightMicrons: 297000},
+			Margins:   &cdd.MarginsTicketItem{TopMicrons: 10000, LeftMicrons: 20000},
+		},
+	}
+	expected := cdd.MarginsTicketItem{TopMicrons: 10000, RightMicrons: 4000, BottomMicrons: 4000, LeftMicrons: 20000}
+	width, height, margins, ok = fitToPageArea(&printer, ticket)
+	if !ok || width != 210000 || height != 297000 || margins != expected {
+		t.Logf("expected the ticket's media and margins, got %d %d %+v %t", width, height, margins, ok)
+		t.Fail()
+	}
+
+	ticket.Print.MediaSize = &cdd.MediaSizeTicketItem{WidthMicrons: 210000, IsContinuousFeed: true}
+	if _, _, _, ok = fitToPageArea(&printer, ticket); ok {
+		t.Log("expected roll media to have no area to fit to")
+		t.Fail()
+	}
+}
+
+func TestGhostscriptFitToPageArgs(t *testing.T) {
+	margins := cdd.MarginsTicketItem{TopMicrons: 25400, RightMicrons: 12700, BottomMicrons: 0, LeftMicrons: 6350}
+	expected := []string{
+		"-q", "-dBATCH", "-dNOPAUSE", "-dSAFER",
+		"-sDEVICE=pdfwrite",
+		"-dFIXEDMEDIA", "-dPDFFitPage",
+		"-dDEVICEWIDTHPOINTS=612",
+		"-dDEVICEHEIGHTPOINTS=792",
+		"-sOutputFile=/tmp/out.pdf",
+		"-c", "<</.HWMargins [18 0 36 72]>> setpagedevice",
+		"-f", "/tmp/in.pdf",
+	}
+	actual := ghostscriptFitToPageArgs("/tmp/in.pdf", "/tmp/out.pdf", 215900, 279400, margins)
+	if !reflect.DeepEqual(expected, actual) {
+		t.Logf("expected\n %q\ngot\n %q", expected, actual)
+		t.Fail()
+	}
+}
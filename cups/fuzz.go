@@ -0,0 +1,79 @@
+// Copyright 2015 Google Inc. All rights reserved.
+
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+// +build gofuzz
+// +build linux darwin freebsd
+
+// Fuzz targets for go-fuzz (https://github.com/dvyukov/go-fuzz).
+// Build and run one target like so:
+//   go-fuzz-build -func FuzzPPD github.com/google/cloud-print-connector/cups
+//   go-fuzz -bin cups-fuzz.zip -workdir fuzz/ppd
+
+package cups
+
+import "strings"
+
+// FuzzPPD fuzzes the PPD parser and translator.
+func FuzzPPD(data []byte) int {
+	pds, _, _, _, _ := translatePPD(string(data), []string{"all"})
+	if pds == nil {
+		return 0
+	}
+	return 1
+}
+
+// FuzzAttrs fuzzes translation of CUPS printer attributes, including markers.
+//
+// Input is a list of attributes separated by newlines, each formatted
+// like name=value1,value2.
+func FuzzAttrs(data []byte) int {
+	tags := make(map[string][]string)
+	for _, line := range strings.Split(string(data), "\n") {
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		if kv[1] == "" {
+			// attributesToMap represents "none" as an empty slice.
+			tags[kv[0]] = []string{}
+		} else {
+			tags[kv[0]] = strings.Split(kv[1], ",")
+		}
+	}
+	if len(tags) == 0 {
+		return 0
+	}
+
+	translateAttrs(tags)
+	return 1
+}
+
+// FuzzMarkers fuzzes marker translation, with input formatted as
+// names, types and levels on three lines.
+func FuzzMarkers(data []byte) int {
+	lines := strings.SplitN(string(data), "\n", 3)
+	if len(lines) != 3 {
+		return 0
+	}
+	tags := map[string][]string{
+		attrMarkerNames:  strings.Split(lines[0], ","),
+		attrMarkerTypes:  strings.Split(lines[1], ","),
+		attrMarkerLevels: strings.Split(lines[2], ","),
+	}
+	q, _ := newQuirks("")
+	q.applyAttributes(tags)
+
+	if markers, _ := convertMarkers(tags); markers == nil {
+		return 0
+	}
+	return 1
+}
+
+// FuzzIPPDate fuzzes conversion of RFC 2579 dates.
+func FuzzIPPDate(data []byte) int {
+	convertIPPDateToTime(data)
+	return 0
+}
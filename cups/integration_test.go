@@ -0,0 +1,221 @@
+// Copyright 2015 Google Inc. All rights reserved.
+
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+// +build integration
+// +build linux darwin freebsd
+
+// Integration tests against a real cupsd. These talk to the CUPS server
+// named by CUPS_SERVER, which must have the queues created by
+// testdata/integration/setup-printers.sh. Run them with
+// testdata/integration/run-integration-tests.sh.
+
+package cups
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/cloud-print-connector/cdd"
+	"github.com/google/cloud-print-connector/lib"
+)
+
+// integrationJobTimeout bounds how long a job may take to finish.
+const integrationJobTimeout = time.Minute
+
+// integrationPDF is the smallest PDF that CUPS filters accept.
+const integrationPDF = `%PDF-1.1
+1 0 obj << /Type /Catalog /Pages 2 0 R >> endobj
+2 0 obj << /Type /Pages /Kids [3 0 R] /Count 1 >> endobj
+3 0 obj << /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] >> endobj
+trailer << /Root 1 0 R >>
+%%EOF
+`
+
+// integrationPrinter describes what the connector should generate for
+// one of the queues created by setup-printers.sh.
+type integrationPrinter struct {
+	manufacturer string
+	model        string
+	duplex       bool
+	color        bool
+	dpi          bool
+	vendor       bool
+}
+
+var integrationPrinters = map[string]integrationPrinter{
+	"laser-duplex": {"Acme", "LaserWriter 100", true, false, true, false},
+	"color-inkjet": {"Acme", "ColorJet 200", false, true, false, true},
+	"basic-mono":   {"Initech", "PC LOAD 1", false, false, false, false},
+}
+
+func newIntegrationCUPS(t *testing.T) *CUPS {
+	if os.Getenv("CUPS_SERVER") == "" {
+		t.Skip("CUPS_SERVER not set; see testdata/integration/run-integration-tests.sh")
+	}
+
+	c, err := NewCUPS(false, true, "", lib.DefaultConfig.CUPSPrinterAttributes, []string{"InputSlot"}, lib.DefaultConfig.CUPSPPDMaxBytes,
+		lib.DefaultConfig.CUPSMinConnections, lib.DefaultConfig.CUPSMaxConnections, 5*time.Second, nil, []string{}, []string{}, true, false, 2,
+		lib.NewJobTicketAudit(0, 0), "", []string{}, []string{}, []string{}, false,
+		[]string{}, []string{}, false, false, nil, nil, nil, "", "", "",
+		RequestTimeouts{time.Minute, time.Minute, time.Minute, time.Minute, time.Minute}, nil, nil, "", false, false, nil, false, "", nil, nil)
+	if err != nil {
+		t.Fatalf("Failed to connect to CUPS: %s", err)
+	}
+	return c
+}
+
+func getIntegrationPrinters(t *testing.T, c *CUPS) map[string]lib.Printer {
+	printers, err := c.GetPrinters()
+	if err != nil {
+		t.Fatalf("GetPrinters failed: %s", err)
+	}
+
+	m := make(map[string]lib.Printer, len(printers))
+	for _, p := range printers {
+		m[p.Name] = p
+	}
+	return m
+}
+
+func TestIntegrationCapabilities(t *testing.T) {
+	c := newIntegrationCUPS(t)
+	defer c.Quit()
+
+	printers := getIntegrationPrinters(t, c)
+
+	if _, exists := printers["raw"]; exists {
+		t.Log("raw printer should be ignored")
+		t.Fail()
+	}
+
+	for name, expected := range integrationPrinters {
+		p, exists := printers[name]
+		if !exists {
+			t.Errorf("printer %s missing", name)
+			continue
+		}
+		if p.Manufacturer != expected.manufacturer || p.Model != expected.model {
+			t.Errorf("%s: expected %s %s, got %s %s",
+				name, expected.manufacturer, expected.model, p.Manufacturer, p.Model)
+		}
+		if p.State == nil || p.State.State != cdd.CloudDeviceStateIdle {
+			t.Errorf("%s: expected IDLE, got %+v", name, p.State)
+		}
+		if p.Description.MediaSize == nil || len(p.Description.MediaSize.Option) == 0 {
+			t.Errorf("%s: expected media sizes", name)
+		}
+		if (p.Description.Duplex != nil) != expected.duplex {
+			t.Errorf("%s: expected duplex %t, got %+v", name, expected.duplex, p.Description.Duplex)
+		}
+		if expected.color && p.Description.Color == nil {
+			t.Errorf("%s: expected color", name)
+		}
+		if expected.dpi != hasPPDResolution(p.Description.DPI) {
+			t.Errorf("%s: expected PPD resolutions %t, got %+v", name, expected.dpi, p.Description.DPI)
+		}
+		if (p.Description.VendorCapability != nil) != expected.vendor {
+			t.Errorf("%s: expected vendor capabilities %t, got %+v", name, expected.vendor, p.Description.VendorCapability)
+		}
+		if p.Description.SupportedContentType == nil {
+			t.Errorf("%s: expected supported content types", name)
+		}
+	}
+
+	if p, exists := printers["stopped"]; !exists {
+		t.Error("printer stopped missing")
+	} else if p.State == nil || p.State.State != cdd.CloudDeviceStateStopped {
+		t.Errorf("stopped: expected STOPPED, got %+v", p.State)
+	}
+}
+
+func TestIntegrationPrint(t *testing.T) {
+	c := newIntegrationCUPS(t)
+	defer c.Quit()
+
+	printers := getIntegrationPrinters(t, c)
+
+	f, err := ioutil.TempFile("", "cups-integration")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	if _, err = f.WriteString(integrationPDF); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	ticket := &cdd.CloudJobTicket{
+		Print: cdd.PrintTicketSection{
+			Copies: &cdd.CopiesTicketItem{Copies: 2},
+		},
+	}
+
+	for _, name := range []string{"cups-pdf", "laser-duplex", "stopped"} {
+		p, exists := printers[name]
+		if !exists {
+			t.Errorf("printer %s missing", name)
+			continue
+		}
+		p.NativeJobSemaphore = lib.NewSemaphore(1)
+
+		jobID, err := c.Print(&p, f.Name(), "integration test", "integration", "job-"+name, ticket)
+		if err != nil {
+			t.Errorf("%s: Print failed: %s", name, err)
+			continue
+		}
+
+		state := waitForJob(t, c, name, jobID)
+		if name == "stopped" {
+			if state != cdd.JobStateInProgress {
+				t.Errorf("%s: expected job to wait in progress, got %s", name, state)
+			}
+		} else if state != cdd.JobStateDone {
+			t.Errorf("%s: expected job DONE, got %s", name, state)
+		}
+	}
+}
+
+// hasPPDResolution reports whether any resolution came from the PPD, as
+// cupsd also reports printer-resolution-supported for queues without one.
+func hasPPDResolution(dpi *cdd.DPI) bool {
+	if dpi == nil {
+		return false
+	}
+	for _, o := range dpi.Option {
+		if strings.HasPrefix(o.VendorID, ppdResolution+internalKeySeparator) {
+			return true
+		}
+	}
+	return false
+}
+
+// waitForJob polls a job until it leaves the IN_PROGRESS state, or
+// integrationJobTimeout passes. Returns the last state seen.
+func waitForJob(t *testing.T, c *CUPS, printerName string, jobID uint32) cdd.JobStateType {
+	var state cdd.JobStateType
+	for deadline := time.Now().Add(integrationJobTimeout); time.Now().Before(deadline); time.Sleep(time.Second) {
+		s, err := c.GetJobState(printerName, jobID)
+		if err != nil {
+			t.Errorf("%s: GetJobState failed: %s", printerName, err)
+			return state
+		}
+		if s.State == nil {
+			continue
+		}
+		state = s.State.Type
+		if state != cdd.JobStateInProgress {
+			return state
+		}
+		if printerName == "stopped" {
+			// Stopped printers hold jobs forever; one observation is enough.
+			return state
+		}
+	}
+	return state
+}
@@ -0,0 +1,165 @@
+// Copyright 2015 Google Inc. All rights reserved.
+
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+// +build linux darwin freebsd
+
+package cups
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	// The USB interface class, subclass and protocol of IPP-over-USB, from
+	// the IPP-USB specification.
+	usbClassPrinter    = 0x07
+	usbSubclassPrinter = 0x01
+	usbProtocolIPPUSB  = 0x04
+
+	usbDescriptorInterface = 0x04
+	usbDescriptorEndpoint  = 0x05
+	usbEndpointIn          = 0x80
+	usbTransferTypeMask    = 0x03
+	usbTransferTypeBulk    = 0x02
+
+	// ippUSBPrinterURI is the printer-uri of every IPP-over-USB printer,
+	// which sees itself at localhost.
+	ippUSBPrinterURI = "ipp://localhost/ipp/print"
+
+	// ippUSBDialTimeout is how long a request waits for a free interface.
+	ippUSBDialTimeout = time.Minute
+)
+
+var rIPPUSBNameUnsafe = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+// ippUSBInterface is a USB interface that carries one HTTP connection at a
+// time, over a pair of bulk endpoints.
+type ippUSBInterface struct {
+	number     uint8
+	altSetting uint8
+	in, out    uint8
+}
+
+// ippUSBDevice is a USB printer with at least one IPP-over-USB interface.
+type ippUSBDevice struct {
+	// path is the device's name in sysfs, like 1-1.2.
+	path                          string
+	busnum, devnum                int
+	manufacturer, product, serial string
+	interfaces                    []ippUSBInterface
+}
+
+// name gets a printer name for this device, which is stable across
+// reconnects: by serial number when the device has one, otherwise by the
+// USB port it is connected to.
+func (d *ippUSBDevice) name() string {
+	id := d.serial
+	if id == "" {
+		id = d.path
+	}
+	name := strings.Join([]string{d.manufacturer, d.product, id}, " ")
+	return strings.Trim(rIPPUSBNameUnsafe.ReplaceAllString(strings.TrimSpace(name), "_"), "_")
+}
+
+// parseIPPUSBInterfaces finds the IPP-over-USB interfaces in the raw
+// descriptors of a USB configuration. Only the first alternate setting of
+// each interface with IPP-over-USB and a pair of bulk endpoints counts.
+func parseIPPUSBInterfaces(descriptors []byte) []ippUSBInterface {
+	var interfaces []ippUSBInterface
+	var current *ippUSBInterface
+	found := make(map[uint8]struct{})
+
+	finish := func() {
+		if current != nil && current.in != 0 && current.out != 0 {
+			if _, exists := found[current.number]; !exists {
+				found[current.number] = struct{}{}
+				interfaces = append(interfaces, *current)
+			}
+		}
+		current = nil
+	}
+
+	for len(descriptors) >= 2 {
+		length := int(descriptors[0])
+		if length < 2 || length > len(descriptors) {
+			break
+		}
+		d := descriptors[:length]
+		descriptors = descriptors[length:]
+
+		switch d[1] {
+		case usbDescriptorInterface:
+			finish()
+			if len(d) >= 8 && d[5] == usbClassPrinter && d[6] == usbSubclassPrinter && d[7] == usbProtocolIPPUSB {
+				current = &ippUSBInterface{number: d[2], altSetting: d[3]}
+			}
+		case usbDescriptorEndpoint:
+			if current == nil || len(d) < 4 || d[3]&usbTransferTypeMask != usbTransferTypeBulk {
+				continue
+			}
+			if d[2]&usbEndpointIn != 0 {
+				if current.in == 0 {
+					current.in = d[2]
+				}
+			} else if current.out == 0 {
+				current.out = d[2]
+			}
+		}
+	}
+	finish()
+
+	return interfaces
+}
+
+// ippUSBPrinter speaks HTTP to an IPP-over-USB device, with one connection
+// per free interface.
+type ippUSBPrinter struct {
+	device     ippUSBDevice
+	interfaces chan ippUSBInterface
+	transport  *http.Transport
+}
+
+func newIPPUSBPrinter(device ippUSBDevice) *ippUSBPrinter {
+	p := ippUSBPrinter{
+		device:     device,
+		interfaces: make(chan ippUSBInterface, len(device.interfaces)),
+	}
+	for _, i := range device.interfaces {
+		p.interfaces <- i
+	}
+	// Each connection holds its interface until it is closed, so connections
+	// are not kept alive for other requests.
+	p.transport = &http.Transport{Dial: p.dial, DisableKeepAlives: true}
+	return &p
+}
+
+// dial opens a connection on the next free interface.
+func (p *ippUSBPrinter) dial(network, address string) (net.Conn, error) {
+	var i ippUSBInterface
+	select {
+	case i = <-p.interfaces:
+	case <-time.After(ippUSBDialTimeout):
+		return nil, errors.New("Timed out waiting for a free IPP-over-USB interface")
+	}
+
+	conn, err := openIPPUSBConn(&p.device, i, func() { p.interfaces <- i })
+	if err != nil {
+		p.interfaces <- i
+		return nil, err
+	}
+	return conn, nil
+}
+
+// ippUSBAddr is the address of an IPP-over-USB connection.
+type ippUSBAddr string
+
+func (a ippUSBAddr) Network() string { return "usb" }
+func (a ippUSBAddr) String() string  { return string(a) }
@@ -0,0 +1,234 @@
+// Copyright 2015 Google Inc. All rights reserved.
+
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+// +build linux
+
+package cups
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const (
+	sysfsUSBDevices = "/sys/bus/usb/devices"
+	usbfsDevices    = "/dev/bus/usb"
+
+	// usbfsMaxTransfer is the largest bulk transfer that usbfs always allows.
+	usbfsMaxTransfer = 16384
+	// usbfsReadTimeout bounds each bulk read, so that a closed connection
+	// stops reading.
+	usbfsReadTimeout = time.Second
+	// usbfsWriteTimeout gives up on a device that doesn't accept data.
+	usbfsWriteTimeout = 30 * time.Second
+
+	usbfsIoctlClaimInterface   = 0x8004550f // _IOR('U', 15, unsigned int)
+	usbfsIoctlReleaseInterface = 0x80045510 // _IOR('U', 16, unsigned int)
+	usbfsIoctlSetInterface     = 0x80085504 // _IOR('U', 4, struct usbdevfs_setinterface)
+)
+
+// usbfsBulkTransfer is struct usbdevfs_bulktransfer.
+type usbfsBulkTransfer struct {
+	endpoint uint32
+	length   uint32
+	timeout  uint32
+	data     unsafe.Pointer
+}
+
+// usbfsIoctlBulk is _IOWR('U', 2, struct usbdevfs_bulktransfer), whose size
+// depends on the architecture.
+var usbfsIoctlBulk = uintptr(3<<30 | unsafe.Sizeof(usbfsBulkTransfer{})<<16 | 'U'<<8 | 2)
+
+// findIPPUSBDevices finds the USB devices with IPP-over-USB interfaces.
+func findIPPUSBDevices() ([]ippUSBDevice, error) {
+	entries, err := ioutil.ReadDir(sysfsUSBDevices)
+	if err != nil {
+		return nil, err
+	}
+
+	var devices []ippUSBDevice
+	for _, entry := range entries {
+		if strings.Contains(entry.Name(), ":") {
+			// An interface, not a device.
+			continue
+		}
+		dir := filepath.Join(sysfsUSBDevices, entry.Name())
+		descriptors, err := ioutil.ReadFile(filepath.Join(dir, "descriptors"))
+		if err != nil || len(descriptors) < 18 {
+			continue
+		}
+		// The 18-byte device descriptor is followed by the descriptors of
+		// the active configuration.
+		interfaces := parseIPPUSBInterfaces(descriptors[18:])
+		if len(interfaces) == 0 {
+			continue
+		}
+
+		busnum, err := strconv.Atoi(readSysfsString(dir, "busnum"))
+		if err != nil {
+			continue
+		}
+		devnum, err := strconv.Atoi(readSysfsString(dir, "devnum"))
+		if err != nil {
+			continue
+		}
+		devices = append(devices, ippUSBDevice{
+			path:         entry.Name(),
+			busnum:       busnum,
+			devnum:       devnum,
+			manufacturer: readSysfsString(dir, "manufacturer"),
+			product:      readSysfsString(dir, "product"),
+			serial:       readSysfsString(dir, "serial"),
+			interfaces:   interfaces,
+		})
+	}
+
+	return devices, nil
+}
+
+func readSysfsString(dir, name string) string {
+	b, err := ioutil.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// ippUSBConn is an HTTP connection over the bulk endpoints of one claimed
+// IPP-over-USB interface.
+type ippUSBConn struct {
+	f       *os.File
+	device  *ippUSBDevice
+	iface   ippUSBInterface
+	release func()
+
+	closed     int32
+	readMutex  sync.Mutex
+	writeMutex sync.Mutex
+}
+
+// openIPPUSBConn claims interface i of device. release is called when the
+// connection is closed.
+func openIPPUSBConn(device *ippUSBDevice, i ippUSBInterface, release func()) (net.Conn, error) {
+	name := filepath.Join(usbfsDevices, fmt.Sprintf("%03d", device.busnum), fmt.Sprintf("%03d", device.devnum))
+	f, err := os.OpenFile(name, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	number := uint32(i.number)
+	if err = usbfsIoctl(f, usbfsIoctlClaimInterface, unsafe.Pointer(&number)); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("Failed to claim IPP-over-USB interface %d of %s: %s", i.number, device.path, err)
+	}
+	setting := [2]uint32{uint32(i.number), uint32(i.altSetting)}
+	if err = usbfsIoctl(f, usbfsIoctlSetInterface, unsafe.Pointer(&setting)); err != nil {
+		usbfsIoctl(f, usbfsIoctlReleaseInterface, unsafe.Pointer(&number))
+		f.Close()
+		return nil, fmt.Errorf("Failed to select IPP-over-USB interface %d of %s: %s", i.number, device.path, err)
+	}
+
+	return &ippUSBConn{f: f, device: device, iface: i, release: release}, nil
+}
+
+func usbfsIoctl(f *os.File, request uintptr, arg unsafe.Pointer) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), request, uintptr(arg)); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// bulk does one bulk transfer, and returns the quantity of bytes transferred.
+func (c *ippUSBConn) bulk(endpoint uint8, b []byte, timeout time.Duration) (int, error) {
+	if len(b) > usbfsMaxTransfer {
+		b = b[:usbfsMaxTransfer]
+	}
+	if len(b) == 0 {
+		return 0, nil
+	}
+	transfer := usbfsBulkTransfer{
+		endpoint: uint32(endpoint),
+		length:   uint32(len(b)),
+		timeout:  uint32(timeout / time.Millisecond),
+		data:     unsafe.Pointer(&b[0]),
+	}
+	n, _, errno := syscall.Syscall(syscall.SYS_IOCTL, c.f.Fd(), usbfsIoctlBulk, uintptr(unsafe.Pointer(&transfer)))
+	if errno != 0 {
+		return 0, errno
+	}
+	return int(n), nil
+}
+
+func (c *ippUSBConn) Read(b []byte) (int, error) {
+	c.readMutex.Lock()
+	defer c.readMutex.Unlock()
+
+	for {
+		if atomic.LoadInt32(&c.closed) != 0 {
+			return 0, errors.New("IPP-over-USB connection closed")
+		}
+		n, err := c.bulk(c.iface.in, b, usbfsReadTimeout)
+		if err == syscall.ETIMEDOUT || (err == nil && n == 0) {
+			// Nothing yet; the device is still working on a response.
+			continue
+		}
+		return n, err
+	}
+}
+
+func (c *ippUSBConn) Write(b []byte) (int, error) {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+
+	var written int
+	for written < len(b) {
+		if atomic.LoadInt32(&c.closed) != 0 {
+			return written, errors.New("IPP-over-USB connection closed")
+		}
+		n, err := c.bulk(c.iface.out, b[written:], usbfsWriteTimeout)
+		if err != nil {
+			return written, err
+		}
+		written += n
+	}
+	return written, nil
+}
+
+// Close releases the interface, after any transfer in progress.
+func (c *ippUSBConn) Close() error {
+	if !atomic.CompareAndSwapInt32(&c.closed, 0, 1) {
+		return nil
+	}
+	c.readMutex.Lock()
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	defer c.readMutex.Unlock()
+
+	number := uint32(c.iface.number)
+	usbfsIoctl(c.f, usbfsIoctlReleaseInterface, unsafe.Pointer(&number))
+	err := c.f.Close()
+	c.release()
+	return err
+}
+
+func (c *ippUSBConn) LocalAddr() net.Addr  { return ippUSBAddr("localhost") }
+func (c *ippUSBConn) RemoteAddr() net.Addr { return ippUSBAddr(c.device.path) }
+
+// Deadlines are not supported; reads stop when the connection is closed.
+func (c *ippUSBConn) SetDeadline(t time.Time) error      { return nil }
+func (c *ippUSBConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *ippUSBConn) SetWriteDeadline(t time.Time) error { return nil }
@@ -0,0 +1,24 @@
+// Copyright 2015 Google Inc. All rights reserved.
+
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+// +build darwin freebsd
+
+package cups
+
+import (
+	"errors"
+	"net"
+)
+
+var errIPPUSBUnsupported = errors.New("IPP-over-USB is only supported on Linux")
+
+func findIPPUSBDevices() ([]ippUSBDevice, error) {
+	return nil, errIPPUSBUnsupported
+}
+
+func openIPPUSBConn(device *ippUSBDevice, i ippUSBInterface, release func()) (net.Conn, error) {
+	return nil, errIPPUSBUnsupported
+}
@@ -0,0 +1,99 @@
+// Copyright 2015 Google Inc. All rights reserved.
+
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+// +build linux darwin freebsd
+
+package cups
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseIPPUSBInterfaces(t *testing.T) {
+	descriptors := []byte{
+		// Configuration.
+		9, 0x02, 0, 0, 3, 1, 0, 0xc0, 0,
+		// Interface 0: legacy bidirectional printer.
+		9, 0x04, 0, 0, 2, 0x07, 0x01, 0x02, 0,
+		7, 0x05, 0x01, 0x02, 0, 2, 0,
+		7, 0x05, 0x81, 0x02, 0, 2, 0,
+		// Interface 1, alternate setting 0: vendor-specific.
+		9, 0x04, 1, 0, 0, 0xff, 0, 0, 0,
+		// Interface 1, alternate setting 1: IPP-over-USB, with an
+		// interrupt endpoint to ignore.
+		9, 0x04, 1, 1, 3, 0x07, 0x01, 0x04, 0,
+		7, 0x05, 0x83, 0x03, 8, 0, 10,
+		7, 0x05, 0x02, 0x02, 0, 2, 0,
+		7, 0x05, 0x82, 0x02, 0, 2, 0,
+		// Interface 1, alternate setting 2: IPP-over-USB again.
+		9, 0x04, 1, 2, 2, 0x07, 0x01, 0x04, 0,
+		7, 0x05, 0x06, 0x02, 0, 2, 0,
+		7, 0x05, 0x86, 0x02, 0, 2, 0,
+		// Interface 2: IPP-over-USB without an IN endpoint.
+		9, 0x04, 2, 0, 1, 0x07, 0x01, 0x04, 0,
+		7, 0x05, 0x04, 0x02, 0, 2, 0,
+	}
+
+	expected := []ippUSBInterface{{number: 1, altSetting: 1, in: 0x82, out: 0x02}}
+	if interfaces := parseIPPUSBInterfaces(descriptors); !reflect.DeepEqual(interfaces, expected) {
+		t.Logf("expected interfaces %+v, got %+v", expected, interfaces)
+		t.Fail()
+	}
+
+	// Truncated descriptors must not panic.
+	parseIPPUSBInterfaces(descriptors[:13])
+	parseIPPUSBInterfaces([]byte{0})
+}
+
+func TestIPPUSBDeviceName(t *testing.T) {
+	d := ippUSBDevice{path: "1-1.2", manufacturer: "Acme", product: "Laser/Jet 9"}
+	if name := d.name(); name != "Acme_Laser_Jet_9_1-1.2" {
+		t.Logf("expected a name by USB port without a serial number, got %s", name)
+		t.Fail()
+	}
+	d.serial = "SN 123"
+	if name := d.name(); name != "Acme_Laser_Jet_9_SN_123" {
+		t.Logf("expected a name by serial number, got %s", name)
+		t.Fail()
+	}
+}
+
+func TestIPPUSBPrinter(t *testing.T) {
+	ipp := &fakeIPPPrinter{t: t}
+	server := httptest.NewServer(ipp)
+	defer server.Close()
+
+	f := newFakeCUPSClient()
+	c := newTestCUPS(f, 0)
+	c.direct = &directClient{usbPrinters: make(map[string]*ippUSBPrinter)}
+
+	// Stand in for a USB device with a connection to the fake printer.
+	p := newIPPUSBPrinter(ippUSBDevice{path: "1-1", interfaces: []ippUSBInterface{{number: 1}}})
+	p.transport.Dial = func(network, address string) (net.Conn, error) {
+		if address != "localhost:631" {
+			t.Errorf("expected a connection to localhost:631, got %s", address)
+		}
+		return net.Dial("tcp", strings.TrimPrefix(server.URL, "http://"))
+	}
+	c.direct.usbPrinters["usb-printer"] = p
+
+	printers, err := c.GetPrinters()
+	if err != nil {
+		t.Fatalf("GetPrinters failed: %s", err)
+	}
+	if names := printerNames(printers); !reflect.DeepEqual(names, []string{"usb-printer"}) {
+		t.Fatalf("expected printer usb-printer, got %v", names)
+	}
+	if uri, transport, ok := c.direct.lookup("usb-printer"); !ok || uri != ippUSBPrinterURI || transport != http.RoundTripper(p.transport) {
+		t.Logf("expected usb-printer at %s over its own transport, got %s %v", ippUSBPrinterURI, uri, transport)
+		t.Fail()
+	}
+}
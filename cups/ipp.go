@@ -0,0 +1,476 @@
+// Copyright 2015 Google Inc. All rights reserved.
+
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+// +build linux darwin freebsd
+
+package cups
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/cloud-print-connector/lib"
+)
+
+// IPP operations, status codes and tags, from RFC 8010 and RFC 8011, for
+// talking to printers without the CUPS library.
+const (
+	ippVersionMajor = 2
+	ippVersionMinor = 0
+
+	ippOpPrintJob              uint16 = 0x0002
+	ippOpCancelJob             uint16 = 0x0008
+	ippOpGetJobAttributes      uint16 = 0x0009
+	ippOpGetPrinterAttributes  uint16 = 0x000b
+	ippOpIdentifyPrinter       uint16 = 0x003c
+	ippStatusSuccessfulMaximum uint16 = 0x00ff
+
+	ippStatusBadRequest                 uint16 = 0x0400
+	ippStatusForbidden                  uint16 = 0x0401
+	ippStatusNotAuthenticated           uint16 = 0x0402
+	ippStatusNotAuthorized              uint16 = 0x0403
+	ippStatusNotFound                   uint16 = 0x0406
+	ippStatusGone                       uint16 = 0x0407
+	ippStatusRequestEntityTooLarge      uint16 = 0x0408
+	ippStatusDocumentFormatNotSupported uint16 = 0x040a
+	ippStatusAttributesNotSupported     uint16 = 0x040b
+	ippStatusInternal                   uint16 = 0x0500
+	ippStatusServiceUnavailable         uint16 = 0x0502
+	ippStatusNotAcceptingJobs           uint16 = 0x0506
+	ippStatusBusy                       uint16 = 0x0507
+
+	ippTagOperation   byte = 0x01
+	ippTagJob         byte = 0x02
+	ippTagEnd         byte = 0x03
+	ippTagPrinter     byte = 0x04
+	ippTagUnsupported byte = 0x05
+
+	ippTagNoValue         byte = 0x13
+	ippTagNotSettable     byte = 0x15
+	ippTagInteger         byte = 0x21
+	ippTagBoolean         byte = 0x22
+	ippTagEnum            byte = 0x23
+	ippTagDate            byte = 0x31
+	ippTagResolution      byte = 0x32
+	ippTagRange           byte = 0x33
+	ippTagBeginCollection byte = 0x34
+	ippTagTextLang        byte = 0x35
+	ippTagNameLang        byte = 0x36
+	ippTagEndCollection   byte = 0x37
+	ippTagText            byte = 0x41
+	ippTagName            byte = 0x42
+	ippTagKeyword         byte = 0x44
+	ippTagURI             byte = 0x45
+	ippTagURIScheme       byte = 0x46
+	ippTagCharset         byte = 0x47
+	ippTagLanguage        byte = 0x48
+	ippTagMimeType        byte = 0x49
+	ippTagMemberName      byte = 0x4a
+
+	// ippResolutionDPI is the units of a resolution in dots per inch.
+	ippResolutionDPI = 3
+)
+
+// ippStatusError is an IPP request that failed with an error status.
+type ippStatusError struct {
+	status  uint16
+	message string
+}
+
+func (e *ippStatusError) Error() string {
+	return e.message
+}
+
+// prefixError prefixes the message of an error, keeping its IPP status, if
+// it has one.
+func prefixError(prefix string, err error) error {
+	if e, ok := err.(*ippStatusError); ok {
+		return &ippStatusError{e.status, prefix + e.message}
+	}
+	return errors.New(prefix + err.Error())
+}
+
+// ippStatusJobErrorKind tells the user of a job why an IPP request failed,
+// by its status.
+func ippStatusJobErrorKind(status uint16) lib.JobErrorKind {
+	switch status {
+	case ippStatusForbidden, ippStatusNotAuthenticated, ippStatusNotAuthorized:
+		return lib.JobErrorNotAllowed
+	case ippStatusNotFound, ippStatusGone, ippStatusServiceUnavailable, ippStatusNotAcceptingJobs, ippStatusBusy:
+		return lib.JobErrorPrinterUnavailable
+	case ippStatusRequestEntityTooLarge:
+		return lib.JobErrorTooLarge
+	case ippStatusDocumentFormatNotSupported:
+		return lib.JobErrorDocumentUnsupported
+	case ippStatusAttributesNotSupported:
+		return lib.JobErrorTicketUnsupported
+	case ippStatusBadRequest, ippStatusInternal:
+		return lib.JobErrorInternal
+	default:
+		return lib.JobErrorPrintFailed
+	}
+}
+
+// submitJobError describes a job that failed to submit, by the IPP status
+// of the request that failed, if any.
+func submitJobError(err error) *lib.JobError {
+	jobErr := &lib.JobError{Kind: lib.JobErrorPrintFailed, Stage: lib.JobErrorStageSubmit, Err: err}
+	if e, ok := err.(*ippStatusError); ok {
+		jobErr.Kind = ippStatusJobErrorKind(e.status)
+		jobErr.IPPStatus = int(e.status)
+	}
+	return jobErr
+}
+
+// ippTraceRedacted are the attributes whose values are never traced.
+var ippTraceRedacted = map[string]bool{
+	"requesting-user-name":      true,
+	"job-originating-user-name": true,
+}
+
+// ippGroupNames names groups of attributes in traces.
+var ippGroupNames = map[byte]string{
+	ippTagOperation:   "operation",
+	ippTagJob:         "job",
+	ippTagPrinter:     "printer",
+	ippTagUnsupported: "unsupported",
+}
+
+// ippAttribute is one attribute of a request, with values formatted like
+// attributesToMap formats them.
+type ippAttribute struct {
+	tag    byte
+	name   string
+	values []string
+}
+
+// ippRequest is an IPP request, for encoding.
+type ippRequest struct {
+	operation           uint16
+	requestID           uint32
+	operationAttributes []ippAttribute
+	jobAttributes       []ippAttribute
+}
+
+// newIPPRequest creates a request with the attributes that every request
+// starts with.
+func newIPPRequest(operation uint16, requestID uint32, printerURI string) *ippRequest {
+	return &ippRequest{
+		operation: operation,
+		requestID: requestID,
+		operationAttributes: []ippAttribute{
+			{ippTagCharset, "attributes-charset", []string{"utf-8"}},
+			{ippTagLanguage, "attributes-natural-language", []string{"en"}},
+			{ippTagURI, "printer-uri", []string{printerURI}},
+		},
+	}
+}
+
+// encode gets the request in the IPP wire format, without document data.
+func (r *ippRequest) encode() ([]byte, error) {
+	var b bytes.Buffer
+	b.Write([]byte{ippVersionMajor, ippVersionMinor})
+	binary.Write(&b, binary.BigEndian, r.operation)
+	binary.Write(&b, binary.BigEndian, r.requestID)
+
+	for _, group := range []struct {
+		tag        byte
+		attributes []ippAttribute
+	}{
+		{ippTagOperation, r.operationAttributes},
+		{ippTagJob, r.jobAttributes},
+	} {
+		if len(group.attributes) == 0 {
+			continue
+		}
+		b.WriteByte(group.tag)
+		for _, a := range group.attributes {
+			for i, v := range a.values {
+				value, err := encodeIPPValue(a.tag, v)
+				if err != nil {
+					return nil, fmt.Errorf("IPP attribute %s: %s", a.name, err)
+				}
+				name := a.name
+				if i > 0 {
+					// Additional values have no name.
+					name = ""
+				}
+				b.WriteByte(a.tag)
+				binary.Write(&b, binary.BigEndian, uint16(len(name)))
+				b.WriteString(name)
+				binary.Write(&b, binary.BigEndian, uint16(len(value)))
+				b.Write(value)
+			}
+		}
+	}
+	b.WriteByte(ippTagEnd)
+
+	return b.Bytes(), nil
+}
+
+// dump formats the request for a trace, without document data.
+func (r *ippRequest) dump() string {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "operation 0x%04x request-id %d\n", r.operation, r.requestID)
+	for _, group := range []struct {
+		tag        byte
+		attributes []ippAttribute
+	}{
+		{ippTagOperation, r.operationAttributes},
+		{ippTagJob, r.jobAttributes},
+	} {
+		if len(group.attributes) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "%s attributes:\n", ippGroupNames[group.tag])
+		for _, a := range group.attributes {
+			dumpIPPAttribute(&b, a.name, a.values)
+		}
+	}
+	return b.String()
+}
+
+func dumpIPPAttribute(b *bytes.Buffer, name string, values []string) {
+	value := strings.Join(values, ",")
+	if ippTraceRedacted[name] {
+		value = "REDACTED"
+	}
+	fmt.Fprintf(b, "  %s=%s\n", name, value)
+}
+
+func encodeIPPValue(tag byte, value string) ([]byte, error) {
+	switch tag {
+	case ippTagInteger, ippTagEnum:
+		i, err := strconv.ParseInt(value, 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		b := make([]byte, 4)
+		binary.BigEndian.PutUint32(b, uint32(int32(i)))
+		return b, nil
+
+	case ippTagBoolean:
+		if value == attrTrue {
+			return []byte{1}, nil
+		}
+		return []byte{0}, nil
+
+	case ippTagResolution:
+		x, y, ok := parseIPPResolution(value)
+		if !ok {
+			return nil, fmt.Errorf("resolution %q is not like 600x600ppi", value)
+		}
+		b := make([]byte, 9)
+		binary.BigEndian.PutUint32(b, uint32(x))
+		binary.BigEndian.PutUint32(b[4:], uint32(y))
+		b[8] = ippResolutionDPI
+		return b, nil
+
+	default:
+		return []byte(value), nil
+	}
+}
+
+// ippResponse is a decoded IPP response. Each group of attributes is a
+// map like attributesToMap makes.
+type ippResponse struct {
+	status    uint16
+	requestID uint32
+	groups    []ippGroup
+}
+
+type ippGroup struct {
+	tag        byte
+	attributes map[string][]string
+}
+
+// group gets the first group with tag; nil when there is none.
+func (r *ippResponse) group(tag byte) map[string][]string {
+	for _, g := range r.groups {
+		if g.tag == tag {
+			return g.attributes
+		}
+	}
+	return nil
+}
+
+// dump formats the response for a trace.
+func (r *ippResponse) dump() string {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "status 0x%04x request-id %d\n", r.status, r.requestID)
+	for _, g := range r.groups {
+		name, exists := ippGroupNames[g.tag]
+		if !exists {
+			name = fmt.Sprintf("0x%02x", g.tag)
+		}
+		fmt.Fprintf(&b, "%s attributes:\n", name)
+		names := make([]string, 0, len(g.attributes))
+		for n := range g.attributes {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		for _, n := range names {
+			dumpIPPAttribute(&b, n, g.attributes[n])
+		}
+	}
+	return b.String()
+}
+
+// decodeIPPResponse decodes a response in the IPP wire format.
+func decodeIPPResponse(r io.Reader) (*ippResponse, error) {
+	var header struct {
+		Major, Minor byte
+		Status       uint16
+		RequestID    uint32
+	}
+	if err := binary.Read(r, binary.BigEndian, &header); err != nil {
+		return nil, fmt.Errorf("Failed to read IPP response header: %s", err)
+	}
+	response := ippResponse{status: header.Status, requestID: header.RequestID}
+
+	var group *ippGroup
+	var name string
+	// collections counts nested collections of the current attribute,
+	// whose members are skipped.
+	var collections int
+	for {
+		var tag [1]byte
+		if _, err := io.ReadFull(r, tag[:]); err != nil {
+			return nil, fmt.Errorf("Failed to read IPP tag: %s", err)
+		}
+		if tag[0] == ippTagEnd {
+			break
+		}
+		if tag[0] < 0x10 {
+			// Begins a group of attributes.
+			response.groups = append(response.groups, ippGroup{tag[0], make(map[string][]string)})
+			group = &response.groups[len(response.groups)-1]
+			continue
+		}
+		if group == nil {
+			return nil, errors.New("IPP attribute outside of a group")
+		}
+
+		n, err := readIPPBytes(r)
+		if err != nil {
+			return nil, err
+		}
+		value, err := readIPPBytes(r)
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case collections > 0:
+			switch tag[0] {
+			case ippTagBeginCollection:
+				collections++
+			case ippTagEndCollection:
+				collections--
+			}
+			continue
+		case tag[0] == ippTagBeginCollection:
+			collections++
+		}
+
+		if len(n) > 0 {
+			name = string(n)
+			group.attributes[name] = []string{}
+		}
+		if v, ok := decodeIPPValue(tag[0], value); ok {
+			group.attributes[name] = append(group.attributes[name], v)
+		}
+	}
+
+	for _, g := range response.groups {
+		for k, v := range g.attributes {
+			if len(v) == 1 && (v[0] == "none" || len(v[0]) == 0) {
+				g.attributes[k] = []string{}
+			}
+		}
+	}
+
+	return &response, nil
+}
+
+func readIPPBytes(r io.Reader) ([]byte, error) {
+	var length uint16
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return nil, fmt.Errorf("Failed to read IPP length: %s", err)
+	}
+	b := make([]byte, length)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, fmt.Errorf("Failed to read IPP value: %s", err)
+	}
+	return b, nil
+}
+
+// decodeIPPValue formats a value like attributesToMap does. Returns false
+// when the attribute has no value.
+func decodeIPPValue(tag byte, value []byte) (string, bool) {
+	switch tag {
+	case ippTagNoValue, ippTagNotSettable:
+		return "", false
+
+	case ippTagInteger, ippTagEnum:
+		if len(value) != 4 {
+			return "", false
+		}
+		return strconv.FormatInt(int64(int32(binary.BigEndian.Uint32(value))), 10), true
+
+	case ippTagBoolean:
+		if len(value) == 1 && value[0] != 0 {
+			return attrTrue, true
+		}
+		return attrFalse, true
+
+	case ippTagText, ippTagName, ippTagKeyword, ippTagURI, ippTagURIScheme,
+		ippTagCharset, ippTagLanguage, ippTagMimeType:
+		return string(value), true
+
+	case ippTagTextLang, ippTagNameLang:
+		// Language length, language, text length, text.
+		if len(value) < 2 {
+			return "", false
+		}
+		l := int(binary.BigEndian.Uint16(value))
+		if len(value) < 2+l+2 {
+			return "", false
+		}
+		return string(value[2+l+2:]), true
+
+	case ippTagDate:
+		if len(value) != 11 {
+			return "", false
+		}
+		return strconv.FormatInt(convertIPPDateToTime(value).Unix(), 10), true
+
+	case ippTagResolution:
+		if len(value) != 9 {
+			return "", false
+		}
+		x := int32(binary.BigEndian.Uint32(value))
+		y := int32(binary.BigEndian.Uint32(value[4:]))
+		return fmt.Sprintf("%dx%dppi", x, y), true
+
+	case ippTagRange:
+		if len(value) != 8 {
+			return "", false
+		}
+		lower := int32(binary.BigEndian.Uint32(value))
+		upper := int32(binary.BigEndian.Uint32(value[4:]))
+		return fmt.Sprintf("%d~%d", lower, upper), true
+
+	default:
+		return "unknown or unsupported type", true
+	}
+}
@@ -0,0 +1,263 @@
+// Copyright 2015 Google Inc. All rights reserved.
+
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+// +build linux darwin freebsd
+
+package cups
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/google/cloud-print-connector/cdd"
+	"github.com/google/cloud-print-connector/lib"
+	"github.com/google/cloud-print-connector/log"
+)
+
+const (
+	// coverSheetVendorID is the ID of the vendor ticket item that prints a
+	// cover sheet, with the job's title, owner and ID, before a job.
+	coverSheetVendorID = "cover-sheet"
+	// separatorSheetsVendorID is the ID of the vendor ticket item that
+	// prints a separator sheet between the copies of a job.
+	separatorSheetsVendorID = "separator-sheets"
+
+	// Job sheets are this size when neither the ticket nor the printer
+	// has a media size.
+	jobSheetDefaultWidthMicrons  = 215900
+	jobSheetDefaultHeightMicrons = 279400
+)
+
+// jobSheets adds cover sheets and separator sheets to the jobs of printers
+// that share an output tray, so that jobs and copies are easy to tell
+// apart.
+type jobSheets struct {
+	// coverPrinters print a cover sheet before each job, unless the
+	// ticket says not to.
+	coverPrinters map[string]interface{}
+	// separatorPrinters print a separator sheet between the copies of each
+	// job, unless the ticket says not to.
+	separatorPrinters map[string]interface{}
+	// ticketOptions lets the tickets of every printer ask for job sheets.
+	ticketOptions bool
+}
+
+func newJobSheets(coverPrinters, separatorPrinters []string, ticketOptions bool) jobSheets {
+	s := jobSheets{
+		coverPrinters:     map[string]interface{}{},
+		separatorPrinters: map[string]interface{}{},
+		ticketOptions:     ticketOptions,
+	}
+	for _, p := range coverPrinters {
+		s.coverPrinters[p] = struct{}{}
+	}
+	for _, p := range separatorPrinters {
+		s.separatorPrinters[p] = struct{}{}
+	}
+	return s
+}
+
+// jobSheetCapability is the capability that lets a ticket turn a job sheet
+// on or off.
+func jobSheetCapability(id, displayName string, isDefault bool) cdd.VendorCapability {
+	return cdd.VendorCapability{
+		ID:   id,
+		Type: cdd.VendorCapabilityTypedValue,
+		TypedValueCap: &cdd.TypedValueCapability{
+			ValueType: cdd.TypedValueCapabilityTypeBoolean,
+			Default:   strconv.FormatBool(isDefault),
+		},
+		DisplayNameLocalized: cdd.NewLocalizedString(displayName),
+	}
+}
+
+// addCapabilities advertises the job sheets that the tickets of each
+// printer may turn on or off, defaulting to whether the printer prints
+// them.
+func (s jobSheets) addCapabilities(printers []lib.Printer) {
+	for i := range printers {
+		var capabilities []cdd.VendorCapability
+		if _, cover := s.coverPrinters[printers[i].Name]; cover || s.ticketOptions {
+			capabilities = append(capabilities, jobSheetCapability(coverSheetVendorID, "Cover sheet", cover))
+		}
+		if _, separators := s.separatorPrinters[printers[i].Name]; separators || s.ticketOptions {
+			capabilities = append(capabilities, jobSheetCapability(separatorSheetsVendorID, "Separator sheets between copies", separators))
+		}
+		if len(capabilities) == 0 {
+			continue
+		}
+
+		if printers[i].Description.VendorCapability == nil {
+			printers[i].Description.VendorCapability = &[]cdd.VendorCapability{}
+		}
+		*printers[i].Description.VendorCapability = append(*printers[i].Description.VendorCapability, capabilities...)
+	}
+}
+
+// wants tells whether a job prints the job sheet of a vendor ticket item:
+// as the ticket says, or else as the printer does by default.
+func (s jobSheets) wants(printer *lib.Printer, ticket *cdd.CloudJobTicket, id string) bool {
+	printers := s.coverPrinters
+	if id == separatorSheetsVendorID {
+		printers = s.separatorPrinters
+	}
+	_, want := printers[printer.Name]
+
+	if ticket != nil && (want || s.ticketOptions) {
+		for _, vti := range ticket.Print.VendorTicketItem {
+			if vti.ID == id {
+				if b, err := strconv.ParseBool(vti.Value); err == nil {
+					want = b
+				}
+			}
+		}
+	}
+	return want
+}
+
+// insert writes a copy of a PDF with the job sheets that the job wants to
+// a temporary file: a cover sheet first, and a separator sheet between
+// copies, in which case the copies are in the file, and copies is set to
+// one. The caller removes the file.
+//
+// Returns false when the job wants no job sheets, or they can't be added;
+// then the job prints as it is.
+func (s jobSheets) insert(printer *lib.Printer, ticket *cdd.CloudJobTicket, filename, title, user, gcpJobID string, options map[string]string) (string, bool) {
+	copies, _ := strconv.Atoi(options[attrCopies])
+	if copies < 1 {
+		copies = 1
+	}
+	cover := s.wants(printer, ticket, coverSheetVendorID)
+	separators := copies > 1 && s.wants(printer, ticket, separatorSheetsVendorID)
+	if !cover && !separators {
+		return "", false
+	}
+
+	pages, err := lib.CountPDFPages(filename)
+	if err != nil || pages == 0 {
+		log.WarningPrinterf(printer.Name, "Not adding job sheets to a job that isn't a PDF")
+		return "", false
+	}
+
+	width, height, _, ok := fitToPageArea(printer, ticket)
+	if !ok {
+		width, height = jobSheetDefaultWidthMicrons, jobSheetDefaultHeightMicrons
+	}
+	duplex := isDuplex(ticket)
+	details := []string{
+		title,
+		"User: " + user,
+		"Job: " + gcpJobID,
+		"Printer: " + printer.Name,
+		"Printed: " + time.Now().Format("2006-01-02 15:04:05 MST"),
+	}
+
+	var sheets []string
+	defer func() {
+		for _, sheet := range sheets {
+			os.Remove(sheet)
+		}
+	}()
+	writeSheet := func(pages [][]string) (string, error) {
+		f, err := ioutil.TempFile("", "cloud-print-connector-sheet-")
+		if err != nil {
+			return "", err
+		}
+		sheets = append(sheets, f.Name())
+		_, err = f.Write(jobSheetPDF(width, height, pages))
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		return f.Name(), err
+	}
+
+	var inFilenames []string
+	if cover {
+		sheet, err := writeSheet(jobSheetPages(details, duplex, false))
+		if err != nil {
+			log.WarningPrinterf(printer.Name, "Failed to write a cover sheet, so printing the job without: %s", err)
+			return "", false
+		}
+		inFilenames = append(inFilenames, sheet)
+	}
+	inFilenames = append(inFilenames, filename)
+	if separators {
+		for i := 2; i <= copies; i++ {
+			lines := append([]string{fmt.Sprintf("Copy %d of %d", i, copies)}, details...)
+			sheet, err := writeSheet(jobSheetPages(lines, duplex, pages%2 == 1))
+			if err != nil {
+				log.WarningPrinterf(printer.Name, "Failed to write a separator sheet, so printing the job without: %s", err)
+				return "", false
+			}
+			inFilenames = append(inFilenames, sheet, filename)
+		}
+	}
+
+	out, err := runGhostscript("cloud-print-connector-sheets-", func(outFilename string) []string {
+		return ghostscriptJoinArgs(outFilename, inFilenames)
+	})
+	if err != nil {
+		log.WarningPrinterf(printer.Name, "Failed to add job sheets, so printing the job without: %s", err)
+		return "", false
+	}
+	if separators {
+		options[attrCopies] = "1"
+	}
+	return out, true
+}
+
+// jobSheetPages lays out a job sheet of lines of text. A duplex job sheet
+// has a blank back, so that the next page starts a new sheet; padFront adds
+// a blank page first, for the back of an odd last page before it.
+func jobSheetPages(lines []string, duplex, padFront bool) [][]string {
+	pages := [][]string{lines}
+	if duplex {
+		if padFront {
+			pages = append([][]string{nil}, pages...)
+		}
+		pages = append(pages, nil)
+	}
+	return pages
+}
+
+// ghostscriptJoinArgs gets the arguments that make Ghostscript join the
+// pages of inFilenames, in order, into outFilename.
+func ghostscriptJoinArgs(outFilename string, inFilenames []string) []string {
+	return append([]string{
+		"-q", "-dBATCH", "-dNOPAUSE", "-dSAFER",
+		"-sDEVICE=pdfwrite",
+		"-sOutputFile=" + outFilename,
+		"-f",
+	}, inFilenames...)
+}
+
+// jobSheetPDF writes a PDF of pages of the given size, in microns, with
+// each page's lines of text printed from the top left, the first line
+// larger. A page of no lines is blank.
+func jobSheetPDF(width, height int32, pages [][]string) []byte {
+	pointsWide, _ := strconv.Atoi(micronsToPoints(width))
+	pointsHigh, _ := strconv.Atoi(micronsToPoints(height))
+
+	pdfPages := make([]lib.PDFPage, len(pages))
+	for i, lines := range pages {
+		var content bytes.Buffer
+		y := pointsHigh - 72
+		for j, line := range lines {
+			size := 12
+			if j == 0 {
+				size = 24
+			}
+			y -= size + 6
+			content.WriteString(lib.PDFText(72, y, size, line))
+		}
+		pdfPages[i] = lib.PDFPage{Width: pointsWide, Height: pointsHigh, Content: content.String()}
+	}
+	return lib.WritePDF(pdfPages)
+}
@@ -0,0 +1,100 @@
+// Copyright 2015 Google Inc. All rights reserved.
+
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+// +build linux darwin freebsd
+
+package cups
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/google/cloud-print-connector/cdd"
+	"github.com/google/cloud-print-connector/lib"
+)
+
+func TestJobSheetPDF(t *testing.T) {
+	pdf := jobSheetPDF(210000, 297000, jobSheetPages([]string{"Report (final)", "User: a@example.com"}, true, true))
+
+	f, err := ioutil.TempFile("", "cloud-print-connector-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.Write(pdf)
+	f.Close()
+
+	pages, err := lib.CountPDFPages(f.Name())
+	if err != nil || pages != 3 {
+		t.Logf("expected 3 pages, got %d, %v", pages, err)
+		t.Fail()
+	}
+	for _, expected := range []string{"/MediaBox [0 0 595 842]", "(Report \\(final\\)) Tj", "(User: a@example.com) Tj"} {
+		if !bytes.Contains(pdf, []byte(expected)) {
+			t.Logf("expected %q in job sheet PDF", expected)
+			t.Fail()
+		}
+	}
+}
+
+func TestGhostscriptJoinArgs(t *testing.T) {
+	expected := []string{
+		"-q", "-dBATCH", "-dNOPAUSE", "-dSAFER",
+		"-sDEVICE=pdfwrite",
+		"-sOutputFile=out.pdf",
+		"-f", "cover.pdf", "job.pdf", "separator.pdf", "job.pdf",
+	}
+	if args := ghostscriptJoinArgs("out.pdf", []string{"cover.pdf", "job.pdf", "separator.pdf", "job.pdf"}); !reflect.DeepEqual(args, expected) {
+		t.Logf("expected %v, got %v", expected, args)
+		t.Fail()
+	}
+}
+
+func TestJobSheetsWants(t *testing.T) {
+	s := newJobSheets([]string{"alpha"}, []string{}, false)
+	alpha, beta := &lib.Printer{Name: "alpha"}, &lib.Printer{Name: "beta"}
+	off := &cdd.CloudJobTicket{Print: cdd.PrintTicketSection{
+		VendorTicketItem: []cdd.VendorTicketItem{{ID: coverSheetVendorID, Value: "false"}},
+	}}
+	on := &cdd.CloudJobTicket{Print: cdd.PrintTicketSection{
+		VendorTicketItem: []cdd.VendorTicketItem{{ID: coverSheetVendorID, Value: "true"}},
+	}}
+
+	if !s.wants(alpha, nil, coverSheetVendorID) {
+		t.Log("expected a cover sheet by default on alpha")
+		t.Fail()
+	}
+	if s.wants(alpha, off, coverSheetVendorID) {
+		t.Log("expected the ticket to turn off the cover sheet on alpha")
+		t.Fail()
+	}
+	if s.wants(beta, on, coverSheetVendorID) {
+		t.Log("expected no cover sheet on beta without ticket options")
+		t.Fail()
+	}
+
+	s.ticketOptions = true
+	if !s.wants(beta, on, coverSheetVendorID) {
+		t.Log("expected the ticket to turn on the cover sheet on beta")
+		t.Fail()
+	}
+	if s.wants(beta, nil, separatorSheetsVendorID) {
+		t.Log("expected no separator sheets by default on beta")
+		t.Fail()
+	}
+
+	printers := []lib.Printer{{Name: "alpha", Description: &cdd.PrinterDescriptionSection{}}}
+	s.addCapabilities(printers)
+	vc := *printers[0].Description.VendorCapability
+	if len(vc) != 2 || vc[0].ID != coverSheetVendorID || vc[0].TypedValueCap.Default != "true" ||
+		vc[1].ID != separatorSheetsVendorID || vc[1].TypedValueCap.Default != "false" {
+		t.Logf("unexpected job sheet capabilities %+v", vc)
+		t.Fail()
+	}
+}
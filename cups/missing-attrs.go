@@ -0,0 +1,67 @@
+// Copyright 2015 Google Inc. All rights reserved.
+
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+// +build linux darwin freebsd
+
+package cups
+
+import (
+	"sync"
+
+	"github.com/google/cloud-print-connector/log"
+)
+
+// degradedAttributes are required printer attributes that some CUPS servers
+// don't report, and what the connector does without them.
+var degradedAttributes = []struct {
+	name    string
+	without string
+}{
+	{attrPrinterUUID, "printers are identified by name"},
+	{attrMarkerNames, "printers have no markers"},
+	{attrMarkerLevels, "printers have no marker levels"},
+	{attrPrinterStateReasons, "printer states have no reasons"},
+}
+
+// missingAttributes remembers the degraded attributes that the CUPS server
+// doesn't report, to note each once. The zero value is ready to use.
+type missingAttributes struct {
+	mutex sync.Mutex
+	noted map[string]struct{}
+}
+
+// check notes the degraded attributes that no printer has. An
+// attribute that a printer has again is noted about again when it goes
+// missing again.
+func (m *missingAttributes) check(printers []map[string][]string) {
+	if len(printers) == 0 {
+		return
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.noted == nil {
+		m.noted = make(map[string]struct{})
+	}
+
+	for _, a := range degradedAttributes {
+		var reported bool
+		for _, p := range printers {
+			if _, exists := p[a.name]; exists {
+				reported = true
+				break
+			}
+		}
+
+		_, noted := m.noted[a.name]
+		if reported {
+			delete(m.noted, a.name)
+		} else if !noted {
+			m.noted[a.name] = struct{}{}
+			log.Infof("CUPS doesn't report %s, so %s", a.name, a.without)
+		}
+	}
+}
@@ -0,0 +1,309 @@
+// Copyright 2015 Google Inc. All rights reserved.
+
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+// +build linux darwin freebsd
+
+package cups
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/cloud-print-connector/lib"
+	"github.com/google/cloud-print-connector/log"
+)
+
+// prefixedServer is one CUPS server of a multiServerClient, whose printer
+// names are prefixed to keep them apart from those of the other servers.
+type prefixedServer struct {
+	address string
+	prefix  string
+	cc      cupsClient
+	// printers are the attributes of the server's printers, by name, as it
+	// last listed them; they stand in for its printers while it doesn't
+	// answer, so that they aren't deleted.
+	printers map[string]map[string][]string
+}
+
+// multiServerClient is a cupsClient that aggregates the printers of several
+// CUPS servers, like the servers of branch offices, so that one connector
+// registers them all. Each server has its own connections, and a prefix
+// that its printer names start with; requests for a printer go to the
+// server whose prefix its name starts with.
+type multiServerClient struct {
+	servers []prefixedServer
+	// mutex guards the printers of each server.
+	mutex sync.Mutex
+}
+
+// newMultiServerClient connects to each CUPS server. maxConnections is the
+// limit of the connections to each server that doesn't set its own. A
+// server that doesn't answer yet is synced once it does.
+func newMultiServerClient(servers []lib.CUPSServer, minConnections, maxConnections uint, connectTimeout time.Duration, timeouts RequestTimeouts, ppdMaxBytes int64) (*multiServerClient, error) {
+	for i := range servers {
+		for j := range servers {
+			if i != j && strings.HasPrefix(servers[i].Prefix, servers[j].Prefix) {
+				return nil, fmt.Errorf("The printer name prefix %q of CUPS server %s starts with the prefix %q of CUPS server %s, so their printers can't be told apart",
+					servers[i].Prefix, servers[i].Address, servers[j].Prefix, servers[j].Address)
+			}
+		}
+	}
+
+	m := &multiServerClient{servers: make([]prefixedServer, 0, len(servers))}
+	for _, s := range servers {
+		connections := s.MaxConnections
+		if connections == 0 {
+			connections = maxConnections
+		}
+		cc, err := newUncheckedCUPSCore(s.Address, minConnections, connections, connectTimeout, timeouts, ppdMaxBytes)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to connect to CUPS server %s: %s", s.Address, err)
+		}
+		if err = cc.checkConnection(); err != nil {
+			// Don't hold up the other servers.
+			log.Warningf("Failed to connect to CUPS server %s; its printers will be synced once it answers: %s", s.Address, err)
+		}
+		m.servers = append(m.servers, prefixedServer{address: s.Address, prefix: s.Prefix, cc: cc})
+	}
+	return m, nil
+}
+
+// route finds the index of the server of a printer, and the printer's name
+// on it.
+func (m *multiServerClient) route(printername string) (int, string, error) {
+	for i, s := range m.servers {
+		if strings.HasPrefix(printername, s.prefix) {
+			return i, strings.TrimPrefix(printername, s.prefix), nil
+		}
+	}
+	return 0, "", fmt.Errorf("Printer %s is not on any CUPS server", printername)
+}
+
+// getPrinters gets the printers of each server in turn, with prefixed
+// names. A page that starts at firstPrinterName starts on its server, and
+// continues onto the next servers until it has limit printers. A server
+// that fails is skipped, and the printers it listed last stand in for its
+// printers, so that one unreachable server doesn't hold up the others.
+func (m *multiServerClient) getPrinters(attributes []string, limit uint, firstPrinterName string) ([]map[string][]string, error) {
+	var first int
+	var name string
+	if firstPrinterName != "" {
+		var err error
+		if first, name, err = m.route(firstPrinterName); err != nil {
+			return nil, err
+		}
+	}
+
+	var printers []map[string][]string
+	for i := first; i < len(m.servers); i++ {
+		var remaining uint
+		if limit > 0 {
+			if uint(len(printers)) >= limit {
+				break
+			}
+			remaining = limit - uint(len(printers))
+		}
+		if i > first {
+			name = ""
+		}
+
+		s := &m.servers[i]
+		page, err := s.cc.getPrinters(attributes, remaining, name)
+		if err != nil {
+			log.Warningf("Failed to get the printers of CUPS server %s; keeping the ones it had: %s", s.address, err)
+			page = m.lastPrinters(s, remaining, name)
+		} else {
+			m.rememberPrinters(s, page, remaining, name)
+		}
+		for _, p := range page {
+			if n := p[attrPrinterName]; len(n) > 0 {
+				p[attrPrinterName] = []string{s.prefix + n[0]}
+			}
+			printers = append(printers, p)
+		}
+	}
+	if printers == nil {
+		printers = make([]map[string][]string, 0)
+	}
+	return printers, nil
+}
+
+// printerNameLess orders printer names like CUPS does, regardless of case.
+func printerNameLess(a, b string) bool {
+	return strings.ToLower(a) < strings.ToLower(b)
+}
+
+// copyAttributes copies the attributes of a printer, so that prefixing its
+// name doesn't change the original.
+func copyAttributes(attributes map[string][]string) map[string][]string {
+	c := make(map[string][]string, len(attributes))
+	for k, v := range attributes {
+		c[k] = v
+	}
+	return c
+}
+
+// rememberPrinters remembers a page of the printers of a server, which was
+// requested from first, with limit. The printers that the page passes over
+// are gone from the server.
+func (m *multiServerClient) rememberPrinters(s *prefixedServer, page []map[string][]string, limit uint, first string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if s.printers == nil {
+		s.printers = make(map[string]map[string][]string)
+	}
+	// A page that isn't full goes to the last printer.
+	var last string
+	toEnd := limit == 0 || uint(len(page)) < limit
+	listed := make(map[string]struct{}, len(page))
+	for _, p := range page {
+		n := p[attrPrinterName]
+		if len(n) == 0 {
+			continue
+		}
+		s.printers[n[0]] = copyAttributes(p)
+		listed[n[0]] = struct{}{}
+		last = n[0]
+	}
+	for name := range s.printers {
+		if _, exists := listed[name]; exists || printerNameLess(name, first) {
+			continue
+		}
+		if toEnd || !printerNameLess(last, name) {
+			delete(s.printers, name)
+		}
+	}
+}
+
+// lastPrinters gets a page of the printers that a server listed last, like
+// cupsClient.getPrinters would.
+func (m *multiServerClient) lastPrinters(s *prefixedServer, limit uint, first string) []map[string][]string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	names := make([]string, 0, len(s.printers))
+	for name := range s.printers {
+		if !printerNameLess(name, first) {
+			names = append(names, name)
+		}
+	}
+	sort.Slice(names, func(i, j int) bool { return printerNameLess(names[i], names[j]) })
+	if limit > 0 && uint(len(names)) > limit {
+		names = names[:limit]
+	}
+
+	printers := make([]map[string][]string, len(names))
+	for i, name := range names {
+		printers[i] = copyAttributes(s.printers[name])
+	}
+	return printers
+}
+
+func (m *multiServerClient) getPPD(printername string, modtime *time.Time) (string, error) {
+	i, name, err := m.route(printername)
+	if err != nil {
+		return "", err
+	}
+	return m.servers[i].cc.getPPD(name, modtime)
+}
+
+func (m *multiServerClient) printFile(user, printername, filename, title string, options map[string]string) (uint32, error) {
+	i, name, err := m.route(printername)
+	if err != nil {
+		return 0, err
+	}
+	return m.servers[i].cc.printFile(user, name, filename, title, options)
+}
+
+func (m *multiServerClient) getJobAttributes(printername string, jobID uint32, attributes []string) (map[string][]string, error) {
+	i, name, err := m.route(printername)
+	if err != nil {
+		return nil, err
+	}
+	return m.servers[i].cc.getJobAttributes(name, jobID, attributes)
+}
+
+// countPendingJobs counts the jobs that are not completed on every server.
+func (m *multiServerClient) countPendingJobs() (uint, error) {
+	var jobs uint
+	for _, s := range m.servers {
+		n, err := s.cc.countPendingJobs()
+		if err != nil {
+			return 0, fmt.Errorf("CUPS server %s: %s", s.address, err)
+		}
+		jobs += n
+	}
+	return jobs, nil
+}
+
+func (m *multiServerClient) cancelJob(printername string, jobID uint32) error {
+	i, name, err := m.route(printername)
+	if err != nil {
+		return err
+	}
+	return m.servers[i].cc.cancelJob(name, jobID)
+}
+
+func (m *multiServerClient) connQtyOpen() uint {
+	var n uint
+	for _, s := range m.servers {
+		n += s.cc.connQtyOpen()
+	}
+	return n
+}
+
+func (m *multiServerClient) connQtyMax() uint {
+	var n uint
+	for _, s := range m.servers {
+		n += s.cc.connQtyMax()
+	}
+	return n
+}
+
+func (m *multiServerClient) connPoolResizes() (uint, uint) {
+	var grows, shrinks uint
+	for _, s := range m.servers {
+		g, sh := s.cc.connPoolResizes()
+		grows += g
+		shrinks += sh
+	}
+	return grows, shrinks
+}
+
+func (m *multiServerClient) connReuse() (uint64, uint64) {
+	var opened, reused uint64
+	for _, s := range m.servers {
+		o, r := s.cc.connReuse()
+		opened += o
+		reused += r
+	}
+	return opened, reused
+}
+
+// splitServerAddress splits the address of a CUPS server into its host and
+// port; the port is zero when the address has none, as does the path of a
+// domain socket.
+func splitServerAddress(address string) (string, int, error) {
+	if strings.HasPrefix(address, "/") {
+		return address, 0, nil
+	}
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		// No port.
+		return strings.Trim(address, "[]"), 0, nil
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil || p < 1 || p > 65535 {
+		return "", 0, fmt.Errorf("Invalid port in CUPS server address %s", address)
+	}
+	return host, p, nil
+}
@@ -0,0 +1,170 @@
+// Copyright 2015 Google Inc. All rights reserved.
+
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+// +build linux darwin freebsd
+
+package cups
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func newTestMultiServerClient() (*multiServerClient, *fakeCUPSClient, *fakeCUPSClient) {
+	east, west := newFakeCUPSClient(), newFakeCUPSClient()
+	for _, name := range []string{"a", "b", "c"} {
+		east.addPrinter(name, nil, fakePPD)
+	}
+	for _, name := range []string{"a", "d"} {
+		west.addPrinter(name, nil, fakePPD)
+	}
+	m := &multiServerClient{servers: []prefixedServer{
+		{address: "east.example.com", prefix: "east-", cc: east},
+		{address: "west.example.com", prefix: "west-", cc: west},
+	}}
+	return m, east, west
+}
+
+func attributePrinterNames(printers []map[string][]string) []string {
+	names := make([]string, len(printers))
+	for i, p := range printers {
+		names[i] = p[attrPrinterName][0]
+	}
+	return names
+}
+
+func TestMultiServerGetPrinters(t *testing.T) {
+	m, _, _ := newTestMultiServerClient()
+
+	printers, err := m.getPrinters([]string{"all"}, 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"east-a", "east-b", "east-c", "west-a", "west-d"}
+	if names := attributePrinterNames(printers); !reflect.DeepEqual(names, expected) {
+		t.Logf("expected %v, got %v", expected, names)
+		t.Fail()
+	}
+
+	// Page like CUPS.GetPrinters does: each page starts with the last
+	// printer of the page before.
+	var names []string
+	var first string
+	for {
+		page, err := m.getPrinters([]string{"all"}, 2, first)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pageNames := attributePrinterNames(page)
+		if first != "" && len(pageNames) > 0 && pageNames[0] == first {
+			pageNames = pageNames[1:]
+		}
+		names = append(names, pageNames...)
+		if len(page) < 2 {
+			break
+		}
+		first = page[len(page)-1][attrPrinterName][0]
+	}
+	if !reflect.DeepEqual(names, expected) {
+		t.Logf("expected %v by pages, got %v", expected, names)
+		t.Fail()
+	}
+}
+
+func TestMultiServerGetPrintersFailingServer(t *testing.T) {
+	m, east, _ := newTestMultiServerClient()
+
+	// The east server has never answered.
+	east.getPrintersErr = errors.New("connection refused")
+	printers, err := m.getPrinters([]string{"all"}, 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := attributePrinterNames(printers); !reflect.DeepEqual(names, []string{"west-a", "west-d"}) {
+		t.Logf("expected the printers of the west server, got %v", names)
+		t.Fail()
+	}
+
+	// Printer b is deleted while the east server answers, then it fails.
+	east.getPrintersErr = nil
+	east.printers = append(east.printers[:1], east.printers[2:]...)
+	if _, err = m.getPrinters([]string{"all"}, 0, ""); err != nil {
+		t.Fatal(err)
+	}
+	east.getPrintersErr = errors.New("connection refused")
+
+	expected := []string{"east-a", "east-c", "west-a", "west-d"}
+	printers, err = m.getPrinters([]string{"all"}, 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := attributePrinterNames(printers); !reflect.DeepEqual(names, expected) {
+		t.Logf("expected the last printers of the east server, got %v", names)
+		t.Fail()
+	}
+	page, err := m.getPrinters([]string{"all"}, 2, "east-c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := attributePrinterNames(page); !reflect.DeepEqual(names, expected[1:3]) {
+		t.Logf("expected a page of %v, got %v", expected[1:3], names)
+		t.Fail()
+	}
+	// The last printers aren't changed by prefixing.
+	if name := m.servers[0].printers["a"][attrPrinterName][0]; name != "a" {
+		t.Logf("expected the last printers to keep their names, got %s", name)
+		t.Fail()
+	}
+}
+
+func TestMultiServerRoute(t *testing.T) {
+	m, east, west := newTestMultiServerClient()
+
+	if _, err := m.printFile("user", "west-a", "job.pdf", "title", map[string]string{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(east.printed) != 0 || len(west.printed) != 1 || west.printed[0].printername != "a" {
+		t.Logf("expected the job on printer a of the west server, got east %v, west %v", east.printed, west.printed)
+		t.Fail()
+	}
+	if _, err := m.getJobAttributes("west-a", 1, jobAttributes); err != nil {
+		t.Logf("expected the job's attributes from the west server: %s", err)
+		t.Fail()
+	}
+	if _, err := m.getJobAttributes("east-a", 1, jobAttributes); err == nil {
+		t.Log("expected no job on the east server")
+		t.Fail()
+	}
+	if _, err := m.printFile("user", "north-a", "job.pdf", "title", map[string]string{}); err == nil {
+		t.Log("expected an error printing to a printer of no server")
+		t.Fail()
+	}
+}
+
+func TestSplitServerAddress(t *testing.T) {
+	for _, test := range []struct {
+		address string
+		host    string
+		port    int
+	}{
+		{"cups.example.com", "cups.example.com", 0},
+		{"cups.example.com:8631", "cups.example.com", 8631},
+		{"[::1]:631", "::1", 631},
+		{"[::1]", "::1", 0},
+		{"/var/run/cups/cups.sock", "/var/run/cups/cups.sock", 0},
+	} {
+		host, port, err := splitServerAddress(test.address)
+		if err != nil || host != test.host || port != test.port {
+			t.Logf("expected %s to split to %s, %d; got %s, %d, %v", test.address, test.host, test.port, host, port, err)
+			t.Fail()
+		}
+	}
+	if _, _, err := splitServerAddress("cups.example.com:http"); err == nil {
+		t.Log("expected an error for a port that isn't a number")
+		t.Fail()
+	}
+}
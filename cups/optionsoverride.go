@@ -0,0 +1,126 @@
+// Copyright 2015 Google Inc. All rights reserved.
+
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+// +build linux darwin freebsd
+
+package cups
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/google/cloud-print-connector/log"
+)
+
+// optionsOverrideExtension is the extension of the override files in the
+// override directory, named like the printer they apply to.
+const optionsOverrideExtension = ".json"
+
+// OptionsOverride is a set of CUPS options to merge into the next Jobs jobs
+// of one printer. An option with an empty value is removed from the job.
+type OptionsOverride struct {
+	Options map[string]string `json:"options"`
+	Jobs    uint              `json:"jobs"`
+}
+
+// optionsOverrides holds the OptionsOverride of each printer, so that driver
+// options can be tried out on live jobs without touching PPDs.
+//
+// Overrides are set by the monitor, or by dropping a file like
+// <dir>/<printer name>.json into the override directory. Files are consumed
+// by the next job to their printer, replacing any earlier override.
+type optionsOverrides struct {
+	dir       string
+	overrides map[string]OptionsOverride
+	mutex     sync.Mutex
+}
+
+func newOptionsOverrides(dir string) *optionsOverrides {
+	return &optionsOverrides{
+		dir:       dir,
+		overrides: make(map[string]OptionsOverride),
+	}
+}
+
+// set replaces the override of one printer. Zero jobs clears it.
+func (oo *optionsOverrides) set(printerName string, o OptionsOverride) {
+	oo.mutex.Lock()
+	defer oo.mutex.Unlock()
+
+	if o.Jobs == 0 {
+		delete(oo.overrides, printerName)
+	} else {
+		oo.overrides[printerName] = o
+	}
+}
+
+// apply merges the override of one printer into the options of a job, and
+// counts the job against the override.
+func (oo *optionsOverrides) apply(printerName string, options map[string]string) {
+	oo.mutex.Lock()
+	defer oo.mutex.Unlock()
+
+	oo.readFile(printerName)
+
+	o, exists := oo.overrides[printerName]
+	if !exists {
+		return
+	}
+
+	for k, v := range o.Options {
+		if v == "" {
+			delete(options, k)
+		} else {
+			options[k] = v
+		}
+	}
+	log.InfoPrinterf(printerName, "Applied options override %v, for %d more jobs", o.Options, o.Jobs-1)
+
+	o.Jobs--
+	if o.Jobs == 0 {
+		delete(oo.overrides, printerName)
+	} else {
+		oo.overrides[printerName] = o
+	}
+}
+
+// readFile consumes the override file of one printer, if there is one. Call
+// with the mutex held.
+func (oo *optionsOverrides) readFile(printerName string) {
+	if oo.dir == "" {
+		return
+	}
+
+	filename := filepath.Join(oo.dir, printerName+optionsOverrideExtension)
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.WarningPrinterf(printerName, "Failed to read options override file: %s", err)
+		}
+		return
+	}
+	if err = os.Remove(filename); err != nil {
+		// Leaving the file behind would apply it to every job, forever.
+		log.WarningPrinterf(printerName, "Ignoring options override file that can't be removed: %s", err)
+		return
+	}
+
+	var o OptionsOverride
+	if err = json.Unmarshal(b, &o); err != nil {
+		log.WarningPrinterf(printerName, "Failed to parse options override file %s: %s", filename, err)
+		return
+	}
+	if o.Jobs == 0 {
+		delete(oo.overrides, printerName)
+	} else {
+		oo.overrides[printerName] = o
+	}
+	log.InfoPrinterf(printerName, "Read options override file %s", filename)
+}
+
@@ -0,0 +1,94 @@
+// Copyright 2015 Google Inc. All rights reserved.
+
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+// +build linux darwin freebsd
+
+package cups
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestOptionsOverridesSet(t *testing.T) {
+	oo := newOptionsOverrides("")
+	oo.set("alpha", OptionsOverride{
+		Options: map[string]string{"Resolution": "1200dpi", "InputSlot": ""},
+		Jobs:    2,
+	})
+
+	for i := 0; i < 2; i++ {
+		options := map[string]string{"copies": "1", "InputSlot": "Tray1"}
+		oo.apply("alpha", options)
+		expected := map[string]string{"copies": "1", "Resolution": "1200dpi"}
+		if !reflect.DeepEqual(expected, options) {
+			t.Logf("job %d: expected %v, got %v", i, expected, options)
+			t.Fail()
+		}
+	}
+
+	options := map[string]string{"copies": "1"}
+	oo.apply("alpha", options)
+	if len(options) != 1 {
+		t.Logf("expected the override to be used up, got %v", options)
+		t.Fail()
+	}
+
+	oo.set("alpha", OptionsOverride{Options: map[string]string{"Resolution": "1200dpi"}, Jobs: 1})
+	oo.set("alpha", OptionsOverride{})
+	oo.apply("alpha", options)
+	if len(options) != 1 {
+		t.Logf("expected the override to be cleared, got %v", options)
+		t.Fail()
+	}
+
+	oo.set("beta", OptionsOverride{Options: map[string]string{"Resolution": "1200dpi"}, Jobs: 1})
+	oo.apply("alpha", options)
+	if len(options) != 1 {
+		t.Logf("expected the override of beta to leave alpha alone, got %v", options)
+		t.Fail()
+	}
+}
+
+func TestOptionsOverridesFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "options-override")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "alpha"+optionsOverrideExtension)
+	err = ioutil.WriteFile(filename, []byte(`{"options": {"MediaType": "Glossy"}, "jobs": 2}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oo := newOptionsOverrides(dir)
+	oo.set("alpha", OptionsOverride{Options: map[string]string{"Resolution": "1200dpi"}, Jobs: 5})
+
+	for i := 0; i < 3; i++ {
+		options := map[string]string{}
+		oo.apply("alpha", options)
+		var expected map[string]string
+		if i < 2 {
+			expected = map[string]string{"MediaType": "Glossy"}
+		} else {
+			expected = map[string]string{}
+		}
+		if !reflect.DeepEqual(expected, options) {
+			t.Logf("job %d: expected %v, got %v", i, expected, options)
+			t.Fail()
+		}
+	}
+
+	if _, err = os.Stat(filename); !os.IsNotExist(err) {
+		t.Logf("expected override file to be consumed, got %v", err)
+		t.Fail()
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright 2015 Google Inc. All rights reserved.
+
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+// +build linux darwin freebsd
+
+package cups
+
+import (
+	"github.com/google/cloud-print-connector/cdd"
+	"github.com/google/cloud-print-connector/lib"
+)
+
+// substitutePaperSizes are the paper sizes that stand in for each other,
+// so that a job doesn't stall on a printer waiting for paper that nobody
+// stocks.
+var substitutePaperSizes = []struct {
+	name                              cdd.MediaSizeName
+	widthMicrons, heightMicrons       int32
+	substitute                        cdd.MediaSizeName
+	substituteWidth, substituteHeight int32
+}{
+	{cdd.MediaSizeNALetter, 215900, 279400, cdd.MediaSizeISOA4, 210000, 297000},
+	{cdd.MediaSizeISOA4, 210000, 297000, cdd.MediaSizeNALetter, 215900, 279400},
+}
+
+// paperSubstitution prints Letter jobs on A4, and A4 jobs on Letter, when
+// a printer doesn't support or stock the size that a job asks for, but
+// does the other.
+type paperSubstitution struct {
+	enabled bool
+	// stocked are the paper sizes, by name or vendor ID, that each printer
+	// has loaded; printers that aren't here stock every size they support.
+	stocked map[string]map[string]interface{}
+}
+
+func newPaperSubstitution(enabled bool, stocked map[string][]string) paperSubstitution {
+	p := paperSubstitution{
+		enabled: enabled,
+		stocked: make(map[string]map[string]interface{}, len(stocked)),
+	}
+	for printerName, sizes := range stocked {
+		p.stocked[printerName] = make(map[string]interface{}, len(sizes))
+		for _, size := range sizes {
+			p.stocked[printerName][size] = struct{}{}
+		}
+	}
+	return p
+}
+
+// available finds the media size option of a printer of the given size,
+// if the printer stocks it.
+func (p paperSubstitution) available(printer *lib.Printer, name cdd.MediaSizeName, width, height int32) (cdd.MediaSizeOption, bool) {
+	for _, o := range printer.Description.MediaSize.Option {
+		if o.Name != name && (o.WidthMicrons != width || o.HeightMicrons != height) {
+			continue
+		}
+		if stocked, exists := p.stocked[printer.Name]; exists {
+			_, byName := stocked[string(o.Name)]
+			_, byVendorID := stocked[o.VendorID]
+			if !byName && !byVendorID {
+				continue
+			}
+		}
+		return o, true
+	}
+	return cdd.MediaSizeOption{}, false
+}
+
+// substitute finds the paper size that a job prints on instead of the one
+// its ticket asks for. Returns a copy of the ticket with the substitute
+// size, and its name, or the ticket and the empty string when the job
+// prints on the size it asks for.
+func (p paperSubstitution) substitute(printer *lib.Printer, ticket *cdd.CloudJobTicket) (*cdd.CloudJobTicket, cdd.MediaSizeName) {
+	if !p.enabled || ticket == nil || ticket.Print.MediaSize == nil ||
+		printer.Description == nil || printer.Description.MediaSize == nil {
+		return ticket, ""
+	}
+	requested := ticket.Print.MediaSize
+
+	for _, s := range substitutePaperSizes {
+		if requested.WidthMicrons != s.widthMicrons || requested.HeightMicrons != s.heightMicrons {
+			continue
+		}
+		if _, ok := p.available(printer, s.name, s.widthMicrons, s.heightMicrons); ok {
+			return ticket, ""
+		}
+		o, ok := p.available(printer, s.substitute, s.substituteWidth, s.substituteHeight)
+		if !ok {
+			return ticket, ""
+		}
+
+		t := *ticket
+		t.Print.MediaSize = &cdd.MediaSizeTicketItem{
+			WidthMicrons:  o.WidthMicrons,
+			HeightMicrons: o.HeightMicrons,
+			VendorID:      o.VendorID,
+		}
+		return &t, s.substitute
+	}
+	return ticket, ""
+}
@@ -0,0 +1,74 @@
+// Copyright 2015 Google Inc. All rights reserved.
+
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+// +build linux darwin freebsd
+
+package cups
+
+import (
+	"testing"
+
+	"github.com/google/cloud-print-connector/cdd"
+	"github.com/google/cloud-print-connector/lib"
+)
+
+func TestPaperSubstitution(t *testing.T) {
+	printer := lib.Printer{
+		Name: "alpha",
+		Description: &cdd.PrinterDescriptionSection{
+			MediaSize: &cdd.MediaSize{Option: []cdd.MediaSizeOption{
+				{Name: cdd.MediaSizeISOA4, WidthMicrons: 210000, HeightMicrons: 297000, VendorID: "A4", IsDefault: true},
+				{Name: cdd.MediaSizeISOA5, WidthMicrons: 148000, HeightMicrons: 210000, VendorID: "A5"},
+			}},
+		},
+	}
+	letter := &cdd.CloudJobTicket{Print: cdd.PrintTicketSection{
+		MediaSize: &cdd.MediaSizeTicketItem{WidthMicrons: 215900, HeightMicrons: 279400, VendorID: "Letter"},
+	}}
+
+	p := newPaperSubstitution(false, nil)
+	if ticket, substitute := p.substitute(&printer, letter); ticket != letter || substitute != "" {
+		t.Log("expected no substitution when disabled")
+		t.Fail()
+	}
+
+	p = newPaperSubstitution(true, nil)
+	ticket, substitute := p.substitute(&printer, letter)
+	if substitute != cdd.MediaSizeISOA4 || ticket.Print.MediaSize.VendorID != "A4" || ticket.Print.MediaSize.WidthMicrons != 210000 {
+		t.Logf("expected Letter to print on A4, got %s %+v", substitute, ticket.Print.MediaSize)
+		t.Fail()
+	}
+	if letter.Print.MediaSize.VendorID != "Letter" {
+		t.Log("expected the original ticket to be unchanged")
+		t.Fail()
+	}
+
+	a5 := &cdd.CloudJobTicket{Print: cdd.PrintTicketSection{
+		MediaSize: &cdd.MediaSizeTicketItem{WidthMicrons: 148000, HeightMicrons: 210000, VendorID: "A5"},
+	}}
+	if _, substitute := p.substitute(&printer, a5); substitute != "" {
+		t.Log("expected no substitution for A5")
+		t.Fail()
+	}
+
+	// A printer that supports Letter, but has only A4 loaded.
+	printer.Description.MediaSize.Option = append(printer.Description.MediaSize.Option,
+		cdd.MediaSizeOption{Name: cdd.MediaSizeNALetter, WidthMicrons: 215900, HeightMicrons: 279400, VendorID: "Letter"})
+	if _, substitute := p.substitute(&printer, letter); substitute != "" {
+		t.Log("expected no substitution when Letter is supported")
+		t.Fail()
+	}
+	p = newPaperSubstitution(true, map[string][]string{"alpha": {"A4"}})
+	if _, substitute := p.substitute(&printer, letter); substitute != cdd.MediaSizeISOA4 {
+		t.Log("expected Letter to print on A4 when only A4 is stocked")
+		t.Fail()
+	}
+	p = newPaperSubstitution(true, map[string][]string{"alpha": {"A5"}})
+	if _, substitute := p.substitute(&printer, letter); substitute != "" {
+		t.Log("expected no substitution when neither Letter nor A4 is stocked")
+		t.Fail()
+	}
+}
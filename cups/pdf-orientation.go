@@ -0,0 +1,162 @@
+// Copyright 2015 Google Inc. All rights reserved.
+
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+// +build linux darwin freebsd
+
+package cups
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strconv"
+
+	"github.com/google/cloud-print-connector/cdd"
+)
+
+// pdfOrientationMaxBytes is how much of a PDF to search for pages.
+const pdfOrientationMaxBytes = 16 * 1024 * 1024
+
+var (
+	rPDFMediaBox = regexp.MustCompile(
+		`/MediaBox\s*\[\s*(-?[0-9.]+)\s+(-?[0-9.]+)\s+(-?[0-9.]+)\s+(-?[0-9.]+)\s*\]`)
+	rPDFRotate = regexp.MustCompile(`/Rotate\s+(-?[0-9]+)`)
+
+	rPDFObject    = regexp.MustCompile(`(?s)\b\d+\s+\d+\s+obj\b(.*?)\bendobj\b`)
+	rPDFPageType  = regexp.MustCompile(`/Type\s*/Page\b`)
+	rPDFPagesType = regexp.MustCompile(`/Type\s*/Pages\b`)
+)
+
+// detectPDFOrientation guesses the orientation of the first page of a PDF,
+// from the first MediaBox and Rotate entries in it, and returns the matching
+// orientation-requested value.
+//
+// This is a heuristic: it does not parse the PDF, so it finds nothing when
+// the page objects are compressed into object streams. Returns false when
+// nothing was found, or the page is square.
+func detectPDFOrientation(filename string) (string, bool) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", false
+	}
+	defer f.Close()
+
+	pdf, err := ioutil.ReadAll(io.LimitReader(f, pdfOrientationMaxBytes))
+	if err != nil {
+		return "", false
+	}
+
+	return pdfOrientation(pdf)
+}
+
+func pdfOrientation(pdf []byte) (string, bool) {
+	box := rPDFMediaBox.FindSubmatch(pdf)
+	if box == nil {
+		return "", false
+	}
+	orientation, ok := mediaBoxOrientation(box, rPDFRotate.FindSubmatch(pdf))
+	if !ok {
+		return "", false
+	}
+	return orientationValueByType[orientation], true
+}
+
+// mediaBoxOrientation gets the orientation of a MediaBox match, turned by a
+// Rotate match, which may be nil. Returns false when the box is square.
+func mediaBoxOrientation(box, rotate [][]byte) (cdd.PageOrientationType, bool) {
+	var coords [4]float64
+	for i := range coords {
+		c, err := strconv.ParseFloat(string(box[i+1]), 64)
+		if err != nil {
+			return "", false
+		}
+		coords[i] = c
+	}
+	width, height := coords[2]-coords[0], coords[3]-coords[1]
+	if width < 0 {
+		width = -width
+	}
+	if height < 0 {
+		height = -height
+	}
+
+	if rotate != nil {
+		if degrees, err := strconv.Atoi(string(rotate[1])); err == nil && (degrees/90)%2 != 0 {
+			width, height = height, width
+		}
+	}
+
+	switch {
+	case width > height:
+		return cdd.PageOrientationLandscape, true
+	case height > width:
+		return cdd.PageOrientationPortrait, true
+	default:
+		return "", false
+	}
+}
+
+// detectMixedOrientation tells whether a PDF has both portrait and
+// landscape pages, and which orientation most of its pages have.
+//
+// Like detectPDFOrientation, this is a heuristic that finds nothing when
+// the page objects are compressed.
+func detectMixedOrientation(filename string) (cdd.PageOrientationType, bool) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", false
+	}
+	defer f.Close()
+
+	pdf, err := ioutil.ReadAll(io.LimitReader(f, pdfOrientationMaxBytes))
+	if err != nil {
+		return "", false
+	}
+
+	portrait, landscape := pdfPageOrientations(pdf)
+	if portrait == 0 || landscape == 0 {
+		return "", false
+	}
+	if landscape > portrait {
+		return cdd.PageOrientationLandscape, true
+	}
+	return cdd.PageOrientationPortrait, true
+}
+
+// pdfPageOrientations counts the portrait and landscape pages of a PDF, by
+// the MediaBox and Rotate entries of its page objects. Pages without a
+// MediaBox have that of the first page tree node.
+func pdfPageOrientations(pdf []byte) (int, int) {
+	var inherited [][]byte
+	var pages [][]byte
+	for _, object := range rPDFObject.FindAllSubmatch(pdf, -1) {
+		switch {
+		case rPDFPageType.Match(object[1]):
+			pages = append(pages, object[1])
+		case inherited == nil && rPDFPagesType.Match(object[1]):
+			inherited = rPDFMediaBox.FindSubmatch(object[1])
+		}
+	}
+
+	var portrait, landscape int
+	for _, page := range pages {
+		box := rPDFMediaBox.FindSubmatch(page)
+		if box == nil {
+			box = inherited
+		}
+		if box == nil {
+			continue
+		}
+		switch orientation, _ := mediaBoxOrientation(box, rPDFRotate.FindSubmatch(page)); orientation {
+		case cdd.PageOrientationPortrait:
+			portrait++
+		case cdd.PageOrientationLandscape:
+			landscape++
+		}
+	}
+	return portrait, landscape
+}
@@ -0,0 +1,65 @@
+// Copyright 2015 Google Inc. All rights reserved.
+
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+// +build linux darwin freebsd
+
+package cups
+
+import (
+	"testing"
+)
+
+func TestPDFOrientation(t *testing.T) {
+	testCases := []struct {
+		pdf         string
+		orientation string
+		ok          bool
+	}{
+		{"<< /Type /Page /MediaBox [0 0 612 792] >>", "3", true},
+		{"<< /Type /Page /MediaBox [0 0 842 595.3] >>", "4", true},
+		{"<< /Type /Page /MediaBox[0 0 612 792]/Rotate 90 >>", "4", true},
+		{"<< /Type /Page /MediaBox [0 0 792 612] /Rotate -270 >>", "3", true},
+		{"<< /Type /Page /MediaBox [0 0 792 612] /Rotate 180 >>", "4", true},
+		{"<< /Type /Page /MediaBox [612 792 0 0] >>", "3", true},
+		{"<< /Type /Page /MediaBox [0 0 500 500] >>", "", false},
+		{"<< /Type /ObjStm /N 3 >> stream", "", false},
+	}
+
+	for _, tc := range testCases {
+		orientation, ok := pdfOrientation([]byte(tc.pdf))
+		if orientation != tc.orientation || ok != tc.ok {
+			t.Logf("%s: expected %q %t, got %q %t", tc.pdf, tc.orientation, tc.ok, orientation, ok)
+			t.Fail()
+		}
+	}
+}
+
+func TestPDFPageOrientations(t *testing.T) {
+	testCases := []struct {
+		pdf       string
+		portrait  int
+		landscape int
+	}{
+		{"1 0 obj << /Type /Pages /Kids [2 0 R 3 0 R] /Count 2 /MediaBox [0 0 612 792] >> endobj\n" +
+			"2 0 obj << /Type /Page /Parent 1 0 R >> endobj\n" +
+			"3 0 obj << /Type /Page /Parent 1 0 R /MediaBox [0 0 792 612] >> endobj\n", 1, 1},
+		{"1 0 obj << /Type /Pages /Kids [2 0 R 3 0 R 4 0 R] /Count 3 >> endobj\n" +
+			"2 0 obj << /Type /Page /MediaBox [0 0 842 595] >> endobj\n" +
+			"3 0 obj << /Type/Page/MediaBox[0 0 595 842]/Rotate 90 >> endobj\n" +
+			"4 0 obj << /Type /Page /MediaBox [0 0 595 842] >> endobj\n", 1, 2},
+		{"2 0 obj << /Type /Page /MediaBox [0 0 500 500] >> endobj\n", 0, 0},
+		{"5 0 obj << /Type /ObjStm /N 3 >> stream endstream endobj\n", 0, 0},
+	}
+
+	for _, tc := range testCases {
+		portrait, landscape := pdfPageOrientations([]byte(tc.pdf))
+		if portrait != tc.portrait || landscape != tc.landscape {
+			t.Logf("%s: expected %d portrait and %d landscape pages, got %d and %d",
+				tc.pdf, tc.portrait, tc.landscape, portrait, landscape)
+			t.Fail()
+		}
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright 2015 Google Inc. All rights reserved.
+
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+// +build linux darwin freebsd
+
+package cups
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/cloud-print-connector/cdd"
+	"github.com/google/cloud-print-connector/lib"
+)
+
+// ppdCorpusDir holds real-world PPDs, each with a golden translation
+// next to it, named like the PPD with a .json extension.
+const ppdCorpusDir = "testdata/ppd"
+
+var updateGolden = flag.Bool("update-golden", false,
+	"Rewrite the golden translations in "+ppdCorpusDir+" instead of comparing against them")
+
+// ppdCorpusTranslation is the golden form of translatePPD's results.
+type ppdCorpusTranslation struct {
+	Manufacturer string                         `json:"manufacturer"`
+	Model        string                         `json:"model"`
+	Description  *cdd.PrinterDescriptionSection `json:"description"`
+	DuplexMap    lib.DuplexVendorMap            `json:"duplex_map,omitempty"`
+}
+
+// TestPPDCorpus flags any change to the translation of the PPDs in
+// ppdCorpusDir. When a change is intended, regenerate the golden files by
+// running this test with -update-golden, and review the diff.
+func TestPPDCorpus(t *testing.T) {
+	filenames, err := filepath.Glob(filepath.Join(ppdCorpusDir, "*.ppd"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(filenames) == 0 {
+		t.Fatalf("no PPDs found in %s", ppdCorpusDir)
+	}
+
+	for _, filename := range filenames {
+		ppd, err := ioutil.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var tr ppdCorpusTranslation
+		tr.Description, tr.Manufacturer, tr.Model, tr.DuplexMap, _ = translatePPD(string(ppd), []string{"all"})
+		if err = tr.Description.Validate(); err != nil {
+			t.Errorf("translation of %s is not valid CDD: %s", filename, err)
+		}
+		actual, err := json.MarshalIndent(tr, "", "  ")
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual = append(actual, '\n')
+
+		goldenFilename := strings.TrimSuffix(filename, ".ppd") + ".json"
+		if *updateGolden {
+			if err = ioutil.WriteFile(goldenFilename, actual, 0644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+
+		expected, err := ioutil.ReadFile(goldenFilename)
+		if err != nil {
+			t.Errorf("%s has no golden translation; run with -update-golden: %s", filename, err)
+			continue
+		}
+		if !bytes.Equal(expected, actual) {
+			t.Errorf("translation of %s changed\nexpected\n%s\ngot\n%s", filename, expected, actual)
+		}
+	}
+}
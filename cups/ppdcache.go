@@ -8,16 +8,10 @@
 
 package cups
 
-/*
-#include "cups.h"
-*/
-import "C"
 import (
-	"bytes"
 	"errors"
-	"os"
 	"sync"
-	"unsafe"
+	"time"
 
 	"github.com/google/cloud-print-connector/cdd"
 	"github.com/google/cloud-print-connector/lib"
@@ -28,20 +22,27 @@ import (
 // (1) fetch a PPD to a file
 // (2) indicate whether a PPD file is up-to-date.
 // So, this "cache":
-// (1) maintains temporary file copies of PPDs for each printer
-// (2) updates those PPD files as necessary
+// (1) maintains translated copies of PPDs for each printer
+// (2) updates those copies as necessary
 type ppdCache struct {
-	cc               *cupsCore
+	cc               cupsClient
 	vendorPPDOptions []string
-	cache            map[string]*ppdCacheEntry
-	cacheMutex       sync.RWMutex
+	// maxBytes is the size of the largest PPD to translate; zero is no limit.
+	maxBytes int64
+	// throttle slows the translation of new and changed PPDs while the
+	// system is busy; nil never throttles.
+	throttle   *lib.LoadThrottle
+	cache      map[string]*ppdCacheEntry
+	cacheMutex sync.RWMutex
 }
 
-func newPPDCache(cc *cupsCore, vendorPPDOptions []string) *ppdCache {
+func newPPDCache(cc cupsClient, vendorPPDOptions []string, maxBytes int64, throttle *lib.LoadThrottle) *ppdCache {
 	cache := make(map[string]*ppdCacheEntry)
 	pc := ppdCache{
 		cc:               cc,
 		vendorPPDOptions: vendorPPDOptions,
+		maxBytes:         maxBytes,
+		throttle:         throttle,
 		cache:            cache,
 	}
 	return &pc
@@ -51,8 +52,7 @@ func (pc *ppdCache) quit() {
 	pc.cacheMutex.Lock()
 	defer pc.cacheMutex.Unlock()
 
-	for printername, pce := range pc.cache {
-		pce.free()
+	for printername := range pc.cache {
 		delete(pc.cache, printername)
 	}
 }
@@ -62,13 +62,10 @@ func (pc *ppdCache) removePPD(printername string) {
 	pc.cacheMutex.Lock()
 	defer pc.cacheMutex.Unlock()
 
-	if pce, exists := pc.cache[printername]; exists {
-		pce.free()
-		delete(pc.cache, printername)
-	}
+	delete(pc.cache, printername)
 }
 
-func (pc *ppdCache) getPPDCacheEntry(printername string) (*cdd.PrinterDescriptionSection, string, string, lib.DuplexVendorMap, error) {
+func (pc *ppdCache) getPPDCacheEntry(printername string) (*cdd.PrinterDescriptionSection, string, string, lib.DuplexVendorMap, lib.MarginsVendorMap, error) {
 	pc.cacheMutex.RLock()
 	pce, exists := pc.cache[printername]
 	pc.cacheMutex.RUnlock()
@@ -76,54 +73,51 @@ func (pc *ppdCache) getPPDCacheEntry(printername string) (*cdd.PrinterDescriptio
 	if !exists {
 		pce, err := createPPDCacheEntry(printername)
 		if err != nil {
-			return nil, "", "", nil, err
+			return nil, "", "", nil, nil, err
 		}
-		if err = pce.refresh(pc.cc, pc.vendorPPDOptions); err != nil {
-			pce.free()
-			return nil, "", "", nil, err
+		if err = pce.refresh(pc.cc, pc.vendorPPDOptions, pc.maxBytes, pc.throttle); err != nil {
+			return nil, "", "", nil, nil, err
 		}
 
 		pc.cacheMutex.Lock()
 		defer pc.cacheMutex.Unlock()
 
-		if firstPCE, exists := pc.cache[printername]; exists {
-			// Two entries were created at the same time. Remove the older one.
-			delete(pc.cache, printername)
-			go firstPCE.free()
-		}
+		// If two entries were created at the same time, this replaces the older one.
 		pc.cache[printername] = pce
-		description, manufacturer, model, duplexMap := pce.getFields()
-		return &description, manufacturer, model, duplexMap, nil
+		description, manufacturer, model, duplexMap, marginsMap := pce.getFields()
+		return &description, manufacturer, model, duplexMap, marginsMap, nil
 
 	} else {
-		if err := pce.refresh(pc.cc, pc.vendorPPDOptions); err != nil {
-			delete(pc.cache, printername)
-			pce.free()
-			return nil, "", "", nil, err
+		if err := pce.refresh(pc.cc, pc.vendorPPDOptions, pc.maxBytes, pc.throttle); err != nil {
+			pc.cacheMutex.Lock()
+			if pc.cache[printername] == pce {
+				delete(pc.cache, printername)
+			}
+			pc.cacheMutex.Unlock()
+			return nil, "", "", nil, nil, err
 		}
-		description, manufacturer, model, duplexMap := pce.getFields()
-		return &description, manufacturer, model, duplexMap, nil
+		description, manufacturer, model, duplexMap, marginsMap := pce.getFields()
+		return &description, manufacturer, model, duplexMap, marginsMap, nil
 	}
 }
 
-// Holds persistent data needed for calling C.cupsGetPPD3.
+// Holds persistent data needed for calling cupsClient.getPPD.
 type ppdCacheEntry struct {
-	printername  *C.char
-	modtime      C.time_t
+	printername  string
+	modtime      time.Time
 	description  cdd.PrinterDescriptionSection
 	manufacturer string
 	model        string
 	duplexMap    lib.DuplexVendorMap
+	marginsMap   lib.MarginsVendorMap
 	mutex        sync.Mutex
 }
 
 // createPPDCacheEntry creates an instance of ppdCache with the name field set,
-// all else empty. The caller must free the name and buffer fields with
-// ppdCacheEntry.free()
+// all else empty.
 func createPPDCacheEntry(name string) (*ppdCacheEntry, error) {
 	pce := &ppdCacheEntry{
-		printername: C.CString(name),
-		modtime:     C.time_t(0),
+		printername: name,
 	}
 
 	return pce, nil
@@ -131,56 +125,36 @@ func createPPDCacheEntry(name string) (*ppdCacheEntry, error) {
 
 // getFields gets externally-interesting fields from this ppdCacheEntry under
 // a lock. The description is passed as a value (copy), to protect the cached copy.
-func (pce *ppdCacheEntry) getFields() (cdd.PrinterDescriptionSection, string, string, lib.DuplexVendorMap) {
-	pce.mutex.Lock()
-	defer pce.mutex.Unlock()
-	return pce.description, pce.manufacturer, pce.model, pce.duplexMap
-}
-
-// free frees the memory that stores the name and buffer fields, and deletes
-// the file named by the buffer field. If the file doesn't exist, no error is
-// returned.
-func (pce *ppdCacheEntry) free() {
+func (pce *ppdCacheEntry) getFields() (cdd.PrinterDescriptionSection, string, string, lib.DuplexVendorMap, lib.MarginsVendorMap) {
 	pce.mutex.Lock()
 	defer pce.mutex.Unlock()
-
-	C.free(unsafe.Pointer(pce.printername))
+	return pce.description, pce.manufacturer, pce.model, pce.duplexMap, pce.marginsMap
 }
 
-// refresh calls cupsGetPPD3() to refresh this PPD information, in
-// case CUPS has a new PPD for the printer.
-func (pce *ppdCacheEntry) refresh(cc *cupsCore, vendorPPDOptions []string) error {
+// refresh calls cupsClient.getPPD to refresh this PPD information, in
+// case CUPS has a new PPD for the printer. A PPD larger than maxBytes, or
+// that isn't a PPD, isn't translated. A new PPD waits for throttle before
+// it's translated.
+func (pce *ppdCacheEntry) refresh(cc cupsClient, vendorPPDOptions []string, maxBytes int64, throttle *lib.LoadThrottle) error {
 	pce.mutex.Lock()
 	defer pce.mutex.Unlock()
 
-	ppdFilename, err := cc.getPPD(pce.printername, &pce.modtime)
+	ppd, err := cc.getPPD(pce.printername, &pce.modtime)
 	if err != nil {
 		return err
 	}
 
-	if ppdFilename == nil {
+	if ppd == "" {
 		// Cache hit.
 		return nil
 	}
 
 	// (else) Cache miss.
-	defer C.free(unsafe.Pointer(ppdFilename))
-	defer os.Remove(C.GoString(ppdFilename))
-
-	// Read from CUPS temporary file.
-	r, err := os.Open(C.GoString(ppdFilename))
-	if err != nil {
+	if err = validatePPD(ppd, maxBytes); err != nil {
 		return err
 	}
-	defer r.Close()
-
-	// Write to a buffer string for translation.
-	var w bytes.Buffer
-	if _, err := w.ReadFrom(r); err != nil {
-		return err
-	}
-
-	description, manufacturer, model, duplexMap := translatePPD(w.String(), vendorPPDOptions)
+	throttle.Wait()
+	description, manufacturer, model, duplexMap, marginsMap := translatePPD(ppd, vendorPPDOptions)
 	if description == nil || manufacturer == "" || model == "" {
 		return errors.New("Failed to parse PPD")
 	}
@@ -189,6 +163,7 @@ func (pce *ppdCacheEntry) refresh(cc *cupsCore, vendorPPDOptions []string) error
 	pce.manufacturer = manufacturer
 	pce.model = model
 	pce.duplexMap = duplexMap
+	pce.marginsMap = marginsMap
 
 	return nil
 }
@@ -0,0 +1,60 @@
+// Copyright 2015 Google Inc. All rights reserved.
+
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+// +build linux darwin freebsd
+
+package cups
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/cloud-print-connector/lib"
+)
+
+// printerCacheTTL is how long callers other than the printer sync share
+// one CUPS-Get-Printers response.
+const printerCacheTTL = 10 * time.Second
+
+// printerCache holds a recent printer list. The mutex is held while the
+// list is fetched, so that concurrent callers wait for one fetch rather
+// than make their own.
+type printerCache struct {
+	ttl      time.Duration
+	printers []lib.Printer
+	fetched  time.Time
+	mutex    sync.Mutex
+}
+
+func newPrinterCache(ttl time.Duration) *printerCache {
+	return &printerCache{ttl: ttl}
+}
+
+// get returns the cached printers, or calls fetch when they are older than
+// the TTL.
+func (pc *printerCache) get(fetch func() ([]lib.Printer, error)) ([]lib.Printer, error) {
+	pc.mutex.Lock()
+	defer pc.mutex.Unlock()
+
+	if pc.printers != nil && time.Since(pc.fetched) < pc.ttl {
+		return pc.printers, nil
+	}
+
+	printers, err := fetch()
+	if err != nil {
+		return nil, err
+	}
+	pc.printers, pc.fetched = printers, time.Now()
+	return printers, nil
+}
+
+// invalidate makes the next get fetch.
+func (pc *printerCache) invalidate() {
+	pc.mutex.Lock()
+	defer pc.mutex.Unlock()
+
+	pc.printers = nil
+}
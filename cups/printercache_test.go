@@ -0,0 +1,68 @@
+// Copyright 2015 Google Inc. All rights reserved.
+
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+// +build linux darwin freebsd
+
+package cups
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/cloud-print-connector/lib"
+)
+
+func TestPrinterCache(t *testing.T) {
+	pc := newPrinterCache(time.Hour)
+
+	var fetches int
+	var fetchesMutex sync.Mutex
+	fetch := func() ([]lib.Printer, error) {
+		fetchesMutex.Lock()
+		defer fetchesMutex.Unlock()
+		fetches++
+		time.Sleep(10 * time.Millisecond)
+		return []lib.Printer{lib.Printer{Name: "p"}}, nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if printers, err := pc.get(fetch); err != nil || len(printers) != 1 {
+				t.Logf("expected one printer, got %v, %v", printers, err)
+				t.Fail()
+			}
+		}()
+	}
+	wg.Wait()
+	if fetches != 1 {
+		t.Logf("expected concurrent gets to share 1 fetch, got %d", fetches)
+		t.Fail()
+	}
+
+	pc.invalidate()
+	pc.get(fetch)
+	if fetches != 2 {
+		t.Logf("expected a get after invalidate to fetch, got %d fetches", fetches)
+		t.Fail()
+	}
+
+	pc = newPrinterCache(0)
+	if _, err := pc.get(func() ([]lib.Printer, error) { return nil, errors.New("down") }); err == nil {
+		t.Log("expected a failed fetch to fail")
+		t.Fail()
+	}
+	pc.get(fetch)
+	pc.get(fetch)
+	if fetches != 4 {
+		t.Logf("expected each get of an expired cache to fetch, got %d fetches", fetches)
+		t.Fail()
+	}
+}
@@ -0,0 +1,273 @@
+// Copyright 2015 Google Inc. All rights reserved.
+
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+// +build linux darwin freebsd
+
+package cups
+
+import (
+	"regexp"
+	"strconv"
+
+	"github.com/google/cloud-print-connector/cdd"
+)
+
+// pwgMediaSizeTolerance is how far, in microns, a page size may be from a
+// standard size and still be called by the standard name. Page sizes in
+// PPDs are usually given in whole points, which are about 350 microns.
+const pwgMediaSizeTolerance = 1000
+
+// rPWGMediaName matches PWG 5100.1 self-describing media names, like
+// iso_a4_210x297mm and na_number-10_4.125x9.5in.
+var rPWGMediaName = regexp.MustCompile(`^([a-z0-9]+)_([a-z0-9.-]+)_([\d.]+)x([\d.]+)(mm|in)$`)
+
+// pwgMediaSize is a standard media size from PWG 5100.1.
+type pwgMediaSize struct {
+	name          cdd.MediaSizeName
+	widthMicrons  int32
+	heightMicrons int32
+}
+
+// pwgMediaNames maps the PWG 5100.1 self-describing names to CDD names.
+// When two standard sizes are within pwgMediaSizeTolerance of each other,
+// the first one listed wins a lookup by dimensions.
+var pwgMediaNames = []struct {
+	pwgName string
+	name    cdd.MediaSizeName
+}{
+	{"na_index-3x5_3x5in", cdd.MediaSizeNAIndex3x5},
+	{"na_personal_3.625x6.5in", cdd.MediaSizeNAPersonal},
+	{"na_monarch_3.875x7.5in", cdd.MediaSizeNAMonarch},
+	{"na_number-9_3.875x8.875in", cdd.MediaSizeNANumber9},
+	{"na_index-4x6_4x6in", cdd.MediaSizeNAIndex4x6},
+	{"na_number-10_4.125x9.5in", cdd.MediaSizeNANumber10},
+	{"na_a2_4.375x5.75in", cdd.MediaSizeNAA2},
+	{"na_number-11_4.5x10.375in", cdd.MediaSizeNANumber11},
+	{"na_number-12_4.75x11in", cdd.MediaSizeNANumber12},
+	{"na_5x7_5x7in", cdd.MediaSizeNA5x7},
+	{"na_index-5x8_5x8in", cdd.MediaSizeNAIndex5x8},
+	{"na_number-14_5x11.5in", cdd.MediaSizeNANumber14},
+	{"na_invoice_5.5x8.5in", cdd.MediaSizeNAInvoice},
+	{"na_index-4x6-ext_6x8in", cdd.MediaSizeNAIndex4x6Ext},
+	{"na_6x9_6x9in", cdd.MediaSizeNA6x9},
+	{"na_c5_6.5x9.5in", cdd.MediaSizeNAC5},
+	{"na_7x9_7x9in", cdd.MediaSizeNA7x9},
+	{"na_executive_7.25x10.5in", cdd.MediaSizeNAExecutive},
+	{"na_govt-letter_8x10in", cdd.MediaSizeNAGovtLetter},
+	{"na_govt-legal_8x13in", cdd.MediaSizeNAGovtLegal},
+	{"na_quarto_8.5x10.83in", cdd.MediaSizeNAQuarto},
+	{"na_letter_8.5x11in", cdd.MediaSizeNALetter},
+	{"na_fanfold-eur_8.5x12in", cdd.MediaSizeNAFanfoldEur},
+	{"na_letter-plus_8.5x12.69in", cdd.MediaSizeNALetterPlus},
+	{"na_foolscap_8.5x13in", cdd.MediaSizeNAFoolscap},
+	{"na_legal_8.5x14in", cdd.MediaSizeNALegal},
+	{"na_super-a_8.94x14in", cdd.MediaSizeNASuperA},
+	{"na_9x11_9x11in", cdd.MediaSizeNA9x11},
+	{"na_arch-a_9x12in", cdd.MediaSizeNAArchA},
+	{"na_letter-extra_9.5x12in", cdd.MediaSizeNALetterExtra},
+	{"na_legal-extra_9.5x15in", cdd.MediaSizeNALegalExtra},
+	{"na_10x11_10x11in", cdd.MediaSizeNA10x11},
+	{"na_10x13_10x13in", cdd.MediaSizeNA10x13},
+	{"na_10x14_10x14in", cdd.MediaSizeNA10x14},
+	{"na_10x15_10x15in", cdd.MediaSizeNA10x15},
+	{"na_11x12_11x12in", cdd.MediaSizeNA11x12},
+	{"na_edp_11x14in", cdd.MediaSizeNAEDP},
+	{"na_fanfold-us_11x14.875in", cdd.MediaSizeNAFanfoldUS},
+	{"na_11x15_11x15in", cdd.MediaSizeNA11x15},
+	{"na_ledger_11x17in", cdd.MediaSizeNALedger},
+	{"na_eur-edp_12x14in", cdd.MediaSizeNAEurEDP},
+	{"na_arch-b_12x18in", cdd.MediaSizeNAArchB},
+	{"na_12x19_12x19in", cdd.MediaSizeNA12x19},
+	{"na_b-plus_12x19.17in", cdd.MediaSizeNABPlus},
+	{"na_super-b_13x19in", cdd.MediaSizeNASuperB},
+	{"na_c_17x22in", cdd.MediaSizeNAC},
+	{"na_arch-c_18x24in", cdd.MediaSizeNAArchC},
+	{"na_d_22x34in", cdd.MediaSizeNAD},
+	{"na_arch-d_24x36in", cdd.MediaSizeNAArchD},
+	{"asme_f_28x40in", cdd.MediaSizeNAAsmeF},
+	{"na_wide-format_30x42in", cdd.MediaSizeNAWideFormat},
+	{"na_e_34x44in", cdd.MediaSizeNAE},
+	{"na_arch-e_36x48in", cdd.MediaSizeNAArchE},
+	{"na_f_44x68in", cdd.MediaSizeNAF},
+
+	{"iso_a10_26x37mm", cdd.MediaSizeISOA10},
+	{"iso_a9_37x52mm", cdd.MediaSizeISOA9},
+	{"iso_a8_52x74mm", cdd.MediaSizeISOA8},
+	{"iso_a7_74x105mm", cdd.MediaSizeISOA7},
+	{"iso_a6_105x148mm", cdd.MediaSizeISOA6},
+	{"iso_a5_148x210mm", cdd.MediaSizeISOA5},
+	{"iso_a5-extra_174x235mm", cdd.MediaSizeISOA5Extra},
+	{"iso_a4_210x297mm", cdd.MediaSizeISOA4},
+	{"iso_a4-tab_225x297mm", cdd.MediaSizeISOA4Tab},
+	{"iso_a4-extra_235.5x322.3mm", cdd.MediaSizeISOA4Extra},
+	{"iso_a3_297x420mm", cdd.MediaSizeISOA3},
+	{"iso_a4x3_297x630mm", cdd.MediaSizeISOA4x3},
+	{"iso_a4x4_297x841mm", cdd.MediaSizeISOA4x4},
+	{"iso_a4x5_297x1051mm", cdd.MediaSizeISOA4x5},
+	{"iso_a4x6_297x1261mm", cdd.MediaSizeISOA4x6},
+	{"iso_a4x7_297x1471mm", cdd.MediaSizeISOA4x7},
+	{"iso_a4x8_297x1682mm", cdd.MediaSizeISOA4x8},
+	{"iso_a4x9_297x1892mm", cdd.MediaSizeISOA4x9},
+	{"iso_a3-extra_322x445mm", cdd.MediaSizeISOA3Extra},
+	{"iso_a2_420x594mm", cdd.MediaSizeISOA2},
+	{"iso_a3x3_420x891mm", cdd.MediaSizeISOA3x3},
+	{"iso_a3x4_420x1189mm", cdd.MediaSizeISOA3x4},
+	{"iso_a3x5_420x1486mm", cdd.MediaSizeISOA3x5},
+	{"iso_a3x6_420x1783mm", cdd.MediaSizeISOA3x6},
+	{"iso_a3x7_420x2080mm", cdd.MediaSizeISOA3x7},
+	{"iso_a1_594x841mm", cdd.MediaSizeISOA1},
+	{"iso_a2x3_594x1261mm", cdd.MediaSizeISOA2x3},
+	{"iso_a2x4_594x1682mm", cdd.MediaSizeISOA2x4},
+	{"iso_a2x5_594x2102mm", cdd.MediaSizeISOA2x5},
+	{"iso_a0_841x1189mm", cdd.MediaSizeISOA0},
+	{"iso_a1x3_841x1783mm", cdd.MediaSizeISOA1x3},
+	{"iso_a1x4_841x2378mm", cdd.MediaSizeISOA1x4},
+	{"iso_2a0_1189x1682mm", cdd.MediaSizeISO2A0},
+	{"iso_a0x3_1189x2523mm", cdd.MediaSizeISOA0x3},
+	{"iso_b10_31x44mm", cdd.MediaSizeISOB10},
+	{"iso_b9_44x62mm", cdd.MediaSizeISOB9},
+	{"iso_b8_62x88mm", cdd.MediaSizeISOB8},
+	{"iso_b7_88x125mm", cdd.MediaSizeISOB7},
+	{"iso_b6_125x176mm", cdd.MediaSizeISOB6},
+	{"iso_b6c4_125x324mm", cdd.MediaSizeISOB6C4},
+	{"iso_b5_176x250mm", cdd.MediaSizeISOB5},
+	{"iso_b5-extra_201x276mm", cdd.MediaSizeISOB5Extra},
+	{"iso_b4_250x353mm", cdd.MediaSizeISOB4},
+	{"iso_b3_353x500mm", cdd.MediaSizeISOB3},
+	{"iso_b2_500x707mm", cdd.MediaSizeISOB2},
+	{"iso_b1_707x1000mm", cdd.MediaSizeISOB1},
+	{"iso_b0_1000x1414mm", cdd.MediaSizeISOB0},
+	{"iso_c10_28x40mm", cdd.MediaSizeISOC10},
+	{"iso_c9_40x57mm", cdd.MediaSizeISOC9},
+	{"iso_c8_57x81mm", cdd.MediaSizeISOC8},
+	{"iso_c7_81x114mm", cdd.MediaSizeISOC7},
+	{"iso_c7c6_81x162mm", cdd.MediaSizeISOC7c6},
+	{"iso_c6_114x162mm", cdd.MediaSizeISOC6},
+	{"iso_c6c5_114x229mm", cdd.MediaSizeISOC6c5},
+	{"iso_c5_162x229mm", cdd.MediaSizeISOC5},
+	{"iso_c4_229x324mm", cdd.MediaSizeISOC4},
+	{"iso_c3_324x458mm", cdd.MediaSizeISOC3},
+	{"iso_c2_458x648mm", cdd.MediaSizeISOC2},
+	{"iso_c1_648x917mm", cdd.MediaSizeISOC1},
+	{"iso_c0_917x1297mm", cdd.MediaSizeISOC0},
+	{"iso_dl_110x220mm", cdd.MediaSizeISODL},
+	{"iso_ra2_430x610mm", cdd.MediaSizeISORA2},
+	{"iso_sra2_450x640mm", cdd.MediaSizeISOSRA2},
+	{"iso_ra1_610x860mm", cdd.MediaSizeISORA1},
+	{"iso_sra1_640x900mm", cdd.MediaSizeISOSRA1},
+	{"iso_ra0_860x1220mm", cdd.MediaSizeISORA0},
+	{"iso_sra0_900x1280mm", cdd.MediaSizeISOSRA0},
+
+	{"jis_b10_32x45mm", cdd.MediaSizeJISB10},
+	{"jis_b9_45x64mm", cdd.MediaSizeJISB9},
+	{"jis_b8_64x91mm", cdd.MediaSizeJISB8},
+	{"jis_b7_91x128mm", cdd.MediaSizeJISB7},
+	{"jis_b6_128x182mm", cdd.MediaSizeJISB6},
+	{"jis_b5_182x257mm", cdd.MediaSizeJISB5},
+	{"jis_b4_257x364mm", cdd.MediaSizeJISB4},
+	{"jis_b3_364x515mm", cdd.MediaSizeJISB3},
+	{"jis_b2_515x728mm", cdd.MediaSizeJISB2},
+	{"jis_b1_728x1030mm", cdd.MediaSizeJISB1},
+	{"jis_b0_1030x1456mm", cdd.MediaSizeJISB0},
+	{"jis_exec_216x330mm", cdd.MediaSizeJISExec},
+	{"jpn_chou4_90x205mm", cdd.MediaSizeJPNChou4},
+	{"jpn_hagaki_100x148mm", cdd.MediaSizeJPNHagaki},
+	{"jpn_you4_105x235mm", cdd.MediaSizeJPNYou4},
+	{"jpn_chou2_111.1x146mm", cdd.MediaSizeJPNChou2},
+	{"jpn_chou3_120x235mm", cdd.MediaSizeJPNChou3},
+	{"jpn_oufuku_148x200mm", cdd.MediaSizeJPNOufuku},
+	{"jpn_kahu_240x322.1mm", cdd.MediaSizeJPNKahu},
+	{"jpn_kaku2_240x332mm", cdd.MediaSizeJPNKaku2},
+
+	{"prc_32k_97x151mm", cdd.MediaSizePRC32k},
+	{"prc_1_102x165mm", cdd.MediaSizePRC1},
+	{"prc_2_102x176mm", cdd.MediaSizePRC2},
+	{"prc_4_110x208mm", cdd.MediaSizePRC4},
+	{"prc_5_110x220mm", cdd.MediaSizePRC5},
+	{"prc_8_120x309mm", cdd.MediaSizePRC8},
+	{"prc_6_120x230mm", cdd.MediaSizePRC6},
+	{"prc_3_125x176mm", cdd.MediaSizePRC3},
+	{"prc_16k_146x215mm", cdd.MediaSizePRC16k},
+	{"prc_7_160x230mm", cdd.MediaSizePRC7},
+	{"prc_10_324x458mm", cdd.MediaSizePRC10},
+	{"roc_16k_7.75x10.75in", cdd.MediaSizeROC16k},
+	{"roc_8k_10.75x15.5in", cdd.MediaSizeROC8k},
+
+	{"om_juuro-ku-kai_198x275mm", cdd.MediaSizeOMJuuroKuKai},
+	{"om_pa-kai_267x389mm", cdd.MediaSizeOMPaKai},
+	{"om_dai-pa-kai_275x395mm", cdd.MediaSizeOMDaiPaKai},
+	{"om_small-photo_100x150mm", cdd.MediaSizeOMSmallPhoto},
+	{"om_italian_110x230mm", cdd.MediaSizeOMItalian},
+	{"om_postfix_114x229mm", cdd.MediaSizeOMPostfix},
+	{"om_large-photo_200x300mm", cdd.MediaSizeOMLargePhoto},
+	{"om_folio_210x330mm", cdd.MediaSizeOMFolio},
+	{"om_folio-sp_215x315mm", cdd.MediaSizeOMFolioSP},
+	{"om_invite_220x220mm", cdd.MediaSizeOMInvite},
+}
+
+var (
+	// pwgMediaSizes holds pwgMediaNames with dimensions, in the same order.
+	pwgMediaSizes []pwgMediaSize
+	// pwgMediaSizesByName indexes pwgMediaSizes by self-describing name.
+	pwgMediaSizesByName map[string]pwgMediaSize
+)
+
+func init() {
+	pwgMediaSizes = make([]pwgMediaSize, 0, len(pwgMediaNames))
+	pwgMediaSizesByName = make(map[string]pwgMediaSize, len(pwgMediaNames))
+	for _, n := range pwgMediaNames {
+		width, height, ok := pwgMediaNameDimensions(n.pwgName)
+		if !ok {
+			panic("malformed PWG media name " + n.pwgName)
+		}
+		s := pwgMediaSize{n.name, width, height}
+		pwgMediaSizes = append(pwgMediaSizes, s)
+		pwgMediaSizesByName[n.pwgName] = s
+	}
+}
+
+// pwgMediaNameDimensions gets the width and height, in microns, from a PWG
+// self-describing media name.
+func pwgMediaNameDimensions(pwgName string) (int32, int32, bool) {
+	found := rPWGMediaName.FindStringSubmatch(pwgName)
+	if found == nil {
+		return 0, 0, false
+	}
+
+	width, err := strconv.ParseFloat(found[3], 32)
+	if err != nil {
+		return 0, 0, false
+	}
+	height, err := strconv.ParseFloat(found[4], 32)
+	if err != nil {
+		return 0, 0, false
+	}
+
+	if found[5] == "mm" {
+		return mmToMicrons(float32(width)), mmToMicrons(float32(height)), true
+	}
+	return inchesToMicrons(float32(width)), inchesToMicrons(float32(height)), true
+}
+
+// pwgMediaSizeForDimensions finds the standard media size that matches a
+// width and height, in microns. Only portrait orientation matches, so that
+// a rotated page size keeps its own dimensions.
+func pwgMediaSizeForDimensions(widthMicrons, heightMicrons int32) (pwgMediaSize, bool) {
+	for _, s := range pwgMediaSizes {
+		if absInt32(s.widthMicrons-widthMicrons) <= pwgMediaSizeTolerance &&
+			absInt32(s.heightMicrons-heightMicrons) <= pwgMediaSizeTolerance {
+			return s, true
+		}
+	}
+	return pwgMediaSize{}, false
+}
+
+func absInt32(i int32) int32 {
+	if i < 0 {
+		return -i
+	}
+	return i
+}
@@ -0,0 +1,58 @@
+// Copyright 2015 Google Inc. All rights reserved.
+
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+// +build linux darwin freebsd
+
+package cups
+
+import (
+	"testing"
+
+	"github.com/google/cloud-print-connector/cdd"
+)
+
+// TestPPDMediaSizesMatchPWG checks that the well-known PPD page sizes agree
+// with the PWG standard sizes of the same name.
+func TestPPDMediaSizesMatchPWG(t *testing.T) {
+	byName := make(map[cdd.MediaSizeName]pwgMediaSize, len(pwgMediaSizes))
+	for _, s := range pwgMediaSizes {
+		byName[s.name] = s
+	}
+
+	for keyword, o := range ppdMediaSizes {
+		if o.Name == cdd.MediaSizeCustom {
+			continue
+		}
+		s, exists := byName[o.Name]
+		if !exists {
+			t.Errorf("PageSize %s is %s, which has no PWG name", keyword, o.Name)
+			continue
+		}
+		if absInt32(s.widthMicrons-o.WidthMicrons) > pwgMediaSizeTolerance ||
+			absInt32(s.heightMicrons-o.HeightMicrons) > pwgMediaSizeTolerance {
+			t.Errorf("PageSize %s is %dx%d, but %s is %dx%d",
+				keyword, o.WidthMicrons, o.HeightMicrons, s.name, s.widthMicrons, s.heightMicrons)
+		}
+	}
+}
+
+func TestPWGMediaSizeForDimensions(t *testing.T) {
+	s, ok := pwgMediaSizeForDimensions(pointsToMicrons(612), pointsToMicrons(792))
+	if !ok || s.name != cdd.MediaSizeNALetter {
+		t.Errorf("expected %s, got %+v", cdd.MediaSizeNALetter, s)
+	}
+
+	// Landscape A4 is not A4.
+	if s, ok = pwgMediaSizeForDimensions(mmToMicrons(297), mmToMicrons(210)); ok {
+		t.Errorf("expected no match for landscape A4, got %+v", s)
+	}
+
+	// ISO DL and PRC 5 are the same size; ISO wins.
+	s, ok = pwgMediaSizeForDimensions(mmToMicrons(110), mmToMicrons(220))
+	if !ok || s.name != cdd.MediaSizeISODL {
+		t.Errorf("expected %s, got %+v", cdd.MediaSizeISODL, s)
+	}
+}
@@ -0,0 +1,244 @@
+// Copyright 2015 Google Inc. All rights reserved.
+
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+// +build linux darwin freebsd
+
+package cups
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+
+	"github.com/google/cloud-print-connector/cdd"
+	"github.com/google/cloud-print-connector/lib"
+)
+
+// quirk fixes the printers whose printer-make-and-model matches
+// MakeAndModel, to work around drivers that misbehave. A quirk has either
+// an Attribute or an Option.
+//
+// An Attribute quirk rewrites an IPP attribute before it is translated.
+// When JoinSpaced is set, each value that starts with a space is joined to
+// the value before it with a comma, undoing CUPS splitting a value at its
+// comma. When Match is set, each value of the attribute that Match matches
+// is replaced by Replace, which may refer to submatches like $1. Otherwise
+// the attribute's values are replaced by Values.
+//
+// An Option quirk fixes the capability and job option of a PPD option.
+// DropChoices are removed from the capability, and from jobs. Default
+// becomes the capability's default, and the option of jobs that don't
+// choose one. Rename is the key that jobs send the option to CUPS as.
+type quirk struct {
+	MakeAndModel string `json:"make_and_model"`
+
+	Attribute  string   `json:"attribute,omitempty"`
+	JoinSpaced bool     `json:"join_spaced,omitempty"`
+	Match      string   `json:"match,omitempty"`
+	Replace    string   `json:"replace,omitempty"`
+	Values     []string `json:"values,omitempty"`
+
+	Option      string   `json:"option,omitempty"`
+	DropChoices []string `json:"drop_choices,omitempty"`
+	Default     string   `json:"default,omitempty"`
+	Rename      string   `json:"rename,omitempty"`
+
+	makeAndModel *regexp.Regexp
+	match        *regexp.Regexp
+}
+
+// builtinQuirks apply to every printer, before the quirks from the quirks
+// file.
+var builtinQuirks = []quirk{
+	// Some drivers name markers with a comma, like "Black, Reorder Part
+	// #12345", which CUPS splits into two values.
+	quirk{
+		Attribute:  attrMarkerNames,
+		JoinSpaced: true,
+	},
+	quirk{
+		Attribute:  attrMarkerTypes,
+		JoinSpaced: true,
+	},
+}
+
+// quirks is a table of driver quirks.
+type quirks []quirk
+
+// newQuirks compiles builtinQuirks, followed by the quirks in filename, a
+// JSON array of quirk objects. An empty filename reads no file.
+func newQuirks(filename string) (quirks, error) {
+	q := append(quirks{}, builtinQuirks...)
+
+	if filename != "" {
+		b, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		var fileQuirks []quirk
+		if err = json.Unmarshal(b, &fileQuirks); err != nil {
+			return nil, fmt.Errorf("Failed to parse quirks file %s: %s", filename, err)
+		}
+		q = append(q, fileQuirks...)
+	}
+
+	for i := range q {
+		if (q[i].Attribute == "") == (q[i].Option == "") {
+			return nil, fmt.Errorf("Quirk %d needs either an attribute or an option", i)
+		}
+		var err error
+		if q[i].makeAndModel, err = regexp.Compile(q[i].MakeAndModel); err != nil {
+			return nil, fmt.Errorf("Quirk %d make_and_model is not valid: %s", i, err)
+		}
+		if q[i].Match != "" {
+			if q[i].match, err = regexp.Compile(q[i].Match); err != nil {
+				return nil, fmt.Errorf("Quirk %d match is not valid: %s", i, err)
+			}
+		}
+	}
+
+	return q, nil
+}
+
+// applyAttributes rewrites the attributes of one printer by the attribute
+// quirks that match it.
+func (q quirks) applyAttributes(attributes map[string][]string) {
+	var makeAndModel string
+	if v := attributes[attrPrinterMakeAndModel]; len(v) > 0 {
+		makeAndModel = v[0]
+	}
+
+	for _, quirk := range q {
+		if quirk.Attribute == "" || !quirk.makeAndModel.MatchString(makeAndModel) {
+			continue
+		}
+		if quirk.match == nil && !quirk.JoinSpaced {
+			attributes[quirk.Attribute] = append([]string{}, quirk.Values...)
+			continue
+		}
+		values, exists := attributes[quirk.Attribute]
+		if !exists {
+			continue
+		}
+		if quirk.JoinSpaced {
+			attributes[quirk.Attribute] = joinSpacedValues(values)
+			continue
+		}
+		newValues := make([]string, len(values))
+		for i, v := range values {
+			newValues[i] = quirk.match.ReplaceAllString(v, quirk.Replace)
+		}
+		attributes[quirk.Attribute] = newValues
+	}
+}
+
+// joinSpacedValues joins each value that starts with a space to the value
+// before it, with a comma.
+func joinSpacedValues(values []string) []string {
+	var newValues []string
+	for i := range values {
+		if i > 0 && len(values[i]) > 1 && values[i][0] == ' ' {
+			newValues[len(newValues)-1] = newValues[len(newValues)-1] + "," + values[i]
+		} else {
+			newValues = append(newValues, values[i])
+		}
+	}
+	return newValues
+}
+
+// applyCapabilities fixes the vendor capabilities of one printer by the
+// option quirks that match it. The capabilities may be shared with the PPD
+// cache, so they are copied rather than changed.
+func (q quirks) applyCapabilities(printer *lib.Printer) {
+	if printer.Description == nil || printer.Description.VendorCapability == nil {
+		return
+	}
+	makeAndModel := printer.Tags[attrPrinterMakeAndModel]
+
+	for _, quirk := range q {
+		if quirk.Option == "" || !quirk.makeAndModel.MatchString(makeAndModel) {
+			continue
+		}
+
+		vcs := make([]cdd.VendorCapability, 0, len(*printer.Description.VendorCapability))
+		for _, vc := range *printer.Description.VendorCapability {
+			if vc.ID == quirk.Option && vc.SelectCap != nil {
+				vc.SelectCap = quirk.fixSelectCapability(vc.SelectCap)
+				if vc.SelectCap == nil {
+					// Every choice is broken.
+					continue
+				}
+			}
+			vcs = append(vcs, vc)
+		}
+		printer.Description.VendorCapability = &vcs
+	}
+}
+
+// fixSelectCapability returns a copy of sc without the quirk's dropped
+// choices, and with its default. Returns nil when no choice is left.
+func (q *quirk) fixSelectCapability(sc *cdd.SelectCapability) *cdd.SelectCapability {
+	options := make([]cdd.SelectCapabilityOption, 0, len(sc.Option))
+	var hasDefault, hasQuirkDefault bool
+	for _, o := range sc.Option {
+		if !q.drops(o.Value) {
+			options = append(options, o)
+			hasDefault = hasDefault || o.IsDefault
+			hasQuirkDefault = hasQuirkDefault || (q.Default != "" && o.Value == q.Default)
+		}
+	}
+	if len(options) == 0 {
+		return nil
+	}
+
+	if hasQuirkDefault {
+		for i := range options {
+			options[i].IsDefault = options[i].Value == q.Default
+		}
+	} else if !hasDefault {
+		options[0].IsDefault = true
+	}
+	return &cdd.SelectCapability{Option: options}
+}
+
+// applyOptions fixes the CUPS options of a job by the option quirks that
+// match its printer.
+func (q quirks) applyOptions(printer *lib.Printer, options map[string]string) {
+	makeAndModel := printer.Tags[attrPrinterMakeAndModel]
+
+	for _, quirk := range q {
+		if quirk.Option == "" || !quirk.makeAndModel.MatchString(makeAndModel) {
+			continue
+		}
+		value, exists := options[quirk.Option]
+		if exists && quirk.drops(value) {
+			delete(options, quirk.Option)
+			exists = false
+		}
+		if !exists && quirk.Default != "" {
+			value, exists = quirk.Default, true
+		}
+		if !exists {
+			continue
+		}
+		if quirk.Rename != "" {
+			delete(options, quirk.Option)
+			options[quirk.Rename] = value
+		} else {
+			options[quirk.Option] = value
+		}
+	}
+}
+
+func (q *quirk) drops(value string) bool {
+	for _, d := range q.DropChoices {
+		if d == value {
+			return true
+		}
+	}
+	return false
+}
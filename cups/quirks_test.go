@@ -0,0 +1,163 @@
+// Copyright 2015 Google Inc. All rights reserved.
+
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+// +build linux darwin freebsd
+
+package cups
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/google/cloud-print-connector/cdd"
+	"github.com/google/cloud-print-connector/lib"
+)
+
+func TestQuirksAttributes(t *testing.T) {
+	f, err := ioutil.TempFile("", "quirks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`[
+		{"make_and_model": "^Acme ", "attribute": "marker-types", "match": "^drum-unit$", "replace": "opc"},
+		{"make_and_model": "^Acme Copier", "attribute": "marker-names", "values": ["Black Toner", "Drum"]}
+	]`)
+	f.Close()
+
+	q, err := newQuirks(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	attributes := map[string][]string{
+		attrPrinterMakeAndModel: []string{"Acme Copier 9000"},
+		attrMarkerNames:         []string{"K", "D"},
+		attrMarkerTypes:         []string{"toner", "drum-unit"},
+		attrMarkerLevels:        []string{"-3", "40"},
+	}
+	q.applyAttributes(attributes)
+	expected := map[string][]string{
+		attrPrinterMakeAndModel: []string{"Acme Copier 9000"},
+		attrMarkerNames:         []string{"Black Toner", "Drum"},
+		attrMarkerTypes:         []string{"toner", "opc"},
+		attrMarkerLevels:        []string{"-3", "40"},
+	}
+	if !reflect.DeepEqual(expected, attributes) {
+		t.Logf("expected %v, got %v", expected, attributes)
+		t.Fail()
+	}
+
+	attributes = map[string][]string{
+		attrPrinterMakeAndModel: []string{"Other Printer"},
+		attrMarkerNames:         []string{"Black", " Reorder Part #12345", "Drum"},
+	}
+	q.applyAttributes(attributes)
+	if !reflect.DeepEqual([]string{"Black, Reorder Part #12345", "Drum"}, attributes[attrMarkerNames]) {
+		t.Logf("expected marker names split at a comma to be joined, got %v", attributes[attrMarkerNames])
+		t.Fail()
+	}
+	if _, exists := attributes[attrMarkerTypes]; exists {
+		t.Logf("expected missing marker types to stay missing, got %v", attributes[attrMarkerTypes])
+		t.Fail()
+	}
+
+	attributes = map[string][]string{
+		attrPrinterMakeAndModel: []string{"Other Printer"},
+		attrMarkerTypes:         []string{"drum-unit"},
+	}
+	q.applyAttributes(attributes)
+	if attributes[attrMarkerTypes][0] != "drum-unit" {
+		t.Logf("expected quirks of Acme printers to leave other printers be, got %v", attributes)
+		t.Fail()
+	}
+
+	f, err = ioutil.TempFile("", "quirks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`[{"make_and_model": "(", "attribute": "marker-types"}]`)
+	f.Close()
+	if _, err = newQuirks(f.Name()); err == nil {
+		t.Log("expected a quirk with a bad regexp to fail")
+		t.Fail()
+	}
+}
+
+func TestQuirksOptions(t *testing.T) {
+	q := quirks{
+		quirk{MakeAndModel: "^Acme ", Option: "Resolution", DropChoices: []string{"1200dpi"}, Default: "600dpi"},
+		quirk{MakeAndModel: "^Acme ", Option: "Tray", Rename: "InputSlot"},
+		quirk{MakeAndModel: "^Acme ", Option: "Broken", DropChoices: []string{"On", "Off"}},
+	}
+	for i := range q {
+		q[i].makeAndModel = regexp.MustCompile(q[i].MakeAndModel)
+	}
+
+	vcs := []cdd.VendorCapability{
+		cdd.VendorCapability{
+			ID:   "Resolution",
+			Type: cdd.VendorCapabilitySelect,
+			SelectCap: &cdd.SelectCapability{Option: []cdd.SelectCapabilityOption{
+				cdd.SelectCapabilityOption{Value: "300dpi"},
+				cdd.SelectCapabilityOption{Value: "600dpi"},
+				cdd.SelectCapabilityOption{Value: "1200dpi", IsDefault: true},
+			}},
+		},
+		cdd.VendorCapability{
+			ID:   "Broken",
+			Type: cdd.VendorCapabilitySelect,
+			SelectCap: &cdd.SelectCapability{Option: []cdd.SelectCapabilityOption{
+				cdd.SelectCapabilityOption{Value: "On", IsDefault: true},
+				cdd.SelectCapabilityOption{Value: "Off"},
+			}},
+		},
+	}
+	printer := lib.Printer{
+		Description: &cdd.PrinterDescriptionSection{VendorCapability: &vcs},
+		Tags:        map[string]string{attrPrinterMakeAndModel: "Acme LaserWriter"},
+	}
+	q.applyCapabilities(&printer)
+
+	expected := []cdd.VendorCapability{
+		cdd.VendorCapability{
+			ID:   "Resolution",
+			Type: cdd.VendorCapabilitySelect,
+			SelectCap: &cdd.SelectCapability{Option: []cdd.SelectCapabilityOption{
+				cdd.SelectCapabilityOption{Value: "300dpi"},
+				cdd.SelectCapabilityOption{Value: "600dpi", IsDefault: true},
+			}},
+		},
+	}
+	if !reflect.DeepEqual(expected, *printer.Description.VendorCapability) {
+		t.Logf("expected %+v, got %+v", expected, *printer.Description.VendorCapability)
+		t.Fail()
+	}
+	if len(vcs) != 2 || len(vcs[0].SelectCap.Option) != 3 {
+		t.Log("expected the original capabilities to be left be")
+		t.Fail()
+	}
+
+	options := map[string]string{"Resolution": "1200dpi", "Tray": "Tray2", "Broken": "On"}
+	q.applyOptions(&printer, options)
+	expectedOptions := map[string]string{"Resolution": "600dpi", "InputSlot": "Tray2"}
+	if !reflect.DeepEqual(expectedOptions, options) {
+		t.Logf("expected %v, got %v", expectedOptions, options)
+		t.Fail()
+	}
+
+	printer.Tags[attrPrinterMakeAndModel] = "Other Printer"
+	options = map[string]string{"Tray": "Tray2"}
+	q.applyOptions(&printer, options)
+	if options["Tray"] != "Tray2" {
+		t.Logf("expected quirks of Acme printers to leave other printers be, got %v", options)
+		t.Fail()
+	}
+}
@@ -0,0 +1,385 @@
+// Copyright 2015 Google Inc. All rights reserved.
+
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+// +build linux darwin freebsd
+
+package cups
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/cloud-print-connector/cdd"
+	"github.com/google/cloud-print-connector/lib"
+	"github.com/google/cloud-print-connector/log"
+)
+
+const (
+	rawSchemeSocket   = "socket"
+	rawSchemeLPD      = "lpd"
+	rawDefaultPortJD  = "9100"
+	rawDefaultPortLPD = "515"
+
+	// pjlUEL is the PJL Universal Exit Language command, which resets the
+	// printer's language interpreter.
+	pjlUEL = "\x1b%-12345X"
+	// pjlInfoStatus asks for the printer's status.
+	pjlInfoStatus = pjlUEL + "@PJL INFO STATUS\r\n" + pjlUEL
+
+	// rawTimeout bounds connecting to a device, each PJL status query, and
+	// each write of a job.
+	rawTimeout = 10 * time.Second
+)
+
+// rawBackend sends jobs for raw queues straight to JetDirect (socket://)
+// and LPD (lpd://) devices, without CUPS, and asks the JetDirect devices
+// that speak PJL for their status. Raw queues don't filter jobs, so this
+// prints exactly what CUPS would, without depending on cupsd.
+type rawBackend struct {
+	timeout time.Duration
+	// pjlPrinters are the names of the raw queues whose devices are asked
+	// for their status with PJL. Devices that don't speak PJL, like many
+	// label printers, print the query as text.
+	pjlPrinters map[string]struct{}
+	// jobID numbers jobs, which are done once they are sent.
+	jobID uint32
+	// printers maps the names of raw queues, as of the last sync, to their
+	// device URIs.
+	printers map[string]*url.URL
+	mutex    sync.RWMutex
+	hostname string
+}
+
+// newRawBackend creates a rawBackend whose connections give up after
+// timeout, and which asks the devices of pjlPrinters for their status.
+func newRawBackend(timeout time.Duration, pjlPrinters []string) *rawBackend {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "localhost"
+	}
+	pp := make(map[string]struct{}, len(pjlPrinters))
+	for _, p := range pjlPrinters {
+		pp[p] = struct{}{}
+	}
+	return &rawBackend{
+		timeout:     timeout,
+		pjlPrinters: pp,
+		printers:    make(map[string]*url.URL),
+		hostname:    hostname,
+	}
+}
+
+// rawDeviceURI gets the device URI of a raw queue that prints to a JetDirect
+// or LPD device.
+func rawDeviceURI(printer *lib.Printer) (*url.URL, bool) {
+	if !lib.PrinterIsRaw(*printer) {
+		return nil, false
+	}
+	u, err := url.Parse(printer.Tags[attrDeviceURI])
+	if err != nil || u.Host == "" {
+		return nil, false
+	}
+	if u.Scheme != rawSchemeSocket && u.Scheme != rawSchemeLPD {
+		return nil, false
+	}
+	return u, true
+}
+
+// rawHostPort gets host:port of a device URI, with the default port of its
+// scheme.
+func rawHostPort(u *url.URL) string {
+	if _, _, err := net.SplitHostPort(u.Host); err == nil {
+		return u.Host
+	}
+	port := rawDefaultPortJD
+	if u.Scheme == rawSchemeLPD {
+		port = rawDefaultPortLPD
+	}
+	return net.JoinHostPort(strings.Trim(u.Host, "[]"), port)
+}
+
+// isRaw returns true when name was a raw queue with a JetDirect or LPD device
+// at the last sync. Safe to call on nil.
+func (rb *rawBackend) isRaw(name string) bool {
+	if rb == nil {
+		return false
+	}
+	rb.mutex.RLock()
+	defer rb.mutex.RUnlock()
+
+	_, exists := rb.printers[name]
+	return exists
+}
+
+// update remembers the raw queues among printers, and replaces the state of
+// those with JetDirect devices that speak PJL with their PJL status. A
+// device that doesn't answer, or is busy with a job, keeps the state that
+// CUPS reports.
+func (rb *rawBackend) update(printers []lib.Printer) {
+	raw := make(map[string]*url.URL)
+	var wg sync.WaitGroup
+	for i := range printers {
+		u, ok := rawDeviceURI(&printers[i])
+		if !ok {
+			continue
+		}
+		raw[printers[i].Name] = u
+		if _, exists := rb.pjlPrinters[printers[i].Name]; !exists || u.Scheme != rawSchemeSocket {
+			continue
+		}
+
+		wg.Add(1)
+		p, hostPort := &printers[i], rawHostPort(u)
+		lib.Go(lib.SubsystemCUPS, func() {
+			defer wg.Done()
+			status, err := rb.pjlStatus(hostPort)
+			if err != nil {
+				log.Debugf("Keeping the CUPS state of raw printer %s: %s", p.Name, err)
+				return
+			}
+			state, vendorState := convertPJLStatus(status)
+			if p.State == nil {
+				p.State = &cdd.PrinterStateSection{}
+			}
+			p.State.State = state
+			p.State.VendorState = vendorState
+		})
+	}
+	wg.Wait()
+
+	rb.mutex.Lock()
+	defer rb.mutex.Unlock()
+	rb.printers = raw
+}
+
+// pjlStatus sends PJL INFO STATUS to a JetDirect device, and gets the
+// variables of its answer, like CODE and DISPLAY.
+func (rb *rawBackend) pjlStatus(hostPort string) (map[string]string, error) {
+	conn, err := lib.DialTimeout("tcp", hostPort, rb.timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(rb.timeout))
+
+	if _, err = io.WriteString(conn, pjlInfoStatus); err != nil {
+		return nil, err
+	}
+	// The answer ends with a form feed.
+	answer, err := bufio.NewReader(conn).ReadString('\f')
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read PJL status from %s: %s", hostPort, err)
+	}
+	return parsePJLStatus(answer)
+}
+
+// parsePJLStatus parses the answer to PJL INFO STATUS.
+func parsePJLStatus(answer string) (map[string]string, error) {
+	lines := strings.Split(strings.Replace(answer, "\r", "", -1), "\n")
+	var i int
+	for i < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[i]), "@PJL INFO STATUS") {
+		i++
+	}
+	if i == len(lines) {
+		return nil, errors.New("PJL answer is not a status")
+	}
+
+	status := make(map[string]string)
+	for _, line := range lines[i+1:] {
+		kv := strings.SplitN(strings.TrimSpace(line), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		status[strings.ToUpper(kv[0])] = strings.Trim(kv[1], `"`)
+	}
+	if _, exists := status["CODE"]; !exists {
+		return nil, errors.New("PJL status has no CODE")
+	}
+	return status, nil
+}
+
+// convertPJLStatus converts PJL status variables to a printer state. Status
+// codes are from the PJL Technical Reference: 10xxx are informational, 30xxx
+// are auto-continuable errors, and others need attention.
+func convertPJLStatus(status map[string]string) (cdd.CloudDeviceStateType, *cdd.VendorState) {
+	code, _ := strconv.Atoi(status["CODE"])
+	state := cdd.CloudDeviceStateIdle
+	vs := cdd.VendorStateInfo
+	switch {
+	case code == 10003 || code == 10023:
+		// Warming up, or printing.
+		state = cdd.CloudDeviceStateProcessing
+	case code/1000 == 10:
+	case code/1000 == 30:
+		vs = cdd.VendorStateWarning
+	default:
+		state = cdd.CloudDeviceStateStopped
+		vs = cdd.VendorStateError
+	}
+	if strings.EqualFold(status["ONLINE"], "FALSE") {
+		state = cdd.CloudDeviceStateStopped
+	}
+
+	description := status["DISPLAY"]
+	if description == "" {
+		description = "PJL status " + status["CODE"]
+	}
+	return state, &cdd.VendorState{Item: []cdd.VendorStateItem{
+		{State: vs, DescriptionLocalized: cdd.NewLocalizedString(description)},
+	}}
+}
+
+// printFile sends a file, copies times, to the device of a raw queue.
+// Returns a job ID; the job is done once this returns.
+func (rb *rawBackend) printFile(user, printername, filename, title string, copies int) (uint32, error) {
+	rb.mutex.RLock()
+	u := rb.printers[printername]
+	rb.mutex.RUnlock()
+	if u == nil {
+		return 0, fmt.Errorf("%s is not a raw JetDirect or LPD queue", printername)
+	}
+	if copies < 1 {
+		copies = 1
+	}
+
+	jobID := atomic.AddUint32(&rb.jobID, 1)
+	var err error
+	if u.Scheme == rawSchemeLPD {
+		err = rb.sendLPD(rawHostPort(u), strings.Trim(u.Path, "/"), user, title, filename, jobID, copies)
+	} else {
+		err = rb.sendJetDirect(rawHostPort(u), filename, copies)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return jobID, nil
+}
+
+// sendJetDirect sends a file, copies times, over one connection.
+func (rb *rawBackend) sendJetDirect(hostPort, filename string, copies int) error {
+	conn, err := lib.DialTimeout("tcp", hostPort, rb.timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	for i := 0; i < copies; i++ {
+		if err = rb.copyFile(conn, filename); err != nil {
+			return fmt.Errorf("Failed to send job to %s: %s", hostPort, err)
+		}
+	}
+	return nil
+}
+
+// copyFile writes a file to conn, extending the deadline as the file is
+// written, so that a large file doesn't time out while the device accepts it.
+func (rb *rawBackend) copyFile(conn net.Conn, filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	b := make([]byte, 32*1024)
+	for {
+		n, err := f.Read(b)
+		if n > 0 {
+			conn.SetWriteDeadline(time.Now().Add(rb.timeout))
+			if _, werr := conn.Write(b[:n]); werr != nil {
+				return werr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// sendLPD sends a file to an LPD queue, following RFC 1179. The control
+// file prints the data file copies times, without filtering.
+func (rb *rawBackend) sendLPD(hostPort, queue, user, title, filename string, jobID uint32, copies int) error {
+	fi, err := os.Stat(filename)
+	if err != nil {
+		return err
+	}
+
+	conn, err := lib.DialTimeout("tcp", hostPort, rb.timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	ack := make([]byte, 1)
+
+	command := func(line string) error {
+		conn.SetDeadline(time.Now().Add(rb.timeout))
+		if _, err := io.WriteString(conn, line); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(conn, ack); err != nil {
+			return err
+		}
+		if ack[0] != 0 {
+			return fmt.Errorf("LPD server %s refused %q", hostPort, strings.TrimSpace(line[1:]))
+		}
+		return nil
+	}
+
+	jobName := fmt.Sprintf("%03d%s", jobID%1000, rb.hostname)
+	control := lpdControlFile(rb.hostname, user, title, "dfA"+jobName, copies)
+
+	if err = command("\x02" + queue + "\n"); err != nil {
+		return err
+	}
+	if err = command(fmt.Sprintf("\x02%d cfA%s\n", len(control), jobName)); err != nil {
+		return err
+	}
+	if err = command(control + "\x00"); err != nil {
+		return err
+	}
+	if err = command(fmt.Sprintf("\x03%d dfA%s\n", fi.Size(), jobName)); err != nil {
+		return err
+	}
+	if err = rb.copyFile(conn, filename); err != nil {
+		return err
+	}
+	return command("\x00")
+}
+
+// lpdControlFile creates an RFC 1179 control file that prints dataFile
+// copies times, leaving control characters alone.
+func lpdControlFile(host, user, title, dataFile string, copies int) string {
+	// Lines may not contain newlines.
+	clean := func(s string) string {
+		return strings.Map(func(r rune) rune {
+			if r == '\n' || r == '\r' {
+				return ' '
+			}
+			return r
+		}, s)
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "H%s\nP%s\nJ%s\n", clean(host), clean(user), clean(title))
+	for i := 0; i < copies; i++ {
+		fmt.Fprintf(&b, "l%s\n", dataFile)
+	}
+	fmt.Fprintf(&b, "U%s\nN%s\n", dataFile, clean(title))
+	return b.String()
+}
@@ -0,0 +1,289 @@
+// Copyright 2015 Google Inc. All rights reserved.
+
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+// +build linux darwin freebsd
+
+package cups
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/cloud-print-connector/cdd"
+	"github.com/google/cloud-print-connector/lib"
+)
+
+func TestParsePJLStatus(t *testing.T) {
+	answer := "@PJL INFO STATUS\r\nCODE=40021\r\nDISPLAY=\"CLOSE TOP COVER\"\r\nONLINE=FALSE\r\n\f"
+	status, err := parsePJLStatus(answer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, vs := convertPJLStatus(status)
+	if state != cdd.CloudDeviceStateStopped {
+		t.Logf("expected state STOPPED for code 40021, got %s", state)
+		t.Fail()
+	}
+	if len(vs.Item) != 1 || vs.Item[0].State != cdd.VendorStateError ||
+		(*vs.Item[0].DescriptionLocalized)[0].Value != "CLOSE TOP COVER" {
+		t.Logf("expected error CLOSE TOP COVER, got %+v", vs.Item)
+		t.Fail()
+	}
+
+	testCases := map[string]cdd.CloudDeviceStateType{
+		"10001": cdd.CloudDeviceStateIdle,
+		"10023": cdd.CloudDeviceStateProcessing,
+		"30016": cdd.CloudDeviceStateIdle,
+		"41002": cdd.CloudDeviceStateStopped,
+	}
+	for code, expected := range testCases {
+		if state, _ := convertPJLStatus(map[string]string{"CODE": code}); state != expected {
+			t.Logf("expected state %s for code %s, got %s", expected, code, state)
+			t.Fail()
+		}
+	}
+
+	if _, err := parsePJLStatus("@PJL ECHO hello\r\n\f"); err == nil {
+		t.Log("expected an error for an answer that isn't a status")
+		t.Fail()
+	}
+}
+
+func TestLPDControlFile(t *testing.T) {
+	expected := "Hhost\nPuser\nJtwo lines\nldfA001host\nldfA001host\nUdfA001host\nNtwo lines\n"
+	if control := lpdControlFile("host", "user", "two\nlines", "dfA001host", 2); control != expected {
+		t.Logf("expected control file %q, got %q", expected, control)
+		t.Fail()
+	}
+}
+
+// fakeJetDirect answers PJL status queries, and records the data of jobs.
+type fakeJetDirect struct {
+	listener net.Listener
+	jobs     []string
+	mutex    sync.Mutex
+	done     chan struct{}
+}
+
+func newFakeJetDirect(t *testing.T) *fakeJetDirect {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeJetDirect{listener: l, done: make(chan struct{}, 10)}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeJetDirect) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	head, _ := r.Peek(len(pjlInfoStatus))
+	if string(head) == pjlInfoStatus {
+		io.WriteString(conn, "@PJL INFO STATUS\r\nCODE=10001\r\nDISPLAY=\"READY\"\r\nONLINE=TRUE\r\n\f")
+		return
+	}
+
+	data, _ := ioutil.ReadAll(r)
+	f.mutex.Lock()
+	f.jobs = append(f.jobs, string(data))
+	f.mutex.Unlock()
+	f.done <- struct{}{}
+}
+
+func TestRawJetDirect(t *testing.T) {
+	jd := newFakeJetDirect(t)
+	defer jd.listener.Close()
+
+	f := newFakeCUPSClient()
+	f.addPrinter("label", map[string][]string{
+		attrPrinterMakeAndModel: []string{"Local Raw Printer"},
+		attrDeviceURI:           []string{"socket://" + jd.listener.Addr().String()},
+		attrCopiesSupported:     []string{"1~99"},
+	}, "")
+	c := newTestCUPS(f, 0)
+	c.raw = newRawBackend(time.Second, []string{"label"})
+
+	printers, err := c.GetPrinters()
+	if err != nil {
+		t.Fatalf("GetPrinters failed: %s", err)
+	}
+	if len(printers) != 1 {
+		t.Fatalf("expected the raw queue without a PPD, got %d printers", len(printers))
+	}
+	printer := printers[0]
+	if printer.State.State != cdd.CloudDeviceStateIdle || printer.State.VendorState == nil ||
+		(*printer.State.VendorState.Item[0].DescriptionLocalized)[0].Value != "READY" {
+		t.Logf("expected state IDLE and READY from PJL, got %+v", printer.State)
+		t.Fail()
+	}
+
+	document, err := ioutil.TempFile("", "raw-job")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(document.Name())
+	document.WriteString("^XA^FDlabel^FS^XZ")
+	document.Close()
+
+	printer.NativeJobSemaphore = lib.NewSemaphore(1)
+	ticket := &cdd.CloudJobTicket{Print: cdd.PrintTicketSection{Copies: &cdd.CopiesTicketItem{Copies: 2}}}
+	jobID, err := c.Print(&printer, document.Name(), "title", "user@example.com", "gcp-123", ticket)
+	if err != nil {
+		t.Fatalf("Print failed: %s", err)
+	}
+	select {
+	case <-jd.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the job")
+	}
+	if len(f.printed) != 0 {
+		t.Logf("expected no job to go through CUPS, got %+v", f.printed)
+		t.Fail()
+	}
+	if expected := strings.Repeat("^XA^FDlabel^FS^XZ", 2); len(jd.jobs) != 1 || jd.jobs[0] != expected {
+		t.Logf("expected one connection with the job twice, got %q", jd.jobs)
+		t.Fail()
+	}
+
+	state, err := c.GetJobState("label", jobID)
+	if err != nil {
+		t.Fatalf("GetJobState failed: %s", err)
+	}
+	if state.State.Type != cdd.JobStateDone {
+		t.Logf("expected a sent job to be DONE, got %+v", state.State)
+		t.Fail()
+	}
+}
+
+func TestRawStateWithoutPJL(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed.Close()
+
+	f := newFakeCUPSClient()
+	f.addPrinter("label", map[string][]string{
+		attrPrinterMakeAndModel: []string{"Local Raw Printer"},
+		attrPrinterState:        []string{"4"},
+		attrDeviceURI:           []string{"socket://" + l.Addr().String()},
+	}, "")
+	f.addPrinter("offline", map[string][]string{
+		attrPrinterMakeAndModel: []string{"Local Raw Printer"},
+		attrPrinterState:        []string{"4"},
+		attrDeviceURI:           []string{"socket://" + closed.Addr().String()},
+	}, "")
+	c := newTestCUPS(f, 0)
+	// label doesn't speak PJL; offline does, but doesn't answer.
+	c.raw = newRawBackend(time.Second, []string{"offline"})
+
+	printers, err := c.GetPrinters()
+	if err != nil {
+		t.Fatalf("GetPrinters failed: %s", err)
+	}
+	if len(printers) != 2 {
+		t.Fatalf("expected 2 raw queues, got %d printers", len(printers))
+	}
+	for _, p := range printers {
+		if p.State.State != cdd.CloudDeviceStateProcessing {
+			t.Logf("expected %s to keep its CUPS state PROCESSING, got %+v", p.Name, p.State)
+			t.Fail()
+		}
+	}
+
+	l.(*net.TCPListener).SetDeadline(time.Now().Add(100 * time.Millisecond))
+	if conn, err := l.Accept(); err == nil {
+		conn.Close()
+		t.Log("expected no PJL query to a printer that isn't configured for PJL")
+		t.Fail()
+	}
+}
+
+// serveFakeLPD receives one job, following RFC 1179, and sends its queue,
+// control file and data file to received.
+func serveFakeLPD(l net.Listener, received chan<- []string) {
+	conn, err := l.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	ack := func() { conn.Write([]byte{0}) }
+
+	line, _ := r.ReadString('\n')
+	job := []string{strings.TrimSpace(line[1:])}
+	ack()
+	for i := 0; i < 2; i++ {
+		// Receive control file, or receive data file: \x02 or \x03, then
+		// size and name.
+		line, _ = r.ReadString('\n')
+		size, _ := strconv.Atoi(strings.Fields(line[1:])[0])
+		ack()
+		b := make([]byte, size+1)
+		io.ReadFull(r, b)
+		ack()
+		job = append(job, string(b[:size]))
+	}
+	received <- job
+}
+
+func TestRawLPD(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	received := make(chan []string, 1)
+	go serveFakeLPD(l, received)
+
+	rb := newRawBackend(time.Second, nil)
+	rb.hostname = "host"
+	rb.printers["label"], _ = url.Parse("lpd://" + l.Addr().String() + "/zebra")
+
+	document, err := ioutil.TempFile("", "raw-job")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(document.Name())
+	document.WriteString("^XA^XZ")
+	document.Close()
+
+	if _, err := rb.printFile("user", "label", document.Name(), "title", 1); err != nil {
+		t.Fatalf("printFile failed: %s", err)
+	}
+	select {
+	case job := <-received:
+		if job[0] != "zebra" || !strings.Contains(job[1], "ldfA001host\n") || job[2] != "^XA^XZ" {
+			t.Logf("unexpected LPD job %q", job)
+			t.Fail()
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the LPD job")
+	}
+}
@@ -0,0 +1,291 @@
+// Copyright 2015 Google Inc. All rights reserved.
+
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+// +build linux darwin freebsd
+
+package cups
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/cloud-print-connector/lib"
+)
+
+// SNMPv2c message types and tags, from RFC 3416, for reading the Printer MIB
+// of printers that CUPS prints to without IPP.
+const (
+	snmpPort      = "161"
+	snmpVersion2c = 1
+
+	berTagInteger     byte = 0x02
+	berTagOctetString byte = 0x04
+	berTagNull        byte = 0x05
+	berTagOID         byte = 0x06
+	berTagSequence    byte = 0x30
+	snmpTagCounter32  byte = 0x41
+	snmpTagGauge32    byte = 0x42
+	snmpTagTimeTicks  byte = 0x43
+	snmpTagCounter64  byte = 0x46
+	snmpTagGetRequest byte = 0xa0
+	snmpTagResponse   byte = 0xa2
+
+	// snmpMaxMessageSize is more than the response to a few GETs needs.
+	snmpMaxMessageSize = 4096
+)
+
+// snmpGet gets the values of oids from the SNMP agent at address, like
+// host:161, formatted as strings. OIDs that the agent doesn't have are left
+// out.
+func snmpGet(address, community string, oids []string, timeout time.Duration) (map[string]string, error) {
+	requestID := rand.Int31()
+	request, err := encodeSNMPGet(community, requestID, oids)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := lib.DialTimeout("udp", address, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	if _, err = conn.Write(request); err != nil {
+		return nil, err
+	}
+	b := make([]byte, snmpMaxMessageSize)
+	for {
+		n, err := conn.Read(b)
+		if err != nil {
+			return nil, fmt.Errorf("SNMP agent %s didn't answer: %s", address, err)
+		}
+		id, values, err := decodeSNMPResponse(b[:n])
+		if err != nil {
+			return nil, fmt.Errorf("SNMP agent %s: %s", address, err)
+		}
+		if id == requestID {
+			return values, nil
+		}
+		// An answer to an earlier request.
+	}
+}
+
+// encodeSNMPGet encodes a GetRequest message for oids.
+func encodeSNMPGet(community string, requestID int32, oids []string) ([]byte, error) {
+	var varbinds []byte
+	for _, oid := range oids {
+		o, err := encodeOID(oid)
+		if err != nil {
+			return nil, err
+		}
+		varbinds = append(varbinds, encodeBER(berTagSequence,
+			append(encodeBER(berTagOID, o), encodeBER(berTagNull, nil)...))...)
+	}
+
+	var pdu []byte
+	pdu = append(pdu, encodeBER(berTagInteger, encodeInteger(int64(requestID)))...)
+	pdu = append(pdu, encodeBER(berTagInteger, encodeInteger(0))...) // error-status
+	pdu = append(pdu, encodeBER(berTagInteger, encodeInteger(0))...) // error-index
+	pdu = append(pdu, encodeBER(berTagSequence, varbinds)...)
+
+	var message []byte
+	message = append(message, encodeBER(berTagInteger, encodeInteger(snmpVersion2c))...)
+	message = append(message, encodeBER(berTagOctetString, []byte(community))...)
+	message = append(message, encodeBER(snmpTagGetRequest, pdu)...)
+	return encodeBER(berTagSequence, message), nil
+}
+
+// decodeSNMPResponse decodes a Response message, returning its request ID
+// and its values by OID.
+func decodeSNMPResponse(b []byte) (int32, map[string]string, error) {
+	message, err := expectBER(b, berTagSequence)
+	if err != nil {
+		return 0, nil, err
+	}
+	if _, message, err = nextBER(message, berTagInteger); err != nil {
+		return 0, nil, err
+	}
+	if _, message, err = nextBER(message, berTagOctetString); err != nil {
+		return 0, nil, err
+	}
+	pdu, _, err := nextBER(message, snmpTagResponse)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	id, pdu, err := nextBER(pdu, berTagInteger)
+	if err != nil {
+		return 0, nil, err
+	}
+	errorStatus, pdu, err := nextBER(pdu, berTagInteger)
+	if err != nil {
+		return 0, nil, err
+	}
+	if status := decodeInteger(errorStatus); status != 0 {
+		return 0, nil, fmt.Errorf("SNMP error status %d", status)
+	}
+	if _, pdu, err = nextBER(pdu, berTagInteger); err != nil {
+		return 0, nil, err
+	}
+	varbinds, _, err := nextBER(pdu, berTagSequence)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	values := make(map[string]string)
+	for len(varbinds) > 0 {
+		var varbind []byte
+		if varbind, varbinds, err = nextBER(varbinds, berTagSequence); err != nil {
+			return 0, nil, err
+		}
+		o, varbind, err := nextBER(varbind, berTagOID)
+		if err != nil {
+			return 0, nil, err
+		}
+		tag, value, _, err := readBER(varbind)
+		if err != nil {
+			return 0, nil, err
+		}
+		switch tag {
+		case berTagOctetString:
+			values[decodeOID(o)] = strings.TrimSpace(string(bytes.TrimRight(value, "\x00")))
+		case berTagInteger, snmpTagCounter32, snmpTagGauge32, snmpTagTimeTicks:
+			values[decodeOID(o)] = strconv.FormatInt(decodeInteger(value), 10)
+		case snmpTagCounter64:
+			values[decodeOID(o)] = strconv.FormatUint(uint64(decodeInteger(value)), 10)
+		}
+		// Other tags are NULL, noSuchObject, noSuchInstance and types that
+		// printers don't use for these values.
+	}
+
+	return int32(decodeInteger(id)), values, nil
+}
+
+// encodeBER encodes one tag, length and value.
+func encodeBER(tag byte, value []byte) []byte {
+	b := []byte{tag}
+	if l := len(value); l < 0x80 {
+		b = append(b, byte(l))
+	} else {
+		var lb []byte
+		for ; l > 0; l >>= 8 {
+			lb = append([]byte{byte(l)}, lb...)
+		}
+		b = append(b, 0x80|byte(len(lb)))
+		b = append(b, lb...)
+	}
+	return append(b, value...)
+}
+
+// readBER reads one tag, length and value from b, returning the rest of b.
+func readBER(b []byte) (byte, []byte, []byte, error) {
+	if len(b) < 2 {
+		return 0, nil, nil, errors.New("truncated SNMP message")
+	}
+	tag, l, b := b[0], int(b[1]), b[2:]
+	if l&0x80 != 0 {
+		n := l &^ 0x80
+		if n == 0 || n > 4 || len(b) < n {
+			return 0, nil, nil, errors.New("bad length in SNMP message")
+		}
+		l = 0
+		for _, lb := range b[:n] {
+			l = l<<8 | int(lb)
+		}
+		b = b[n:]
+	}
+	if l < 0 || len(b) < l {
+		return 0, nil, nil, errors.New("truncated SNMP message")
+	}
+	return tag, b[:l], b[l:], nil
+}
+
+// nextBER reads one value of tag from b, returning the rest of b.
+func nextBER(b []byte, tag byte) ([]byte, []byte, error) {
+	t, value, rest, err := readBER(b)
+	if err != nil {
+		return nil, nil, err
+	}
+	if t != tag {
+		return nil, nil, fmt.Errorf("expected tag 0x%02x in SNMP message, got 0x%02x", tag, t)
+	}
+	return value, rest, nil
+}
+
+// expectBER reads the one value of tag in b.
+func expectBER(b []byte, tag byte) ([]byte, error) {
+	value, _, err := nextBER(b, tag)
+	return value, err
+}
+
+func encodeInteger(i int64) []byte {
+	b := []byte{byte(i)}
+	for i >>= 8; ; i >>= 8 {
+		// Stop when the remaining bytes are only sign extension.
+		if (i == 0 && b[0]&0x80 == 0) || (i == -1 && b[0]&0x80 != 0) {
+			return b
+		}
+		b = append([]byte{byte(i)}, b...)
+	}
+}
+
+func decodeInteger(b []byte) int64 {
+	var i int64
+	if len(b) > 0 && b[0]&0x80 != 0 {
+		i = -1
+	}
+	for _, x := range b {
+		i = i<<8 | int64(x)
+	}
+	return i
+}
+
+// encodeOID encodes a dotted OID like 1.3.6.1.2.1.43.
+func encodeOID(oid string) ([]byte, error) {
+	parts := strings.Split(strings.TrimPrefix(oid, "."), ".")
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("OID %s is too short", oid)
+	}
+	arcs := make([]uint64, len(parts))
+	for i, p := range parts {
+		var err error
+		if arcs[i], err = strconv.ParseUint(p, 10, 32); err != nil {
+			return nil, fmt.Errorf("OID %s is not valid", oid)
+		}
+	}
+
+	b := []byte{byte(arcs[0]*40 + arcs[1])}
+	for _, arc := range arcs[2:] {
+		sub := []byte{byte(arc & 0x7f)}
+		for arc >>= 7; arc > 0; arc >>= 7 {
+			sub = append([]byte{0x80 | byte(arc&0x7f)}, sub...)
+		}
+		b = append(b, sub...)
+	}
+	return b, nil
+}
+
+func decodeOID(b []byte) string {
+	if len(b) == 0 {
+		return ""
+	}
+	arcs := []string{strconv.Itoa(int(b[0]) / 40), strconv.Itoa(int(b[0]) % 40)}
+	var arc uint64
+	for _, x := range b[1:] {
+		arc = arc<<7 | uint64(x&0x7f)
+		if x&0x80 == 0 {
+			arcs = append(arcs, strconv.FormatUint(arc, 10))
+			arc = 0
+		}
+	}
+	return strings.Join(arcs, ".")
+}
@@ -0,0 +1,128 @@
+// Copyright 2015 Google Inc. All rights reserved.
+
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+// +build linux darwin freebsd
+
+package cups
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// fakeSNMPAgent answers GetRequests for a fixed set of string and counter
+// values; other OIDs are noSuchObject.
+func fakeSNMPAgent(t *testing.T, texts map[string]string, counters map[string]int64) *net.UDPConn {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		b := make([]byte, snmpMaxMessageSize)
+		for {
+			n, addr, err := conn.ReadFromUDP(b)
+			if err != nil {
+				return
+			}
+			message, _ := expectBER(b[:n], berTagSequence)
+			_, message, _ = nextBER(message, berTagInteger)
+			_, message, _ = nextBER(message, berTagOctetString)
+			pdu, _, _ := nextBER(message, snmpTagGetRequest)
+			id, pdu, _ := nextBER(pdu, berTagInteger)
+			_, pdu, _ = nextBER(pdu, berTagInteger)
+			_, pdu, _ = nextBER(pdu, berTagInteger)
+			requested, _, _ := nextBER(pdu, berTagSequence)
+
+			var varbinds []byte
+			for len(requested) > 0 {
+				var varbind []byte
+				varbind, requested, _ = nextBER(requested, berTagSequence)
+				o, _, _ := nextBER(varbind, berTagOID)
+				value := encodeBER(0x80, nil) // noSuchObject
+				if s, exists := texts[decodeOID(o)]; exists {
+					value = encodeBER(berTagOctetString, []byte(s))
+				} else if c, exists := counters[decodeOID(o)]; exists {
+					value = encodeBER(snmpTagCounter32, encodeInteger(c))
+				}
+				varbinds = append(varbinds, encodeBER(berTagSequence, append(encodeBER(berTagOID, o), value...))...)
+			}
+
+			var response []byte
+			response = append(response, encodeBER(berTagInteger, id)...)
+			response = append(response, encodeBER(berTagInteger, encodeInteger(0))...)
+			response = append(response, encodeBER(berTagInteger, encodeInteger(0))...)
+			response = append(response, encodeBER(berTagSequence, varbinds)...)
+			var answer []byte
+			answer = append(answer, encodeBER(berTagInteger, encodeInteger(snmpVersion2c))...)
+			answer = append(answer, encodeBER(berTagOctetString, []byte("public"))...)
+			answer = append(answer, encodeBER(snmpTagResponse, response)...)
+			conn.WriteToUDP(encodeBER(berTagSequence, answer), addr)
+		}
+	}()
+
+	return conn
+}
+
+func TestSNMPGet(t *testing.T) {
+	agent := fakeSNMPAgent(t,
+		map[string]string{oidPrtGeneralSerialNumber: "CN12345\x00"},
+		map[string]int64{oidPrtMarkerLifeCount: 123456})
+	defer agent.Close()
+
+	values, err := snmpGet(agent.LocalAddr().String(), "public",
+		[]string{oidEntPhysicalFirmwareRev, oidPrtGeneralSerialNumber, oidPrtMarkerLifeCount}, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		tagDeviceSerialNumber: "CN12345",
+		tagDevicePageCount:    "123456",
+	}
+	if info := deviceInfoFromSNMP(values); !reflect.DeepEqual(expected, info) {
+		t.Logf("expected %v, got %v from %v", expected, info, values)
+		t.Fail()
+	}
+}
+
+func TestBERIntegersAndOIDs(t *testing.T) {
+	for _, i := range []int64{0, 1, 127, 128, 255, 256, -1, -128, -129, 1 << 31} {
+		if d := decodeInteger(encodeInteger(i)); d != i {
+			t.Logf("expected %d, got %d", i, d)
+			t.Fail()
+		}
+	}
+
+	oid := "1.3.6.1.2.1.43.10.2.1.4.1.1"
+	o, err := encodeOID(oid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := decodeOID(o); d != oid {
+		t.Logf("expected OID %s, got %s", oid, d)
+		t.Fail()
+	}
+}
+
+func TestDeviceInfoFromAttributes(t *testing.T) {
+	info := deviceInfoFromAttributes(map[string][]string{
+		attrPrinterDeviceID:              []string{"MFG:Acme;MDL:Laser 9000;SERN:X123;"},
+		attrPrinterFirmwareStringVersion: []string{"2.1.0"},
+		attrPrinterImpressionsCompleted:  []string{"42"},
+	})
+	expected := map[string]string{
+		tagDeviceFirmwareVersion: "2.1.0",
+		tagDeviceSerialNumber:    "X123",
+		tagDevicePageCount:       "42",
+	}
+	if !reflect.DeepEqual(expected, info) {
+		t.Logf("expected %v, got %v", expected, info)
+		t.Fail()
+	}
+}
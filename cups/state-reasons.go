@@ -0,0 +1,160 @@
+// Copyright 2015 Google Inc. All rights reserved.
+
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+// +build linux darwin freebsd
+
+package cups
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/google/cloud-print-connector/cdd"
+)
+
+// builtinStateReasonMessages are English messages for the printer-state-reasons
+// keywords of RFC 8011 and CUPS, without their -error, -warning or -report
+// suffix.
+var builtinStateReasonMessages = map[string]string{
+	"connecting-to-device":             "Connecting to the printer",
+	"cover-open":                       "A cover is open",
+	"cups-insecure-filter":             "The printer driver has an insecure filter",
+	"cups-missing-filter":              "The printer driver is missing a filter",
+	"developer-empty":                  "Out of developer",
+	"developer-low":                    "Developer is low",
+	"door-open":                        "A door is open",
+	"fuser-over-temp":                  "The fuser is too hot",
+	"fuser-under-temp":                 "The fuser is warming up",
+	"input-tray-missing":               "A paper tray is missing",
+	"interlock-open":                   "An interlock is open",
+	"interpreter-resource-unavailable": "The printer is out of memory or fonts",
+	"marker-supply-empty":              "Out of ink or toner",
+	"marker-supply-low":                "Ink or toner is low",
+	"marker-waste-almost-full":         "The waste container is almost full",
+	"marker-waste-full":                "The waste container is full",
+	"media-empty":                      "Out of paper",
+	"media-jam":                        "Paper jam",
+	"media-low":                        "Paper is low",
+	"media-needed":                     "Load paper",
+	"moving-to-paused":                 "The printer is pausing",
+	"offline":                          "The printer is offline",
+	"opc-life-over":                    "Replace the photoconductor",
+	"opc-near-eol":                     "The photoconductor is almost worn out",
+	"other":                            "The printer has a problem",
+	"output-area-almost-full":          "The output tray is almost full",
+	"output-area-full":                 "The output tray is full",
+	"paused":                           "The printer is paused",
+	"shutdown":                         "The printer is shut down",
+	"spool-area-full":                  "The print spool is full",
+	"stopped-partly":                   "Some parts of the printer are stopped",
+	"stopping":                         "The printer is stopping",
+	"timed-out":                        "The printer isn't responding",
+	"toner-empty":                      "Out of toner",
+	"toner-low":                        "Toner is low",
+}
+
+// trayReason matches reasons about one tray, like media-empty-tray-2.
+var trayReason = regexp.MustCompile(`^(.+)-tray-?(\d+)$`)
+
+// stateReasonMessages maps printer-state-reasons to messages by locale, on
+// top of builtinStateReasonMessages. The nil value has only the built-in
+// messages.
+type stateReasonMessages map[string]map[string]string
+
+// newStateReasonMessages reads a JSON file of messages, like
+// {"media-empty": {"EN": "Out of paper", "DE": "Kein Papier"}}. A reason
+// with its suffix takes precedence over the reason without.
+func newStateReasonMessages(filename string) (stateReasonMessages, error) {
+	if filename == "" {
+		return nil, nil
+	}
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var m stateReasonMessages
+	if err = json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("Failed to parse state reason messages file %s: %s", filename, err)
+	}
+	return m, nil
+}
+
+// lookup gets the messages for one reason keyword, by locale.
+func (m stateReasonMessages) lookup(keyword string) map[string]string {
+	if messages, exists := m[keyword]; exists && len(messages) > 0 {
+		return messages
+	}
+	if message, exists := builtinStateReasonMessages[keyword]; exists {
+		return map[string]string{"EN": message}
+	}
+	return nil
+}
+
+// localize gets the messages for a printer-state-reason, or nil when there
+// are none.
+func (m stateReasonMessages) localize(reason string) *[]cdd.LocalizedString {
+	messages := m.lookup(reason)
+	if messages == nil {
+		keyword := reason
+		for _, suffix := range []string{"-error", "-warning", "-report"} {
+			keyword = strings.TrimSuffix(keyword, suffix)
+		}
+		if messages = m.lookup(keyword); messages == nil {
+			if match := trayReason.FindStringSubmatch(keyword); match != nil {
+				if messages = m.lookup(match[1]); messages != nil {
+					tray := make(map[string]string, len(messages))
+					for locale, message := range messages {
+						tray[locale] = fmt.Sprintf("%s – Tray %s", message, match[2])
+					}
+					messages = tray
+				}
+			}
+		}
+	}
+	if messages == nil {
+		return nil
+	}
+
+	locales := make([]string, 0, len(messages))
+	for locale := range messages {
+		locales = append(locales, locale)
+	}
+	sort.Strings(locales)
+	ls := make([]cdd.LocalizedString, 0, len(locales))
+	if message, exists := messages["EN"]; exists {
+		// English first, since some clients show only the first.
+		ls = append(ls, cdd.LocalizedString{Locale: "EN", Value: message})
+	}
+	for _, locale := range locales {
+		if locale != "EN" {
+			ls = append(ls, cdd.LocalizedString{Locale: locale, Value: messages[locale]})
+		}
+	}
+	return &ls
+}
+
+// describe replaces the printer-state-reasons in the items of a vendor state
+// with their messages. The reasons move to the unlocalized descriptions.
+func (m stateReasonMessages) describe(vs *cdd.VendorState) {
+	if vs == nil {
+		return
+	}
+	for i := range vs.Item {
+		item := &vs.Item[i]
+		if item.Description != "" || item.DescriptionLocalized == nil || len(*item.DescriptionLocalized) != 1 {
+			continue
+		}
+		reason := (*item.DescriptionLocalized)[0].Value
+		if ls := m.localize(reason); ls != nil {
+			item.Description = reason
+			item.DescriptionLocalized = ls
+		}
+	}
+}
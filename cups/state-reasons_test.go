@@ -0,0 +1,75 @@
+// Copyright 2015 Google Inc. All rights reserved.
+
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+// +build linux darwin freebsd
+
+package cups
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/google/cloud-print-connector/cdd"
+)
+
+func TestStateReasonMessages(t *testing.T) {
+	f, err := ioutil.TempFile("", "state-reasons")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`{
+		"media-empty": {"EN": "No paper", "DE": "Kein Papier"},
+		"com.acme-drum-error": {"EN": "Replace the drum"}
+	}`)
+	f.Close()
+
+	m, err := newStateReasonMessages(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vs := &cdd.VendorState{
+		Item: []cdd.VendorStateItem{
+			cdd.VendorStateItem{DescriptionLocalized: cdd.NewLocalizedString("media-empty-tray-2-error")},
+			cdd.VendorStateItem{DescriptionLocalized: cdd.NewLocalizedString("com.acme-drum-error")},
+			cdd.VendorStateItem{DescriptionLocalized: cdd.NewLocalizedString("toner-low-warning")},
+			cdd.VendorStateItem{DescriptionLocalized: cdd.NewLocalizedString("broken-arrow")},
+		},
+	}
+	m.describe(vs)
+
+	expected := []cdd.VendorStateItem{
+		cdd.VendorStateItem{
+			Description: "media-empty-tray-2-error",
+			DescriptionLocalized: &[]cdd.LocalizedString{
+				cdd.LocalizedString{Locale: "EN", Value: "No paper – Tray 2"},
+				cdd.LocalizedString{Locale: "DE", Value: "Kein Papier – Tray 2"},
+			},
+		},
+		cdd.VendorStateItem{
+			Description:          "com.acme-drum-error",
+			DescriptionLocalized: cdd.NewLocalizedString("Replace the drum"),
+		},
+		cdd.VendorStateItem{
+			Description:          "toner-low-warning",
+			DescriptionLocalized: cdd.NewLocalizedString("Toner is low"),
+		},
+		cdd.VendorStateItem{DescriptionLocalized: cdd.NewLocalizedString("broken-arrow")},
+	}
+	if !reflect.DeepEqual(expected, vs.Item) {
+		t.Logf("expected\n %+v\ngot\n %+v", expected, vs.Item)
+		t.Fail()
+	}
+
+	var builtin stateReasonMessages
+	if ls := builtin.localize("media-jam-error"); ls == nil || (*ls)[0].Value != "Paper jam" {
+		t.Logf("expected the built-in message, got %+v", ls)
+		t.Fail()
+	}
+}
@@ -0,0 +1,22 @@
+#!/bin/sh
+# Runs the cups package integration tests against cupsd in a container.
+#
+# Requires docker and the CUPS development headers on the host.
+set -e
+
+HERE=$(cd "$(dirname "$0")" && pwd)
+IMAGE=cloud-print-connector-cupsd
+PORT=${CUPS_INTEGRATION_PORT:-6310}
+
+docker build -t "$IMAGE" "$HERE"
+CONTAINER=$(docker run -d -p "127.0.0.1:$PORT:631" "$IMAGE")
+trap 'docker rm -f "$CONTAINER" >/dev/null' EXIT
+
+# Wait for cupsd to listen.
+for i in 1 2 3 4 5 6 7 8 9 10; do
+  curl -s "http://127.0.0.1:$PORT/" >/dev/null && break
+  sleep 1
+done
+
+cd "$HERE/../.."
+CUPS_SERVER="127.0.0.1:$PORT" go test -v -tags integration -run Integration "$@" .
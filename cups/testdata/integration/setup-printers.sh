@@ -0,0 +1,22 @@
+#!/bin/sh
+# Creates the virtual queues that integration_test.go expects. Queues other
+# than cups-pdf discard their output and use the PPDs in ppd/, so that the
+# expected capabilities don't depend on which drivers the image ships.
+set -e
+
+/usr/sbin/cupsd
+# Wait for the scheduler to accept requests.
+for i in 1 2 3 4 5 6 7 8 9 10; do
+  lpstat -r >/dev/null 2>&1 && break
+  sleep 1
+done
+
+lpadmin -p cups-pdf -E -v cups-pdf:/ -m lsb/usr/cups-pdf/CUPS-PDF.ppd
+lpadmin -p laser-duplex -E -v file:///dev/null -P /ppd/laser-duplex.ppd
+lpadmin -p color-inkjet -E -v file:///dev/null -P /ppd/color-inkjet.ppd
+lpadmin -p basic-mono -E -v file:///dev/null -P /ppd/basic-mono.ppd
+lpadmin -p raw -E -v file:///dev/null -m raw
+lpadmin -p stopped -E -v file:///dev/null -P /ppd/basic-mono.ppd
+cupsdisable stopped
+
+kill "$(cat /var/run/cups/cupsd.pid)"
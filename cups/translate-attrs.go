@@ -9,13 +9,18 @@
 package cups
 
 import (
+	"bytes"
+	"encoding/binary"
 	"fmt"
 	"reflect"
+	"regexp"
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/google/cloud-print-connector/cdd"
+	"github.com/google/cloud-print-connector/lib"
 	"github.com/google/cloud-print-connector/log"
 )
 
@@ -38,9 +43,16 @@ func translateAttrs(printerTags map[string][]string) (*cdd.PrinterDescriptionSec
 	desc.PageOrientation = convertPageOrientation(printerTags)
 	desc.Copies = convertCopies(printerTags)
 	desc.Color = convertColorAttrs(printerTags)
+	desc.DPI = convertDPIAttrs(printerTags)
 	if vc := convertPagesPerSheet(printerTags); vc != nil {
 		*desc.VendorCapability = append(*desc.VendorCapability, *vc)
 	}
+	if vc := convertJobPriority(printerTags); vc != nil {
+		*desc.VendorCapability = append(*desc.VendorCapability, *vc)
+	}
+	if pdfPasswordSupported {
+		*desc.VendorCapability = append(*desc.VendorCapability, pdfPasswordCapability)
+	}
 
 	state.State = getState(printerTags)
 	state.VendorState = getVendorState(printerTags)
@@ -55,18 +67,29 @@ func translateAttrs(printerTags map[string][]string) (*cdd.PrinterDescriptionSec
 
 func getUUID(printerTags map[string][]string) string {
 	var uuid string
-	if u, ok := printerTags[attrPrinterUUID]; ok {
+	if u, ok := printerTags[attrPrinterUUID]; ok && len(u) > 0 {
 		uuid = u[0]
 		uuid = strings.TrimPrefix(uuid, "urn:")
 		uuid = strings.TrimPrefix(uuid, "uuid:")
-	} else if u, ok := printerTags[attrPrinterName]; ok {
+	} else if u, ok := printerTags[attrPrinterName]; ok && len(u) > 0 {
 		// CUPS < 1.5 doesn't send a printer-uuid attribute.
 		uuid = u[0]
 	}
 	return uuid
 }
 
+// rejectingJobs is true when a CUPS queue rejects new jobs, like after
+// cupsreject.
+func rejectingJobs(printerTags map[string][]string) bool {
+	a, ok := printerTags[attrPrinterIsAcceptingJobs]
+	return ok && len(a) > 0 && a[0] == attrFalse
+}
+
 func getState(printerTags map[string][]string) cdd.CloudDeviceStateType {
+	if rejectingJobs(printerTags) {
+		return cdd.CloudDeviceStateStopped
+	}
+
 	// Some CUPS backends (e.g. usb-darwin) add offline-report
 	// to printer-state-reasons when the printer is offline/disconnected
 	reasons, exists := printerTags[attrPrinterStateReasons]
@@ -78,7 +101,7 @@ func getState(printerTags map[string][]string) cdd.CloudDeviceStateType {
 		}
 	}
 
-	if s, ok := printerTags[attrPrinterState]; ok {
+	if s, ok := printerTags[attrPrinterState]; ok && len(s) > 0 {
 		switch s[0] {
 		case "3":
 			return cdd.CloudDeviceStateIdle
@@ -94,14 +117,21 @@ func getState(printerTags map[string][]string) cdd.CloudDeviceStateType {
 }
 
 func getVendorState(printerTags map[string][]string) *cdd.VendorState {
-	reasons, exists := printerTags[attrPrinterStateReasons]
-	if !exists || len(reasons) < 1 {
+	reasons := printerTags[attrPrinterStateReasons]
+	rejecting := rejectingJobs(printerTags)
+	if len(reasons) < 1 && !rejecting {
 		return nil
 	}
 
 	sort.Strings(reasons)
-	vendorState := &cdd.VendorState{Item: make([]cdd.VendorStateItem, len(reasons))}
-	for i, reason := range reasons {
+	vendorState := &cdd.VendorState{Item: make([]cdd.VendorStateItem, 0, len(reasons)+1)}
+	if rejecting {
+		vendorState.Item = append(vendorState.Item, cdd.VendorStateItem{
+			State:                cdd.VendorStateError,
+			DescriptionLocalized: cdd.NewLocalizedString("queue is rejecting jobs"),
+		})
+	}
+	for _, reason := range reasons {
 		vs := cdd.VendorStateItem{DescriptionLocalized: cdd.NewLocalizedString(reason)}
 		if strings.HasSuffix(reason, "-error") {
 			vs.State = cdd.VendorStateError
@@ -112,7 +142,7 @@ func getVendorState(printerTags map[string][]string) *cdd.VendorState {
 		} else {
 			vs.State = cdd.VendorStateError
 		}
-		vendorState.Item[i] = vs
+		vendorState.Item = append(vendorState.Item, vs)
 	}
 
 	return vendorState
@@ -210,6 +240,31 @@ var cupsMarkerNameToGCP map[string]cdd.MarkerColorType = map[string]cdd.MarkerCo
 	"blue":         cdd.MarkerColorBlue,
 }
 
+// cupsMaintenanceMarkerTypes are the CUPS marker types of replaceable parts
+// that CDD has no marker type for, which become custom markers.
+var cupsMaintenanceMarkerTypes = map[string]struct{}{
+	"waste-toner":        struct{}{},
+	"wastetoner":         struct{}{},
+	"waste-ink":          struct{}{},
+	"wasteink":           struct{}{},
+	"opc":                struct{}{},
+	"drum":               struct{}{},
+	"developer":          struct{}{},
+	"fuser":              struct{}{},
+	"fuser-oil":          struct{}{},
+	"fuser-oiler":        struct{}{},
+	"fuser-oil-wick":     struct{}{},
+	"fuser-cleaning-pad": struct{}{},
+	"transfer-unit":      struct{}{},
+	"transferunit":       struct{}{},
+	"cleaner-unit":       struct{}{},
+	"corona-wire":        struct{}{},
+}
+
+// markerLevelSomeRemaining is the marker level of a supply of which some
+// remains, but whose level is otherwise unknown (RFC 3805).
+const markerLevelSomeRemaining = -3
+
 // convertMarkers converts CUPS marker-(names|types|levels) to *[]cdd.Marker and *cdd.MarkerState.
 //
 // Normalizes marker type: toner(Cartridge|-cartridge) => toner,
@@ -220,14 +275,11 @@ func convertMarkers(printerTags map[string][]string) (*[]cdd.Marker, *cdd.Marker
 		return nil, nil
 	}
 
+	// Names and types that CUPS split at a comma are joined by quirks.
 	if len(names) != len(levels) {
-		newNames := fixMarkers(names)
-		if len(newNames) != len(levels) {
-			log.Warningf("Received badly-formatted marker-names from CUPS: %s, %s, %s",
-				strings.Join(names, ";"), strings.Join(types, ";"), strings.Join(levels, ";"))
-			return nil, nil
-		}
-		names = newNames
+		log.Warningf("Received badly-formatted marker-names from CUPS: %s, %s, %s",
+			strings.Join(names, ";"), strings.Join(types, ";"), strings.Join(levels, ";"))
+		return nil, nil
 	}
 
 	{
@@ -241,13 +293,9 @@ func convertMarkers(printerTags map[string][]string) (*[]cdd.Marker, *cdd.Marker
 	}
 
 	if len(types) != len(levels) {
-		newTypes := fixMarkers(types)
-		if len(newTypes) != len(levels) {
-			log.Warningf("Received badly-formatted marker-types from CUPS: %s, %s, %s",
-				strings.Join(names, ";"), strings.Join(types, ";"), strings.Join(levels, ";"))
-			return nil, nil
-		}
-		types = newTypes
+		log.Warningf("Received badly-formatted marker-types from CUPS: %s, %s, %s",
+			strings.Join(names, ";"), strings.Join(types, ";"), strings.Join(levels, ";"))
+		return nil, nil
 	}
 
 	markers := make([]cdd.Marker, 0, len(names))
@@ -262,10 +310,13 @@ func convertMarkers(printerTags map[string][]string) (*[]cdd.Marker, *cdd.Marker
 			markerType = cdd.MarkerToner
 		case "ink", "inkcartridge", "ink-cartridge", "ink-ribbon", "inkribbon":
 			markerType = cdd.MarkerInk
-		case "staples":
+		case "staples", "stitching-wire", "stitchingwire":
 			markerType = cdd.MarkerStaples
 		default:
-			continue
+			if _, exists := cupsMaintenanceMarkerTypes[strings.ToLower(types[i])]; !exists {
+				continue
+			}
+			markerType = cdd.MarkerCustom
 		}
 
 		var color *cdd.MarkerColor
@@ -299,12 +350,21 @@ func convertMarkers(printerTags map[string][]string) (*[]cdd.Marker, *cdd.Marker
 			Type:     markerType,
 			Color:    color,
 		}
+		if markerType == cdd.MarkerCustom {
+			marker.CustomDisplayNameLocalized = cdd.NewLocalizedString(strings.Replace(names[i], "-", " ", -1))
+		}
 
 		level, err := strconv.ParseInt(levels[i], 10, 32)
 		if err != nil {
 			log.Warningf("Failed to parse CUPS marker state %s=%s: %s", names[i], levels[i], err)
 			return nil, nil
 		}
+		if level == markerLevelSomeRemaining {
+			// The level is unknown, so leave it out.
+			markers = append(markers, marker)
+			states.Item = append(states.Item, cdd.MarkerStateItem{VendorID: names[i], State: cdd.MarkerStateOK})
+			continue
+		}
 		if level > 100 {
 			// Lop off extra (proprietary?) bits.
 			level = level & 0x7f
@@ -333,21 +393,6 @@ func convertMarkers(printerTags map[string][]string) (*[]cdd.Marker, *cdd.Marker
 	return &markers, &states
 }
 
-//fixMarkers corrects some drivers' marker names/types where CUPS detects names/types with a comma
-// as two separate values. The second value of these pairs contain a space, so it's easy to detect.
-func fixMarkers(values []string) []string {
-	var newValues []string
-
-	for i := range values {
-		if i > 0 && len(values[i]) > 1 && values[i][0] == ' ' {
-			newValues[len(newValues)-1] = strings.Join([]string{newValues[len(newValues)-1], values[i]}, ",")
-		} else {
-			newValues = append(newValues, values[i])
-		}
-	}
-	return newValues
-}
-
 func convertPagesPerSheet(printerTags map[string][]string) *cdd.VendorCapability {
 	numberUpSupported, exists := printerTags[attrNumberUpSupported]
 	if !exists {
@@ -362,7 +407,7 @@ func convertPagesPerSheet(printerTags map[string][]string) *cdd.VendorCapability
 	}
 
 	def, exists := printerTags[attrNumberUpDefault]
-	if !exists {
+	if !exists || len(def) < 1 {
 		def = []string{"1"}
 	}
 
@@ -378,6 +423,49 @@ func convertPagesPerSheet(printerTags map[string][]string) *cdd.VendorCapability
 	return &c
 }
 
+var (
+	// pdfPasswordSupported is whether the connector can decrypt PDFs.
+	pdfPasswordSupported = lib.CanDecryptPDF()
+
+	// pdfPasswordCapability lets users give the password of an encrypted
+	// PDF, which is decrypted when the job is downloaded.
+	pdfPasswordCapability = cdd.VendorCapability{
+		ID:   lib.PDFPasswordVendorID,
+		Type: cdd.VendorCapabilityTypedValue,
+		TypedValueCap: &cdd.TypedValueCapability{
+			ValueType: cdd.TypedValueCapabilityTypeString,
+		},
+		DisplayNameLocalized: cdd.NewLocalizedString("PDF password"),
+	}
+)
+
+// convertJobPriority describes the IPP job-priority attribute, which orders
+// the jobs waiting in a CUPS queue, from 1 (last) to 100 (first).
+func convertJobPriority(printerTags map[string][]string) *cdd.VendorCapability {
+	if _, exists := printerTags[attrJobPrioritySupported]; !exists {
+		return nil
+	}
+
+	def := int64(jobPriorityDefault)
+	if d, exists := printerTags[attrJobPriorityDefault]; exists && len(d) > 0 {
+		if p, err := strconv.ParseInt(d[0], 10, 32); err == nil && p >= jobPriorityMin && p <= jobPriorityMax {
+			def = p
+		}
+	}
+
+	return &cdd.VendorCapability{
+		ID:   attrJobPriority,
+		Type: cdd.VendorCapabilityRange,
+		RangeCap: &cdd.RangeCapability{
+			ValueType: cdd.RangeCapabilityValueInteger,
+			Default:   strconv.FormatInt(def, 10),
+			Min:       strconv.FormatInt(jobPriorityMin, 10),
+			Max:       strconv.FormatInt(jobPriorityMax, 10),
+		},
+		DisplayNameLocalized: cdd.NewLocalizedString("Job priority"),
+	}
+}
+
 var (
 	pageOrientationByValue map[string]cdd.PageOrientationType = map[string]cdd.PageOrientationType{
 		"3":    cdd.PageOrientationPortrait,
@@ -431,12 +519,23 @@ func convertCopies(printerTags map[string][]string) *cdd.Copies {
 		return nil
 	} else {
 		c := strings.SplitN(copiesSupported[0], "~", 2)
+		if len(c) != 2 {
+			return nil
+		}
 		max, err = strconv.ParseInt(c[1], 10, 32)
-		if err != nil {
+		if err != nil || max < 1 {
 			return nil
 		}
 	}
 
+	// Keep the default within the range that the printer accepts.
+	if def < 1 {
+		def = 1
+	}
+	if def > max {
+		def = max
+	}
+
 	return &cdd.Copies{
 		Default: int32(def),
 		Max:     int32(max),
@@ -463,7 +562,7 @@ var colorByKeyword = map[string]cdd.ColorOption{
 
 func convertColorAttrs(printerTags map[string][]string) *cdd.Color {
 	colorSupported, exists := printerTags[attrPrintColorModeSupported]
-	if !exists {
+	if !exists || len(colorSupported) < 1 {
 		return nil
 	}
 
@@ -492,3 +591,94 @@ func convertColorAttrs(printerTags map[string][]string) *cdd.Color {
 
 	return &c
 }
+
+// rIPPResolution matches IPP resolution values, as formatted by
+// attributesToMap.
+var rIPPResolution = regexp.MustCompile(`^(\d+)x(\d+)ppi$`)
+
+// parseIPPResolution parses an IPP resolution value.
+func parseIPPResolution(resolution string) (int32, int32, bool) {
+	found := rIPPResolution.FindStringSubmatch(resolution)
+	if found == nil {
+		return 0, 0, false
+	}
+	h, err := strconv.ParseInt(found[1], 10, 32)
+	if err != nil || h <= 0 {
+		return 0, 0, false
+	}
+	v, err := strconv.ParseInt(found[2], 10, 32)
+	if err != nil || v <= 0 {
+		return 0, 0, false
+	}
+	return int32(h), int32(v), true
+}
+
+func convertDPIAttrs(printerTags map[string][]string) *cdd.DPI {
+	resolutionSupported, exists := printerTags[attrPrinterResolutionSupported]
+	if !exists || len(resolutionSupported) < 1 {
+		return nil
+	}
+
+	d := cdd.DPI{}
+	for _, resolution := range resolutionSupported {
+		h, v, ok := parseIPPResolution(resolution)
+		if !ok {
+			continue
+		}
+		r := formatResolution(h, v)
+		d.Option = appendDPIOption(d.Option, cdd.DPIOption{
+			HorizontalDPI:              h,
+			VerticalDPI:                v,
+			VendorID:                   attrPrinterResolution + internalKeySeparator + r,
+			CustomDisplayNameLocalized: cdd.NewLocalizedString(r),
+		})
+	}
+
+	if len(d.Option) == 0 {
+		return nil
+	}
+
+	var defaultDPI *cdd.DPIOption
+	if resolutionDefault, exists := printerTags[attrPrinterResolutionDefault]; exists && len(resolutionDefault) == 1 {
+		if h, v, ok := parseIPPResolution(resolutionDefault[0]); ok {
+			defaultDPI = &cdd.DPIOption{HorizontalDPI: h, VerticalDPI: v}
+		}
+	}
+	markDefaultDPI(d.Option, defaultDPI)
+
+	return &d
+}
+
+// convertIPPDateToTime converts an RFC 2579 date to a time.Time object.
+// Missing bytes are read as zero.
+func convertIPPDateToTime(date []byte) time.Time {
+	r := bytes.NewReader(date)
+	var year uint16
+	var month, day, hour, min, sec, dsec uint8
+	binary.Read(r, binary.BigEndian, &year)
+	binary.Read(r, binary.BigEndian, &month)
+	binary.Read(r, binary.BigEndian, &day)
+	binary.Read(r, binary.BigEndian, &hour)
+	binary.Read(r, binary.BigEndian, &min)
+	binary.Read(r, binary.BigEndian, &sec)
+	binary.Read(r, binary.BigEndian, &dsec)
+
+	var utcDirection, utcHour, utcMin uint8
+	binary.Read(r, binary.BigEndian, &utcDirection)
+	binary.Read(r, binary.BigEndian, &utcHour)
+	binary.Read(r, binary.BigEndian, &utcMin)
+
+	var utcOffset time.Duration
+	utcOffset += time.Duration(utcHour) * time.Hour
+	utcOffset += time.Duration(utcMin) * time.Minute
+	var loc *time.Location
+	if utcDirection == '-' {
+		loc = time.FixedZone("", -int(utcOffset.Seconds()))
+	} else {
+		loc = time.FixedZone("", int(utcOffset.Seconds()))
+	}
+
+	nsec := int(dsec) * 100 * int(time.Millisecond)
+
+	return time.Date(int(year), time.Month(month), int(day), int(hour), int(min), int(sec), nsec, loc)
+}
@@ -12,6 +12,7 @@ import (
 	"encoding/json"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/google/cloud-print-connector/cdd"
 	"github.com/google/cloud-print-connector/log"
@@ -117,6 +118,13 @@ func TestGetState(t *testing.T) {
 		t.Logf("expected %+v, got %+v", cdd.CloudDeviceStateProcessing, state)
 		t.Fail()
 	}
+
+	pt = map[string][]string{attrPrinterState: []string{"3"}, attrPrinterIsAcceptingJobs: []string{"false"}}
+	state = getState(pt)
+	if cdd.CloudDeviceStateStopped != state {
+		t.Logf("expected %+v for a queue that rejects jobs, got %+v", cdd.CloudDeviceStateStopped, state)
+		t.Fail()
+	}
 }
 
 func TestGetVendorState(t *testing.T) {
@@ -153,6 +161,21 @@ func TestGetVendorState(t *testing.T) {
 		t.Logf("expected\n %+v\ngot\n %+v", expected, vs)
 		t.Fail()
 	}
+
+	pt = map[string][]string{attrPrinterIsAcceptingJobs: []string{"false"}}
+	expected = &cdd.VendorState{
+		Item: []cdd.VendorStateItem{
+			cdd.VendorStateItem{
+				DescriptionLocalized: cdd.NewLocalizedString("queue is rejecting jobs"),
+				State:                cdd.VendorStateError,
+			},
+		},
+	}
+	vs = getVendorState(pt)
+	if !reflect.DeepEqual(expected, vs) {
+		t.Logf("expected\n %+v\ngot\n %+v", expected, vs)
+		t.Fail()
+	}
 }
 
 func TestConvertSupportedContentType(t *testing.T) {
@@ -279,6 +302,11 @@ func TestConvertMarkers(t *testing.T) {
 		attrMarkerTypes:  []string{"toner", "toner", "ink", "staples", "water", " Reorder H2O"},
 		attrMarkerLevels: []string{"10", "11", "12", "208", "13"},
 	}
+	q, err := newQuirks("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.applyAttributes(pt)
 	mExpected := &[]cdd.Marker{
 		cdd.Marker{
 			VendorID: "black, Reorder Part #12345",
@@ -407,6 +435,59 @@ func TestConvertMarkers(t *testing.T) {
 		t.Logf("expected\n %s\ngot\n %s", e, f)
 		t.Fail()
 	}
+
+	pt = map[string][]string{
+		attrMarkerNames:  []string{"Waste Toner Box", "Imaging-Drum", "Fuser Kit", "Stapler", "Water"},
+		attrMarkerTypes:  []string{"waste-toner", "opc", "fuser", "stitching-wire", "water"},
+		attrMarkerLevels: []string{"90", "50", "5", "80", "13"},
+	}
+	mExpected = &[]cdd.Marker{
+		cdd.Marker{
+			VendorID:                   "Waste Toner Box",
+			Type:                       cdd.MarkerCustom,
+			CustomDisplayNameLocalized: cdd.NewLocalizedString("Waste Toner Box"),
+		},
+		cdd.Marker{
+			VendorID:                   "Imaging-Drum",
+			Type:                       cdd.MarkerCustom,
+			CustomDisplayNameLocalized: cdd.NewLocalizedString("Imaging Drum"),
+		},
+		cdd.Marker{
+			VendorID:                   "Fuser Kit",
+			Type:                       cdd.MarkerCustom,
+			CustomDisplayNameLocalized: cdd.NewLocalizedString("Fuser Kit"),
+		},
+		cdd.Marker{
+			VendorID: "Stapler",
+			Type:     cdd.MarkerStaples,
+		},
+	}
+	m, ms = convertMarkers(pt)
+	if !reflect.DeepEqual(mExpected, m) {
+		e, _ := json.Marshal(mExpected)
+		f, _ := json.Marshal(m)
+		t.Logf("expected\n %s\ngot\n %s", e, f)
+		t.Fail()
+	}
+	if ms == nil || len(ms.Item) != 4 || ms.Item[2].State != cdd.MarkerStateExhausted {
+		t.Logf("expected 4 marker states, with the fuser exhausted, got %+v", ms)
+		t.Fail()
+	}
+
+	pt = map[string][]string{
+		attrMarkerNames:  []string{"black", "cyan"},
+		attrMarkerTypes:  []string{"toner", "toner"},
+		attrMarkerLevels: []string{"-3", "40"},
+	}
+	m, ms = convertMarkers(pt)
+	if m == nil || len(*m) != 2 || ms == nil || len(ms.Item) != 2 {
+		t.Logf("expected 2 markers and states, got %+v %+v", m, ms)
+		t.FailNow()
+	}
+	if ms.Item[0].State != cdd.MarkerStateOK || ms.Item[0].LevelPercent != nil {
+		t.Logf("expected a marker with some remaining to be OK at an unknown level, got %+v", ms.Item[0])
+		t.Fail()
+	}
 }
 
 func TestConvertPagesPerSheet(t *testing.T) {
@@ -460,6 +541,43 @@ func TestConvertPagesPerSheet(t *testing.T) {
 	}
 }
 
+func TestConvertJobPriority(t *testing.T) {
+	if vc := convertJobPriority(map[string][]string{}); vc != nil {
+		t.Logf("expected nil, got %+v", vc)
+		t.Fail()
+	}
+
+	pt := map[string][]string{
+		"job-priority-default":   []string{"40"},
+		"job-priority-supported": []string{"100"},
+	}
+	expected := &cdd.VendorCapability{
+		ID:   "job-priority",
+		Type: cdd.VendorCapabilityRange,
+		RangeCap: &cdd.RangeCapability{
+			ValueType: cdd.RangeCapabilityValueInteger,
+			Default:   "40",
+			Min:       "1",
+			Max:       "100",
+		},
+		DisplayNameLocalized: cdd.NewLocalizedString("Job priority"),
+	}
+	vc := convertJobPriority(pt)
+	if !reflect.DeepEqual(expected, vc) {
+		e, _ := json.Marshal(expected)
+		f, _ := json.Marshal(vc)
+		t.Logf("expected\n %s\ngot\n %s", e, f)
+		t.Fail()
+	}
+
+	pt["job-priority-default"] = []string{"500"}
+	vc = convertJobPriority(pt)
+	if vc == nil || vc.RangeCap.Default != "50" {
+		t.Logf("expected out-of-range default to become 50, got %+v", vc)
+		t.Fail()
+	}
+}
+
 func TestConvertPageOrientation(t *testing.T) {
 	po := convertPageOrientation(nil)
 	if po != nil {
@@ -563,6 +681,20 @@ func TestConvertCopies(t *testing.T) {
 		t.Logf("expected %+v, got %+v", expected, c)
 		t.Fail()
 	}
+
+	pt = map[string][]string{
+		"copies-default":   []string{"20"},
+		"copies-supported": []string{"1~10"},
+	}
+	expected = &cdd.Copies{
+		Default: int32(10),
+		Max:     int32(10),
+	}
+	c = convertCopies(pt)
+	if !reflect.DeepEqual(expected, c) {
+		t.Logf("expected %+v, got %+v", expected, c)
+		t.Fail()
+	}
 }
 
 func TestConvertColorAttrs(t *testing.T) {
@@ -597,3 +729,95 @@ func TestConvertColorAttrs(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestConvertDPIAttrs(t *testing.T) {
+	d := convertDPIAttrs(map[string][]string{})
+	if d != nil {
+		t.Logf("expected nil")
+		t.Fail()
+	}
+
+	pt := map[string][]string{
+		"printer-resolution-default":   []string{"600x600ppi"},
+		"printer-resolution-supported": []string{"300x300ppi", "600x600ppi", "600x600ppi", "1200x600ppi", "bogus"},
+	}
+	expected := &cdd.DPI{
+		Option: []cdd.DPIOption{
+			cdd.DPIOption{300, 300, false, "", "printer-resolution:300dpi", cdd.NewLocalizedString("300dpi")},
+			cdd.DPIOption{600, 600, true, "", "printer-resolution:600dpi", cdd.NewLocalizedString("600dpi")},
+			cdd.DPIOption{1200, 600, false, "", "printer-resolution:1200x600dpi", cdd.NewLocalizedString("1200x600dpi")},
+		},
+	}
+	d = convertDPIAttrs(pt)
+	if !reflect.DeepEqual(expected, d) {
+		t.Logf("expected %+v, got %+v", expected, d)
+		t.Fail()
+	}
+}
+
+func BenchmarkTranslateAttrs(b *testing.B) {
+	pt := map[string][]string{
+		attrPrinterName:                   []string{"printer"},
+		attrPrinterInfo:                   []string{"Printer on the third floor"},
+		attrPrinterUUID:                   []string{"urn:uuid:ea3a5c7c-7ddf-3e1f-6f4b-5d2c5ae01ac8"},
+		attrPrinterState:                  []string{"3"},
+		attrPrinterStateReasons:           []string{"media-low-warning", "toner-low-report"},
+		attrDocumentFormatSupported:       []string{"application/pdf", "application/postscript", "image/jpeg"},
+		attrMarkerNames:                   []string{"black", "cyan", "magenta", "yellow"},
+		attrMarkerTypes:                   []string{"toner", "toner", "toner", "toner"},
+		attrMarkerLevels:                  []string{"10", "11", "12", "13"},
+		attrCopiesDefault:                 []string{"1"},
+		attrCopiesSupported:               []string{"1~99"},
+		attrNumberUpDefault:               []string{"1"},
+		attrNumberUpSupported:             []string{"1", "2", "4", "6", "9", "16"},
+		attrOrientationRequestedDefault:   []string{"3"},
+		attrOrientationRequestedSupported: []string{"3", "4"},
+		attrPrintColorModeDefault:         []string{"color"},
+		attrPrintColorModeSupported:       []string{"color", "monochrome"},
+	}
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		translateAttrs(pt)
+	}
+}
+
+// attributesToMap represents "none" and empty values as empty slices.
+func TestTranslateAttrsEmptyValues(t *testing.T) {
+	pt := map[string][]string{
+		attrPrinterName:             []string{},
+		attrPrinterUUID:             []string{},
+		attrPrinterState:            []string{},
+		attrNumberUpDefault:         []string{},
+		attrNumberUpSupported:       []string{"1"},
+		attrCopiesSupported:         []string{"0"},
+		attrPrintColorModeSupported: []string{},
+	}
+	pds, pss, _, _, uuid, _ := translateAttrs(pt)
+	if uuid != "" {
+		t.Logf("expected empty UUID, got %s", uuid)
+		t.Fail()
+	}
+	if pss.State != cdd.CloudDeviceStateIdle {
+		t.Logf("expected %s, got %s", cdd.CloudDeviceStateIdle, pss.State)
+		t.Fail()
+	}
+	if pds.Copies != nil || pds.Color != nil {
+		t.Logf("expected nil copies and color, got %+v %+v", pds.Copies, pds.Color)
+		t.Fail()
+	}
+}
+
+func TestConvertIPPDateToTime(t *testing.T) {
+	// 2015-06-17 13:45:30.5 -07:00
+	date := []byte{0x07, 0xdf, 6, 17, 13, 45, 30, 5, '-', 7, 0}
+	expected := time.Date(2015, 6, 17, 13, 45, 30, 500*int(time.Millisecond), time.FixedZone("", -7*60*60))
+	if got := convertIPPDateToTime(date); !got.Equal(expected) {
+		t.Logf("expected %s, got %s", expected, got)
+		t.Fail()
+	}
+
+	// Short input must not panic.
+	convertIPPDateToTime(date[:3])
+	convertIPPDateToTime(nil)
+}
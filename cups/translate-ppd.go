@@ -11,6 +11,7 @@ package cups
 import (
 	"fmt"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -26,6 +27,11 @@ const (
 	ppdCloseSubGroup           = "CloseSubGroup"
 	ppdCloseUI                 = "CloseUI"
 	ppdColorModel              = "ColorModel"
+	ppdCollate                 = "Collate"
+	ppdCUPSFilter              = "cupsFilter"
+	ppdCUPSFilter2             = "cupsFilter2"
+	ppdCUPSManualCopies        = "cupsManualCopies"
+	ppdCUPSMaxCopies           = "cupsMaxCopies"
 	ppdDefault                 = "Default"
 	ppdDuplex                  = "Duplex"
 	ppdDuplexNoTumble          = "DuplexNoTumble"
@@ -33,6 +39,7 @@ const (
 	ppdEnd                     = "End"
 	ppdFalse                   = "False"
 	ppdHWMargins               = "HWMargins"
+	ppdImageableArea           = "ImageableArea"
 	ppdInstallableOptions      = "InstallableOptions"
 	ppdJCLCloseUI              = "JCLCloseUI"
 	ppdJCLOpenUI               = "JCLOpenUI"
@@ -51,16 +58,22 @@ const (
 	ppdOpenSubGroup            = "OpenSubGroup"
 	ppdOpenUI                  = "OpenUI"
 	ppdOutputBin               = "OutputBin"
+	ppdOutputOrder             = "OutputOrder"
 	ppdPageSize                = "PageSize"
+	ppdPaperDimension          = "PaperDimension"
 	ppdPickMany                = "PickMany"
 	ppdPickOne                 = "PickOne"
 	ppdPrintQualityTranslation = "Print Quality"
 	ppdResolution              = "Resolution"
+	ppdReverse                 = "Reverse"
 	ppdSelectColor             = "SelectColor"
 	ppdThroughput              = "Throughput"
 	ppdTrue                    = "True"
 	ppdUIConstraints           = "UIConstraints"
 
+	// mimePDF is the type of the documents that the connector submits.
+	mimePDF = "application/pdf"
+
 	// These characters are not allowed in PPD main keywords or option keywords,
 	// so they are safe to use as separators in CDD strings.
 	// A:B/C is interpreted as 2 CUPS/IPP options: A=B and C=[VendorTicketItem.Value].
@@ -90,6 +103,8 @@ var (
 		`)\s*$`)
 	rPageSize              = regexp.MustCompile(`([\d.]+)(?:mm|in)?x([\d.]+)(mm|in)?`)
 	rPageSizeInPoints      = regexp.MustCompile(`w([\d.]+)h([\d.]+)`)
+	rRollMedia             = regexp.MustCompile(`(?i)roll|continuous`)
+	rRollWidth             = regexp.MustCompile(`([\d.]+)\s*(mm|in|")`)
 	rColor                 = regexp.MustCompile(`(?i)^(?:cmy|rgb|color)`)
 	rGray                  = regexp.MustCompile(`(?i)^(?:gray|black|mono)`)
 	rCMAndResolutionPrefix = regexp.MustCompile(`(?i)^(?:on|off)\s*-?\s*`)
@@ -125,9 +140,37 @@ type entry struct {
 	options      []statement
 }
 
-// translatePPD extracts a PrinterDescriptionSection, manufacturer string, model string, and DuplexVendorMap
-// from a PPD string.
-func translatePPD(ppd string, vendorPPDOptions []string) (*cdd.PrinterDescriptionSection, string, string, lib.DuplexVendorMap) {
+// ppdHeader starts every PPD.
+const ppdHeader = "*PPD-Adobe:"
+
+// checkPPDSize checks that a PPD of size bytes is no larger than maxBytes,
+// which is no limit when zero.
+func checkPPDSize(size, maxBytes int64) error {
+	if maxBytes > 0 && size > maxBytes {
+		return fmt.Errorf("PPD is %d bytes, more than the limit of %d", size, maxBytes)
+	}
+	return nil
+}
+
+// validatePPD checks a PPD before it's translated, so that a huge or
+// corrupt PPD from a pathological driver package doesn't cost the
+// connector lots of memory for a bad translation.
+func validatePPD(ppd string, maxBytes int64) error {
+	if err := checkPPDSize(int64(len(ppd)), maxBytes); err != nil {
+		return err
+	}
+	if !strings.HasPrefix(strings.TrimPrefix(ppd, "\ufeff"), ppdHeader) {
+		return fmt.Errorf("PPD doesn't start with %s", ppdHeader)
+	}
+	if i := strings.IndexByte(ppd, 0); i >= 0 {
+		return fmt.Errorf("PPD has a NUL byte at offset %d; it's binary, not a PPD", i)
+	}
+	return nil
+}
+
+// translatePPD extracts a PrinterDescriptionSection, manufacturer string, model string, DuplexVendorMap
+// and MarginsVendorMap from a PPD string.
+func translatePPD(ppd string, vendorPPDOptions []string) (*cdd.PrinterDescriptionSection, string, string, lib.DuplexVendorMap, lib.MarginsVendorMap) {
 	statements := ppdToStatements(ppd)
 	openUIStatements, installables, uiConstraints, standAlones := groupStatements(statements)
 	openUIStatements = filterConstraints(openUIStatements, installables, uiConstraints)
@@ -165,6 +208,14 @@ func translatePPD(ppd string, vendorPPDOptions []string) (*cdd.PrinterDescriptio
 		pds.DPI = convertDPI(e)
 		consideredMainKeywords[e.mainKeyword] = struct{}{}
 	}
+	if e, exists := entriesByMainKeyword[ppdCollate]; exists {
+		pds.Collate = &cdd.Collate{Default: e.defaultValue == ppdTrue}
+		consideredMainKeywords[e.mainKeyword] = struct{}{}
+	}
+	if e, exists := entriesByMainKeyword[ppdOutputOrder]; exists {
+		pds.ReverseOrder = &cdd.ReverseOrder{Default: e.defaultValue == ppdReverse}
+		consideredMainKeywords[e.mainKeyword] = struct{}{}
+	}
 	if e, exists := entriesByMainKeyword[ppdOutputBin]; exists {
 		*pds.VendorCapability = append(*pds.VendorCapability, *convertVendorCapability(e))
 		consideredMainKeywords[e.mainKeyword] = struct{}{}
@@ -189,7 +240,15 @@ func translatePPD(ppd string, vendorPPDOptions []string) (*cdd.PrinterDescriptio
 		vendorPPDOptionsMap[o] = struct{}{}
 	}
 	_, translateAllVendorPPDOptions := vendorPPDOptionsMap["all"]
-	for _, e := range entriesByMainKeyword {
+	// Sort so that the capabilities, and so the printer's CDD, don't
+	// change from one translation to the next.
+	mainKeywords := make([]string, 0, len(entriesByMainKeyword))
+	for mainKeyword := range entriesByMainKeyword {
+		mainKeywords = append(mainKeywords, mainKeyword)
+	}
+	sort.Strings(mainKeywords)
+	for _, mainKeyword := range mainKeywords {
+		e := entriesByMainKeyword[mainKeyword]
 		if _, exists := consideredMainKeywords[e.mainKeyword]; exists {
 			continue
 		}
@@ -206,7 +265,10 @@ func translatePPD(ppd string, vendorPPDOptions []string) (*cdd.PrinterDescriptio
 		pds.VendorCapability = nil
 	}
 
-	var manufacturer, model string
+	var manufacturer, model, defaultOutputOrder string
+	manualCopies, filtersPDF := true, true
+	imageableAreas := make(map[string]string)
+	paperDimensions := make(map[string]string)
 	for _, s := range standAlones {
 		switch s.mainKeyword {
 		case ppdManufacturer:
@@ -217,11 +279,46 @@ func translatePPD(ppd string, vendorPPDOptions []string) (*cdd.PrinterDescriptio
 			pds.Margins = convertMargins(s.value)
 		case ppdThroughput:
 			pds.PrintingSpeed = convertPrintingSpeed(s.value, pds.Color)
+		case ppdCUPSMaxCopies:
+			pds.Copies = convertMaxCopies(s.value)
+		case ppdImageableArea:
+			imageableAreas[s.optionKeyword] = s.value
+		case ppdPaperDimension:
+			paperDimensions[s.optionKeyword] = s.value
+		case ppdCUPSManualCopies:
+			manualCopies = s.value != ppdFalse
+		case ppdCUPSFilter, ppdCUPSFilter2:
+			if strings.HasPrefix(s.value, mimePDF+" ") {
+				// The driver takes PDF as-is, so pdftopdf never runs.
+				filtersPDF = false
+			}
+		case ppdDefault + ppdOutputOrder:
+			defaultOutputOrder = s.value
+		}
+	}
+	if filtersPDF {
+		// The CUPS pdftopdf filter collates and reverses when the
+		// printer can't. Collating needs copies made in software.
+		if pds.Collate == nil && manualCopies {
+			pds.Collate = &cdd.Collate{Default: true}
+		}
+		if pds.ReverseOrder == nil {
+			pds.ReverseOrder = &cdd.ReverseOrder{Default: defaultOutputOrder == ppdReverse}
+		}
+	}
+	var marginsMap lib.MarginsVendorMap
+	if e, exists := entriesByMainKeyword[ppdPageSize]; exists {
+		var margins *cdd.Margins
+		margins, marginsMap = convertImageableArea(e, imageableAreas, paperDimensions)
+		if pds.Margins == nil {
+			// *HWMargins is the whole-device answer; without it, use the
+			// printable area of each page size.
+			pds.Margins = margins
 		}
 	}
 	model = strings.TrimLeft(strings.TrimPrefix(model, manufacturer), " ")
 
-	return &pds, manufacturer, model, duplexMap
+	return &pds, manufacturer, model, duplexMap, marginsMap
 }
 
 // ppdToStatements converts a PPD file to a slice of statements.
@@ -444,6 +541,97 @@ func convertMargins(hwMargins string) *cdd.Margins {
 	}
 }
 
+// convertImageableArea derives margins from the *ImageableArea and
+// *PaperDimension of each page size, which it maps by page size. CDD
+// margins are the same for every media size, so the default page size's
+// margins become the STANDARD option; if any page size prints to the edge,
+// a BORDERLESS option is offered too.
+func convertImageableArea(pageSize entry, imageableAreas, paperDimensions map[string]string) (*cdd.Margins, lib.MarginsVendorMap) {
+	var standard *cdd.MarginsOption
+	var borderless bool
+	marginsMap := lib.MarginsVendorMap{}
+
+	for _, option := range pageSize.options {
+		margins := pageSizeMargins(imageableAreas[option.optionKeyword], paperDimensions[option.optionKeyword])
+		if margins == nil {
+			continue
+		}
+		marginsMap[option.optionKeyword] = *margins
+		if margins.Type == cdd.MarginsBorderless {
+			borderless = true
+			continue
+		}
+		if standard == nil || option.optionKeyword == pageSize.defaultValue {
+			standard = margins
+		}
+	}
+
+	var m cdd.Margins
+	if standard != nil {
+		standard.IsDefault = true
+		m.Option = append(m.Option, *standard)
+	}
+	if borderless {
+		m.Option = append(m.Option, cdd.MarginsOption{
+			Type:      cdd.MarginsBorderless,
+			IsDefault: standard == nil,
+		})
+	}
+
+	if len(m.Option) == 0 {
+		return nil, nil
+	}
+	return &m, marginsMap
+}
+
+// pageSizeMargins computes margins from one page size's imageable area,
+// "llx lly urx ury", and paper dimension, "width height", all in points.
+func pageSizeMargins(imageableArea, paperDimension string) *cdd.MarginsOption {
+	area := strings.Fields(imageableArea)
+	dimension := strings.Fields(paperDimension)
+	if len(area) != 4 || len(dimension) != 2 {
+		return nil
+	}
+
+	var v [6]float64
+	for i, s := range append(area, dimension...) {
+		f, err := strconv.ParseFloat(s, 32)
+		if err != nil {
+			return nil
+		}
+		v[i] = f
+	}
+	left, bottom, right, top := v[0], v[1], v[4]-v[2], v[5]-v[3]
+	if left < 0 || bottom < 0 || right < 0 || top < 0 {
+		return nil
+	}
+
+	o := cdd.MarginsOption{
+		Type:          cdd.MarginsStandard,
+		LeftMicrons:   pointsToMicrons(float32(left)),
+		BottomMicrons: pointsToMicrons(float32(bottom)),
+		RightMicrons:  pointsToMicrons(float32(right)),
+		TopMicrons:    pointsToMicrons(float32(top)),
+	}
+	// Imageable areas are often rounded outwards to the nearest point.
+	const edgeMicrons = 353 // One point.
+	if o.LeftMicrons <= edgeMicrons && o.BottomMicrons <= edgeMicrons &&
+		o.RightMicrons <= edgeMicrons && o.TopMicrons <= edgeMicrons {
+		return &cdd.MarginsOption{Type: cdd.MarginsBorderless}
+	}
+	return &o
+}
+
+// convertMaxCopies converts the value of *cupsMaxCopies. The default is
+// always one copy; CUPS provides the real default as copies-default.
+func convertMaxCopies(maxCopies string) *cdd.Copies {
+	max, err := strconv.ParseInt(strings.TrimSpace(maxCopies), 10, 32)
+	if err != nil || max < 1 {
+		return nil
+	}
+	return &cdd.Copies{Default: 1, Max: int32(max)}
+}
+
 func convertPrintingSpeed(throughput string, color *cdd.Color) *cdd.PrintingSpeed {
 	speedPPM, err := strconv.ParseInt(throughput, 10, 32)
 	if err != nil {
@@ -451,13 +639,14 @@ func convertPrintingSpeed(throughput string, color *cdd.Color) *cdd.PrintingSpee
 	}
 	var colorTypes *[]cdd.ColorType
 	if color != nil {
+		// Dedupe in option order, so that the result is stable.
 		mColorTypes := make(map[cdd.ColorType]struct{}, len(color.Option))
+		ct := make([]cdd.ColorType, 0, len(color.Option))
 		for _, co := range color.Option {
-			mColorTypes[co.Type] = struct{}{}
-		}
-		ct := make([]cdd.ColorType, 0, len(mColorTypes))
-		for colorType := range mColorTypes {
-			ct = append(ct, colorType)
+			if _, exists := mColorTypes[co.Type]; !exists {
+				mColorTypes[co.Type] = struct{}{}
+				ct = append(ct, co.Type)
+			}
 		}
 		colorTypes = &ct
 	}
@@ -618,41 +807,76 @@ func convertDuplex(e entry) (*cdd.Duplex, lib.DuplexVendorMap) {
 
 func convertDPI(e entry) *cdd.DPI {
 	d := cdd.DPI{}
+	var defaultDPI *cdd.DPIOption
 	for _, o := range e.options {
 		found := rResolution.FindStringSubmatch(o.optionKeyword)
 		if found == nil {
 			continue
 		}
 		h, err := strconv.ParseInt(found[1], 10, 32)
-		if err != nil {
+		if err != nil || h <= 0 {
 			continue
 		}
 		v, err := strconv.ParseInt(found[2], 10, 32)
 		if err != nil {
 			v = h
 		}
+		if v <= 0 {
+			continue
+		}
 		do := cdd.DPIOption{
 			HorizontalDPI:              int32(h),
 			VerticalDPI:                int32(v),
-			VendorID:                   o.optionKeyword,
+			VendorID:                   ppdResolution + internalKeySeparator + o.optionKeyword,
 			CustomDisplayNameLocalized: cdd.NewLocalizedString(o.translation),
 		}
-		d.Option = append(d.Option, do)
+		if o.optionKeyword == e.defaultValue {
+			defaultDPI = &do
+		}
+		d.Option = appendDPIOption(d.Option, do)
 	}
 
 	if len(d.Option) == 0 {
 		return nil
 	}
 
-	for i := range d.Option {
-		if d.Option[i].VendorID == e.defaultValue {
-			d.Option[i].IsDefault = true
-			return &d
+	markDefaultDPI(d.Option, defaultDPI)
+	return &d
+}
+
+// formatResolution formats a resolution the way that PPD Resolution
+// keywords and the CUPS printer-resolution option spell it, like 600dpi or
+// 600x1200dpi.
+func formatResolution(horizontalDPI, verticalDPI int32) string {
+	if horizontalDPI == verticalDPI {
+		return fmt.Sprintf("%ddpi", horizontalDPI)
+	}
+	return fmt.Sprintf("%dx%ddpi", horizontalDPI, verticalDPI)
+}
+
+// appendDPIOption appends o to options, unless options already has its
+// resolution.
+func appendDPIOption(options []cdd.DPIOption, o cdd.DPIOption) []cdd.DPIOption {
+	for _, existing := range options {
+		if existing.HorizontalDPI == o.HorizontalDPI && existing.VerticalDPI == o.VerticalDPI {
+			return options
 		}
 	}
+	return append(options, o)
+}
 
-	d.Option[0].IsDefault = true
-	return &d
+// markDefaultDPI marks the option with the resolution of defaultDPI as the
+// default, or the first option when defaultDPI is nil or not in options.
+func markDefaultDPI(options []cdd.DPIOption, defaultDPI *cdd.DPIOption) {
+	if defaultDPI != nil {
+		for i := range options {
+			if options[i].HorizontalDPI == defaultDPI.HorizontalDPI && options[i].VerticalDPI == defaultDPI.VerticalDPI {
+				options[i].IsDefault = true
+				return
+			}
+		}
+	}
+	options[0].IsDefault = true
 }
 
 // Convert 2 entries, JobType and LockedPrintPassword, to one CDD VendorCapability.
@@ -753,7 +977,77 @@ func convertMediaSize(e entry) *cdd.MediaSize {
 	return &ms
 }
 
+// getCustomMediaSizeOption makes a media size option from a PageSize that
+// is not in ppdMediaSizes. The size comes from a PWG self-describing name, or
+// from dimensions in the keyword or translation. Sizes that match a PWG
+// standard size get its name; roll media becomes a continuous feed option.
 func getCustomMediaSizeOption(optionKeyword, translation string) *cdd.MediaSizeOption {
+	if rRollMedia.MatchString(optionKeyword) || rRollMedia.MatchString(translation) {
+		return getRollMediaSizeOption(optionKeyword, translation)
+	}
+
+	width, height, ok := pwgMediaNameDimensions(optionKeyword)
+	if !ok {
+		width, height, ok = pageSizeDimensions(optionKeyword, translation)
+		if !ok {
+			return nil
+		}
+	}
+
+	name := cdd.MediaSizeCustom
+	if s, exists := pwgMediaSizesByName[optionKeyword]; exists {
+		name = s.name
+	} else if s, exists := pwgMediaSizeForDimensions(width, height); exists {
+		name = s.name
+	}
+
+	return &cdd.MediaSizeOption{
+		Name:                       name,
+		WidthMicrons:               width,
+		HeightMicrons:              height,
+		VendorID:                   optionKeyword,
+		CustomDisplayNameLocalized: cdd.NewLocalizedString(translation),
+	}
+}
+
+// getRollMediaSizeOption makes a continuous feed option for roll media, which
+// has a width but no height.
+func getRollMediaSizeOption(optionKeyword, translation string) *cdd.MediaSizeOption {
+	width, _, ok := pageSizeDimensions(optionKeyword, translation)
+	if !ok {
+		found := rRollWidth.FindStringSubmatch(optionKeyword)
+		if found == nil {
+			found = rRollWidth.FindStringSubmatch(translation)
+		}
+		if found == nil {
+			return nil
+		}
+		w, err := strconv.ParseFloat(found[1], 32)
+		if err != nil {
+			return nil
+		}
+		if found[2] == "mm" {
+			width = mmToMicrons(float32(w))
+		} else {
+			width = inchesToMicrons(float32(w))
+		}
+	}
+	if width <= 0 {
+		return nil
+	}
+
+	return &cdd.MediaSizeOption{
+		Name:                       cdd.MediaSizeCustom,
+		WidthMicrons:               width,
+		IsContinuousFeed:           true,
+		VendorID:                   optionKeyword,
+		CustomDisplayNameLocalized: cdd.NewLocalizedString(translation),
+	}
+}
+
+// pageSizeDimensions finds the width and height, in microns, of a PageSize
+// like 5.5x8.5, 100x150mm or w81h252.
+func pageSizeDimensions(optionKeyword, translation string) (int32, int32, bool) {
 	found := rPageSize.FindStringSubmatch(optionKeyword)
 	if found == nil {
 		found = rPageSize.FindStringSubmatch(translation)
@@ -761,21 +1055,21 @@ func getCustomMediaSizeOption(optionKeyword, translation string) *cdd.MediaSizeO
 	if found == nil {
 		found = rPageSizeInPoints.FindStringSubmatch(optionKeyword)
 		if found == nil {
-			return nil
+			return 0, 0, false
 		}
 		found = append(found, "points")
 	}
 	if len(found) != 4 {
-		return nil
+		return 0, 0, false
 	}
 
 	width, err := strconv.ParseFloat(found[1], 32)
 	if err != nil {
-		return nil
+		return 0, 0, false
 	}
 	height, err := strconv.ParseFloat(found[2], 32)
 	if err != nil {
-		return nil
+		return 0, 0, false
 	}
 
 	var toMicrons func(float32) int32
@@ -787,13 +1081,7 @@ func getCustomMediaSizeOption(optionKeyword, translation string) *cdd.MediaSizeO
 	default:
 		toMicrons = inchesToMicrons
 	}
-	return &cdd.MediaSizeOption{
-		Name:                       cdd.MediaSizeCustom,
-		WidthMicrons:               toMicrons(float32(width)),
-		HeightMicrons:              toMicrons(float32(height)),
-		VendorID:                   optionKeyword,
-		CustomDisplayNameLocalized: cdd.NewLocalizedString(translation),
-	}
+	return toMicrons(float32(width)), toMicrons(float32(height)), true
 }
 
 func inchesToMicrons(inches float32) int32 {
@@ -23,7 +23,7 @@ type testdata struct {
 }
 
 func translationTest(t *testing.T, ppd string, vendorPPDOptions []string, expected testdata) {
-	description, _, _, dm := translatePPD(ppd, vendorPPDOptions)
+	description, _, _, dm, _ := translatePPD(ppd, vendorPPDOptions)
 	actual := testdata{description, dm}
 	if !reflect.DeepEqual(expected, actual) {
 		e, _ := json.Marshal(expected)
@@ -38,6 +38,8 @@ func TestTrPrintingSpeed(t *testing.T) {
 *Throughput: "30"`
 	expected := testdata{
 		&cdd.PrinterDescriptionSection{
+			Collate:      &cdd.Collate{Default: true},
+			ReverseOrder: &cdd.ReverseOrder{},
 			PrintingSpeed: &cdd.PrintingSpeed{
 				[]cdd.PrintingSpeedOption{
 					cdd.PrintingSpeedOption{
@@ -51,6 +53,102 @@ func TestTrPrintingSpeed(t *testing.T) {
 	translationTest(t, ppd, []string{}, expected)
 }
 
+func TestTrImageableArea(t *testing.T) {
+	ppd := `*PPD-Adobe: "4.3"
+*OpenUI *PageSize: PickOne
+*DefaultPageSize: A4
+*PageSize Letter/US Letter: ""
+*PageSize A4/A4: ""
+*PageSize A4.FullBleed/A4 Borderless: ""
+*CloseUI: *PageSize
+*ImageableArea Letter/US Letter: "18 36 594 756"
+*ImageableArea A4/A4: "18 18 577 824"
+*ImageableArea A4.FullBleed/A4 Borderless: "0 0 595 842"
+*PaperDimension Letter/US Letter: "612 792"
+*PaperDimension A4/A4: "595 842"
+*PaperDimension A4.FullBleed/A4 Borderless: "595 842"`
+	description, _, _, _, marginsMap := translatePPD(ppd, []string{})
+	expected := &cdd.Margins{
+		Option: []cdd.MarginsOption{
+			cdd.MarginsOption{
+				Type:          cdd.MarginsStandard,
+				TopMicrons:    6350,
+				RightMicrons:  6350,
+				BottomMicrons: 6350,
+				LeftMicrons:   6350,
+				IsDefault:     true,
+			},
+			cdd.MarginsOption{Type: cdd.MarginsBorderless},
+		},
+	}
+	if !reflect.DeepEqual(expected, description.Margins) {
+		e, _ := json.Marshal(expected)
+		d, _ := json.Marshal(description.Margins)
+		t.Logf("expected\n %s\ngot\n %s", e, d)
+		t.Fail()
+	}
+	expectedMap := lib.MarginsVendorMap{
+		"Letter": cdd.MarginsOption{
+			Type:          cdd.MarginsStandard,
+			TopMicrons:    12700,
+			RightMicrons:  6350,
+			BottomMicrons: 12700,
+			LeftMicrons:   6350,
+		},
+		"A4": cdd.MarginsOption{
+			Type:          cdd.MarginsStandard,
+			TopMicrons:    6350,
+			RightMicrons:  6350,
+			BottomMicrons: 6350,
+			LeftMicrons:   6350,
+		},
+		"A4.FullBleed": cdd.MarginsOption{Type: cdd.MarginsBorderless},
+	}
+	if !reflect.DeepEqual(expectedMap, marginsMap) {
+		t.Logf("expected\n %+v\ngot\n %+v", expectedMap, marginsMap)
+		t.Fail()
+	}
+
+	// *HWMargins wins over the imageable area.
+	description, _, _, _, _ = translatePPD(ppd+"\n*HWMargins: 0 0 0 0", []string{})
+	expected = &cdd.Margins{
+		Option: []cdd.MarginsOption{
+			cdd.MarginsOption{Type: cdd.MarginsBorderless, IsDefault: true},
+		},
+	}
+	if !reflect.DeepEqual(expected, description.Margins) {
+		e, _ := json.Marshal(expected)
+		d, _ := json.Marshal(description.Margins)
+		t.Logf("expected\n %s\ngot\n %s", e, d)
+		t.Fail()
+	}
+}
+
+func TestTrMaxCopies(t *testing.T) {
+	ppd := `*PPD-Adobe: "4.3"
+*cupsMaxCopies: 99`
+	expected := testdata{
+		&cdd.PrinterDescriptionSection{
+			Collate:      &cdd.Collate{Default: true},
+			ReverseOrder: &cdd.ReverseOrder{},
+			Copies:       &cdd.Copies{Default: 1, Max: 99},
+		},
+		nil,
+	}
+	translationTest(t, ppd, []string{}, expected)
+
+	ppd = `*PPD-Adobe: "4.3"
+*cupsMaxCopies: 0`
+	expected = testdata{
+		&cdd.PrinterDescriptionSection{
+			Collate:      &cdd.Collate{Default: true},
+			ReverseOrder: &cdd.ReverseOrder{},
+		},
+		nil,
+	}
+	translationTest(t, ppd, []string{}, expected)
+}
+
 func TestTrMediaSize(t *testing.T) {
 	ppd := `*PPD-Adobe: "4.3"
 *OpenUI *PageSize: PickOne
@@ -61,17 +159,27 @@ func TestTrMediaSize(t *testing.T) {
 *PageSize Letter/Letter: ""
 *PageSize HalfLetter/5.5x8.5: ""
 *PageSize w81h252/Address - 1 1/8 x 3 1/2":         "<</PageSize[81 252]/ImagingBBox null>>setpagedevice"
+*PageSize w595h842/210 x 297 mm: ""
+*PageSize na_number-10_4.125x9.5in/Envelope #10: ""
+*PageSize custom_card_54x86mm/Card: ""
+*PageSize Roll24in/Roll Paper 24 in: ""
 *CloseUI: *PageSize`
 	expected := testdata{
 		&cdd.PrinterDescriptionSection{
+			Collate:      &cdd.Collate{Default: true},
+			ReverseOrder: &cdd.ReverseOrder{},
 			MediaSize: &cdd.MediaSize{
 				Option: []cdd.MediaSizeOption{
 					cdd.MediaSizeOption{cdd.MediaSizeISOA3, mmToMicrons(297), mmToMicrons(420), false, false, "", "A3", cdd.NewLocalizedString("A3")},
 					cdd.MediaSizeOption{cdd.MediaSizeISOB5, mmToMicrons(176), mmToMicrons(250), false, false, "", "ISOB5", cdd.NewLocalizedString("B5 (ISO)")},
 					cdd.MediaSizeOption{cdd.MediaSizeJISB5, mmToMicrons(182), mmToMicrons(257), false, false, "", "B5", cdd.NewLocalizedString("B5 (JIS)")},
 					cdd.MediaSizeOption{cdd.MediaSizeNALetter, inchesToMicrons(8.5), inchesToMicrons(11), false, true, "", "Letter", cdd.NewLocalizedString("Letter")},
-					cdd.MediaSizeOption{cdd.MediaSizeCustom, inchesToMicrons(5.5), inchesToMicrons(8.5), false, false, "", "HalfLetter", cdd.NewLocalizedString("5.5x8.5")},
+					cdd.MediaSizeOption{cdd.MediaSizeNAInvoice, inchesToMicrons(5.5), inchesToMicrons(8.5), false, false, "", "HalfLetter", cdd.NewLocalizedString("5.5x8.5")},
 					cdd.MediaSizeOption{cdd.MediaSizeCustom, pointsToMicrons(81), pointsToMicrons(252), false, false, "", "w81h252", cdd.NewLocalizedString(`Address - 1 1/8 x 3 1/2"`)},
+					cdd.MediaSizeOption{cdd.MediaSizeISOA4, pointsToMicrons(595), pointsToMicrons(842), false, false, "", "w595h842", cdd.NewLocalizedString("210 x 297 mm")},
+					cdd.MediaSizeOption{cdd.MediaSizeNANumber10, inchesToMicrons(4.125), inchesToMicrons(9.5), false, false, "", "na_number-10_4.125x9.5in", cdd.NewLocalizedString("Envelope #10")},
+					cdd.MediaSizeOption{cdd.MediaSizeCustom, mmToMicrons(54), mmToMicrons(86), false, false, "", "custom_card_54x86mm", cdd.NewLocalizedString("Card")},
+					cdd.MediaSizeOption{cdd.MediaSizeCustom, inchesToMicrons(24), 0, true, false, "", "Roll24in", cdd.NewLocalizedString("Roll Paper 24 in")},
 				},
 			},
 		},
@@ -80,6 +188,49 @@ func TestTrMediaSize(t *testing.T) {
 	translationTest(t, ppd, []string{}, expected)
 }
 
+func TestTrCollateAndReverseOrder(t *testing.T) {
+	ppd := `*PPD-Adobe: "4.3"
+*cupsManualCopies: False
+*DefaultOutputOrder: Reverse`
+	expected := testdata{
+		&cdd.PrinterDescriptionSection{
+			ReverseOrder: &cdd.ReverseOrder{Default: true},
+		},
+		nil,
+	}
+	translationTest(t, ppd, []string{}, expected)
+
+	ppd = `*PPD-Adobe: "4.3"
+*cupsManualCopies: False
+*OpenUI *Collate/Collate: Boolean
+*DefaultCollate: False
+*Collate True/On: ""
+*Collate False/Off: ""
+*CloseUI: *Collate
+*OpenUI *OutputOrder/Output Order: PickOne
+*DefaultOutputOrder: Normal
+*OutputOrder Normal/Normal: ""
+*OutputOrder Reverse/Reverse: ""
+*CloseUI: *OutputOrder`
+	expected = testdata{
+		&cdd.PrinterDescriptionSection{
+			Collate:      &cdd.Collate{Default: false},
+			ReverseOrder: &cdd.ReverseOrder{Default: false},
+		},
+		nil,
+	}
+	translationTest(t, ppd, []string{"all"}, expected)
+
+	// A driver that takes PDF directly skips pdftopdf, so nothing is emulated.
+	ppd = `*PPD-Adobe: "4.3"
+*cupsFilter2: "application/pdf application/vnd.acme-pdf 0 acmetopdl"`
+	expected = testdata{
+		&cdd.PrinterDescriptionSection{},
+		nil,
+	}
+	translationTest(t, ppd, []string{}, expected)
+}
+
 func TestTrColor(t *testing.T) {
 	ppd := `*PPD-Adobe: "4.3"
 *OpenUI *ColorModel/Color Mode: PickOne
@@ -89,6 +240,8 @@ func TestTrColor(t *testing.T) {
 *CloseUI: *ColorModel`
 	expected := testdata{
 		&cdd.PrinterDescriptionSection{
+			Collate:      &cdd.Collate{Default: true},
+			ReverseOrder: &cdd.ReverseOrder{},
 			Color: &cdd.Color{
 				Option: []cdd.ColorOption{
 					cdd.ColorOption{"ColorModel:CMYK", cdd.ColorTypeStandardColor, "", false, cdd.NewLocalizedString("Color")},
@@ -114,6 +267,8 @@ func TestTrColor(t *testing.T) {
 `
 	expected = testdata{
 		&cdd.PrinterDescriptionSection{
+			Collate:      &cdd.Collate{Default: true},
+			ReverseOrder: &cdd.ReverseOrder{},
 			Color: &cdd.Color{
 				Option: []cdd.ColorOption{
 					cdd.ColorOption{"CMAndResolution:CMYKImageRET3600", cdd.ColorTypeStandardColor, "", true, cdd.NewLocalizedString("Color")},
@@ -136,6 +291,8 @@ func TestTrColor(t *testing.T) {
 `
 	expected = testdata{
 		&cdd.PrinterDescriptionSection{
+			Collate:      &cdd.Collate{Default: true},
+			ReverseOrder: &cdd.ReverseOrder{},
 			Color: &cdd.Color{
 				Option: []cdd.ColorOption{
 					cdd.ColorOption{"CMAndResolution:CMYKImageRET2400", cdd.ColorTypeStandardColor, "", true, cdd.NewLocalizedString("Color, ImageRET 2400")},
@@ -158,6 +315,8 @@ func TestTrColor(t *testing.T) {
 `
 	expected = testdata{
 		&cdd.PrinterDescriptionSection{
+			Collate:      &cdd.Collate{Default: true},
+			ReverseOrder: &cdd.ReverseOrder{},
 			Color: &cdd.Color{
 				Option: []cdd.ColorOption{
 					cdd.ColorOption{"SelectColor:Color", cdd.ColorTypeStandardColor, "", true, cdd.NewLocalizedString("Color")},
@@ -179,6 +338,8 @@ func TestTrDuplex(t *testing.T) {
 *CloseUI: *Duplex`
 	expected := testdata{
 		&cdd.PrinterDescriptionSection{
+			Collate:      &cdd.Collate{Default: true},
+			ReverseOrder: &cdd.ReverseOrder{},
 			Duplex: &cdd.Duplex{
 				Option: []cdd.DuplexOption{
 					cdd.DuplexOption{cdd.DuplexNoDuplex, true},
@@ -212,6 +373,8 @@ func TestTrKMDuplex(t *testing.T) {
 `
 	expected := testdata{
 		&cdd.PrinterDescriptionSection{
+			Collate:      &cdd.Collate{Default: true},
+			ReverseOrder: &cdd.ReverseOrder{},
 			Duplex: &cdd.Duplex{
 				Option: []cdd.DuplexOption{
 					cdd.DuplexOption{cdd.DuplexNoDuplex, false},
@@ -238,6 +401,8 @@ func TestTrKMDuplex(t *testing.T) {
 `
 	expected = testdata{
 		&cdd.PrinterDescriptionSection{
+			Collate:      &cdd.Collate{Default: true},
+			ReverseOrder: &cdd.ReverseOrder{},
 			Duplex: &cdd.Duplex{
 				Option: []cdd.DuplexOption{
 					cdd.DuplexOption{cdd.DuplexNoDuplex, true},
@@ -260,14 +425,17 @@ func TestTrDPI(t *testing.T) {
 *Resolution 600dpi/600 dpi: ""
 *Resolution 1200x600dpi/1200x600 dpi: ""
 *Resolution 1200x1200dpi/1200 dpi: ""
+*Resolution 600x600dpi/600 dpi (duplicate): ""
 *CloseUI: *Resolution`
 	expected := testdata{
 		&cdd.PrinterDescriptionSection{
+			Collate:      &cdd.Collate{Default: true},
+			ReverseOrder: &cdd.ReverseOrder{},
 			DPI: &cdd.DPI{
 				Option: []cdd.DPIOption{
-					cdd.DPIOption{600, 600, true, "", "600dpi", cdd.NewLocalizedString("600 dpi")},
-					cdd.DPIOption{1200, 600, false, "", "1200x600dpi", cdd.NewLocalizedString("1200x600 dpi")},
-					cdd.DPIOption{1200, 1200, false, "", "1200x1200dpi", cdd.NewLocalizedString("1200 dpi")},
+					cdd.DPIOption{600, 600, true, "", "Resolution:600dpi", cdd.NewLocalizedString("600 dpi")},
+					cdd.DPIOption{1200, 600, false, "", "Resolution:1200x600dpi", cdd.NewLocalizedString("1200x600 dpi")},
+					cdd.DPIOption{1200, 1200, false, "", "Resolution:1200x1200dpi", cdd.NewLocalizedString("1200 dpi")},
 				},
 			},
 		},
@@ -276,6 +444,43 @@ func TestTrDPI(t *testing.T) {
 	translationTest(t, ppd, []string{}, expected)
 }
 
+func TestFormatResolution(t *testing.T) {
+	if r := formatResolution(600, 600); r != "600dpi" {
+		t.Logf("expected 600dpi, got %s", r)
+		t.Fail()
+	}
+	if r := formatResolution(1200, 600); r != "1200x600dpi" {
+		t.Logf("expected 1200x600dpi, got %s", r)
+		t.Fail()
+	}
+}
+
+func TestValidatePPD(t *testing.T) {
+	ppd := `*PPD-Adobe: "4.3"
+*ModelName: "Acme Laser"`
+	if err := validatePPD(ppd, 0); err != nil {
+		t.Logf("expected a valid PPD, got %s", err)
+		t.Fail()
+	}
+	if err := validatePPD("\ufeff"+ppd, int64(len(ppd))+3); err != nil {
+		t.Logf("expected a valid PPD with a byte order mark, got %s", err)
+		t.Fail()
+	}
+	for _, bad := range []struct {
+		ppd      string
+		maxBytes int64
+	}{
+		{ppd, int64(len(ppd)) - 1},
+		{"not a PPD", 0},
+		{ppd + "\x00\x01\x02", 0},
+	} {
+		if err := validatePPD(bad.ppd, bad.maxBytes); err == nil {
+			t.Logf("expected %q to be invalid with a limit of %d bytes", bad.ppd, bad.maxBytes)
+			t.Fail()
+		}
+	}
+}
+
 func TestTrInputSlot(t *testing.T) {
 	ppd := `*PPD-Adobe: "4.3"
 *OpenUI *OutputBin/Destination: PickOne
@@ -287,6 +492,8 @@ func TestTrInputSlot(t *testing.T) {
 *CloseUI: *OutputBin`
 	expected := testdata{
 		&cdd.PrinterDescriptionSection{
+			Collate:      &cdd.Collate{Default: true},
+			ReverseOrder: &cdd.ReverseOrder{},
 			VendorCapability: &[]cdd.VendorCapability{
 				cdd.VendorCapability{
 					ID:                   "OutputBin",
@@ -317,6 +524,8 @@ func TestTrPrintQuality(t *testing.T) {
 *CloseUI: *HPPrintQuality`
 	expected := testdata{
 		&cdd.PrinterDescriptionSection{
+			Collate:      &cdd.Collate{Default: true},
+			ReverseOrder: &cdd.ReverseOrder{},
 			VendorCapability: &[]cdd.VendorCapability{
 				cdd.VendorCapability{
 					ID:                   "HPPrintQuality",
@@ -366,6 +575,8 @@ func TestRicohLockedPrint(t *testing.T) {
 `
 	expected := testdata{
 		&cdd.PrinterDescriptionSection{
+			Collate:      &cdd.Collate{Default: true},
+			ReverseOrder: &cdd.ReverseOrder{},
 			VendorCapability: &[]cdd.VendorCapability{
 				cdd.VendorCapability{
 					ID:                   "JobType:LockedPrint/LockedPrintPassword",
@@ -428,6 +639,8 @@ func TestTrVendorPPDOptions(t *testing.T) {
 
 	expected := testdata{
 		&cdd.PrinterDescriptionSection{
+			Collate:      &cdd.Collate{Default: true},
+			ReverseOrder: &cdd.ReverseOrder{},
 			VendorCapability: &[]cdd.VendorCapability{
 				cdd.VendorCapability{
 					ID:                   "CustomKey",
@@ -451,9 +664,54 @@ func TestTrVendorPPDOptions(t *testing.T) {
 	translationTest(t, ppd, []string{"AnyOtherKey", "all"}, expected)
 
 	expected = testdata{
-		&cdd.PrinterDescriptionSection{},
+		&cdd.PrinterDescriptionSection{
+			Collate:      &cdd.Collate{Default: true},
+			ReverseOrder: &cdd.ReverseOrder{},
+		},
 		nil,
 	}
 	translationTest(t, ppd, []string{}, expected)
 	translationTest(t, ppd, []string{"AnyOtherKey"}, expected)
 }
+
+func BenchmarkTranslatePPD(b *testing.B) {
+	ppd := `*PPD-Adobe: "4.3"
+*Manufacturer: "Acme"
+*ModelName: "Acme LaserWriter 9000"
+*Throughput: "30"
+*OpenUI *PageSize: PickOne
+*DefaultPageSize: Letter
+*PageSize A3/A3: ""
+*PageSize A4/A4: ""
+*PageSize Letter/Letter: ""
+*PageSize Legal/Legal: ""
+*CloseUI: *PageSize
+*OpenUI *ColorModel/Color Mode: PickOne
+*DefaultColorModel: Gray
+*ColorModel CMYK/Color: "(cmyk) RCsetdevicecolor"
+*ColorModel Gray/Black and White: "(gray) RCsetdevicecolor"
+*CloseUI: *ColorModel
+*OpenUI *Duplex/2-Sided Printing: PickOne
+*DefaultDuplex: None
+*Duplex None/Off: ""
+*Duplex DuplexNoTumble/Long Edge: ""
+*Duplex DuplexTumble/Short Edge: ""
+*CloseUI: *Duplex
+*OpenUI *Resolution/Resolution: PickOne
+*DefaultResolution: 600dpi
+*Resolution 300dpi/300 dpi: ""
+*Resolution 600dpi/600 dpi: ""
+*Resolution 1200x600dpi/1200x600 dpi: ""
+*CloseUI: *Resolution
+*OpenUI *InputSlot/Paper Source: PickOne
+*DefaultInputSlot: Auto
+*InputSlot Auto/Automatic: ""
+*InputSlot Tray1/Tray 1: ""
+*InputSlot Tray2/Tray 2: ""
+*CloseUI: *InputSlot`
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		translatePPD(ppd, []string{"all"})
+	}
+}
@@ -14,13 +14,21 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"unicode"
 
 	"github.com/google/cloud-print-connector/cdd"
 	"github.com/google/cloud-print-connector/lib"
+	"github.com/google/cloud-print-connector/log"
 )
 
-var rVendorIDKeyValue = regexp.MustCompile(
-	`^([^\` + internalKeySeparator + `]+)(?:` + internalKeySeparator + `(.+))?$`)
+var (
+	rVendorIDKeyValue = regexp.MustCompile(
+		`^([^\` + internalKeySeparator + `]+)(?:` + internalKeySeparator + `(.+))?$`)
+
+	// rOptionName matches the PPD keywords and IPP attribute names that
+	// vendor ticket items may set as CUPS options.
+	rOptionName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._+-]*$`)
+)
 
 // translateTicket converts a CloudJobTicket to a map of options, suitable for a new CUPS print job.
 func translateTicket(printer *lib.Printer, ticket *cdd.CloudJobTicket) (map[string]string, error) {
@@ -30,6 +38,15 @@ func translateTicket(printer *lib.Printer, ticket *cdd.CloudJobTicket) (map[stri
 
 	m := map[string]string{}
 	for _, vti := range ticket.Print.VendorTicketItem {
+		if vti.ID == lib.PDFPasswordVendorID || vti.ID == lib.AllowDuplicateVendorID ||
+			vti.ID == coverSheetVendorID || vti.ID == separatorSheetsVendorID {
+			// Used by the connector before printing, not by CUPS.
+			continue
+		}
+		if err := checkVendorTicketItem(printer, vti); err != nil {
+			log.WarningPrinterf(printer.Name, "Ignoring vendor ticket item: %s", err)
+			continue
+		}
 		if vti.ID == ricohPasswordVendorID {
 			if vti.Value == "" {
 				// do not add specific map of options for Ricoh vendor like ppdLockedPrintPassword or ppdJobType when password is empty
@@ -48,6 +65,10 @@ func translateTicket(printer *lib.Printer, ticket *cdd.CloudJobTicket) (map[stri
 			} else {
 				key, value = parts[1], parts[2]
 			}
+			if !rOptionName.MatchString(key) {
+				log.WarningPrinterf(printer.Name, "Ignoring vendor ticket item %s with bad option name %q", vti.ID, key)
+				continue
+			}
 			m[key] = value
 		}
 	}
@@ -82,24 +103,34 @@ func translateTicket(printer *lib.Printer, ticket *cdd.CloudJobTicket) (map[stri
 		}
 	}
 	if ticket.Print.Copies != nil && printer.Description.Copies != nil {
-		m[attrCopies] = strconv.FormatInt(int64(ticket.Print.Copies.Copies), 10)
+		copies := ticket.Print.Copies.Copies
+		if copies < 1 {
+			return map[string]string{}, fmt.Errorf("Job requests %d copies; at least 1 is required", copies)
+		}
+		if max := printer.Description.Copies.Max; max > 0 && copies > max {
+			return map[string]string{}, fmt.Errorf("Job requests %d copies but printer %s prints at most %d", copies, printer.Name, max)
+		}
+		m[attrCopies] = strconv.FormatInt(int64(copies), 10)
 	}
 	if ticket.Print.Margins != nil && printer.Description.Margins != nil {
-		m[attrMediaLeftMargin] = micronsToPoints(ticket.Print.Margins.LeftMicrons)
-		m[attrMediaRightMargin] = micronsToPoints(ticket.Print.Margins.RightMicrons)
-		m[attrMediaTopMargin] = micronsToPoints(ticket.Print.Margins.TopMicrons)
-		m[attrMediaBottomMargin] = micronsToPoints(ticket.Print.Margins.BottomMicrons)
+		margins := reconcileMargins(*ticket.Print.Margins, printer.Description.Margins, mediaMargins(printer, ticket))
+		m[attrMediaLeftMargin] = micronsToPoints(margins.LeftMicrons)
+		m[attrMediaRightMargin] = micronsToPoints(margins.RightMicrons)
+		m[attrMediaTopMargin] = micronsToPoints(margins.TopMicrons)
+		m[attrMediaBottomMargin] = micronsToPoints(margins.BottomMicrons)
 	}
 	if ticket.Print.DPI != nil && printer.Description.DPI != nil {
-		if ticket.Print.DPI.VendorID != "" {
-			m[ppdResolution] = ticket.Print.DPI.VendorID
+		dpiOption := findDPIOption(ticket.Print.DPI, printer.Description.DPI)
+		if dpiOption == nil {
+			return map[string]string{}, fmt.Errorf("Job requests %s but printer %s does not support it",
+				formatResolution(ticket.Print.DPI.HorizontalDPI, ticket.Print.DPI.VerticalDPI), printer.Name)
+		}
+		parts := rVendorIDKeyValue.FindStringSubmatch(dpiOption.VendorID)
+		if parts != nil && parts[2] != "" {
+			m[parts[1]] = parts[2]
 		} else {
-			for _, dpiOption := range printer.Description.DPI.Option {
-				if ticket.Print.DPI.HorizontalDPI == dpiOption.HorizontalDPI &&
-					ticket.Print.DPI.VerticalDPI == dpiOption.VerticalDPI {
-					m[ppdResolution] = dpiOption.VendorID
-				}
-			}
+			// A bare vendor ID is a PPD Resolution keyword.
+			m[ppdResolution] = dpiOption.VendorID
 		}
 	}
 	if ticket.Print.FitToPage != nil && printer.Description.FitToPage != nil {
@@ -137,6 +168,157 @@ func translateTicket(printer *lib.Printer, ticket *cdd.CloudJobTicket) (map[stri
 	return m, nil
 }
 
+// checkVendorTicketItem checks that a vendor ticket item sets a vendor
+// capability that the printer advertises, to a value that the capability
+// allows.
+func checkVendorTicketItem(printer *lib.Printer, vti cdd.VendorTicketItem) error {
+	var vc *cdd.VendorCapability
+	if printer.Description != nil && printer.Description.VendorCapability != nil {
+		for i, c := range *printer.Description.VendorCapability {
+			if c.ID == vti.ID {
+				vc = &(*printer.Description.VendorCapability)[i]
+				break
+			}
+		}
+	}
+	if vc == nil {
+		return fmt.Errorf("%s is not a capability of printer %s", vti.ID, printer.Name)
+	}
+
+	for _, r := range vti.Value {
+		if unicode.IsControl(r) {
+			return fmt.Errorf("value of %s contains control characters", vti.ID)
+		}
+	}
+
+	switch vc.Type {
+	case cdd.VendorCapabilitySelect:
+		if vc.SelectCap == nil {
+			return nil
+		}
+		for _, o := range vc.SelectCap.Option {
+			if o.Value == vti.Value {
+				return nil
+			}
+		}
+		return fmt.Errorf("%q is not an option of %s", vti.Value, vti.ID)
+
+	case cdd.VendorCapabilityTypedValue:
+		if vc.TypedValueCap == nil {
+			return nil
+		}
+		var err error
+		switch vc.TypedValueCap.ValueType {
+		case cdd.TypedValueCapabilityTypeBoolean:
+			_, err = strconv.ParseBool(vti.Value)
+		case cdd.TypedValueCapabilityTypeInteger:
+			_, err = strconv.ParseInt(vti.Value, 10, 32)
+		case cdd.TypedValueCapabilityTypeFloat:
+			_, err = strconv.ParseFloat(vti.Value, 32)
+		}
+		if err != nil {
+			return fmt.Errorf("%q is not a %s value of %s", vti.Value, vc.TypedValueCap.ValueType, vti.ID)
+		}
+
+	case cdd.VendorCapabilityRange:
+		if vc.RangeCap == nil {
+			return nil
+		}
+		v, err := parseRangeValue(vc.RangeCap.ValueType, vti.Value)
+		if err != nil {
+			return fmt.Errorf("%q is not a %s value of %s", vti.Value, vc.RangeCap.ValueType, vti.ID)
+		}
+		if min, err := parseRangeValue(vc.RangeCap.ValueType, vc.RangeCap.Min); err == nil && v < min {
+			return fmt.Errorf("%s is below the minimum %s of %s", vti.Value, vc.RangeCap.Min, vti.ID)
+		}
+		if max, err := parseRangeValue(vc.RangeCap.ValueType, vc.RangeCap.Max); err == nil && v > max {
+			return fmt.Errorf("%s is above the maximum %s of %s", vti.Value, vc.RangeCap.Max, vti.ID)
+		}
+	}
+
+	return nil
+}
+
+// parseRangeValue parses a value of a range vendor capability.
+func parseRangeValue(valueType cdd.RangeCapabilityValueType, value string) (float64, error) {
+	if valueType == cdd.RangeCapabilityValueInteger {
+		i, err := strconv.ParseInt(value, 10, 32)
+		return float64(i), err
+	}
+	return strconv.ParseFloat(value, 64)
+}
+
+// findDPIOption finds the printer's resolution that a ticket asks for, by
+// vendor ID, then by resolution, so that tickets made for an older
+// description still match.
+func findDPIOption(requested *cdd.DPITicketItem, capability *cdd.DPI) *cdd.DPIOption {
+	if requested.VendorID != "" {
+		for i := range capability.Option {
+			if capability.Option[i].VendorID == requested.VendorID {
+				return &capability.Option[i]
+			}
+		}
+	}
+	for i := range capability.Option {
+		if capability.Option[i].HorizontalDPI == requested.HorizontalDPI &&
+			capability.Option[i].VerticalDPI == requested.VerticalDPI {
+			return &capability.Option[i]
+		}
+	}
+	return nil
+}
+
+// mediaMargins gets the margins of the page size that a ticket asks for,
+// from the PPD of the printer; nil when either doesn't say.
+func mediaMargins(printer *lib.Printer, ticket *cdd.CloudJobTicket) *cdd.MarginsOption {
+	if ticket == nil || ticket.Print.MediaSize == nil {
+		return nil
+	}
+	if margins, exists := printer.MarginsMap[ticket.Print.MediaSize.VendorID]; exists {
+		return &margins
+	}
+	return nil
+}
+
+// reconcileMargins widens requested margins that are narrower than the
+// printer can print, so that content isn't silently cut off at the edge.
+// The margins of the requested media, when known, are the minimum;
+// otherwise the default margins of the printer are, and printers that
+// offer borderless printing accept any margins.
+func reconcileMargins(requested cdd.MarginsTicketItem, capability *cdd.Margins, media *cdd.MarginsOption) cdd.MarginsTicketItem {
+	minimum := media
+	if minimum == nil && capability != nil {
+		for i := range capability.Option {
+			o := &capability.Option[i]
+			switch o.Type {
+			case cdd.MarginsBorderless:
+				return requested
+			case cdd.MarginsStandard:
+				if minimum == nil || o.IsDefault {
+					minimum = o
+				}
+			}
+		}
+	}
+	if minimum == nil || minimum.Type == cdd.MarginsBorderless {
+		return requested
+	}
+
+	if requested.TopMicrons < minimum.TopMicrons {
+		requested.TopMicrons = minimum.TopMicrons
+	}
+	if requested.RightMicrons < minimum.RightMicrons {
+		requested.RightMicrons = minimum.RightMicrons
+	}
+	if requested.BottomMicrons < minimum.BottomMicrons {
+		requested.BottomMicrons = minimum.BottomMicrons
+	}
+	if requested.LeftMicrons < minimum.LeftMicrons {
+		requested.LeftMicrons = minimum.LeftMicrons
+	}
+	return requested
+}
+
 func micronsToPoints(microns int32) string {
 	return strconv.Itoa(int(float32(microns)*72/25400 + 0.5))
 }
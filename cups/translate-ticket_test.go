@@ -76,6 +76,18 @@ func TestTranslateTicket(t *testing.T) {
 			MediaSize:    &cdd.MediaSize{},
 			Collate:      &cdd.Collate{},
 			ReverseOrder: &cdd.ReverseOrder{},
+			VendorCapability: &[]cdd.VendorCapability{
+				cdd.VendorCapability{
+					ID:        "number-up",
+					Type:      cdd.VendorCapabilitySelect,
+					SelectCap: &cdd.SelectCapability{Option: []cdd.SelectCapabilityOption{{Value: "a"}}},
+				},
+				cdd.VendorCapability{
+					ID:            "a:b/c:d/e",
+					Type:          cdd.VendorCapabilityTypedValue,
+					TypedValueCap: &cdd.TypedValueCapability{ValueType: cdd.TypedValueCapabilityTypeString},
+				},
+			},
 		},
 		DuplexMap: lib.DuplexVendorMap{
 			cdd.DuplexNoDuplex: "Duplex:None",
@@ -252,8 +264,286 @@ func TestTranslateTicket(t *testing.T) {
 	}
 }
 
+func TestTranslateTicket_Copies(t *testing.T) {
+	printer := lib.Printer{
+		Name: "printer",
+		Description: &cdd.PrinterDescriptionSection{
+			Copies: &cdd.Copies{Default: 1, Max: 10},
+		},
+	}
+	ticket := cdd.CloudJobTicket{}
+
+	ticket.Print.Copies = &cdd.CopiesTicketItem{Copies: 10}
+	o, err := translateTicket(&printer, &ticket)
+	if err != nil {
+		t.Logf("did not expect error %s", err)
+		t.Fail()
+	}
+	if o[attrCopies] != "10" {
+		t.Logf("expected 10 copies, got %+v", o)
+		t.Fail()
+	}
+
+	for _, copies := range []int32{0, 11} {
+		ticket.Print.Copies = &cdd.CopiesTicketItem{Copies: copies}
+		if _, err = translateTicket(&printer, &ticket); err == nil {
+			t.Logf("expected error for %d copies", copies)
+			t.Fail()
+		}
+	}
+}
+
+func TestReconcileMargins(t *testing.T) {
+	requested := cdd.MarginsTicketItem{TopMicrons: 0, RightMicrons: 10000, BottomMicrons: 1000, LeftMicrons: 5000}
+	standard := cdd.MarginsOption{
+		Type: cdd.MarginsStandard, TopMicrons: 4000, RightMicrons: 4000, BottomMicrons: 4000, LeftMicrons: 4000, IsDefault: true,
+	}
+
+	expected := cdd.MarginsTicketItem{TopMicrons: 4000, RightMicrons: 10000, BottomMicrons: 4000, LeftMicrons: 5000}
+	m := reconcileMargins(requested, &cdd.Margins{Option: []cdd.MarginsOption{standard}}, nil)
+	if m != expected {
+		t.Logf("expected %+v, got %+v", expected, m)
+		t.Fail()
+	}
+
+	capability := &cdd.Margins{
+		Option: []cdd.MarginsOption{standard, cdd.MarginsOption{Type: cdd.MarginsBorderless}},
+	}
+	m = reconcileMargins(requested, capability, nil)
+	if m != requested {
+		t.Logf("expected %+v, got %+v", requested, m)
+		t.Fail()
+	}
+
+	// The margins of the requested media win over the printer's.
+	media := cdd.MarginsOption{
+		Type: cdd.MarginsStandard, TopMicrons: 12700, RightMicrons: 6350, BottomMicrons: 12700, LeftMicrons: 6350,
+	}
+	expected = cdd.MarginsTicketItem{TopMicrons: 12700, RightMicrons: 10000, BottomMicrons: 12700, LeftMicrons: 6350}
+	m = reconcileMargins(requested, capability, &media)
+	if m != expected {
+		t.Logf("expected %+v, got %+v", expected, m)
+		t.Fail()
+	}
+	m = reconcileMargins(requested, capability, &cdd.MarginsOption{Type: cdd.MarginsBorderless})
+	if m != requested {
+		t.Logf("expected %+v, got %+v", requested, m)
+		t.Fail()
+	}
+}
+
+func TestTranslateTicketMediaMargins(t *testing.T) {
+	printer := lib.Printer{
+		Description: &cdd.PrinterDescriptionSection{
+			Margins: &cdd.Margins{
+				Option: []cdd.MarginsOption{
+					cdd.MarginsOption{Type: cdd.MarginsStandard, IsDefault: true},
+					cdd.MarginsOption{Type: cdd.MarginsBorderless},
+				},
+			},
+		},
+		MarginsMap: lib.MarginsVendorMap{
+			"Letter": cdd.MarginsOption{
+				Type: cdd.MarginsStandard, TopMicrons: 12700, RightMicrons: 6350, BottomMicrons: 12700, LeftMicrons: 6350,
+			},
+		},
+	}
+	ticket := cdd.CloudJobTicket{}
+	ticket.Print.Margins = &cdd.MarginsTicketItem{}
+	ticket.Print.MediaSize = &cdd.MediaSizeTicketItem{VendorID: "Letter"}
+
+	o, err := translateTicket(&printer, &ticket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o[attrMediaTopMargin] != "36" || o[attrMediaLeftMargin] != "18" {
+		t.Logf("expected the margins of Letter, got %+v", o)
+		t.Fail()
+	}
+}
+
+func TestMergeCopies(t *testing.T) {
+	ipp := &cdd.Copies{Default: 5, Max: 100}
+	ppd := &cdd.Copies{Default: 1, Max: 3}
+
+	if c := mergeCopies(ipp, nil); c != ipp {
+		t.Logf("expected IPP copies, got %+v", c)
+		t.Fail()
+	}
+	if c := mergeCopies(nil, ppd); c != ppd {
+		t.Logf("expected PPD copies, got %+v", c)
+		t.Fail()
+	}
+	expected := &cdd.Copies{Default: 3, Max: 3}
+	if c := mergeCopies(ipp, ppd); !reflect.DeepEqual(expected, c) {
+		t.Logf("expected %+v, got %+v", expected, c)
+		t.Fail()
+	}
+}
+
+func TestMergeDPI(t *testing.T) {
+	ipp := &cdd.DPI{
+		Option: []cdd.DPIOption{
+			cdd.DPIOption{HorizontalDPI: 300, VerticalDPI: 300, IsDefault: true, VendorID: "printer-resolution:300dpi"},
+			cdd.DPIOption{HorizontalDPI: 600, VerticalDPI: 600, VendorID: "printer-resolution:600dpi"},
+		},
+	}
+	ppd := &cdd.DPI{
+		Option: []cdd.DPIOption{
+			cdd.DPIOption{HorizontalDPI: 600, VerticalDPI: 600, IsDefault: true, VendorID: "Resolution:600dpi"},
+		},
+	}
+
+	if d := mergeDPI(ipp, nil); d != ipp {
+		t.Logf("expected IPP DPI, got %+v", d)
+		t.Fail()
+	}
+	if d := mergeDPI(nil, ppd); d != ppd {
+		t.Logf("expected PPD DPI, got %+v", d)
+		t.Fail()
+	}
+	expected := &cdd.DPI{
+		Option: []cdd.DPIOption{
+			cdd.DPIOption{HorizontalDPI: 600, VerticalDPI: 600, IsDefault: true, VendorID: "Resolution:600dpi"},
+			cdd.DPIOption{HorizontalDPI: 300, VerticalDPI: 300, VendorID: "printer-resolution:300dpi"},
+		},
+	}
+	if d := mergeDPI(ipp, ppd); !reflect.DeepEqual(expected, d) {
+		t.Logf("expected %+v, got %+v", expected, d)
+		t.Fail()
+	}
+	if !ipp.Option[0].IsDefault {
+		t.Logf("mergeDPI modified its argument")
+		t.Fail()
+	}
+}
+
+func TestTranslateTicket_DPI(t *testing.T) {
+	printer := lib.Printer{
+		Name: "printer",
+		Description: &cdd.PrinterDescriptionSection{
+			DPI: &cdd.DPI{
+				Option: []cdd.DPIOption{
+					cdd.DPIOption{HorizontalDPI: 600, VerticalDPI: 600, IsDefault: true, VendorID: "Resolution:600dpi"},
+					cdd.DPIOption{HorizontalDPI: 300, VerticalDPI: 300, VendorID: "printer-resolution:300dpi"},
+				},
+			},
+		},
+	}
+	ticket := cdd.CloudJobTicket{}
+
+	ticket.Print.DPI = &cdd.DPITicketItem{HorizontalDPI: 600, VerticalDPI: 600, VendorID: "Resolution:600dpi"}
+	expected := map[string]string{"Resolution": "600dpi"}
+	o, err := translateTicket(&printer, &ticket)
+	if err != nil || !reflect.DeepEqual(o, expected) {
+		t.Logf("expected %+v, got %+v, %v", expected, o, err)
+		t.Fail()
+	}
+
+	// Tickets made for an older description match by resolution.
+	ticket.Print.DPI = &cdd.DPITicketItem{HorizontalDPI: 300, VerticalDPI: 300, VendorID: "300dpi"}
+	expected = map[string]string{"printer-resolution": "300dpi"}
+	o, err = translateTicket(&printer, &ticket)
+	if err != nil || !reflect.DeepEqual(o, expected) {
+		t.Logf("expected %+v, got %+v, %v", expected, o, err)
+		t.Fail()
+	}
+
+	ticket.Print.DPI = &cdd.DPITicketItem{HorizontalDPI: 1200, VerticalDPI: 1200}
+	if _, err = translateTicket(&printer, &ticket); err == nil {
+		t.Log("expected error for unsupported resolution")
+		t.Fail()
+	}
+}
+
+func TestTranslateTicket_VendorTicketItems(t *testing.T) {
+	printer := lib.Printer{
+		Name: "printer",
+		Description: &cdd.PrinterDescriptionSection{
+			VendorCapability: &[]cdd.VendorCapability{
+				cdd.VendorCapability{
+					ID:   "OutputBin",
+					Type: cdd.VendorCapabilitySelect,
+					SelectCap: &cdd.SelectCapability{
+						Option: []cdd.SelectCapabilityOption{{Value: "Upper"}, {Value: "Lower"}},
+					},
+				},
+				cdd.VendorCapability{
+					ID:            "Staple",
+					Type:          cdd.VendorCapabilityTypedValue,
+					TypedValueCap: &cdd.TypedValueCapability{ValueType: cdd.TypedValueCapabilityTypeBoolean},
+				},
+				cdd.VendorCapability{
+					ID:       "Darkness",
+					Type:     cdd.VendorCapabilityRange,
+					RangeCap: &cdd.RangeCapability{ValueType: cdd.RangeCapabilityValueInteger, Min: "1", Max: "5"},
+				},
+				cdd.VendorCapability{
+					ID:            "Bad Name",
+					Type:          cdd.VendorCapabilityTypedValue,
+					TypedValueCap: &cdd.TypedValueCapability{ValueType: cdd.TypedValueCapabilityTypeString},
+				},
+			},
+		},
+	}
+	ticket := cdd.CloudJobTicket{}
+	ticket.Print = cdd.PrintTicketSection{
+		VendorTicketItem: []cdd.VendorTicketItem{
+			cdd.VendorTicketItem{"OutputBin", "Lower"},
+			cdd.VendorTicketItem{"Staple", "true"},
+			cdd.VendorTicketItem{"Darkness", "3"},
+		},
+	}
+	expected := map[string]string{
+		"OutputBin": "Lower",
+		"Staple":    "true",
+		"Darkness":  "3",
+	}
+	o, err := translateTicket(&printer, &ticket)
+	if err != nil {
+		t.Logf("did not expect error %s", err)
+		t.Fail()
+	}
+	if !reflect.DeepEqual(o, expected) {
+		t.Logf("expected\n %+v\ngot\n %+v", expected, o)
+		t.Fail()
+	}
+
+	// Each of these is dropped.
+	ticket.Print = cdd.PrintTicketSection{
+		VendorTicketItem: []cdd.VendorTicketItem{
+			cdd.VendorTicketItem{"job-sheets", "secret"},
+			cdd.VendorTicketItem{"OutputBin", "Shredder"},
+			cdd.VendorTicketItem{"Staple", "maybe"},
+			cdd.VendorTicketItem{"Darkness", "9"},
+			cdd.VendorTicketItem{"Darkness", "2\nlp -d other"},
+			cdd.VendorTicketItem{"Bad Name", "x"},
+		},
+	}
+	expected = map[string]string{}
+	o, err = translateTicket(&printer, &ticket)
+	if err != nil {
+		t.Logf("did not expect error %s", err)
+		t.Fail()
+	}
+	if !reflect.DeepEqual(o, expected) {
+		t.Logf("expected\n %+v\ngot\n %+v", expected, o)
+		t.Fail()
+	}
+}
+
 func TestTranslateTicket_RicohLockedPrint(t *testing.T) {
-	printer := lib.Printer{}
+	printer := lib.Printer{
+		Description: &cdd.PrinterDescriptionSection{
+			VendorCapability: &[]cdd.VendorCapability{
+				cdd.VendorCapability{
+					ID:            ricohPasswordVendorID,
+					Type:          cdd.VendorCapabilityTypedValue,
+					TypedValueCap: &cdd.TypedValueCapability{ValueType: cdd.TypedValueCapabilityTypeString},
+				},
+			},
+		},
+	}
 	ticket := cdd.CloudJobTicket{}
 	ticket.Print = cdd.PrintTicketSection{
 		VendorTicketItem: []cdd.VendorTicketItem{
@@ -335,3 +625,49 @@ func TestTranslateTicket_RicohLockedPrint(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func BenchmarkTranslateTicket(b *testing.B) {
+	printer := lib.Printer{
+		Description: &cdd.PrinterDescriptionSection{
+			Color: &cdd.Color{
+				Option: []cdd.ColorOption{
+					cdd.ColorOption{VendorID: "ColorModel:Gray", Type: cdd.ColorTypeStandardMonochrome},
+				},
+			},
+			Duplex: &cdd.Duplex{
+				Option: []cdd.DuplexOption{
+					cdd.DuplexOption{Type: cdd.DuplexLongEdge},
+				},
+			},
+			DPI: &cdd.DPI{
+				Option: []cdd.DPIOption{
+					cdd.DPIOption{HorizontalDPI: 600, VerticalDPI: 600, VendorID: "600dpi"},
+				},
+			},
+			MediaSize: &cdd.MediaSize{},
+		},
+		DuplexMap: lib.DuplexVendorMap{
+			cdd.DuplexLongEdge: "Duplex:DuplexNoTumble",
+		},
+	}
+	ticket := cdd.CloudJobTicket{
+		Print: cdd.PrintTicketSection{
+			VendorTicketItem: []cdd.VendorTicketItem{
+				cdd.VendorTicketItem{ID: "number-up", Value: "2"},
+			},
+			Color:        &cdd.ColorTicketItem{VendorID: "ColorModel:Gray", Type: cdd.ColorTypeStandardMonochrome},
+			Duplex:       &cdd.DuplexTicketItem{Type: cdd.DuplexLongEdge},
+			Copies:       &cdd.CopiesTicketItem{Copies: 2},
+			Margins:      &cdd.MarginsTicketItem{TopMicrons: 100000, RightMicrons: 100000, BottomMicrons: 100000, LeftMicrons: 100000},
+			DPI:          &cdd.DPITicketItem{HorizontalDPI: 600, VerticalDPI: 600, VendorID: "600dpi"},
+			MediaSize:    &cdd.MediaSizeTicketItem{WidthMicrons: 215900, HeightMicrons: 279400, VendorID: "Letter"},
+			Collate:      &cdd.CollateTicketItem{Collate: true},
+			ReverseOrder: &cdd.ReverseOrderTicketItem{ReverseOrder: false},
+		},
+	}
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		translateTicket(&printer, &ticket)
+	}
+}
@@ -0,0 +1,323 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+// Package fakeprinter simulates printers, in addition to those of a native
+// print system, to load test and integration test the connector and the
+// cloud without physical printers.
+package fakeprinter
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/google/cloud-print-connector/cdd"
+	"github.com/google/cloud-print-connector/lib"
+	"github.com/google/cloud-print-connector/manager"
+)
+
+// NamePrefix starts the names of simulated printers, which are numbered
+// from 1.
+const NamePrefix = "simulated-printer-"
+
+// SimulatedTag marks simulated printers.
+const SimulatedTag = "simulated"
+
+// DefaultDescription is the capabilities of simulated printers that aren't
+// configured: a monochrome printer of letter and A4 paper.
+var DefaultDescription = cdd.PrinterDescriptionSection{
+	SupportedContentType: cdd.NewSupportedContentType("application/pdf"),
+	Color: &cdd.Color{Option: []cdd.ColorOption{
+		{VendorID: "monochrome", Type: cdd.ColorTypeStandardMonochrome, IsDefault: true},
+	}},
+	Copies: &cdd.Copies{Default: 1, Max: 99},
+	MediaSize: &cdd.MediaSize{Option: []cdd.MediaSizeOption{
+		{Name: cdd.MediaSizeNALetter, WidthMicrons: 215900, HeightMicrons: 279400, VendorID: "Letter", IsDefault: true},
+		{Name: cdd.MediaSizeISOA4, WidthMicrons: 210000, HeightMicrons: 297000, VendorID: "A4"},
+	}},
+}
+
+type fakeJob struct {
+	printerName string
+	// start is when the job starts to print, after the jobs before it.
+	start time.Time
+	pages uint
+	// failAt is how many pages print before the job fails; negative never
+	// fails.
+	failAt int
+	// canceled is when the job was canceled; zero is not canceled.
+	canceled time.Time
+}
+
+// FakePrinters is a native print system of simulated printers, and of the
+// printers of another native print system. Each simulated printer prints
+// its jobs one at a time, at a number of pages per minute, and fails a
+// fraction of them partway.
+type FakePrinters struct {
+	native         manager.NativePrintSystem
+	count          uint
+	description    cdd.PrinterDescriptionSection
+	pagesPerMinute uint
+	failureRate    float64
+	// now is time.Now, except in tests.
+	now func() time.Time
+
+	mutex sync.Mutex
+	rand  *rand.Rand
+	jobs  map[uint32]*fakeJob
+	// busyUntil is when each simulated printer finishes its jobs, by name.
+	busyUntil map[string]time.Time
+	nextJobID uint32
+}
+
+// NewFakePrinters creates count simulated printers of description, or of
+// DefaultDescription when it is nil, in addition to the printers of native,
+// which may be nil. The printers print pagesPerMinute, or at once when it
+// is zero, and fail failureRate of their jobs, from 0 to 1.
+func NewFakePrinters(native manager.NativePrintSystem, count uint, description *cdd.PrinterDescriptionSection, pagesPerMinute uint, failureRate float64) (*FakePrinters, error) {
+	if failureRate < 0 || failureRate > 1 {
+		return nil, fmt.Errorf("Simulated printer failure rate %g is not from 0 to 1", failureRate)
+	}
+	if description == nil {
+		description = &DefaultDescription
+	}
+	if err := description.Validate(); err != nil {
+		return nil, fmt.Errorf("Simulated printer capabilities are invalid: %s", err)
+	}
+
+	return &FakePrinters{
+		native:         native,
+		count:          count,
+		description:    *description,
+		pagesPerMinute: pagesPerMinute,
+		failureRate:    failureRate,
+		now:            time.Now,
+		rand:           rand.New(rand.NewSource(time.Now().UnixNano())),
+		jobs:           make(map[uint32]*fakeJob),
+		busyUntil:      make(map[string]time.Time),
+		nextJobID:      1,
+	}, nil
+}
+
+// isFake tells whether printerName is the name of a simulated printer.
+func (f *FakePrinters) isFake(printerName string) bool {
+	var n uint
+	if _, err := fmt.Sscanf(printerName, NamePrefix+"%d", &n); err != nil {
+		return false
+	}
+	return n >= 1 && n <= f.count && printerName == fmt.Sprintf("%s%d", NamePrefix, n)
+}
+
+// GetPrinters gets the printers of the native print system, and the
+// simulated printers.
+func (f *FakePrinters) GetPrinters() ([]lib.Printer, error) {
+	var printers []lib.Printer
+	if f.native != nil {
+		var err error
+		if printers, err = f.native.GetPrinters(); err != nil {
+			return nil, err
+		}
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	now := f.now()
+	for i := uint(1); i <= f.count; i++ {
+		name := fmt.Sprintf("%s%d", NamePrefix, i)
+		description := f.description
+		state := cdd.CloudDeviceStateIdle
+		if f.busyUntil[name].After(now) {
+			state = cdd.CloudDeviceStateProcessing
+		}
+		tags := map[string]string{SimulatedTag: "true"}
+		for k, v := range lib.BuildInfoTags() {
+			tags[k] = v
+		}
+		printers = append(printers, lib.Printer{
+			Name:               name,
+			DefaultDisplayName: fmt.Sprintf("Simulated printer %d", i),
+			UUID:               fmt.Sprintf("simulated-%d", i),
+			Manufacturer:       "Simulated",
+			Model:              "Simulated printer",
+			GCPVersion:         lib.GCPAPIVersion,
+			SetupURL:           lib.ConnectorHomeURL,
+			SupportURL:         lib.ConnectorHomeURL,
+			UpdateURL:          lib.ConnectorHomeURL,
+			ConnectorVersion:   lib.ShortName,
+			State:              &cdd.PrinterStateSection{State: state},
+			Description:        &description,
+			Tags:               tags,
+		})
+	}
+	return printers, nil
+}
+
+// Print queues a job on a simulated printer, after its other jobs.
+func (f *FakePrinters) Print(printer *lib.Printer, fileName, title, user, gcpJobID string, ticket *cdd.CloudJobTicket) (uint32, error) {
+	if !f.isFake(printer.Name) {
+		if f.native == nil {
+			return 0, fmt.Errorf("Printer %s does not exist", printer.Name)
+		}
+		return f.native.Print(printer, fileName, title, user, gcpJobID, ticket)
+	}
+
+	pages, err := lib.CountPDFPages(fileName)
+	if err != nil || pages == 0 {
+		// Not a PDF, or not one whose pages can be counted.
+		pages = 1
+	}
+	if ticket != nil && ticket.Print.Copies != nil && ticket.Print.Copies.Copies > 1 {
+		pages *= uint(ticket.Print.Copies.Copies)
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	start := f.now()
+	if busyUntil := f.busyUntil[printer.Name]; busyUntil.After(start) {
+		start = busyUntil
+	}
+	job := fakeJob{printerName: printer.Name, start: start, pages: pages, failAt: -1}
+	if f.rand.Float64() < f.failureRate {
+		job.failAt = f.rand.Intn(int(pages))
+	}
+	f.busyUntil[printer.Name] = start.Add(f.duration(job.printedPages()))
+
+	jobID := f.nextJobID
+	f.nextJobID++
+	f.jobs[jobID] = &job
+	return jobID, nil
+}
+
+// printedPages is how many pages a job prints before it is done, or
+// before it fails.
+func (j *fakeJob) printedPages() uint {
+	if j.failAt >= 0 {
+		return uint(j.failAt)
+	}
+	return j.pages
+}
+
+// duration is how long a simulated printer takes to print pages.
+func (f *FakePrinters) duration(pages uint) time.Duration {
+	if f.pagesPerMinute == 0 {
+		return 0
+	}
+	return time.Duration(pages) * time.Minute / time.Duration(f.pagesPerMinute)
+}
+
+// GetJobState gets the state of a job of a simulated printer, by how long
+// it has been printing.
+func (f *FakePrinters) GetJobState(printerName string, jobID uint32) (*cdd.PrintJobStateDiff, error) {
+	if !f.isFake(printerName) {
+		if f.native == nil {
+			return nil, fmt.Errorf("Printer %s does not exist", printerName)
+		}
+		return f.native.GetJobState(printerName, jobID)
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	job, exists := f.jobs[jobID]
+	if !exists || job.printerName != printerName {
+		return nil, fmt.Errorf("Job %d of printer %s does not exist", jobID, printerName)
+	}
+
+	now := f.now()
+	if !job.canceled.IsZero() {
+		now = job.canceled
+	}
+	var printed uint
+	switch elapsed := now.Sub(job.start); {
+	case elapsed < 0:
+	case f.pagesPerMinute == 0:
+		printed = job.pages
+	default:
+		printed = uint(elapsed * time.Duration(f.pagesPerMinute) / time.Minute)
+	}
+	if printed > job.printedPages() {
+		printed = job.printedPages()
+	}
+	pagesPrinted := int32(printed)
+	state := cdd.PrintJobStateDiff{
+		State:        &cdd.JobState{Type: cdd.JobStateInProgress},
+		PagesPrinted: &pagesPrinted,
+	}
+
+	switch {
+	case !job.canceled.IsZero():
+		state.State = &cdd.JobState{
+			Type:            cdd.JobStateAborted,
+			UserActionCause: &cdd.UserActionCause{ActionCode: cdd.UserActionCauseCanceled},
+		}
+	case now.Before(job.start):
+		state.State.Type = cdd.JobStateQueued
+	case job.failAt >= 0 && printed == uint(job.failAt):
+		state.State = &cdd.JobState{
+			Type:              cdd.JobStateAborted,
+			DeviceActionCause: &cdd.DeviceActionCause{ErrorCode: cdd.DeviceActionCausePrintFailure},
+		}
+	case printed == job.pages:
+		state.State.Type = cdd.JobStateDone
+	}
+	return &state, nil
+}
+
+// ReleaseJob forgets a job of a simulated printer.
+func (f *FakePrinters) ReleaseJob(printerName string, jobID uint32) error {
+	if !f.isFake(printerName) {
+		if f.native == nil {
+			return nil
+		}
+		return f.native.ReleaseJob(printerName, jobID)
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	delete(f.jobs, jobID)
+	return nil
+}
+
+// CancelJob cancels a job of a simulated printer. When it is the last job
+// of its printer, the printer is idle at once.
+func (f *FakePrinters) CancelJob(printerName string, jobID uint32) error {
+	if !f.isFake(printerName) {
+		if f.native == nil {
+			return fmt.Errorf("Printer %s does not exist", printerName)
+		}
+		return f.native.CancelJob(printerName, jobID)
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	job, exists := f.jobs[jobID]
+	if !exists || job.printerName != printerName {
+		return fmt.Errorf("Job %d of printer %s does not exist", jobID, printerName)
+	}
+	now := f.now()
+	if job.canceled.IsZero() {
+		job.canceled = now
+		if f.busyUntil[printerName].Equal(job.start.Add(f.duration(job.printedPages()))) {
+			f.busyUntil[printerName] = now
+		}
+	}
+	return nil
+}
+
+// RemoveCachedPPD removes the PPD of a native printer from the cache;
+// simulated printers have none.
+func (f *FakePrinters) RemoveCachedPPD(printerName string) {
+	if f.native != nil && !f.isFake(printerName) {
+		f.native.RemoveCachedPPD(printerName)
+	}
+}
@@ -0,0 +1,101 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package fakeprinter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/cloud-print-connector/cdd"
+	"github.com/google/cloud-print-connector/lib"
+)
+
+func TestFakePrinters(t *testing.T) {
+	f, err := NewFakePrinters(nil, 2, nil, 60, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1000, 0)
+	f.now = func() time.Time { return now }
+
+	printers, err := f.GetPrinters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(printers) != 2 || printers[1].Name != NamePrefix+"2" || printers[0].Tags[SimulatedTag] != "true" {
+		t.Fatalf("expected 2 simulated printers, got %+v", printers)
+	}
+
+	// Not a PDF, so one page, three copies: three seconds at 60 pages per
+	// minute.
+	var ticket cdd.CloudJobTicket
+	ticket.Print.Copies = &cdd.CopiesTicketItem{Copies: 3}
+	first, err := f.Print(&printers[0], "/nonexistent", "title", "user", "job1", &ticket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := f.Print(&printers[0], "/nonexistent", "title", "user", "job2", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = f.Print(&lib.Printer{Name: "real-printer"}, "/nonexistent", "title", "user", "job3", nil); err == nil {
+		t.Log("expected a job of a printer that isn't simulated to fail without a native print system")
+		t.Fail()
+	}
+
+	testCases := []struct {
+		after        time.Duration
+		jobID        uint32
+		state        cdd.JobStateType
+		pagesPrinted int32
+	}{
+		{0, first, cdd.JobStateInProgress, 0},
+		{2 * time.Second, first, cdd.JobStateInProgress, 2},
+		{2 * time.Second, second, cdd.JobStateQueued, 0},
+		{3 * time.Second, first, cdd.JobStateDone, 3},
+		{4 * time.Second, second, cdd.JobStateDone, 1},
+	}
+	for _, tc := range testCases {
+		now = time.Unix(1000, 0).Add(tc.after)
+		state, err := f.GetJobState(printers[0].Name, tc.jobID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if state.State.Type != tc.state || *state.PagesPrinted != tc.pagesPrinted {
+			t.Logf("expected job %d to be %s with %d pages after %s, got %s with %d",
+				tc.jobID, tc.state, tc.pagesPrinted, tc.after, state.State.Type, *state.PagesPrinted)
+			t.Fail()
+		}
+	}
+}
+
+func TestFakePrintersFailure(t *testing.T) {
+	f, err := NewFakePrinters(nil, 1, nil, 0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	printers, _ := f.GetPrinters()
+	jobID, err := f.Print(&printers[0], "/nonexistent", "title", "user", "job", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, err := f.GetJobState(printers[0].Name, jobID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.State.Type != cdd.JobStateAborted || state.State.DeviceActionCause == nil {
+		t.Logf("expected the job to fail, got %+v", state.State)
+		t.Fail()
+	}
+
+	if _, err = NewFakePrinters(nil, 1, nil, 0, 2); err == nil {
+		t.Log("expected a failure rate above 1 to be rejected")
+		t.Fail()
+	}
+}
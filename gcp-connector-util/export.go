@@ -0,0 +1,285 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+
+	"github.com/google/cloud-print-connector/cdd"
+	"github.com/google/cloud-print-connector/gcp"
+	"github.com/google/cloud-print-connector/lib"
+	"github.com/urfave/cli"
+)
+
+// printerDefinition is a registered printer, as export-gcp-printers writes
+// it and import-gcp-printers reads it.
+type printerDefinition struct {
+	GCPID              string                         `json:"gcp_id"`
+	Name               string                         `json:"name"`
+	DefaultDisplayName string                         `json:"default_display_name"`
+	UUID               string                         `json:"uuid"`
+	Manufacturer       string                         `json:"manufacturer"`
+	Model              string                         `json:"model"`
+	GCPVersion         string                         `json:"gcp_version"`
+	SetupURL           string                         `json:"setup_url,omitempty"`
+	SupportURL         string                         `json:"support_url,omitempty"`
+	UpdateURL          string                         `json:"update_url,omitempty"`
+	ConnectorVersion   string                         `json:"connector_version,omitempty"`
+	Capabilities       *cdd.PrinterDescriptionSection `json:"capabilities"`
+	CapsHash           string                         `json:"caps_hash"`
+	State              *cdd.PrinterStateSection       `json:"state,omitempty"`
+	Tags               map[string]string              `json:"tags,omitempty"`
+	// Access is nil when it couldn't be retrieved.
+	Access []gcp.Access `json:"access,omitempty"`
+}
+
+// newPrinterDefinition defines a registered printer, shared as access.
+func newPrinterDefinition(printer *lib.Printer, access []gcp.Access) printerDefinition {
+	return printerDefinition{
+		GCPID:              printer.GCPID,
+		Name:               printer.Name,
+		DefaultDisplayName: printer.DefaultDisplayName,
+		UUID:               printer.UUID,
+		Manufacturer:       printer.Manufacturer,
+		Model:              printer.Model,
+		GCPVersion:         printer.GCPVersion,
+		SetupURL:           printer.SetupURL,
+		SupportURL:         printer.SupportURL,
+		UpdateURL:          printer.UpdateURL,
+		ConnectorVersion:   printer.ConnectorVersion,
+		Capabilities:       printer.Description,
+		CapsHash:           printer.CapsHash,
+		State:              printer.State,
+		Tags:               printer.Tags,
+		Access:             access,
+	}
+}
+
+// printer is the printer to register for the definition, without its GCP ID;
+// idle when the definition has no state.
+func (d *printerDefinition) printer() lib.Printer {
+	state := d.State
+	if state == nil {
+		state = &cdd.PrinterStateSection{State: cdd.CloudDeviceStateIdle}
+	}
+	return lib.Printer{
+		Name:               d.Name,
+		DefaultDisplayName: d.DefaultDisplayName,
+		UUID:               d.UUID,
+		Manufacturer:       d.Manufacturer,
+		Model:              d.Model,
+		GCPVersion:         d.GCPVersion,
+		SetupURL:           d.SetupURL,
+		SupportURL:         d.SupportURL,
+		UpdateURL:          d.UpdateURL,
+		ConnectorVersion:   d.ConnectorVersion,
+		State:              state,
+		Description:        d.Capabilities,
+		CapsHash:           d.CapsHash,
+		Tags:               d.Tags,
+	}
+}
+
+// exportGCPPrinters writes the definitions of all GCP printers associated
+// with this connector, and whom they are shared with, to a file.
+func exportGCPPrinters(context *cli.Context) error {
+	if context.String("file") == "" {
+		return fmt.Errorf("--file is required")
+	}
+	config, err := getConfig(context)
+	if err != nil {
+		return err
+	}
+	gcpConn, err := getGCP(config)
+	if err != nil {
+		return err
+	}
+
+	printers, err := gcpConn.List(background)
+	if err != nil {
+		return err
+	}
+	gcpIDs := make([]string, 0, len(printers))
+	for gcpID := range printers {
+		gcpIDs = append(gcpIDs, gcpID)
+	}
+	sort.Slice(gcpIDs, func(i, j int) bool { return printers[gcpIDs[i]] < printers[gcpIDs[j]] })
+
+	definitions := make([]printerDefinition, 0, len(gcpIDs))
+	for _, gcpID := range gcpIDs {
+		printer, _, err := gcpConn.Printer(background, gcpID)
+		if err != nil {
+			return fmt.Errorf("Failed to get printer %s \"%s\": %s", gcpID, printers[gcpID], err)
+		}
+		access, err := gcpConn.PrinterAccess(background, gcpID)
+		if err != nil {
+			fmt.Printf("Failed to get whom %s \"%s\" is shared with; exporting it without: %s\n", gcpID, printer.Name, err)
+		}
+		definitions = append(definitions, newPrinterDefinition(printer, access))
+	}
+
+	b, err := json.MarshalIndent(definitions, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = ioutil.WriteFile(context.String("file"), append(b, '\n'), 0600); err != nil {
+		return err
+	}
+	fmt.Printf("Exported %d printers to %s\n", len(definitions), context.String("file"))
+	return nil
+}
+
+// importGCPPrinters registers the printers of a file written by
+// exportGCPPrinters, unless a printer of the same name is registered
+// already, and shares them like they were shared. The connector adopts the
+// printers by name, as though it had registered them.
+func importGCPPrinters(context *cli.Context) error {
+	if context.String("file") == "" {
+		return fmt.Errorf("--file is required")
+	}
+	config, err := getConfig(context)
+	if err != nil {
+		return err
+	}
+	gcpConn, err := getGCP(config)
+	if err != nil {
+		return err
+	}
+
+	b, err := ioutil.ReadFile(context.String("file"))
+	if err != nil {
+		return err
+	}
+	var definitions []printerDefinition
+	if err = json.Unmarshal(b, &definitions); err != nil {
+		return fmt.Errorf("Failed to read printer definitions from %s: %s", context.String("file"), err)
+	}
+
+	registered, err := gcpConn.List(background)
+	if err != nil {
+		return err
+	}
+	registeredNames := make(map[string]string, len(registered))
+	for gcpID, name := range registered {
+		registeredNames[name] = gcpID
+	}
+
+	share := !context.Bool("skip-sharing")
+	if share && config.UserRefreshToken == "" {
+		fmt.Println("Not sharing the printers, because the config file has no user OAuth credentials")
+		share = false
+	}
+
+	var failures int
+	for _, d := range definitions {
+		if gcpID, exists := registeredNames[d.Name]; exists {
+			fmt.Printf("Skipped \"%s\", which is registered already as %s\n", d.Name, gcpID)
+			continue
+		}
+
+		printer := d.printer()
+		if err := gcpConn.Register(background, &printer); err != nil {
+			fmt.Printf("Failed to register \"%s\": %s\n", d.Name, err)
+			failures++
+			continue
+		}
+		fmt.Printf("Registered %s \"%s\" as %s\n", d.GCPID, d.Name, printer.GCPID)
+
+		if !share {
+			continue
+		}
+		for _, a := range d.Access {
+			if a.Role == gcp.Owner {
+				continue
+			}
+			if err := gcpConn.Share(background, printer.GCPID, a.Scope, a.Role, true, false); err != nil {
+				fmt.Printf("Failed to share \"%s\" with %s: %s\n", d.Name, a.Scope, err)
+				failures++
+			} else {
+				fmt.Printf("Shared \"%s\" with %s as %s\n", d.Name, a.Scope, a.Role)
+			}
+		}
+	}
+
+	if failures > 0 {
+		return fmt.Errorf("%d printers or shares failed to import", failures)
+	}
+	return nil
+}
+
+// auditExport is an export of the audit log, as export-audit-log writes it.
+// Its checkpoint is the head of the log, for the next export to verify.
+type auditExport struct {
+	Checkpoint lib.AuditCheckpoint `json:"checkpoint"`
+	Entries    []lib.AuditEntry    `json:"entries"`
+}
+
+// exportAuditLog verifies the hash chain of the audit log, and the
+// checkpoint of an earlier export, when given, and writes its entries to a
+// file, with a checkpoint of its head, for compliance reviews. Nothing is
+// written when the verification fails.
+func exportAuditLog(context *cli.Context) error {
+	if context.String("file") == "" {
+		return fmt.Errorf("--file is required")
+	}
+	config, err := getConfig(context)
+	if err != nil {
+		return err
+	}
+	if config.AuditLogFile == "" {
+		return fmt.Errorf("The config file has no audit_log_file")
+	}
+
+	key, err := lib.ReadAuditKey(config.AuditLogKeyFile)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(config.AuditLogFile)
+	if err != nil {
+		return err
+	}
+	entries, err := lib.ReadAuditLog(f, key)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("The audit log %s failed verification after %d good entries: %s", config.AuditLogFile, len(entries), err)
+	}
+
+	if context.String("checkpoint") != "" {
+		b, err := ioutil.ReadFile(context.String("checkpoint"))
+		if err != nil {
+			return err
+		}
+		var previous auditExport
+		if err = json.Unmarshal(b, &previous); err != nil {
+			return fmt.Errorf("Failed to read the checkpoint of %s: %s", context.String("checkpoint"), err)
+		}
+		if err = lib.VerifyAuditCheckpoint(entries, previous.Checkpoint); err != nil {
+			return fmt.Errorf("The audit log %s failed verification against the checkpoint of %s: %s", config.AuditLogFile, context.String("checkpoint"), err)
+		}
+	}
+
+	export := auditExport{Entries: entries}
+	if len(entries) > 0 {
+		last := entries[len(entries)-1]
+		export.Checkpoint = lib.AuditCheckpoint{Sequence: last.Sequence, Hash: last.Hash, Time: last.Time}
+	}
+	b, err := json.MarshalIndent(export, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = ioutil.WriteFile(context.String("file"), append(b, '\n'), 0600); err != nil {
+		return err
+	}
+	fmt.Printf("Verified and exported %d audit entries to %s; keep it apart from the log, to verify the next export with --checkpoint\n", len(entries), context.String("file"))
+	return nil
+}
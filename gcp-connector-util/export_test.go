@@ -0,0 +1,74 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/google/cloud-print-connector/cdd"
+	"github.com/google/cloud-print-connector/gcp"
+	"github.com/google/cloud-print-connector/lib"
+)
+
+func TestPrinterDefinitionRoundTrip(t *testing.T) {
+	exported := lib.Printer{
+		GCPID:              "6c0a2f1e-93b4-4d02-b8f7-0e5d1a2b3c05",
+		Name:               "laser",
+		DefaultDisplayName: "Laser on 2",
+		UUID:               "c3a1b2d4-0000-4000-8000-000000000001",
+		Manufacturer:       "Test",
+		Model:              "Laser 9000",
+		GCPVersion:         lib.GCPAPIVersion,
+		SetupURL:           "https://example.com/setup",
+		SupportURL:         "https://example.com/support",
+		UpdateURL:          "https://example.com/update",
+		ConnectorVersion:   "1.0",
+		State:              &cdd.PrinterStateSection{State: cdd.CloudDeviceStateStopped},
+		Description: &cdd.PrinterDescriptionSection{
+			SupportedContentType: cdd.NewSupportedContentType("application/pdf"),
+			Copies:               &cdd.Copies{Default: 1, Max: 10},
+			Duplex:               &cdd.Duplex{Option: []cdd.DuplexOption{{Type: cdd.DuplexNoDuplex, IsDefault: true}, {Type: cdd.DuplexLongEdge}}},
+		},
+		CapsHash: "caps-hash",
+		Tags:     map[string]string{"location": "second floor"},
+	}
+	access := []gcp.Access{{Scope: "alice@example.com", Role: gcp.User, Type: "USER"}}
+
+	b, err := json.Marshal([]printerDefinition{newPrinterDefinition(&exported, access)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var definitions []printerDefinition
+	if err = json.Unmarshal(b, &definitions); err != nil {
+		t.Fatal(err)
+	}
+	if len(definitions) != 1 {
+		t.Fatalf("expected 1 definition, got %d", len(definitions))
+	}
+	if !reflect.DeepEqual(definitions[0].Access, access) {
+		t.Logf("expected access %+v, got %+v", access, definitions[0].Access)
+		t.Fail()
+	}
+
+	// The imported printer is registered anew, so it has no GCP ID yet.
+	expected := exported
+	expected.GCPID = ""
+	if imported := definitions[0].printer(); !reflect.DeepEqual(imported, expected) {
+		t.Logf("expected %+v, got %+v", expected, imported)
+		t.Fail()
+	}
+
+	var stateless printerDefinition
+	if imported := stateless.printer(); imported.State == nil || imported.State.State != cdd.CloudDeviceStateIdle {
+		t.Logf("expected a definition without a state to be idle, got %+v", imported.State)
+		t.Fail()
+	}
+}
@@ -9,6 +9,7 @@ https://developers.google.com/open-source/licenses/bsd
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -22,7 +23,17 @@ import (
 	"github.com/urfave/cli"
 )
 
+// background is the context of cloud requests. Commands name their
+// *cli.Context context, which hides the context package.
+var background = context.Background()
+
 var commonCommands = []cli.Command{
+	cli.Command{
+		Name:   "reauthorize",
+		Usage:  "Replace revoked robot account credentials in the config file, keeping the printers",
+		Action: reauthorizeConfigFile,
+		Flags:  reauthorizeFlags,
+	},
 	cli.Command{
 		Name:   "delete-all-gcp-printers",
 		Usage:  "Delete all printers associated with this connector",
@@ -158,6 +169,47 @@ var commonCommands = []cli.Command{
 			},
 		},
 	},
+	cli.Command{
+		Name:   "export-gcp-printers",
+		Usage:  "Write the definitions of all printers registered by this connector to a JSON file",
+		Action: exportGCPPrinters,
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "file",
+				Usage: "File to write the printer definitions to",
+			},
+		},
+	},
+	cli.Command{
+		Name:   "import-gcp-printers",
+		Usage:  "Register the printers of a file written by export-gcp-printers with this connector's robot account",
+		Action: importGCPPrinters,
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "file",
+				Usage: "File to read the printer definitions from",
+			},
+			cli.BoolFlag{
+				Name:  "skip-sharing",
+				Usage: "Don't share the printers like they were shared",
+			},
+		},
+	},
+	cli.Command{
+		Name:   "export-audit-log",
+		Usage:  "Verify the hash chain of the audit log, and write its entries, with a checkpoint of its head, to a JSON file for review",
+		Action: exportAuditLog,
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "file",
+				Usage: "File to write the audit entries to",
+			},
+			cli.StringFlag{
+				Name:  "checkpoint",
+				Usage: "An earlier export, kept apart from the log, whose checkpoint the log must still have",
+			},
+		},
+	},
 }
 
 // getConfig returns a config object
@@ -166,15 +218,62 @@ func getConfig(context *cli.Context) (*lib.Config, error) {
 	if err != nil {
 		return nil, err
 	}
+	if err = setTLSPolicy(config); err != nil {
+		return nil, err
+	}
 	return config, nil
 }
 
+// setTLSPolicy limits TLS by the policy of the config, as the connector
+// does.
+func setTLSPolicy(config *lib.Config) error {
+	return lib.SetTLSPolicy(config.TLSProfile, config.TLSMinVersion, config.TLSMaxVersion, config.TLSCipherSuites, config.TLSCurves)
+}
+
 // getGCP returns a GoogleCloudPrint object
 func getGCP(config *lib.Config) (*gcp.GoogleCloudPrint, error) {
+	auditLog, err := getAuditLog(config)
+	if err != nil {
+		return nil, err
+	}
 	return gcp.NewGoogleCloudPrint(config.GCPBaseURL, config.RobotRefreshToken,
 		config.UserRefreshToken, config.ProxyName, config.GCPOAuthClientID,
 		config.GCPOAuthClientSecret, config.GCPOAuthAuthURL, config.GCPOAuthTokenURL,
-		0, nil)
+		0, nil, nil, nil, auditLog)
+}
+
+// getAuditLog returns the audit log of the config, which is nil when it has
+// none.
+func getAuditLog(config *lib.Config) (*lib.AuditLog, error) {
+	return lib.NewAuditLog(config.AuditLogFile, config.AuditLogKeyFile, "gcp-connector-util")
+}
+
+// recordAudit records an event in the audit log of the config, and prints
+// when it can't.
+func recordAudit(config *lib.Config, event lib.AuditEvent, format string, args ...interface{}) {
+	auditLog, err := getAuditLog(config)
+	if err == nil {
+		err = auditLog.Record(event, fmt.Sprintf(format, args...))
+	}
+	if err != nil {
+		fmt.Printf("Failed to record %s event in the audit log: %s\n", event, err)
+	}
+}
+
+// migrateConfigFile upgrades the config file to the current schema
+// version, before any command reads it.
+func migrateConfigFile(context *cli.Context) error {
+	cf, backup, report, err := lib.MigrateConfigFile(context)
+	if err != nil {
+		return fmt.Errorf("Failed to migrate config file %s: %s", cf, err)
+	}
+	if len(report) > 0 {
+		fmt.Printf("Migrated %s to schema version %d, and saved the old file as %s:\n", cf, lib.ConfigSchemaVersion, backup)
+		for _, change := range report {
+			fmt.Printf("  %s\n", change)
+		}
+	}
+	return nil
 }
 
 // backfillConfigFile opens the config file, adds all missing keys
@@ -239,7 +338,7 @@ func deleteAllGCPPrinters(context *cli.Context) error {
 		return err
 	}
 
-	printers, err := gcp.List()
+	printers, err := gcp.List(background)
 	if err != nil {
 		return err
 	}
@@ -249,7 +348,7 @@ func deleteAllGCPPrinters(context *cli.Context) error {
 		wg.Add(1)
 		go func(gcpID, name string) {
 			defer wg.Done()
-			err := gcp.Delete(gcpID)
+			err := gcp.Delete(background, gcpID)
 			if err != nil {
 				fmt.Printf("Failed to delete %s \"%s\": %s\n", gcpID, name, err)
 			} else {
@@ -272,7 +371,7 @@ func deleteGCPJob(context *cli.Context) error {
 		return err
 	}
 
-	err = gcp.DeleteJob(context.String("job-id"))
+	err = gcp.DeleteJob(background, context.String("job-id"))
 	if err != nil {
 		return fmt.Errorf("Failed to delete GCP job %s: %s\n", context.String("job-id"), err)
 	}
@@ -298,7 +397,7 @@ func cancelGCPJob(context *cli.Context) error {
 		},
 	}
 
-	err = gcp.Control(context.String("job-id"), &cancelState)
+	err = gcp.Control(background, context.String("job-id"), &cancelState, "")
 	if err != nil {
 		return fmt.Errorf("Failed to cancel GCP job %s: %s", context.String("job-id"), err)
 	}
@@ -318,7 +417,7 @@ func deleteAllGCPPrinterJobs(context *cli.Context) error {
 		return err
 	}
 
-	jobs, err := gcp.Fetch(context.String("printer-id"))
+	jobs, err := gcp.Fetch(background, context.String("printer-id"))
 	if err != nil {
 		return err
 	}
@@ -330,7 +429,7 @@ func deleteAllGCPPrinterJobs(context *cli.Context) error {
 	ch := make(chan bool)
 	for _, job := range jobs {
 		go func(gcpJobID string) {
-			err := gcp.DeleteJob(gcpJobID)
+			err := gcp.DeleteJob(background, gcpJobID)
 			if err != nil {
 				fmt.Printf("Failed to delete GCP job %s: %s\n", gcpJobID, err)
 			} else {
@@ -358,7 +457,7 @@ func cancelAllGCPPrinterJobs(context *cli.Context) error {
 		return err
 	}
 
-	jobs, err := gcp.Fetch(context.String("printer-id"))
+	jobs, err := gcp.Fetch(background, context.String("printer-id"))
 	if err != nil {
 		return err
 	}
@@ -377,7 +476,7 @@ func cancelAllGCPPrinterJobs(context *cli.Context) error {
 	ch := make(chan bool)
 	for _, job := range jobs {
 		go func(gcpJobID string) {
-			err := gcp.Control(gcpJobID, &cancelState)
+			err := gcp.Control(background, gcpJobID, &cancelState, "")
 			if err != nil {
 				fmt.Printf("Failed to cancel GCP job %s: %s\n", gcpJobID, err)
 			} else {
@@ -404,7 +503,7 @@ func showGCPPrinterStatus(context *cli.Context) error {
 		return err
 	}
 
-	printer, _, err := gcp.Printer(context.String("printer-id"))
+	printer, _, err := gcp.Printer(background, context.String("printer-id"))
 	if err != nil {
 		return err
 	}
@@ -412,7 +511,7 @@ func showGCPPrinterStatus(context *cli.Context) error {
 	fmt.Println("Name:", printer.DefaultDisplayName)
 	fmt.Println("State:", printer.State.State)
 
-	jobs, err := gcp.Jobs(context.String("printer-id"))
+	jobs, err := gcp.Jobs(background, context.String("printer-id"))
 	if err != nil {
 		return err
 	}
@@ -460,7 +559,7 @@ func shareGCPPrinter(context *cli.Context) error {
 		return fmt.Errorf("role should be user or manager.")
 	}
 
-	err = gcpConn.Share(context.String("printer-id"), context.String("email"),
+	err = gcpConn.Share(background, context.String("printer-id"), context.String("email"),
 		role, context.Bool("skip-notification"), context.Bool("public"))
 	var sharedWith string
 	if context.Bool("public") {
@@ -486,7 +585,7 @@ func unshareGCPPrinter(context *cli.Context) error {
 		return err
 	}
 
-	err = gcpConn.Unshare(context.String("printer-id"), context.String("email"), context.Bool("public"))
+	err = gcpConn.Unshare(background, context.String("printer-id"), context.String("email"), context.Bool("public"))
 	var sharedWith string
 	if context.Bool("public") {
 		sharedWith = "public"
@@ -525,7 +624,7 @@ func updateGCPPrinter(context *cli.Context) error {
 		diff.Printer.DailyQuota = context.Int("daily-quota")
 		diff.DailyQuotaChanged = true
 	}
-	err = gcpConn.Update(&diff)
+	err = gcpConn.Update(background, &diff)
 	if err != nil {
 		return fmt.Errorf("Failed to update GCP printer %s: %s", context.String("printer-id"), err)
 	} else {
@@ -0,0 +1,326 @@
+// Copyright 2015 Google Inc. All rights reserved.
+
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+// +build linux darwin freebsd
+
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/google/cloud-print-connector/gcp"
+	"github.com/google/cloud-print-connector/lib"
+	"github.com/urfave/cli"
+)
+
+// The columns of a printer rules CSV file. Only printer is required; an
+// empty cell leaves that setting of the printer alone.
+const (
+	// printer is the CUPS queue name.
+	rulesColumnPrinter = "printer"
+	// share is the users, groups and domains to share the printer with,
+	// separated by semicolons.
+	rulesColumnShare = "share"
+	// role is the role that they are granted: user, the default, or manager.
+	rulesColumnRole = "role"
+	// color is allow, to let everyone print in color, grayscale, to print
+	// every job in grayscale, or hours like 09:00-17:00, to print in color
+	// only within them.
+	rulesColumnColor = "color"
+	// color_users is the users who print in color at any time, separated by
+	// semicolons; not with color allow.
+	rulesColumnColorUsers = "color_users"
+	// quota is the pages that each user may print per day; 0 is no quota.
+	rulesColumnQuota = "quota"
+)
+
+var rulesColumns = []string{rulesColumnPrinter, rulesColumnShare, rulesColumnRole, rulesColumnColor, rulesColumnColorUsers, rulesColumnQuota}
+
+// colorRuleDefinition is a rule of the color policy file, as the connector
+// reads it.
+type colorRuleDefinition struct {
+	Printer      string   `json:"printer"`
+	AllowColor   bool     `json:"allow_color,omitempty"`
+	AllowedUsers []string `json:"allowed_users,omitempty"`
+	ColorHours   string   `json:"color_hours,omitempty"`
+}
+
+// printerRules are the sharing, color policy and quota of one printer.
+type printerRules struct {
+	line    int
+	printer string
+	share   []string
+	role    gcp.Role
+	// color is nil to leave the printer's color rule alone.
+	color *colorRuleDefinition
+	// quota is negative to leave the printer's quota alone.
+	quota int
+}
+
+var rColorHoursCell = regexp.MustCompile(`^([01]?\d|2[0-3]):[0-5]\d-([01]?\d|2[0-3]):[0-5]\d$`)
+
+// readPrinterRules reads a CSV file of printer rules, whose first row names
+// its columns.
+func readPrinterRules(r io.Reader) ([]printerRules, error) {
+	cr := csv.NewReader(r)
+	cr.TrimLeadingSpace = true
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read the header row: %s", err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		known := false
+		for _, c := range rulesColumns {
+			known = known || c == name
+		}
+		if !known {
+			return nil, fmt.Errorf("Column %q is not one of %s", name, strings.Join(rulesColumns, ", "))
+		}
+		columns[name] = i
+	}
+	if _, exists := columns[rulesColumnPrinter]; !exists {
+		return nil, fmt.Errorf("The header row has no %s column", rulesColumnPrinter)
+	}
+
+	var rules []printerRules
+	seen := make(map[string]int)
+	for line := 2; ; line++ {
+		row, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		cell := func(column string) string {
+			if i, exists := columns[column]; exists {
+				return strings.TrimSpace(row[i])
+			}
+			return ""
+		}
+
+		p := printerRules{line: line, printer: cell(rulesColumnPrinter), role: gcp.User, quota: -1}
+		if p.printer == "" {
+			return nil, fmt.Errorf("Line %d has no printer", line)
+		}
+		if first, exists := seen[p.printer]; exists {
+			return nil, fmt.Errorf("Line %d repeats printer %s of line %d", line, p.printer, first)
+		}
+		seen[p.printer] = line
+
+		p.share = splitList(cell(rulesColumnShare))
+		switch strings.ToUpper(cell(rulesColumnRole)) {
+		case "", "USER":
+		case "MANAGER":
+			p.role = gcp.Manager
+		default:
+			return nil, fmt.Errorf("Line %d role %q should be user or manager", line, cell(rulesColumnRole))
+		}
+
+		colorUsers := splitList(cell(rulesColumnColorUsers))
+		pattern := "^" + regexp.QuoteMeta(p.printer) + "$"
+		switch color := strings.ToLower(cell(rulesColumnColor)); {
+		case color == "" && len(colorUsers) == 0:
+		case color == "allow" && len(colorUsers) > 0:
+			return nil, fmt.Errorf("Line %d color allow lets everyone print in color, so it should have no %s", line, rulesColumnColorUsers)
+		case color == "allow":
+			p.color = &colorRuleDefinition{Printer: pattern, AllowColor: true}
+		case color == "" || color == "grayscale":
+			p.color = &colorRuleDefinition{Printer: pattern, AllowedUsers: colorUsers}
+		case rColorHoursCell.MatchString(color):
+			p.color = &colorRuleDefinition{Printer: pattern, AllowedUsers: colorUsers, ColorHours: color}
+		default:
+			return nil, fmt.Errorf("Line %d color %q should be allow, grayscale, or hours like 09:00-17:00", line, color)
+		}
+
+		if quota := cell(rulesColumnQuota); quota != "" {
+			if p.quota, err = strconv.Atoi(quota); err != nil || p.quota < 0 {
+				return nil, fmt.Errorf("Line %d quota %q should be pages per day, or 0 for none", line, quota)
+			}
+		}
+
+		rules = append(rules, p)
+	}
+	return rules, nil
+}
+
+// splitList splits a cell of values separated by semicolons.
+func splitList(cell string) []string {
+	var values []string
+	for _, v := range strings.Split(cell, ";") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// importPrinterRules applies a CSV file of printer rules to the printers
+// registered by this connector: it shares them, sets their daily quotas,
+// and writes their color rules to the color policy file.
+func importPrinterRules(context *cli.Context) error {
+	if context.String("file") == "" {
+		return fmt.Errorf("--file is required")
+	}
+	config, err := getConfig(context)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(context.String("file"))
+	if err != nil {
+		return err
+	}
+	rules, err := readPrinterRules(f)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("Failed to read printer rules from %s: %s", context.String("file"), err)
+	}
+
+	dryRun := context.Bool("dry-run")
+	var colorRules []printerRules
+	for _, p := range rules {
+		if len(p.share) > 0 && config.UserRefreshToken == "" {
+			return fmt.Errorf("Line %d shares a printer, but the config file has no user OAuth credentials", p.line)
+		}
+		if p.color != nil {
+			colorRules = append(colorRules, p)
+		}
+	}
+	if len(colorRules) > 0 && config.CUPSColorPolicyFile == "" {
+		return fmt.Errorf("Line %d sets a color policy, but the config file has no cups_color_policy_file", colorRules[0].line)
+	}
+
+	gcpConn, err := getGCP(config)
+	if err != nil {
+		return err
+	}
+	registered, err := gcpConn.List(background)
+	if err != nil {
+		return err
+	}
+	gcpIDs := make(map[string]string, len(registered))
+	for gcpID, name := range registered {
+		gcpIDs[name] = gcpID
+	}
+
+	var failures int
+	for _, p := range rules {
+		gcpID, exists := gcpIDs[p.printer]
+		if !exists {
+			fmt.Printf("Line %d: printer %s is not registered by this connector\n", p.line, p.printer)
+			failures++
+			continue
+		}
+
+		for _, scope := range p.share {
+			if dryRun {
+				fmt.Printf("Would share %s with %s as %s\n", p.printer, scope, p.role)
+			} else if err := gcpConn.Share(background, gcpID, scope, p.role, true, false); err != nil {
+				fmt.Printf("Line %d: failed to share %s with %s: %s\n", p.line, p.printer, scope, err)
+				failures++
+			} else {
+				fmt.Printf("Shared %s with %s as %s\n", p.printer, scope, p.role)
+			}
+		}
+
+		if p.quota >= 0 {
+			diff := lib.PrinterDiff{
+				Printer:             lib.Printer{GCPID: gcpID, QuotaEnabled: p.quota > 0, DailyQuota: p.quota},
+				QuotaEnabledChanged: true,
+				DailyQuotaChanged:   p.quota > 0,
+			}
+			if dryRun {
+				fmt.Printf("Would set the daily quota of %s to %d pages\n", p.printer, p.quota)
+			} else if err := gcpConn.Update(background, &diff); err != nil {
+				fmt.Printf("Line %d: failed to set the daily quota of %s: %s\n", p.line, p.printer, err)
+				failures++
+			} else {
+				fmt.Printf("Set the daily quota of %s to %d pages\n", p.printer, p.quota)
+			}
+		}
+	}
+
+	if len(colorRules) > 0 {
+		if err := writeColorRules(config.CUPSColorPolicyFile, colorRules, dryRun); err != nil {
+			fmt.Printf("Failed to write color policy file %s: %s\n", config.CUPSColorPolicyFile, err)
+			failures++
+		} else if !dryRun {
+			printers := make([]string, len(colorRules))
+			for i, p := range colorRules {
+				printers[i] = p.printer
+			}
+			recordAudit(config, lib.AuditEventPolicy, "Replaced the color rules of %s in %s", strings.Join(printers, ", "), config.CUPSColorPolicyFile)
+		}
+	}
+
+	if failures > 0 {
+		return fmt.Errorf("%d printer rules failed to import", failures)
+	}
+	return nil
+}
+
+// writeColorRules replaces the color rules of printers in a color policy
+// file. Their rules go first, so that they come before the rules that
+// match many printers; a printer that allows color gets a rule that
+// allows it, so that a broader rule doesn't limit it.
+func writeColorRules(filename string, rules []printerRules, dryRun bool) error {
+	var existing []colorRuleDefinition
+	b, err := ioutil.ReadFile(filename)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		if err = json.Unmarshal(b, &existing); err != nil {
+			return err
+		}
+	}
+
+	replaced := make(map[string]struct{}, len(rules))
+	policy := []colorRuleDefinition{}
+	for _, p := range rules {
+		replaced["^"+regexp.QuoteMeta(p.printer)+"$"] = struct{}{}
+		policy = append(policy, *p.color)
+		switch {
+		case p.color.AllowColor:
+			fmt.Printf("Color: %s prints in color\n", p.printer)
+		case p.color.ColorHours != "":
+			fmt.Printf("Color: %s prints in color within %s\n", p.printer, p.color.ColorHours)
+		case len(p.color.AllowedUsers) > 0:
+			fmt.Printf("Color: %s prints in color for %s only\n", p.printer, strings.Join(p.color.AllowedUsers, ", "))
+		default:
+			fmt.Printf("Color: %s prints in grayscale\n", p.printer)
+		}
+	}
+	for _, r := range existing {
+		if _, exists := replaced[r.Printer]; !exists {
+			policy = append(policy, r)
+		}
+	}
+	if dryRun {
+		fmt.Printf("Would write %d color rules to %s\n", len(policy), filename)
+		return nil
+	}
+
+	if b, err = json.MarshalIndent(policy, "", "  "); err != nil {
+		return err
+	}
+	if err = ioutil.WriteFile(filename, append(b, '\n'), 0644); err != nil {
+		return err
+	}
+	fmt.Printf("Wrote %d color rules to %s; restart the connector to apply them\n", len(policy), filename)
+	return nil
+}
@@ -0,0 +1,159 @@
+// Copyright 2015 Google Inc. All rights reserved.
+
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+// +build linux darwin freebsd
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/cloud-print-connector/gcp"
+)
+
+func TestReadPrinterRules(t *testing.T) {
+	for _, c := range []struct {
+		name  string
+		csv   string
+		rules []printerRules
+		err   string
+	}{
+		{
+			name: "header",
+			csv:  " Printer ,QUOTA\nlaser, 50\n",
+			rules: []printerRules{
+				{line: 2, printer: "laser", role: gcp.User, quota: 50},
+			},
+		},
+		{
+			name: "unknown column",
+			csv:  "printer,owner\nlaser,alice\n",
+			err:  `Column "owner" is not one of`,
+		},
+		{
+			name: "no printer column",
+			csv:  "share,quota\nalice@example.com,5\n",
+			err:  "The header row has no printer column",
+		},
+		{
+			name: "empty",
+			csv:  "",
+			err:  "Failed to read the header row",
+		},
+		{
+			name: "roles",
+			csv:  "printer,share,role\nlaser,alice@example.com; example.com,\ninkjet,bob@example.com,Manager\n",
+			rules: []printerRules{
+				{line: 2, printer: "laser", share: []string{"alice@example.com", "example.com"}, role: gcp.User, quota: -1},
+				{line: 3, printer: "inkjet", share: []string{"bob@example.com"}, role: gcp.Manager, quota: -1},
+			},
+		},
+		{
+			name: "unknown role",
+			csv:  "printer,role\nlaser,owner\n",
+			err:  `Line 2 role "owner" should be user or manager`,
+		},
+		{
+			name: "colors",
+			csv:  "printer,color,color_users\na,allow,\nb,grayscale,alice@example.com\nc,,bob@example.com\nd,09:00-17:00,\ne,,\n",
+			rules: []printerRules{
+				{line: 2, printer: "a", role: gcp.User, quota: -1, color: &colorRuleDefinition{Printer: "^a$", AllowColor: true}},
+				{line: 3, printer: "b", role: gcp.User, quota: -1, color: &colorRuleDefinition{Printer: "^b$", AllowedUsers: []string{"alice@example.com"}}},
+				{line: 4, printer: "c", role: gcp.User, quota: -1, color: &colorRuleDefinition{Printer: "^c$", AllowedUsers: []string{"bob@example.com"}}},
+				{line: 5, printer: "d", role: gcp.User, quota: -1, color: &colorRuleDefinition{Printer: "^d$", ColorHours: "09:00-17:00"}},
+				{line: 6, printer: "e", role: gcp.User, quota: -1},
+			},
+		},
+		{
+			name: "color allow with color users",
+			csv:  "printer,color,color_users\nlaser,allow,alice@example.com\n",
+			err:  "Line 2 color allow lets everyone print in color, so it should have no color_users",
+		},
+		{
+			name: "unknown color",
+			csv:  "printer,color\nlaser,sepia\n",
+			err:  `Line 2 color "sepia" should be allow, grayscale, or hours like 09:00-17:00`,
+		},
+		{
+			name: "hours",
+			csv:  "printer,color\nlaser,24:00-08:00\n",
+			err:  `Line 2 color "24:00-08:00" should be allow`,
+		},
+		{
+			name: "quota",
+			csv:  "printer,quota\nlaser,-1\n",
+			err:  `Line 2 quota "-1" should be pages per day, or 0 for none`,
+		},
+		{
+			name: "duplicate printer",
+			csv:  "printer\nlaser\ninkjet\nlaser\n",
+			err:  "Line 4 repeats printer laser of line 2",
+		},
+		{
+			name: "no printer",
+			csv:  "printer,quota\n,5\n",
+			err:  "Line 2 has no printer",
+		},
+		{
+			name: "short line",
+			csv:  "printer,quota\nlaser\n",
+			err:  "wrong number of fields",
+		},
+	} {
+		rules, err := readPrinterRules(strings.NewReader(c.csv))
+		if c.err != "" {
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Logf("%s: expected error %q, got %v", c.name, c.err, err)
+				t.Fail()
+			}
+			continue
+		}
+		if err != nil {
+			t.Logf("%s: %s", c.name, err)
+			t.Fail()
+			continue
+		}
+		if !reflect.DeepEqual(rules, c.rules) {
+			t.Logf("%s: expected %+v, got %+v", c.name, c.rules, rules)
+			t.Fail()
+		}
+	}
+}
+
+func TestWriteColorRules(t *testing.T) {
+	f, err := ioutil.TempFile("", "color-policy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`[{"printer": "^a$", "color_hours": "09:00-17:00"}, {"printer": ".*"}]`)
+	f.Close()
+
+	rules := []printerRules{
+		{line: 2, printer: "a", color: &colorRuleDefinition{Printer: "^a$", AllowColor: true}},
+	}
+	if err = writeColorRules(f.Name(), rules, false); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var policy []colorRuleDefinition
+	if err = json.Unmarshal(b, &policy); err != nil {
+		t.Fatal(err)
+	}
+	expected := []colorRuleDefinition{{Printer: "^a$", AllowColor: true}, {Printer: ".*"}}
+	if !reflect.DeepEqual(expected, policy) {
+		t.Logf("expected the printer that allows color to come before the broader rule, got %+v", policy)
+		t.Fail()
+	}
+}
@@ -11,6 +11,7 @@ package main
 import (
 	"bufio"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
@@ -34,7 +35,8 @@ const (
 	gcpOAuthGrantTypeDevice = "http://oauth.net/grant_type/device/1.0"
 )
 
-var commonInitFlags = []cli.Flag{
+// credentialFlags are the flags needed to acquire robot account credentials.
+var credentialFlags = []cli.Flag{
 	cli.DurationFlag{
 		Name:  "gcp-api-timeout",
 		Usage: "GCP API timeout, for debugging",
@@ -54,6 +56,15 @@ var commonInitFlags = []cli.Flag{
 		Name:  "gcp-user-refresh-token",
 		Usage: "GCP user refresh token, useful when managing many connectors",
 	},
+}
+
+// reauthorizeFlags are the flags of the reauthorize command.
+var reauthorizeFlags = append(credentialFlags, cli.BoolFlag{
+	Name:  "force",
+	Usage: "Write the new credentials even when the new robot account sees none of the printers",
+})
+
+var commonInitFlags = append(credentialFlags, []cli.Flag{
 	cli.StringFlag{
 		Name:  "share-scope",
 		Usage: "Scope (user or group email address) to automatically share printers with",
@@ -82,6 +93,11 @@ var commonInitFlags = []cli.Flag{
 		Usage: "Maximum quantity of PDFs to download concurrently from GCP cloud service",
 		Value: int(lib.DefaultConfig.GCPMaxConcurrentDownloads),
 	},
+	cli.IntFlag{
+		Name:  "gcp-max-concurrent-fetches",
+		Usage: "Maximum quantity of printers to fetch jobs for concurrently from GCP cloud service",
+		Value: int(lib.DefaultConfig.GCPMaxConcurrentFetches),
+	},
 	cli.IntFlag{
 		Name:  "native-job-queue-size",
 		Usage: "Native job queue size",
@@ -109,6 +125,10 @@ var commonInitFlags = []cli.Flag{
 		Name:  "cloud-printing-enable",
 		Usage: "Enable cloud discovery and printing",
 	},
+	cli.StringFlag{
+		Name:  "profile",
+		Usage: "Config profile with defaults for a deployment shape: " + strings.Join(lib.ConfigProfiles(), ", "),
+	},
 	cli.StringFlag{
 		Name:  "log-level",
 		Usage: "Minimum event severity to log: FATAL, ERROR, WARNING, INFO, DEBUG",
@@ -124,7 +144,7 @@ var commonInitFlags = []cli.Flag{
 		Usage: "Local HTTP API server port range, high",
 		Value: int(lib.DefaultConfig.LocalPortHigh),
 	},
-}
+}...)
 
 func postWithRetry(url string, data url.Values) (*http.Response, error) {
 	backoff := lib.Backoff{}
@@ -349,7 +369,10 @@ func stringToBool(val string) (bool, bool) {
 }
 
 func initConfigFile(context *cli.Context) error {
-	var err error
+	profile, err := lib.ProfileDefaults(context.String("profile"))
+	if err != nil {
+		return err
+	}
 
 	var localEnable bool
 	if runtime.GOOS == "windows" {
@@ -357,6 +380,8 @@ func initConfigFile(context *cli.Context) error {
 		localEnable = false
 	} else if context.IsSet("local-printing-enable") {
 		localEnable = context.Bool("local-printing-enable")
+	} else if profile.Profile != "" {
+		localEnable = profile.LocalPrintingEnable
 	} else {
 		fmt.Println("\"Local printing\" means that clients print directly to the connector via")
 		fmt.Println("local subnet, and that an Internet connection is neither necessary nor used.")
@@ -434,6 +459,8 @@ func initConfigFile(context *cli.Context) error {
 	} else {
 		config = createLocalConfig(context)
 	}
+	config.Profile = profile.Profile
+	config = config.UseProfileDefaults(context)
 
 	configFilename, err := config.Sparse(context).ToFile(context)
 	if err != nil {
@@ -453,3 +480,86 @@ func initConfigFile(context *cli.Context) error {
 	}
 	return nil
 }
+
+// checkReauthorizedPrinters refuses new robot account credentials that see
+// none of the printers of proxy, unless force is set: they probably belong
+// to another account, and would lose the printers.
+func checkReauthorizedPrinters(printers int, proxy string, force bool) error {
+	if printers > 0 || force {
+		return nil
+	}
+	return fmt.Errorf("The new robot account owns no printers of proxy %s, so the config file was not changed; set --force to write the new credentials anyway", proxy)
+}
+
+// reauthorizeConfigFile replaces the robot account credentials in the config
+// file, for when they have been revoked. The proxy name is kept, so the new
+// robot account owns the same printers, with the same IDs and shares.
+func reauthorizeConfigFile(context *cli.Context) error {
+	config, configFilename, err := lib.GetConfig(context)
+	if err != nil {
+		return err
+	}
+	if configFilename == "" {
+		return errors.New("Could not find a config file to reauthorize")
+	}
+	if err = setTLSPolicy(config); err != nil {
+		return err
+	}
+	if !config.CloudPrintingEnable || config.ProxyName == "" {
+		return errors.New("Cloud printing is not enabled in the config file, so there is nothing to reauthorize")
+	}
+	if context.String("gcp-oauth-client-id") != config.GCPOAuthClientID {
+		return fmt.Errorf("The config file uses the OAuth client ID %s; set --gcp-oauth-client-id to match it", config.GCPOAuthClientID)
+	}
+
+	var userClient *http.Client
+	var urt string
+	if context.IsSet("gcp-user-refresh-token") {
+		userClient = getUserClientFromToken(context)
+		urt = context.String("gcp-user-refresh-token")
+	} else {
+		userClient, urt, err = getUserClientFromUser(context)
+		if err != nil {
+			return err
+		}
+	}
+
+	xmppJID, robotRefreshToken, err := createRobotAccount(context, userClient)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Acquired OAuth credentials for robot account")
+	fmt.Println("")
+
+	// Make sure the new robot account sees this connector's printers before
+	// throwing away the old credentials.
+	g, err := gcp.NewGoogleCloudPrint(config.GCPBaseURL, robotRefreshToken, "",
+		config.ProxyName, config.GCPOAuthClientID, config.GCPOAuthClientSecret,
+		config.GCPOAuthAuthURL, config.GCPOAuthTokenURL, 0, nil, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	printers, err := g.List(background)
+	if err != nil {
+		return fmt.Errorf("Failed to list printers with the new credentials: %s", err)
+	}
+	if err = checkReauthorizedPrinters(len(printers), config.ProxyName, context.Bool("force")); err != nil {
+		return err
+	}
+	fmt.Printf("The new robot account owns the %d printers of proxy %s\n", len(printers), config.ProxyName)
+
+	config.XMPPJID = xmppJID
+	config.RobotRefreshToken = robotRefreshToken
+	if config.ShareScope != "" {
+		// The user token shares new printers, and may have been revoked too.
+		config.UserRefreshToken = urt
+	}
+
+	if _, err = config.Sparse(context).ToFile(context); err != nil {
+		return fmt.Errorf("Failed to write config file: %s", err)
+	}
+	fmt.Printf("The config file %s has the new credentials. Restart the connector to use them.\n", configFilename)
+	recordAudit(config, lib.AuditEventCredentials, "Replaced the robot account credentials of proxy %s in %s with those of %s", config.ProxyName, configFilename, xmppJID)
+	return nil
+}
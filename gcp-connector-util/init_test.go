@@ -0,0 +1,48 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package main
+
+import "testing"
+
+func TestCheckReauthorizedPrinters(t *testing.T) {
+	for _, c := range []struct {
+		printers int
+		force    bool
+		ok       bool
+	}{
+		{0, false, false},
+		{0, true, true},
+		{1, false, true},
+		{12, false, true},
+		{12, true, true},
+	} {
+		err := checkReauthorizedPrinters(c.printers, "proxy", c.force)
+		if (err == nil) != c.ok {
+			t.Logf("%d printers, force %t: expected ok %t, got %v", c.printers, c.force, c.ok, err)
+			t.Fail()
+		}
+	}
+}
+
+func TestReauthorizeFlags(t *testing.T) {
+	var force bool
+	for _, f := range reauthorizeFlags {
+		if f.GetName() == "force" {
+			force = true
+		}
+	}
+	if !force {
+		t.Log("expected reauthorize to have a --force flag")
+		t.Fail()
+	}
+	if len(credentialFlags) >= len(reauthorizeFlags) {
+		t.Log("expected reauthorize to have the credential flags too")
+		t.Fail()
+	}
+}
@@ -41,16 +41,86 @@ var unixInitFlags = []cli.Flag{
 		Usage: "Filename of unix socket for connector-check to talk to connector",
 		Value: lib.DefaultConfig.MonitorSocketFilename,
 	},
+	cli.IntFlag{
+		Name:  "job-ticket-audit-max-records",
+		Usage: "Quantity of job tickets to keep for the monitor's job-tickets request; 0 keeps none",
+		Value: int(lib.DefaultConfig.JobTicketAuditMaxRecords),
+	},
+	cli.StringFlag{
+		Name:  "job-ticket-audit-max-age",
+		Usage: "Longest time to keep job tickets for the monitor's job-tickets request",
+		Value: lib.DefaultConfig.JobTicketAuditMaxAge,
+	},
 	cli.IntFlag{
 		Name:  "cups-max-connections",
 		Usage: "Max connections to CUPS server",
 		Value: int(lib.DefaultConfig.CUPSMaxConnections),
 	},
+	cli.IntFlag{
+		Name:  "cups-min-connections",
+		Usage: "Connections to CUPS server that the pool starts at and shrinks to when idle; 0 fixes it at the max",
+		Value: int(lib.DefaultConfig.CUPSMinConnections),
+	},
 	cli.StringFlag{
 		Name:  "cups-connect-timeout",
 		Usage: "CUPS timeout for opening a new connection",
 		Value: lib.DefaultConfig.CUPSConnectTimeout,
 	},
+	cli.StringFlag{
+		Name:  "cups-get-printers-timeout",
+		Usage: "CUPS timeout for listing printers, once connected",
+		Value: lib.DefaultConfig.CUPSGetPrintersTimeout,
+	},
+	cli.StringFlag{
+		Name:  "cups-get-ppd-timeout",
+		Usage: "CUPS timeout for downloading a PPD, once connected",
+		Value: lib.DefaultConfig.CUPSGetPPDTimeout,
+	},
+	cli.StringFlag{
+		Name:  "cups-ppd-timeout",
+		Usage: "Deadline for fetching and translating one printer's PPD, after which the printer syncs without it",
+		Value: lib.DefaultConfig.CUPSPPDTimeout,
+	},
+	cli.IntFlag{
+		Name:  "cups-ppd-max-bytes",
+		Usage: "Largest PPD, in bytes, to translate; 0 is no limit",
+		Value: int(lib.DefaultConfig.CUPSPPDMaxBytes),
+	},
+	cli.StringFlag{
+		Name:  "cups-print-timeout",
+		Usage: "CUPS timeout for submitting a job, once connected",
+		Value: lib.DefaultConfig.CUPSPrintTimeout,
+	},
+	cli.StringFlag{
+		Name:  "cups-job-state-timeout",
+		Usage: "CUPS timeout for getting the state of a job, once connected",
+		Value: lib.DefaultConfig.CUPSJobStateTimeout,
+	},
+	cli.IntFlag{
+		Name:  "cups-printer-page-size",
+		Usage: "Quantity of printers to request from CUPS at a time; 0 requests all at once",
+		Value: int(lib.DefaultConfig.CUPSPrinterPageSize),
+	},
+	cli.StringFlag{
+		Name:  "cups-job-options-override-dir",
+		Usage: "Directory of <printer name>.json files of options to merge into the printer's next jobs",
+	},
+	cli.StringFlag{
+		Name:  "cups-quirks-file",
+		Usage: "JSON file of quirks that fix the attributes, capabilities and options of printers, by printer-make-and-model regexp",
+	},
+	cli.BoolFlag{
+		Name:  "cups-discovery-enable",
+		Usage: "Whether to find the CUPS server via DNS-SD",
+	},
+	cli.BoolFlag{
+		Name:  "cups-ipp-usb-enable",
+		Usage: "Whether to print directly to IPP-over-USB printers (Linux only)",
+	},
+	cli.BoolFlag{
+		Name:  "cups-raw-direct-submit",
+		Usage: "Whether to send jobs for raw JetDirect and LPD queues straight to the device",
+	},
 	cli.BoolFlag{
 		Name:  "cups-job-full-username",
 		Usage: "Whether to use the full username (joe@example.com) in CUPS jobs",
@@ -90,6 +160,330 @@ var unixCommands = []cli.Command{
 			},
 		},
 	},
+	cli.Command{
+		Name:   "override-options",
+		Usage:  "Merge CUPS options into a printer's next jobs, in a running connector",
+		Action: overrideOptions,
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "printer",
+				Usage: "CUPS printer name",
+			},
+			cli.IntFlag{
+				Name:  "jobs",
+				Usage: "Quantity of jobs to merge the options into; 0 clears the override",
+				Value: 1,
+			},
+			cli.StringSliceFlag{
+				Name:  "option",
+				Usage: "CUPS option like name=value; an empty value removes the option from the jobs",
+			},
+			cli.DurationFlag{
+				Name:  "monitor-timeout",
+				Usage: "wait for a monitor response no more than this long",
+				Value: 10 * time.Second,
+			},
+		},
+	},
+	cli.Command{
+		Name:   "tune",
+		Usage:  "Read or change log level, printer poll interval, concurrency, download bandwidth and GCP request rate of a running connector",
+		Action: tune,
+		Flags: []cli.Flag{
+			cli.StringSliceFlag{
+				Name:  "set",
+				Usage: "Parameter like log-level=DEBUG, printer-poll-interval=5m, gcp-max-concurrent-fetches=2, gcp-max-concurrent-downloads=2, gcp-download-bytes-per-second=125000 or gcp-requests-per-minute=600",
+			},
+			cli.DurationFlag{
+				Name:  "monitor-timeout",
+				Usage: "wait for a monitor response no more than this long",
+				Value: 10 * time.Second,
+			},
+		},
+	},
+	cli.Command{
+		Name:   "faults",
+		Usage:  "Read or inject faults in a running connector built with -tags faultinject, to test recovery from them",
+		Action: faults,
+		Flags: []cli.Flag{
+			cli.StringSliceFlag{
+				Name:  "set",
+				Usage: "Fault like xmpp-drop=100 or cups-submit-fail=25 (percent), or download-delay=30s; zero stops it",
+			},
+			cli.DurationFlag{
+				Name:  "monitor-timeout",
+				Usage: "wait for a monitor response no more than this long",
+				Value: 10 * time.Second,
+			},
+		},
+	},
+	cli.Command{
+		Name:   "job-tickets",
+		Usage:  "Read the tickets and CUPS options of recent jobs from a running connector",
+		Action: jobTickets,
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "job-id",
+				Usage: "GCP job ID; omit to read every recent job",
+			},
+			cli.DurationFlag{
+				Name:  "monitor-timeout",
+				Usage: "wait for a monitor response no more than this long",
+				Value: 10 * time.Second,
+			},
+		},
+	},
+	cli.Command{
+		Name:   "job-errors",
+		Usage:  "Read why recent jobs failed, in detail, from a running connector",
+		Action: jobErrors,
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "job-id",
+				Usage: "GCP job ID; omit to read every recent failure",
+			},
+			cli.DurationFlag{
+				Name:  "monitor-timeout",
+				Usage: "wait for a monitor response no more than this long",
+				Value: 10 * time.Second,
+			},
+		},
+	},
+	cli.Command{
+		Name:   "job-eta",
+		Usage:  "Read when the jobs that a running connector is printing are estimated to complete",
+		Action: jobETAs,
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "job-id",
+				Usage: "GCP job ID; omit to read every job that is printing",
+			},
+			cli.DurationFlag{
+				Name:  "monitor-timeout",
+				Usage: "wait for a monitor response no more than this long",
+				Value: 10 * time.Second,
+			},
+		},
+	},
+	cli.Command{
+		Name:   "cancel-job",
+		Usage:  "Cancel a job that a running connector is printing",
+		Action: cancelJob,
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "job-id",
+				Usage: "GCP or Privet job ID",
+			},
+			cli.DurationFlag{
+				Name:  "monitor-timeout",
+				Usage: "wait for a monitor response no more than this long",
+				Value: 10 * time.Second,
+			},
+		},
+	},
+	cli.Command{
+		Name:   "redirect-job",
+		Usage:  "Print a queued or failed cloud job on another printer, like when its printer died",
+		Action: redirectJob,
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "job-id",
+				Usage: "GCP job ID",
+			},
+			cli.StringFlag{
+				Name:  "printer",
+				Usage: "CUPS queue name to print to",
+			},
+			cli.DurationFlag{
+				Name:  "monitor-timeout",
+				Usage: "wait for a monitor response no more than this long",
+				Value: 10 * time.Second,
+			},
+		},
+	},
+	cli.Command{
+		Name:   "reprint",
+		Usage:  "Print a job again from the cache of a running connector, or list the cached jobs",
+		Action: reprint,
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "job-id",
+				Usage: "GCP or Privet job ID; list the cached jobs when empty",
+			},
+			cli.StringFlag{
+				Name:  "printer",
+				Usage: "CUPS queue name to print to, instead of the job's",
+			},
+			cli.DurationFlag{
+				Name:  "monitor-timeout",
+				Usage: "wait for a monitor response no more than this long",
+				Value: 10 * time.Second,
+			},
+		},
+	},
+	cli.Command{
+		Name:   "capabilities-diff",
+		Usage:  "Compare the capabilities that the cloud has registered for a printer to those a running connector generates now",
+		Action: capabilitiesDiff,
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "printer",
+				Usage: "CUPS queue name",
+			},
+			cli.DurationFlag{
+				Name:  "monitor-timeout",
+				Usage: "wait for a monitor response no more than this long",
+				Value: 30 * time.Second,
+			},
+		},
+	},
+	cli.Command{
+		Name:   "identify-printer",
+		Usage:  "Make a printer beep or flash, through a running connector, to find it among identical printers",
+		Action: identifyPrinter,
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "printer",
+				Usage: "CUPS queue name",
+			},
+			cli.StringFlag{
+				Name:  "actions",
+				Usage: "comma-separated display, flash, sound or speak; empty is the printer's default",
+			},
+			cli.DurationFlag{
+				Name:  "monitor-timeout",
+				Usage: "wait for a monitor response no more than this long",
+				Value: 30 * time.Second,
+			},
+		},
+	},
+	cli.Command{
+		Name:   "print-test-page",
+		Usage:  "Print a test page, with the connector version and a summary of the printer's capabilities, through a running connector",
+		Action: printTestPage,
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "printer",
+				Usage: "CUPS queue name",
+			},
+			cli.DurationFlag{
+				Name:  "monitor-timeout",
+				Usage: "wait for a monitor response no more than this long",
+				Value: 10 * time.Second,
+			},
+		},
+	},
+	cli.Command{
+		Name:   "goroutines",
+		Usage:  "Read the goroutine stacks, by subsystem, of a running connector, to find leaks",
+		Action: goroutines,
+		Flags: []cli.Flag{
+			cli.DurationFlag{
+				Name:  "monitor-timeout",
+				Usage: "wait for a monitor response no more than this long",
+				Value: 10 * time.Second,
+			},
+		},
+	},
+	cli.Command{
+		Name:   "trace",
+		Usage:  "Start or stop tracing the IPP and GCP exchanges of a running connector to a file, or read the trace status",
+		Action: trace,
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "filename",
+				Usage: "New file to write the redacted exchanges to; starts tracing",
+			},
+			cli.StringFlag{
+				Name:  "printer",
+				Usage: "CUPS printer name; omit to trace every printer",
+			},
+			cli.StringFlag{
+				Name:  "job-id",
+				Usage: "GCP or Privet job ID; omit to trace every job",
+			},
+			cli.BoolFlag{
+				Name:  "stop",
+				Usage: "Stop tracing",
+			},
+			cli.DurationFlag{
+				Name:  "monitor-timeout",
+				Usage: "wait for a monitor response no more than this long",
+				Value: 10 * time.Second,
+			},
+		},
+	},
+	cli.Command{
+		Name:   "refresh-printers",
+		Usage:  "Make a running connector's monitor get the CUPS printers again",
+		Action: refreshPrinters,
+		Flags: []cli.Flag{
+			cli.DurationFlag{
+				Name:  "monitor-timeout",
+				Usage: "wait for a monitor response no more than this long",
+				Value: 10 * time.Second,
+			},
+		},
+	},
+	cli.Command{
+		Name:   "quarantine",
+		Usage:  "List the printers that a running connector has quarantined after failed jobs",
+		Action: quarantine,
+		Flags: []cli.Flag{
+			cli.DurationFlag{
+				Name:  "monitor-timeout",
+				Usage: "wait for a monitor response no more than this long",
+				Value: 10 * time.Second,
+			},
+		},
+	},
+	cli.Command{
+		Name:   "clear-quarantine",
+		Usage:  "Release a printer from quarantine, so that a running connector prints its jobs again",
+		Action: clearQuarantine,
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "printer",
+				Usage: "CUPS printer name",
+			},
+			cli.DurationFlag{
+				Name:  "monitor-timeout",
+				Usage: "wait for a monitor response no more than this long",
+				Value: 10 * time.Second,
+			},
+		},
+	},
+	cli.Command{
+		Name:   "supplies",
+		Usage:  "Read the marker levels and days remaining of printers from a running connector",
+		Action: supplies,
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "printer",
+				Usage: "CUPS printer name; omit to read every printer",
+			},
+			cli.DurationFlag{
+				Name:  "monitor-timeout",
+				Usage: "wait for a monitor response no more than this long",
+				Value: 10 * time.Second,
+			},
+		},
+	},
+	cli.Command{
+		Name:   "import-printer-rules",
+		Usage:  "Share printers, and set their color policies and daily quotas, from a CSV file of printer, share, role, color, color_users and quota columns",
+		Action: importPrinterRules,
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "file",
+				Usage: "CSV file to read the printer rules from",
+			},
+			cli.BoolFlag{
+				Name:  "dry-run",
+				Usage: "Check the file, and show what would change, without changing anything",
+			},
+		},
+	},
 }
 
 func main() {
@@ -101,6 +495,7 @@ func main() {
 		lib.ConfigFilenameFlag,
 	}
 	app.Commands = append(unixCommands, commonCommands...)
+	app.Before = migrateConfigFile
 
 	app.Run(os.Args)
 }
@@ -126,6 +521,7 @@ func createCloudConfig(context *cli.Context, xmppJID, robotRefreshToken, userRef
 		GCPOAuthAuthURL:           lib.DefaultConfig.GCPOAuthAuthURL,
 		GCPOAuthTokenURL:          lib.DefaultConfig.GCPOAuthTokenURL,
 		GCPMaxConcurrentDownloads: uint(context.Int("gcp-max-concurrent-downloads")),
+		GCPMaxConcurrentFetches:   uint(context.Int("gcp-max-concurrent-fetches")),
 
 		NativeJobQueueSize:        uint(context.Int("native-job-queue-size")),
 		NativePrinterPollInterval: context.String("native-printer-poll-interval"),
@@ -143,8 +539,23 @@ func createCloudConfig(context *cli.Context, xmppJID, robotRefreshToken, userRef
 		LogMaxFiles:                      uint(context.Int("log-max-files")),
 		LogToJournal:                     lib.PointerToBool(context.Bool("log-to-journal")),
 		MonitorSocketFilename:            context.String("monitor-socket-filename"),
+		JobTicketAuditMaxRecords:         uint(context.Int("job-ticket-audit-max-records")),
+		JobTicketAuditMaxAge:             context.String("job-ticket-audit-max-age"),
 		CUPSMaxConnections:               uint(context.Int("cups-max-connections")),
+		CUPSMinConnections:               uint(context.Int("cups-min-connections")),
 		CUPSConnectTimeout:               context.String("cups-connect-timeout"),
+		CUPSGetPrintersTimeout:           context.String("cups-get-printers-timeout"),
+		CUPSGetPPDTimeout:                context.String("cups-get-ppd-timeout"),
+		CUPSPPDTimeout:                   context.String("cups-ppd-timeout"),
+		CUPSPPDMaxBytes:                  int64(context.Int("cups-ppd-max-bytes")),
+		CUPSPrintTimeout:                 context.String("cups-print-timeout"),
+		CUPSJobStateTimeout:              context.String("cups-job-state-timeout"),
+		CUPSPrinterPageSize:              uint(context.Int("cups-printer-page-size")),
+		CUPSJobOptionsOverrideDir:        context.String("cups-job-options-override-dir"),
+		CUPSQuirksFile:                   context.String("cups-quirks-file"),
+		CUPSDiscoveryEnable:              context.Bool("cups-discovery-enable"),
+		CUPSIPPUSBEnable:                 context.Bool("cups-ipp-usb-enable"),
+		CUPSRawDirectSubmit:              context.Bool("cups-raw-direct-submit"),
 		CUPSPrinterAttributes:            lib.DefaultConfig.CUPSPrinterAttributes,
 		CUPSJobFullUsername:              lib.PointerToBool(context.Bool("cups-job-full-username")),
 		CUPSIgnoreRawPrinters:            lib.PointerToBool(context.Bool("cups-ignore-raw-printers")),
@@ -175,8 +586,23 @@ func createLocalConfig(context *cli.Context) *lib.Config {
 		LogMaxFiles:                      uint(context.Int("log-max-files")),
 		LogToJournal:                     lib.PointerToBool(context.Bool("log-to-journal")),
 		MonitorSocketFilename:            context.String("monitor-socket-filename"),
+		JobTicketAuditMaxRecords:         uint(context.Int("job-ticket-audit-max-records")),
+		JobTicketAuditMaxAge:             context.String("job-ticket-audit-max-age"),
 		CUPSMaxConnections:               uint(context.Int("cups-max-connections")),
+		CUPSMinConnections:               uint(context.Int("cups-min-connections")),
 		CUPSConnectTimeout:               context.String("cups-connect-timeout"),
+		CUPSGetPrintersTimeout:           context.String("cups-get-printers-timeout"),
+		CUPSGetPPDTimeout:                context.String("cups-get-ppd-timeout"),
+		CUPSPPDTimeout:                   context.String("cups-ppd-timeout"),
+		CUPSPPDMaxBytes:                  int64(context.Int("cups-ppd-max-bytes")),
+		CUPSPrintTimeout:                 context.String("cups-print-timeout"),
+		CUPSJobStateTimeout:              context.String("cups-job-state-timeout"),
+		CUPSPrinterPageSize:              uint(context.Int("cups-printer-page-size")),
+		CUPSJobOptionsOverrideDir:        context.String("cups-job-options-override-dir"),
+		CUPSQuirksFile:                   context.String("cups-quirks-file"),
+		CUPSDiscoveryEnable:              context.Bool("cups-discovery-enable"),
+		CUPSIPPUSBEnable:                 context.Bool("cups-ipp-usb-enable"),
+		CUPSRawDirectSubmit:              context.Bool("cups-raw-direct-submit"),
 		CUPSPrinterAttributes:            lib.DefaultConfig.CUPSPrinterAttributes,
 		CUPSJobFullUsername:              lib.PointerToBool(context.Bool("cups-job-full-username")),
 		CUPSIgnoreRawPrinters:            lib.PointerToBool(context.Bool("cups-ignore-raw-printers")),
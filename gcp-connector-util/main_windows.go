@@ -181,6 +181,7 @@ func main() {
 		lib.ConfigFilenameFlag,
 	}
 	app.Commands = append(windowsCommands, commonCommands...)
+	app.Before = migrateConfigFile
 
 	app.Run(os.Args)
 }
@@ -206,6 +207,7 @@ func createCloudConfig(context *cli.Context, xmppJID, robotRefreshToken, userRef
 		GCPOAuthAuthURL:           lib.DefaultConfig.GCPOAuthAuthURL,
 		GCPOAuthTokenURL:          lib.DefaultConfig.GCPOAuthTokenURL,
 		GCPMaxConcurrentDownloads: uint(context.Int("gcp-max-concurrent-downloads")),
+		GCPMaxConcurrentFetches:   uint(context.Int("gcp-max-concurrent-fetches")),
 
 		NativeJobQueueSize:        uint(context.Int("native-job-queue-size")),
 		NativePrinterPollInterval: context.String("native-printer-poll-interval"),
@@ -13,6 +13,8 @@ import (
 	"io/ioutil"
 	"net"
 	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/google/cloud-print-connector/lib"
@@ -20,6 +22,162 @@ import (
 )
 
 func monitorConnector(context *cli.Context) error {
+	return monitorRequest(context, "")
+}
+
+func jobTickets(context *cli.Context) error {
+	return monitorRequest(context, strings.TrimSpace("job-tickets "+context.String("job-id")))
+}
+
+func jobErrors(context *cli.Context) error {
+	return monitorRequest(context, strings.TrimSpace("job-errors "+context.String("job-id")))
+}
+
+func jobETAs(context *cli.Context) error {
+	return monitorRequest(context, strings.TrimSpace("job-eta "+context.String("job-id")))
+}
+
+func cancelJob(context *cli.Context) error {
+	if context.String("job-id") == "" {
+		return fmt.Errorf("--job-id is required")
+	}
+	return monitorRequest(context, "cancel-job "+context.String("job-id"))
+}
+
+func redirectJob(context *cli.Context) error {
+	if context.String("job-id") == "" || context.String("printer") == "" {
+		return fmt.Errorf("--job-id and --printer are required")
+	}
+	return monitorRequest(context, "redirect-job "+context.String("job-id")+" "+context.String("printer"))
+}
+
+func reprint(context *cli.Context) error {
+	switch {
+	case context.String("job-id") == "":
+		return monitorRequest(context, "reprint")
+	case context.String("printer") == "":
+		return monitorRequest(context, "reprint "+context.String("job-id"))
+	}
+	return monitorRequest(context, "reprint "+context.String("job-id")+" "+context.String("printer"))
+}
+
+func capabilitiesDiff(context *cli.Context) error {
+	if context.String("printer") == "" {
+		return fmt.Errorf("--printer is required")
+	}
+	return monitorRequest(context, "capabilities-diff "+context.String("printer"))
+}
+
+func identifyPrinter(context *cli.Context) error {
+	if context.String("printer") == "" {
+		return fmt.Errorf("--printer is required")
+	}
+	request := "identify-printer " + context.String("printer")
+	if context.String("actions") != "" {
+		if strings.ContainsAny(context.String("actions"), " \t\n") {
+			return fmt.Errorf("--actions can't contain whitespace")
+		}
+		request += " " + context.String("actions")
+	}
+	return monitorRequest(context, request)
+}
+
+func printTestPage(context *cli.Context) error {
+	if context.String("printer") == "" {
+		return fmt.Errorf("--printer is required")
+	}
+	return monitorRequest(context, "test-page "+context.String("printer"))
+}
+
+func goroutines(context *cli.Context) error {
+	return monitorRequest(context, "goroutines")
+}
+
+func trace(context *cli.Context) error {
+	switch {
+	case context.Bool("stop"):
+		return monitorRequest(context, "trace stop")
+	case context.String("filename") == "":
+		return monitorRequest(context, "trace")
+	}
+
+	filename, err := filepath.Abs(context.String("filename"))
+	if err != nil {
+		return err
+	}
+	for _, value := range []string{filename, context.String("printer"), context.String("job-id")} {
+		if strings.ContainsAny(value, " \t\n") {
+			return fmt.Errorf("%q can't contain whitespace", value)
+		}
+	}
+	request := "trace start " + filename
+	if context.String("printer") != "" {
+		request += " printer=" + context.String("printer")
+	}
+	if context.String("job-id") != "" {
+		request += " job=" + context.String("job-id")
+	}
+	return monitorRequest(context, request)
+}
+
+func refreshPrinters(context *cli.Context) error {
+	return monitorRequest(context, "refresh-printers")
+}
+
+func supplies(context *cli.Context) error {
+	return monitorRequest(context, strings.TrimSpace("supplies "+context.String("printer")))
+}
+
+func quarantine(context *cli.Context) error {
+	return monitorRequest(context, "quarantine")
+}
+
+func clearQuarantine(context *cli.Context) error {
+	if context.String("printer") == "" {
+		return fmt.Errorf("--printer is required")
+	}
+	return monitorRequest(context, "clear-quarantine "+context.String("printer"))
+}
+
+func overrideOptions(context *cli.Context) error {
+	if context.String("printer") == "" {
+		return fmt.Errorf("--printer is required")
+	}
+	request := fmt.Sprintf("override-options %s %d", context.String("printer"), context.Int("jobs"))
+	for _, option := range context.StringSlice("option") {
+		if strings.ContainsAny(option, " \t\n") {
+			return fmt.Errorf("Option %q can't contain whitespace", option)
+		}
+		request += " " + option
+	}
+	return monitorRequest(context, request)
+}
+
+func tune(context *cli.Context) error {
+	request := "tune"
+	for _, parameter := range context.StringSlice("set") {
+		if strings.ContainsAny(parameter, " \t\n") {
+			return fmt.Errorf("Parameter %q can't contain whitespace", parameter)
+		}
+		request += " " + parameter
+	}
+	return monitorRequest(context, request)
+}
+
+func faults(context *cli.Context) error {
+	request := "faults"
+	for _, fault := range context.StringSlice("set") {
+		if strings.ContainsAny(fault, " \t\n") {
+			return fmt.Errorf("Fault %q can't contain whitespace", fault)
+		}
+		request += " " + fault
+	}
+	return monitorRequest(context, request)
+}
+
+// monitorRequest sends request to a running connector's monitor socket, and
+// prints the response. The empty request gets the stats.
+func monitorRequest(context *cli.Context, request string) error {
 	config, filename, err := lib.GetConfig(context)
 	if err != nil {
 		return fmt.Errorf("Failed to read config file: %s", err)
@@ -50,6 +208,16 @@ func monitorConnector(context *cli.Context) error {
 	}
 	defer conn.Close()
 
+	if request != "" {
+		if _, err = fmt.Fprintln(conn, request); err != nil {
+			return err
+		}
+	}
+	// Closing our side tells the connector the request is complete.
+	if err = conn.(*net.UnixConn).CloseWrite(); err != nil {
+		return err
+	}
+
 	buf, err := ioutil.ReadAll(conn)
 	if err != nil {
 		return err
@@ -57,6 +225,6 @@ func monitorConnector(context *cli.Context) error {
 
 	timer.Stop()
 
-	fmt.Printf(string(buf))
+	fmt.Print(string(buf))
 	return nil
 }
@@ -14,13 +14,17 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net/http"
+	_ "net/http/pprof"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/coreos/go-systemd/journal"
 	"github.com/google/cloud-print-connector/cups"
+	"github.com/google/cloud-print-connector/fakeprinter"
 	"github.com/google/cloud-print-connector/gcp"
 	"github.com/google/cloud-print-connector/lib"
 	"github.com/google/cloud-print-connector/log"
@@ -85,9 +89,17 @@ func connector(context *cli.Context) error {
 
 	if configFilename == "" {
 		log.Info("No config file was found, so using defaults")
+	} else if strings.HasSuffix(configFilename, lib.PreviousStateSuffix) {
+		log.Warningf("The config file is corrupt, so using its previous version %s", configFilename)
 	} else {
 		log.Infof("Using config file %s", configFilename)
 	}
+	if _, backup, report, err := lib.MigrateConfigFile(context); err != nil {
+		log.Warningf("Failed to migrate config file to schema version %d: %s", lib.ConfigSchemaVersion, err)
+	} else if len(report) > 0 {
+		log.Infof("Migrated config file to schema version %d, and saved the old file as %s: %s",
+			lib.ConfigSchemaVersion, backup, strings.Join(report, "; "))
+	}
 	completeConfig, _ := json.MarshalIndent(config, "", " ")
 	log.Debugf("Config: %s", string(completeConfig))
 
@@ -100,6 +112,17 @@ func connector(context *cli.Context) error {
 		return errors.New(errStr)
 	}
 
+	if err := lib.SetAddressFamily(config.AddressFamily); err != nil {
+		log.Fatal(err)
+		return err
+	}
+	lib.SetUserAgentSite(config.UserAgentSite)
+	if err := lib.SetTLSPolicy(config.TLSProfile, config.TLSMinVersion, config.TLSMaxVersion, config.TLSCipherSuites, config.TLSCurves); err != nil {
+		log.Fatal(err)
+		return err
+	}
+	log.Info(lib.TLSPolicyReport())
+
 	if _, err := os.Stat(config.MonitorSocketFilename); !os.IsNotExist(err) {
 		var errStr string
 		if err != nil {
@@ -113,8 +136,48 @@ func connector(context *cli.Context) error {
 		return errors.New(errStr)
 	}
 
+	if config.PprofAddress != "" {
+		go func() {
+			// net/http/pprof registers its handlers with the default mux.
+			http.HandleFunc("/debug/goroutines", lib.ServeGoroutineStacks)
+			log.Infof("Serving pprof profiles and goroutine stacks on %s", config.PprofAddress)
+			listener, err := lib.ListenAdmin(config.PprofAddress, config.PprofTLSCertFile, config.PprofTLSKeyFile, config.PprofTLSClientCAFile)
+			if err == nil {
+				err = http.Serve(listener, nil)
+			}
+			if err != nil {
+				log.Errorf("Failed to serve pprof profiles: %s", err)
+			}
+		}()
+	}
+
 	jobs := make(chan *lib.Job, 10)
+	jobLimiter := lib.NewJobLimiter(
+		lib.JobLimits{MaxBytes: config.MaxJobBytes, MaxPages: config.MaxJobPages, MaxSeconds: config.MaxJobSeconds}, config.PrinterJobLimits)
 	xmppNotifications := make(chan xmpp.PrinterNotification, 5)
+	jobErrorMessages, err := lib.NewJobErrorMessages(config.JobErrorMessagesFile)
+	if err != nil {
+		log.Fatal(err)
+		return err
+	}
+	jobErrors := lib.NewJobErrorLog(jobErrorMessages)
+	auditLog, err := lib.NewAuditLog(config.AuditLogFile, config.AuditLogKeyFile, "gcp-cups-connector")
+	if err != nil {
+		log.Fatal(err)
+		return err
+	}
+	// The head, in the connector's log, shows later whether the newest
+	// entries were removed, or the audit log was rewritten.
+	if head, err := auditLog.Head(); err != nil {
+		log.Errorf("Failed to read the head of the audit log: %s", err)
+	} else if head.Hash != "" {
+		log.Infof("Audit log %s head is entry %d, hash %s", config.AuditLogFile, head.Sequence, head.Hash)
+	}
+	supportURLs, err := lib.NewSupportURLs(config.PrinterSupportURL, config.PrinterSupportURLs)
+	if err != nil {
+		log.Fatal(err)
+		return err
+	}
 
 	var g *gcp.GoogleCloudPrint
 	var x *xmpp.XMPP
@@ -135,11 +198,22 @@ func connector(context *cli.Context) error {
 		g, err = gcp.NewGoogleCloudPrint(config.GCPBaseURL, config.RobotRefreshToken,
 			config.UserRefreshToken, config.ProxyName, config.GCPOAuthClientID,
 			config.GCPOAuthClientSecret, config.GCPOAuthAuthURL, config.GCPOAuthTokenURL,
-			config.GCPMaxConcurrentDownloads, jobs)
+			config.GCPMaxConcurrentDownloads, jobs, jobLimiter, jobErrors, auditLog)
 		if err != nil {
 			log.Fatal(err)
 			return err
 		}
+		g.SetDownloadRate(config.DownloadBytesPerSecond)
+		g.SetRequestsPerMinute(config.GCPRequestsPerMinute)
+		if config.GCPPrinterCacheTTL != "" {
+			printerCacheTTL, err := time.ParseDuration(config.GCPPrinterCacheTTL)
+			if err != nil {
+				errStr := fmt.Sprintf("Failed to parse GCP printer cache TTL: %s", err)
+				log.Fatal(errStr)
+				return errors.New(errStr)
+			}
+			g.SetPrinterCacheTTL(printerCacheTTL)
+		}
 
 		x, err = xmpp.NewXMPP(config.XMPPJID, config.ProxyName, config.XMPPServer, config.XMPPPort,
 			xmppPingTimeout, xmppPingInterval, g.GetRobotAccessToken, xmppNotifications)
@@ -156,10 +230,51 @@ func connector(context *cli.Context) error {
 		log.Fatalf(errStr)
 		return errors.New(errStr)
 	}
+	requestTimeouts, err := parseRequestTimeouts(config)
+	if err != nil {
+		log.Fatal(err)
+		return err
+	}
+	jobTicketAuditMaxAge, err := time.ParseDuration(config.JobTicketAuditMaxAge)
+	if err != nil {
+		errStr := fmt.Sprintf("Failed to parse job ticket audit max age: %s", err)
+		log.Fatal(errStr)
+		return errors.New(errStr)
+	}
+	audit := lib.NewJobTicketAudit(config.JobTicketAuditMaxRecords, jobTicketAuditMaxAge)
+
+	throttle, err := lib.NewLoadThrottle(config.BackgroundLoadThreshold)
+	if err != nil {
+		log.Fatal(err)
+		return err
+	}
+
+	groups, err := newGroupResolver(config)
+	if err != nil {
+		log.Fatal(err)
+		return err
+	}
+
+	lib.SetFeatureFlags(config.FeatureFlags())
+	if config.CUPSDiscoveryEnable {
+		if _, err = cups.DiscoverServer(config.CUPSDiscoveryRules); err != nil {
+			log.Fatal(err)
+			return err
+		}
+	}
 	c, err := cups.NewCUPS(*config.CUPSCopyPrinterInfoToDisplayName, *config.PrefixJobIDToJobTitle,
-		config.DisplayNamePrefix, config.CUPSPrinterAttributes, config.CUPSVendorPPDOptions, config.CUPSMaxConnections,
-		cupsConnectTimeout, config.PrinterBlacklist, config.PrinterWhitelist, *config.CUPSIgnoreRawPrinters,
-		*config.CUPSIgnoreClassPrinters)
+		config.DisplayNamePrefix, config.CUPSPrinterAttributes, config.CUPSVendorPPDOptions, config.CUPSPPDMaxBytes, config.CUPSMinConnections, config.CUPSMaxConnections,
+		cupsConnectTimeout, config.CUPSServers, config.PrinterBlacklist, config.PrinterWhitelist, *config.CUPSIgnoreRawPrinters,
+		*config.CUPSIgnoreClassPrinters, config.CUPSPrinterPageSize, audit,
+		config.CUPSJobOptionsOverrideDir, config.CUPSJobPriorityUsers, config.CUPSAutoRotatePrinters,
+		config.CUPSFitToPageBrokenDrivers, config.CUPSNormalizeMixedOrientation,
+		config.CUPSCoverSheetPrinters, config.CUPSSeparatorSheetPrinters, config.CUPSJobSheetOptions,
+		config.CUPSPaperSizeSubstitution, config.CUPSStockedPaperSizes,
+		config.CUPSFetchDocumentFormats, config.CUPSSubmitDocumentFormats, config.CUPSQuirksFile,
+		config.CUPSColorPolicyFile, config.CUPSStateReasonMessagesFile,
+		requestTimeouts, config.CUPSDirectPrinters, config.CUPSDirectPrinterTLS, config.CUPSDirectTOFUFile,
+		config.CUPSIPPUSBEnable, config.CUPSRawDirectSubmit, config.CUPSRawPJLStatusPrinters, config.CUPSDeviceInfoEnable, config.CUPSSNMPCommunity,
+		throttle, groups)
 	if err != nil {
 		log.Fatal(err)
 		return err
@@ -169,9 +284,9 @@ func connector(context *cli.Context) error {
 	var priv *privet.Privet
 	if config.LocalPrintingEnable {
 		if g == nil {
-			priv, err = privet.NewPrivet(jobs, config.LocalPortLow, config.LocalPortHigh, config.GCPBaseURL, nil)
+			priv, err = privet.NewPrivet(jobs, config.LocalPortLow, config.LocalPortHigh, config.GCPBaseURL, nil, jobLimiter)
 		} else {
-			priv, err = privet.NewPrivet(jobs, config.LocalPortLow, config.LocalPortHigh, config.GCPBaseURL, g.ProximityToken)
+			priv, err = privet.NewPrivet(jobs, config.LocalPortLow, config.LocalPortHigh, config.GCPBaseURL, g.ProximityToken, jobLimiter)
 		}
 		if err != nil {
 			log.Fatal(err)
@@ -180,22 +295,97 @@ func connector(context *cli.Context) error {
 		defer priv.Quit()
 	}
 
+	var native manager.NativePrintSystem = c
+	if config.SimulatedPrinters > 0 {
+		native, err = fakeprinter.NewFakePrinters(c, config.SimulatedPrinters, config.SimulatedPrinterCapabilities,
+			config.SimulatedPrinterPagesPerMinute, config.SimulatedPrinterFailureRate)
+		if err != nil {
+			log.Fatal(err)
+			return err
+		}
+		log.Infof("Simulating %d printers", config.SimulatedPrinters)
+	}
+
 	nativePrinterPollInterval, err := time.ParseDuration(config.NativePrinterPollInterval)
 	if err != nil {
 		errStr := fmt.Sprintf("Failed to parse CUPS printer poll interval: %s", err)
 		log.Fatal(errStr)
 		return errors.New(errStr)
 	}
-	pm, err := manager.NewPrinterManager(c, g, priv, nativePrinterPollInterval,
+	var duplicates *lib.DuplicateJobDetector
+	if config.DuplicateJobWindow != "" {
+		duplicateJobWindow, err := time.ParseDuration(config.DuplicateJobWindow)
+		if err != nil {
+			errStr := fmt.Sprintf("Failed to parse duplicate job window: %s", err)
+			log.Fatal(errStr)
+			return errors.New(errStr)
+		}
+		duplicates = lib.NewDuplicateJobDetector(duplicateJobWindow)
+	}
+	instanceID := lib.InstanceID(config.InstanceID)
+	var coordinator manager.InstanceCoordinator
+	if config.InstanceLeaseFile != "" && config.FailoverRole != "" {
+		errStr := "Configure an instance lease file or a failover role, not both"
+		log.Fatal(errStr)
+		return errors.New(errStr)
+	} else if config.InstanceLeaseFile != "" {
+		coordinator = lib.NewInstanceLease(config.InstanceLeaseFile, instanceID, lib.InstanceLeaseDuration)
+	} else if config.FailoverRole != "" {
+		var failoverTimeout time.Duration
+		if config.FailoverTimeout != "" {
+			failoverTimeout, err = time.ParseDuration(config.FailoverTimeout)
+			if err != nil {
+				errStr := fmt.Sprintf("Failed to parse failover timeout: %s", err)
+				log.Fatal(errStr)
+				return errors.New(errStr)
+			}
+		}
+		coordinator, err = lib.NewInstanceFailover(instanceID, config.FailoverRole, config.FailoverListenAddress, config.FailoverPeerURL, failoverTimeout)
+		if err != nil {
+			log.Fatal(err)
+			return err
+		}
+	}
+	shard, err := lib.NewPrinterShard(config.ShardCount, config.ShardIndex, config.ShardPrinters)
+	if err != nil {
+		log.Fatal(err)
+		return err
+	}
+	if shard != nil {
+		log.Infof("Managing %s", shard)
+	}
+	var updateCheckInterval time.Duration
+	if config.UpdateCheckInterval != "" {
+		updateCheckInterval, err = time.ParseDuration(config.UpdateCheckInterval)
+		if err != nil {
+			errStr := fmt.Sprintf("Failed to parse update check interval: %s", err)
+			log.Fatal(errStr)
+			return errors.New(errStr)
+		}
+	}
+	updates := lib.NewUpdateChecker(config.UpdateFeedURL, updateCheckInterval, config.UpdateStagingFilename)
+	var quarantineProbeInterval time.Duration
+	if config.QuarantineProbeInterval != "" {
+		quarantineProbeInterval, err = time.ParseDuration(config.QuarantineProbeInterval)
+		if err != nil {
+			errStr := fmt.Sprintf("Failed to parse quarantine probe interval: %s", err)
+			log.Fatal(errStr)
+			return errors.New(errStr)
+		}
+	}
+	quarantine := lib.NewPrinterQuarantine(config.QuarantineAfterFailures, quarantineProbeInterval, config.QuarantineWebhookURL)
+	pm, err := manager.NewPrinterManager(native, g, priv, nativePrinterPollInterval,
 		config.NativeJobQueueSize, *config.CUPSJobFullUsername, config.ShareScope,
-		jobs, xmppNotifications)
+		config.GCPMaxConcurrentFetches, jobs, xmppNotifications, duplicates, lib.NewJobCache(config.JobCacheBytes),
+		lib.NewSupplyAlerts(config.SupplyAlertThresholds, config.PrinterSupplyAlertThresholds, config.SupplyAlertWebhookURL),
+		quarantine, jobErrors, supportURLs, instanceID, coordinator, shard, config.VendorStateMaxItems, updates)
 	if err != nil {
 		log.Fatal(err)
 		return err
 	}
 	defer pm.Quit()
 
-	m, err := monitor.NewMonitor(c, g, priv, pm, config.MonitorSocketFilename)
+	m, err := monitor.NewMonitor(c, g, priv, pm, auditLog, config.MonitorSocketFilename, config.MonitorReadOnlySocketFilename)
 	if err != nil {
 		log.Fatal(err)
 		return err
@@ -237,3 +427,51 @@ func waitIndefinitely() {
 		os.Exit(1)
 	}()
 }
+
+// parseRequestTimeouts parses the timeout of each kind of CUPS request.
+func parseRequestTimeouts(config *lib.Config) (cups.RequestTimeouts, error) {
+	var requestTimeouts cups.RequestTimeouts
+	for _, t := range []struct {
+		name  string
+		value string
+		d     *time.Duration
+	}{
+		{"get printers", config.CUPSGetPrintersTimeout, &requestTimeouts.GetPrinters},
+		{"get PPD", config.CUPSGetPPDTimeout, &requestTimeouts.GetPPD},
+		{"PPD", config.CUPSPPDTimeout, &requestTimeouts.PPD},
+		{"print", config.CUPSPrintTimeout, &requestTimeouts.PrintFile},
+		{"job state", config.CUPSJobStateTimeout, &requestTimeouts.GetJobAttributes},
+	} {
+		var err error
+		if *t.d, err = time.ParseDuration(t.value); err != nil {
+			return cups.RequestTimeouts{}, fmt.Errorf("Failed to parse CUPS %s timeout: %s", t.name, err)
+		}
+	}
+	return requestTimeouts, nil
+}
+
+// newGroupResolver resolves the groups that CUPS policies name, from the
+// Google Directory and LDAP, when either is configured.
+func newGroupResolver(config *lib.Config) (*lib.GroupResolver, error) {
+	var ttl time.Duration
+	if config.CUPSGroupCacheTTL != "" {
+		var err error
+		if ttl, err = time.ParseDuration(config.CUPSGroupCacheTTL); err != nil {
+			return nil, fmt.Errorf("Failed to parse group cache TTL: %s", err)
+		}
+	}
+
+	var google, ldap lib.GroupSource
+	directory, err := gcp.NewDirectoryGroups(config.GCPOAuthClientID, config.GCPOAuthClientSecret,
+		config.GCPOAuthAuthURL, config.GCPOAuthTokenURL, config.CUPSGroupDirectoryRefreshToken)
+	if err != nil {
+		return nil, err
+	}
+	if directory != nil {
+		google = directory
+	}
+	if l := lib.NewLDAPGroups(config.CUPSGroupLDAPURL, config.CUPSGroupLDAPBindDN, config.CUPSGroupLDAPPasswordFile); l != nil {
+		ldap = l
+	}
+	return lib.NewGroupResolver(google, ldap, ttl, config.CUPSGroupUserDomains), nil
+}
@@ -0,0 +1,55 @@
+// Copyright 2015 Google Inc. All rights reserved.
+
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+// +build linux darwin freebsd
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/cloud-print-connector/cups"
+	"github.com/google/cloud-print-connector/lib"
+)
+
+func TestParseRequestTimeouts(t *testing.T) {
+	config := lib.DefaultConfig
+	timeouts, err := parseRequestTimeouts(&config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := cups.RequestTimeouts{
+		GetPrinters:      time.Minute,
+		GetPPD:           2 * time.Minute,
+		PrintFile:        5 * time.Minute,
+		GetJobAttributes: 15 * time.Second,
+		PPD:              time.Minute,
+	}
+	if timeouts != expected {
+		t.Logf("expected %+v, got %+v", expected, timeouts)
+		t.Fail()
+	}
+
+	// Zero is no limit.
+	config.CUPSJobStateTimeout = "0s"
+	if timeouts, err = parseRequestTimeouts(&config); err != nil || timeouts.GetJobAttributes != 0 {
+		t.Logf("expected no job state timeout, got %s, %v", timeouts.GetJobAttributes, err)
+		t.Fail()
+	}
+
+	config.CUPSGetPPDTimeout = "2 minutes"
+	if _, err = parseRequestTimeouts(&config); err == nil {
+		t.Log("expected an error for an invalid get PPD timeout")
+		t.Fail()
+	}
+	config = lib.DefaultConfig
+	config.CUPSPrintTimeout = ""
+	if _, err = parseRequestTimeouts(&config); err == nil {
+		t.Log("expected an error for an empty print timeout")
+		t.Fail()
+	}
+}
@@ -12,9 +12,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/urfave/cli"
+	"github.com/google/cloud-print-connector/fakeprinter"
 	"github.com/google/cloud-print-connector/gcp"
 	"github.com/google/cloud-print-connector/lib"
 	"github.com/google/cloud-print-connector/log"
@@ -97,9 +99,17 @@ func (service *service) Execute(args []string, r <-chan svc.ChangeRequest, s cha
 
 	if configFilename == "" {
 		log.Info("No config file was found, so using defaults")
+	} else if strings.HasSuffix(configFilename, lib.PreviousStateSuffix) {
+		log.Warningf("The config file is corrupt, so using its previous version %s", configFilename)
 	} else {
 		log.Infof("Using config file %s", configFilename)
 	}
+	if _, backup, report, err := lib.MigrateConfigFile(service.context); err != nil {
+		log.Warningf("Failed to migrate config file to schema version %d: %s", lib.ConfigSchemaVersion, err)
+	} else if len(report) > 0 {
+		log.Infof("Migrated config file to schema version %d, and saved the old file as %s: %s",
+			lib.ConfigSchemaVersion, backup, strings.Join(report, "; "))
+	}
 	completeConfig, _ := json.MarshalIndent(config, "", " ")
 	log.Debugf("Config: %s", string(completeConfig))
 
@@ -113,8 +123,44 @@ func (service *service) Execute(args []string, r <-chan svc.ChangeRequest, s cha
 		return false, 1
 	}
 
+	if err := lib.SetAddressFamily(config.AddressFamily); err != nil {
+		log.Fatal(err)
+		return false, 1
+	}
+	lib.SetUserAgentSite(config.UserAgentSite)
+	if err := lib.SetTLSPolicy(config.TLSProfile, config.TLSMinVersion, config.TLSMaxVersion, config.TLSCipherSuites, config.TLSCurves); err != nil {
+		log.Fatal(err)
+		return false, 1
+	}
+	log.Info(lib.TLSPolicyReport())
+
 	jobs := make(chan *lib.Job, 10)
+	jobLimiter := lib.NewJobLimiter(
+		lib.JobLimits{MaxBytes: config.MaxJobBytes, MaxPages: config.MaxJobPages, MaxSeconds: config.MaxJobSeconds}, config.PrinterJobLimits)
 	xmppNotifications := make(chan xmpp.PrinterNotification, 5)
+	jobErrorMessages, err := lib.NewJobErrorMessages(config.JobErrorMessagesFile)
+	if err != nil {
+		log.Fatal(err)
+		return false, 1
+	}
+	jobErrors := lib.NewJobErrorLog(jobErrorMessages)
+	auditLog, err := lib.NewAuditLog(config.AuditLogFile, config.AuditLogKeyFile, "gcp-windows-connector")
+	if err != nil {
+		log.Fatal(err)
+		return false, 1
+	}
+	// The head, in the connector's log, shows later whether the newest
+	// entries were removed, or the audit log was rewritten.
+	if head, err := auditLog.Head(); err != nil {
+		log.Errorf("Failed to read the head of the audit log: %s", err)
+	} else if head.Hash != "" {
+		log.Infof("Audit log %s head is entry %d, hash %s", config.AuditLogFile, head.Sequence, head.Hash)
+	}
+	supportURLs, err := lib.NewSupportURLs(config.PrinterSupportURL, config.PrinterSupportURLs)
+	if err != nil {
+		log.Fatal(err)
+		return false, 1
+	}
 
 	var g *gcp.GoogleCloudPrint
 	var x *xmpp.XMPP
@@ -133,11 +179,21 @@ func (service *service) Execute(args []string, r <-chan svc.ChangeRequest, s cha
 		g, err = gcp.NewGoogleCloudPrint(config.GCPBaseURL, config.RobotRefreshToken,
 			config.UserRefreshToken, config.ProxyName, config.GCPOAuthClientID,
 			config.GCPOAuthClientSecret, config.GCPOAuthAuthURL, config.GCPOAuthTokenURL,
-			config.GCPMaxConcurrentDownloads, jobs)
+			config.GCPMaxConcurrentDownloads, jobs, jobLimiter, jobErrors, auditLog)
 		if err != nil {
 			log.Fatal(err)
 			return false, 1
 		}
+		g.SetDownloadRate(config.DownloadBytesPerSecond)
+		g.SetRequestsPerMinute(config.GCPRequestsPerMinute)
+		if config.GCPPrinterCacheTTL != "" {
+			printerCacheTTL, err := time.ParseDuration(config.GCPPrinterCacheTTL)
+			if err != nil {
+				log.Fatalf("Failed to parse GCP printer cache TTL: %s", err)
+				return false, 1
+			}
+			g.SetPrinterCacheTTL(printerCacheTTL)
+		}
 
 		x, err = xmpp.NewXMPP(config.XMPPJID, config.ProxyName, config.XMPPServer, config.XMPPPort,
 			xmppPingTimeout, xmppPingInterval, g.GetRobotAccessToken, xmppNotifications)
@@ -148,19 +204,91 @@ func (service *service) Execute(args []string, r <-chan svc.ChangeRequest, s cha
 		defer x.Quit()
 	}
 
+	lib.SetFeatureFlags(config.FeatureFlags())
 	ws, err := winspool.NewWinSpool(*config.PrefixJobIDToJobTitle, config.DisplayNamePrefix, config.PrinterBlacklist, config.PrinterWhitelist)
 	if err != nil {
 		log.Fatal(err)
 		return false, 1
 	}
 
+	var native manager.NativePrintSystem = ws
+	if config.SimulatedPrinters > 0 {
+		native, err = fakeprinter.NewFakePrinters(ws, config.SimulatedPrinters, config.SimulatedPrinterCapabilities,
+			config.SimulatedPrinterPagesPerMinute, config.SimulatedPrinterFailureRate)
+		if err != nil {
+			log.Fatal(err)
+			return false, 1
+		}
+		log.Infof("Simulating %d printers", config.SimulatedPrinters)
+	}
+
 	nativePrinterPollInterval, err := time.ParseDuration(config.NativePrinterPollInterval)
 	if err != nil {
 		log.Fatalf("Failed to parse printer poll interval: %s", err)
 		return false, 1
 	}
-	pm, err := manager.NewPrinterManager(ws, g, nil, nativePrinterPollInterval,
-		config.NativeJobQueueSize, *config.CUPSJobFullUsername, config.ShareScope, jobs, xmppNotifications)
+	var duplicates *lib.DuplicateJobDetector
+	if config.DuplicateJobWindow != "" {
+		duplicateJobWindow, err := time.ParseDuration(config.DuplicateJobWindow)
+		if err != nil {
+			log.Fatalf("Failed to parse duplicate job window: %s", err)
+			return false, 1
+		}
+		duplicates = lib.NewDuplicateJobDetector(duplicateJobWindow)
+	}
+	instanceID := lib.InstanceID(config.InstanceID)
+	var coordinator manager.InstanceCoordinator
+	if config.InstanceLeaseFile != "" && config.FailoverRole != "" {
+		log.Fatal("Configure an instance lease file or a failover role, not both")
+		return false, 1
+	} else if config.InstanceLeaseFile != "" {
+		coordinator = lib.NewInstanceLease(config.InstanceLeaseFile, instanceID, lib.InstanceLeaseDuration)
+	} else if config.FailoverRole != "" {
+		var failoverTimeout time.Duration
+		if config.FailoverTimeout != "" {
+			failoverTimeout, err = time.ParseDuration(config.FailoverTimeout)
+			if err != nil {
+				log.Fatalf("Failed to parse failover timeout: %s", err)
+				return false, 1
+			}
+		}
+		coordinator, err = lib.NewInstanceFailover(instanceID, config.FailoverRole, config.FailoverListenAddress, config.FailoverPeerURL, failoverTimeout)
+		if err != nil {
+			log.Fatal(err)
+			return false, 1
+		}
+	}
+	shard, err := lib.NewPrinterShard(config.ShardCount, config.ShardIndex, config.ShardPrinters)
+	if err != nil {
+		log.Fatal(err)
+		return false, 1
+	}
+	if shard != nil {
+		log.Infof("Managing %s", shard)
+	}
+	var updateCheckInterval time.Duration
+	if config.UpdateCheckInterval != "" {
+		updateCheckInterval, err = time.ParseDuration(config.UpdateCheckInterval)
+		if err != nil {
+			log.Fatalf("Failed to parse update check interval: %s", err)
+			return false, 1
+		}
+	}
+	updates := lib.NewUpdateChecker(config.UpdateFeedURL, updateCheckInterval, config.UpdateStagingFilename)
+	var quarantineProbeInterval time.Duration
+	if config.QuarantineProbeInterval != "" {
+		quarantineProbeInterval, err = time.ParseDuration(config.QuarantineProbeInterval)
+		if err != nil {
+			log.Fatalf("Failed to parse quarantine probe interval: %s", err)
+			return false, 1
+		}
+	}
+	quarantine := lib.NewPrinterQuarantine(config.QuarantineAfterFailures, quarantineProbeInterval, config.QuarantineWebhookURL)
+	pm, err := manager.NewPrinterManager(native, g, nil, nativePrinterPollInterval,
+		config.NativeJobQueueSize, *config.CUPSJobFullUsername, config.ShareScope,
+		config.GCPMaxConcurrentFetches, jobs, xmppNotifications, duplicates, lib.NewJobCache(config.JobCacheBytes),
+		lib.NewSupplyAlerts(config.SupplyAlertThresholds, config.PrinterSupplyAlertThresholds, config.SupplyAlertWebhookURL),
+		quarantine, jobErrors, supportURLs, instanceID, coordinator, shard, config.VendorStateMaxItems, updates)
 	if err != nil {
 		log.Fatal(err)
 		return false, 1
@@ -0,0 +1,163 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package gcp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+)
+
+// interaction is one HTTP request to GCP, and its response. Request
+// headers, like Authorization, aren't kept.
+type interaction struct {
+	Method      string      `json:"method"`
+	URL         string      `json:"url"`
+	RequestBody string      `json:"request_body,omitempty"`
+	Status      int         `json:"status"`
+	Header      http.Header `json:"header,omitempty"`
+	Body        string      `json:"body"`
+}
+
+// cassette is a recording of the interactions with GCP of a GoogleCloudPrint
+// with a base URL and proxy name, in order.
+type cassette struct {
+	BaseURL      string        `json:"base_url"`
+	ProxyName    string        `json:"proxy_name"`
+	Interactions []interaction `json:"interactions"`
+}
+
+func loadCassette(filename string) (*cassette, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var c cassette
+	if err = json.Unmarshal(b, &c); err != nil {
+		return nil, fmt.Errorf("Failed to read cassette %s: %s", filename, err)
+	}
+	return &c, nil
+}
+
+func (c *cassette) save(filename string) error {
+	b, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, append(b, '\n'), 0600)
+}
+
+// cassetteTransport records the interactions of a base transport to a
+// cassette or, without a base transport, replays the interactions of a
+// cassette, in the order they were recorded, without a network.
+type cassetteTransport struct {
+	base     http.RoundTripper
+	mutex    sync.Mutex
+	cassette *cassette
+	// next is the interaction to replay next.
+	next int
+}
+
+func newRecordingTransport(base http.RoundTripper, baseURL, proxyName string) *cassetteTransport {
+	return &cassetteTransport{
+		base:     base,
+		cassette: &cassette{BaseURL: baseURL, ProxyName: proxyName, Interactions: []interaction{}},
+	}
+}
+
+func newReplayingTransport(c *cassette) *cassetteTransport {
+	return &cassetteTransport{cassette: c}
+}
+
+func (t *cassetteTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	var requestBody []byte
+	if request.Body != nil {
+		var err error
+		requestBody, err = ioutil.ReadAll(request.Body)
+		request.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		request.Body = ioutil.NopCloser(bytes.NewReader(requestBody))
+	}
+
+	if t.base == nil {
+		return t.replay(request, string(requestBody))
+	}
+
+	response, err := t.base.RoundTrip(request)
+	if err != nil {
+		return nil, err
+	}
+	body, err := ioutil.ReadAll(response.Body)
+	response.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	response.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.cassette.Interactions = append(t.cassette.Interactions, interaction{
+		Method:      request.Method,
+		URL:         request.URL.String(),
+		RequestBody: string(requestBody),
+		Status:      response.StatusCode,
+		Header:      response.Header,
+		Body:        string(body),
+	})
+	return response, nil
+}
+
+// replay responds to a request like the next interaction, when it is the
+// same request.
+func (t *cassetteTransport) replay(request *http.Request, requestBody string) (*http.Response, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.next >= len(t.cassette.Interactions) {
+		return nil, fmt.Errorf("Cassette has no interaction for %s %s", request.Method, request.URL)
+	}
+	i := t.cassette.Interactions[t.next]
+	if i.Method != request.Method || i.URL != request.URL.String() || i.RequestBody != requestBody {
+		return nil, fmt.Errorf("Cassette interaction %d is %s %s %q, not %s %s %q",
+			t.next, i.Method, i.URL, i.RequestBody, request.Method, request.URL, requestBody)
+	}
+	t.next++
+
+	header := i.Header
+	if header == nil {
+		header = http.Header{}
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", i.Status, http.StatusText(i.Status)),
+		StatusCode:    i.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader([]byte(i.Body))),
+		ContentLength: int64(len(i.Body)),
+		Request:       request,
+	}, nil
+}
+
+// unplayed is how many interactions of the cassette haven't been replayed.
+func (t *cassetteTransport) unplayed() int {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.base != nil {
+		return 0
+	}
+	return len(t.cassette.Interactions) - t.next
+}
@@ -0,0 +1,89 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package gcp
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/cloud-print-connector/log"
+)
+
+const (
+	// clockSkewWarning is how far the local clock may be from GCP's before
+	// it is worth a warning. The Date header only has seconds.
+	clockSkewWarning = time.Minute
+)
+
+// clockSkew measures how far GCP's clock is ahead of the local clock, from
+// the Date header of GCP's responses. Devices without a real-time clock,
+// like a Raspberry Pi, may boot with a clock that is way off, then jump
+// when NTP catches up.
+type clockSkew struct {
+	skew   time.Duration
+	warned bool
+	mutex  sync.Mutex
+}
+
+// observe measures the skew from one response.
+func (c *clockSkew) observe(response *http.Response) {
+	date, err := http.ParseTime(response.Header.Get("Date"))
+	if err != nil {
+		return
+	}
+	skew := date.Sub(time.Now())
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.skew = skew
+	if abs(skew) >= clockSkewWarning {
+		if !c.warned {
+			log.Warningf("The local clock is %s off from Google Cloud Print's; fix it, for example with NTP", abs(skew))
+			c.warned = true
+		}
+	} else if c.warned {
+		log.Info("The local clock now agrees with Google Cloud Print's")
+		c.warned = false
+	}
+}
+
+// get returns the last skew measured.
+func (c *clockSkew) get() time.Duration {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.skew
+}
+
+// now returns GCP's idea of the time.
+func (c *clockSkew) now() time.Time {
+	return time.Now().Add(c.get())
+}
+
+func abs(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+// skewTransport measures clock skew from every response.
+type skewTransport struct {
+	base http.RoundTripper
+	skew *clockSkew
+}
+
+func (t *skewTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	response, err := t.base.RoundTrip(request)
+	if err == nil {
+		t.skew.observe(response)
+	}
+	return response, err
+}
@@ -0,0 +1,85 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package gcp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// dateResponse is a response from a server whose clock is skew ahead of
+// the local clock.
+func dateResponse(skew time.Duration) *http.Response {
+	response := &http.Response{Header: make(http.Header)}
+	response.Header.Set("Date", time.Now().Add(skew).UTC().Format(http.TimeFormat))
+	return response
+}
+
+// nearSkew is whether a measured skew is expected, give or take the
+// second of precision of the Date header.
+func nearSkew(measured, expected time.Duration) bool {
+	return abs(measured-expected) <= 2*time.Second
+}
+
+func TestClockSkewObserve(t *testing.T) {
+	var c clockSkew
+
+	c.observe(dateResponse(time.Hour))
+	if !nearSkew(c.get(), time.Hour) || !c.warned {
+		t.Logf("expected a warning about an hour of skew, got %s, warned %t", c.get(), c.warned)
+		t.Fail()
+	}
+	if now := c.now(); !nearSkew(now.Sub(time.Now()), time.Hour) {
+		t.Logf("expected GCP's time to be an hour ahead, got %s", now)
+		t.Fail()
+	}
+
+	// Responses without a valid Date don't change the skew.
+	c.observe(&http.Response{Header: make(http.Header)})
+	response := &http.Response{Header: make(http.Header)}
+	response.Header.Set("Date", "yesterday")
+	c.observe(response)
+	if !nearSkew(c.get(), time.Hour) || !c.warned {
+		t.Logf("expected the skew to stay an hour, got %s, warned %t", c.get(), c.warned)
+		t.Fail()
+	}
+
+	c.observe(dateResponse(0))
+	if !nearSkew(c.get(), 0) || c.warned {
+		t.Logf("expected no skew and no warning after the clock was fixed, got %s, warned %t", c.get(), c.warned)
+		t.Fail()
+	}
+
+	c.observe(dateResponse(-10 * time.Minute))
+	if !nearSkew(c.get(), -10*time.Minute) || !c.warned {
+		t.Logf("expected a warning about a clock 10 minutes ahead, got %s, warned %t", c.get(), c.warned)
+		t.Fail()
+	}
+}
+
+func TestSkewTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Date", time.Now().Add(-5*time.Minute).UTC().Format(http.TimeFormat))
+	}))
+	defer server.Close()
+
+	var c clockSkew
+	client := &http.Client{Transport: &skewTransport{base: http.DefaultTransport, skew: &c}}
+	response, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if !nearSkew(c.get(), -5*time.Minute) {
+		t.Logf("expected 5 minutes of skew behind, got %s", c.get())
+		t.Fail()
+	}
+}
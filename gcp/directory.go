@@ -0,0 +1,88 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package gcp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+const (
+	// ScopeDirectoryGroupMembers reads the members of Google groups.
+	ScopeDirectoryGroupMembers = "https://www.googleapis.com/auth/admin.directory.group.member.readonly"
+
+	directoryBaseURL = "https://admin.googleapis.com/admin/directory/v1/"
+)
+
+// DirectoryGroups gets the members of Google groups, by their email
+// addresses, from the Directory API.
+type DirectoryGroups struct {
+	baseURL string
+	client  *http.Client
+}
+
+// NewDirectoryGroups reads the Directory API with the credentials of a
+// Google Workspace administrator, whose refresh token grants
+// ScopeDirectoryGroupMembers to the connector's OAuth client. Returns nil
+// when refreshToken is empty.
+func NewDirectoryGroups(oauthClientID, oauthClientSecret, oauthAuthURL, oauthTokenURL, refreshToken string) (*DirectoryGroups, error) {
+	if refreshToken == "" {
+		return nil, nil
+	}
+	client, err := newClient(&clockSkew{}, newRequestQuota(), oauthClientID, oauthClientSecret, oauthAuthURL, oauthTokenURL, refreshToken, ScopeDirectoryGroupMembers)
+	if err != nil {
+		return nil, err
+	}
+	return &DirectoryGroups{directoryBaseURL, client}, nil
+}
+
+// GroupMembers gets the email addresses of the members of a group,
+// including the members of the groups in it.
+func (d *DirectoryGroups) GroupMembers(ctx context.Context, group string) ([]string, error) {
+	var members []string
+	var pageToken string
+	for {
+		query := url.Values{}
+		query.Set("includeDerivedMembership", "true")
+		query.Set("maxResults", "200")
+		if pageToken != "" {
+			query.Set("pageToken", pageToken)
+		}
+		response, err := get(ctx, d.client, d.baseURL+"groups/"+url.PathEscape(group)+"/members?"+query.Encode(), "")
+		if err != nil {
+			return nil, err
+		}
+
+		var page struct {
+			Members []struct {
+				Email string `json:"email"`
+				Type  string `json:"type"`
+			} `json:"members"`
+			NextPageToken string `json:"nextPageToken"`
+		}
+		err = json.NewDecoder(response.Body).Decode(&page)
+		response.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse the members of group %s: %s", group, err)
+		}
+
+		for _, m := range page.Members {
+			if m.Type == "USER" && m.Email != "" {
+				members = append(members, m.Email)
+			}
+		}
+		if page.NextPageToken == "" {
+			return members, nil
+		}
+		pageToken = page.NextPageToken
+	}
+}
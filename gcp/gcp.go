@@ -11,6 +11,7 @@ package gcp
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -19,9 +20,11 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"runtime/debug"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"golang.org/x/oauth2"
@@ -42,6 +45,11 @@ const (
 	ScopeCloudPrint = "https://www.googleapis.com/auth/cloudprint"
 	ScopeGoogleTalk = "https://www.googleapis.com/auth/googletalk"
 	AccessType      = "offline"
+
+	// Download progress is reported to users every downloadProgressStep
+	// percent, but no more often than every downloadProgressInterval.
+	downloadProgressStep     = 10
+	downloadProgressInterval = 2 * time.Second
 )
 
 // GoogleCloudPrint is the interface between Go and the Google Cloud Print API.
@@ -53,18 +61,35 @@ type GoogleCloudPrint struct {
 
 	jobs              chan<- *lib.Job
 	downloadSemaphore *lib.Semaphore
+	jobLimiter        *lib.JobLimiter
+	// jobErrors tells users why their jobs failed, and keeps the details.
+	jobErrors *lib.JobErrorLog
+	// audit records sharing and quota changes.
+	audit *lib.AuditLog
+
+	// downloadLimiter limits the bandwidth of every download together;
+	// printerDownloadLimiters limit those of each printer, by name.
+	downloadLimiter         *lib.RateLimiter
+	printerDownloadLimiters map[string]*lib.RateLimiter
+	printerDownloadMutex    sync.Mutex
+	downloadMeter           lib.ThroughputMeter
+
+	printerCache *printerCache
+	quota        *requestQuota
 }
 
 // NewGoogleCloudPrint establishes a connection with GCP, returns a new GoogleCloudPrint object.
-func NewGoogleCloudPrint(baseURL, robotRefreshToken, userRefreshToken, proxyName, oauthClientID, oauthClientSecret, oauthAuthURL, oauthTokenURL string, maxConcurrentDownload uint, jobs chan<- *lib.Job) (*GoogleCloudPrint, error) {
-	robotClient, err := newClient(oauthClientID, oauthClientSecret, oauthAuthURL, oauthTokenURL, robotRefreshToken, ScopeCloudPrint, ScopeGoogleTalk)
+func NewGoogleCloudPrint(baseURL, robotRefreshToken, userRefreshToken, proxyName, oauthClientID, oauthClientSecret, oauthAuthURL, oauthTokenURL string, maxConcurrentDownload uint, jobs chan<- *lib.Job, jobLimiter *lib.JobLimiter, jobErrors *lib.JobErrorLog, audit *lib.AuditLog) (*GoogleCloudPrint, error) {
+	skew := &clockSkew{}
+	quota := newRequestQuota()
+	robotClient, err := newClient(skew, quota, oauthClientID, oauthClientSecret, oauthAuthURL, oauthTokenURL, robotRefreshToken, ScopeCloudPrint, ScopeGoogleTalk)
 	if err != nil {
 		return nil, err
 	}
 
 	var userClient *http.Client
 	if userRefreshToken != "" {
-		userClient, err = newClient(oauthClientID, oauthClientSecret, oauthAuthURL, oauthTokenURL, userRefreshToken, ScopeCloudPrint)
+		userClient, err = newClient(skew, quota, oauthClientID, oauthClientSecret, oauthAuthURL, oauthTokenURL, userRefreshToken, ScopeCloudPrint)
 		if err != nil {
 			return nil, err
 		}
@@ -77,27 +102,139 @@ func NewGoogleCloudPrint(baseURL, robotRefreshToken, userRefreshToken, proxyName
 		proxyName:         proxyName,
 		jobs:              jobs,
 		downloadSemaphore: lib.NewSemaphore(maxConcurrentDownload),
+		jobLimiter:        jobLimiter,
+		jobErrors:         jobErrors,
+		audit:             audit,
+
+		downloadLimiter:         lib.NewRateLimiter(0),
+		printerDownloadLimiters: make(map[string]*lib.RateLimiter),
+		printerCache:            newPrinterCache(),
+		quota:                   quota,
 	}
 
 	return gcp, nil
 }
 
 func (gcp *GoogleCloudPrint) GetRobotAccessToken() (string, error) {
-	token, err := gcp.robotClient.Transport.(*oauth2.Transport).Source.Token()
+	t, ok := gcp.robotClient.Transport.(*oauth2.Transport)
+	if !ok {
+		return "", errors.New("The robot client has no OAuth credentials")
+	}
+	token, err := t.Source.Token()
 	if err != nil {
 		return "", err
 	}
 	return token.AccessToken, nil
 }
 
+// ReauthorizationRequired is true when a refresh token has been revoked, so
+// that the connector must be authorized again before it can work.
+func (gcp *GoogleCloudPrint) ReauthorizationRequired() bool {
+	for _, client := range []*http.Client{gcp.robotClient, gcp.userClient} {
+		if client == nil {
+			continue
+		}
+		t, ok := client.Transport.(*oauth2.Transport)
+		if !ok {
+			continue
+		}
+		if ts, ok := t.Source.(*tokenSource); ok && ts.ReauthorizationRequired() {
+			return true
+		}
+	}
+	return false
+}
+
 // CanShare answers the question "can we share printers when they are registered?"
 func (gcp *GoogleCloudPrint) CanShare() bool {
 	return gcp.userClient != nil
 }
 
+// MaxConcurrentDownloads gets the most job files that download at once.
+func (gcp *GoogleCloudPrint) MaxConcurrentDownloads() uint {
+	return gcp.downloadSemaphore.Size()
+}
+
+// SetMaxConcurrentDownloads changes the most job files that download at
+// once. Downloads in progress finish.
+func (gcp *GoogleCloudPrint) SetMaxConcurrentDownloads(max uint) {
+	gcp.downloadSemaphore.SetSize(max)
+}
+
+// DownloadRate gets the most bytes per second that all job files download
+// at together; zero is no limit.
+func (gcp *GoogleCloudPrint) DownloadRate() int64 {
+	return gcp.downloadLimiter.Rate()
+}
+
+// SetDownloadRate changes the most bytes per second that all job files
+// download at together; zero is no limit.
+func (gcp *GoogleCloudPrint) SetDownloadRate(bytesPerSecond int64) {
+	gcp.downloadLimiter.SetRate(bytesPerSecond)
+}
+
+// DownloadThroughput gets the bytes per second that job files downloaded
+// at in the last ten seconds, and the bytes downloaded since start.
+func (gcp *GoogleCloudPrint) DownloadThroughput() (int64, int64) {
+	return gcp.downloadMeter.Throughput()
+}
+
+// SetPrinterCacheTTL changes how long the capabilities and sharing of a
+// printer, as GCP answered, are reused before GCP is asked again; zero asks
+// every time.
+func (gcp *GoogleCloudPrint) SetPrinterCacheTTL(ttl time.Duration) {
+	gcp.printerCache.setTTL(ttl)
+}
+
+// PrinterCacheStats gets how many reads of printers were answered by the
+// cache, and how many by GCP.
+func (gcp *GoogleCloudPrint) PrinterCacheStats() (uint64, uint64) {
+	return gcp.printerCache.stats()
+}
+
+// RequestsPerMinute gets the most requests per minute to GCP; zero is no
+// limit.
+func (gcp *GoogleCloudPrint) RequestsPerMinute() uint {
+	perMinute, _, _ := gcp.quota.stats()
+	return perMinute
+}
+
+// SetRequestsPerMinute changes the most requests per minute to GCP, as its
+// quota allows; zero is no limit. Near the limit, the calls that jobs need
+// go before printer updates.
+func (gcp *GoogleCloudPrint) SetRequestsPerMinute(perMinute uint) {
+	gcp.quota.setPerMinute(perMinute)
+}
+
+// RequestQuotaStats gets the requests that remain this minute, and how many
+// times requests have waited for the next minute.
+func (gcp *GoogleCloudPrint) RequestQuotaStats() (uint, uint64) {
+	_, remaining, deferred := gcp.quota.stats()
+	return remaining, deferred
+}
+
+// printerDownloadLimiter gets the limiter of a printer's downloads, or nil
+// when its job limits don't limit them.
+func (gcp *GoogleCloudPrint) printerDownloadLimiter(printerName string) *lib.RateLimiter {
+	rate := gcp.jobLimiter.Limits(printerName).MaxDownloadBytesPerSecond
+	if rate <= 0 {
+		return nil
+	}
+
+	gcp.printerDownloadMutex.Lock()
+	defer gcp.printerDownloadMutex.Unlock()
+
+	l, exists := gcp.printerDownloadLimiters[printerName]
+	if !exists {
+		l = lib.NewRateLimiter(rate)
+		gcp.printerDownloadLimiters[printerName] = l
+	}
+	return l
+}
+
 // Control calls google.com/cloudprint/control to set the state of a
 // GCP print job.
-func (gcp *GoogleCloudPrint) Control(jobID string, state *cdd.PrintJobStateDiff) error {
+func (gcp *GoogleCloudPrint) Control(ctx context.Context, jobID string, state *cdd.PrintJobStateDiff, userMessage string) error {
 	semanticState, err := json.Marshal(state)
 	if err != nil {
 		return err
@@ -106,20 +243,33 @@ func (gcp *GoogleCloudPrint) Control(jobID string, state *cdd.PrintJobStateDiff)
 	form := url.Values{}
 	form.Set("jobid", jobID)
 	form.Set("semantic_state_diff", string(semanticState))
+	if userMessage != "" {
+		form.Set("message", userMessage)
+	}
 
-	if _, _, _, err := postWithRetry(gcp.robotClient, gcp.baseURL+"control", form); err != nil {
+	if _, _, _, err := postWithRetry(ctx, gcp.robotClient, gcp.baseURL+"control", form); err != nil {
 		return err
 	}
 
 	return nil
 }
 
+// reportProgress tells the user of an IN_PROGRESS job how far it has got.
+// Failures are only logged, since the job carries on regardless.
+func (gcp *GoogleCloudPrint) reportProgress(ctx context.Context, jobID, message string) {
+	state := cdd.PrintJobStateDiff{State: &cdd.JobState{Type: cdd.JobStateInProgress}}
+	if err := gcp.Control(ctx, jobID, &state, message); err != nil {
+		log.WarningJobf(jobID, "Failed to report progress: %s", err)
+	}
+}
+
 // Delete calls google.com/cloudprint/delete to delete a printer from GCP.
-func (gcp *GoogleCloudPrint) Delete(gcpID string) error {
+func (gcp *GoogleCloudPrint) Delete(ctx context.Context, gcpID string) error {
 	form := url.Values{}
 	form.Set("printerid", gcpID)
 
-	if _, _, _, err := postWithRetry(gcp.robotClient, gcp.baseURL+"delete", form); err != nil {
+	defer gcp.printerCache.invalidate(gcpID)
+	if _, _, _, err := postWithRetry(ctx, gcp.robotClient, gcp.baseURL+"delete", form); err != nil {
 		return err
 	}
 
@@ -127,11 +277,11 @@ func (gcp *GoogleCloudPrint) Delete(gcpID string) error {
 }
 
 // DeleteJob calls google.com/cloudprint/deletejob to delete a print job.
-func (gcp *GoogleCloudPrint) DeleteJob(gcpJobID string) error {
+func (gcp *GoogleCloudPrint) DeleteJob(ctx context.Context, gcpJobID string) error {
 	form := url.Values{}
 	form.Set("jobid", gcpJobID)
 
-	if _, _, _, err := postWithRetry(gcp.robotClient, gcp.baseURL+"deletejob", form); err != nil {
+	if _, _, _, err := postWithRetry(ctx, gcp.robotClient, gcp.baseURL+"deletejob", form); err != nil {
 		return err
 	}
 
@@ -140,11 +290,11 @@ func (gcp *GoogleCloudPrint) DeleteJob(gcpJobID string) error {
 
 // Fetch calls google.com/cloudprint/fetch to get the outstanding print jobs for
 // a GCP printer.
-func (gcp *GoogleCloudPrint) Fetch(gcpID string) ([]Job, error) {
+func (gcp *GoogleCloudPrint) Fetch(ctx context.Context, gcpID string) ([]Job, error) {
 	form := url.Values{}
 	form.Set("printerid", gcpID)
 
-	responseBody, errorCode, _, err := postWithRetry(gcp.robotClient, gcp.baseURL+"fetch", form)
+	responseBody, errorCode, _, err := postWithRetry(ctx, gcp.robotClient, gcp.baseURL+"fetch", form)
 	if err != nil {
 		if errorCode == 413 {
 			log.Debugf("No jobs returned by fetch (413 error)")
@@ -184,11 +334,11 @@ func (gcp *GoogleCloudPrint) Fetch(gcpID string) ([]Job, error) {
 }
 
 // Jobs calls google.com/cloudprint/jobs to get print jobs for a GCP printer.
-func (gcp *GoogleCloudPrint) Jobs(gcpID string) ([]Job, error) {
+func (gcp *GoogleCloudPrint) Jobs(ctx context.Context, gcpID string) ([]Job, error) {
 	form := url.Values{}
 	form.Set("printerid", gcpID)
 
-	responseBody, _, _, err := postWithRetry(gcp.robotClient, gcp.baseURL+"jobs", form)
+	responseBody, _, _, err := postWithRetry(ctx, gcp.robotClient, gcp.baseURL+"jobs", form)
 	if err != nil {
 		return nil, err
 	}
@@ -197,6 +347,7 @@ func (gcp *GoogleCloudPrint) Jobs(gcpID string) ([]Job, error) {
 		Jobs []struct {
 			ID            string
 			Title         string
+			FileURL       string
 			OwnerID       string
 			SemanticState *cdd.PrintJobState
 		}
@@ -211,6 +362,7 @@ func (gcp *GoogleCloudPrint) Jobs(gcpID string) ([]Job, error) {
 		jobs[i] = Job{
 			GCPPrinterID:  gcpID,
 			GCPJobID:      jobData.ID,
+			FileURL:       jobData.FileURL,
 			OwnerID:       jobData.OwnerID,
 			Title:         jobData.Title,
 			SemanticState: jobData.SemanticState,
@@ -225,12 +377,12 @@ func (gcp *GoogleCloudPrint) Jobs(gcpID string) ([]Job, error) {
 //
 // Returns map of GCPID => printer name. GCPID is unique to GCP; printer name
 // should be unique to CUPS. Use Printer to get details about each printer.
-func (gcp *GoogleCloudPrint) List() (map[string]string, error) {
+func (gcp *GoogleCloudPrint) List(ctx context.Context) (map[string]string, error) {
 	form := url.Values{}
 	form.Set("proxy", gcp.proxyName)
 	form.Set("extra_fields", "-tags")
 
-	responseBody, _, _, err := postWithRetry(gcp.robotClient, gcp.baseURL+"list", form)
+	responseBody, _, _, err := postWithRetry(ctx, gcp.robotClient, gcp.baseURL+"list", form)
 	if err != nil {
 		return nil, err
 	}
@@ -256,8 +408,8 @@ func (gcp *GoogleCloudPrint) List() (map[string]string, error) {
 // Register calls google.com/cloudprint/register to register a GCP printer.
 //
 // Sets the GCPID field in the printer arg.
-func (gcp *GoogleCloudPrint) Register(printer *lib.Printer) error {
-	capabilities, err := marshalCapabilities(printer.Description)
+func (gcp *GoogleCloudPrint) Register(ctx context.Context, printer *lib.Printer) error {
+	capabilities, err := marshalCapabilities(printer.Name, printer.Description)
 	if err != nil {
 		return err
 	}
@@ -293,7 +445,7 @@ func (gcp *GoogleCloudPrint) Register(printer *lib.Printer) error {
 		form.Add("tag", fmt.Sprintf("%s%s=%s", gcpTagPrefix, key, printer.Tags[key]))
 	}
 
-	responseBody, _, _, err := postWithRetry(gcp.robotClient, gcp.baseURL+"register", form)
+	responseBody, _, _, err := postWithRetry(ctx, gcp.robotClient, gcp.baseURL+"register", form)
 	if err != nil {
 		return err
 	}
@@ -313,7 +465,7 @@ func (gcp *GoogleCloudPrint) Register(printer *lib.Printer) error {
 }
 
 // Update calls google.com/cloudprint/update to update a GCP printer.
-func (gcp *GoogleCloudPrint) Update(diff *lib.PrinterDiff) error {
+func (gcp *GoogleCloudPrint) Update(ctx context.Context, diff *lib.PrinterDiff) error {
 	// Ignores Name field because it never changes.
 
 	form := url.Values{}
@@ -354,7 +506,7 @@ func (gcp *GoogleCloudPrint) Update(diff *lib.PrinterDiff) error {
 	}
 
 	if diff.CapsHashChanged || diff.DescriptionChanged || diff.GCPVersionChanged {
-		capabilities, err := marshalCapabilities(diff.Printer.Description)
+		capabilities, err := marshalCapabilities(diff.Printer.Name, diff.Printer.Description)
 		if err != nil {
 			return err
 		}
@@ -385,23 +537,55 @@ func (gcp *GoogleCloudPrint) Update(diff *lib.PrinterDiff) error {
 		form.Set("daily_quota", strconv.Itoa(diff.Printer.DailyQuota))
 	}
 
-	if _, _, _, err := postWithRetry(gcp.robotClient, gcp.baseURL+"update", form); err != nil {
+	// Forget the printer after the update, so that a read during it isn't
+	// kept.
+	defer gcp.printerCache.invalidate(diff.Printer.GCPID)
+	if _, _, _, err := postWithRetry(ctx, gcp.robotClient, gcp.baseURL+"update", form); err != nil {
 		return err
 	}
 
+	if diff.QuotaEnabledChanged {
+		gcp.recordAudit(lib.AuditEventPolicy, "Set quota_enabled of printer %s to %t", diff.Printer.GCPID, diff.Printer.QuotaEnabled)
+	}
+	if diff.DailyQuotaChanged {
+		gcp.recordAudit(lib.AuditEventPolicy, "Set the daily quota of printer %s to %d pages", diff.Printer.GCPID, diff.Printer.DailyQuota)
+	}
+
 	return nil
 }
 
 // Printer gets the printer identified by it's GCPID.
 //
-// The second return value is queued print job quantity.
-func (gcp *GoogleCloudPrint) Printer(gcpID string) (*lib.Printer, uint, error) {
+// The second return value is queued print job quantity, which may be as old
+// as the printer cache TTL.
+func (gcp *GoogleCloudPrint) Printer(ctx context.Context, gcpID string) (*lib.Printer, uint, error) {
+	return gcp.printer(ctx, gcpID, false)
+}
+
+// printerResponse calls google.com/cloudprint/printer to get a printer, or
+// gets its cached response, unless fresh.
+func (gcp *GoogleCloudPrint) printerResponse(ctx context.Context, gcpID string, fresh bool) ([]byte, error) {
+	if !fresh {
+		if responseBody, ok := gcp.printerCache.get(gcpID); ok {
+			return responseBody, nil
+		}
+	}
+
 	form := url.Values{}
 	form.Set("printerid", gcpID)
 	form.Set("use_cdd", "true")
 	form.Set("extra_fields", "queuedJobsCount,semanticState")
 
-	responseBody, _, _, err := postWithRetry(gcp.robotClient, gcp.baseURL+"printer", form)
+	responseBody, _, _, err := postWithRetry(ctx, gcp.robotClient, gcp.baseURL+"printer", form)
+	if err != nil {
+		return nil, err
+	}
+	gcp.printerCache.put(gcpID, responseBody)
+	return responseBody, nil
+}
+
+func (gcp *GoogleCloudPrint) printer(ctx context.Context, gcpID string, fresh bool) (*lib.Printer, uint, error) {
+	responseBody, err := gcp.printerResponse(ctx, gcpID, fresh)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -464,11 +648,24 @@ func (gcp *GoogleCloudPrint) Printer(gcpID string) (*lib.Printer, uint, error) {
 	return printer, p.QueuedJobsCount, err
 }
 
-func marshalCapabilities(description *cdd.PrinterDescriptionSection) (string, error) {
+// marshalCapabilities marshals the description of printerName, without its
+// invalid capabilities, which are logged.
+func marshalCapabilities(printerName string, description *cdd.PrinterDescriptionSection) (string, error) {
+	// Catch bad translations here, where the error can say which field is
+	// wrong; GCP only replies with a 400, for the whole printer.
+	if description != nil {
+		var errs cdd.ValidationErrors
+		if description, errs = description.DropInvalid(); errs != nil {
+			log.WarningPrinterf(printerName, "Dropped invalid capabilities of translated CDD: %s", errs)
+		}
+	}
 	capabilities := cdd.CloudDeviceDescription{
 		Version: "1.0",
 		Printer: description,
 	}
+	if err := capabilities.Validate(); err != nil {
+		return "", fmt.Errorf("Translated CDD is invalid: %s", err)
+	}
 
 	cdd, err := json.Marshal(capabilities)
 	if err != nil {
@@ -487,8 +684,39 @@ const (
 	Owner   Role = "OWNER"
 )
 
+// Access is a user, group or domain that a printer is shared with.
+type Access struct {
+	// Scope is an email address or a domain.
+	Scope string `json:"scope"`
+	Role  Role   `json:"role"`
+	// Type is USER, GROUP or DOMAIN.
+	Type string `json:"type,omitempty"`
+}
+
+// PrinterAccess gets the users, groups and domains that a printer is shared
+// with, including its owner.
+func (gcp *GoogleCloudPrint) PrinterAccess(ctx context.Context, gcpID string) ([]Access, error) {
+	responseBody, err := gcp.printerResponse(ctx, gcpID, false)
+	if err != nil {
+		return nil, err
+	}
+
+	var printersData struct {
+		Printers []struct {
+			Access []Access `json:"access"`
+		}
+	}
+	if err = json.Unmarshal(responseBody, &printersData); err != nil {
+		return nil, err
+	}
+	if len(printersData.Printers) == 0 {
+		return nil, fmt.Errorf("Printer %s was not found", gcpID)
+	}
+	return printersData.Printers[0].Access, nil
+}
+
 // Share calls google.com/cloudprint/share to share a registered GCP printer.
-func (gcp *GoogleCloudPrint) Share(gcpID, shareScope string, role Role, skip_notification bool, public bool) error {
+func (gcp *GoogleCloudPrint) Share(ctx context.Context, gcpID, shareScope string, role Role, skip_notification bool, public bool) error {
 	if gcp.userClient == nil {
 		return errors.New("Cannot share because user OAuth credentials not provided.")
 	}
@@ -502,15 +730,21 @@ func (gcp *GoogleCloudPrint) Share(gcpID, shareScope string, role Role, skip_not
 		form.Set("role", string(role))
 		form.Set("scope", shareScope)
 	}
-	if _, _, _, err := postWithRetry(gcp.userClient, gcp.baseURL+"share", form); err != nil {
+	defer gcp.printerCache.invalidate(gcpID)
+	if _, _, _, err := postWithRetry(ctx, gcp.userClient, gcp.baseURL+"share", form); err != nil {
 		return err
 	}
 
+	if public {
+		gcp.recordAudit(lib.AuditEventShare, "Shared printer %s publicly", gcpID)
+	} else {
+		gcp.recordAudit(lib.AuditEventShare, "Shared printer %s with %s as %s", gcpID, shareScope, role)
+	}
 	return nil
 }
 
 // Unshare calls google.com/cloudprint/unshare to unshare a registered GCP printer.
-func (gcp *GoogleCloudPrint) Unshare(gcpID, shareScope string, public bool) error {
+func (gcp *GoogleCloudPrint) Unshare(ctx context.Context, gcpID, shareScope string, public bool) error {
 	if gcp.userClient == nil {
 		return errors.New("Cannot unshare because user OAuth credentials not provided.")
 	}
@@ -523,36 +757,78 @@ func (gcp *GoogleCloudPrint) Unshare(gcpID, shareScope string, public bool) erro
 		form.Set("scope", shareScope)
 	}
 
-	if _, _, _, err := postWithRetry(gcp.userClient, gcp.baseURL+"unshare", form); err != nil {
+	defer gcp.printerCache.invalidate(gcpID)
+	if _, _, _, err := postWithRetry(ctx, gcp.userClient, gcp.baseURL+"unshare", form); err != nil {
 		return err
 	}
 
+	if public {
+		gcp.recordAudit(lib.AuditEventShare, "Unshared printer %s publicly", gcpID)
+	} else {
+		gcp.recordAudit(lib.AuditEventShare, "Unshared printer %s from %s", gcpID, shareScope)
+	}
 	return nil
 }
 
+// recordAudit records an event in the audit log, and logs when it can't.
+func (gcp *GoogleCloudPrint) recordAudit(event lib.AuditEvent, format string, args ...interface{}) {
+	if err := gcp.audit.Record(event, fmt.Sprintf(format, args...)); err != nil {
+		log.Errorf("Failed to record %s event in the audit log: %s", event, err)
+	}
+}
+
 // Download downloads a URL (a print job data file) directly to a Writer.
-func (gcp *GoogleCloudPrint) Download(dst io.Writer, url string) error {
-	response, err := getWithRetry(gcp.robotClient, url)
+// When accept isn't empty, GCP converts the file to that format.
+//
+// Downloads larger than maxBytes, by Content-Length or by the bytes read,
+// stop early with a lib.JobTooLargeError. Zero maxBytes is no limit.
+//
+// When progress isn't nil, and the download has a Content-Length, progress
+// is called in the background with the percent downloaded, every
+// downloadProgressStep percent, but no more often than every
+// downloadProgressInterval; percents passed while progress is still
+// reporting an earlier one are dropped, except for the newest. Download
+// returns once progress has returned.
+func (gcp *GoogleCloudPrint) Download(ctx context.Context, dst io.Writer, url, accept string, maxBytes int64, progress func(percent int)) error {
+	response, err := getWithRetry(ctx, gcp.robotClient, url, accept)
 	if err != nil {
 		return err
 	}
 	defer response.Body.Close()
 
-	_, err = io.Copy(dst, response.Body)
+	if progress != nil && response.ContentLength > 0 {
+		pw := lib.NewProgressWriter(dst, response.ContentLength, downloadProgressStep, downloadProgressInterval, progress)
+		defer pw.Close()
+		dst = pw
+	}
+
+	if maxBytes <= 0 {
+		_, err = io.Copy(dst, response.Body)
+		return err
+	}
+
+	if response.ContentLength > maxBytes {
+		return &lib.JobTooLargeError{Reason: fmt.Sprintf(
+			"Download is %d bytes, more than the limit %d", response.ContentLength, maxBytes)}
+	}
+	n, err := io.Copy(dst, io.LimitReader(response.Body, maxBytes+1))
 	if err != nil {
 		return err
 	}
+	if n > maxBytes {
+		return &lib.JobTooLargeError{Reason: fmt.Sprintf("Download is more than the limit %d bytes", maxBytes)}
+	}
 
 	return nil
 }
 
 // Ticket gets a ticket, aka print job options.
-func (gcp *GoogleCloudPrint) Ticket(gcpJobID string) (*cdd.CloudJobTicket, error) {
+func (gcp *GoogleCloudPrint) Ticket(ctx context.Context, gcpJobID string) (*cdd.CloudJobTicket, error) {
 	form := url.Values{}
 	form.Set("jobid", gcpJobID)
 	form.Set("use_cjt", "true")
 
-	responseBody, _, httpStatusCode, err := postWithRetry(gcp.robotClient, gcp.baseURL+"ticket", form)
+	responseBody, _, httpStatusCode, err := postWithRetry(ctx, gcp.robotClient, gcp.baseURL+"ticket", form)
 	// The /ticket API is different than others, because it only returns the
 	// standard GCP error information on success=false.
 	if httpStatusCode != http.StatusOK {
@@ -576,22 +852,23 @@ func (gcp *GoogleCloudPrint) Ticket(gcpJobID string) (*cdd.CloudJobTicket, error
 //
 // Returns byte array of raw JSON to preserve any/all returned fields
 // and returned HTTP status code.
-func (gcp *GoogleCloudPrint) ProximityToken(gcpID, user string) ([]byte, int, error) {
+func (gcp *GoogleCloudPrint) ProximityToken(ctx context.Context, gcpID, user string) ([]byte, int, error) {
 	form := url.Values{}
 	form.Set("printerid", gcpID)
 	form.Set("user", user)
 
-	responseBody, _, httpStatus, err := postWithRetry(gcp.robotClient, gcp.baseURL+"proximitytoken", form)
+	responseBody, _, httpStatus, err := postWithRetry(ctx, gcp.robotClient, gcp.baseURL+"proximitytoken", form)
 	return responseBody, httpStatus, err
 }
 
 // ListPrinters calls gcp.List, then calls gcp.Printer, one goroutine per
-// printer. This is a fast way to fetch all printers with corresponding CDD
-// info, which the List API does not provide.
+// printer, bypassing the printer cache, so that the queued job quantities
+// are current. This is a fast way to fetch all printers with corresponding
+// CDD info, which the List API does not provide.
 //
 // The second return value is a map of GCPID -> queued print job quantity.
-func (gcp *GoogleCloudPrint) ListPrinters() ([]lib.Printer, map[string]uint, error) {
-	ids, err := gcp.List()
+func (gcp *GoogleCloudPrint) ListPrinters(ctx context.Context) ([]lib.Printer, map[string]uint, error) {
+	ids, err := gcp.List(ctx)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -603,10 +880,11 @@ func (gcp *GoogleCloudPrint) ListPrinters() ([]lib.Printer, map[string]uint, err
 	}
 	ch := make(chan response)
 	for id := range ids {
-		go func(id string) {
-			printer, queuedJobsCount, err := gcp.Printer(id)
+		id := id
+		lib.Go(lib.SubsystemGCP, func() {
+			printer, queuedJobsCount, err := gcp.printer(ctx, id, true)
 			ch <- response{printer, queuedJobsCount, err}
-		}(id)
+		})
 	}
 
 	errs := make([]error, 0)
@@ -642,17 +920,32 @@ func (gcp *GoogleCloudPrint) ListPrinters() ([]lib.Printer, map[string]uint, err
 }
 
 // HandleJobs gets and processes jobs waiting on a printer.
-func (gcp *GoogleCloudPrint) HandleJobs(printer *lib.Printer, reportJobFailed func()) {
-	jobs, err := gcp.Fetch(printer.GCPID)
+func (gcp *GoogleCloudPrint) HandleJobs(ctx context.Context, printer *lib.Printer, reportJobFailed func()) {
+	ctx = lib.WithTraceScope(ctx, printer.Name, "")
+	jobs, err := gcp.Fetch(ctx, printer.GCPID)
 	if err != nil {
 		log.Errorf("Failed to fetch jobs for GCP printer %s: %s", printer.GCPID, err)
 	} else {
 		for i := range jobs {
-			go gcp.processJob(&jobs[i], printer, reportJobFailed)
+			job := &jobs[i]
+			lib.Go(lib.SubsystemGCP, func() { gcp.processJob(ctx, job, printer, reportJobFailed) })
 		}
 	}
 }
 
+// RedirectJob prints a queued or failed job of another printer on printer,
+// like the printer's own jobs. The job is in progress until it prints, so
+// that its own printer doesn't fetch it too.
+func (gcp *GoogleCloudPrint) RedirectJob(ctx context.Context, job *Job, printer *lib.Printer, reportJobFailed func()) error {
+	state := cdd.PrintJobStateDiff{State: &cdd.JobState{Type: cdd.JobStateInProgress}}
+	if err := gcp.Control(ctx, job.GCPJobID, &state, ""); err != nil {
+		return err
+	}
+	log.InfoJobf(job.GCPJobID, "Redirected to printer %s", printer.Name)
+	lib.Go(lib.SubsystemGCP, func() { gcp.processJob(ctx, job, printer, reportJobFailed) })
+	return nil
+}
+
 // processJob performs these steps:
 //
 // 1) Assembles the job resources (printer, ticket, data)
@@ -661,27 +954,80 @@ func (gcp *GoogleCloudPrint) HandleJobs(printer *lib.Printer, reportJobFailed fu
 // 4) Deletes temporary file.
 //
 // Nothing is returned; intended for use as goroutine.
-func (gcp *GoogleCloudPrint) processJob(job *Job, printer *lib.Printer, reportJobFailed func()) {
+func (gcp *GoogleCloudPrint) processJob(ctx context.Context, job *Job, printer *lib.Printer, reportJobFailed func()) {
 	log.InfoJobf(job.GCPJobID, "Received from cloud")
-
-	ticket, filename, message, state := gcp.assembleJob(job)
-	if message != "" {
+	ctx = lib.WithTraceScope(ctx, printer.Name, job.GCPJobID)
+
+	// A malformed job must not take down the other printers.
+	defer func() {
+		if r := recover(); r != nil {
+			reportJobFailed()
+			log.ErrorJobf(job.GCPJobID, "Aborted after a panic: %v\n%s", r, debug.Stack())
+			userMessage := gcp.jobErrors.Add(job.GCPJobID, printer.Name, &lib.JobError{
+				Kind:  lib.JobErrorInternal,
+				Stage: lib.JobErrorStageAssemble,
+				Err:   fmt.Errorf("Aborted after a panic: %v", r),
+			})
+			if err := gcp.Control(ctx, job.GCPJobID, &cdd.PrintJobStateDiff{State: lib.PanicState()}, userMessage); err != nil {
+				log.ErrorJob(job.GCPJobID, err)
+			}
+		}
+	}()
+
+	// The job's time limit starts now, so that a slow download counts too.
+	assembleCtx := ctx
+	deadline := gcp.jobLimiter.Deadline(printer.Name, time.Now())
+	if !deadline.IsZero() {
+		var cancel context.CancelFunc
+		assembleCtx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
+
+	ticket, filename, jobErr, state := gcp.assembleJob(assembleCtx, job, printer)
+	if jobErr != nil {
+		if ctx.Err() != nil {
+			// Shutting down; the job stays queued in the cloud.
+			return
+		}
+		if assembleCtx.Err() == context.DeadlineExceeded {
+			jobErr = &lib.JobError{
+				Kind:  lib.JobErrorTimeout,
+				Stage: lib.JobErrorStageAssemble,
+				Err:   fmt.Errorf("Aborted after its deadline: %s", jobErr),
+			}
+			state = &cdd.PrintJobStateDiff{
+				State: &cdd.JobState{
+					Type:               cdd.JobStateAborted,
+					ServiceActionCause: &cdd.ServiceActionCause{ErrorCode: cdd.ServiceActionCauseRemoteJobTimeout},
+				},
+			}
+		}
 		reportJobFailed()
-		log.ErrorJob(job.GCPJobID, message)
-		if err := gcp.Control(job.GCPJobID, state); err != nil {
+		log.ErrorJob(job.GCPJobID, jobErr)
+		userMessage := gcp.jobErrors.Add(job.GCPJobID, printer.Name, jobErr)
+		if err := gcp.Control(ctx, job.GCPJobID, state, userMessage); err != nil {
 			log.ErrorJob(job.GCPJobID, err)
 		}
 		return
 	}
+	if job.GCPPrinterID != printer.GCPID {
+		// A redirected job's ticket names the options of its own printer.
+		ticket = lib.RetargetTicket(ticket, printer.Description)
+	}
 
-	gcp.jobs <- &lib.Job{
+	select {
+	case gcp.jobs <- &lib.Job{
 		NativePrinterName: printer.Name,
 		Filename:          filename,
 		Title:             job.Title,
 		User:              job.OwnerID,
 		JobID:             job.GCPJobID,
 		Ticket:            ticket,
+		Deadline:          deadline,
 		UpdateJob:         gcp.Control,
+	}:
+	case <-ctx.Done():
+		os.Remove(filename)
 	}
 }
 
@@ -689,13 +1035,12 @@ func (gcp *GoogleCloudPrint) processJob(job *Job, printer *lib.Printer, reportJo
 //
 // The caller is responsible to remove the returned file.
 //
-// Errors are returned as a string (last return value), for reporting
-// to GCP and local log.
-func (gcp *GoogleCloudPrint) assembleJob(job *Job) (*cdd.CloudJobTicket, string, string, *cdd.PrintJobStateDiff) {
-	ticket, err := gcp.Ticket(job.GCPJobID)
+// Errors are returned as a JobError, with the state to report to GCP.
+func (gcp *GoogleCloudPrint) assembleJob(ctx context.Context, job *Job, printer *lib.Printer) (*cdd.CloudJobTicket, string, *lib.JobError, *cdd.PrintJobStateDiff) {
+	ticket, err := gcp.Ticket(ctx, job.GCPJobID)
 	if err != nil {
 		return nil, "",
-			fmt.Sprintf("Failed to get a ticket: %s", err),
+			assembleError(lib.JobErrorInternal, "Failed to get a ticket: %s", err),
 			&cdd.PrintJobStateDiff{
 				State: &cdd.JobState{
 					Type:              cdd.JobStateAborted,
@@ -707,7 +1052,7 @@ func (gcp *GoogleCloudPrint) assembleJob(job *Job) (*cdd.CloudJobTicket, string,
 	file, err := ioutil.TempFile("", "cloud-print-connector-")
 	if err != nil {
 		return nil, "",
-			fmt.Sprintf("Failed to create a temporary file: %s", err),
+			assembleError(lib.JobErrorInternal, "Failed to create a temporary file: %s", err),
 			&cdd.PrintJobStateDiff{
 				State: &cdd.JobState{
 					Type:              cdd.JobStateAborted,
@@ -719,14 +1064,31 @@ func (gcp *GoogleCloudPrint) assembleJob(job *Job) (*cdd.CloudJobTicket, string,
 	gcp.downloadSemaphore.Acquire()
 	t := time.Now()
 	// Do not check err until semaphore is released and timer is stopped.
-	err = gcp.Download(file, job.FileURL)
+	dst := lib.NewRateLimitedWriter(ctx, io.MultiWriter(file, &gcp.downloadMeter),
+		gcp.downloadLimiter, gcp.printerDownloadLimiter(printer.Name))
+	if err = lib.FaultDelay(ctx, lib.FaultDownloadDelay); err == nil {
+		err = gcp.Download(ctx, dst, job.FileURL, preferredContentType(printer.Description), gcp.jobLimiter.Limits(printer.Name).MaxBytes,
+			func(percent int) { gcp.reportProgress(ctx, job.GCPJobID, lib.JobProgressDownloading(percent)) })
+	}
 	dt := time.Since(t)
 	gcp.downloadSemaphore.Release()
+	if _, tooLarge := err.(*lib.JobTooLargeError); tooLarge {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, "",
+			assembleError(lib.JobErrorTooLarge, "Rejected: %s", err),
+			&cdd.PrintJobStateDiff{
+				State: &cdd.JobState{
+					Type:               cdd.JobStateAborted,
+					ServiceActionCause: &cdd.ServiceActionCause{ErrorCode: cdd.ServiceActionCauseConversionFileTooBig},
+				},
+			}
+	}
 	if err != nil {
 		// Clean up this temporary file so the caller doesn't need extra logic.
 		os.Remove(file.Name())
 		return nil, "",
-			fmt.Sprintf("Failed to download data: %s", err),
+			assembleError(lib.JobErrorDownload, "Failed to download data: %s", err),
 			&cdd.PrintJobStateDiff{
 				State: &cdd.JobState{
 					Type:              cdd.JobStateAborted,
@@ -736,9 +1098,47 @@ func (gcp *GoogleCloudPrint) assembleJob(job *Job) (*cdd.CloudJobTicket, string,
 	}
 
 	log.InfoJobf(job.GCPJobID, "Downloaded in %s", dt.String())
-	defer file.Close()
+	file.Close()
+
+	if err = lib.PreparePDF(file.Name(), ticket); err != nil {
+		os.Remove(file.Name())
+		return nil, "",
+			assembleError(lib.PDFErrorKind(err), "Failed to decrypt data: %s", err),
+			&cdd.PrintJobStateDiff{State: lib.PDFErrorState(err)}
+	}
+
+	if err = gcp.jobLimiter.CheckPages(printer.Name, file.Name()); err != nil {
+		os.Remove(file.Name())
+		return nil, "",
+			assembleError(lib.JobErrorTooLarge, "Rejected: %s", err),
+			&cdd.PrintJobStateDiff{
+				State: &cdd.JobState{
+					Type:               cdd.JobStateAborted,
+					ServiceActionCause: &cdd.ServiceActionCause{ErrorCode: cdd.ServiceActionCauseConversionFileTooBig},
+				},
+			}
+	}
 
 	log.DebugJobf(job.GCPJobID, "Assembled with file %s: %+v", file.Name(), ticket.Print.Color)
 
-	return ticket, file.Name(), "", &cdd.PrintJobStateDiff{}
+	return ticket, file.Name(), nil, &cdd.PrintJobStateDiff{}
+}
+
+// assembleError describes a job that failed to assemble.
+func assembleError(kind lib.JobErrorKind, format string, args ...interface{}) *lib.JobError {
+	return &lib.JobError{Kind: kind, Stage: lib.JobErrorStageAssemble, Err: fmt.Errorf(format, args...)}
+}
+
+// preferredContentType gets the format to download the files of a printer's
+// jobs in: the one format that the printer accepts, or the empty string
+// when it accepts several, so that GCP chooses.
+func preferredContentType(description *cdd.PrinterDescriptionSection) string {
+	if description == nil || description.SupportedContentType == nil || len(*description.SupportedContentType) != 1 {
+		return ""
+	}
+	contentType := (*description.SupportedContentType)[0].ContentType
+	if contentType == "*/*" {
+		return ""
+	}
+	return contentType
 }
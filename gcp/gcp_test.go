@@ -0,0 +1,325 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package gcp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"golang.org/x/oauth2"
+
+	"github.com/google/cloud-print-connector/cdd"
+	"github.com/google/cloud-print-connector/lib"
+)
+
+// The tests replay the cassettes in testdata. To record them again against
+// GCP, run go test -gcp-record=<config file> with the config file of a
+// connector whose robot account may register printers.
+var recordConfig = flag.String("gcp-record", "", "record the cassettes in testdata against GCP, with the credentials of this connector config file")
+
+// newCassetteGCP creates a GoogleCloudPrint that replays a cassette, or that
+// records it. The returned function checks that every interaction was
+// replayed, or saves the recording.
+func newCassetteGCP(t *testing.T, name string) (*GoogleCloudPrint, func()) {
+	filename := filepath.Join("testdata", name+".json")
+	jobLimiter := lib.NewJobLimiter(lib.JobLimits{}, nil)
+
+	if *recordConfig == "" {
+		c, err := loadCassette(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		gcp, err := NewGoogleCloudPrint(c.BaseURL, "robot-refresh-token", "", c.ProxyName, "", "", "", "", 1, nil, jobLimiter, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		transport := newReplayingTransport(c)
+		gcp.robotClient = &http.Client{Transport: transport}
+		return gcp, func() {
+			if n := transport.unplayed(); n > 0 {
+				t.Logf("%d interactions of cassette %s were not replayed", n, name)
+				t.Fail()
+			}
+		}
+	}
+
+	config := lib.DefaultConfig
+	b, err := ioutil.ReadFile(*recordConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = json.Unmarshal(b, &config); err != nil {
+		t.Fatal(err)
+	}
+	gcp, err := NewGoogleCloudPrint(config.GCPBaseURL, config.RobotRefreshToken, "", config.ProxyName,
+		config.GCPOAuthClientID, config.GCPOAuthClientSecret, config.GCPOAuthAuthURL, config.GCPOAuthTokenURL,
+		1, nil, jobLimiter, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oauthTransport := gcp.robotClient.Transport.(*oauth2.Transport)
+	transport := newRecordingTransport(oauthTransport.Base, config.GCPBaseURL, config.ProxyName)
+	oauthTransport.Base = transport
+	return gcp, func() {
+		if err := transport.cassette.save(filename); err != nil {
+			t.Logf("failed to save cassette %s: %s", name, err)
+			t.Fail()
+		}
+	}
+}
+
+func testPrinter(name string) *lib.Printer {
+	return &lib.Printer{
+		Name:               name,
+		DefaultDisplayName: "Cassette test printer",
+		UUID:               "cassette-" + name,
+		Manufacturer:       "Test",
+		Model:              "Cassette",
+		GCPVersion:         lib.GCPAPIVersion,
+		ConnectorVersion:   "cassette-test",
+		State:              &cdd.PrinterStateSection{State: cdd.CloudDeviceStateIdle},
+		Description: &cdd.PrinterDescriptionSection{
+			SupportedContentType: cdd.NewSupportedContentType("application/pdf"),
+			Copies:               &cdd.Copies{Default: 1, Max: 10},
+		},
+		CapsHash: "cassette-caps-hash",
+		Tags:     map[string]string{"location": "test lab"},
+	}
+}
+
+// submit submits a text job to a printer, as a user of the printer would.
+func submit(ctx context.Context, gcp *GoogleCloudPrint, gcpID, title, content string) error {
+	form := url.Values{}
+	form.Set("printerid", gcpID)
+	form.Set("title", title)
+	form.Set("ticket", `{"version":"1.0","print":{}}`)
+	form.Set("contentType", "text/plain")
+	form.Set("content", content)
+	_, _, _, err := postWithRetry(ctx, gcp.robotClient, gcp.baseURL+"submit", form)
+	return err
+}
+
+func TestCassetteRegister(t *testing.T) {
+	gcp, done := newCassetteGCP(t, "register")
+	defer done()
+	ctx := context.Background()
+
+	printer := testPrinter("cassette-register")
+	if err := gcp.Register(ctx, printer); err != nil {
+		t.Fatal(err)
+	}
+	if printer.GCPID == "" {
+		t.Fatal("expected Register to set the GCP ID")
+	}
+
+	printers, err := gcp.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if printers[printer.GCPID] != printer.Name {
+		t.Logf("expected List to include %s %s, got %v", printer.GCPID, printer.Name, printers)
+		t.Fail()
+	}
+
+	registered, queued, err := gcp.Printer(ctx, printer.GCPID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if registered.DefaultDisplayName != printer.DefaultDisplayName || registered.CapsHash != printer.CapsHash ||
+		registered.Tags["location"] != "test lab" || queued != 0 {
+		t.Logf("expected the registered printer to be %+v with no jobs, got %+v with %d jobs", printer, registered, queued)
+		t.Fail()
+	}
+
+	if err = gcp.Delete(ctx, printer.GCPID); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCassetteUpdate(t *testing.T) {
+	gcp, done := newCassetteGCP(t, "update")
+	defer done()
+	ctx := context.Background()
+
+	printer := testPrinter("cassette-update")
+	if err := gcp.Register(ctx, printer); err != nil {
+		t.Fatal(err)
+	}
+
+	printer.DefaultDisplayName = "Updated cassette test printer"
+	printer.State = &cdd.PrinterStateSection{State: cdd.CloudDeviceStateStopped}
+	diff := lib.PrinterDiff{
+		Operation:                 lib.UpdatePrinter,
+		Printer:                   *printer,
+		DefaultDisplayNameChanged: true,
+		StateChanged:              true,
+	}
+	if err := gcp.Update(ctx, &diff); err != nil {
+		t.Fatal(err)
+	}
+
+	registered, _, err := gcp.Printer(ctx, printer.GCPID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if registered.DefaultDisplayName != printer.DefaultDisplayName ||
+		registered.State == nil || registered.State.State != cdd.CloudDeviceStateStopped {
+		t.Logf("expected the update to be registered, got %+v", registered)
+		t.Fail()
+	}
+
+	if err = gcp.Delete(ctx, printer.GCPID); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCassetteFetch(t *testing.T) {
+	gcp, done := newCassetteGCP(t, "fetch")
+	defer done()
+	ctx := context.Background()
+
+	printer := testPrinter("cassette-fetch")
+	if err := gcp.Register(ctx, printer); err != nil {
+		t.Fatal(err)
+	}
+	if err := submit(ctx, gcp, printer.GCPID, "cassette job", "Hello, printer.\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	jobs, err := gcp.Fetch(ctx, printer.GCPID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 1 || jobs[0].Title != "cassette job" || jobs[0].GCPPrinterID != printer.GCPID {
+		t.Fatalf("expected to fetch the cassette job, got %+v", jobs)
+	}
+
+	var b bytes.Buffer
+	if err = gcp.Download(ctx, &b, jobs[0].FileURL, "", 0, nil); err != nil {
+		t.Fatal(err)
+	}
+	if b.Len() == 0 {
+		t.Logf("expected to download the cassette job's file")
+		t.Fail()
+	}
+
+	for _, state := range []cdd.JobStateType{cdd.JobStateInProgress, cdd.JobStateDone} {
+		if err = gcp.Control(ctx, jobs[0].GCPJobID, &cdd.PrintJobStateDiff{State: &cdd.JobState{Type: state}}, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Zero jobs is an error of GCP, not of Fetch.
+	if jobs, err = gcp.Fetch(ctx, printer.GCPID); err != nil || len(jobs) != 0 {
+		t.Logf("expected to fetch no more jobs, got %+v, %v", jobs, err)
+		t.Fail()
+	}
+
+	if err = gcp.Delete(ctx, printer.GCPID); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCassetteAccess(t *testing.T) {
+	gcp, done := newCassetteGCP(t, "access")
+	defer done()
+	ctx := context.Background()
+
+	gcpID := "6c0a2f1e-93b4-4d02-b8f7-0e5d1a2b3c05"
+	access, err := gcp.PrinterAccess(ctx, gcpID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Access{
+		{Scope: "cassette-robot@cloudprint.example.com", Role: Owner, Type: "USER"},
+		{Scope: "alice@example.com", Role: User, Type: "USER"},
+		{Scope: "example.com", Role: Manager, Type: "DOMAIN"},
+	}
+	if !reflect.DeepEqual(access, expected) {
+		t.Logf("expected access %+v, got %+v", expected, access)
+		t.Fail()
+	}
+
+	if access, err = gcp.PrinterAccess(ctx, "no-such-printer"); err == nil {
+		t.Logf("expected to fail to get the access of a printer that doesn't exist, got %+v", access)
+		t.Fail()
+	}
+}
+
+func TestCassetteErrors(t *testing.T) {
+	gcp, done := newCassetteGCP(t, "errors")
+	defer done()
+	ctx := context.Background()
+
+	if _, err := gcp.Fetch(ctx, "no-such-printer"); err == nil {
+		t.Log("expected to fail to fetch the jobs of a printer that doesn't exist")
+		t.Fail()
+	}
+	if err := gcp.Control(ctx, "no-such-job", &cdd.PrintJobStateDiff{State: &cdd.JobState{Type: cdd.JobStateDone}}, ""); err == nil {
+		t.Log("expected to fail to control a job that doesn't exist")
+		t.Fail()
+	}
+	if err := gcp.Delete(ctx, "no-such-printer"); err == nil {
+		t.Log("expected to fail to delete a printer that doesn't exist")
+		t.Fail()
+	}
+}
+
+func TestPreferredContentType(t *testing.T) {
+	testCases := []struct {
+		description *cdd.PrinterDescriptionSection
+		expected    string
+	}{
+		{nil, ""},
+		{&cdd.PrinterDescriptionSection{}, ""},
+		{&cdd.PrinterDescriptionSection{SupportedContentType: cdd.NewSupportedContentType("image/pwg-raster")}, "image/pwg-raster"},
+		{&cdd.PrinterDescriptionSection{SupportedContentType: cdd.NewSupportedContentType("*/*")}, ""},
+		{&cdd.PrinterDescriptionSection{SupportedContentType: &[]cdd.SupportedContentType{
+			{ContentType: "application/pdf"}, {ContentType: "application/postscript"},
+		}}, ""},
+	}
+	for i, tc := range testCases {
+		if contentType := preferredContentType(tc.description); contentType != tc.expected {
+			t.Logf("case %d: expected %q, got %q", i, tc.expected, contentType)
+			t.Fail()
+		}
+	}
+}
+
+func TestMarshalCapabilitiesDropsInvalid(t *testing.T) {
+	description := &cdd.PrinterDescriptionSection{
+		Copies: &cdd.Copies{Default: 1, Max: 10},
+		Duplex: &cdd.Duplex{Option: []cdd.DuplexOption{{Type: "TOP_EDGE"}}},
+	}
+	capabilities, err := marshalCapabilities("printer", description)
+	if err != nil {
+		t.Fatalf("expected the printer to keep its valid capabilities, got %s", err)
+	}
+	var c cdd.CloudDeviceDescription
+	if err = json.Unmarshal([]byte(capabilities), &c); err != nil {
+		t.Fatal(err)
+	}
+	if c.Printer == nil || c.Printer.Duplex != nil || c.Printer.Copies == nil || c.Printer.Copies.Max != 10 {
+		t.Logf("expected only the invalid duplex to be dropped, got %s", capabilities)
+		t.Fail()
+	}
+
+	if _, err = marshalCapabilities("printer", nil); err == nil {
+		t.Log("expected a printer without a description to fail")
+		t.Fail()
+	}
+}
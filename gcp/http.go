@@ -9,12 +9,15 @@ https://developers.google.com/open-source/licenses/bsd
 package gcp
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/url"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/cloud-print-connector/lib"
@@ -41,8 +44,34 @@ https://github.com/golang/go/issues/6336
 */
 var lock *lib.Semaphore = lib.NewSemaphore(100)
 
+// transport is http.DefaultTransport, except that it races the addresses of
+// the configured address family, so that a broken IPv6 or IPv4 network
+// doesn't look like a GCP outage, and its connections are counted as GCP's.
+var transport = &http.Transport{
+	Proxy: http.ProxyFromEnvironment,
+	DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+		dialer := net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+		conn, err := lib.DialHappyEyeballs(ctx, &dialer, network, address)
+		if err != nil {
+			return nil, err
+		}
+		return lib.TrackConn(lib.SubsystemGCP, conn), nil
+	},
+	ForceAttemptHTTP2:     true,
+	MaxIdleConns:          100,
+	IdleConnTimeout:       90 * time.Second,
+	TLSHandshakeTimeout:   10 * time.Second,
+	ExpectContinueTimeout: 1 * time.Second,
+}
+
+// transportTLS limits the TLS of transport by the TLS policy, which is set
+// at startup, before the first client is created.
+var transportTLS sync.Once
+
 // newClient creates an instance of http.Client, wrapped with OAuth credentials.
-func newClient(oauthClientID, oauthClientSecret, oauthAuthURL, oauthTokenURL, refreshToken string, scopes ...string) (*http.Client, error) {
+// Tokens are refreshed by GCP's clock, as measured by skew. Requests wait
+// for quota.
+func newClient(skew *clockSkew, quota *requestQuota, oauthClientID, oauthClientSecret, oauthAuthURL, oauthTokenURL, refreshToken string, scopes ...string) (*http.Client, error) {
 	config := oauth2.Config{
 		ClientID:     oauthClientID,
 		ClientSecret: oauthClientSecret,
@@ -53,43 +82,57 @@ func newClient(oauthClientID, oauthClientSecret, oauthAuthURL, oauthTokenURL, re
 		RedirectURL: RedirectURL,
 		Scopes:      scopes,
 	}
-
-	token := oauth2.Token{RefreshToken: refreshToken}
-	client := config.Client(oauth2.NoContext, &token)
+	transportTLS.Do(func() { transport.TLSClientConfig = lib.TLSConfig() })
+
+	client := &http.Client{
+		Transport: &oauth2.Transport{
+			Source: newTokenSource(&config, refreshToken, skew),
+			Base: &lib.UserAgentTransport{Base: &quotaTransport{
+				base:  &skewTransport{base: &traceTransport{base: transport}, skew: skew},
+				quota: quota,
+			}},
+		},
+	}
 
 	return client, nil
 }
 
 // getWithRetry calls get() and retries on HTTP failure
-// (response code != 200).
-func getWithRetry(hc *http.Client, url string) (*http.Response, error) {
+// (response code != 200), until ctx is done.
+func getWithRetry(ctx context.Context, hc *http.Client, url, accept string) (*http.Response, error) {
 	backoff := lib.Backoff{}
 	for {
-		response, err := get(hc, url)
+		response, err := get(ctx, hc, url, accept)
 		if response != nil && response.StatusCode == http.StatusOK {
 			return response, err
 		}
 
 		p, retryAgain := backoff.Pause()
 		if !retryAgain {
-			log.Debugf("HTTP error %s, retry timeout hit", err, p)
+			log.Debugf("HTTP error %s, retry timeout hit", err)
 			return response, err
 		}
 		log.Debugf("HTTP error %s, retrying after %s", err, p)
-		time.Sleep(p)
+		if !sleep(ctx, p) {
+			return nil, ctx.Err()
+		}
 	}
 }
 
 // get GETs a URL. Returns the response object (not body), in case the body
-// is very large.
+// is very large. When accept isn't empty, it is the Accept header.
 //
 // The caller must close the returned Response.Body object if err == nil.
-func get(hc *http.Client, url string) (*http.Response, error) {
+func get(ctx context.Context, hc *http.Client, url, accept string) (*http.Response, error) {
 	request, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
+	request = request.WithContext(ctx)
 	request.Header.Set("X-CloudPrint-Proxy", lib.ShortName)
+	if accept != "" {
+		request.Header.Set("Accept", accept)
+	}
 
 	lock.Acquire()
 	response, err := hc.Do(request)
@@ -105,22 +148,36 @@ func get(hc *http.Client, url string) (*http.Response, error) {
 }
 
 // postWithRetry calls post() and retries on HTTP failure
-// (response code != 200).
-func postWithRetry(hc *http.Client, url string, form url.Values) ([]byte, uint, int, error) {
+// (response code != 200), until ctx is done.
+func postWithRetry(ctx context.Context, hc *http.Client, url string, form url.Values) ([]byte, uint, int, error) {
 	backoff := lib.Backoff{}
 	for {
-		responseBody, gcpErrorCode, httpStatusCode, err := post(hc, url, form)
+		responseBody, gcpErrorCode, httpStatusCode, err := post(ctx, hc, url, form)
 		if responseBody != nil && httpStatusCode == http.StatusOK {
 			return responseBody, gcpErrorCode, httpStatusCode, err
 		}
 
 		p, retryAgain := backoff.Pause()
 		if !retryAgain {
-			log.Debugf("HTTP error %s, retry timeout hit", err, p)
+			log.Debugf("HTTP error %s, retry timeout hit", err)
 			return responseBody, gcpErrorCode, httpStatusCode, err
 		}
 		log.Debugf("HTTP error %s, retrying after %s", err, p)
-		time.Sleep(p)
+		if !sleep(ctx, p) {
+			return responseBody, gcpErrorCode, httpStatusCode, ctx.Err()
+		}
+	}
+}
+
+// sleep pauses for d. Returns false when ctx is done first.
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
 	}
 }
 
@@ -128,12 +185,13 @@ func postWithRetry(hc *http.Client, url string, form url.Values) ([]byte, uint,
 //
 // Returns the response body, GCP error code, HTTP status, and error.
 // None of the returned fields is guaranteed to be non-zero.
-func post(hc *http.Client, url string, form url.Values) ([]byte, uint, int, error) {
+func post(ctx context.Context, hc *http.Client, url string, form url.Values) ([]byte, uint, int, error) {
 	requestBody := strings.NewReader(form.Encode())
 	request, err := http.NewRequest("POST", url, requestBody)
 	if err != nil {
 		return nil, 0, 0, err
 	}
+	request = request.WithContext(ctx)
 	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	request.Header.Set("X-CloudPrint-Proxy", lib.ShortName)
 
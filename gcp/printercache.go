@@ -0,0 +1,92 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package gcp
+
+import (
+	"sync"
+	"time"
+)
+
+// printerCache keeps the responses of google.com/cloudprint/printer, by GCP
+// ID, so that frequent reads of the capabilities and sharing of a printer
+// don't each call GCP. GCP can't revalidate a response, so it is reused
+// until it is older than the TTL, or until the connector changes the
+// printer. A TTL of zero keeps nothing.
+type printerCache struct {
+	mutex   sync.Mutex
+	ttl     time.Duration
+	entries map[string]printerCacheEntry
+	hits    uint64
+	misses  uint64
+	// now is time.Now, except in tests.
+	now func() time.Time
+}
+
+type printerCacheEntry struct {
+	body    []byte
+	fetched time.Time
+}
+
+func newPrinterCache() *printerCache {
+	return &printerCache{
+		entries: make(map[string]printerCacheEntry),
+		now:     time.Now,
+	}
+}
+
+// get gets the response for a printer, unless it is too old.
+func (c *printerCache) get(gcpID string) ([]byte, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entry, exists := c.entries[gcpID]
+	if !exists || c.now().Sub(entry.fetched) >= c.ttl {
+		delete(c.entries, gcpID)
+		c.misses++
+		return nil, false
+	}
+	c.hits++
+	return entry.body, true
+}
+
+// put keeps the response for a printer.
+func (c *printerCache) put(gcpID string, body []byte) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.ttl <= 0 {
+		return
+	}
+	c.entries[gcpID] = printerCacheEntry{body, c.now()}
+}
+
+// invalidate forgets the response for a printer, after it changes.
+func (c *printerCache) invalidate(gcpID string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	delete(c.entries, gcpID)
+}
+
+func (c *printerCache) setTTL(ttl time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.ttl = ttl
+	if ttl <= 0 {
+		c.entries = make(map[string]printerCacheEntry)
+	}
+}
+
+func (c *printerCache) stats() (uint64, uint64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.hits, c.misses
+}
@@ -0,0 +1,51 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package gcp
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPrinterCache(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	c := newPrinterCache()
+	c.now = func() time.Time { return now }
+
+	c.put("a", []byte("a1"))
+	if _, ok := c.get("a"); ok {
+		t.Log("expected a cache without a TTL to keep nothing")
+		t.Fail()
+	}
+
+	c.setTTL(time.Minute)
+	c.put("a", []byte("a1"))
+	now = now.Add(59 * time.Second)
+	if body, ok := c.get("a"); !ok || string(body) != "a1" {
+		t.Logf("expected a1 to be cached, got %q", body)
+		t.Fail()
+	}
+	now = now.Add(time.Second)
+	if _, ok := c.get("a"); ok {
+		t.Log("expected a1 to expire after the TTL")
+		t.Fail()
+	}
+
+	c.put("b", []byte("b1"))
+	c.invalidate("b")
+	if _, ok := c.get("b"); ok {
+		t.Log("expected b1 to be invalidated")
+		t.Fail()
+	}
+
+	if hits, misses := c.stats(); hits != 1 || misses != 3 {
+		t.Logf("expected 1 hit and 3 misses, got %d and %d", hits, misses)
+		t.Fail()
+	}
+}
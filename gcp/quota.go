@@ -0,0 +1,120 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package gcp
+
+import (
+	"net/http"
+	"path"
+	"sync"
+	"time"
+)
+
+// quotaReserveFraction is the fraction of each minute's requests that only
+// job calls may make, so that jobs keep printing while printer state churns.
+const quotaReserveFraction = 5 // 1/5
+
+// jobCalls are the GCP calls that jobs need to print, by the last element
+// of their path.
+var jobCalls = map[string]bool{
+	"fetch":          true,
+	"control":        true,
+	"ticket":         true,
+	"download":       true,
+	"jobs":           true,
+	"deletejob":      true,
+	"proximitytoken": true,
+}
+
+// requestQuota keeps the requests to GCP within a quantity per minute.
+// Background calls, like printer updates, wait for the next minute once
+// most of the quantity is used, leaving the rest to job calls. Zero
+// requests per minute is no limit.
+type requestQuota struct {
+	mutex       sync.Mutex
+	perMinute   uint
+	windowStart time.Time
+	used        uint
+	deferred    uint64
+	// now is time.Now, except in tests.
+	now func() time.Time
+}
+
+func newRequestQuota() *requestQuota {
+	return &requestQuota{now: time.Now}
+}
+
+// take counts one request, when the quota allows it now. Otherwise returns
+// how long until it might.
+func (q *requestQuota) take(jobCall bool) (time.Duration, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if q.perMinute == 0 {
+		return 0, true
+	}
+	now := q.now()
+	if now.Sub(q.windowStart) >= time.Minute {
+		q.windowStart, q.used = now, 0
+	}
+	limit := q.perMinute
+	if !jobCall {
+		limit -= q.perMinute / quotaReserveFraction
+	}
+	if q.used < limit {
+		q.used++
+		return 0, true
+	}
+	q.deferred++
+	return q.windowStart.Add(time.Minute).Sub(now), false
+}
+
+func (q *requestQuota) setPerMinute(perMinute uint) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	q.perMinute = perMinute
+}
+
+// stats gets the requests per minute, those that remain this minute, and
+// how many times requests have waited for the next minute.
+func (q *requestQuota) stats() (uint, uint, uint64) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	remaining := q.perMinute
+	if q.now().Sub(q.windowStart) < time.Minute {
+		// More may have been used before the requests per minute were lowered.
+		if q.used < remaining {
+			remaining -= q.used
+		} else {
+			remaining = 0
+		}
+	}
+	return q.perMinute, remaining, q.deferred
+}
+
+// quotaTransport waits for the quota before each request, or until the
+// request's context is done.
+type quotaTransport struct {
+	base  http.RoundTripper
+	quota *requestQuota
+}
+
+func (t *quotaTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	jobCall := jobCalls[path.Base(request.URL.Path)]
+	for {
+		wait, ok := t.quota.take(jobCall)
+		if ok {
+			return t.base.RoundTrip(request)
+		}
+		if !sleep(request.Context(), wait) {
+			return nil, request.Context().Err()
+		}
+	}
+}
@@ -0,0 +1,69 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package gcp
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRequestQuota(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	q := newRequestQuota()
+	q.now = func() time.Time { return now }
+
+	for i := 0; i < 100; i++ {
+		if _, ok := q.take(false); !ok {
+			t.Fatal("expected no limit without requests per minute")
+		}
+	}
+
+	q.setPerMinute(10)
+	for i := 0; i < 8; i++ {
+		if _, ok := q.take(false); !ok {
+			t.Fatalf("expected background request %d to be allowed", i)
+		}
+	}
+	if wait, ok := q.take(false); ok || wait != time.Minute {
+		t.Logf("expected the 9th background request to wait a minute, got %s, %v", wait, ok)
+		t.Fail()
+	}
+	for i := 0; i < 2; i++ {
+		if _, ok := q.take(true); !ok {
+			t.Logf("expected job request %d to use the reserve", i)
+			t.Fail()
+		}
+	}
+	now = now.Add(15 * time.Second)
+	if wait, ok := q.take(true); ok || wait != 45*time.Second {
+		t.Logf("expected a job request to wait for the next minute once the quota is used, got %s, %v", wait, ok)
+		t.Fail()
+	}
+	if perMinute, remaining, deferred := q.stats(); perMinute != 10 || remaining != 0 || deferred != 2 {
+		t.Logf("expected 10 per minute, 0 remaining and 2 deferred, got %d, %d and %d", perMinute, remaining, deferred)
+		t.Fail()
+	}
+
+	now = now.Add(45 * time.Second)
+	if _, ok := q.take(false); !ok {
+		t.Log("expected a background request to be allowed the next minute")
+		t.Fail()
+	}
+	if _, remaining, _ := q.stats(); remaining != 9 {
+		t.Logf("expected 9 requests to remain, got %d", remaining)
+		t.Fail()
+	}
+
+	q.take(false)
+	q.setPerMinute(1)
+	if _, remaining, _ := q.stats(); remaining != 0 {
+		t.Logf("expected no requests to remain after lowering the quota below those used, got %d", remaining)
+		t.Fail()
+	}
+}
@@ -0,0 +1,183 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package gcp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/cloud-print-connector/lib"
+	"github.com/google/cloud-print-connector/log"
+
+	"golang.org/x/oauth2"
+)
+
+const (
+	// tokenExpiryMargin is how long before its expiry a token is no longer
+	// used.
+	tokenExpiryMargin = time.Minute
+	// tokenRenewWindow is how long before its expiry a token is refreshed in
+	// the background, while it is still used.
+	tokenRenewWindow = 5 * time.Minute
+	// tokenRefreshAttempts is how many times to try to refresh a token
+	// before giving up, unless the refresh token is revoked.
+	tokenRefreshAttempts = 4
+	// revokedRetryInterval is how often to try a revoked refresh token
+	// again, in case the account is fixed.
+	revokedRetryInterval = time.Minute
+)
+
+// tokenContext refreshes tokens with a client that sends the connector's
+// User-Agent.
+var tokenContext = context.WithValue(context.Background(), oauth2.HTTPClient,
+	&http.Client{Transport: &lib.UserAgentTransport{}})
+
+// tokenSource refreshes access tokens, one refresh at a time, before they
+// expire.
+//
+// Tokens expire by GCP's clock rather than the local clock, so that a token
+// isn't used past its expiry when the local clock jumps back, nor refreshed
+// early when it jumps ahead.
+type tokenSource struct {
+	config *oauth2.Config
+	skew   *clockSkew
+
+	// refreshMutex serializes refreshes.
+	refreshMutex sync.Mutex
+
+	mutex        sync.Mutex
+	refreshToken string
+	token        *oauth2.Token
+	expiry       time.Time // By GCP's clock.
+	renewing     bool
+	revoked      bool
+	revokedAt    time.Time
+	revokedErr   error
+}
+
+func newTokenSource(config *oauth2.Config, refreshToken string, skew *clockSkew) *tokenSource {
+	return &tokenSource{config: config, refreshToken: refreshToken, skew: skew}
+}
+
+func (s *tokenSource) Token() (*oauth2.Token, error) {
+	s.mutex.Lock()
+	token, expiry := s.token, s.expiry
+	startRenew := false
+	if token != nil && !s.renewing && s.skew.now().After(expiry.Add(-tokenRenewWindow)) {
+		s.renewing, startRenew = true, true
+	}
+	s.mutex.Unlock()
+
+	if token != nil && s.skew.now().Before(expiry.Add(-tokenExpiryMargin)) {
+		if startRenew {
+			lib.Go(lib.SubsystemGCP, func() {
+				if _, err := s.refresh(token); err != nil {
+					log.Warningf("Failed to renew OAuth access token early: %s", err)
+				}
+				s.mutex.Lock()
+				s.renewing = false
+				s.mutex.Unlock()
+			})
+		}
+		return token, nil
+	}
+
+	if startRenew {
+		s.mutex.Lock()
+		s.renewing = false
+		s.mutex.Unlock()
+	}
+	return s.refresh(token)
+}
+
+// ReauthorizationRequired is true when the refresh token has been revoked,
+// so that the connector must be authorized again.
+func (s *tokenSource) ReauthorizationRequired() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.revoked
+}
+
+// refresh gets a new access token, unless another goroutine has already
+// replaced old.
+func (s *tokenSource) refresh(old *oauth2.Token) (*oauth2.Token, error) {
+	s.refreshMutex.Lock()
+	defer s.refreshMutex.Unlock()
+
+	s.mutex.Lock()
+	refreshToken := s.refreshToken
+	if s.token != old && s.token != nil {
+		defer s.mutex.Unlock()
+		return s.token, nil
+	}
+	if s.revoked && time.Since(s.revokedAt) < revokedRetryInterval {
+		defer s.mutex.Unlock()
+		return nil, s.revokedErr
+	}
+	s.mutex.Unlock()
+
+	var token *oauth2.Token
+	var err error
+	backoff := lib.Backoff{}
+	for attempt := 1; ; attempt++ {
+		// A token without an access token is refreshed right away.
+		token, err = s.config.TokenSource(tokenContext, &oauth2.Token{RefreshToken: refreshToken}).Token()
+		if err == nil || isRevoked(err) || attempt == tokenRefreshAttempts {
+			break
+		}
+		p, _ := backoff.Pause()
+		log.Debugf("Failed to refresh OAuth access token, retrying after %s: %s", p, err)
+		time.Sleep(p)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err != nil {
+		revoked := isRevoked(err)
+		if skew := s.skew.get(); abs(skew) >= clockSkewWarning {
+			err = fmt.Errorf("%s; the local clock is %s off, which may be why", err, abs(skew))
+		}
+		if revoked {
+			if !s.revoked {
+				log.Errorf("REAUTHORIZATION REQUIRED: the connector's OAuth refresh token was revoked; run gcp-connector-util reauthorize: %s", err)
+			}
+			s.revoked, s.revokedAt = true, time.Now()
+			s.revokedErr = errors.New("Reauthorization required; the OAuth refresh token was revoked")
+		}
+		return nil, err
+	}
+
+	if s.revoked {
+		log.Info("OAuth refresh token works again")
+		s.revoked = false
+	}
+	if token.RefreshToken != "" {
+		s.refreshToken = token.RefreshToken
+	}
+	// The expiry is local time plus the token's lifetime, so measure the
+	// lifetime now, before the local clock may jump.
+	s.expiry = s.skew.now().Add(token.Expiry.Sub(time.Now()))
+	s.token = token
+	return token, nil
+}
+
+// isRevoked tells whether a refresh failed because the refresh token is no
+// longer good, rather than for a reason that may pass.
+func isRevoked(err error) bool {
+	if re, ok := err.(*oauth2.RetrieveError); ok {
+		return strings.Contains(string(re.Body), "invalid_grant")
+	}
+	return false
+}
@@ -0,0 +1,161 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package gcp
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// fakeTokenEndpoint grants access tokens for good refresh tokens, and
+// rotates them, like Google's OAuth token endpoint.
+type fakeTokenEndpoint struct {
+	mutex         sync.Mutex
+	refreshTokens []string
+	revoked       bool
+}
+
+func (e *fakeTokenEndpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.refreshTokens = append(e.refreshTokens, r.PostForm.Get("refresh_token"))
+
+	w.Header().Set("Content-Type", "application/json")
+	if e.revoked {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"error":"invalid_grant","error_description":"Token has been expired or revoked."}`)
+		return
+	}
+	n := len(e.refreshTokens)
+	fmt.Fprintf(w, `{"access_token":"access-%d","token_type":"Bearer","expires_in":3600,"refresh_token":"refresh-%d"}`, n, n)
+}
+
+func (e *fakeTokenEndpoint) requests() []string {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return append([]string(nil), e.refreshTokens...)
+}
+
+func newFakeTokenSource(endpoint *fakeTokenEndpoint) (*tokenSource, func()) {
+	server := httptest.NewServer(endpoint)
+	config := oauth2.Config{
+		ClientID:     "client-id",
+		ClientSecret: "client-secret",
+		Endpoint:     oauth2.Endpoint{TokenURL: server.URL, AuthStyle: oauth2.AuthStyleInParams},
+	}
+	return newTokenSource(&config, "refresh-0", &clockSkew{}), server.Close
+}
+
+func TestTokenSourceRefresh(t *testing.T) {
+	endpoint := &fakeTokenEndpoint{}
+	s, done := newFakeTokenSource(endpoint)
+	defer done()
+
+	for i := 0; i < 3; i++ {
+		token, err := s.Token()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token.AccessToken != "access-1" {
+			t.Logf("expected the first access token, got %s", token.AccessToken)
+			t.Fail()
+		}
+	}
+	if requests := endpoint.requests(); len(requests) != 1 || requests[0] != "refresh-0" {
+		t.Logf("expected one refresh with the first refresh token, got %v", requests)
+		t.Fail()
+	}
+
+	// An expired token is refreshed, with the rotated refresh token.
+	s.mutex.Lock()
+	s.expiry = s.skew.now().Add(-time.Second)
+	s.mutex.Unlock()
+	token, err := s.Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.AccessToken != "access-2" {
+		t.Logf("expected a new access token, got %s", token.AccessToken)
+		t.Fail()
+	}
+	if requests := endpoint.requests(); len(requests) != 2 || requests[1] != "refresh-1" {
+		t.Logf("expected a refresh with the rotated refresh token, got %v", requests)
+		t.Fail()
+	}
+	if s.ReauthorizationRequired() {
+		t.Log("expected no reauthorization to be required")
+		t.Fail()
+	}
+}
+
+func TestTokenSourceRevoked(t *testing.T) {
+	endpoint := &fakeTokenEndpoint{revoked: true}
+	s, done := newFakeTokenSource(endpoint)
+	defer done()
+
+	if _, err := s.Token(); err == nil {
+		t.Fatal("expected a revoked refresh token to fail")
+	}
+	if !s.ReauthorizationRequired() {
+		t.Log("expected reauthorization to be required")
+		t.Fail()
+	}
+	// A revoked refresh token isn't retried, nor tried again right away.
+	if _, err := s.Token(); err == nil {
+		t.Log("expected a revoked refresh token to fail again")
+		t.Fail()
+	}
+	if requests := endpoint.requests(); len(requests) != 1 {
+		t.Logf("expected one refresh, got %v", requests)
+		t.Fail()
+	}
+
+	// Once the account is fixed, the next try works.
+	endpoint.mutex.Lock()
+	endpoint.revoked = false
+	endpoint.mutex.Unlock()
+	s.mutex.Lock()
+	s.revokedAt = time.Now().Add(-revokedRetryInterval)
+	s.mutex.Unlock()
+	if _, err := s.Token(); err != nil {
+		t.Fatal(err)
+	}
+	if s.ReauthorizationRequired() {
+		t.Log("expected the working refresh token to need no reauthorization")
+		t.Fail()
+	}
+}
+
+func TestReauthorizationRequired(t *testing.T) {
+	endpoint := &fakeTokenEndpoint{revoked: true}
+	s, done := newFakeTokenSource(endpoint)
+	defer done()
+
+	// Clients without OAuth, like the cassettes', never need it.
+	gcp := &GoogleCloudPrint{
+		robotClient: &http.Client{Transport: http.DefaultTransport},
+		userClient:  &http.Client{Transport: &oauth2.Transport{Source: s}},
+	}
+	if gcp.ReauthorizationRequired() {
+		t.Log("expected no reauthorization to be required before a refresh")
+		t.Fail()
+	}
+	s.Token()
+	if !gcp.ReauthorizationRequired() {
+		t.Log("expected reauthorization to be required after a revoked refresh")
+		t.Fail()
+	}
+}
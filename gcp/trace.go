@@ -0,0 +1,142 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package gcp
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/cloud-print-connector/lib"
+)
+
+// traceRedactedHeaders are the headers whose values are never traced.
+var traceRedactedHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+	"Set-Cookie":          true,
+}
+
+// traceTransport records the exchanges of the printer and job of each
+// request's context, while they are being traced.
+type traceTransport struct {
+	base http.RoundTripper
+}
+
+func (t *traceTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	printer, jobID := lib.TraceScope(request.Context())
+	if !lib.Tracing(printer, jobID) {
+		return t.base.RoundTrip(request)
+	}
+
+	record := lib.TraceRecord{
+		Time:     time.Now(),
+		Protocol: lib.TraceProtocolHTTP,
+		Printer:  printer,
+		JobID:    jobID,
+		Request:  dumpTraceRequest(request),
+	}
+	response, err := t.base.RoundTrip(request)
+	if err != nil {
+		record.Error = err.Error()
+	} else {
+		record.Response = dumpTraceResponse(response)
+	}
+	lib.Trace(record)
+	return response, err
+}
+
+// dumpTraceRequest formats a request, leaving it as it was.
+func dumpTraceRequest(request *http.Request) string {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%s %s\n", request.Method, request.URL)
+	dumpTraceHeader(&b, request.Header)
+
+	if request.Body == nil {
+		return b.String()
+	}
+	if request.GetBody == nil {
+		b.WriteString("\n(body not traced)\n")
+		return b.String()
+	}
+	body, err := request.GetBody()
+	if err != nil {
+		fmt.Fprintf(&b, "\n(body not traced: %s)\n", err)
+		return b.String()
+	}
+	defer body.Close()
+	dumpTraceBody(&b, request.Header.Get("Content-Type"), body)
+	return b.String()
+}
+
+// dumpTraceResponse formats a response. The start of its body is read, and
+// put back in front of the rest.
+func dumpTraceResponse(response *http.Response) string {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%s\n", response.Status)
+	dumpTraceHeader(&b, response.Header)
+
+	contentType := response.Header.Get("Content-Type")
+	if !traceableContentType(contentType) {
+		fmt.Fprintf(&b, "\n(body of type %s not traced)\n", contentType)
+		return b.String()
+	}
+	start, err := ioutil.ReadAll(io.LimitReader(response.Body, lib.MaxTraceBody+1))
+	response.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(start), response.Body), response.Body}
+	if err != nil {
+		fmt.Fprintf(&b, "\n(body not traced: %s)\n", err)
+		return b.String()
+	}
+	dumpTraceBody(&b, contentType, bytes.NewReader(start))
+	return b.String()
+}
+
+func dumpTraceHeader(b *bytes.Buffer, header http.Header) {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		value := strings.Join(header[name], ", ")
+		if traceRedactedHeaders[name] {
+			value = "REDACTED"
+		}
+		fmt.Fprintf(b, "%s: %s\n", name, value)
+	}
+}
+
+func dumpTraceBody(b *bytes.Buffer, contentType string, body io.Reader) {
+	if !traceableContentType(contentType) {
+		fmt.Fprintf(b, "\n(body of type %s not traced)\n", contentType)
+		return
+	}
+	start, err := ioutil.ReadAll(io.LimitReader(body, lib.MaxTraceBody+1))
+	if err != nil {
+		fmt.Fprintf(b, "\n(body not traced: %s)\n", err)
+		return
+	}
+	fmt.Fprintf(b, "\n%s\n", lib.TruncateTrace(start))
+}
+
+// traceableContentType tells whether a body is text worth tracing, unlike
+// the documents of jobs.
+func traceableContentType(contentType string) bool {
+	return contentType == "" || strings.HasPrefix(contentType, "text/") ||
+		strings.Contains(contentType, "json") || strings.Contains(contentType, "x-www-form-urlencoded")
+}
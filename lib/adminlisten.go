@@ -0,0 +1,91 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package lib
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+)
+
+// IsLoopbackAddress tells whether a listen address, like localhost:6060,
+// only accepts connections from this host. Addresses without a host, like
+// :6060, accept them from every interface.
+func IsLoopbackAddress(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// NewMutualTLSConfig serves the certificate and key in certFile and keyFile,
+// to clients that present certificates signed by the CAs in clientCAFile,
+// limited by the TLS policy, and to TLS 1.2 or later.
+func NewMutualTLSConfig(certFile, keyFile, clientCAFile string) (*tls.Config, error) {
+	certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load TLS certificate %s: %s", certFile, err)
+	}
+	b, err := ioutil.ReadFile(clientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read client CA file: %s", err)
+	}
+	clientCAs := x509.NewCertPool()
+	if !clientCAs.AppendCertsFromPEM(b) {
+		return nil, fmt.Errorf("Client CA file %s has no PEM certificates", clientCAFile)
+	}
+
+	config := TLSConfig()
+	config.Certificates = []tls.Certificate{certificate}
+	config.ClientCAs = clientCAs
+	config.ClientAuth = tls.RequireAndVerifyClientCert
+	if config.MinVersion < tls.VersionTLS12 {
+		config.MinVersion = tls.VersionTLS12
+	}
+	return config, nil
+}
+
+// ListenAdmin listens to address for an admin listener, like pprof's. When
+// certFile, keyFile and clientCAFile are set, clients must present a
+// certificate signed by a client CA. They must be set when address isn't a
+// loopback address, so that only trusted clients manage the connector
+// remotely.
+func ListenAdmin(address, certFile, keyFile, clientCAFile string) (net.Listener, error) {
+	mutualTLS := certFile != "" || keyFile != "" || clientCAFile != ""
+	if mutualTLS && (certFile == "" || keyFile == "" || clientCAFile == "") {
+		return nil, errors.New("Mutual TLS needs a certificate, a key and a client CA file")
+	}
+	if !mutualTLS && !IsLoopbackAddress(address) {
+		return nil, fmt.Errorf("Address %s accepts remote connections, so it needs mutual TLS; set a certificate, a key and a client CA file, or listen to a loopback address", address)
+	}
+
+	var config *tls.Config
+	if mutualTLS {
+		var err error
+		if config, err = NewMutualTLSConfig(certFile, keyFile, clientCAFile); err != nil {
+			return nil, err
+		}
+	}
+	listener, err := Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	if config != nil {
+		listener = tls.NewListener(listener, config)
+	}
+	return listener, nil
+}
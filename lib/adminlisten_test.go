@@ -0,0 +1,151 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package lib
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestIsLoopbackAddress(t *testing.T) {
+	for address, expected := range map[string]bool{
+		"localhost:6060":   true,
+		"127.0.0.1:6060":   true,
+		"[::1]:6060":       true,
+		":6060":            false,
+		"0.0.0.0:6060":     false,
+		"192.168.1.2:6060": false,
+		"admin.example:80": false,
+		"localhost":        false,
+	} {
+		if IsLoopbackAddress(address) != expected {
+			t.Logf("expected %s to be loopback %t", address, expected)
+			t.Fail()
+		}
+	}
+}
+
+// testCertificate creates a certificate, signed by parent, or self-signed
+// when parent is nil.
+func testCertificate(t *testing.T, name string, isCA bool, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey, tls.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certificate, key, tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func writePEM(t *testing.T, filename, blockType string, b []byte) {
+	if err := ioutil.WriteFile(filename, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: b}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestListenAdmin(t *testing.T) {
+	if _, err := ListenAdmin(":0", "", "", ""); err == nil {
+		t.Log("expected a remote address without mutual TLS to be refused")
+		t.Fail()
+	}
+	if _, err := ListenAdmin("127.0.0.1:0", "cert.pem", "", ""); err == nil {
+		t.Log("expected mutual TLS without a key and client CA to be refused")
+		t.Fail()
+	}
+
+	dir, err := ioutil.TempDir("", "adminlisten")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ca, caKey, _ := testCertificate(t, "ca", true, nil, nil)
+	_, serverKey, server := testCertificate(t, "server", false, ca, caKey)
+	_, _, client := testCertificate(t, "client", false, ca, caKey)
+	_, _, stranger := testCertificate(t, "stranger", false, nil, nil)
+
+	certFile, keyFile, caFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"), filepath.Join(dir, "ca.pem")
+	writePEM(t, certFile, "CERTIFICATE", server.Certificate[0])
+	keyDER, err := x509.MarshalECPrivateKey(serverKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePEM(t, keyFile, "EC PRIVATE KEY", keyDER)
+	writePEM(t, caFile, "CERTIFICATE", ca.Raw)
+
+	listener, err := ListenAdmin("127.0.0.1:0", certFile, keyFile, caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	for name, c := range map[string]struct {
+		certificates []tls.Certificate
+		accepted     bool
+	}{
+		"trusted client":        {[]tls.Certificate{client}, true},
+		"untrusted client":      {[]tls.Certificate{stranger}, false},
+		"no client certificate": {nil, false},
+	} {
+		conn, err := tls.Dial("tcp", listener.Addr().String(), &tls.Config{RootCAs: roots, Certificates: c.certificates})
+		if err == nil {
+			// With TLS 1.3, the server's refusal arrives with the first read.
+			_, err = conn.Read(make([]byte, 1))
+			conn.Close()
+		}
+		if accepted := err == nil || err == io.EOF; accepted != c.accepted {
+			t.Logf("expected the %s to be accepted %t, got %v", name, c.accepted, err)
+			t.Fail()
+		}
+	}
+}
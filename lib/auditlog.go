@@ -0,0 +1,338 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package lib
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// auditLogTailBytes is how much of the end of an audit log is read to find
+// its last entry; entries are much smaller.
+const auditLogTailBytes = 64 * 1024
+
+// auditDetailMaxBytes is the longest detail of an audit entry.
+const auditDetailMaxBytes = 4096
+
+// AuditEvent is a kind of security-relevant event.
+type AuditEvent string
+
+const (
+	// AuditEventCredentials is a change of the connector's OAuth
+	// credentials.
+	AuditEventCredentials AuditEvent = "credentials"
+	// AuditEventShare is a printer shared with, or unshared from, a user,
+	// group or domain.
+	AuditEventShare AuditEvent = "share"
+	// AuditEventPolicy is a change of a policy, like a quota or color rule,
+	// or an override of the options or settings that policies set.
+	AuditEventPolicy AuditEvent = "policy"
+	// AuditEventAdmin is an action that an administrator took through the
+	// monitor.
+	AuditEventAdmin AuditEvent = "admin"
+	// AuditEventRecovery is the removal of a partial entry, left by a write
+	// that was interrupted by a crash.
+	AuditEventRecovery AuditEvent = "recovery"
+)
+
+// AuditEntry is one event of an AuditLog. Hash is the SHA-256 of the
+// previous entry's hash and this entry, keyed by the log's key when it has
+// one, so that changing or removing an entry breaks the chain of every
+// entry after it.
+type AuditEntry struct {
+	Sequence uint64     `json:"seq"`
+	Time     time.Time  `json:"time"`
+	Program  string     `json:"program"`
+	User     string     `json:"user"`
+	Event    AuditEvent `json:"event"`
+	Detail   string     `json:"detail"`
+	PrevHash string     `json:"prev_hash"`
+	Hash     string     `json:"hash"`
+}
+
+// hash gets the hash of an entry, chained to the hash of the entry before
+// it: an HMAC-SHA256 with key, or a SHA-256 when key is empty.
+func (e AuditEntry) hash(key []byte) (string, error) {
+	e.Hash = ""
+	b, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+	var h hash.Hash
+	if len(key) > 0 {
+		h = hmac.New(sha256.New, key)
+	} else {
+		h = sha256.New()
+	}
+	io.WriteString(h, e.PrevHash)
+	h.Write(b)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// AuditCheckpoint is the head of an audit log: the sequence and hash of
+// its last entry. Kept apart from the log, like in the connector's log or
+// in an export, it shows whether the newest entries were removed later, or
+// the log was rewritten and hashed again from an earlier entry.
+type AuditCheckpoint struct {
+	Sequence uint64    `json:"seq"`
+	Hash     string    `json:"hash"`
+	Time     time.Time `json:"time"`
+}
+
+// AuditLog appends security-relevant events, like credential changes,
+// sharing, policy overrides and admin actions, to a file of JSON lines,
+// whose entries are chained by their hashes. The connector and the
+// connector util append to the same file, so each entry is appended under
+// a file lock. A nil AuditLog records nothing.
+type AuditLog struct {
+	filename string
+	key      []byte
+	program  string
+	user     string
+	mutex    sync.Mutex
+}
+
+// NewAuditLog appends to filename, as program, run by the current user.
+// When keyFilename isn't empty, entries are hashed with the secret key in
+// it, so that whoever can write the log, but can't read the key, can't
+// rewrite it undetected. Returns nil when filename is empty.
+func NewAuditLog(filename, keyFilename, program string) (*AuditLog, error) {
+	if filename == "" {
+		return nil, nil
+	}
+	key, err := ReadAuditKey(keyFilename)
+	if err != nil {
+		return nil, err
+	}
+	username := strconv.Itoa(os.Getuid())
+	if u, err := user.Current(); err == nil {
+		username = u.Username
+	}
+	return &AuditLog{filename: filename, key: key, program: program, user: username}, nil
+}
+
+// ReadAuditKey reads the key of an audit log from a file; empty when
+// filename is.
+func ReadAuditKey(filename string) ([]byte, error) {
+	if filename == "" {
+		return nil, nil
+	}
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read audit log key: %s", err)
+	}
+	key := bytes.TrimSpace(b)
+	if len(key) < 16 {
+		return nil, fmt.Errorf("Audit log key file %s has fewer than 16 bytes", filename)
+	}
+	return key, nil
+}
+
+// open opens and locks the log, for Record and Head.
+func (l *AuditLog) open() (*os.File, error) {
+	f, err := os.OpenFile(l.filename, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err = lockFile(f); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("Failed to lock audit log %s: %s", l.filename, err)
+	}
+	return f, nil
+}
+
+func (l *AuditLog) close(f *os.File) {
+	unlockFile(f)
+	f.Close()
+}
+
+// Head gets the checkpoint of the last entry of the log, which has no
+// entries when its hash is empty.
+func (l *AuditLog) Head() (AuditCheckpoint, error) {
+	if l == nil {
+		return AuditCheckpoint{}, nil
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	f, err := l.open()
+	if err != nil {
+		return AuditCheckpoint{}, err
+	}
+	defer l.close(f)
+
+	last, _, err := lastAuditEntry(f)
+	if err != nil || last == nil {
+		return AuditCheckpoint{}, err
+	}
+	return AuditCheckpoint{Sequence: last.Sequence, Hash: last.Hash, Time: last.Time}, nil
+}
+
+// Record appends an event, with details, to the log.
+func (l *AuditLog) Record(event AuditEvent, detail string) error {
+	if l == nil {
+		return nil
+	}
+	if len(detail) > auditDetailMaxBytes {
+		detail = detail[:auditDetailMaxBytes]
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	f, err := l.open()
+	if err != nil {
+		return err
+	}
+	defer l.close(f)
+
+	last, partial, err := lastAuditEntry(f)
+	if err != nil {
+		return fmt.Errorf("Failed to read audit log %s: %s", l.filename, err)
+	}
+	if partial > 0 {
+		// A crash interrupted a write. Remove its partial entry, so that
+		// the log can go on, and record that it was removed.
+		info, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		if err = f.Truncate(info.Size() - partial); err != nil {
+			return fmt.Errorf("Failed to remove the partial entry of audit log %s: %s", l.filename, err)
+		}
+		if last, err = l.append(f, last, AuditEventRecovery, fmt.Sprintf("Removed a partial entry of %d bytes, left by an interrupted write", partial)); err != nil {
+			return err
+		}
+	}
+	_, err = l.append(f, last, event, detail)
+	return err
+}
+
+// append appends an entry after last, which is nil when the log is empty.
+func (l *AuditLog) append(f *os.File, last *AuditEntry, event AuditEvent, detail string) (*AuditEntry, error) {
+	e := AuditEntry{
+		Time:    time.Now().UTC(),
+		Program: l.program,
+		User:    l.user,
+		Event:   event,
+		Detail:  detail,
+	}
+	if last != nil {
+		e.Sequence = last.Sequence + 1
+		e.PrevHash = last.Hash
+	}
+	var err error
+	if e.Hash, err = e.hash(l.key); err != nil {
+		return nil, err
+	}
+
+	b, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = f.Write(append(b, '\n')); err != nil {
+		return nil, err
+	}
+	return &e, f.Sync()
+}
+
+// lastAuditEntry reads the last complete entry of an audit log; nil when
+// it has none. partial is the length of the partial line after it, left by
+// an interrupted write.
+func lastAuditEntry(f *os.File) (last *AuditEntry, partial int64, err error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+	offset := info.Size() - auditLogTailBytes
+	if offset < 0 {
+		offset = 0
+	}
+	tail := make([]byte, info.Size()-offset)
+	if _, err = f.ReadAt(tail, offset); err != nil && err != io.EOF {
+		return nil, 0, err
+	}
+
+	end := bytes.LastIndexByte(tail, '\n')
+	partial = int64(len(tail) - end - 1)
+	if end < 0 {
+		if offset > 0 {
+			return nil, 0, fmt.Errorf("The last %d bytes have no complete entry", len(tail))
+		}
+		return nil, partial, nil
+	}
+	line := tail[bytes.LastIndexByte(tail[:end], '\n')+1 : end]
+	var e AuditEntry
+	if err = json.Unmarshal(line, &e); err != nil {
+		return nil, 0, fmt.Errorf("The last entry is not valid: %s", err)
+	}
+	return &e, partial, nil
+}
+
+// ReadAuditLog reads the entries of an audit log, and verifies their chain,
+// hashed with key, which is empty when the log has none. When an entry has
+// been changed, or entries have been removed or inserted, returns the
+// entries before it, and an error that says which.
+func ReadAuditLog(r io.Reader, key []byte) ([]AuditEntry, error) {
+	var entries []AuditEntry
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, auditLogTailBytes), auditLogTailBytes)
+	for line := 1; scanner.Scan(); line++ {
+		var e AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return entries, fmt.Errorf("Line %d is not an audit entry: %s", line, err)
+		}
+
+		var prevHash string
+		var sequence uint64
+		if len(entries) > 0 {
+			prevHash = entries[len(entries)-1].Hash
+			sequence = entries[len(entries)-1].Sequence + 1
+		}
+		if e.Sequence != sequence || e.PrevHash != prevHash {
+			return entries, fmt.Errorf("Line %d doesn't follow the entry before it; entries were removed or inserted", line)
+		}
+		if h, err := e.hash(key); err != nil || !hmac.Equal([]byte(h), []byte(e.Hash)) {
+			return entries, fmt.Errorf("Line %d doesn't match its hash; it was changed, or hashed with another key", line)
+		}
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
+
+// VerifyAuditCheckpoint verifies that the verified entries of an audit log
+// still have a checkpoint that was taken earlier: that the newest entries
+// at the time weren't removed, and that the log wasn't rewritten from an
+// earlier entry.
+func VerifyAuditCheckpoint(entries []AuditEntry, checkpoint AuditCheckpoint) error {
+	if checkpoint.Hash == "" {
+		return nil
+	}
+	if checkpoint.Sequence >= uint64(len(entries)) {
+		return fmt.Errorf("The log has %d entries, but had entry %d at the checkpoint; entries were removed", len(entries), checkpoint.Sequence)
+	}
+	if e := entries[checkpoint.Sequence]; !strings.EqualFold(e.Hash, checkpoint.Hash) {
+		return fmt.Errorf("Entry %d doesn't have the hash of the checkpoint; the log was rewritten", checkpoint.Sequence)
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package lib
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAuditLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "auditlog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "audit.log")
+
+	if err = (*AuditLog)(nil).Record(AuditEventAdmin, "nothing"); err != nil {
+		t.Logf("expected the nil log to record nothing, got %s", err)
+		t.Fail()
+	}
+
+	// Two logs of the same file, like the connector's and the util's, share
+	// one chain.
+	connector, err := NewAuditLog(filename, "", "connector")
+	if err != nil {
+		t.Fatal(err)
+	}
+	util, err := NewAuditLog(filename, "", "util")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range []struct {
+		l      *AuditLog
+		event  AuditEvent
+		detail string
+	}{
+		{connector, AuditEventShare, "Shared printer 1 with alice@example.com as USER"},
+		{util, AuditEventCredentials, "Replaced the robot account credentials"},
+		{connector, AuditEventPolicy, "Set the daily quota of printer 1 to 100 pages"},
+	} {
+		if err = r.l.Record(r.event, r.detail); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := ReadAuditLog(bytes.NewReader(b), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 || entries[1].Program != "util" || entries[2].Sequence != 2 || entries[2].PrevHash != entries[1].Hash {
+		t.Logf("expected 3 chained entries, got %+v", entries)
+		t.Fail()
+	}
+	head, err := connector.Head()
+	if err != nil || head.Sequence != 2 || head.Hash != entries[2].Hash {
+		t.Logf("expected the head to be the last entry, got %+v, %v", head, err)
+		t.Fail()
+	}
+
+	lines := strings.SplitAfter(string(b), "\n")
+	for name, tampered := range map[string]string{
+		"changed":   lines[0] + strings.Replace(lines[1], "credentials", "password", 1) + lines[2],
+		"removed":   lines[0] + lines[2],
+		"reordered": lines[1] + lines[0] + lines[2],
+	} {
+		if entries, err := ReadAuditLog(strings.NewReader(tampered), nil); err == nil {
+			t.Logf("expected the %s entry to break the chain, got %d entries", name, len(entries))
+			t.Fail()
+		}
+	}
+
+	// Removing the newest entries keeps the chain, but not the checkpoint.
+	truncated, err := ReadAuditLog(strings.NewReader(lines[0]+lines[1]), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = VerifyAuditCheckpoint(truncated, head); err == nil {
+		t.Log("expected removed entries to fail the checkpoint")
+		t.Fail()
+	}
+	if err = VerifyAuditCheckpoint(entries, head); err != nil {
+		t.Logf("expected the log to pass its own checkpoint, got %s", err)
+		t.Fail()
+	}
+
+	// A crash that left a partial entry is recovered from: the partial
+	// entry is removed, and its removal recorded.
+	if err = ioutil.WriteFile(filename, append(b, `{"seq":3,"ti`...), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = connector.Record(AuditEventAdmin, "refresh-printers"); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err = ReadAuditLog(f, nil)
+	f.Close()
+	if err != nil || len(entries) != 5 || entries[3].Event != AuditEventRecovery || entries[4].Detail != "refresh-printers" {
+		t.Logf("expected the partial entry to be replaced by a recovery entry, got %+v, %v", entries, err)
+		t.Fail()
+	}
+	if err = VerifyAuditCheckpoint(entries, head); err != nil {
+		t.Logf("expected the recovered log to pass the earlier checkpoint, got %s", err)
+		t.Fail()
+	}
+}
+
+func TestAuditLogKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "auditlog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename, keyFilename := filepath.Join(dir, "audit.log"), filepath.Join(dir, "audit.key")
+
+	if err = ioutil.WriteFile(keyFilename, []byte("short\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = NewAuditLog(filename, keyFilename, "connector"); err == nil {
+		t.Log("expected a short key to be refused")
+		t.Fail()
+	}
+	if err = ioutil.WriteFile(keyFilename, []byte("0123456789abcdef0123456789abcdef\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	l, err := NewAuditLog(filename, keyFilename, "connector")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, detail := range []string{"first", "second"} {
+		if err = l.Record(AuditEventAdmin, detail); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ReadAuditKey(keyFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ReadAuditLog(bytes.NewReader(b), key); err != nil {
+		t.Logf("expected the keyed log to verify with its key, got %s", err)
+		t.Fail()
+	}
+
+	// Without the key, a rewritten log can't be hashed to match.
+	rewritten, err := NewAuditLog(filepath.Join(dir, "rewritten.log"), "", "connector")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, detail := range []string{"first", "forged"} {
+		if err = rewritten.Record(AuditEventAdmin, detail); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b, err = ioutil.ReadFile(filepath.Join(dir, "rewritten.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ReadAuditLog(bytes.NewReader(b), key); err == nil {
+		t.Log("expected a log rewritten without the key to fail verification")
+		t.Fail()
+	}
+}
@@ -0,0 +1,84 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package lib
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Tags that tell support which binary and configuration registered a
+// printer.
+const (
+	BuildCommitTag   = "connector-build-commit"
+	BuildFeaturesTag = "connector-build-features"
+	FeatureFlagsTag  = "connector-feature-flags"
+)
+
+// To be populated by something like:
+// go install -ldflags "-X github.com/google/cloud-print-connector/lib.BuildCommit=`git rev-parse --short HEAD`"
+var BuildCommit = "unknown"
+
+// buildInfo holds the optional parts that are built into this binary, like
+// cups or privet-avahi, and the features that the config enables.
+var buildInfo struct {
+	mutex        sync.Mutex
+	features     []string
+	featureFlags []string
+}
+
+// AddBuildFeature records that an optional part, which is built only on some
+// platforms, is in this binary. Packages call it from init.
+func AddBuildFeature(feature string) {
+	buildInfo.mutex.Lock()
+	defer buildInfo.mutex.Unlock()
+
+	buildInfo.features = append(buildInfo.features, feature)
+	sort.Strings(buildInfo.features)
+}
+
+// SetFeatureFlags records the features that the config enables, before the
+// native print system is created.
+func SetFeatureFlags(flags []string) {
+	buildInfo.mutex.Lock()
+	defer buildInfo.mutex.Unlock()
+
+	buildInfo.featureFlags = flags
+}
+
+// BuildInfoTags gets the build commit, built features and feature flags, as
+// printer tags.
+func BuildInfoTags() map[string]string {
+	buildInfo.mutex.Lock()
+	defer buildInfo.mutex.Unlock()
+
+	return map[string]string{
+		BuildCommitTag:   BuildCommit,
+		BuildFeaturesTag: strings.Join(buildInfo.features, ","),
+		FeatureFlagsTag:  strings.Join(buildInfo.featureFlags, ","),
+	}
+}
+
+// enabledFlags gets the names of the flags that are true, sorted.
+func enabledFlags(flags map[string]bool) []string {
+	enabled := make([]string, 0, len(flags))
+	for name, on := range flags {
+		if on {
+			enabled = append(enabled, name)
+		}
+	}
+	sort.Strings(enabled)
+	return enabled
+}
+
+// isTrue tells whether an optional config flag is set and true.
+func isTrue(b *bool) bool {
+	return b != nil && *b
+}
@@ -0,0 +1,37 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package lib
+
+import "testing"
+
+func TestBuildInfoTags(t *testing.T) {
+	AddBuildFeature("test-b")
+	AddBuildFeature("test-a")
+	SetFeatureFlags(enabledFlags(map[string]bool{
+		"on":       true,
+		"off":      false,
+		"also_on":  isTrue(PointerToBool(true)),
+		"nil_flag": isTrue(nil),
+	}))
+	defer SetFeatureFlags(nil)
+
+	tags := BuildInfoTags()
+	if tags[BuildCommitTag] != BuildCommit {
+		t.Logf("expected commit %s, got %s", BuildCommit, tags[BuildCommitTag])
+		t.Fail()
+	}
+	if tags[BuildFeaturesTag] != "test-a,test-b" {
+		t.Logf("expected sorted features test-a,test-b, got %s", tags[BuildFeaturesTag])
+		t.Fail()
+	}
+	if tags[FeatureFlagsTag] != "also_on,on" {
+		t.Logf("expected flags also_on,on, got %s", tags[FeatureFlagsTag])
+		t.Fail()
+	}
+}
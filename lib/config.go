@@ -10,7 +10,6 @@ package lib
 
 import (
 	"encoding/json"
-	"io/ioutil"
 	"reflect"
 	"runtime"
 
@@ -55,19 +54,34 @@ func GetConfig(context *cli.Context) (*Config, string, error) {
 		return &DefaultConfig, "", nil
 	}
 
-	configRaw, err := ioutil.ReadFile(cf)
+	// A config file that was cut short falls back to its previous version.
+	configRaw, cf, err := ReadStateFile(cf, validJSON)
 	if err != nil {
 		return nil, "", err
 	}
 
+	// Same config as a map so that we can detect missing keys.
+	var configMap map[string]interface{}
+	if err = json.Unmarshal(configRaw, &configMap); err != nil {
+		return nil, "", err
+	}
+
+	// Read old config files with current keys, even when they can't be
+	// migrated in place.
+	if report, err := migrateConfigMap(configMap); err != nil {
+		return nil, "", err
+	} else if len(report) > 0 {
+		if configRaw, err = json.Marshal(configMap); err != nil {
+			return nil, "", err
+		}
+	}
+
 	config := new(Config)
 	if err = json.Unmarshal(configRaw, config); err != nil {
 		return nil, "", err
 	}
 
-	// Same config as a map so that we can detect missing keys.
-	var configMap map[string]interface{}
-	if err = json.Unmarshal(configRaw, &configMap); err != nil {
+	if _, err = ProfileDefaults(config.Profile); err != nil {
 		return nil, "", err
 	}
 
@@ -78,81 +92,95 @@ func GetConfig(context *cli.Context) (*Config, string, error) {
 
 // ToFile writes this Config object to the config file indicated by ConfigFile.
 func (c *Config) ToFile(context *cli.Context) (string, error) {
-	b, err := json.MarshalIndent(c, "", "  ")
+	// Config always has the current keys.
+	v := *c
+	v.SchemaVersion = ConfigSchemaVersion
+	b, err := json.MarshalIndent(&v, "", "  ")
 	if err != nil {
 		return "", err
 	}
 
 	cf, _ := getConfigFilename(context)
-	if err = ioutil.WriteFile(cf, b, 0600); err != nil {
+	if err = WriteStateFile(cf, b, 0600); err != nil {
 		return "", err
 	}
 	return cf, nil
 }
 
+// validJSON accepts a JSON object.
+func validJSON(b []byte) error {
+	var m map[string]interface{}
+	return json.Unmarshal(b, &m)
+}
+
 func (c *Config) commonSparse(context *cli.Context) *Config {
 	s := *c
+	d, _ := ProfileDefaults(c.Profile)
 
-	if s.XMPPServer == DefaultConfig.XMPPServer {
+	if s.XMPPServer == d.XMPPServer {
 		s.XMPPServer = ""
 	}
 	if !context.IsSet("xmpp-port") &&
-		s.XMPPPort == DefaultConfig.XMPPPort {
+		s.XMPPPort == d.XMPPPort {
 		s.XMPPPort = 0
 	}
 	if !context.IsSet("xmpp-ping-timeout") &&
-		s.XMPPPingTimeout == DefaultConfig.XMPPPingTimeout {
+		s.XMPPPingTimeout == d.XMPPPingTimeout {
 		s.XMPPPingTimeout = ""
 	}
 	if !context.IsSet("xmpp-ping-interval") &&
-		s.XMPPPingInterval == DefaultConfig.XMPPPingInterval {
+		s.XMPPPingInterval == d.XMPPPingInterval {
 		s.XMPPPingInterval = ""
 	}
-	if s.GCPBaseURL == DefaultConfig.GCPBaseURL {
+	if s.GCPBaseURL == d.GCPBaseURL {
 		s.GCPBaseURL = ""
 	}
-	if s.GCPOAuthClientID == DefaultConfig.GCPOAuthClientID {
+	if s.GCPOAuthClientID == d.GCPOAuthClientID {
 		s.GCPOAuthClientID = ""
 	}
-	if s.GCPOAuthClientSecret == DefaultConfig.GCPOAuthClientSecret {
+	if s.GCPOAuthClientSecret == d.GCPOAuthClientSecret {
 		s.GCPOAuthClientSecret = ""
 	}
-	if s.GCPOAuthAuthURL == DefaultConfig.GCPOAuthAuthURL {
+	if s.GCPOAuthAuthURL == d.GCPOAuthAuthURL {
 		s.GCPOAuthAuthURL = ""
 	}
-	if s.GCPOAuthTokenURL == DefaultConfig.GCPOAuthTokenURL {
+	if s.GCPOAuthTokenURL == d.GCPOAuthTokenURL {
 		s.GCPOAuthTokenURL = ""
 	}
 	if !context.IsSet("gcp-max-concurrent-downloads") &&
-		s.GCPMaxConcurrentDownloads == DefaultConfig.GCPMaxConcurrentDownloads {
+		s.GCPMaxConcurrentDownloads == d.GCPMaxConcurrentDownloads {
 		s.GCPMaxConcurrentDownloads = 0
 	}
+	if !context.IsSet("gcp-max-concurrent-fetches") &&
+		s.GCPMaxConcurrentFetches == d.GCPMaxConcurrentFetches {
+		s.GCPMaxConcurrentFetches = 0
+	}
 	if !context.IsSet("native-job-queue-size") &&
-		s.NativeJobQueueSize == DefaultConfig.NativeJobQueueSize {
+		s.NativeJobQueueSize == d.NativeJobQueueSize {
 		s.NativeJobQueueSize = 0
 	}
 	if !context.IsSet("native-printer-poll-interval") &&
-		s.NativePrinterPollInterval == DefaultConfig.NativePrinterPollInterval {
+		s.NativePrinterPollInterval == d.NativePrinterPollInterval {
 		s.NativePrinterPollInterval = ""
 	}
 	if !context.IsSet("cups-job-full-username") &&
-		reflect.DeepEqual(s.CUPSJobFullUsername, DefaultConfig.CUPSJobFullUsername) {
+		reflect.DeepEqual(s.CUPSJobFullUsername, d.CUPSJobFullUsername) {
 		s.CUPSJobFullUsername = nil
 	}
 	if !context.IsSet("prefix-job-id-to-job-title") &&
-		reflect.DeepEqual(s.PrefixJobIDToJobTitle, DefaultConfig.PrefixJobIDToJobTitle) {
+		reflect.DeepEqual(s.PrefixJobIDToJobTitle, d.PrefixJobIDToJobTitle) {
 		s.PrefixJobIDToJobTitle = nil
 	}
 	if !context.IsSet("display-name-prefix") &&
-		s.DisplayNamePrefix == DefaultConfig.DisplayNamePrefix {
+		s.DisplayNamePrefix == d.DisplayNamePrefix {
 		s.DisplayNamePrefix = ""
 	}
 	if !context.IsSet("local-port-low") &&
-		s.LocalPortLow == DefaultConfig.LocalPortLow {
+		s.LocalPortLow == d.LocalPortLow {
 		s.LocalPortLow = 0
 	}
 	if !context.IsSet("local-port-high") &&
-		s.LocalPortHigh == DefaultConfig.LocalPortHigh {
+		s.LocalPortHigh == d.LocalPortHigh {
 		s.LocalPortHigh = 0
 	}
 
@@ -161,72 +189,76 @@ func (c *Config) commonSparse(context *cli.Context) *Config {
 
 func (c *Config) commonBackfill(configMap map[string]interface{}) *Config {
 	b := *c
+	d, _ := ProfileDefaults(c.Profile)
 
 	if _, exists := configMap["xmpp_server"]; !exists {
-		b.XMPPServer = DefaultConfig.XMPPServer
+		b.XMPPServer = d.XMPPServer
 	}
 	if _, exists := configMap["xmpp_port"]; !exists {
-		b.XMPPPort = DefaultConfig.XMPPPort
+		b.XMPPPort = d.XMPPPort
 	}
-	if _, exists := configMap["gcp_xmpp_ping_timeout"]; !exists {
-		b.XMPPPingTimeout = DefaultConfig.XMPPPingTimeout
+	if _, exists := configMap["xmpp_ping_timeout"]; !exists {
+		b.XMPPPingTimeout = d.XMPPPingTimeout
 	}
-	if _, exists := configMap["gcp_xmpp_ping_interval_default"]; !exists {
-		b.XMPPPingInterval = DefaultConfig.XMPPPingInterval
+	if _, exists := configMap["xmpp_ping_interval"]; !exists {
+		b.XMPPPingInterval = d.XMPPPingInterval
 	}
 	if _, exists := configMap["gcp_base_url"]; !exists {
-		b.GCPBaseURL = DefaultConfig.GCPBaseURL
+		b.GCPBaseURL = d.GCPBaseURL
 	}
 	if _, exists := configMap["gcp_oauth_client_id"]; !exists {
-		b.GCPOAuthClientID = DefaultConfig.GCPOAuthClientID
+		b.GCPOAuthClientID = d.GCPOAuthClientID
 	}
 	if _, exists := configMap["gcp_oauth_client_secret"]; !exists {
-		b.GCPOAuthClientSecret = DefaultConfig.GCPOAuthClientSecret
+		b.GCPOAuthClientSecret = d.GCPOAuthClientSecret
 	}
 	if _, exists := configMap["gcp_oauth_auth_url"]; !exists {
-		b.GCPOAuthAuthURL = DefaultConfig.GCPOAuthAuthURL
+		b.GCPOAuthAuthURL = d.GCPOAuthAuthURL
 	}
 	if _, exists := configMap["gcp_oauth_token_url"]; !exists {
-		b.GCPOAuthTokenURL = DefaultConfig.GCPOAuthTokenURL
+		b.GCPOAuthTokenURL = d.GCPOAuthTokenURL
 	}
 	if _, exists := configMap["gcp_max_concurrent_downloads"]; !exists {
-		b.GCPMaxConcurrentDownloads = DefaultConfig.GCPMaxConcurrentDownloads
+		b.GCPMaxConcurrentDownloads = d.GCPMaxConcurrentDownloads
+	}
+	if _, exists := configMap["gcp_max_concurrent_fetches"]; !exists {
+		b.GCPMaxConcurrentFetches = d.GCPMaxConcurrentFetches
 	}
-	if _, exists := configMap["cups_job_queue_size"]; !exists {
-		b.NativeJobQueueSize = DefaultConfig.NativeJobQueueSize
+	if _, exists := configMap["native_job_queue_size"]; !exists {
+		b.NativeJobQueueSize = d.NativeJobQueueSize
 	}
-	if _, exists := configMap["cups_printer_poll_interval"]; !exists {
-		b.NativePrinterPollInterval = DefaultConfig.NativePrinterPollInterval
+	if _, exists := configMap["native_printer_poll_interval"]; !exists {
+		b.NativePrinterPollInterval = d.NativePrinterPollInterval
 	}
-	if _, exists := configMap["cups_job_full_username"]; !exists {
-		b.CUPSJobFullUsername = DefaultConfig.CUPSJobFullUsername
+	if _, exists := configMap["job_full_username"]; !exists {
+		b.CUPSJobFullUsername = d.CUPSJobFullUsername
 	}
 	if _, exists := configMap["prefix_job_id_to_job_title"]; !exists {
-		b.PrefixJobIDToJobTitle = DefaultConfig.PrefixJobIDToJobTitle
+		b.PrefixJobIDToJobTitle = d.PrefixJobIDToJobTitle
 	}
 	if _, exists := configMap["display_name_prefix"]; !exists {
-		b.DisplayNamePrefix = DefaultConfig.DisplayNamePrefix
+		b.DisplayNamePrefix = d.DisplayNamePrefix
 	}
 	if _, exists := configMap["printer_blacklist"]; !exists {
-		b.PrinterBlacklist = DefaultConfig.PrinterBlacklist
+		b.PrinterBlacklist = d.PrinterBlacklist
 	}
 	if _, exists := configMap["printer_whitelist"]; !exists {
-		b.PrinterWhitelist = DefaultConfig.PrinterWhitelist
+		b.PrinterWhitelist = d.PrinterWhitelist
 	}
 	if _, exists := configMap["local_printing_enable"]; !exists {
-		b.LocalPrintingEnable = DefaultConfig.LocalPrintingEnable
+		b.LocalPrintingEnable = d.LocalPrintingEnable
 	}
 	if _, exists := configMap["cloud_printing_enable"]; !exists {
-		b.CloudPrintingEnable = DefaultConfig.CloudPrintingEnable
+		b.CloudPrintingEnable = d.CloudPrintingEnable
 	}
 	if _, exists := configMap["log_level"]; !exists {
-		b.LogLevel = DefaultConfig.LogLevel
+		b.LogLevel = d.LogLevel
 	}
 	if _, exists := configMap["local_port_low"]; !exists {
-		b.LocalPortLow = DefaultConfig.LocalPortLow
+		b.LocalPortLow = d.LocalPortLow
 	}
 	if _, exists := configMap["local_port_high"]; !exists {
-		b.LocalPortHigh = DefaultConfig.LocalPortHigh
+		b.LocalPortHigh = d.LocalPortHigh
 	}
 
 	return &b
@@ -13,6 +13,7 @@ import (
 	"path/filepath"
 	"reflect"
 
+	"github.com/google/cloud-print-connector/cdd"
 	"github.com/urfave/cli"
 	"launchpad.net/go-xdg/v0"
 )
@@ -23,13 +24,33 @@ const (
 	defaultConfigFilename = "gcp-cups-connector.config.json"
 )
 
+// CUPSServer is one of several CUPS servers whose printers the connector
+// serves.
+type CUPSServer struct {
+	// Address is host, host:port or the path of a domain socket.
+	Address string `json:"address"`
+	// Prefix starts the names of this server's printers, to keep them apart
+	// from the printers of the other servers.
+	Prefix string `json:"prefix"`
+	// MaxConnections limits the open connections to this server; zero is
+	// cups_max_connections.
+	MaxConnections uint `json:"max_connections,omitempty"`
+}
+
 type Config struct {
+	// Version of the config file keys; see ConfigSchemaVersion.
+	SchemaVersion uint `json:"config_schema_version"`
+
 	// Enable local discovery and printing.
 	LocalPrintingEnable bool `json:"local_printing_enable"`
 
 	// Enable cloud discovery and printing.
 	CloudPrintingEnable bool `json:"cloud_printing_enable"`
 
+	// Named bundle of defaults for keys that are missing: workstation,
+	// print-server, kiosk or container.
+	Profile string `json:"profile,omitempty"`
+
 	// Associated with root account. XMPP credential.
 	XMPPJID string `json:"xmpp_jid,omitempty"`
 
@@ -52,13 +73,10 @@ type Config struct {
 	XMPPPort uint16 `json:"xmpp_port,omitempty"`
 
 	// XMPP ping timeout (give up waiting after this time).
-	// TODO: Rename with "gcp_" removed.
-	XMPPPingTimeout string `json:"gcp_xmpp_ping_timeout,omitempty"`
+	XMPPPingTimeout string `json:"xmpp_ping_timeout,omitempty"`
 
 	// XMPP ping interval (time between ping attempts).
-	// TODO: Rename with "gcp_" removed.
-	// TODO: Rename with "_default" removed.
-	XMPPPingInterval string `json:"gcp_xmpp_ping_interval_default,omitempty"`
+	XMPPPingInterval string `json:"xmpp_ping_interval,omitempty"`
 
 	// GCP API URL prefix.
 	GCPBaseURL string `json:"gcp_base_url,omitempty"`
@@ -78,17 +96,17 @@ type Config struct {
 	// Maximum quantity of jobs (data) to download concurrently.
 	GCPMaxConcurrentDownloads uint `json:"gcp_max_concurrent_downloads,omitempty"`
 
+	// Maximum quantity of printers to fetch jobs for concurrently.
+	GCPMaxConcurrentFetches uint `json:"gcp_max_concurrent_fetches,omitempty"`
+
 	// CUPS job queue size, must be greater than zero.
-	// TODO: rename without cups_ prefix
-	NativeJobQueueSize uint `json:"cups_job_queue_size,omitempty"`
+	NativeJobQueueSize uint `json:"native_job_queue_size,omitempty"`
 
 	// Interval (eg 10s, 1m) between CUPS printer state polls.
-	// TODO: rename without cups_ prefix
-	NativePrinterPollInterval string `json:"cups_printer_poll_interval,omitempty"`
+	NativePrinterPollInterval string `json:"native_printer_poll_interval,omitempty"`
 
 	// Use the full username (joe@example.com) in job.
-	// TODO: rename without cups_ prefix
-	CUPSJobFullUsername *bool `json:"cups_job_full_username,omitempty"`
+	CUPSJobFullUsername *bool `json:"job_full_username,omitempty"`
 
 	// Add the job ID to the beginning of the job title. Useful for debugging.
 	PrefixJobIDToJobTitle *bool `json:"prefix_job_id_to_job_title,omitempty"`
@@ -102,6 +120,141 @@ type Config struct {
 	// Allow printers with native names.
 	PrinterWhitelist []string `json:"printer_whitelist,omitempty"`
 
+	// Largest job, in bytes, to print; zero is no limit.
+	MaxJobBytes int64 `json:"max_job_bytes,omitempty"`
+
+	// Most pages in a job to print; zero is no limit.
+	MaxJobPages uint `json:"max_job_pages,omitempty"`
+
+	// Longest time, in seconds, that a job may take to download and print before it is aborted; zero is no limit.
+	MaxJobSeconds uint `json:"max_job_seconds,omitempty"`
+
+	// Job limits of printers, by native name, that replace max_job_bytes, max_job_pages and max_job_seconds, and limit the download bytes per second of each printer (max_download_bytes_per_second).
+	PrinterJobLimits map[string]JobLimits `json:"printer_job_limits,omitempty"`
+
+	// Most bytes per second that all job downloads together may take; zero is no limit.
+	DownloadBytesPerSecond int64 `json:"download_bytes_per_second,omitempty"`
+
+	// How long (eg 1m) the capabilities and sharing of a printer, as GCP answered, are reused before GCP is asked again; empty asks every time.
+	GCPPrinterCacheTTL string `json:"gcp_printer_cache_ttl,omitempty"`
+
+	// Most requests per minute to GCP, as its quota allows; zero is no limit. Near the limit, the requests that jobs need go before printer updates.
+	GCPRequestsPerMinute uint `json:"gcp_requests_per_minute,omitempty"`
+
+	// Most bytes of downloaded job files kept after they print, to reprint them without downloading them again; zero keeps none.
+	JobCacheBytes int64 `json:"job_cache_bytes,omitempty"`
+
+	// Simulated printers registered in addition to the native printers, for load and integration testing; zero is none.
+	SimulatedPrinters uint `json:"simulated_printers,omitempty"`
+
+	// Capabilities of the simulated printers, as a CDD printer section; empty is a monochrome printer of letter and A4 paper.
+	SimulatedPrinterCapabilities *cdd.PrinterDescriptionSection `json:"simulated_printer_capabilities,omitempty"`
+
+	// Pages per minute that simulated printers print; zero prints each job at once.
+	SimulatedPrinterPagesPerMinute uint `json:"simulated_printer_pages_per_minute,omitempty"`
+
+	// Fraction of the jobs of simulated printers that fail partway, from 0 to 1.
+	SimulatedPrinterFailureRate float64 `json:"simulated_printer_failure_rate,omitempty"`
+
+	// Reject a document sent again to the same printer by the same user within this long (eg 1m); empty allows it.
+	DuplicateJobWindow string `json:"duplicate_job_window,omitempty"`
+
+	// Marker levels, in percent, at or below which supplies are low (warning) or exhausted (critical); zero critical is 10.
+	SupplyAlertThresholds SupplyThresholds `json:"supply_alert_thresholds"`
+
+	// Supply alert thresholds of printers, by native name, that replace supply_alert_thresholds.
+	PrinterSupplyAlertThresholds map[string]SupplyThresholds `json:"printer_supply_alert_thresholds,omitempty"`
+
+	// URL to post supply alerts to, as JSON, when a supply becomes low or exhausted; empty posts none.
+	SupplyAlertWebhookURL string `json:"supply_alert_webhook_url,omitempty"`
+
+	// Consecutive failed jobs after which a printer is quarantined: reported stopped, with its jobs held in the cloud; 0 never quarantines.
+	QuarantineAfterFailures uint `json:"quarantine_after_failures,omitempty"`
+
+	// How often to probe a quarantined printer, and release it once its native state is healthy; empty releases it only when cleared.
+	QuarantineProbeInterval string `json:"quarantine_probe_interval,omitempty"`
+
+	// URL to post quarantine alerts to, as JSON, when a printer is quarantined or released; empty posts none.
+	QuarantineWebhookURL string `json:"quarantine_webhook_url,omitempty"`
+
+	// JSON file of the messages that users see when their jobs fail, by kind of failure, to reword them or write them in the users' language; empty uses the built-in English messages.
+	JobErrorMessagesFile string `json:"job_error_messages_file,omitempty"`
+
+	// URL where the users of every printer get help, published as its support URL; {name} is replaced with the printer's native name, and {hostname} with the host of its device URI (eg http://{hostname}/ for its embedded web server). Empty is the connector's home page.
+	PrinterSupportURL string `json:"printer_support_url,omitempty"`
+
+	// Support URLs of printers, by native name, that replace printer_support_url, with the same placeholders.
+	PrinterSupportURLs map[string]string `json:"printer_support_urls,omitempty"`
+
+	// File to append security-relevant events to, like credential changes, sharing, policy overrides and monitor admin actions, as hash-chained JSON lines that export-audit-log verifies; empty records none.
+	AuditLogFile string `json:"audit_log_file,omitempty"`
+
+	// File of a secret key, of at least 16 bytes, that keys the hashes of audit_log_file, so that whoever can write the log, but can't read the key, can't rewrite it undetected; empty hashes without a key.
+	AuditLogKeyFile string `json:"audit_log_key_file,omitempty"`
+
+	// Most vendor state items to report per printer, after removing repeats, plus an "and N more" item; 0 is no limit.
+	VendorStateMaxItems uint `json:"vendor_state_max_items,omitempty"`
+
+	// URL of a JSON release feed to check for newer connector versions, which are reported in the logs, monitor and printer tags; empty never checks.
+	UpdateFeedURL string `json:"update_feed_url,omitempty"`
+
+	// Interval (eg 24h) between checks of update_feed_url.
+	UpdateCheckInterval string `json:"update_check_interval,omitempty"`
+
+	// File to download the binary of a newer connector version to, for an admin to install; empty downloads nothing.
+	UpdateStagingFilename string `json:"update_staging_filename,omitempty"`
+
+	// Slow background work, like PPD translation, while the 1-minute load average per CPU is above this; 0 never slows it.
+	BackgroundLoadThreshold float64 `json:"background_load_threshold,omitempty"`
+
+	// Address family, ipv4, ipv6, prefer-ipv4 or prefer-ipv6, that listeners and outbound connections are limited to or prefer; empty is dual-stack, in the system's order.
+	AddressFamily string `json:"address_family,omitempty"`
+
+	// TLS profile of every TLS client and server of the connector: empty is Go's defaults, or "fips" to use only TLS 1.2, and the cipher suites and curves that FIPS 140 approves, and refuse others below. Printers with old TLS may fail. It doesn't apply to the CUPS server connection, which libcups encrypts by the CUPS client.conf, or to LDAP group lookups, which ldapsearch encrypts by the OpenLDAP ldap.conf.
+	TLSProfile string `json:"tls_profile,omitempty"`
+
+	// Lowest TLS version (eg 1.2) of every TLS client and server; empty is the profile's default.
+	TLSMinVersion string `json:"tls_min_version,omitempty"`
+
+	// Highest TLS version (eg 1.2, to avoid TLS 1.3's cipher suites, which aren't configurable); empty is the profile's default. The fips profile refuses 1.3.
+	TLSMaxVersion string `json:"tls_max_version,omitempty"`
+
+	// TLS 1.2 cipher suites, by Go name (eg TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256), in order of preference; empty is the profile's defaults. Insecure suites are refused.
+	TLSCipherSuites []string `json:"tls_cipher_suites,omitempty"`
+
+	// TLS key exchange curves (P256, P384, P521 or X25519), in order of preference; empty is the profile's defaults.
+	TLSCurves []string `json:"tls_curves,omitempty"`
+
+	// Identifier of this deployment, like a site name, added to the User-Agent of requests to GCP, XMPP and printers; empty adds none.
+	UserAgentSite string `json:"user_agent_site,omitempty"`
+
+	// ID of this connector instance, published in printer tags; empty is the host name.
+	InstanceID string `json:"instance_id,omitempty"`
+
+	// File, reachable by every connector instance of a printer system, that decides which instance owns the printers; empty is no coordination.
+	InstanceLeaseFile string `json:"instance_lease_file,omitempty"`
+
+	// Role of this connector instance in a pair, active or standby, that takes over the printers when the other dies; empty is no failover.
+	FailoverRole string `json:"failover_role,omitempty"`
+
+	// Address, like :26000, on which to serve the failover heartbeat to the other instance of the pair.
+	FailoverListenAddress string `json:"failover_listen_address,omitempty"`
+
+	// URL, like http://host:26000, of the active instance's failover heartbeat; standby only.
+	FailoverPeerURL string `json:"failover_peer_url,omitempty"`
+
+	// How long the standby instance waits for the active instance's heartbeat before it takes over (eg 30s); empty is 30s.
+	FailoverTimeout string `json:"failover_timeout,omitempty"`
+
+	// Quantity of connector instances that split the printers between them by hash of their names; zero or one is no sharding.
+	ShardCount uint `json:"shard_count,omitempty"`
+
+	// Shard of printers, from zero to shard_count - 1, that this instance manages.
+	ShardIndex uint `json:"shard_index,omitempty"`
+
+	// Printers, by native name, that this instance manages, instead of a shard by hash.
+	ShardPrinters []string `json:"shard_printers,omitempty"`
+
 	// Least severity to log.
 	LogLevel string `json:"log_level"`
 
@@ -126,18 +279,159 @@ type Config struct {
 	// CUPS only: Filename of unix socket for connector-check to talk to connector.
 	MonitorSocketFilename string `json:"monitor_socket_filename,omitempty"`
 
+	// CUPS only: Filename of a second monitor socket, that any local user may connect to, which answers only stats, job-eta, supplies, quarantine and capabilities-diff, for dashboards that only read status; restrict who may use it by its directory's permissions. Empty opens none.
+	MonitorReadOnlySocketFilename string `json:"monitor_read_only_socket_filename,omitempty"`
+
+	// CUPS only: Quantity of job tickets to keep for the monitor's job-tickets request; zero keeps none.
+	JobTicketAuditMaxRecords uint `json:"job_ticket_audit_max_records,omitempty"`
+
+	// CUPS only: Longest time (eg 24h) to keep job tickets for the monitor's job-tickets request.
+	JobTicketAuditMaxAge string `json:"job_ticket_audit_max_age,omitempty"`
+
+	// CUPS only: Address (eg localhost:6060) to serve pprof profiles, and goroutine stacks by subsystem, on. Empty disables.
+	PprofAddress string `json:"pprof_address,omitempty"`
+
+	// CUPS only: PEM certificate for pprof_address to serve with mutual TLS, which it must when it isn't a loopback address (eg localhost:6060).
+	PprofTLSCertFile string `json:"pprof_tls_cert_file,omitempty"`
+
+	// CUPS only: PEM private key of pprof_tls_cert_file.
+	PprofTLSKeyFile string `json:"pprof_tls_key_file,omitempty"`
+
+	// CUPS only: PEM certificates of the CAs that must sign the client certificates of pprof_address.
+	PprofTLSClientCAFile string `json:"pprof_tls_client_ca_file,omitempty"`
+
 	// CUPS only: Maximum quantity of open CUPS connections.
 	CUPSMaxConnections uint `json:"cups_max_connections,omitempty"`
 
+	// CUPS only: quantity of open CUPS connections that the connection pool starts at, and shrinks to when idle; it grows under load up to cups_max_connections. Zero fixes the pool at cups_max_connections.
+	CUPSMinConnections uint `json:"cups_min_connections,omitempty"`
+
 	// CUPS only: timeout for opening a new connection.
 	CUPSConnectTimeout string `json:"cups_connect_timeout,omitempty"`
 
+	// CUPS only: CUPS servers to serve the printers of, each with its own connections and a prefix for its printer names; empty serves the CUPS server of client.conf or CUPS_SERVER.
+	CUPSServers []CUPSServer `json:"cups_servers,omitempty"`
+
+	// CUPS only: timeout for listing printers, once connected; the CUPS server must respond within it.
+	CUPSGetPrintersTimeout string `json:"cups_get_printers_timeout,omitempty"`
+
+	// CUPS only: timeout for downloading a PPD, once connected; the CUPS server must respond within it.
+	CUPSGetPPDTimeout string `json:"cups_get_ppd_timeout,omitempty"`
+
+	// CUPS only: deadline for fetching and translating the PPD of one printer; a printer whose PPD misses it is synced without its PPD's capabilities, keeping those it's registered with.
+	CUPSPPDTimeout string `json:"cups_ppd_timeout,omitempty"`
+
+	// CUPS only: largest PPD, in bytes, to translate; a printer with a larger PPD is synced without its PPD's capabilities. Zero is no limit.
+	CUPSPPDMaxBytes int64 `json:"cups_ppd_max_bytes,omitempty"`
+
+	// CUPS only: timeout for submitting a job, once connected; the CUPS server must respond within it.
+	CUPSPrintTimeout string `json:"cups_print_timeout,omitempty"`
+
+	// CUPS only: timeout for getting the state of a job, once connected; the CUPS server must respond within it.
+	CUPSJobStateTimeout string `json:"cups_job_state_timeout,omitempty"`
+
+	// CUPS only: quantity of printers to request from CUPS at a time; zero requests all at once.
+	CUPSPrinterPageSize uint `json:"cups_printer_page_size,omitempty"`
+
 	// CUPS only: printer attributes to copy to GCP.
 	CUPSPrinterAttributes []string `json:"cups_printer_attributes,omitempty"`
 
 	// CUPS only: non-standard PPD options to add as GCP vendor capabilities.
 	CUPSVendorPPDOptions []string `json:"cups_vendor_ppd_options,omitempty"`
 
+	// CUPS only: users who may raise the job-priority of their jobs above the printer's default, and groups of them, like group:<email> and ldap:<group DN>.
+	CUPSJobPriorityUsers []string `json:"cups_job_priority_users,omitempty"`
+
+	// CUPS only: printers whose jobs get orientation-requested from their PDF, for drivers that don't auto-rotate.
+	CUPSAutoRotatePrinters []string `json:"cups_auto_rotate_printers,omitempty"`
+
+	// CUPS only: drivers, by printer-make-and-model substring, that ignore fit-to-page; their jobs are scaled with Ghostscript.
+	CUPSFitToPageBrokenDrivers []string `json:"cups_fit_to_page_broken_drivers,omitempty"`
+
+	// CUPS only: turn the pages of duplex jobs that mix portrait and landscape to the orientation of most of their pages, with Ghostscript, so that every sheet binds on the requested edge.
+	CUPSNormalizeMixedOrientation bool `json:"cups_normalize_mixed_orientation,omitempty"`
+
+	// CUPS only: print Letter jobs on A4, and A4 jobs on Letter, scaled with Ghostscript, on printers that don't support or stock the size a job asks for.
+	CUPSPaperSizeSubstitution bool `json:"cups_paper_size_substitution,omitempty"`
+
+	// CUPS only: paper sizes, by PPD PageSize or media size name, that each printer has loaded, by printer name; other printers stock every size they support.
+	CUPSStockedPaperSizes map[string][]string `json:"cups_stocked_paper_sizes,omitempty"`
+
+	// CUPS only: the document format that GCP converts jobs to, by printer name, like image/pwg-raster for queues with flaky PDF interpreters.
+	CUPSFetchDocumentFormats map[string]string `json:"cups_fetch_document_formats,omitempty"`
+
+	// CUPS only: the document format, application/pdf or image/pwg-raster, that jobs are converted to with Ghostscript before they are sent to CUPS, by printer name.
+	CUPSSubmitDocumentFormats map[string]string `json:"cups_submit_document_formats,omitempty"`
+
+	// CUPS only: printers that print a cover sheet, with the job's title, owner and ID, before each job, with Ghostscript.
+	CUPSCoverSheetPrinters []string `json:"cups_cover_sheet_printers,omitempty"`
+
+	// CUPS only: printers that print a separator sheet between the copies of each job, with Ghostscript.
+	CUPSSeparatorSheetPrinters []string `json:"cups_separator_sheet_printers,omitempty"`
+
+	// CUPS only: let the tickets of every printer ask for cover and separator sheets.
+	CUPSJobSheetOptions bool `json:"cups_job_sheet_options,omitempty"`
+
+	// CUPS only: directory of <printer name>.json files of options to merge into the printer's next jobs.
+	CUPSJobOptionsOverrideDir string `json:"cups_job_options_override_dir,omitempty"`
+
+	// CUPS only: JSON file of quirks that fix the attributes, capabilities and options of printers, by printer-make-and-model regexp.
+	CUPSQuirksFile string `json:"cups_quirks_file,omitempty"`
+
+	// CUPS only: JSON file of rules that print in grayscale, by printer name regexp, for users who aren't allowed color, or outside the hours of color; a rule with allow_color exempts its printers from the rules after it.
+	CUPSColorPolicyFile string `json:"cups_color_policy_file,omitempty"`
+
+	// CUPS only: OAuth refresh token of a Google Workspace administrator, granting the connector's OAuth client the scope https://www.googleapis.com/auth/admin.directory.group.member.readonly, to resolve the group:<email> entries of cups_job_priority_users and of the allowed_users of color rules; empty resolves none.
+	CUPSGroupDirectoryRefreshToken string `json:"cups_group_directory_refresh_token,omitempty"`
+
+	// CUPS only: URL (eg ldaps://ldap.example.com) of the LDAP server that resolves the ldap:<group DN> entries of cups_job_priority_users and of the allowed_users of color rules, with ldapsearch; empty resolves none.
+	CUPSGroupLDAPURL string `json:"cups_group_ldap_url,omitempty"`
+
+	// CUPS only: DN to bind to the LDAP server as; empty binds anonymously.
+	CUPSGroupLDAPBindDN string `json:"cups_group_ldap_bind_dn,omitempty"`
+
+	// CUPS only: file of the password of cups_group_ldap_bind_dn.
+	CUPSGroupLDAPPasswordFile string `json:"cups_group_ldap_password_file,omitempty"`
+
+	// CUPS only: how long (eg 15m) the members of groups are reused before they are resolved again; empty is 15m.
+	CUPSGroupCacheTTL string `json:"cups_group_cache_ttl,omitempty"`
+
+	// CUPS only: email domains (eg example.com) whose addresses name the same users as bare user names, like CUPS user names and LDAP memberUid values, when matching group members; empty matches group members exactly.
+	CUPSGroupUserDomains []string `json:"cups_group_user_domains,omitempty"`
+
+	// CUPS only: JSON file of messages, by locale, that replace printer-state-reasons in printer states.
+	CUPSStateReasonMessagesFile string `json:"cups_state_reason_messages_file,omitempty"`
+
+	// CUPS only: find the CUPS server via DNS-SD, instead of client.conf or CUPS_SERVER.
+	CUPSDiscoveryEnable bool `json:"cups_discovery_enable,omitempty"`
+
+	// CUPS only: rules, in order of preference, that select the discovered CUPS server; empty selects any.
+	CUPSDiscoveryRules []DNSSDRule `json:"cups_discovery_rules,omitempty"`
+
+	// CUPS only: IPP Everywhere printers, by name, to print to directly instead of through CUPS; values are ipp:// or ipps:// URIs.
+	CUPSDirectPrinters map[string]string `json:"cups_direct_printers,omitempty"`
+
+	// CUPS only: how to validate the certificates of ipps:// direct printers, by name: "system" roots (the default), "pin:<SHA-256 of the certificate>", or "tofu" to trust the first certificate seen.
+	CUPSDirectPrinterTLS map[string]string `json:"cups_direct_printer_tls,omitempty"`
+
+	// CUPS only: file that remembers the certificates of direct printers that are trusted on first use.
+	CUPSDirectTOFUFile string `json:"cups_direct_tofu_file,omitempty"`
+
+	// CUPS only: print directly to IPP-over-USB printers, found by USB enumeration; Linux only.
+	CUPSIPPUSBEnable bool `json:"cups_ipp_usb_enable,omitempty"`
+
+	// CUPS only: send jobs for raw queues with socket:// or lpd:// devices straight to the device; requires cups_ignore_raw_printers false.
+	CUPSRawDirectSubmit bool `json:"cups_raw_direct_submit,omitempty"`
+
+	// CUPS only: raw queues, by name, whose socket:// devices speak PJL, and are asked for their status with it; devices that don't speak PJL print the query.
+	CUPSRawPJLStatusPrinters []string `json:"cups_raw_pjl_status_printers,omitempty"`
+
+	// CUPS only: tag printers with the firmware version, serial number and page count of their devices, by IPP or SNMP.
+	CUPSDeviceInfoEnable bool `json:"cups_device_info_enable,omitempty"`
+
+	// CUPS only: SNMP community for reading device info; public when empty.
+	CUPSSNMPCommunity string `json:"cups_snmp_community,omitempty"`
+
 	// CUPS only: ignore printers with make/model 'Local Raw Printer'.
 	CUPSIgnoreRawPrinters *bool `json:"cups_ignore_raw_printers,omitempty"`
 
@@ -145,14 +439,15 @@ type Config struct {
 	CUPSIgnoreClassPrinters *bool `json:"cups_ignore_class_printers,omitempty"`
 
 	// CUPS only: copy the CUPS printer's printer-info attribute to the GCP printer's defaultDisplayName.
-	// TODO: rename with cups_ prefix
-	CUPSCopyPrinterInfoToDisplayName *bool `json:"copy_printer_info_to_display_name,omitempty"`
+	CUPSCopyPrinterInfoToDisplayName *bool `json:"cups_copy_printer_info_to_display_name,omitempty"`
 }
 
 // DefaultConfig represents reasonable default values for Config fields.
 // Omitted Config fields are omitted on purpose; they are unique per
 // connector instance.
 var DefaultConfig = Config{
+	SchemaVersion: ConfigSchemaVersion,
+
 	LocalPrintingEnable: true,
 	CloudPrintingEnable: false,
 
@@ -166,6 +461,7 @@ var DefaultConfig = Config{
 	GCPOAuthAuthURL:           "https://accounts.google.com/o/oauth2/auth",
 	GCPOAuthTokenURL:          "https://accounts.google.com/o/oauth2/token",
 	GCPMaxConcurrentDownloads: 5,
+	GCPMaxConcurrentFetches:   5,
 
 	NativeJobQueueSize:        3,
 	NativePrinterPollInterval: "1m",
@@ -178,6 +474,8 @@ var DefaultConfig = Config{
 	LocalPortLow:  26000,
 	LocalPortHigh: 26999,
 
+	UpdateCheckInterval: "24h",
+
 	LogFileName:         "/tmp/cloud-print-connector",
 	LogFileMaxMegabytes: 1,
 	LogMaxFiles:         3,
@@ -185,8 +483,18 @@ var DefaultConfig = Config{
 
 	MonitorSocketFilename: "/tmp/cloud-print-connector-monitor.sock",
 
-	CUPSMaxConnections: 50,
-	CUPSConnectTimeout: "5s",
+	JobTicketAuditMaxRecords: 1000,
+	JobTicketAuditMaxAge:     "24h",
+
+	CUPSMaxConnections:     50,
+	CUPSMinConnections:     4,
+	CUPSConnectTimeout:     "5s",
+	CUPSGetPrintersTimeout: "1m",
+	CUPSGetPPDTimeout:      "2m",
+	CUPSPPDTimeout:         "1m",
+	CUPSPPDMaxBytes:        16 * 1024 * 1024,
+	CUPSPrintTimeout:       "5m",
+	CUPSJobStateTimeout:    "15s",
 	CUPSPrinterAttributes: []string{
 		"cups-version",
 		"device-uri",
@@ -199,6 +507,7 @@ var DefaultConfig = Config{
 		"printer-make-and-model",
 		"printer-state",
 		"printer-state-reasons",
+		"printer-is-accepting-jobs",
 		"printer-uuid",
 		"marker-names",
 		"marker-types",
@@ -210,6 +519,10 @@ var DefaultConfig = Config{
 		"orientation-requested-default",
 		"orientation-requested-supported",
 		"pdf-versions-supported",
+		"printer-resolution-default",
+		"printer-resolution-supported",
+		"job-priority-default",
+		"job-priority-supported",
 	},
 	CUPSJobFullUsername:              PointerToBool(false),
 	CUPSIgnoreRawPrinters:            PointerToBool(true),
@@ -217,6 +530,24 @@ var DefaultConfig = Config{
 	CUPSCopyPrinterInfoToDisplayName: PointerToBool(true),
 }
 
+// FeatureFlags gets the config keys of the features that are enabled.
+func (c *Config) FeatureFlags() []string {
+	return enabledFlags(map[string]bool{
+		"local_printing_enable":                  c.LocalPrintingEnable,
+		"cloud_printing_enable":                  c.CloudPrintingEnable,
+		"job_full_username":                      isTrue(c.CUPSJobFullUsername),
+		"prefix_job_id_to_job_title":             isTrue(c.PrefixJobIDToJobTitle),
+		"log_to_journal":                         isTrue(c.LogToJournal),
+		"cups_discovery_enable":                  c.CUPSDiscoveryEnable,
+		"cups_ipp_usb_enable":                    c.CUPSIPPUSBEnable,
+		"cups_raw_direct_submit":                 c.CUPSRawDirectSubmit,
+		"cups_device_info_enable":                c.CUPSDeviceInfoEnable,
+		"cups_ignore_raw_printers":               isTrue(c.CUPSIgnoreRawPrinters),
+		"cups_ignore_class_printers":             isTrue(c.CUPSIgnoreClassPrinters),
+		"cups_copy_printer_info_to_display_name": isTrue(c.CUPSCopyPrinterInfoToDisplayName),
+	})
+}
+
 // getConfigFilename gets the absolute filename of the config file specified by
 // the ConfigFilename flag, and whether it exists.
 //
@@ -255,53 +586,81 @@ func getConfigFilename(context *cli.Context) (string, bool) {
 // Backfill returns a copy of this config with all missing keys set to default values.
 func (c *Config) Backfill(configMap map[string]interface{}) *Config {
 	b := *c.commonBackfill(configMap)
+	d, _ := ProfileDefaults(c.Profile)
 
 	if _, exists := configMap["log_file_name"]; !exists {
-		b.LogFileName = DefaultConfig.LogFileName
+		b.LogFileName = d.LogFileName
 	}
 	if _, exists := configMap["log_file_max_megabytes"]; !exists {
-		b.LogFileMaxMegabytes = DefaultConfig.LogFileMaxMegabytes
+		b.LogFileMaxMegabytes = d.LogFileMaxMegabytes
 	}
 	if _, exists := configMap["log_max_files"]; !exists {
-		b.LogMaxFiles = DefaultConfig.LogMaxFiles
+		b.LogMaxFiles = d.LogMaxFiles
 	}
 	if _, exists := configMap["log_to_journal"]; !exists {
-		b.LogToJournal = DefaultConfig.LogToJournal
+		b.LogToJournal = d.LogToJournal
 	}
 	if _, exists := configMap["monitor_socket_filename"]; !exists {
-		b.MonitorSocketFilename = DefaultConfig.MonitorSocketFilename
+		b.MonitorSocketFilename = d.MonitorSocketFilename
+	}
+	if _, exists := configMap["job_ticket_audit_max_records"]; !exists {
+		b.JobTicketAuditMaxRecords = d.JobTicketAuditMaxRecords
+	}
+	if _, exists := configMap["job_ticket_audit_max_age"]; !exists {
+		b.JobTicketAuditMaxAge = d.JobTicketAuditMaxAge
 	}
 	if _, exists := configMap["cups_max_connections"]; !exists {
-		b.CUPSMaxConnections = DefaultConfig.CUPSMaxConnections
+		b.CUPSMaxConnections = d.CUPSMaxConnections
+	}
+	if _, exists := configMap["cups_min_connections"]; !exists {
+		b.CUPSMinConnections = d.CUPSMinConnections
 	}
 	if _, exists := configMap["cups_connect_timeout"]; !exists {
-		b.CUPSConnectTimeout = DefaultConfig.CUPSConnectTimeout
+		b.CUPSConnectTimeout = d.CUPSConnectTimeout
+	}
+	if _, exists := configMap["cups_get_printers_timeout"]; !exists {
+		b.CUPSGetPrintersTimeout = d.CUPSGetPrintersTimeout
+	}
+	if _, exists := configMap["cups_get_ppd_timeout"]; !exists {
+		b.CUPSGetPPDTimeout = d.CUPSGetPPDTimeout
+	}
+	if _, exists := configMap["cups_ppd_timeout"]; !exists {
+		b.CUPSPPDTimeout = d.CUPSPPDTimeout
+	}
+	if _, exists := configMap["cups_ppd_max_bytes"]; !exists {
+		b.CUPSPPDMaxBytes = d.CUPSPPDMaxBytes
+	}
+	if _, exists := configMap["cups_print_timeout"]; !exists {
+		b.CUPSPrintTimeout = d.CUPSPrintTimeout
+	}
+	if _, exists := configMap["cups_job_state_timeout"]; !exists {
+		b.CUPSJobStateTimeout = d.CUPSJobStateTimeout
 	}
 	if _, exists := configMap["cups_printer_attributes"]; !exists {
-		b.CUPSPrinterAttributes = DefaultConfig.CUPSPrinterAttributes
+		b.CUPSPrinterAttributes = d.CUPSPrinterAttributes
 	} else {
 		// Make sure all required attributes are present.
 		s := make(map[string]struct{}, len(b.CUPSPrinterAttributes))
 		for _, a := range b.CUPSPrinterAttributes {
 			s[a] = struct{}{}
 		}
-		for _, a := range DefaultConfig.CUPSPrinterAttributes {
+		for _, a := range d.CUPSPrinterAttributes {
 			if _, exists := s[a]; !exists {
 				b.CUPSPrinterAttributes = append(b.CUPSPrinterAttributes, a)
 			}
 		}
 	}
-	if _, exists := configMap["cups_job_full_username"]; !exists {
-		b.CUPSJobFullUsername = DefaultConfig.CUPSJobFullUsername
+	if _, exists := configMap["job_full_username"]; !exists {
+		b.CUPSJobFullUsername = d.CUPSJobFullUsername
 	}
 	if _, exists := configMap["cups_ignore_raw_printers"]; !exists {
-		b.CUPSIgnoreRawPrinters = DefaultConfig.CUPSIgnoreRawPrinters
+		b.CUPSIgnoreRawPrinters = d.CUPSIgnoreRawPrinters
 	}
 	if _, exists := configMap["cups_ignore_class_printers"]; !exists {
-		b.CUPSIgnoreClassPrinters = DefaultConfig.CUPSIgnoreClassPrinters
+		b.CUPSIgnoreClassPrinters = d.CUPSIgnoreClassPrinters
 	}
-	if _, exists := configMap["copy_printer_info_to_display_name"]; !exists {
-		b.CUPSCopyPrinterInfoToDisplayName = DefaultConfig.CUPSCopyPrinterInfoToDisplayName
+	if _, exists := configMap["cups_copy_printer_info_to_display_name"]; !exists {
+		b.CUPSCopyPrinterInfoToDisplayName = d.CUPSCopyPrinterInfoToDisplayName
 	}
 
 	return &b
@@ -310,50 +669,95 @@ func (c *Config) Backfill(configMap map[string]interface{}) *Config {
 // Sparse returns a copy of this config with obvious values removed.
 func (c *Config) Sparse(context *cli.Context) *Config {
 	s := *c.commonSparse(context)
+	d, _ := ProfileDefaults(c.Profile)
 
 	if !context.IsSet("log-file-max-megabytes") &&
-		s.LogFileMaxMegabytes == DefaultConfig.LogFileMaxMegabytes {
+		s.LogFileMaxMegabytes == d.LogFileMaxMegabytes {
 		s.LogFileMaxMegabytes = 0
 	}
 	if !context.IsSet("log-max-files") &&
-		s.LogMaxFiles == DefaultConfig.LogMaxFiles {
+		s.LogMaxFiles == d.LogMaxFiles {
 		s.LogMaxFiles = 0
 	}
 	if !context.IsSet("log-to-journal") &&
-		reflect.DeepEqual(s.LogToJournal, DefaultConfig.LogToJournal) {
+		reflect.DeepEqual(s.LogToJournal, d.LogToJournal) {
 		s.LogToJournal = nil
 	}
 	if !context.IsSet("monitor-socket-filename") &&
-		s.MonitorSocketFilename == DefaultConfig.MonitorSocketFilename {
+		s.MonitorSocketFilename == d.MonitorSocketFilename {
 		s.MonitorSocketFilename = ""
 	}
+	if !context.IsSet("job-ticket-audit-max-records") &&
+		s.JobTicketAuditMaxRecords == d.JobTicketAuditMaxRecords {
+		s.JobTicketAuditMaxRecords = 0
+	}
+	if !context.IsSet("job-ticket-audit-max-age") &&
+		s.JobTicketAuditMaxAge == d.JobTicketAuditMaxAge {
+		s.JobTicketAuditMaxAge = ""
+	}
 	if !context.IsSet("cups-max-connections") &&
-		s.CUPSMaxConnections == DefaultConfig.CUPSMaxConnections {
+		s.CUPSMaxConnections == d.CUPSMaxConnections {
 		s.CUPSMaxConnections = 0
 	}
+	if !context.IsSet("cups-min-connections") &&
+		s.CUPSMinConnections == d.CUPSMinConnections {
+		s.CUPSMinConnections = 0
+	}
 	if !context.IsSet("cups-connect-timeout") &&
-		s.CUPSConnectTimeout == DefaultConfig.CUPSConnectTimeout {
+		s.CUPSConnectTimeout == d.CUPSConnectTimeout {
 		s.CUPSConnectTimeout = ""
 	}
-	if reflect.DeepEqual(s.CUPSPrinterAttributes, DefaultConfig.CUPSPrinterAttributes) {
+	if !context.IsSet("cups-get-printers-timeout") &&
+		s.CUPSGetPrintersTimeout == d.CUPSGetPrintersTimeout {
+		s.CUPSGetPrintersTimeout = ""
+	}
+	if !context.IsSet("cups-get-ppd-timeout") &&
+		s.CUPSGetPPDTimeout == d.CUPSGetPPDTimeout {
+		s.CUPSGetPPDTimeout = ""
+	}
+	if !context.IsSet("cups-ppd-timeout") &&
+		s.CUPSPPDTimeout == d.CUPSPPDTimeout {
+		s.CUPSPPDTimeout = ""
+	}
+	if !context.IsSet("cups-ppd-max-bytes") &&
+		s.CUPSPPDMaxBytes == d.CUPSPPDMaxBytes {
+		s.CUPSPPDMaxBytes = 0
+	}
+	if !context.IsSet("cups-print-timeout") &&
+		s.CUPSPrintTimeout == d.CUPSPrintTimeout {
+		s.CUPSPrintTimeout = ""
+	}
+	if !context.IsSet("cups-job-state-timeout") &&
+		s.CUPSJobStateTimeout == d.CUPSJobStateTimeout {
+		s.CUPSJobStateTimeout = ""
+	}
+	if reflect.DeepEqual(s.CUPSPrinterAttributes, d.CUPSPrinterAttributes) {
 		s.CUPSPrinterAttributes = nil
 	}
 	if !context.IsSet("cups-job-full-username") &&
-		reflect.DeepEqual(s.CUPSJobFullUsername, DefaultConfig.CUPSJobFullUsername) {
+		reflect.DeepEqual(s.CUPSJobFullUsername, d.CUPSJobFullUsername) {
 		s.CUPSJobFullUsername = nil
 	}
 	if !context.IsSet("cups-ignore-raw-printers") &&
-		reflect.DeepEqual(s.CUPSIgnoreRawPrinters, DefaultConfig.CUPSIgnoreRawPrinters) {
+		reflect.DeepEqual(s.CUPSIgnoreRawPrinters, d.CUPSIgnoreRawPrinters) {
 		s.CUPSIgnoreRawPrinters = nil
 	}
 	if !context.IsSet("cups-ignore-class-printers") &&
-		reflect.DeepEqual(s.CUPSIgnoreClassPrinters, DefaultConfig.CUPSIgnoreClassPrinters) {
+		reflect.DeepEqual(s.CUPSIgnoreClassPrinters, d.CUPSIgnoreClassPrinters) {
 		s.CUPSIgnoreClassPrinters = nil
 	}
 	if !context.IsSet("copy-printer-info-to-display-name") &&
-		reflect.DeepEqual(s.CUPSCopyPrinterInfoToDisplayName, DefaultConfig.CUPSCopyPrinterInfoToDisplayName) {
+		reflect.DeepEqual(s.CUPSCopyPrinterInfoToDisplayName, d.CUPSCopyPrinterInfoToDisplayName) {
 		s.CUPSCopyPrinterInfoToDisplayName = nil
 	}
 
 	return &s
 }
+
+// apply sets the values of this profile that aren't set by flags.
+func (p *configProfile) apply(c *Config, isSet func(string) bool) {
+	p.applyCommon(c, isSet)
+	if !isSet("cups-max-connections") {
+		c.CUPSMaxConnections = p.cupsMaxConnections
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"os"
 	"path/filepath"
 
+	"github.com/google/cloud-print-connector/cdd"
 	"github.com/urfave/cli"
 )
 
@@ -22,12 +23,19 @@ const (
 )
 
 type Config struct {
+	// Version of the config file keys; see ConfigSchemaVersion.
+	SchemaVersion uint `json:"config_schema_version"`
+
 	// Enable local discovery and printing.
 	LocalPrintingEnable bool `json:"local_printing_enable"`
 
 	// Enable cloud discovery and printing.
 	CloudPrintingEnable bool `json:"cloud_printing_enable"`
 
+	// Named bundle of defaults for keys that are missing: workstation,
+	// print-server, kiosk or container.
+	Profile string `json:"profile,omitempty"`
+
 	// Associated with root account. XMPP credential.
 	XMPPJID string `json:"xmpp_jid,omitempty"`
 
@@ -50,13 +58,10 @@ type Config struct {
 	XMPPPort uint16 `json:"xmpp_port,omitempty"`
 
 	// XMPP ping timeout (give up waiting after this time).
-	// TODO: Rename with "gcp_" removed.
-	XMPPPingTimeout string `json:"gcp_xmpp_ping_timeout,omitempty"`
+	XMPPPingTimeout string `json:"xmpp_ping_timeout,omitempty"`
 
 	// XMPP ping interval (time between ping attempts).
-	// TODO: Rename with "gcp_" removed.
-	// TODO: Rename with "_default" removed.
-	XMPPPingInterval string `json:"gcp_xmpp_ping_interval_default,omitempty"`
+	XMPPPingInterval string `json:"xmpp_ping_interval,omitempty"`
 
 	// GCP API URL prefix.
 	GCPBaseURL string `json:"gcp_base_url,omitempty"`
@@ -76,17 +81,17 @@ type Config struct {
 	// Maximum quantity of jobs (data) to download concurrently.
 	GCPMaxConcurrentDownloads uint `json:"gcp_max_concurrent_downloads,omitempty"`
 
+	// Maximum quantity of printers to fetch jobs for concurrently.
+	GCPMaxConcurrentFetches uint `json:"gcp_max_concurrent_fetches,omitempty"`
+
 	// Windows Spooler job queue size, must be greater than zero.
-	// TODO: rename without cups_ prefix
-	NativeJobQueueSize uint `json:"cups_job_queue_size,omitempty"`
+	NativeJobQueueSize uint `json:"native_job_queue_size,omitempty"`
 
 	// Interval (eg 10s, 1m) between Windows Spooler printer state polls.
-	// TODO: rename without cups_ prefix
-	NativePrinterPollInterval string `json:"cups_printer_poll_interval,omitempty"`
+	NativePrinterPollInterval string `json:"native_printer_poll_interval,omitempty"`
 
 	// Use the full username (joe@example.com) in job.
-	// TODO: rename without cups_ prefix
-	CUPSJobFullUsername *bool `json:"cups_job_full_username,omitempty"`
+	CUPSJobFullUsername *bool `json:"job_full_username,omitempty"`
 
 	// Add the job ID to the beginning of the job title. Useful for debugging.
 	PrefixJobIDToJobTitle *bool `json:"prefix_job_id_to_job_title,omitempty"`
@@ -100,6 +105,138 @@ type Config struct {
 	// Allow printers with native names.
 	PrinterWhitelist []string `json:"printer_whitelist,omitempty"`
 
+	// Largest job, in bytes, to print; zero is no limit.
+	MaxJobBytes int64 `json:"max_job_bytes,omitempty"`
+
+	// Most pages in a job to print; zero is no limit.
+	MaxJobPages uint `json:"max_job_pages,omitempty"`
+
+	// Longest time, in seconds, that a job may take to download and print before it is aborted; zero is no limit.
+	MaxJobSeconds uint `json:"max_job_seconds,omitempty"`
+
+	// Job limits of printers, by native name, that replace max_job_bytes, max_job_pages and max_job_seconds, and limit the download bytes per second of each printer (max_download_bytes_per_second).
+	PrinterJobLimits map[string]JobLimits `json:"printer_job_limits,omitempty"`
+
+	// Most bytes per second that all job downloads together may take; zero is no limit.
+	DownloadBytesPerSecond int64 `json:"download_bytes_per_second,omitempty"`
+
+	// How long (eg 1m) the capabilities and sharing of a printer, as GCP answered, are reused before GCP is asked again; empty asks every time.
+	GCPPrinterCacheTTL string `json:"gcp_printer_cache_ttl,omitempty"`
+
+	// Most requests per minute to GCP, as its quota allows; zero is no limit. Near the limit, the requests that jobs need go before printer updates.
+	GCPRequestsPerMinute uint `json:"gcp_requests_per_minute,omitempty"`
+
+	// Most bytes of downloaded job files kept after they print, to reprint them without downloading them again; zero keeps none.
+	JobCacheBytes int64 `json:"job_cache_bytes,omitempty"`
+
+	// Simulated printers registered in addition to the native printers, for load and integration testing; zero is none.
+	SimulatedPrinters uint `json:"simulated_printers,omitempty"`
+
+	// Capabilities of the simulated printers, as a CDD printer section; empty is a monochrome printer of letter and A4 paper.
+	SimulatedPrinterCapabilities *cdd.PrinterDescriptionSection `json:"simulated_printer_capabilities,omitempty"`
+
+	// Pages per minute that simulated printers print; zero prints each job at once.
+	SimulatedPrinterPagesPerMinute uint `json:"simulated_printer_pages_per_minute,omitempty"`
+
+	// Fraction of the jobs of simulated printers that fail partway, from 0 to 1.
+	SimulatedPrinterFailureRate float64 `json:"simulated_printer_failure_rate,omitempty"`
+
+	// Reject a document sent again to the same printer by the same user within this long (eg 1m); empty allows it.
+	DuplicateJobWindow string `json:"duplicate_job_window,omitempty"`
+
+	// Marker levels, in percent, at or below which supplies are low (warning) or exhausted (critical); zero critical is 10.
+	SupplyAlertThresholds SupplyThresholds `json:"supply_alert_thresholds"`
+
+	// Supply alert thresholds of printers, by native name, that replace supply_alert_thresholds.
+	PrinterSupplyAlertThresholds map[string]SupplyThresholds `json:"printer_supply_alert_thresholds,omitempty"`
+
+	// URL to post supply alerts to, as JSON, when a supply becomes low or exhausted; empty posts none.
+	SupplyAlertWebhookURL string `json:"supply_alert_webhook_url,omitempty"`
+
+	// Consecutive failed jobs after which a printer is quarantined: reported stopped, with its jobs held in the cloud; 0 never quarantines.
+	QuarantineAfterFailures uint `json:"quarantine_after_failures,omitempty"`
+
+	// How often to probe a quarantined printer, and release it once its native state is healthy; empty releases it only when cleared.
+	QuarantineProbeInterval string `json:"quarantine_probe_interval,omitempty"`
+
+	// URL to post quarantine alerts to, as JSON, when a printer is quarantined or released; empty posts none.
+	QuarantineWebhookURL string `json:"quarantine_webhook_url,omitempty"`
+
+	// JSON file of the messages that users see when their jobs fail, by kind of failure, to reword them or write them in the users' language; empty uses the built-in English messages.
+	JobErrorMessagesFile string `json:"job_error_messages_file,omitempty"`
+
+	// URL where the users of every printer get help, published as its support URL; {name} is replaced with the printer's native name, and {hostname} with the host of its device URI (eg http://{hostname}/ for its embedded web server). Empty is the connector's home page.
+	PrinterSupportURL string `json:"printer_support_url,omitempty"`
+
+	// Support URLs of printers, by native name, that replace printer_support_url, with the same placeholders.
+	PrinterSupportURLs map[string]string `json:"printer_support_urls,omitempty"`
+
+	// File to append security-relevant events to, like credential changes, sharing, policy overrides and monitor admin actions, as hash-chained JSON lines that export-audit-log verifies; empty records none.
+	AuditLogFile string `json:"audit_log_file,omitempty"`
+
+	// File of a secret key, of at least 16 bytes, that keys the hashes of audit_log_file, so that whoever can write the log, but can't read the key, can't rewrite it undetected; empty hashes without a key.
+	AuditLogKeyFile string `json:"audit_log_key_file,omitempty"`
+
+	// Most vendor state items to report per printer, after removing repeats, plus an "and N more" item; 0 is no limit.
+	VendorStateMaxItems uint `json:"vendor_state_max_items,omitempty"`
+
+	// URL of a JSON release feed to check for newer connector versions, which are reported in the logs, monitor and printer tags; empty never checks.
+	UpdateFeedURL string `json:"update_feed_url,omitempty"`
+
+	// Interval (eg 24h) between checks of update_feed_url.
+	UpdateCheckInterval string `json:"update_check_interval,omitempty"`
+
+	// File to download the binary of a newer connector version to, for an admin to install; empty downloads nothing.
+	UpdateStagingFilename string `json:"update_staging_filename,omitempty"`
+
+	// Address family, ipv4, ipv6, prefer-ipv4 or prefer-ipv6, that listeners and outbound connections are limited to or prefer; empty is dual-stack, in the system's order.
+	AddressFamily string `json:"address_family,omitempty"`
+
+	// TLS profile of every TLS client and server of the connector: empty is Go's defaults, or "fips" to use only TLS 1.2, and the cipher suites and curves that FIPS 140 approves, and refuse others below. Printers with old TLS may fail.
+	TLSProfile string `json:"tls_profile,omitempty"`
+
+	// Lowest TLS version (eg 1.2) of every TLS client and server; empty is the profile's default.
+	TLSMinVersion string `json:"tls_min_version,omitempty"`
+
+	// Highest TLS version (eg 1.2, to avoid TLS 1.3's cipher suites, which aren't configurable); empty is the profile's default. The fips profile refuses 1.3.
+	TLSMaxVersion string `json:"tls_max_version,omitempty"`
+
+	// TLS 1.2 cipher suites, by Go name (eg TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256), in order of preference; empty is the profile's defaults. Insecure suites are refused.
+	TLSCipherSuites []string `json:"tls_cipher_suites,omitempty"`
+
+	// TLS key exchange curves (P256, P384, P521 or X25519), in order of preference; empty is the profile's defaults.
+	TLSCurves []string `json:"tls_curves,omitempty"`
+
+	// Identifier of this deployment, like a site name, added to the User-Agent of requests to GCP, XMPP and printers; empty adds none.
+	UserAgentSite string `json:"user_agent_site,omitempty"`
+
+	// ID of this connector instance, published in printer tags; empty is the host name.
+	InstanceID string `json:"instance_id,omitempty"`
+
+	// File, reachable by every connector instance of a printer system, that decides which instance owns the printers; empty is no coordination.
+	InstanceLeaseFile string `json:"instance_lease_file,omitempty"`
+
+	// Role of this connector instance in a pair, active or standby, that takes over the printers when the other dies; empty is no failover.
+	FailoverRole string `json:"failover_role,omitempty"`
+
+	// Address, like :26000, on which to serve the failover heartbeat to the other instance of the pair.
+	FailoverListenAddress string `json:"failover_listen_address,omitempty"`
+
+	// URL, like http://host:26000, of the active instance's failover heartbeat; standby only.
+	FailoverPeerURL string `json:"failover_peer_url,omitempty"`
+
+	// How long the standby instance waits for the active instance's heartbeat before it takes over (eg 30s); empty is 30s.
+	FailoverTimeout string `json:"failover_timeout,omitempty"`
+
+	// Quantity of connector instances that split the printers between them by hash of their names; zero or one is no sharding.
+	ShardCount uint `json:"shard_count,omitempty"`
+
+	// Shard of printers, from zero to shard_count - 1, that this instance manages.
+	ShardIndex uint `json:"shard_index,omitempty"`
+
+	// Printers, by native name, that this instance manages, instead of a shard by hash.
+	ShardPrinters []string `json:"shard_printers,omitempty"`
+
 	// Least severity to log.
 	LogLevel string `json:"log_level"`
 
@@ -114,6 +251,8 @@ type Config struct {
 // Omitted Config fields are omitted on purpose; they are unique per
 // connector instance.
 var DefaultConfig = Config{
+	SchemaVersion: ConfigSchemaVersion,
+
 	XMPPServer:                "talk.google.com",
 	XMPPPort:                  443,
 	XMPPPingTimeout:           "5s",
@@ -124,6 +263,7 @@ var DefaultConfig = Config{
 	GCPOAuthAuthURL:           "https://accounts.google.com/o/oauth2/auth",
 	GCPOAuthTokenURL:          "https://accounts.google.com/o/oauth2/token",
 	GCPMaxConcurrentDownloads: 5,
+	GCPMaxConcurrentFetches:   5,
 
 	NativeJobQueueSize:        3,
 	NativePrinterPollInterval: "1m",
@@ -143,6 +283,18 @@ var DefaultConfig = Config{
 
 	LocalPortLow:  26000,
 	LocalPortHigh: 26999,
+
+	UpdateCheckInterval: "24h",
+}
+
+// FeatureFlags gets the config keys of the features that are enabled.
+func (c *Config) FeatureFlags() []string {
+	return enabledFlags(map[string]bool{
+		"local_printing_enable":      c.LocalPrintingEnable,
+		"cloud_printing_enable":      c.CloudPrintingEnable,
+		"job_full_username":          isTrue(c.CUPSJobFullUsername),
+		"prefix_job_id_to_job_title": isTrue(c.PrefixJobIDToJobTitle),
+	})
 }
 
 // getConfigFilename gets the absolute filename of the config file specified by
@@ -190,3 +342,8 @@ func (c *Config) Backfill(configMap map[string]interface{}) *Config {
 func (c *Config) Sparse(context *cli.Context) *Config {
 	return c.commonSparse(context)
 }
+
+// apply sets the values of this profile that aren't set by flags.
+func (p *configProfile) apply(c *Config, isSet func(string) bool) {
+	p.applyCommon(c, isSet)
+}
@@ -0,0 +1,138 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/urfave/cli"
+)
+
+// ConfigSchemaVersion is the version of the config file keys that this
+// connector reads. Config files without config_schema_version are version 1.
+const ConfigSchemaVersion = 2
+
+const configSchemaVersionKey = "config_schema_version"
+
+// configMigration upgrades config files to version, by renaming keys.
+type configMigration struct {
+	version uint
+	// renames are pairs of old key, new key, in order.
+	renames [][2]string
+}
+
+var configMigrations = []configMigration{
+	{
+		version: 2,
+		renames: [][2]string{
+			{"gcp_xmpp_ping_timeout", "xmpp_ping_timeout"},
+			{"gcp_xmpp_ping_interval_default", "xmpp_ping_interval"},
+			{"cups_job_queue_size", "native_job_queue_size"},
+			{"cups_printer_poll_interval", "native_printer_poll_interval"},
+			{"cups_job_full_username", "job_full_username"},
+			{"copy_printer_info_to_display_name", "cups_copy_printer_info_to_display_name"},
+		},
+	},
+}
+
+// configSchemaVersion gets the schema version of a config, as a map of its
+// keys.
+func configSchemaVersion(configMap map[string]interface{}) (uint, error) {
+	v, exists := configMap[configSchemaVersionKey]
+	if !exists {
+		return 1, nil
+	}
+	f, ok := v.(float64)
+	if !ok || f < 1 || f != float64(uint(f)) {
+		return 0, fmt.Errorf("%s %v is not a version", configSchemaVersionKey, v)
+	}
+	return uint(f), nil
+}
+
+// migrateConfigMap upgrades a config, as a map of its keys, to
+// ConfigSchemaVersion. Returns a report of the changes, which is empty when
+// the config is current.
+func migrateConfigMap(configMap map[string]interface{}) ([]string, error) {
+	version, err := configSchemaVersion(configMap)
+	if err != nil {
+		return nil, err
+	}
+	if version > ConfigSchemaVersion {
+		return nil, fmt.Errorf("Config file schema version %d is newer than this connector's version %d",
+			version, ConfigSchemaVersion)
+	}
+
+	var report []string
+	for _, m := range configMigrations {
+		if m.version <= version {
+			continue
+		}
+		for _, rename := range m.renames {
+			oldKey, newKey := rename[0], rename[1]
+			v, exists := configMap[oldKey]
+			if !exists {
+				continue
+			}
+			delete(configMap, oldKey)
+			if _, exists := configMap[newKey]; exists {
+				report = append(report, fmt.Sprintf("removed %s, which %s replaces", oldKey, newKey))
+				continue
+			}
+			configMap[newKey] = v
+			report = append(report, fmt.Sprintf("renamed %s to %s", oldKey, newKey))
+		}
+		// As if decoded from JSON, like the other values.
+		configMap[configSchemaVersionKey] = float64(m.version)
+		report = append(report, fmt.Sprintf("set %s to %d", configSchemaVersionKey, m.version))
+		version = m.version
+	}
+
+	return report, nil
+}
+
+// MigrateConfigFile upgrades the config file indicated by the config
+// filename flag to ConfigSchemaVersion, after copying the old file to
+// backupFilename. Returns an empty report when there is no config file, or
+// when it is current.
+func MigrateConfigFile(context *cli.Context) (filename, backupFilename string, report []string, err error) {
+	cf, exists := getConfigFilename(context)
+	if !exists {
+		return "", "", nil, nil
+	}
+
+	configRaw, _, err := ReadStateFile(cf, validJSON)
+	if err != nil {
+		return cf, "", nil, err
+	}
+	var configMap map[string]interface{}
+	if err = json.Unmarshal(configRaw, &configMap); err != nil {
+		return cf, "", nil, err
+	}
+	version, err := configSchemaVersion(configMap)
+	if err != nil {
+		return cf, "", nil, err
+	}
+	if report, err = migrateConfigMap(configMap); err != nil || len(report) == 0 {
+		return cf, "", nil, err
+	}
+
+	b, err := json.MarshalIndent(configMap, "", "  ")
+	if err != nil {
+		return cf, "", nil, err
+	}
+	backupFilename = fmt.Sprintf("%s.v%d.bak", cf, version)
+	if err = WriteStateFile(backupFilename, configRaw, 0600); err != nil {
+		return cf, "", nil, fmt.Errorf("Failed to back up config file: %s", err)
+	}
+	if err = WriteStateFile(cf, b, 0600); err != nil {
+		return cf, backupFilename, nil, err
+	}
+	return cf, backupFilename, report, nil
+}
@@ -0,0 +1,113 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package lib
+
+import (
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/urfave/cli"
+)
+
+func TestMigrateConfigMap(t *testing.T) {
+	configMap := map[string]interface{}{
+		"gcp_xmpp_ping_timeout":      "10s",
+		"cups_printer_poll_interval": "5m",
+		"native_job_queue_size":      float64(4),
+		"cups_job_queue_size":        float64(2),
+		"proxy_name":                 "proxy",
+	}
+	report, err := migrateConfigMap(configMap)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]interface{}{
+		"xmpp_ping_timeout":            "10s",
+		"native_printer_poll_interval": "5m",
+		"native_job_queue_size":        float64(4),
+		"proxy_name":                   "proxy",
+		configSchemaVersionKey:         float64(2),
+	}
+	if !reflect.DeepEqual(configMap, expected) {
+		t.Logf("expected migrated config %v, got %v", expected, configMap)
+		t.Fail()
+	}
+	if len(report) != 4 {
+		t.Logf("expected 3 changes and the new version, got %q", report)
+		t.Fail()
+	}
+
+	if report, err = migrateConfigMap(configMap); err != nil || len(report) != 0 {
+		t.Logf("expected a current config to stay, got %q, %v", report, err)
+		t.Fail()
+	}
+
+	if _, err = migrateConfigMap(map[string]interface{}{configSchemaVersionKey: float64(ConfigSchemaVersion + 1)}); err == nil {
+		t.Log("expected an error for a config from a newer connector")
+		t.Fail()
+	}
+}
+
+func TestMigrateConfigFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config-migration")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cf := filepath.Join(dir, "config.json")
+	old := []byte(`{"gcp_xmpp_ping_interval_default": "3m", "log_level": "DEBUG"}`)
+	if err = ioutil.WriteFile(cf, old, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	set := flag.NewFlagSet("test", flag.ContinueOnError)
+	set.String("config-filename", cf, "")
+	context := cli.NewContext(nil, set, nil)
+
+	filename, backup, report, err := MigrateConfigFile(context)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filename != cf || backup != cf+".v1.bak" || len(report) != 2 {
+		t.Logf("expected %s migrated with a backup, got %s %s %q", cf, filename, backup, report)
+		t.Fail()
+	}
+	if b, err := ioutil.ReadFile(backup); err != nil || string(b) != string(old) {
+		t.Logf("expected the backup to be the old file, got %q, %v", b, err)
+		t.Fail()
+	}
+
+	b, err := ioutil.ReadFile(cf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var configMap map[string]interface{}
+	if err = json.Unmarshal(b, &configMap); err != nil {
+		t.Fatal(err)
+	}
+	if configMap["xmpp_ping_interval"] != "3m" || configMap[configSchemaVersionKey] != float64(ConfigSchemaVersion) {
+		t.Logf("expected the new keys in the config file, got %s", b)
+		t.Fail()
+	}
+
+	config, _, err := GetConfig(context)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.XMPPPingInterval != "3m" || config.LogLevel != "DEBUG" {
+		t.Logf("expected the migrated values, got %+v", config)
+		t.Fail()
+	}
+}
@@ -10,8 +10,10 @@ package lib
 
 import (
 	"encoding/binary"
+	"encoding/hex"
 	"fmt"
 	"hash"
+	"hash/adler32"
 	"io"
 	"reflect"
 	"sort"
@@ -28,6 +30,37 @@ func DeepHash(data interface{}, h hash.Hash) {
 	deepHash(h, reflect.ValueOf(data), visited)
 }
 
+// TagsHasher computes the same adler32 hash of a tags map as DeepHash,
+// without reflection, reusing its buffers from one map to the next.
+// A TagsHasher is not safe for concurrent use.
+type TagsHasher struct {
+	h    hash.Hash32
+	keys []string
+	sum  []byte
+}
+
+func NewTagsHasher() *TagsHasher {
+	return &TagsHasher{h: adler32.New()}
+}
+
+// Hash returns the hex-encoded hash of tags.
+func (th *TagsHasher) Hash(tags map[string]string) string {
+	th.keys = th.keys[:0]
+	for k := range tags {
+		th.keys = append(th.keys, k)
+	}
+	sort.Strings(th.keys)
+
+	th.h.Reset()
+	for _, k := range th.keys {
+		io.WriteString(th.h, k)
+		io.WriteString(th.h, tags[k])
+	}
+
+	th.sum = th.h.Sum(th.sum[:0])
+	return hex.EncodeToString(th.sum)
+}
+
 func binWrite(h hash.Hash, d interface{}) {
 	binary.Write(h, binary.BigEndian, d)
 }
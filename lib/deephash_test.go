@@ -12,6 +12,8 @@ import (
 	"bytes"
 	"crypto/md5"
 	"encoding/binary"
+	"fmt"
+	"hash/adler32"
 	"io"
 	"testing"
 )
@@ -171,3 +173,22 @@ func TestString(t *testing.T) {
 	var ms myString = myString(s)
 	check(t, expected, ms)
 }
+
+func TestTagsHasher(t *testing.T) {
+	th := NewTagsHasher()
+	for _, tags := range []map[string]string{
+		map[string]string{},
+		map[string]string{"printer-name": "p1"},
+		map[string]string{"printer-name": "p1", "printer-info": "", "copies-supported": "1~99"},
+		map[string]string{"zzz": "1", "aaa": "2", "mmm": "3"},
+	} {
+		h := adler32.New()
+		DeepHash(tags, h)
+		expected := fmt.Sprintf("%x", h.Sum(nil))
+
+		if got := th.Hash(tags); expected != got {
+			t.Logf("expected %s got %s for %v", expected, got, tags)
+			t.Fail()
+		}
+	}
+}
@@ -0,0 +1,447 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package lib
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	dnsTypeA    = 1
+	dnsTypePTR  = 12
+	dnsTypeTXT  = 16
+	dnsTypeAAAA = 28
+	dnsTypeSRV  = 33
+	dnsClassIN  = 1
+
+	dnsHeaderLength = 12
+	// dnsMaxPointers bounds name decompression, against pointer loops.
+	dnsMaxPointers = 32
+
+	mdnsDomain = "local."
+)
+
+var (
+	mdnsAddress     = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+	mdnsIPv6Address = &net.UDPAddr{IP: net.ParseIP("ff02::fb"), Port: 5353}
+)
+
+// DNSSDService is one instance of a service advertised via DNS-SD.
+type DNSSDService struct {
+	// Name is the instance name, like "Office Laser".
+	Name string
+	// Host is the target host name, like "printserver.local".
+	Host      string
+	Port      uint16
+	Addresses []net.IP
+	TXT       map[string]string
+}
+
+// Address gets host:port of this service, by IP address when one of the
+// address family was advertised, because .local names don't always
+// resolve. IPv4 is preferred, unless the address family says otherwise.
+func (s *DNSSDService) Address() string {
+	host := s.Host
+	ips := OrderAddresses(s.Addresses)
+	if GetAddressFamily() == AddressFamilyAny {
+		ips = orderIPv4First(ips)
+	}
+	if len(ips) > 0 {
+		host = ips[0].String()
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(s.Port)))
+}
+
+func orderIPv4First(ips []net.IP) []net.IP {
+	ordered := make([]net.IP, 0, len(ips))
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			ordered = append(ordered, ip)
+		}
+	}
+	for _, ip := range ips {
+		if ip.To4() == nil {
+			ordered = append(ordered, ip)
+		}
+	}
+	return ordered
+}
+
+// DNSSDRule selects DNS-SD services. Empty fields match any service.
+type DNSSDRule struct {
+	// ServiceName is a pattern, like "Office *", of the instance name.
+	ServiceName string `json:"service_name,omitempty"`
+	// Host is a pattern, like "*.example.com", of the host name or address.
+	Host string `json:"host,omitempty"`
+	// TXT are TXT record values that the service must have.
+	TXT map[string]string `json:"txt,omitempty"`
+}
+
+// Matches returns true when s satisfies every field of this rule.
+func (r *DNSSDRule) Matches(s *DNSSDService) bool {
+	if r.ServiceName != "" {
+		if ok, _ := path.Match(r.ServiceName, s.Name); !ok {
+			return false
+		}
+	}
+	if r.Host != "" {
+		hosts := []string{s.Host}
+		for _, ip := range s.Addresses {
+			hosts = append(hosts, ip.String())
+		}
+		var found bool
+		for _, host := range hosts {
+			if ok, _ := path.Match(r.Host, host); ok {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	for k, v := range r.TXT {
+		if s.TXT[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// BrowseDNSSD finds the services of serviceType, like "_ipp._tcp", that
+// answer a multicast DNS query within timeout, over IPv4 and IPv6 as the
+// address family allows. Services are sorted by name.
+func BrowseDNSSD(serviceType string, timeout time.Duration) ([]DNSSDService, error) {
+	serviceFQDN := strings.TrimSuffix(serviceType, ".") + "." + mdnsDomain
+	query := newDNSQuery(serviceFQDN, dnsTypePTR)
+
+	var conns []*net.UDPConn
+	var firstErr error
+	if IPv4Allowed() {
+		conn, err := sendMDNSQuery("udp4", query, []*net.UDPAddr{mdnsAddress})
+		if err == nil {
+			conns = append(conns, conn)
+		} else {
+			firstErr = err
+		}
+	}
+	if IPv6Allowed() {
+		conn, err := sendMDNSQuery("udp6", query, mdnsIPv6Addresses())
+		if err == nil {
+			conns = append(conns, conn)
+		} else if firstErr == nil {
+			firstErr = err
+		}
+	}
+	if len(conns) == 0 {
+		return nil, firstErr
+	}
+
+	responses := make(chan []byte)
+	errs := make(chan error, len(conns))
+	deadline := time.Now().Add(timeout)
+	for _, conn := range conns {
+		conn := conn
+		defer conn.Close()
+		conn.SetReadDeadline(deadline)
+		go func() {
+			b := make([]byte, 9000)
+			for {
+				n, _, err := conn.ReadFromUDP(b)
+				if err != nil {
+					if ne, ok := err.(net.Error); ok && ne.Timeout() {
+						err = nil
+					}
+					errs <- err
+					return
+				}
+				responses <- append([]byte{}, b[:n]...)
+			}
+		}()
+	}
+
+	records := newDNSSDRecords()
+	for done := 0; done < len(conns); {
+		select {
+		case response := <-responses:
+			// Ignore malformed responses from other hosts.
+			records.parse(response)
+		case err := <-errs:
+			if err != nil {
+				return nil, err
+			}
+			done++
+		}
+	}
+
+	return records.services(serviceFQDN), nil
+}
+
+// sendMDNSQuery sends query to addresses from a new socket of network.
+// From a port other than 5353, this is a "legacy" query, which responders
+// answer by unicast, to the socket. Succeeds when any address was sent to.
+func sendMDNSQuery(network string, query []byte, addresses []*net.UDPAddr) (*net.UDPConn, error) {
+	conn, err := net.ListenUDP(network, &net.UDPAddr{})
+	if err != nil {
+		return nil, err
+	}
+	err = fmt.Errorf("No interface to send an mDNS query over %s", network)
+	var sent bool
+	for _, address := range addresses {
+		if _, e := conn.WriteToUDP(query, address); e == nil {
+			sent = true
+		} else {
+			err = e
+		}
+	}
+	if !sent {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// mdnsIPv6Addresses gets the link-local mDNS address of every interface
+// that is up, multicasts and has an IPv6 address.
+func mdnsIPv6Addresses() []*net.UDPAddr {
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return nil
+	}
+	var addresses []*net.UDPAddr
+	for _, i := range interfaces {
+		if i.Flags&net.FlagUp == 0 || i.Flags&net.FlagMulticast == 0 {
+			continue
+		}
+		addrs, err := i.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.To4() == nil {
+				addresses = append(addresses, &net.UDPAddr{IP: mdnsIPv6Address.IP, Port: mdnsIPv6Address.Port, Zone: i.Name})
+				break
+			}
+		}
+	}
+	return addresses
+}
+
+// newDNSQuery creates a DNS message that asks one question.
+func newDNSQuery(name string, qtype uint16) []byte {
+	msg := make([]byte, dnsHeaderLength)
+	binary.BigEndian.PutUint16(msg[4:], 1) // QDCOUNT
+	msg = appendDNSName(msg, name)
+	msg = append(msg, byte(qtype>>8), byte(qtype), 0, dnsClassIN)
+	return msg
+}
+
+func appendDNSName(msg []byte, name string) []byte {
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		msg = append(msg, byte(len(label)))
+		msg = append(msg, label...)
+	}
+	return append(msg, 0)
+}
+
+type dnssdSRV struct {
+	target string
+	port   uint16
+}
+
+// dnssdRecords collects the records of DNS-SD responses, by lower-case
+// owner name.
+type dnssdRecords struct {
+	ptr   map[string][]string
+	srv   map[string]dnssdSRV
+	txt   map[string]map[string]string
+	addrs map[string][]net.IP
+}
+
+func newDNSSDRecords() *dnssdRecords {
+	return &dnssdRecords{
+		ptr:   make(map[string][]string),
+		srv:   make(map[string]dnssdSRV),
+		txt:   make(map[string]map[string]string),
+		addrs: make(map[string][]net.IP),
+	}
+}
+
+// parse adds the answer and additional records of one DNS message.
+func (r *dnssdRecords) parse(msg []byte) error {
+	if len(msg) < dnsHeaderLength {
+		return errors.New("DNS message too short")
+	}
+	qdcount := int(binary.BigEndian.Uint16(msg[4:]))
+	rrcount := int(binary.BigEndian.Uint16(msg[6:])) +
+		int(binary.BigEndian.Uint16(msg[8:])) +
+		int(binary.BigEndian.Uint16(msg[10:]))
+
+	offset := dnsHeaderLength
+	for i := 0; i < qdcount; i++ {
+		_, next, err := readDNSName(msg, offset)
+		if err != nil {
+			return err
+		}
+		offset = next + 4
+	}
+
+	for i := 0; i < rrcount; i++ {
+		name, next, err := readDNSName(msg, offset)
+		if err != nil {
+			return err
+		}
+		if next+10 > len(msg) {
+			return errors.New("DNS record truncated")
+		}
+		rrtype := binary.BigEndian.Uint16(msg[next:])
+		rdlength := int(binary.BigEndian.Uint16(msg[next+8:]))
+		rdata := next + 10
+		if rdata+rdlength > len(msg) {
+			return errors.New("DNS record data truncated")
+		}
+		offset = rdata + rdlength
+		key := strings.ToLower(name)
+
+		switch rrtype {
+		case dnsTypePTR:
+			target, _, err := readDNSName(msg, rdata)
+			if err != nil {
+				return err
+			}
+			r.ptr[key] = append(r.ptr[key], target)
+		case dnsTypeSRV:
+			if rdlength < 7 {
+				return errors.New("DNS SRV record too short")
+			}
+			target, _, err := readDNSName(msg, rdata+6)
+			if err != nil {
+				return err
+			}
+			r.srv[key] = dnssdSRV{target, binary.BigEndian.Uint16(msg[rdata+4:])}
+		case dnsTypeTXT:
+			r.txt[key] = parseTXT(msg[rdata:offset])
+		case dnsTypeA, dnsTypeAAAA:
+			if rdlength == net.IPv4len || rdlength == net.IPv6len {
+				ip := make(net.IP, rdlength)
+				copy(ip, msg[rdata:offset])
+				r.addrs[key] = append(r.addrs[key], ip)
+			}
+		}
+	}
+	return nil
+}
+
+// services assembles the services of the PTR records of serviceFQDN.
+func (r *dnssdRecords) services(serviceFQDN string) []DNSSDService {
+	suffix := "." + serviceFQDN
+	seen := make(map[string]struct{})
+	var services []DNSSDService
+
+	for _, instance := range r.ptr[strings.ToLower(serviceFQDN)] {
+		key := strings.ToLower(instance)
+		if _, exists := seen[key]; exists {
+			continue
+		}
+		seen[key] = struct{}{}
+
+		srv, exists := r.srv[key]
+		if !exists || !strings.HasSuffix(key, strings.ToLower(suffix)) || len(key) == len(suffix) {
+			continue
+		}
+		name := instance[:len(instance)-len(suffix)]
+		services = append(services, DNSSDService{
+			Name:      strings.Replace(name, `\.`, ".", -1),
+			Host:      strings.TrimSuffix(srv.target, "."),
+			Port:      srv.port,
+			Addresses: r.addrs[strings.ToLower(srv.target)],
+			TXT:       r.txt[key],
+		})
+	}
+
+	sort.Sort(dnssdServicesByName(services))
+	return services
+}
+
+type dnssdServicesByName []DNSSDService
+
+func (s dnssdServicesByName) Len() int           { return len(s) }
+func (s dnssdServicesByName) Less(i, j int) bool { return s[i].Name < s[j].Name }
+func (s dnssdServicesByName) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// readDNSName reads a possibly compressed name at offset. Dots within labels
+// are escaped. Returns the name and the offset after it.
+func readDNSName(msg []byte, offset int) (string, int, error) {
+	var labels []string
+	next := -1
+	for pointers := 0; ; {
+		if offset >= len(msg) {
+			return "", 0, errors.New("DNS name truncated")
+		}
+		length := int(msg[offset])
+		switch {
+		case length == 0:
+			if next < 0 {
+				next = offset + 1
+			}
+			return strings.Join(labels, ".") + ".", next, nil
+
+		case length&0xc0 == 0xc0:
+			if offset+1 >= len(msg) {
+				return "", 0, errors.New("DNS name pointer truncated")
+			}
+			if pointers++; pointers > dnsMaxPointers {
+				return "", 0, errors.New("DNS name has too many pointers")
+			}
+			if next < 0 {
+				next = offset + 2
+			}
+			offset = int(binary.BigEndian.Uint16(msg[offset:]) & 0x3fff)
+
+		default:
+			if offset+1+length > len(msg) {
+				return "", 0, errors.New("DNS label truncated")
+			}
+			label := string(msg[offset+1 : offset+1+length])
+			labels = append(labels, strings.Replace(label, ".", `\.`, -1))
+			offset += 1 + length
+		}
+	}
+}
+
+// parseTXT parses the key=value strings of a TXT record.
+func parseTXT(rdata []byte) map[string]string {
+	txt := make(map[string]string)
+	for len(rdata) > 0 {
+		length := int(rdata[0])
+		if 1+length > len(rdata) {
+			break
+		}
+		s := string(rdata[1 : 1+length])
+		rdata = rdata[1+length:]
+		if s == "" {
+			continue
+		}
+		kv := strings.SplitN(s, "=", 2)
+		if len(kv) == 2 {
+			txt[kv[0]] = kv[1]
+		} else {
+			txt[kv[0]] = ""
+		}
+	}
+	return txt
+}
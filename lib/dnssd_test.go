@@ -0,0 +1,112 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package lib
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+)
+
+func appendDNSRecord(msg []byte, name []byte, rrtype uint16, rdata []byte) []byte {
+	msg = append(msg, name...)
+	msg = append(msg, byte(rrtype>>8), byte(rrtype), 0, dnsClassIN, 0, 0, 0x0e, 0x10)
+	msg = append(msg, byte(len(rdata)>>8), byte(len(rdata)))
+	return append(msg, rdata...)
+}
+
+func TestDNSSDParse(t *testing.T) {
+	msg := make([]byte, dnsHeaderLength)
+	binary.BigEndian.PutUint16(msg[6:], 2)  // ANCOUNT
+	binary.BigEndian.PutUint16(msg[10:], 3) // ARCOUNT
+
+	// The instance name has a dot in its label, and points at the service
+	// name of the first record.
+	serviceOffset := len(msg)
+	serviceName := appendDNSName(nil, "_ipp._tcp.local.")
+	instance := append([]byte{byte(len("Office v2.0"))}, "Office v2.0"...)
+	instance = append(instance, 0xc0, byte(serviceOffset))
+	msg = appendDNSRecord(msg, serviceName, dnsTypePTR, instance)
+
+	// An instance without an SRV record is ignored.
+	lost := append([]byte{byte(len("Lost"))}, "Lost"...)
+	lost = append(lost, 0xc0, byte(serviceOffset))
+	msg = appendDNSRecord(msg, []byte{0xc0, byte(serviceOffset)}, dnsTypePTR, lost)
+
+	instanceOffset := dnsHeaderLength + len(serviceName) + 10
+	host := appendDNSName(nil, "cups.local.")
+	srv := append([]byte{0, 0, 0, 0, 0x02, 0x77}, host...) // Port 631.
+	msg = appendDNSRecord(msg, []byte{0xc0, byte(instanceOffset)}, dnsTypeSRV, srv)
+	msg = appendDNSRecord(msg, []byte{0xc0, byte(instanceOffset)}, dnsTypeTXT,
+		append([]byte{byte(len("printer-type=0x1046"))}, "printer-type=0x1046"...))
+	msg = appendDNSRecord(msg, host, dnsTypeA, []byte{192, 168, 1, 5})
+
+	records := newDNSSDRecords()
+	if err := records.parse(msg); err != nil {
+		t.Fatal(err)
+	}
+	services := records.services("_ipp._tcp.local.")
+	if len(services) != 1 {
+		t.Fatalf("expected 1 service, got %+v", services)
+	}
+
+	s := services[0]
+	if s.Name != "Office v2.0" || s.Host != "cups.local" || s.Port != 631 {
+		t.Logf("expected Office v2.0 at cups.local:631, got %+v", s)
+		t.Fail()
+	}
+	if s.TXT["printer-type"] != "0x1046" {
+		t.Logf("expected TXT printer-type=0x1046, got %v", s.TXT)
+		t.Fail()
+	}
+	if a := s.Address(); a != "192.168.1.5:631" {
+		t.Logf("expected address 192.168.1.5:631, got %s", a)
+		t.Fail()
+	}
+}
+
+func TestDNSSDParsePointerLoop(t *testing.T) {
+	msg := make([]byte, dnsHeaderLength)
+	binary.BigEndian.PutUint16(msg[6:], 1)
+	msg = append(msg, 0xc0, dnsHeaderLength)
+
+	if err := newDNSSDRecords().parse(msg); err == nil {
+		t.Log("expected an error for a name that points at itself")
+		t.Fail()
+	}
+}
+
+func TestDNSSDRuleMatches(t *testing.T) {
+	s := DNSSDService{
+		Name:      "Office Laser",
+		Host:      "cups.example.com",
+		Addresses: []net.IP{net.IPv4(10, 0, 0, 1)},
+		TXT:       map[string]string{"note": "2nd floor"},
+	}
+
+	testCases := []struct {
+		rule    DNSSDRule
+		matches bool
+	}{
+		{DNSSDRule{}, true},
+		{DNSSDRule{ServiceName: "Office *"}, true},
+		{DNSSDRule{ServiceName: "Lab *"}, false},
+		{DNSSDRule{Host: "*.example.com"}, true},
+		{DNSSDRule{Host: "10.0.0.*"}, true},
+		{DNSSDRule{Host: "*.example.org"}, false},
+		{DNSSDRule{TXT: map[string]string{"note": "2nd floor"}}, true},
+		{DNSSDRule{ServiceName: "Office *", TXT: map[string]string{"note": "3rd floor"}}, false},
+	}
+	for _, tc := range testCases {
+		if m := tc.rule.Matches(&s); m != tc.matches {
+			t.Logf("expected rule %+v to match %v, got %v", tc.rule, tc.matches, m)
+			t.Fail()
+		}
+	}
+}
@@ -0,0 +1,99 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package lib
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/cloud-print-connector/cdd"
+)
+
+// AllowDuplicateVendorID is the ID of the vendor ticket item that lets a
+// user print a document again within the duplicate job window.
+const AllowDuplicateVendorID = "allow-duplicate"
+
+// AllowDuplicateCapability is advertised by every printer when duplicate
+// jobs are rejected.
+var AllowDuplicateCapability = cdd.VendorCapability{
+	ID:   AllowDuplicateVendorID,
+	Type: cdd.VendorCapabilityTypedValue,
+	TypedValueCap: &cdd.TypedValueCapability{
+		ValueType: cdd.TypedValueCapabilityTypeBoolean,
+		Default:   "false",
+	},
+	DisplayNameLocalized: cdd.NewLocalizedString("Print again if just printed"),
+}
+
+// DuplicateJobDetector remembers the content of recent jobs, to catch the
+// same document sent to the same printer by the same user twice, as when
+// the print button is double-clicked.
+type DuplicateJobDetector struct {
+	window time.Duration
+	seen   map[string]time.Time
+	mutex  sync.Mutex
+}
+
+// NewDuplicateJobDetector creates a DuplicateJobDetector that considers
+// jobs duplicates when they are less than window apart.
+func NewDuplicateJobDetector(window time.Duration) *DuplicateJobDetector {
+	return &DuplicateJobDetector{
+		window: window,
+		seen:   make(map[string]time.Time),
+	}
+}
+
+// IsDuplicate reports whether the same user sent the same file to the same
+// printer within the window, unless the ticket allows duplicates. Jobs
+// that aren't duplicates start a new window.
+func (d *DuplicateJobDetector) IsDuplicate(printerName, user, filename string, ticket *cdd.CloudJobTicket) (bool, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return false, err
+	}
+	key := fmt.Sprintf("%s\x00%s\x00%x", printerName, user, h.Sum(nil))
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	now := time.Now()
+	for k, t := range d.seen {
+		if now.Sub(t) >= d.window {
+			delete(d.seen, k)
+		}
+	}
+
+	if _, exists := d.seen[key]; exists && !allowsDuplicate(ticket) {
+		return true, nil
+	}
+	d.seen[key] = now
+	return false, nil
+}
+
+func allowsDuplicate(ticket *cdd.CloudJobTicket) bool {
+	if ticket == nil {
+		return false
+	}
+	for _, vti := range ticket.Print.VendorTicketItem {
+		if vti.ID == AllowDuplicateVendorID {
+			return vti.Value == "true"
+		}
+	}
+	return false
+}
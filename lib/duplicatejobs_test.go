@@ -0,0 +1,59 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package lib
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/cloud-print-connector/cdd"
+)
+
+func TestDuplicateJobDetector(t *testing.T) {
+	doc := writeTempFile(t, []byte("%PDF-1.4\nsome document\n"))
+	defer os.Remove(doc)
+	other := writeTempFile(t, []byte("%PDF-1.4\nanother document\n"))
+	defer os.Remove(other)
+
+	allow := &cdd.CloudJobTicket{
+		Print: cdd.PrintTicketSection{
+			VendorTicketItem: []cdd.VendorTicketItem{{ID: AllowDuplicateVendorID, Value: "true"}},
+		},
+	}
+
+	d := NewDuplicateJobDetector(time.Hour)
+	testCases := []struct {
+		printerName, user, filename string
+		ticket                      *cdd.CloudJobTicket
+		duplicate                   bool
+	}{
+		{"alpha", "joe", doc, nil, false},
+		{"alpha", "joe", doc, &cdd.CloudJobTicket{}, true},
+		{"alpha", "joe", doc, allow, false},
+		{"alpha", "jane", doc, nil, false},
+		{"beta", "joe", doc, nil, false},
+		{"alpha", "joe", other, nil, false},
+	}
+	for i, tc := range testCases {
+		duplicate, err := d.IsDuplicate(tc.printerName, tc.user, tc.filename, tc.ticket)
+		if err != nil || duplicate != tc.duplicate {
+			t.Logf("case %d: expected duplicate %t, got %t %v", i, tc.duplicate, duplicate, err)
+			t.Fail()
+		}
+	}
+
+	d = NewDuplicateJobDetector(time.Nanosecond)
+	d.IsDuplicate("alpha", "joe", doc, nil)
+	time.Sleep(time.Millisecond)
+	if duplicate, _ := d.IsDuplicate("alpha", "joe", doc, nil); duplicate {
+		t.Log("expected the window to have passed")
+		t.Fail()
+	}
+}
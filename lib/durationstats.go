@@ -0,0 +1,56 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package lib
+
+import (
+	"sync"
+	"time"
+)
+
+// DurationStats accumulates timings of a repeated operation, for monitoring.
+// The zero value is ready to use.
+type DurationStats struct {
+	mutex sync.Mutex
+	count uint
+	last  time.Duration
+	total time.Duration
+	max   time.Duration
+}
+
+// Record adds one timing.
+func (ds *DurationStats) Record(d time.Duration) {
+	ds.mutex.Lock()
+	defer ds.mutex.Unlock()
+
+	ds.count++
+	ds.last = d
+	ds.total += d
+	if d > ds.max {
+		ds.max = d
+	}
+}
+
+// Since records the time elapsed since start, and is meant to be deferred
+// at the top of the function being timed.
+func (ds *DurationStats) Since(start time.Time) {
+	ds.Record(time.Since(start))
+}
+
+// Get returns the quantity of timings recorded, and the last, mean and
+// maximum timings.
+func (ds *DurationStats) Get() (uint, time.Duration, time.Duration, time.Duration) {
+	ds.mutex.Lock()
+	defer ds.mutex.Unlock()
+
+	var mean time.Duration
+	if ds.count > 0 {
+		mean = ds.total / time.Duration(ds.count)
+	}
+	return ds.count, ds.last, mean, ds.max
+}
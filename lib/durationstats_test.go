@@ -0,0 +1,31 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package lib
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDurationStats(t *testing.T) {
+	var ds DurationStats
+	if count, last, mean, max := ds.Get(); count != 0 || last != 0 || mean != 0 || max != 0 {
+		t.Fatalf("expected zero stats, got %d %s %s %s", count, last, mean, max)
+	}
+
+	ds.Record(3 * time.Second)
+	ds.Record(5 * time.Second)
+	ds.Record(1 * time.Second)
+
+	count, last, mean, max := ds.Get()
+	if count != 3 || last != time.Second || mean != 3*time.Second || max != 5*time.Second {
+		t.Logf("expected 3 1s 3s 5s, got %d %s %s %s", count, last, mean, max)
+		t.Fail()
+	}
+}
@@ -0,0 +1,196 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package lib
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// FailoverHeartbeatPath is where a connector instance serves its
+	// heartbeat to its peer.
+	FailoverHeartbeatPath = "/heartbeat"
+
+	// DefaultFailoverTimeout is how long a standby instance waits for the
+	// active instance's heartbeat before it takes over the printers.
+	DefaultFailoverTimeout = 30 * time.Second
+)
+
+// Failover roles.
+const (
+	FailoverRoleActive  = "active"
+	FailoverRoleStandby = "standby"
+)
+
+type failoverHeartbeat struct {
+	InstanceID string `json:"instance_id"`
+	Active     bool   `json:"active"`
+}
+
+// InstanceFailover pairs an active connector instance with a standby one,
+// which take turns owning the printers. Each serves a heartbeat over HTTP;
+// the standby instance polls the active instance's.
+//
+// The active instance owns the printers whenever it runs. The standby
+// instance owns them when it has not heard the active instance's heartbeat
+// for the failover timeout, and gives them back when the active instance
+// returns.
+type InstanceFailover struct {
+	instanceID string
+	role       string
+	peerURL    string
+	timeout    time.Duration
+	client     *http.Client
+	listener   net.Listener
+
+	active        bool
+	peer          string
+	lastHeartbeat time.Time
+	mutex         sync.Mutex
+}
+
+// NewInstanceFailover creates an InstanceFailover in role (active or
+// standby), which serves its heartbeat on listenAddress. A standby instance
+// polls the heartbeat of its peer at peerURL, an absolute http or https URL.
+func NewInstanceFailover(instanceID, role, listenAddress, peerURL string, timeout time.Duration) (*InstanceFailover, error) {
+	if role != FailoverRoleActive && role != FailoverRoleStandby {
+		return nil, fmt.Errorf("Failover role %q is neither %s nor %s", role, FailoverRoleActive, FailoverRoleStandby)
+	}
+	if listenAddress == "" {
+		// The peer couldn't know the port of an empty address.
+		return nil, errors.New("Failover needs an address to serve the heartbeat on")
+	}
+	if role == FailoverRoleStandby {
+		// A standby that can't reach its peer would take over while the
+		// active instance still runs.
+		u, err := url.Parse(peerURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("Failover peer URL %q of a standby instance is not an absolute http or https URL", peerURL)
+		}
+	}
+	if timeout <= 0 {
+		timeout = DefaultFailoverTimeout
+	}
+
+	listener, err := Listen("tcp", listenAddress)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to serve the failover heartbeat: %s", err)
+	}
+
+	f := InstanceFailover{
+		instanceID: instanceID,
+		role:       role,
+		peerURL:    strings.TrimSuffix(peerURL, "/") + FailoverHeartbeatPath,
+		timeout:    timeout,
+		// Without keep-alives, a poll fails as soon as the peer dies.
+		client: &http.Client{
+			Transport: &http.Transport{DisableKeepAlives: true, TLSClientConfig: TLSConfig()},
+			Timeout:   timeout / 3,
+		},
+		listener: listener,
+		// Give the peer a full timeout to be heard from, after a restart.
+		lastHeartbeat: time.Now(),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(FailoverHeartbeatPath, f.serveHeartbeat)
+	go http.Serve(listener, mux)
+
+	return &f, nil
+}
+
+func (f *InstanceFailover) serveHeartbeat(w http.ResponseWriter, r *http.Request) {
+	f.mutex.Lock()
+	hb := failoverHeartbeat{f.instanceID, f.active}
+	f.mutex.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(hb)
+}
+
+// Renew polls the peer's heartbeat, and decides whether this instance owns
+// the printers. Returns true when it does.
+func (f *InstanceFailover) Renew(now time.Time) (bool, error) {
+	if f.role == FailoverRoleActive {
+		f.mutex.Lock()
+		defer f.mutex.Unlock()
+
+		f.active = true
+		return true, nil
+	}
+
+	hb, err := f.pollPeer()
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if err == nil {
+		f.peer, f.lastHeartbeat = hb.InstanceID, now
+		f.active = !hb.Active
+	} else if now.Sub(f.lastHeartbeat) >= f.timeout {
+		// The active instance is gone.
+		f.active = true
+	}
+
+	return f.active, err
+}
+
+func (f *InstanceFailover) pollPeer() (failoverHeartbeat, error) {
+	var hb failoverHeartbeat
+	response, err := f.client.Get(f.peerURL)
+	if err != nil {
+		return hb, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return hb, fmt.Errorf("Failover peer %s answered %s", f.peerURL, response.Status)
+	}
+	if err = json.NewDecoder(response.Body).Decode(&hb); err != nil {
+		return hb, fmt.Errorf("Failed to parse the heartbeat of failover peer %s: %s", f.peerURL, err)
+	}
+	return hb, nil
+}
+
+// Release stops serving the heartbeat, so that the peer takes over the
+// printers after the failover timeout.
+func (f *InstanceFailover) Release() error {
+	f.mutex.Lock()
+	f.active = false
+	f.mutex.Unlock()
+
+	return f.listener.Close()
+}
+
+// IsHolder returns true when this instance owns the printers.
+func (f *InstanceFailover) IsHolder() bool {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	return f.active
+}
+
+// Holder gets the ID of the instance that owns the printers.
+func (f *InstanceFailover) Holder() string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.active {
+		return f.instanceID
+	}
+	return f.peer
+}
@@ -0,0 +1,74 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package lib
+
+import (
+	"testing"
+	"time"
+)
+
+func TestInstanceFailover(t *testing.T) {
+	active, err := NewInstanceFailover("a", FailoverRoleActive, "127.0.0.1:0", "", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	standby, err := NewInstanceFailover("s", FailoverRoleStandby, "127.0.0.1:0", "http://"+active.listener.Addr().String()+"/", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer standby.Release()
+
+	now := time.Now()
+	if held, err := active.Renew(now); err != nil || !held {
+		t.Logf("expected the active instance to own the printers, got %v %v", held, err)
+		t.Fail()
+	}
+	if held, err := standby.Renew(now); err != nil || held {
+		t.Logf("expected the standby instance to stand by, got %v %v", held, err)
+		t.Fail()
+	}
+	if standby.Holder() != "a" {
+		t.Logf("expected the standby instance to see a as the holder, got %q", standby.Holder())
+		t.Fail()
+	}
+
+	// The active instance dies.
+	active.listener.Close()
+	if held, _ := standby.Renew(now.Add(time.Second)); held {
+		t.Log("expected the standby instance to wait for the failover timeout")
+		t.Fail()
+	}
+	if held, _ := standby.Renew(now.Add(2 * time.Minute)); !held {
+		t.Log("expected the standby instance to take over after the failover timeout")
+		t.Fail()
+	}
+	if standby.Holder() != "s" {
+		t.Logf("expected the standby instance to hold the printers, got %q", standby.Holder())
+		t.Fail()
+	}
+}
+
+func TestInstanceFailoverInvalid(t *testing.T) {
+	for _, c := range []struct {
+		role, listenAddress, peerURL string
+	}{
+		{FailoverRoleActive, "", ""},
+		{FailoverRoleStandby, "", "http://peer:8080"},
+		{FailoverRoleStandby, "127.0.0.1:0", ""},
+		{FailoverRoleStandby, "127.0.0.1:0", "/heartbeat"},
+		{FailoverRoleStandby, "127.0.0.1:0", "peer:8080"},
+		{FailoverRoleStandby, "127.0.0.1:0", "ftp://peer:8080"},
+	} {
+		if f, err := NewInstanceFailover("s", c.role, c.listenAddress, c.peerURL, time.Minute); err == nil {
+			f.Release()
+			t.Logf("expected an error for %s listening on %q with peer %q", c.role, c.listenAddress, c.peerURL)
+			t.Fail()
+		}
+	}
+}
@@ -0,0 +1,68 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package lib
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Faults that can be injected, to exercise retries and failover in staging,
+// when the connector is built with -tags faultinject.
+const (
+	// FaultXMPPDrop is the percent of XMPP pings that fail, which drops the
+	// XMPP conversation. 100 keeps XMPP down.
+	FaultXMPPDrop = "xmpp-drop"
+	// FaultCUPSSubmitFail is the percent of jobs that fail to be submitted
+	// to CUPS.
+	FaultCUPSSubmitFail = "cups-submit-fail"
+	// FaultDownloadDelay is how long each job download is delayed.
+	FaultDownloadDelay = "download-delay"
+)
+
+// FaultNames are the names of the faults, in the order they are listed.
+var FaultNames = []string{FaultXMPPDrop, FaultCUPSSubmitFail, FaultDownloadDelay}
+
+// parseFault validates the value of a fault: a percent from 0 to 100, or a
+// duration of zero or more, by name. Returns the percent, or nanoseconds.
+func parseFault(name, value string) (int64, error) {
+	switch name {
+	case FaultXMPPDrop, FaultCUPSSubmitFail:
+		percent, err := strconv.ParseUint(strings.TrimSuffix(value, "%"), 10, 8)
+		if err != nil || percent > 100 {
+			return 0, fmt.Errorf("Fault %s %q is not a percent from 0 to 100", name, value)
+		}
+		return int64(percent), nil
+	case FaultDownloadDelay:
+		delay, err := time.ParseDuration(value)
+		if err != nil || delay < 0 {
+			return 0, fmt.Errorf("Fault %s %q is not a duration of zero or more", name, value)
+		}
+		return int64(delay), nil
+	default:
+		return 0, fmt.Errorf("Fault %q does not exist", name)
+	}
+}
+
+// CheckFault tells why the value of a fault is invalid, or nil when it is
+// valid.
+func CheckFault(name, value string) error {
+	_, err := parseFault(name, value)
+	return err
+}
+
+// formatFault formats the value of a fault like parseFault parses it.
+func formatFault(name string, value int64) string {
+	if name == FaultDownloadDelay {
+		return time.Duration(value).String()
+	}
+	return fmt.Sprintf("%d%%", value)
+}
@@ -0,0 +1,41 @@
+// Copyright 2015 Google Inc. All rights reserved.
+
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+// +build !faultinject
+
+package lib
+
+import (
+	"context"
+	"errors"
+)
+
+// FaultInjection tells whether faults can be injected in this build.
+const FaultInjection = false
+
+// SetFault fails, because faults can't be injected without -tags
+// faultinject.
+func SetFault(name, value string) error {
+	if err := CheckFault(name, value); err != nil {
+		return err
+	}
+	return errors.New("Faults can't be injected unless the connector is built with -tags faultinject")
+}
+
+// Faults gets no faults.
+func Faults() map[string]string {
+	return map[string]string{}
+}
+
+// FaultPercent never happens.
+func FaultPercent(name string) bool {
+	return false
+}
+
+// FaultDelay doesn't wait.
+func FaultDelay(ctx context.Context, name string) error {
+	return nil
+}
@@ -0,0 +1,83 @@
+// Copyright 2015 Google Inc. All rights reserved.
+
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+// +build faultinject
+
+package lib
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// FaultInjection tells whether faults can be injected in this build.
+const FaultInjection = true
+
+var faults = struct {
+	sync.Mutex
+	values map[string]int64
+	rand   *rand.Rand
+}{
+	values: make(map[string]int64),
+	rand:   rand.New(rand.NewSource(time.Now().UnixNano())),
+}
+
+// SetFault injects a fault, like cups-submit-fail=25 or download-delay=30s.
+// A value of zero stops it.
+func SetFault(name, value string) error {
+	v, err := parseFault(name, value)
+	if err != nil {
+		return err
+	}
+
+	faults.Lock()
+	defer faults.Unlock()
+
+	faults.values[name] = v
+	return nil
+}
+
+// Faults gets the value of every fault, by name.
+func Faults() map[string]string {
+	faults.Lock()
+	defer faults.Unlock()
+
+	values := make(map[string]string, len(FaultNames))
+	for _, name := range FaultNames {
+		values[name] = formatFault(name, faults.values[name])
+	}
+	return values
+}
+
+// FaultPercent tells whether a percent fault happens this time.
+func FaultPercent(name string) bool {
+	faults.Lock()
+	defer faults.Unlock()
+
+	percent := faults.values[name]
+	return percent > 0 && faults.rand.Int63n(100) < percent
+}
+
+// FaultDelay waits for a delay fault, or until ctx is done.
+func FaultDelay(ctx context.Context, name string) error {
+	faults.Lock()
+	delay := time.Duration(faults.values[name])
+	faults.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
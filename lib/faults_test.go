@@ -0,0 +1,48 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package lib
+
+import "testing"
+
+func TestParseFault(t *testing.T) {
+	valid := map[string][]string{
+		FaultXMPPDrop:       {"0", "100"},
+		FaultCUPSSubmitFail: {"25", "25%"},
+		FaultDownloadDelay:  {"0", "30s", "1m30s"},
+	}
+	for name, values := range valid {
+		for _, value := range values {
+			v, err := parseFault(name, value)
+			if err != nil {
+				t.Logf("expected %s=%s to be valid: %s", name, value, err)
+				t.Fail()
+				continue
+			}
+			if v2, err := parseFault(name, formatFault(name, v)); err != nil || v2 != v {
+				t.Logf("expected %s=%s to format as it parses, got %s", name, value, formatFault(name, v))
+				t.Fail()
+			}
+		}
+	}
+
+	invalid := map[string][]string{
+		FaultXMPPDrop:       {"101", "-1", "half"},
+		FaultCUPSSubmitFail: {"", "1.5"},
+		FaultDownloadDelay:  {"-1s", "30"},
+		"disk-full":         {"1"},
+	}
+	for name, values := range invalid {
+		for _, value := range values {
+			if _, err := parseFault(name, value); err == nil {
+				t.Logf("expected %s=%s to be invalid", name, value)
+				t.Fail()
+			}
+		}
+	}
+}
@@ -0,0 +1,265 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package lib
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The prefixes of the groups that user lists may name, like
+// group:teachers@example.com or ldap:cn=teachers,ou=groups,dc=example,dc=com.
+const (
+	GoogleGroupPrefix = "group:"
+	LDAPGroupPrefix   = "ldap:"
+)
+
+// DefaultGroupCacheTTL is how long the members of a group are reused
+// before they are resolved again, by default.
+const DefaultGroupCacheTTL = 15 * time.Minute
+
+// groupResolveTimeout is how long resolving one group may take.
+const groupResolveTimeout = 10 * time.Second
+
+// groupRetryMinInterval and groupRetryMaxInterval bound how long to wait
+// before resolving a group again, after it failed to resolve.
+const (
+	groupRetryMinInterval = 30 * time.Second
+	groupRetryMaxInterval = 10 * time.Minute
+)
+
+// GroupSource gets the members of groups, as email addresses or user names.
+type GroupSource interface {
+	GroupMembers(ctx context.Context, group string) ([]string, error)
+}
+
+type resolvedGroup struct {
+	members []string
+	// resolved is when members were resolved; zero until they are.
+	resolved time.Time
+	// err is why the last resolve failed; retryAt is when to try again.
+	err     error
+	retryAt time.Time
+	retries uint
+	// resolving is true while a resolve is in flight.
+	resolving bool
+}
+
+// GroupResolver resolves the groups that user lists name into their
+// members, by their prefixes, and caches the members for a while. Groups
+// are resolved in the background, one resolve per group at a time, so that
+// jobs never wait for a directory. When a group can't be resolved, its
+// cached members are used, and it is tried again after a backoff; a group
+// that has never been resolved has no members.
+type GroupResolver struct {
+	sources map[string]GroupSource
+	ttl     time.Duration
+	// domains are the email domains whose addresses name the same users as
+	// bare user names.
+	domains map[string]struct{}
+
+	mutex  sync.Mutex
+	groups map[string]*resolvedGroup
+	// resolves waits for the resolves in flight, in tests.
+	resolves sync.WaitGroup
+}
+
+// NewGroupResolver resolves Google groups with google, and LDAP groups with
+// ldap, either of which may be nil, caching their members for ttl. A bare
+// user name, like a CUPS user name or an LDAP memberUid, is the same user
+// as an email address with that name in one of domains, like example.com.
+// Returns nil when both sources are nil.
+func NewGroupResolver(google, ldap GroupSource, ttl time.Duration, domains []string) *GroupResolver {
+	sources := make(map[string]GroupSource)
+	if google != nil {
+		sources[GoogleGroupPrefix] = google
+	}
+	if ldap != nil {
+		sources[LDAPGroupPrefix] = ldap
+	}
+	if len(sources) == 0 {
+		return nil
+	}
+	if ttl <= 0 {
+		ttl = DefaultGroupCacheTTL
+	}
+	r := GroupResolver{sources: sources, ttl: ttl, domains: make(map[string]struct{}), groups: make(map[string]*resolvedGroup)}
+	for _, d := range domains {
+		r.domains[strings.ToLower(strings.TrimPrefix(d, "@"))] = struct{}{}
+	}
+	return &r
+}
+
+// canResolve tells whether there is a source for a group's prefix.
+func (r *GroupResolver) canResolve(group string) bool {
+	if r == nil {
+		return false
+	}
+	_, source := r.source(group)
+	return source != nil
+}
+
+func (r *GroupResolver) source(group string) (string, GroupSource) {
+	for prefix, source := range r.sources {
+		if strings.HasPrefix(group, prefix) {
+			return strings.TrimPrefix(group, prefix), source
+		}
+	}
+	return "", nil
+}
+
+// members gets the cached members of a group, without waiting, and starts
+// resolving it in the background when its members are stale. When the
+// group isn't resolved, or failed to resolve the last time, gets the
+// members that it had, with the error.
+func (r *GroupResolver) members(group string) ([]string, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	g := r.refreshLocked(group)
+	if g.err != nil {
+		return g.members, fmt.Errorf("Failed to resolve group %s, so its %d cached members were used: %s", group, len(g.members), g.err)
+	}
+	if g.resolved.IsZero() {
+		return nil, fmt.Errorf("Group %s is not resolved yet, so it has no members", group)
+	}
+	return g.members, nil
+}
+
+// refreshLocked starts resolving a group in the background, unless its
+// members are fresh, it is being resolved, or it is backing off after a
+// failure. r.mutex must be locked.
+func (r *GroupResolver) refreshLocked(group string) *resolvedGroup {
+	g, exists := r.groups[group]
+	if !exists {
+		g = &resolvedGroup{}
+		r.groups[group] = g
+	}
+	now := time.Now()
+	if g.resolving || now.Before(g.retryAt) || (!g.resolved.IsZero() && g.err == nil && now.Sub(g.resolved) < r.ttl) {
+		return g
+	}
+
+	g.resolving = true
+	r.resolves.Add(1)
+	Go(SubsystemCUPS, func() {
+		defer r.resolves.Done()
+		r.resolve(group)
+	})
+	return g
+}
+
+// resolve gets the members of a group from its source, and caches them, or
+// backs off when it fails.
+func (r *GroupResolver) resolve(group string) {
+	name, source := r.source(group)
+	ctx, cancel := context.WithTimeout(context.Background(), groupResolveTimeout)
+	members, err := source.GroupMembers(ctx, name)
+	cancel()
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	g := r.groups[group]
+	g.resolving = false
+	if err != nil {
+		g.err = err
+		g.retries++
+		wait := groupRetryMinInterval << (g.retries - 1)
+		if wait > groupRetryMaxInterval || wait <= 0 {
+			wait = groupRetryMaxInterval
+		}
+		g.retryAt = time.Now().Add(wait)
+		return
+	}
+	g.members, g.resolved = members, time.Now()
+	g.err, g.retryAt, g.retries = nil, time.Time{}, 0
+}
+
+// UserList is the users that a policy names, directly or by their groups.
+// The nil UserList has no users.
+type UserList struct {
+	users    map[string]struct{}
+	groups   []string
+	resolver *GroupResolver
+}
+
+// NewUserList creates a UserList of entries, which are users or groups.
+// Groups are resolved by resolver, which must have a source for each.
+func NewUserList(entries []string, resolver *GroupResolver) (*UserList, error) {
+	l := UserList{users: make(map[string]struct{}), resolver: resolver}
+	for _, e := range entries {
+		if strings.HasPrefix(e, GoogleGroupPrefix) || strings.HasPrefix(e, LDAPGroupPrefix) {
+			if !resolver.canResolve(e) {
+				return nil, fmt.Errorf("Group %s can't be resolved without configuring its directory", e)
+			}
+			l.groups = append(l.groups, e)
+			// Resolve it now, so that it has members by the first job.
+			resolver.mutex.Lock()
+			resolver.refreshLocked(e)
+			resolver.mutex.Unlock()
+			continue
+		}
+		l.users[strings.ToLower(e)] = struct{}{}
+	}
+	return &l, nil
+}
+
+// Contains tells whether a user is in the list, or is a member of one of
+// its groups, ignoring case. Users in the list match exactly. A group
+// member matches exactly too, or, when one of the user and the member is a
+// bare user name and the other is an email address, when the address is
+// that name in one of the resolver's domains. Groups are never waited for:
+// when a group isn't resolved, its cached members are used, and the error is
+// returned too.
+func (l *UserList) Contains(user string) (bool, error) {
+	if l == nil {
+		return false, nil
+	}
+	user = strings.ToLower(user)
+	if _, exists := l.users[user]; exists {
+		return true, nil
+	}
+	var err error
+	for _, g := range l.groups {
+		members, e := l.resolver.members(g)
+		if e != nil {
+			err = e
+		}
+		for _, m := range members {
+			if l.resolver.sameMember(user, strings.ToLower(m)) {
+				return true, err
+			}
+		}
+	}
+	return false, err
+}
+
+// sameMember compares a lowercase user to a lowercase group member. A bare
+// name is the same as an email address only in one of r's domains.
+func (r *GroupResolver) sameMember(a, b string) bool {
+	if a == b {
+		return true
+	}
+	aAt, bAt := strings.IndexByte(a, '@'), strings.IndexByte(b, '@')
+	switch {
+	case aAt >= 0 && bAt < 0:
+		return a[:aAt] == b && r.inDomain(a[aAt+1:])
+	case aAt < 0 && bAt >= 0:
+		return a == b[:bAt] && r.inDomain(b[bAt+1:])
+	}
+	return false
+}
+
+func (r *GroupResolver) inDomain(domain string) bool {
+	_, exists := r.domains[domain]
+	return exists
+}
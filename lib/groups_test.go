@@ -0,0 +1,178 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package lib
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type fakeGroupSource struct {
+	members  map[string][]string
+	err      error
+	resolves int
+}
+
+func (s *fakeGroupSource) GroupMembers(ctx context.Context, group string) ([]string, error) {
+	s.resolves++
+	if s.err != nil {
+		return nil, s.err
+	}
+	return s.members[group], nil
+}
+
+func TestUserList(t *testing.T) {
+	if _, err := NewUserList([]string{"group:teachers@example.com"}, nil); err == nil {
+		t.Log("expected a group without a directory to be rejected")
+		t.Fail()
+	}
+
+	google := &fakeGroupSource{members: map[string][]string{
+		"teachers@example.com": []string{"Alice@example.com", "bob@partner.com", "carol"},
+	}}
+	r := NewGroupResolver(google, nil, time.Hour, []string{"example.com"})
+	if _, err := NewUserList([]string{"ldap:cn=staff,dc=example,dc=com"}, r); err == nil {
+		t.Log("expected an LDAP group without an LDAP server to be rejected")
+		t.Fail()
+	}
+	l, err := NewUserList([]string{"Boss@example.com", "group:teachers@example.com"}, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.resolves.Wait()
+
+	for user, expected := range map[string]bool{
+		"boss@example.com":  true,
+		"boss":              false,
+		"alice@example.com": true,
+		// A bare name is the member's address only in a configured domain.
+		"alice":             true,
+		"bob@partner.com":   true,
+		"bob":               false,
+		"carol":             true,
+		"carol@example.com": true,
+		"carol@partner.com": false,
+		"alice@partner.com": false,
+		"dave@example.com":  false,
+	} {
+		if in, err := l.Contains(user); in != expected || err != nil {
+			t.Logf("expected %s in list to be %t, got %t, %v", user, expected, in, err)
+			t.Fail()
+		}
+	}
+	if google.resolves != 1 {
+		t.Logf("expected the group to be resolved once, and then cached, got %d resolves", google.resolves)
+		t.Fail()
+	}
+
+	// Without domains, members match exactly.
+	exact, err := NewUserList([]string{"group:teachers@example.com"}, NewGroupResolver(google, nil, time.Hour, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	exact.resolver.resolves.Wait()
+	for user, expected := range map[string]bool{"alice@example.com": true, "alice": false, "carol": true, "carol@example.com": false} {
+		if in, _ := exact.Contains(user); in != expected {
+			t.Logf("expected %s in the exact list to be %t", user, expected)
+			t.Fail()
+		}
+	}
+
+	// A stale group is resolved in the background; the failure gives the
+	// cached members, with the error, and backs off.
+	google.err = errors.New("directory is down")
+	r.groups["group:teachers@example.com"].resolved = time.Now().Add(-2 * time.Hour)
+	l.Contains("bob@partner.com")
+	r.resolves.Wait()
+	for i := 0; i < 3; i++ {
+		if in, err := l.Contains("bob@partner.com"); !in || err == nil {
+			t.Logf("expected the cached members, and the error, when the group can't be resolved; got %t, %v", in, err)
+			t.Fail()
+		}
+	}
+	r.resolves.Wait()
+	if google.resolves != 3 {
+		t.Logf("expected one failed resolve, and then a backoff, got %d resolves", google.resolves-2)
+		t.Fail()
+	}
+
+	if in, err := (*UserList)(nil).Contains("anyone"); in || err != nil {
+		t.Log("expected the nil list to have no users")
+		t.Fail()
+	}
+}
+
+func TestGroupResolverDoesNotBlock(t *testing.T) {
+	release := make(chan struct{})
+	source := &blockingGroupSource{release: release}
+	r := NewGroupResolver(source, nil, time.Hour, nil)
+	l, err := NewUserList([]string{"group:slow@example.com"}, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 10; i++ {
+			l.Contains("alice@example.com")
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected jobs not to wait for a group to resolve")
+	}
+	if in, err := l.Contains("alice@example.com"); in || err == nil {
+		t.Logf("expected an unresolved group to have no members, with an error; got %t, %v", in, err)
+		t.Fail()
+	}
+
+	close(release)
+	r.resolves.Wait()
+	if source.resolves != 1 {
+		t.Logf("expected concurrent lookups to share one resolve, got %d", source.resolves)
+		t.Fail()
+	}
+	if in, err := l.Contains("alice@example.com"); !in || err != nil {
+		t.Logf("expected the resolved members, got %t, %v", in, err)
+		t.Fail()
+	}
+}
+
+type blockingGroupSource struct {
+	release  chan struct{}
+	resolves int
+}
+
+func (s *blockingGroupSource) GroupMembers(ctx context.Context, group string) ([]string, error) {
+	s.resolves++
+	<-s.release
+	return []string{"alice@example.com"}, nil
+}
+
+func TestParseLDAPMembers(t *testing.T) {
+	ldif := "dn: cn=staff,ou=groups,dc=example,dc=com\n" +
+		"member: uid=alice,ou=people,dc=example,dc=com\n" +
+		"uniqueMember: cn=Bob Smith,ou=people,dc=example,dc=com\n" +
+		"memberUid: carol\n" +
+		// uid=dave,ou=people
+		"member:: dWlkPWRhdmUsb3U9cGVvcGxl\n"
+	members, err := parseLDAPMembers([]byte(ldif))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"alice", "Bob Smith", "carol", "dave"}; !reflect.DeepEqual(members, expected) {
+		t.Logf("expected members %v, got %v", expected, members)
+		t.Fail()
+	}
+}
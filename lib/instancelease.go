@@ -0,0 +1,161 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// InstanceIDTag publishes which connector instance owns a printer.
+	InstanceIDTag = "connector-instance-id"
+
+	// InstanceLeaseDuration is how long an instance owns the printers after
+	// it last renewed its lease.
+	InstanceLeaseDuration = 30 * time.Second
+)
+
+// InstanceID gets the ID of this connector instance: configured, or else
+// the host name.
+func InstanceID(configured string) string {
+	if configured != "" {
+		return configured
+	}
+	if hostname, err := os.Hostname(); err == nil && hostname != "" {
+		return hostname
+	}
+	return "unknown"
+}
+
+type instanceLeaseFile struct {
+	InstanceID string    `json:"instance_id"`
+	Expires    time.Time `json:"expires"`
+}
+
+// InstanceLease coordinates connector instances that share a printer
+// system, through a lease file that they can all reach. Only the instance
+// that holds the lease touches the printers; the others stand by.
+type InstanceLease struct {
+	filename   string
+	instanceID string
+	duration   time.Duration
+
+	holder  string
+	expires time.Time
+	mutex   sync.Mutex
+}
+
+// NewInstanceLease creates an InstanceLease for instanceID, which holds the
+// lease in filename for duration after each renewal.
+func NewInstanceLease(filename, instanceID string, duration time.Duration) *InstanceLease {
+	return &InstanceLease{
+		filename:   filename,
+		instanceID: instanceID,
+		duration:   duration,
+	}
+}
+
+// Renew takes the lease if it is free or expired, or extends it if this
+// instance holds it already. Returns true when this instance holds the lease.
+//
+// When the lease file can't be read or written, this instance holds the
+// lease until it expires, like any other instance would believe.
+func (l *InstanceLease) Renew(now time.Time) (bool, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	current, err := l.read()
+	if err != nil {
+		return l.isHolder(now), err
+	}
+
+	if current.InstanceID != "" && current.InstanceID != l.instanceID && now.Before(current.Expires) {
+		l.holder, l.expires = current.InstanceID, current.Expires
+		return false, nil
+	}
+
+	mine := instanceLeaseFile{l.instanceID, now.Add(l.duration)}
+	if err = l.write(mine); err != nil {
+		return l.isHolder(now), err
+	}
+
+	// Two instances may have taken a free lease at once; the last write wins.
+	if current, err = l.read(); err != nil {
+		return l.isHolder(now), err
+	}
+	l.holder, l.expires = current.InstanceID, current.Expires
+	return l.isHolder(now), nil
+}
+
+// Release gives up the lease, if this instance holds it, so that another
+// instance may take it without waiting for it to expire.
+func (l *InstanceLease) Release() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	current, err := l.read()
+	if err != nil {
+		return err
+	}
+	l.holder, l.expires = "", time.Time{}
+	if current.InstanceID != l.instanceID {
+		return nil
+	}
+	return os.Remove(l.filename)
+}
+
+// IsHolder returns true when this instance holds an unexpired lease.
+func (l *InstanceLease) IsHolder() bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return l.isHolder(time.Now())
+}
+
+// Holder gets the ID of the instance that held the lease when it was last
+// renewed; empty when nobody did.
+func (l *InstanceLease) Holder() string {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return l.holder
+}
+
+func (l *InstanceLease) isHolder(now time.Time) bool {
+	return l.holder == l.instanceID && now.Before(l.expires)
+}
+
+// read gets the lease file; a missing file is a free lease.
+func (l *InstanceLease) read() (instanceLeaseFile, error) {
+	var f instanceLeaseFile
+	b, _, err := ReadStateFile(l.filename, validJSON)
+	if os.IsNotExist(err) {
+		return f, nil
+	} else if err != nil {
+		return f, err
+	}
+	if err = json.Unmarshal(b, &f); err != nil {
+		return f, fmt.Errorf("Failed to parse instance lease file %s: %s", l.filename, err)
+	}
+	return f, nil
+}
+
+// write replaces the lease file in one step, so that readers never see
+// half of it.
+func (l *InstanceLease) write(f instanceLeaseFile) error {
+	b, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	return WriteStateFile(l.filename, b, 0644)
+}
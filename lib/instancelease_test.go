@@ -0,0 +1,72 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package lib
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestInstanceLease(t *testing.T) {
+	dir, err := ioutil.TempDir("", "instancelease")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "lease")
+
+	a := NewInstanceLease(filename, "a", time.Minute)
+	b := NewInstanceLease(filename, "b", time.Minute)
+	now := time.Now()
+
+	if held, err := a.Renew(now); err != nil || !held {
+		t.Logf("expected a to take the free lease, got %v %v", held, err)
+		t.Fail()
+	}
+	if held, err := b.Renew(now.Add(time.Second)); err != nil || held {
+		t.Logf("expected b to stand by while a holds the lease, got %v %v", held, err)
+		t.Fail()
+	}
+	if b.Holder() != "a" {
+		t.Logf("expected b to see a as the holder, got %q", b.Holder())
+		t.Fail()
+	}
+	if held, err := a.Renew(now.Add(30 * time.Second)); err != nil || !held {
+		t.Logf("expected a to renew its lease, got %v %v", held, err)
+		t.Fail()
+	}
+
+	// a stops renewing.
+	if held, err := b.Renew(now.Add(2 * time.Minute)); err != nil || !held {
+		t.Logf("expected b to take the expired lease, got %v %v", held, err)
+		t.Fail()
+	}
+	if held, err := a.Renew(now.Add(2*time.Minute + time.Second)); err != nil || held {
+		t.Logf("expected a to lose the lease to b, got %v %v", held, err)
+		t.Fail()
+	}
+
+	if err := a.Release(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filename); err != nil {
+		t.Logf("expected a not to release the lease of b: %s", err)
+		t.Fail()
+	}
+	if err := b.Release(); err != nil {
+		t.Fatal(err)
+	}
+	if held, err := a.Renew(now.Add(2*time.Minute + 2*time.Second)); err != nil || !held {
+		t.Logf("expected a to take the released lease, got %v %v", held, err)
+		t.Fail()
+	}
+}
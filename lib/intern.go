@@ -0,0 +1,71 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package lib
+
+import "sync"
+
+// StringInterner deduplicates strings that repeat across many printers,
+// like attribute names and common attribute values, so that each poll
+// doesn't retain its own copy of every one of them.
+//
+// When the table grows past maxEntries it is discarded and rebuilt, which
+// bounds memory when values are mostly unique (timestamps, serial numbers).
+type StringInterner struct {
+	m          map[string]string
+	maxEntries int
+	mutex      sync.Mutex
+}
+
+func NewStringInterner(maxEntries int) *StringInterner {
+	return &StringInterner{
+		m:          make(map[string]string),
+		maxEntries: maxEntries,
+	}
+}
+
+// Intern returns a canonical copy of s.
+func (si *StringInterner) Intern(s string) string {
+	si.mutex.Lock()
+	defer si.mutex.Unlock()
+
+	if c, exists := si.m[s]; exists {
+		return c
+	}
+	if len(si.m) >= si.maxEntries {
+		si.m = make(map[string]string)
+	}
+	si.m[s] = s
+	return s
+}
+
+// InternBytes returns a canonical string equal to b, allocating
+// only when the string hasn't been seen before.
+func (si *StringInterner) InternBytes(b []byte) string {
+	si.mutex.Lock()
+	defer si.mutex.Unlock()
+
+	// The compiler optimizes string(b) map lookups to not allocate.
+	if c, exists := si.m[string(b)]; exists {
+		return c
+	}
+	if len(si.m) >= si.maxEntries {
+		si.m = make(map[string]string)
+	}
+	s := string(b)
+	si.m[s] = s
+	return s
+}
+
+// Len returns the number of strings currently interned.
+func (si *StringInterner) Len() int {
+	si.mutex.Lock()
+	defer si.mutex.Unlock()
+
+	return len(si.m)
+}
@@ -0,0 +1,29 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package lib
+
+import "testing"
+
+func TestStringInterner(t *testing.T) {
+	si := NewStringInterner(2)
+
+	a := si.InternBytes([]byte("idle"))
+	b := si.Intern("idle")
+	if a != b || si.Len() != 1 {
+		t.Logf("expected one interned string, got %d", si.Len())
+		t.Fail()
+	}
+
+	si.Intern("processing")
+	si.Intern("stopped")
+	if si.Len() != 1 {
+		t.Logf("expected table to be reset when full, got %d entries", si.Len())
+		t.Fail()
+	}
+}
@@ -8,7 +8,12 @@ https://developers.google.com/open-source/licenses/bsd
 
 package lib
 
-import "github.com/google/cloud-print-connector/cdd"
+import (
+	"context"
+	"time"
+
+	"github.com/google/cloud-print-connector/cdd"
+)
 
 type Job struct {
 	NativePrinterName string
@@ -17,5 +22,18 @@ type Job struct {
 	User              string
 	JobID             string
 	Ticket            *cdd.CloudJobTicket
-	UpdateJob         func(string, *cdd.PrintJobStateDiff) error
+	// Deadline is when the job is aborted, if it hasn't finished; zero is none.
+	Deadline time.Time
+	// UpdateJob reports the state of the job; a failed job's state comes
+	// with a message for its user.
+	UpdateJob func(ctx context.Context, jobID string, state *cdd.PrintJobStateDiff, userMessage string) error
+}
+
+// PanicState is the state of a job whose processing panicked. The panic is
+// the connector's fault, not the user's or the printer's.
+func PanicState() *cdd.JobState {
+	return &cdd.JobState{
+		Type:               cdd.JobStateAborted,
+		ServiceActionCause: &cdd.ServiceActionCause{ErrorCode: cdd.ServiceActionCauseOther},
+	}
 }
@@ -0,0 +1,207 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package lib
+
+import (
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/cloud-print-connector/cdd"
+)
+
+// CachedJob describes a job whose downloaded file is kept to be reprinted.
+type CachedJob struct {
+	JobID             string    `json:"job_id"`
+	NativePrinterName string    `json:"native_printer_name"`
+	Title             string    `json:"title"`
+	User              string    `json:"user"`
+	Bytes             int64     `json:"bytes"`
+	Printing          bool      `json:"printing"`
+	Released          time.Time `json:"released"`
+
+	filename string
+	ticket   *cdd.CloudJobTicket
+	holds    uint
+}
+
+// JobCache keeps the downloaded files of jobs, up to a quantity of bytes,
+// so that they can be reprinted without being downloaded again. A file is
+// held while its job prints, and evicted, least recently released first,
+// only when no job holds it. A nil JobCache keeps nothing.
+type JobCache struct {
+	maxBytes int64
+	mutex    sync.Mutex
+	bytes    int64
+	jobs     map[string]*CachedJob
+	// byFilename maps filenames to job IDs.
+	byFilename map[string]string
+}
+
+// NewJobCache creates a JobCache of up to maxBytes, or nil when maxBytes is
+// zero.
+func NewJobCache(maxBytes int64) *JobCache {
+	if maxBytes <= 0 {
+		return nil
+	}
+	return &JobCache{
+		maxBytes:   maxBytes,
+		jobs:       make(map[string]*CachedJob),
+		byFilename: make(map[string]string),
+	}
+}
+
+// Add caches the file of a job that is about to print, held until Release.
+// Returns false when the file isn't cached, because it doesn't fit, or
+// because the job is cached already; Release removes it then.
+func (c *JobCache) Add(job *Job) bool {
+	if c == nil {
+		return false
+	}
+	fi, err := os.Stat(job.Filename)
+	if err != nil {
+		return false
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if _, exists := c.jobs[job.JobID]; exists {
+		return false
+	}
+	c.evict(fi.Size())
+	if c.bytes+fi.Size() > c.maxBytes {
+		return false
+	}
+
+	c.jobs[job.JobID] = &CachedJob{
+		JobID:             job.JobID,
+		NativePrinterName: job.NativePrinterName,
+		Title:             job.Title,
+		User:              job.User,
+		Bytes:             fi.Size(),
+		filename:          job.Filename,
+		ticket:            job.Ticket,
+		holds:             1,
+	}
+	c.byFilename[job.Filename] = job.JobID
+	c.bytes += fi.Size()
+	return true
+}
+
+// Hold holds the cached file of a job, to print it again, until Release.
+// Returns the job, with the filename and ticket to print it with.
+func (c *JobCache) Hold(jobID string) (*Job, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	cached, exists := c.jobs[jobID]
+	if !exists {
+		return nil, false
+	}
+	cached.holds++
+	return &Job{
+		NativePrinterName: cached.NativePrinterName,
+		Filename:          cached.filename,
+		Title:             cached.Title,
+		User:              cached.User,
+		JobID:             cached.JobID,
+		Ticket:            cached.ticket,
+	}, true
+}
+
+// Release releases a file held by Add or Hold, once its job has finished.
+// A file that isn't cached is removed.
+func (c *JobCache) Release(filename string) {
+	if c == nil {
+		os.Remove(filename)
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	jobID, exists := c.byFilename[filename]
+	if !exists {
+		os.Remove(filename)
+		return
+	}
+	cached := c.jobs[jobID]
+	if cached.holds > 0 {
+		cached.holds--
+	}
+	cached.Released = time.Now()
+	c.evict(0)
+}
+
+// evict removes the files of the least recently released jobs that no job
+// holds, until there is room for more bytes.
+func (c *JobCache) evict(more int64) {
+	if c.bytes+more <= c.maxBytes {
+		return
+	}
+	var idle []*CachedJob
+	for _, cached := range c.jobs {
+		if cached.holds == 0 {
+			idle = append(idle, cached)
+		}
+	}
+	sort.Slice(idle, func(i, j int) bool { return idle[i].Released.Before(idle[j].Released) })
+	for _, cached := range idle {
+		if c.bytes+more <= c.maxBytes {
+			return
+		}
+		c.remove(cached)
+	}
+}
+
+func (c *JobCache) remove(cached *CachedJob) {
+	os.Remove(cached.filename)
+	delete(c.jobs, cached.JobID)
+	delete(c.byFilename, cached.filename)
+	c.bytes -= cached.Bytes
+}
+
+// Jobs gets the cached jobs, most recently released first.
+func (c *JobCache) Jobs() []CachedJob {
+	if c == nil {
+		return []CachedJob{}
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	jobs := make([]CachedJob, 0, len(c.jobs))
+	for _, cached := range c.jobs {
+		job := *cached
+		job.Printing = cached.holds > 0
+		jobs = append(jobs, job)
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].Released.After(jobs[j].Released) })
+	return jobs
+}
+
+// Clear removes the cached files, at shutdown. The files of jobs that are
+// printing are removed when they are released.
+func (c *JobCache) Clear() {
+	if c == nil {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.maxBytes = 0
+	c.evict(0)
+}
@@ -0,0 +1,108 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package lib
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestJobCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jobcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	newJob := func(jobID string, size int) *Job {
+		filename := filepath.Join(dir, jobID)
+		if err := ioutil.WriteFile(filename, make([]byte, size), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return &Job{JobID: jobID, NativePrinterName: "printer", Filename: filename}
+	}
+	exists := func(filename string) bool {
+		_, err := os.Stat(filename)
+		return err == nil
+	}
+
+	c := NewJobCache(100)
+	a, b, big := newJob("a", 60), newJob("b", 60), newJob("big", 200)
+
+	if !c.Add(a) {
+		t.Fatal("expected job a to be cached")
+	}
+	if c.Add(b) {
+		t.Log("expected job b not to fit while job a prints")
+		t.Fail()
+	}
+	c.Release(b.Filename)
+	if exists(b.Filename) {
+		t.Log("expected the file of job b, which isn't cached, to be removed")
+		t.Fail()
+	}
+	if c.Add(big) {
+		t.Log("expected a job larger than the cache not to be cached")
+		t.Fail()
+	}
+	c.Release(big.Filename)
+
+	c.Release(a.Filename)
+	if !exists(a.Filename) {
+		t.Fatal("expected the file of job a to be kept after it printed")
+	}
+	held, ok := c.Hold("a")
+	if !ok || held.Filename != a.Filename {
+		t.Fatalf("expected to hold job a, got %+v", held)
+	}
+	if jobs := c.Jobs(); len(jobs) != 1 || !jobs[0].Printing || jobs[0].Bytes != 60 {
+		t.Logf("expected job a to be listed as printing, got %+v", jobs)
+		t.Fail()
+	}
+	c.Release(held.Filename)
+
+	b = newJob("b", 60)
+	if !c.Add(b) {
+		t.Log("expected job b to be cached in place of job a")
+		t.Fail()
+	}
+	if exists(a.Filename) {
+		t.Log("expected the file of job a to be evicted")
+		t.Fail()
+	}
+	if _, ok := c.Hold("a"); ok {
+		t.Log("expected job a not to be held after it was evicted")
+		t.Fail()
+	}
+
+	c.Clear()
+	if !exists(b.Filename) {
+		t.Log("expected the file of job b to be kept while it prints")
+		t.Fail()
+	}
+	c.Release(b.Filename)
+	if exists(b.Filename) {
+		t.Log("expected the file of job b to be removed after the cache was cleared")
+		t.Fail()
+	}
+
+	var nilCache *JobCache
+	n := newJob("n", 10)
+	if nilCache.Add(n) {
+		t.Log("expected a nil cache to cache nothing")
+		t.Fail()
+	}
+	nilCache.Release(n.Filename)
+	if exists(n.Filename) {
+		t.Log("expected a nil cache to remove released files")
+		t.Fail()
+	}
+}
@@ -0,0 +1,207 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"time"
+)
+
+// jobErrorLogMaxRecords is how many records a JobErrorLog keeps.
+const jobErrorLogMaxRecords = 1000
+
+// JobErrorStage is the stage of its processing at which a job failed.
+type JobErrorStage string
+
+const (
+	// JobErrorStageAssemble is getting the job's ticket and document.
+	JobErrorStageAssemble JobErrorStage = "assemble"
+	// JobErrorStageTranslate is translating the job's ticket to native
+	// options.
+	JobErrorStageTranslate JobErrorStage = "translate"
+	// JobErrorStageSubmit is submitting the job to the native print system.
+	JobErrorStageSubmit JobErrorStage = "submit"
+	// JobErrorStagePrint is following the job while it prints.
+	JobErrorStagePrint JobErrorStage = "print"
+)
+
+// JobErrorKind is what went wrong with a job, as its user sees it.
+type JobErrorKind string
+
+const (
+	JobErrorInternal            JobErrorKind = "internal"
+	JobErrorDownload            JobErrorKind = "download"
+	JobErrorTooLarge            JobErrorKind = "too-large"
+	JobErrorDocumentUnsupported JobErrorKind = "document-unsupported"
+	JobErrorPassword            JobErrorKind = "password"
+	JobErrorTicketUnsupported   JobErrorKind = "ticket-unsupported"
+	JobErrorNotAllowed          JobErrorKind = "not-allowed"
+	JobErrorDuplicate           JobErrorKind = "duplicate"
+	JobErrorPrinterUnavailable  JobErrorKind = "printer-unavailable"
+	JobErrorPrintFailed         JobErrorKind = "print-failed"
+	JobErrorStatusUnknown       JobErrorKind = "status-unknown"
+	JobErrorTimeout             JobErrorKind = "timeout"
+)
+
+// IsPrinterFault tells whether a kind of error is the printer's fault,
+// rather than the job's or the connector's.
+func (k JobErrorKind) IsPrinterFault() bool {
+	return k == JobErrorPrintFailed || k == JobErrorPrinterUnavailable
+}
+
+// builtinJobErrorMessages are the English messages that users see, by kind.
+var builtinJobErrorMessages = map[JobErrorKind]string{
+	JobErrorInternal:            "The print connector had a problem with this job. Ask your administrator for help.",
+	JobErrorDownload:            "The document couldn't be downloaded. Try printing it again.",
+	JobErrorTooLarge:            "The document is too large for this printer.",
+	JobErrorDocumentUnsupported: "This printer can't print this kind of document.",
+	JobErrorPassword:            "The document is protected by a password, which is missing or wrong.",
+	JobErrorTicketUnsupported:   "This printer doesn't support the print settings of this job.",
+	JobErrorNotAllowed:          "You aren't allowed to print this job on this printer.",
+	JobErrorDuplicate:           "This job is the same as one you just sent, so it wasn't printed again.",
+	JobErrorPrinterUnavailable:  "The printer isn't accepting jobs right now. Try again later.",
+	JobErrorPrintFailed:         "The printer failed to print this job.",
+	JobErrorStatusUnknown:       "The job was sent to the printer, but whether it printed is unknown.",
+	JobErrorTimeout:             "The job took too long, and was stopped.",
+}
+
+// JobError is a failed job, described twice: by its kind, which selects a
+// concise message for the job's user, and by its technical details, for
+// administrators. Error gets the technical details.
+type JobError struct {
+	Kind  JobErrorKind
+	Stage JobErrorStage
+	// IPPStatus is the status code of the IPP request that failed; zero
+	// when no IPP request failed.
+	IPPStatus int
+	Err       error
+}
+
+func (e *JobError) Error() string {
+	return e.Err.Error()
+}
+
+// AsJobError gets err as a JobError, or else makes it one of kind, at stage.
+func AsJobError(err error, kind JobErrorKind, stage JobErrorStage) *JobError {
+	if e, ok := err.(*JobError); ok {
+		return e
+	}
+	return &JobError{Kind: kind, Stage: stage, Err: err}
+}
+
+// JobErrorMessages replaces the built-in messages that users see, by kind,
+// so that they can be reworded, or written in the users' language. The nil
+// value has only the built-in messages.
+type JobErrorMessages map[JobErrorKind]string
+
+// NewJobErrorMessages reads a JSON file of messages, like
+// {"print-failed": "Der Drucker konnte den Auftrag nicht drucken."}.
+func NewJobErrorMessages(filename string) (JobErrorMessages, error) {
+	if filename == "" {
+		return nil, nil
+	}
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var m JobErrorMessages
+	if err = json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("Failed to parse job error messages file %s: %s", filename, err)
+	}
+	return m, nil
+}
+
+// UserMessage gets the message that users see for a kind of error.
+func (m JobErrorMessages) UserMessage(kind JobErrorKind) string {
+	if message, exists := m[kind]; exists && message != "" {
+		return message
+	}
+	if message, exists := builtinJobErrorMessages[kind]; exists {
+		return message
+	}
+	return builtinJobErrorMessages[JobErrorInternal]
+}
+
+// JobErrorRecord is the technical record of a failed job, for
+// administrators, with the message that its user saw.
+type JobErrorRecord struct {
+	Time        time.Time     `json:"time"`
+	JobID       string        `json:"job_id"`
+	PrinterName string        `json:"printer_name"`
+	Stage       JobErrorStage `json:"stage"`
+	Kind        JobErrorKind  `json:"kind"`
+	IPPStatus   string        `json:"ipp_status,omitempty"`
+	Detail      string        `json:"detail"`
+	UserMessage string        `json:"user_message"`
+}
+
+// JobErrorLog tells users why their jobs failed, and keeps the technical
+// records of the most recent failures in memory, so that an administrator
+// can find out more than the users saw. A nil JobErrorLog has only the
+// built-in messages, and keeps nothing.
+type JobErrorLog struct {
+	messages   JobErrorMessages
+	maxRecords int
+
+	mutex   sync.Mutex
+	records []JobErrorRecord
+}
+
+// NewJobErrorLog creates a JobErrorLog that tells users messages.
+func NewJobErrorLog(messages JobErrorMessages) *JobErrorLog {
+	return &JobErrorLog{messages: messages, maxRecords: jobErrorLogMaxRecords}
+}
+
+// Add records a failed job. Returns the message to tell its user.
+func (l *JobErrorLog) Add(jobID, printerName string, err *JobError) string {
+	if l == nil {
+		return JobErrorMessages(nil).UserMessage(err.Kind)
+	}
+	r := JobErrorRecord{
+		Time:        time.Now(),
+		JobID:       jobID,
+		PrinterName: printerName,
+		Stage:       err.Stage,
+		Kind:        err.Kind,
+		Detail:      err.Error(),
+		UserMessage: l.messages.UserMessage(err.Kind),
+	}
+	if err.IPPStatus != 0 {
+		r.IPPStatus = fmt.Sprintf("0x%04x", err.IPPStatus)
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.records = append(l.records, r)
+	if len(l.records) > l.maxRecords {
+		l.records = append([]JobErrorRecord(nil), l.records[len(l.records)-l.maxRecords:]...)
+	}
+	return r.UserMessage
+}
+
+// Records gets the records of one job, or of every job when jobID is
+// empty, oldest first.
+func (l *JobErrorLog) Records(jobID string) []JobErrorRecord {
+	records := []JobErrorRecord{}
+	if l == nil {
+		return records
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	for _, r := range l.records {
+		if jobID == "" || r.JobID == jobID {
+			records = append(records, r)
+		}
+	}
+	return records
+}
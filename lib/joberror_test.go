@@ -0,0 +1,54 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package lib
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestJobErrorLog(t *testing.T) {
+	var nilLog *JobErrorLog
+	if m := nilLog.Add("job", "printer", &JobError{Kind: JobErrorTooLarge, Err: errors.New("too large")}); m != builtinJobErrorMessages[JobErrorTooLarge] {
+		t.Logf("expected the built-in message from a nil log, got %q", m)
+		t.Fail()
+	}
+
+	l := NewJobErrorLog(JobErrorMessages{JobErrorPrintFailed: "Der Drucker konnte den Auftrag nicht drucken."})
+	l.maxRecords = 2
+
+	jobErr := AsJobError(errors.New("IPP status code 1284: device error"), JobErrorPrintFailed, JobErrorStageSubmit)
+	jobErr.IPPStatus = 0x0504
+	if AsJobError(jobErr, JobErrorInternal, JobErrorStagePrint) != jobErr {
+		t.Log("expected AsJobError to keep a JobError as it is")
+		t.Fail()
+	}
+	if m := l.Add("a", "printer", jobErr); m != "Der Drucker konnte den Auftrag nicht drucken." {
+		t.Logf("expected the configured message, got %q", m)
+		t.Fail()
+	}
+	if m := l.Add("b", "printer", &JobError{Kind: "no-such-kind", Err: errors.New("?")}); m != builtinJobErrorMessages[JobErrorInternal] {
+		t.Logf("expected the internal message for an unknown kind, got %q", m)
+		t.Fail()
+	}
+	l.Add("c", "printer", &JobError{Kind: JobErrorTimeout, Stage: JobErrorStagePrint, Err: errors.New("deadline")})
+
+	if records := l.Records(""); len(records) != 2 || records[0].JobID != "b" || records[1].JobID != "c" {
+		t.Logf("expected the 2 newest records, got %+v", records)
+		t.Fail()
+	}
+	l.maxRecords = 10
+	l.Add("a", "printer", jobErr)
+	records := l.Records("a")
+	if len(records) != 1 || records[0].IPPStatus != "0x0504" || records[0].Stage != JobErrorStageSubmit ||
+		records[0].Detail != "IPP status code 1284: device error" || records[0].Kind != JobErrorPrintFailed {
+		t.Logf("unexpected records of job a %+v", records)
+		t.Fail()
+	}
+}
@@ -0,0 +1,157 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package lib
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"time"
+)
+
+const (
+	// pdfPageCountChunkBytes is how much of a PDF to search for pages at a time.
+	pdfPageCountChunkBytes = 1024 * 1024
+	// pdfPageCountOverlap is longer than any match of rPDFPage, so that
+	// pages split between chunks are found.
+	pdfPageCountOverlap = 64
+)
+
+// rPDFPage matches the type of a page object, but not of a page tree node,
+// which is /Pages.
+var rPDFPage = regexp.MustCompile(`/Type\s{0,8}/Page([^s]|$)`)
+
+// JobLimits bounds the size of the jobs that a printer accepts, how long
+// they may take to print, and how fast they download. Zero is no limit.
+type JobLimits struct {
+	MaxBytes                  int64 `json:"max_bytes,omitempty"`
+	MaxPages                  uint  `json:"max_pages,omitempty"`
+	MaxSeconds                uint  `json:"max_seconds,omitempty"`
+	MaxDownloadBytesPerSecond int64 `json:"max_download_bytes_per_second,omitempty"`
+}
+
+// JobTooLargeError describes a job that is over its printer's limits.
+type JobTooLargeError struct {
+	Reason string
+}
+
+func (e *JobTooLargeError) Error() string {
+	return e.Reason
+}
+
+// JobLimiter keeps large jobs away from slow printers and metered devices.
+// A nil JobLimiter limits nothing.
+type JobLimiter struct {
+	defaults JobLimits
+	printers map[string]JobLimits
+}
+
+// NewJobLimiter creates a JobLimiter that applies defaults to every
+// printer, except where printers, by native printer name, says otherwise.
+func NewJobLimiter(defaults JobLimits, printers map[string]JobLimits) *JobLimiter {
+	return &JobLimiter{defaults, printers}
+}
+
+// Limits gets the limits of one printer.
+func (l *JobLimiter) Limits(printerName string) JobLimits {
+	if l == nil {
+		return JobLimits{}
+	}
+	limits := l.defaults
+	if p, exists := l.printers[printerName]; exists {
+		if p.MaxBytes != 0 {
+			limits.MaxBytes = p.MaxBytes
+		}
+		if p.MaxPages != 0 {
+			limits.MaxPages = p.MaxPages
+		}
+		if p.MaxSeconds != 0 {
+			limits.MaxSeconds = p.MaxSeconds
+		}
+		if p.MaxDownloadBytesPerSecond != 0 {
+			limits.MaxDownloadBytesPerSecond = p.MaxDownloadBytesPerSecond
+		}
+	}
+	return limits
+}
+
+// Deadline gets the time by which a job that starts at start must finish
+// printing, or zero when there is no limit.
+func (l *JobLimiter) Deadline(printerName string, start time.Time) time.Time {
+	if max := l.Limits(printerName).MaxSeconds; max > 0 {
+		return start.Add(time.Duration(max) * time.Second)
+	}
+	return time.Time{}
+}
+
+// CheckBytes returns a JobTooLargeError when a job of size bytes is too
+// large for a printer.
+func (l *JobLimiter) CheckBytes(printerName string, size int64) error {
+	if max := l.Limits(printerName).MaxBytes; max > 0 && size > max {
+		return &JobTooLargeError{fmt.Sprintf(
+			"Job is %d bytes, but printer %s accepts at most %d", size, printerName, max)}
+	}
+	return nil
+}
+
+// CheckPages returns a JobTooLargeError when a job's PDF has too many
+// pages for a printer. Files whose pages can't be counted pass.
+func (l *JobLimiter) CheckPages(printerName, filename string) error {
+	max := l.Limits(printerName).MaxPages
+	if max == 0 {
+		return nil
+	}
+	pages, err := CountPDFPages(filename)
+	if err == nil && pages > max {
+		return &JobTooLargeError{fmt.Sprintf(
+			"Job has %d pages, but printer %s accepts at most %d", pages, printerName, max)}
+	}
+	return nil
+}
+
+// CountPDFPages quickly counts the pages of a PDF, by counting the page
+// objects in it. Returns zero when there are none to be seen, like when
+// the page objects are compressed into object streams, or the file is not
+// a PDF.
+func CountPDFPages(filename string) (uint, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	var pages uint
+	buf := make([]byte, 0, pdfPageCountOverlap+pdfPageCountChunkBytes)
+	for {
+		n, err := io.ReadFull(f, buf[len(buf):cap(buf)])
+		buf = buf[:len(buf)+n]
+		eof := err == io.EOF || err == io.ErrUnexpectedEOF
+		if err != nil && !eof {
+			return 0, err
+		}
+
+		// Matches that start in the overlap at the end are counted with
+		// the next chunk, which starts with the overlap.
+		end := len(buf)
+		if !eof {
+			end -= pdfPageCountOverlap
+		}
+		for _, m := range rPDFPage.FindAllIndex(buf, -1) {
+			if m[0] < end {
+				pages++
+			}
+		}
+
+		if eof {
+			return pages, nil
+		}
+		buf = append(buf[:0], buf[end:]...)
+	}
+}
@@ -0,0 +1,92 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package lib
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+func TestJobLimiterLimits(t *testing.T) {
+	l := NewJobLimiter(JobLimits{MaxBytes: 1000, MaxPages: 10}, map[string]JobLimits{
+		"slow":    {MaxPages: 2},
+		"metered": {MaxBytes: 100},
+	})
+
+	testCases := []struct {
+		printerName string
+		expected    JobLimits
+	}{
+		{"other", JobLimits{MaxBytes: 1000, MaxPages: 10}},
+		{"slow", JobLimits{MaxBytes: 1000, MaxPages: 2}},
+		{"metered", JobLimits{MaxBytes: 100, MaxPages: 10}},
+	}
+	for _, tc := range testCases {
+		if limits := l.Limits(tc.printerName); limits != tc.expected {
+			t.Logf("%s: expected %+v, got %+v", tc.printerName, tc.expected, limits)
+			t.Fail()
+		}
+	}
+
+	if err := l.CheckBytes("metered", 101); err == nil {
+		t.Log("expected 101 bytes to be too many for metered")
+		t.Fail()
+	} else if _, ok := err.(*JobTooLargeError); !ok {
+		t.Logf("expected a JobTooLargeError, got %T", err)
+		t.Fail()
+	}
+	if err := l.CheckBytes("other", 101); err != nil {
+		t.Logf("expected 101 bytes to be OK for other, got %s", err)
+		t.Fail()
+	}
+
+	var nilLimiter *JobLimiter
+	if err := nilLimiter.CheckBytes("other", 1<<40); err != nil {
+		t.Logf("expected a nil JobLimiter to limit nothing, got %s", err)
+		t.Fail()
+	}
+}
+
+func TestCountPDFPages(t *testing.T) {
+	page := []byte("3 0 obj << /Type /Page /Parent 2 0 R >> endobj\n")
+	var pdf bytes.Buffer
+	pdf.WriteString("%PDF-1.4\n1 0 obj << /Type /Catalog /Pages 2 0 R >> endobj\n")
+	pdf.WriteString("2 0 obj << /Type /Pages /Count 3000 >> endobj\n")
+	// Enough pages to span several chunks, so that some straddle the
+	// boundaries between them.
+	for i := 0; i < 3000; i++ {
+		pdf.Write(page)
+		pdf.Write(bytes.Repeat([]byte(" "), i%701))
+	}
+	pdf.WriteString("4 0 obj << /Type/Page >> endobj\n%%EOF")
+
+	filename := writeTempFile(t, pdf.Bytes())
+	defer os.Remove(filename)
+
+	pages, err := CountPDFPages(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pages != 3001 {
+		t.Logf("expected 3001 pages, got %d", pages)
+		t.Fail()
+	}
+
+	l := NewJobLimiter(JobLimits{MaxPages: 3000}, nil)
+	if err = l.CheckPages("any", filename); err == nil {
+		t.Log("expected 3001 pages to be too many")
+		t.Fail()
+	}
+	l = NewJobLimiter(JobLimits{MaxPages: 3001}, nil)
+	if err = l.CheckPages("any", filename); err != nil {
+		t.Logf("expected 3001 pages to be OK, got %s", err)
+		t.Fail()
+	}
+}
@@ -0,0 +1,186 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package lib
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/google/cloud-print-connector/cdd"
+)
+
+// The messages that tell users how far their jobs have got, while they are
+// IN_PROGRESS.
+const (
+	JobProgressConverting = "Preparing the document for the printer"
+	JobProgressSubmitted  = "Sent to the printer"
+)
+
+// JobProgressDownloading tells users how much of their document has been
+// downloaded.
+func JobProgressDownloading(percent int) string {
+	return fmt.Sprintf("Downloading: %d%%", percent)
+}
+
+// JobProgressPrinting tells users which page of their job is printing.
+// pages is the job's pages, or zero when they aren't known.
+func JobProgressPrinting(page, pages int32) string {
+	if pages > 0 && page <= pages {
+		return fmt.Sprintf("Printing page %d of %d", page, pages)
+	}
+	return fmt.Sprintf("Printing page %d", page)
+}
+
+// JobPages counts the pages that a job prints: the document's pages, times
+// the copies asked for. Zero when the document's pages can't be counted.
+func JobPages(filename string, ticket *cdd.CloudJobTicket) int32 {
+	pages, err := CountPDFPages(filename)
+	if err != nil || pages == 0 {
+		return 0
+	}
+	copies := int32(1)
+	if ticket != nil && ticket.Print.Copies != nil && ticket.Print.Copies.Copies > 1 {
+		copies = ticket.Print.Copies.Copies
+	}
+	return int32(pages) * copies
+}
+
+// JobETA is the estimated completion time of a job that is printing.
+type JobETA struct {
+	JobID          string    `json:"job_id"`
+	PrinterName    string    `json:"printer_name"`
+	Pages          int32     `json:"pages"`
+	PagesPrinted   int32     `json:"pages_printed"`
+	PagesPerMinute float64   `json:"pages_per_minute"`
+	ETA            time.Time `json:"eta"`
+}
+
+// EstimateJobETA estimates when a job of pages, of which printed have
+// printed, completes on a printer that prints pagesPerMinute. Returns false
+// when there is no estimate, because either is unknown.
+func EstimateJobETA(now time.Time, pages, printed int32, pagesPerMinute float64) (time.Time, bool) {
+	if pages <= 0 || pagesPerMinute <= 0 {
+		return time.Time{}, false
+	}
+	left := pages - printed
+	if left < 0 {
+		left = 0
+	}
+	return now.Add(time.Duration(float64(left) / pagesPerMinute * float64(time.Minute))), true
+}
+
+// JobProgressETA adds the time left until a job completes to a progress
+// message, in whole minutes.
+func JobProgressETA(message string, left time.Duration) string {
+	if left < time.Minute {
+		return message + "; less than a minute left"
+	}
+	minutes := int((left + time.Minute - 1) / time.Minute)
+	if minutes == 1 {
+		return message + "; about 1 minute left"
+	}
+	return fmt.Sprintf("%s; about %d minutes left", message, minutes)
+}
+
+type progressWriter struct {
+	w        io.Writer
+	total    int64
+	step     int
+	interval time.Duration
+	report   func(percent int)
+
+	written  int64
+	reported int
+	last     time.Time
+
+	// mutex guards reporting, pending and closed.
+	mutex     sync.Mutex
+	reporting bool
+	// pending is the percent to report when the report in flight returns;
+	// zero is none.
+	pending int
+	closed  bool
+	wg      sync.WaitGroup
+}
+
+// NewProgressWriter creates a writer that writes to w, and reports the
+// percent of total bytes written each time that it passes another step
+// percent, but not within interval of the last report, nor at 100%, which
+// the next stage reports.
+//
+// report is called in the background, one call at a time, so a slow report
+// doesn't slow the writer; the percents passed while a report is in flight
+// are dropped, except for the newest. Close stops reporting, and waits for
+// the report in flight, so that it can't land after the next stage's. It
+// doesn't close w.
+func NewProgressWriter(w io.Writer, total int64, step int, interval time.Duration, report func(percent int)) io.WriteCloser {
+	return &progressWriter{w: w, total: total, step: step, interval: interval, report: report, last: time.Now()}
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.written += int64(n)
+	if w.total <= 0 || w.step <= 0 {
+		return n, err
+	}
+
+	percent := int(w.written * 100 / w.total)
+	percent -= percent % w.step
+	if percent > w.reported && percent < 100 && time.Since(w.last) >= w.interval {
+		w.reported = percent
+		w.last = time.Now()
+		w.send(percent)
+	}
+	return n, err
+}
+
+// send reports percent in the background, or, while a report is in
+// flight, leaves it to be reported next, in place of any older percent.
+func (w *progressWriter) send(percent int) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.closed {
+		return
+	}
+	if w.reporting {
+		w.pending = percent
+		return
+	}
+	w.reporting = true
+	w.wg.Add(1)
+
+	go func() {
+		defer w.wg.Done()
+		for {
+			w.report(percent)
+
+			w.mutex.Lock()
+			percent, w.pending = w.pending, 0
+			if percent == 0 {
+				w.reporting = false
+				w.mutex.Unlock()
+				return
+			}
+			w.mutex.Unlock()
+		}
+	}()
+}
+
+func (w *progressWriter) Close() error {
+	w.mutex.Lock()
+	w.closed = true
+	w.pending = 0
+	w.mutex.Unlock()
+
+	w.wg.Wait()
+	return nil
+}
@@ -0,0 +1,133 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package lib
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/cloud-print-connector/cdd"
+)
+
+func TestProgressWriter(t *testing.T) {
+	var b bytes.Buffer
+	var reported []int
+	w := NewProgressWriter(&b, 1000, 10, 0, func(percent int) { reported = append(reported, percent) })
+
+	for _, n := range []int{50, 60, 5, 300, 485, 100} {
+		if _, err := w.Write(make([]byte, n)); err != nil {
+			t.Fatal(err)
+		}
+		// Let each report return before the next write.
+		w.(*progressWriter).wg.Wait()
+	}
+	w.Close()
+	if expected := []int{10, 40, 90}; !reflect.DeepEqual(reported, expected) {
+		t.Logf("expected reports %v, got %v", expected, reported)
+		t.Fail()
+	}
+	if b.Len() != 1000 {
+		t.Logf("expected 1000 bytes written through, got %d", b.Len())
+		t.Fail()
+	}
+
+	reported = nil
+	w = NewProgressWriter(&b, 1000, 10, time.Hour, func(percent int) { reported = append(reported, percent) })
+	w.Write(make([]byte, 500))
+	w.Close()
+	if len(reported) != 0 {
+		t.Logf("expected no reports within the interval, got %v", reported)
+		t.Fail()
+	}
+}
+
+func TestProgressWriterSlowReport(t *testing.T) {
+	started := make(chan int, 10)
+	release := make(chan struct{})
+	w := NewProgressWriter(ioutil.Discard, 1000, 10, 0, func(percent int) {
+		started <- percent
+		<-release
+	})
+
+	w.Write(make([]byte, 100))
+	if percent := <-started; percent != 10 {
+		t.Fatalf("expected a report of 10%%, got %d%%", percent)
+	}
+	// These writes don't wait for the report in flight; 40% is stale by the
+	// time it returns.
+	w.Write(make([]byte, 300))
+	w.Write(make([]byte, 500))
+	close(release)
+	if percent := <-started; percent != 90 {
+		t.Logf("expected the stale report of 40%% to be dropped, got %d%%", percent)
+		t.Fail()
+	}
+	w.Close()
+	if len(started) != 0 {
+		t.Logf("expected no more reports, got %d", len(started))
+		t.Fail()
+	}
+}
+
+func TestJobPages(t *testing.T) {
+	pdf := "%PDF-1.4\n1 0 obj << /Type /Page >> endobj\n2 0 obj << /Type /Page >> endobj\n%%EOF"
+	filename := writeTempFile(t, []byte(pdf))
+	defer os.Remove(filename)
+
+	if pages := JobPages(filename, &cdd.CloudJobTicket{}); pages != 2 {
+		t.Logf("expected 2 pages, got %d", pages)
+		t.Fail()
+	}
+	ticket := cdd.CloudJobTicket{Print: cdd.PrintTicketSection{Copies: &cdd.CopiesTicketItem{Copies: 3}}}
+	if pages := JobPages(filename, &ticket); pages != 6 {
+		t.Logf("expected 3 copies of 2 pages, got %d", pages)
+		t.Fail()
+	}
+
+	if message := JobProgressPrinting(3, 6); message != "Printing page 3 of 6" {
+		t.Logf("unexpected message %q", message)
+		t.Fail()
+	}
+	if message := JobProgressPrinting(3, 0); message != "Printing page 3" {
+		t.Logf("unexpected message %q", message)
+		t.Fail()
+	}
+}
+
+func TestEstimateJobETA(t *testing.T) {
+	now := time.Now()
+	if _, ok := EstimateJobETA(now, 10, 0, 0); ok {
+		t.Log("expected no estimate without a speed")
+		t.Fail()
+	}
+	if _, ok := EstimateJobETA(now, 0, 0, 20); ok {
+		t.Log("expected no estimate without pages")
+		t.Fail()
+	}
+	if eta, ok := EstimateJobETA(now, 50, 10, 20); !ok || !eta.Equal(now.Add(2*time.Minute)) {
+		t.Logf("expected 40 pages at 20 pages per minute to take 2 minutes, got %s", eta.Sub(now))
+		t.Fail()
+	}
+
+	for left, expected := range map[time.Duration]string{
+		30 * time.Second: "Printing page 3; less than a minute left",
+		time.Minute:      "Printing page 3; about 1 minute left",
+		90 * time.Second: "Printing page 3; about 2 minutes left",
+		10 * time.Minute: "Printing page 3; about 10 minutes left",
+	} {
+		if message := JobProgressETA("Printing page 3", left); message != expected {
+			t.Logf("expected %q, got %q", expected, message)
+			t.Fail()
+		}
+	}
+}
@@ -0,0 +1,145 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package lib
+
+import (
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/google/cloud-print-connector/cdd"
+)
+
+// RedactedValue replaces the values of secret options in audit records.
+const RedactedValue = "REDACTED"
+
+// rSecretOption matches the names of options whose values are secrets, like
+// Ricoh's LockedPrintPassword, job-password or ReleasePIN. PIN must stand
+// out as a word, so that names like Mapping don't match.
+var rSecretOption = regexp.MustCompile(`(?i:password|passcode|secret)|PIN|Pin($|[A-Z])|(^|[-_])(?i:pin)($|[-_])`)
+
+// JobTicketRecord is what the connector received for one job, and what it
+// asked the native print system to do with it. ColorPolicy notes why the
+// job printed in grayscale, when a color policy chose that.
+type JobTicketRecord struct {
+	Time        time.Time           `json:"time"`
+	GCPJobID    string              `json:"gcp_job_id"`
+	PrinterName string              `json:"printer_name"`
+	NativeJobID uint32              `json:"native_job_id,omitempty"`
+	Ticket      *cdd.CloudJobTicket `json:"ticket,omitempty"`
+	Options     map[string]string   `json:"options,omitempty"`
+	ColorPolicy string              `json:"color_policy,omitempty"`
+	Error       string              `json:"error,omitempty"`
+}
+
+// JobTicketAudit keeps the most recent JobTicketRecords in memory, so that
+// an administrator can find out how a job's ticket was translated.
+type JobTicketAudit struct {
+	mutex      sync.Mutex
+	maxRecords int
+	maxAge     time.Duration
+	records    []JobTicketRecord
+}
+
+// NewJobTicketAudit creates a JobTicketAudit that keeps at most maxRecords
+// records, each for at most maxAge. Zero maxRecords keeps nothing; zero
+// maxAge keeps records until they are pushed out by newer ones.
+func NewJobTicketAudit(maxRecords uint, maxAge time.Duration) *JobTicketAudit {
+	return &JobTicketAudit{
+		maxRecords: int(maxRecords),
+		maxAge:     maxAge,
+	}
+}
+
+// Add records a job, after redacting secret values.
+func (a *JobTicketAudit) Add(r JobTicketRecord) {
+	if a.maxRecords == 0 {
+		return
+	}
+	if r.Time.IsZero() {
+		r.Time = time.Now()
+	}
+	r.Ticket = redactTicket(r.Ticket)
+	r.Options = redactOptions(r.Options)
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.records = append(a.records, r)
+	if len(a.records) > a.maxRecords {
+		a.records = append([]JobTicketRecord(nil), a.records[len(a.records)-a.maxRecords:]...)
+	}
+	a.prune()
+}
+
+// Records gets the records of one GCP job, or of every job when gcpJobID is
+// empty, oldest first.
+func (a *JobTicketAudit) Records(gcpJobID string) []JobTicketRecord {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.prune()
+
+	records := make([]JobTicketRecord, 0, len(a.records))
+	for _, r := range a.records {
+		if gcpJobID == "" || r.GCPJobID == gcpJobID {
+			records = append(records, r)
+		}
+	}
+	return records
+}
+
+// prune drops records older than maxAge. Call with the mutex held.
+func (a *JobTicketAudit) prune() {
+	if a.maxAge == 0 {
+		return
+	}
+	cutoff := time.Now().Add(-a.maxAge)
+	i := 0
+	for i < len(a.records) && a.records[i].Time.Before(cutoff) {
+		i++
+	}
+	if i > 0 {
+		a.records = append([]JobTicketRecord(nil), a.records[i:]...)
+	}
+}
+
+// redactTicket copies a ticket, with the values of secret vendor ticket
+// items redacted.
+func redactTicket(ticket *cdd.CloudJobTicket) *cdd.CloudJobTicket {
+	if ticket == nil {
+		return nil
+	}
+	t := *ticket
+	if t.Print.VendorTicketItem != nil {
+		t.Print.VendorTicketItem = make([]cdd.VendorTicketItem, len(ticket.Print.VendorTicketItem))
+		for i, vti := range ticket.Print.VendorTicketItem {
+			if rSecretOption.MatchString(vti.ID) {
+				vti.Value = RedactedValue
+			}
+			t.Print.VendorTicketItem[i] = vti
+		}
+	}
+	return &t
+}
+
+// redactOptions copies options, with the values of secret options redacted.
+func redactOptions(options map[string]string) map[string]string {
+	if options == nil {
+		return nil
+	}
+	o := make(map[string]string, len(options))
+	for k, v := range options {
+		if rSecretOption.MatchString(k) {
+			v = RedactedValue
+		}
+		o[k] = v
+	}
+	return o
+}
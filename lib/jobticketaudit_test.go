@@ -0,0 +1,117 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package lib
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/cloud-print-connector/cdd"
+)
+
+func TestJobTicketAuditMaxRecords(t *testing.T) {
+	a := NewJobTicketAudit(3, 0)
+	for i := 0; i < 5; i++ {
+		a.Add(JobTicketRecord{GCPJobID: fmt.Sprintf("job-%d", i), PrinterName: "p"})
+	}
+
+	records := a.Records("")
+	if len(records) != 3 {
+		t.Fatalf("expected 3 records, got %d", len(records))
+	}
+	for i, r := range records {
+		if expected := fmt.Sprintf("job-%d", i+2); r.GCPJobID != expected {
+			t.Logf("expected record %d for %s, got %s", i, expected, r.GCPJobID)
+			t.Fail()
+		}
+		if r.Time.IsZero() {
+			t.Logf("record %d has no time", i)
+			t.Fail()
+		}
+	}
+
+	if records = a.Records("job-3"); len(records) != 1 || records[0].GCPJobID != "job-3" {
+		t.Logf("expected the record of job-3, got %+v", records)
+		t.Fail()
+	}
+	if records = a.Records("job-0"); len(records) != 0 {
+		t.Logf("expected job-0 to be dropped, got %+v", records)
+		t.Fail()
+	}
+}
+
+func TestJobTicketAuditDisabled(t *testing.T) {
+	a := NewJobTicketAudit(0, time.Hour)
+	a.Add(JobTicketRecord{GCPJobID: "job"})
+	if records := a.Records(""); len(records) != 0 {
+		t.Logf("expected no records, got %+v", records)
+		t.Fail()
+	}
+}
+
+func TestJobTicketAuditMaxAge(t *testing.T) {
+	a := NewJobTicketAudit(10, time.Hour)
+	a.Add(JobTicketRecord{GCPJobID: "old", Time: time.Now().Add(-2 * time.Hour)})
+	a.Add(JobTicketRecord{GCPJobID: "new"})
+
+	records := a.Records("")
+	if len(records) != 1 || records[0].GCPJobID != "new" {
+		t.Logf("expected only the new record, got %+v", records)
+		t.Fail()
+	}
+}
+
+func TestJobTicketAuditRedaction(t *testing.T) {
+	ticket := &cdd.CloudJobTicket{
+		Print: cdd.PrintTicketSection{
+			VendorTicketItem: []cdd.VendorTicketItem{
+				{ID: "LockedPrintPassword", Value: "1234"},
+				{ID: "job-password", Value: "1234"},
+				{ID: "ReleasePIN", Value: "1234"},
+				{ID: "Mapping", Value: "On"},
+			},
+		},
+	}
+	options := map[string]string{
+		"LockedPrintPassword": "1234",
+		"user_pin":            "1234",
+		"Mapping":             "On",
+	}
+
+	a := NewJobTicketAudit(10, 0)
+	a.Add(JobTicketRecord{GCPJobID: "job", Ticket: ticket, Options: options})
+	r := a.Records("job")[0]
+
+	for _, vti := range r.Ticket.Print.VendorTicketItem {
+		expected := RedactedValue
+		if vti.ID == "Mapping" {
+			expected = "On"
+		}
+		if vti.Value != expected {
+			t.Logf("expected ticket item %s to be %s, got %s", vti.ID, expected, vti.Value)
+			t.Fail()
+		}
+	}
+	for k, v := range r.Options {
+		expected := RedactedValue
+		if k == "Mapping" {
+			expected = "On"
+		}
+		if v != expected {
+			t.Logf("expected option %s to be %s, got %s", k, expected, v)
+			t.Fail()
+		}
+	}
+
+	if ticket.Print.VendorTicketItem[0].Value != "1234" || options["user_pin"] != "1234" {
+		t.Log("redaction changed the original ticket or options")
+		t.Fail()
+	}
+}
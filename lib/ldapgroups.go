@@ -0,0 +1,94 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package lib
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+const ldapsearchCommand = "ldapsearch"
+
+// LDAPGroups gets the members of LDAP groups, by their distinguished
+// names, with the OpenLDAP ldapsearch command.
+type LDAPGroups struct {
+	url          string
+	bindDN       string
+	passwordFile string
+}
+
+// NewLDAPGroups searches the LDAP server at url, bound as bindDN with the
+// password in passwordFile, or anonymously when bindDN is empty. Returns
+// nil when url is empty.
+func NewLDAPGroups(url, bindDN, passwordFile string) *LDAPGroups {
+	if url == "" {
+		return nil
+	}
+	return &LDAPGroups{url, bindDN, passwordFile}
+}
+
+// GroupMembers gets the members of the group whose distinguished name is
+// groupDN, from its member, uniqueMember and memberUid attributes. Members
+// named by distinguished names are named by the value of their first
+// relative distinguished name, like alice of uid=alice,ou=people. Nested
+// groups aren't followed.
+func (l *LDAPGroups) GroupMembers(ctx context.Context, groupDN string) ([]string, error) {
+	args := []string{"-LLL", "-x", "-o", "ldif-wrap=no", "-H", l.url}
+	if l.bindDN != "" {
+		args = append(args, "-D", l.bindDN, "-y", l.passwordFile)
+	}
+	args = append(args, "-b", groupDN, "-s", "base", "(objectClass=*)", "member", "uniqueMember", "memberUid")
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, ldapsearchCommand, args...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s failed: %s: %s", ldapsearchCommand, err, strings.TrimSpace(stderr.String()))
+	}
+	return parseLDAPMembers(out)
+}
+
+// parseLDAPMembers gets the members of a group from its LDIF.
+func parseLDAPMembers(ldif []byte) ([]string, error) {
+	var members []string
+	scanner := bufio.NewScanner(bytes.NewReader(ldif))
+	for scanner.Scan() {
+		line := scanner.Text()
+		i := strings.IndexByte(line, ':')
+		if i < 0 {
+			continue
+		}
+		attribute, value := strings.ToLower(line[:i]), line[i+1:]
+		if strings.HasPrefix(value, ":") {
+			b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value[1:]))
+			if err != nil {
+				return nil, fmt.Errorf("Failed to decode LDAP attribute %s: %s", attribute, err)
+			}
+			value = string(b)
+		}
+		value = strings.TrimSpace(value)
+
+		switch attribute {
+		case "memberuid":
+			members = append(members, value)
+		case "member", "uniquemember":
+			rdn := strings.SplitN(value, ",", 2)[0]
+			if j := strings.IndexByte(rdn, '='); j >= 0 {
+				members = append(members, strings.TrimSpace(rdn[j+1:]))
+			}
+		}
+	}
+	return members, scanner.Err()
+}
@@ -0,0 +1,30 @@
+// Copyright 2015 Google Inc. All rights reserved.
+
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+// +build darwin freebsd
+
+package lib
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// loadAverage gets the 1-minute load average.
+func loadAverage() (float64, error) {
+	// Like { 1.52 1.38 1.31 }.
+	out, err := exec.Command("sysctl", "-n", "vm.loadavg").Output()
+	if err != nil {
+		return 0, err
+	}
+	fields := strings.Fields(strings.Trim(strings.TrimSpace(string(out)), "{}"))
+	if len(fields) < 1 {
+		return 0, fmt.Errorf("vm.loadavg is empty")
+	}
+	return strconv.ParseFloat(fields[0], 64)
+}
@@ -0,0 +1,16 @@
+// Copyright 2015 Google Inc. All rights reserved.
+
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+// +build windows
+
+package lib
+
+import "errors"
+
+// loadAverage fails, since Windows has no load average.
+func loadAverage() (float64, error) {
+	return 0, errors.New("Windows has no load average")
+}
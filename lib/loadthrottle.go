@@ -0,0 +1,80 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package lib
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// loadThrottleInterval is how often the load average is read, and, while the
+// system is busy, how often one piece of background work may start.
+const loadThrottleInterval = 500 * time.Millisecond
+
+// LoadThrottle slows background work, like translating PPDs, while the
+// system is busy, so that a sync doesn't make a small print server unusable.
+// The nil value never throttles.
+type LoadThrottle struct {
+	// threshold is the 1-minute load average per CPU above which the system
+	// is busy.
+	threshold   float64
+	interval    time.Duration
+	loadAverage func() (float64, error)
+
+	mutex   sync.Mutex
+	checked time.Time
+	busy    bool
+
+	// gate admits one piece of work per interval while busy.
+	gate sync.Mutex
+}
+
+// NewLoadThrottle creates a LoadThrottle that throttles while the 1-minute
+// load average per CPU is above threshold. Returns nil when threshold is
+// zero.
+func NewLoadThrottle(threshold float64) (*LoadThrottle, error) {
+	if threshold <= 0 {
+		return nil, nil
+	}
+	if _, err := loadAverage(); err != nil {
+		return nil, fmt.Errorf("Failed to read the load average to throttle background work: %s", err)
+	}
+	return &LoadThrottle{
+		threshold:   threshold,
+		interval:    loadThrottleInterval,
+		loadAverage: loadAverage,
+	}, nil
+}
+
+// isBusy reads the load average, at most once per interval.
+func (t *LoadThrottle) isBusy() bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if time.Since(t.checked) >= t.interval {
+		load, err := t.loadAverage()
+		t.busy = err == nil && load/float64(runtime.NumCPU()) > t.threshold
+		t.checked = time.Now()
+	}
+	return t.busy
+}
+
+// Wait returns immediately when the system isn't busy. Otherwise it returns
+// after the other waiters, one per interval. Safe to call on nil.
+func (t *LoadThrottle) Wait() {
+	if t == nil || !t.isBusy() {
+		return
+	}
+
+	t.gate.Lock()
+	defer t.gate.Unlock()
+	time.Sleep(t.interval)
+}
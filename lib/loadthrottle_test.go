@@ -0,0 +1,52 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package lib
+
+import (
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestLoadThrottle(t *testing.T) {
+	var nilThrottle *LoadThrottle
+	nilThrottle.Wait()
+
+	if throttle, err := NewLoadThrottle(0); throttle != nil || err != nil {
+		t.Logf("expected no throttle for threshold 0, got %v, %v", throttle, err)
+		t.Fail()
+	}
+
+	load := 0.5 * float64(runtime.NumCPU())
+	throttle := &LoadThrottle{
+		threshold:   1,
+		interval:    20 * time.Millisecond,
+		loadAverage: func() (float64, error) { return load, nil },
+	}
+
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		throttle.Wait()
+	}
+	if d := time.Since(start); d >= throttle.interval {
+		t.Logf("expected no delay while idle, waited %s", d)
+		t.Fail()
+	}
+
+	load = 2 * float64(runtime.NumCPU())
+	time.Sleep(throttle.interval)
+	start = time.Now()
+	for i := 0; i < 3; i++ {
+		throttle.Wait()
+	}
+	if d := time.Since(start); d < 3*throttle.interval {
+		t.Logf("expected one piece of work per interval while busy, waited %s", d)
+		t.Fail()
+	}
+}
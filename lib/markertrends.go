@@ -0,0 +1,151 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package lib
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/cloud-print-connector/cdd"
+)
+
+const (
+	// markerTrendWindow is how far back marker levels are remembered.
+	markerTrendWindow = 30 * 24 * time.Hour
+	// markerLowDays is when an estimate becomes a warning.
+	markerLowDays = 7
+)
+
+type markerSample struct {
+	at    time.Time
+	level int32
+}
+
+type markerKey struct {
+	printerName string
+	vendorID    string
+}
+
+// SupplyEstimate is the level of one printer marker, and when it will be
+// empty at its recent rate of use.
+type SupplyEstimate struct {
+	Printer       string   `json:"printer"`
+	Marker        string   `json:"marker"`
+	LevelPercent  int32    `json:"level_percent"`
+	DaysRemaining *float64 `json:"days_remaining,omitempty"`
+}
+
+// MarkerTrends remembers the levels of printer markers (toner, ink, etc),
+// to estimate how many days each one has left.
+type MarkerTrends struct {
+	history map[markerKey][]markerSample
+	mutex   sync.Mutex
+}
+
+// NewMarkerTrends creates a MarkerTrends without history.
+func NewMarkerTrends() *MarkerTrends {
+	return &MarkerTrends{history: make(map[markerKey][]markerSample)}
+}
+
+// Observe remembers the marker levels of one printer. A level that rises,
+// like when a cartridge is replaced, starts the marker's history over.
+func (m *MarkerTrends) Observe(printerName string, state *cdd.MarkerState, now time.Time) {
+	if state == nil {
+		return
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for _, item := range state.Item {
+		if item.LevelPercent == nil {
+			continue
+		}
+		key := markerKey{printerName, item.VendorID}
+		sample := markerSample{now, *item.LevelPercent}
+
+		samples := m.history[key]
+		if len(samples) > 0 {
+			last := samples[len(samples)-1]
+			if sample.level > last.level {
+				samples = nil
+			} else if sample.level == last.level {
+				// The first and last samples at each level are enough.
+				if len(samples) > 1 && samples[len(samples)-2].level == last.level {
+					samples = samples[:len(samples)-1]
+				}
+			}
+		}
+		for len(samples) > 0 && now.Sub(samples[0].at) > markerTrendWindow {
+			samples = samples[1:]
+		}
+		m.history[key] = append(samples, sample)
+	}
+}
+
+// Estimates gets the supply estimates of one printer, or of every printer
+// when printerName is empty, sorted by printer and marker.
+func (m *MarkerTrends) Estimates(printerName string) []SupplyEstimate {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	estimates := make([]SupplyEstimate, 0)
+	for key, samples := range m.history {
+		if printerName != "" && key.printerName != printerName {
+			continue
+		}
+		first, last := samples[0], samples[len(samples)-1]
+		e := SupplyEstimate{
+			Printer:      key.printerName,
+			Marker:       key.vendorID,
+			LevelPercent: last.level,
+		}
+		if used, days := first.level-last.level, last.at.Sub(first.at).Hours()/24; used > 0 && days > 0 {
+			remaining := float64(last.level) * days / float64(used)
+			e.DaysRemaining = &remaining
+		}
+		estimates = append(estimates, e)
+	}
+
+	sort.Sort(supplyEstimatesByName(estimates))
+	return estimates
+}
+
+// VendorStateItems describes the supply estimates of one printer as vendor
+// state, to be seen beside the printer's other states.
+func (m *MarkerTrends) VendorStateItems(printerName string) []cdd.VendorStateItem {
+	var items []cdd.VendorStateItem
+	for _, e := range m.Estimates(printerName) {
+		if e.DaysRemaining == nil {
+			continue
+		}
+		state := cdd.VendorStateInfo
+		if *e.DaysRemaining < markerLowDays {
+			state = cdd.VendorStateWarning
+		}
+		items = append(items, cdd.VendorStateItem{
+			State:       state,
+			Description: fmt.Sprintf("%s: about %.0f days remaining", e.Marker, *e.DaysRemaining),
+		})
+	}
+	return items
+}
+
+type supplyEstimatesByName []SupplyEstimate
+
+func (s supplyEstimatesByName) Len() int      { return len(s) }
+func (s supplyEstimatesByName) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s supplyEstimatesByName) Less(i, j int) bool {
+	if s[i].Printer != s[j].Printer {
+		return s[i].Printer < s[j].Printer
+	}
+	return s[i].Marker < s[j].Marker
+}
@@ -0,0 +1,67 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package lib
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/cloud-print-connector/cdd"
+)
+
+func markerState(vendorID string, level int32) *cdd.MarkerState {
+	return &cdd.MarkerState{Item: []cdd.MarkerStateItem{
+		{VendorID: vendorID, State: cdd.MarkerStateOK, LevelPercent: &level},
+	}}
+}
+
+func TestMarkerTrends(t *testing.T) {
+	m := NewMarkerTrends()
+	start := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+
+	m.Observe("p", markerState("black", 80), start)
+	if e := m.Estimates("p"); len(e) != 1 || e[0].DaysRemaining != nil {
+		t.Logf("expected no estimate from one level, got %+v", e)
+		t.Fail()
+	}
+
+	// 2% per day, with a level that holds for a while.
+	m.Observe("p", markerState("black", 78), start.Add(day))
+	m.Observe("p", markerState("black", 78), start.Add(day+time.Hour))
+	m.Observe("p", markerState("black", 60), start.Add(10*day))
+	e := m.Estimates("p")
+	if len(e) != 1 || e[0].LevelPercent != 60 || e[0].DaysRemaining == nil || *e[0].DaysRemaining != 30 {
+		t.Logf("expected 30 days remaining at 60%%, got %+v", e)
+		t.Fail()
+	}
+	if items := m.VendorStateItems("p"); len(items) != 1 || items[0].State != cdd.VendorStateInfo {
+		t.Logf("expected one info vendor state item, got %+v", items)
+		t.Fail()
+	}
+
+	m.Observe("p", markerState("black", 2), start.Add(39*day))
+	if items := m.VendorStateItems("p"); len(items) != 1 || items[0].State != cdd.VendorStateWarning {
+		t.Logf("expected one warning vendor state item, got %+v", items)
+		t.Fail()
+	}
+
+	// A new cartridge starts over.
+	m.Observe("p", markerState("black", 100), start.Add(40*day))
+	if e := m.Estimates("p"); len(e) != 1 || e[0].DaysRemaining != nil {
+		t.Logf("expected no estimate after a refill, got %+v", e)
+		t.Fail()
+	}
+
+	m.Observe("q", markerState("cyan", 50), start)
+	if e := m.Estimates(""); len(e) != 2 || e[0].Printer != "p" || e[1].Printer != "q" {
+		t.Logf("expected estimates of p and q, got %+v", e)
+		t.Fail()
+	}
+}
@@ -0,0 +1,292 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package lib
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Address families that listeners and dialers may be limited to, or may
+// prefer. The empty family is whatever the system does: dual-stack
+// listeners, and addresses dialed in the resolver's order.
+const (
+	AddressFamilyAny        = ""
+	AddressFamilyIPv4       = "ipv4"
+	AddressFamilyIPv6       = "ipv6"
+	AddressFamilyPreferIPv4 = "prefer-ipv4"
+	AddressFamilyPreferIPv6 = "prefer-ipv6"
+)
+
+var addressFamily struct {
+	mutex  sync.RWMutex
+	family string
+}
+
+// SetAddressFamily limits, or orders, the addresses that DialContext dials
+// and that listeners listen to.
+func SetAddressFamily(family string) error {
+	switch family {
+	case AddressFamilyAny, AddressFamilyIPv4, AddressFamilyIPv6, AddressFamilyPreferIPv4, AddressFamilyPreferIPv6:
+	default:
+		return fmt.Errorf("Address family %q is not ipv4, ipv6, prefer-ipv4 or prefer-ipv6", family)
+	}
+
+	addressFamily.mutex.Lock()
+	defer addressFamily.mutex.Unlock()
+	addressFamily.family = family
+	return nil
+}
+
+// GetAddressFamily gets the address family set by SetAddressFamily.
+func GetAddressFamily() string {
+	addressFamily.mutex.RLock()
+	defer addressFamily.mutex.RUnlock()
+	return addressFamily.family
+}
+
+// IPv4Allowed and IPv6Allowed tell whether the address family allows
+// addresses of IPv4 and IPv6.
+func IPv4Allowed() bool { return GetAddressFamily() != AddressFamilyIPv6 }
+func IPv6Allowed() bool { return GetAddressFamily() != AddressFamilyIPv4 }
+
+// ListenNetwork gets the network, like tcp6, to listen to instead of
+// network, like tcp, so that listeners are limited to the address family.
+func ListenNetwork(network string) string {
+	switch network {
+	case "tcp", "udp":
+		switch GetAddressFamily() {
+		case AddressFamilyIPv4:
+			return network + "4"
+		case AddressFamilyIPv6:
+			return network + "6"
+		}
+	}
+	return network
+}
+
+// Listen is net.Listen, limited to the address family.
+func Listen(network, address string) (net.Listener, error) {
+	return net.Listen(ListenNetwork(network), address)
+}
+
+// OrderAddresses filters and orders ips by the address family. Addresses
+// of the same family keep their order.
+func OrderAddresses(ips []net.IP) []net.IP {
+	family := GetAddressFamily()
+	ordered := make([]net.IP, 0, len(ips))
+	for _, ip := range ips {
+		isIPv4 := ip.To4() != nil
+		if (isIPv4 && family == AddressFamilyIPv6) || (!isIPv4 && family == AddressFamilyIPv4) {
+			continue
+		}
+		ordered = append(ordered, ip)
+	}
+	if family == AddressFamilyPreferIPv4 || family == AddressFamilyPreferIPv6 {
+		preferIPv4 := family == AddressFamilyPreferIPv4
+		sort.SliceStable(ordered, func(i, j int) bool {
+			return (ordered[i].To4() != nil) == preferIPv4 && (ordered[j].To4() != nil) != preferIPv4
+		})
+	}
+	return ordered
+}
+
+// DialContext dials address with dialer, like dialer.DialContext, but only
+// to addresses of the address family, in its order of preference. Each
+// address is tried in turn, until one answers.
+func DialContext(ctx context.Context, dialer *net.Dialer, network, address string) (net.Conn, error) {
+	if GetAddressFamily() == AddressFamilyAny {
+		return dialer.DialContext(ctx, network, address)
+	}
+	switch network {
+	case "tcp", "udp":
+	default:
+		return dialer.DialContext(ctx, network, address)
+	}
+
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	// An address, maybe with a zone, like fe80::1%eth0.
+	if ip := net.ParseIP(strings.SplitN(host, "%", 2)[0]); ip != nil {
+		if len(OrderAddresses([]net.IP{ip})) == 0 {
+			return nil, fmt.Errorf("%s is not an address of family %s", host, GetAddressFamily())
+		}
+		return dialer.DialContext(ctx, network, address)
+	}
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	ips := make([]net.IP, len(addrs))
+	for i := range addrs {
+		ips[i] = addrs[i].IP
+	}
+	ips = OrderAddresses(ips)
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("%s has no address of family %s", host, GetAddressFamily())
+	}
+
+	var firstErr error
+	for _, ip := range ips {
+		conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, firstErr
+}
+
+// DialTimeout is net.DialTimeout, but dials like DialContext.
+func DialTimeout(network, address string, timeout time.Duration) (net.Conn, error) {
+	return DialContext(context.Background(), &net.Dialer{Timeout: timeout}, network, address)
+}
+
+// HappyEyeballsDelay is how long DialHappyEyeballs waits for an address to
+// connect before it tries the next address too, as RFC 8305 recommends.
+const HappyEyeballsDelay = 250 * time.Millisecond
+
+// DialHappyEyeballs dials address like DialContext, but races its addresses,
+// like RFC 8305 says: the addresses alternate between IPv6 and IPv4,
+// starting with the preferred family, and the next address is tried every
+// HappyEyeballsDelay, or as soon as the last one fails, without waiting
+// for the earlier ones to time out. The first connection wins; the others
+// are canceled, or closed when they connect anyway. A network where one
+// family is broken then costs a fraction of a second, not a timeout.
+func DialHappyEyeballs(ctx context.Context, dialer *net.Dialer, network, address string) (net.Conn, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return DialContext(ctx, dialer, network, address)
+	}
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	if net.ParseIP(strings.SplitN(host, "%", 2)[0]) != nil {
+		return DialContext(ctx, dialer, network, address)
+	}
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	ips := make([]net.IP, len(addrs))
+	for i := range addrs {
+		ips[i] = addrs[i].IP
+	}
+	ips = interleaveAddresses(OrderAddresses(ips))
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("%s has no address of family %s", host, GetAddressFamily())
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type attempt struct {
+		conn net.Conn
+		err  error
+	}
+	attempts := make(chan attempt, len(ips))
+	var started, pending int
+	start := func() {
+		address := net.JoinHostPort(ips[started].String(), port)
+		started++
+		pending++
+		Go(SubsystemGCP, func() {
+			conn, err := dialer.DialContext(ctx, network, address)
+			attempts <- attempt{conn, err}
+		})
+	}
+
+	start()
+	timer := time.NewTimer(HappyEyeballsDelay)
+	defer timer.Stop()
+	var firstErr error
+	for {
+		select {
+		case a := <-attempts:
+			pending--
+			if a.err == nil {
+				// Close the losers that connect before they are canceled.
+				losers := pending
+				Go(SubsystemGCP, func() {
+					for ; losers > 0; losers-- {
+						if a := <-attempts; a.conn != nil {
+							a.conn.Close()
+						}
+					}
+				})
+				return a.conn, nil
+			}
+			if firstErr == nil {
+				firstErr = a.err
+			}
+			if started < len(ips) && ctx.Err() == nil {
+				start()
+				if !timer.Stop() {
+					select {
+					case <-timer.C:
+					default:
+					}
+				}
+				timer.Reset(HappyEyeballsDelay)
+			} else if pending == 0 {
+				return nil, firstErr
+			}
+
+		case <-timer.C:
+			if started < len(ips) && ctx.Err() == nil {
+				start()
+				timer.Reset(HappyEyeballsDelay)
+			}
+		}
+	}
+}
+
+// interleaveAddresses alternates the addresses of IPv6 and IPv4, starting
+// with the family of the first address. Addresses of the same family keep
+// their order.
+func interleaveAddresses(ips []net.IP) []net.IP {
+	if len(ips) == 0 {
+		return ips
+	}
+	var first, second []net.IP
+	firstIsIPv4 := ips[0].To4() != nil
+	for _, ip := range ips {
+		if (ip.To4() != nil) == firstIsIPv4 {
+			first = append(first, ip)
+		} else {
+			second = append(second, ip)
+		}
+	}
+	interleaved := make([]net.IP, 0, len(ips))
+	for i := 0; i < len(first) || i < len(second); i++ {
+		if i < len(first) {
+			interleaved = append(interleaved, first[i])
+		}
+		if i < len(second) {
+			interleaved = append(interleaved, second[i])
+		}
+	}
+	return interleaved
+}
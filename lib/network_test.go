@@ -0,0 +1,114 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package lib
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestOrderAddresses(t *testing.T) {
+	defer SetAddressFamily(AddressFamilyAny)
+
+	v6a, v4a, v6b, v4b := net.ParseIP("2001:db8::1"), net.ParseIP("192.0.2.1"), net.ParseIP("2001:db8::2"), net.ParseIP("192.0.2.2")
+	ips := []net.IP{v6a, v4a, v6b, v4b}
+	testCases := []struct {
+		family  string
+		ordered []net.IP
+		network string
+	}{
+		{AddressFamilyAny, []net.IP{v6a, v4a, v6b, v4b}, "tcp"},
+		{AddressFamilyIPv4, []net.IP{v4a, v4b}, "tcp4"},
+		{AddressFamilyIPv6, []net.IP{v6a, v6b}, "tcp6"},
+		{AddressFamilyPreferIPv4, []net.IP{v4a, v4b, v6a, v6b}, "tcp"},
+		{AddressFamilyPreferIPv6, []net.IP{v6a, v6b, v4a, v4b}, "tcp"},
+	}
+	for _, tc := range testCases {
+		if err := SetAddressFamily(tc.family); err != nil {
+			t.Fatal(err)
+		}
+		if ordered := OrderAddresses(ips); !reflect.DeepEqual(ordered, tc.ordered) {
+			t.Logf("expected %v for family %q, got %v", tc.ordered, tc.family, ordered)
+			t.Fail()
+		}
+		if network := ListenNetwork("tcp"); network != tc.network {
+			t.Logf("expected to listen to %s for family %q, got %s", tc.network, tc.family, network)
+			t.Fail()
+		}
+	}
+
+	if err := SetAddressFamily("ipv5"); err == nil {
+		t.Log("expected family ipv5 to be rejected")
+		t.Fail()
+	}
+}
+
+func TestDialContextAddressFamily(t *testing.T) {
+	defer SetAddressFamily(AddressFamilyAny)
+
+	listener, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	address := listener.Addr().String()
+	dialer := net.Dialer{Timeout: time.Second}
+
+	SetAddressFamily(AddressFamilyIPv4)
+	conn, err := DialContext(context.Background(), &dialer, "tcp", address)
+	if err != nil {
+		t.Fatalf("expected to dial %s over IPv4: %s", address, err)
+	}
+	conn.Close()
+
+	SetAddressFamily(AddressFamilyIPv6)
+	if conn, err = DialContext(context.Background(), &dialer, "tcp", address); err == nil {
+		conn.Close()
+		t.Logf("expected not to dial %s over IPv6 only", address)
+		t.Fail()
+	}
+}
+
+func TestInterleaveAddresses(t *testing.T) {
+	v6a, v6b, v6c, v4a := net.ParseIP("2001:db8::1"), net.ParseIP("2001:db8::2"), net.ParseIP("2001:db8::3"), net.ParseIP("192.0.2.1")
+	if ips := interleaveAddresses([]net.IP{v6a, v6b, v6c, v4a}); !reflect.DeepEqual(ips, []net.IP{v6a, v4a, v6b, v6c}) {
+		t.Logf("expected IPv4 second, got %v", ips)
+		t.Fail()
+	}
+	if ips := interleaveAddresses([]net.IP{v4a, v6a, v6b}); !reflect.DeepEqual(ips, []net.IP{v4a, v6a, v6b}) {
+		t.Logf("expected IPv4 first, got %v", ips)
+		t.Fail()
+	}
+}
+
+func TestDialHappyEyeballs(t *testing.T) {
+	listener, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	dialer := net.Dialer{Timeout: time.Second}
+
+	// localhost may be ::1 too, which refuses; 127.0.0.1 still wins.
+	conn, err := DialHappyEyeballs(context.Background(), &dialer, "tcp", net.JoinHostPort("localhost", port))
+	if err != nil {
+		t.Fatalf("expected to dial localhost:%s: %s", port, err)
+	}
+	conn.Close()
+
+	listener.Close()
+	if conn, err = DialHappyEyeballs(context.Background(), &dialer, "tcp", net.JoinHostPort("localhost", port)); err == nil {
+		conn.Close()
+		t.Log("expected a closed port to fail")
+		t.Fail()
+	}
+}
@@ -0,0 +1,183 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package lib
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+
+	"github.com/google/cloud-print-connector/cdd"
+)
+
+const (
+	// PDFPasswordVendorID is the ID of the vendor ticket item that carries
+	// the password of an encrypted PDF.
+	PDFPasswordVendorID = "pdf-password"
+
+	// qpdfCommand decrypts PDFs.
+	qpdfCommand = "qpdf"
+
+	// The encryption dictionary is named by the trailer, or by the
+	// cross-reference stream, which are both near one end of the file.
+	pdfEncryptionSearchBytes = 1024 * 1024
+)
+
+var (
+	// ErrPDFEncrypted means a PDF is encrypted, and the job has no password.
+	ErrPDFEncrypted = errors.New("PDF is encrypted and the job has no password")
+	// ErrPDFPassword means a PDF could not be decrypted with the job's password.
+	ErrPDFPassword = errors.New("PDF password is incorrect")
+
+	rPDFEncrypt = regexp.MustCompile(`/Encrypt\s*(<<|[0-9]+\s+[0-9]+\s+R)`)
+)
+
+// CanDecryptPDF reports whether the command that decrypts PDFs is installed.
+func CanDecryptPDF() bool {
+	_, err := exec.LookPath(qpdfCommand)
+	return err == nil
+}
+
+// PDFPassword gets the password of a job's PDF from its ticket.
+func PDFPassword(ticket *cdd.CloudJobTicket) (string, bool) {
+	if ticket == nil {
+		return "", false
+	}
+	for _, vti := range ticket.Print.VendorTicketItem {
+		if vti.ID == PDFPasswordVendorID {
+			return vti.Value, true
+		}
+	}
+	return "", false
+}
+
+// IsPDFEncrypted reports whether a PDF is encrypted. Files that aren't PDFs
+// aren't encrypted.
+func IsPDFEncrypted(filename string) (bool, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+
+	head := make([]byte, pdfEncryptionSearchBytes)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return false, err
+	}
+	head = head[:n]
+	if !bytes.HasPrefix(head, []byte("%PDF-")) {
+		return false, nil
+	}
+	if rPDFEncrypt.Match(head) {
+		return true, nil
+	}
+
+	if fi.Size() <= int64(n) {
+		return false, nil
+	}
+	offset := fi.Size() - pdfEncryptionSearchBytes
+	if offset < int64(n) {
+		offset = int64(n)
+	}
+	tail := make([]byte, fi.Size()-offset)
+	if _, err = f.ReadAt(tail, offset); err != nil && err != io.EOF {
+		return false, err
+	}
+	return rPDFEncrypt.Match(tail), nil
+}
+
+// DecryptPDF replaces an encrypted PDF with a decrypted copy. Returns
+// ErrPDFPassword when the password is wrong.
+func DecryptPDF(filename, password string) error {
+	// Pass the password in a file, rather than on the command line where
+	// any user can read it.
+	passwordFile, err := ioutil.TempFile("", "cloud-print-connector-password-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(passwordFile.Name())
+	_, err = passwordFile.WriteString(password + "\n")
+	passwordFile.Close()
+	if err != nil {
+		return err
+	}
+
+	out, err := ioutil.TempFile("", "cloud-print-connector-decrypted-")
+	if err != nil {
+		return err
+	}
+	out.Close()
+	defer os.Remove(out.Name())
+
+	var stderr bytes.Buffer
+	cmd := exec.Command(qpdfCommand, "--password-file="+passwordFile.Name(), "--decrypt", filename, out.Name())
+	cmd.Stderr = &stderr
+	if err = cmd.Run(); err != nil {
+		if strings.Contains(stderr.String(), "invalid password") {
+			return ErrPDFPassword
+		}
+		return fmt.Errorf("%s failed: %s: %s", qpdfCommand, err, strings.TrimSpace(stderr.String()))
+	}
+
+	return os.Rename(out.Name(), filename)
+}
+
+// PDFErrorState describes a job that PreparePDF failed on, so that the user
+// can tell a missing or wrong password from other failures.
+func PDFErrorState(err error) *cdd.JobState {
+	state := cdd.JobState{Type: cdd.JobStateAborted}
+	switch err {
+	case ErrPDFEncrypted:
+		state.ServiceActionCause = &cdd.ServiceActionCause{ErrorCode: cdd.ServiceActionCauseConversionType}
+	case ErrPDFPassword:
+		state.DeviceActionCause = &cdd.DeviceActionCause{ErrorCode: cdd.DeviceActionCauseInvalidTicket}
+	default:
+		state.ServiceActionCause = &cdd.ServiceActionCause{ErrorCode: cdd.ServiceActionCauseConversionError}
+	}
+	return &state
+}
+
+// PDFErrorKind tells the user of a job that PreparePDF failed on whether
+// the document needs a password, or couldn't be read.
+func PDFErrorKind(err error) JobErrorKind {
+	switch err {
+	case ErrPDFEncrypted, ErrPDFPassword:
+		return JobErrorPassword
+	default:
+		return JobErrorDocumentUnsupported
+	}
+}
+
+// PreparePDF checks whether a job's file is an encrypted PDF, and if so
+// decrypts it in place with the password from the job's ticket. Returns
+// ErrPDFEncrypted when there is no password.
+func PreparePDF(filename string, ticket *cdd.CloudJobTicket) error {
+	encrypted, err := IsPDFEncrypted(filename)
+	if err != nil || !encrypted {
+		return err
+	}
+
+	password, exists := PDFPassword(ticket)
+	if !exists {
+		return ErrPDFEncrypted
+	}
+	return DecryptPDF(filename, password)
+}
@@ -0,0 +1,107 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package lib
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/google/cloud-print-connector/cdd"
+)
+
+func writeTempFile(t *testing.T, content []byte) string {
+	f, err := ioutil.TempFile("", "pdfencryption-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err = f.Write(content); err != nil {
+		t.Fatal(err)
+	}
+	return f.Name()
+}
+
+func TestIsPDFEncrypted(t *testing.T) {
+	padding := bytes.Repeat([]byte("% padding\n"), 2*pdfEncryptionSearchBytes/10)
+	testCases := []struct {
+		name      string
+		content   []byte
+		encrypted bool
+	}{
+		{"not a PDF", []byte("/Encrypt 5 0 R"), false},
+		{"plain", []byte("%PDF-1.4\ntrailer << /Root 1 0 R >>\n%%EOF\n"), false},
+		{"trailer", []byte("%PDF-1.4\ntrailer << /Root 1 0 R /Encrypt 5 0 R >>\n%%EOF\n"), true},
+		{"inline dictionary", []byte("%PDF-1.6\n<< /Type /XRef /Encrypt << /Filter /Standard >> >>\n"), true},
+		{"tail of a big file", append(append([]byte("%PDF-1.4\n"), padding...),
+			[]byte("trailer << /Encrypt 5 0 R >>\n%%EOF\n")...), true},
+		{"big plain file", append(append([]byte("%PDF-1.4\n"), padding...),
+			[]byte("trailer << /Root 1 0 R >>\n%%EOF\n")...), false},
+	}
+
+	for _, tc := range testCases {
+		filename := writeTempFile(t, tc.content)
+		encrypted, err := IsPDFEncrypted(filename)
+		os.Remove(filename)
+		if err != nil || encrypted != tc.encrypted {
+			t.Logf("%s: expected encrypted %t, got %t %v", tc.name, tc.encrypted, encrypted, err)
+			t.Fail()
+		}
+	}
+}
+
+func TestPreparePDF(t *testing.T) {
+	filename := writeTempFile(t, []byte("%PDF-1.4\ntrailer << /Encrypt 5 0 R >>\n%%EOF\n"))
+	defer os.Remove(filename)
+
+	if err := PreparePDF(filename, &cdd.CloudJobTicket{}); err != ErrPDFEncrypted {
+		t.Logf("expected ErrPDFEncrypted, got %v", err)
+		t.Fail()
+	}
+
+	plain := writeTempFile(t, []byte("%PDF-1.4\ntrailer << /Root 1 0 R >>\n%%EOF\n"))
+	defer os.Remove(plain)
+	if err := PreparePDF(plain, nil); err != nil {
+		t.Logf("expected plain PDF to need nothing, got %s", err)
+		t.Fail()
+	}
+}
+
+func TestPDFPassword(t *testing.T) {
+	ticket := &cdd.CloudJobTicket{
+		Print: cdd.PrintTicketSection{
+			VendorTicketItem: []cdd.VendorTicketItem{
+				{ID: "number-up", Value: "2"},
+				{ID: PDFPasswordVendorID, Value: "secret"},
+			},
+		},
+	}
+	if password, exists := PDFPassword(ticket); !exists || password != "secret" {
+		t.Logf("expected password secret, got %q %t", password, exists)
+		t.Fail()
+	}
+	if _, exists := PDFPassword(&cdd.CloudJobTicket{}); exists {
+		t.Log("expected no password")
+		t.Fail()
+	}
+}
+
+func TestPDFErrorState(t *testing.T) {
+	if s := PDFErrorState(ErrPDFEncrypted); s.ServiceActionCause == nil ||
+		s.ServiceActionCause.ErrorCode != cdd.ServiceActionCauseConversionType {
+		t.Logf("unexpected state for ErrPDFEncrypted: %+v", s)
+		t.Fail()
+	}
+	if s := PDFErrorState(ErrPDFPassword); s.DeviceActionCause == nil ||
+		s.DeviceActionCause.ErrorCode != cdd.DeviceActionCauseInvalidTicket {
+		t.Logf("unexpected state for ErrPDFPassword: %+v", s)
+		t.Fail()
+	}
+}
@@ -0,0 +1,85 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// PDFPage is a page of a PDF that WritePDF writes: its size, in points, and
+// its content stream, which may show text in /F1, Helvetica. A page of no
+// content is blank.
+type PDFPage struct {
+	Width, Height int
+	Content       string
+}
+
+// WritePDF writes a PDF of pages, like the cover sheets and test pages that
+// the connector prints itself.
+func WritePDF(pages []PDFPage) []byte {
+	// Objects are numbered from 1: the catalog, the page tree, the font,
+	// then each page and its contents.
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>",
+	}
+	kids := make([]string, len(pages))
+	for i, page := range pages {
+		kids[i] = fmt.Sprintf("%d 0 R", len(objects)+1)
+		objects = append(objects,
+			fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Resources << /Font << /F1 3 0 R >> >> /Contents %d 0 R >>",
+				page.Width, page.Height, len(objects)+2),
+			fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", len(page.Content), page.Content))
+	}
+	objects[1] = fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages))
+
+	var pdf bytes.Buffer
+	pdf.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, object := range objects {
+		offsets[i] = pdf.Len()
+		fmt.Fprintf(&pdf, "%d 0 obj\n%s\nendobj\n", i+1, object)
+	}
+	xref := pdf.Len()
+	fmt.Fprintf(&pdf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&pdf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&pdf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return pdf.Bytes()
+}
+
+// PDFText gets the content stream operators that show a line of text at x,
+// y, in points from the bottom left, in Helvetica of size points.
+func PDFText(x, y, size int, text string) string {
+	return fmt.Sprintf("BT /F1 %d Tf %d %d Td (%s) Tj ET\n", size, x, y, PDFString(text))
+}
+
+// PDFString escapes text for a PDF literal string. Characters that
+// Helvetica can't show in WinAnsiEncoding become question marks.
+func PDFString(text string) string {
+	var s bytes.Buffer
+	for _, r := range text {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			s.WriteByte('\\')
+			s.WriteRune(r)
+		case r >= ' ' && r <= '~':
+			s.WriteRune(r)
+		case r >= 0xa0 && r <= 0xff:
+			fmt.Fprintf(&s, "\\%03o", r)
+		default:
+			s.WriteByte('?')
+		}
+	}
+	return s.String()
+}
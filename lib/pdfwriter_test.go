@@ -0,0 +1,50 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package lib
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestWritePDF(t *testing.T) {
+	pdf := WritePDF([]PDFPage{
+		{Width: 595, Height: 842, Content: PDFText(72, 700, 12, "Hello")},
+		{Width: 595, Height: 842},
+	})
+
+	f, err := ioutil.TempFile("", "cloud-print-connector-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.Write(pdf)
+	f.Close()
+
+	pages, err := CountPDFPages(f.Name())
+	if err != nil || pages != 2 {
+		t.Logf("expected 2 pages, got %d, %v", pages, err)
+		t.Fail()
+	}
+	for _, expected := range []string{"/MediaBox [0 0 595 842]", "BT /F1 12 Tf 72 700 Td (Hello) Tj ET", "/Count 2"} {
+		if !bytes.Contains(pdf, []byte(expected)) {
+			t.Logf("expected %q in PDF", expected)
+			t.Fail()
+		}
+	}
+}
+
+func TestPDFString(t *testing.T) {
+	if s := PDFString(`a\b (é) 日`); s != `a\\b \(\351\) ?` {
+		t.Logf("unexpected escaped string %q", s)
+		t.Fail()
+	}
+}
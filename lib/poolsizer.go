@@ -0,0 +1,91 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package lib
+
+import "sync"
+
+// PoolSizer resizes the Semaphore that limits a pool of connections, so
+// that the pool grows under bursts, and shrinks once idle, between min
+// and max. Adjust makes one decision; the owner of the pool calls it
+// periodically.
+type PoolSizer struct {
+	sem      *Semaphore
+	min, max uint
+	// shrinkAfter is how many calls to Adjust the pool must be mostly idle
+	// for before it shrinks.
+	shrinkAfter uint
+
+	mutex     sync.Mutex
+	idleCalls uint
+	grows     uint
+	shrinks   uint
+}
+
+// NewPoolSizer sizes sem between min and max, starting at min. When min is
+// zero or not less than max, the size is fixed at max.
+func NewPoolSizer(sem *Semaphore, min, max, shrinkAfter uint) *PoolSizer {
+	if min == 0 || min > max {
+		min = max
+	}
+	sem.SetSize(min)
+	return &PoolSizer{sem: sem, min: min, max: max, shrinkAfter: shrinkAfter}
+}
+
+// Adjust grows the pool when callers are waiting for it, by doubling it or
+// by the quantity waiting, whichever is more, and halves it once less than
+// half of it has been used for shrinkAfter calls. Returns the size before
+// and after.
+func (p *PoolSizer) Adjust() (uint, uint) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	size, count, waiting := p.sem.Size(), p.sem.Count(), p.sem.Waiting()
+	newSize := size
+
+	switch {
+	case waiting > 0 && size < p.max:
+		p.idleCalls = 0
+		newSize = size * 2
+		if newSize < size+waiting {
+			newSize = size + waiting
+		}
+		if newSize > p.max {
+			newSize = p.max
+		}
+		p.grows++
+
+	case count*2 < size && size > p.min:
+		p.idleCalls++
+		if p.idleCalls < p.shrinkAfter {
+			break
+		}
+		p.idleCalls = 0
+		newSize = size / 2
+		if newSize < p.min {
+			newSize = p.min
+		}
+		p.shrinks++
+
+	default:
+		p.idleCalls = 0
+	}
+
+	if newSize != size {
+		p.sem.SetSize(newSize)
+	}
+	return size, newSize
+}
+
+// Resizes returns how many times the pool has grown and shrunk.
+func (p *PoolSizer) Resizes() (uint, uint) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.grows, p.shrinks
+}
@@ -0,0 +1,68 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package lib
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPoolSizer(t *testing.T) {
+	s := NewSemaphore(10)
+	p := NewPoolSizer(s, 2, 8, 2)
+	if s.Size() != 2 {
+		t.Fatalf("expected the pool to start at 2, got %d", s.Size())
+	}
+
+	s.Acquire()
+	s.Acquire()
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			s.Acquire()
+			wg.Done()
+		}()
+	}
+	for deadline := time.Now().Add(time.Second); s.Waiting() < 3; {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected 3 waiting, got %d", s.Waiting())
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if before, after := p.Adjust(); before != 2 || after != 5 {
+		t.Logf("expected the pool to grow from 2 to 5, got %d to %d", before, after)
+		t.Fail()
+	}
+	wg.Wait()
+
+	for i := 0; i < 5; i++ {
+		s.Release()
+	}
+	if _, after := p.Adjust(); after != 5 {
+		t.Logf("expected the pool to stay at 5 until idle for 2 adjustments, got %d", after)
+		t.Fail()
+	}
+	if _, after := p.Adjust(); after != 2 {
+		t.Logf("expected the pool to shrink to 2, got %d", after)
+		t.Fail()
+	}
+	if grows, shrinks := p.Resizes(); grows != 1 || shrinks != 1 {
+		t.Logf("expected 1 grow and 1 shrink, got %d and %d", grows, shrinks)
+		t.Fail()
+	}
+
+	fixed := NewPoolSizer(NewSemaphore(1), 0, 4, 1)
+	if _, after := fixed.Adjust(); after != 4 {
+		t.Logf("expected a pool without a minimum to stay at its maximum, got %d", after)
+		t.Fail()
+	}
+}
@@ -20,6 +20,10 @@ type PrinterState uint8
 // DuplexVendorMap maps a DuplexType to a CUPS key:value option string for a given printer.
 type DuplexVendorMap map[cdd.DuplexType]string
 
+// MarginsVendorMap maps a CUPS PageSize to the margins that a given printer
+// prints within on it.
+type MarginsVendorMap map[string]cdd.MarginsOption
+
 // CUPS: cups_dest_t; GCP: /register and /update interfaces
 type Printer struct {
 	GCPID              string                         //                                    GCP: printerid (GCP key)
@@ -38,9 +42,11 @@ type Printer struct {
 	CapsHash           string                         // CUPS: hash of PPD;                 GCP: capsHash field
 	Tags               map[string]string              // CUPS: all printer attributes;      GCP: repeated tag field
 	DuplexMap          DuplexVendorMap                // CUPS: PPD;
+	MarginsMap         MarginsVendorMap               // CUPS: PPD;
 	NativeJobSemaphore *Semaphore
 	QuotaEnabled       bool
 	DailyQuota         int
+	CapsUnavailable    bool
 }
 
 var rDeviceURIHostname *regexp.Regexp = regexp.MustCompile(
@@ -88,6 +94,7 @@ type PrinterDiff struct {
 	CapsHashChanged           bool
 	TagsChanged               bool
 	DuplexMapChanged          bool
+	MarginsMapChanged         bool
 	QuotaEnabledChanged       bool
 	DailyQuotaChanged         bool
 }
@@ -124,6 +131,16 @@ func DiffPrinters(nativePrinters, gcpPrinters []Printer) []PrinterDiff {
 				nativePrinter.GCPID = gcpPrinters[i].GCPID
 				// Don't lose track of this semaphore.
 				nativePrinter.NativeJobSemaphore = gcpPrinters[i].NativeJobSemaphore
+				if nativePrinter.CapsUnavailable {
+					// Keep the capabilities that GCP has until they can be
+					// fetched again, rather than replace them with a subset.
+					nativePrinter.Description = gcpPrinters[i].Description
+					nativePrinter.CapsHash = gcpPrinters[i].CapsHash
+					nativePrinter.Manufacturer = gcpPrinters[i].Manufacturer
+					nativePrinter.Model = gcpPrinters[i].Model
+					nativePrinter.DuplexMap = gcpPrinters[i].DuplexMap
+					nativePrinter.MarginsMap = gcpPrinters[i].MarginsMap
+				}
 
 				diff := diffPrinter(&nativePrinter, &gcpPrinters[i])
 				diffs = append(diffs, diff)
@@ -211,6 +228,10 @@ func diffPrinter(pn, pg *Printer) PrinterDiff {
 		d.DuplexMapChanged = true
 	}
 
+	if !reflect.DeepEqual(pg.MarginsMap, pn.MarginsMap) {
+		d.MarginsMapChanged = true
+	}
+
 	if pg.QuotaEnabled != pn.QuotaEnabled {
 		d.QuotaEnabledChanged = true
 	}
@@ -223,7 +244,7 @@ func diffPrinter(pn, pg *Printer) PrinterDiff {
 		d.GCPVersionChanged || d.SetupURLChanged || d.SupportURLChanged ||
 		d.UpdateURLChanged || d.ConnectorVersionChanged || d.StateChanged ||
 		d.DescriptionChanged || d.CapsHashChanged || d.TagsChanged ||
-		d.DuplexMapChanged || d.QuotaEnabledChanged || d.DailyQuotaChanged {
+		d.DuplexMapChanged || d.MarginsMapChanged || d.QuotaEnabledChanged || d.DailyQuotaChanged {
 		return d
 	}
 
@@ -275,6 +296,12 @@ func PrinterIsRaw(printer Printer) bool {
 	return false
 }
 
+// PrinterIsRejectingJobs is true when the native queue of a printer doesn't
+// accept new jobs, so jobs wait in the cloud until it does.
+func PrinterIsRejectingJobs(printer Printer) bool {
+	return printer.Tags["printer-is-accepting-jobs"] == "false"
+}
+
 func PrinterIsClass(printer Printer) bool {
 	if printer.Tags["printer-make-and-model"] == "Local Printer Class" {
 		return true
@@ -11,6 +11,8 @@ package lib
 import (
 	"testing"
 	"reflect"
+
+	"github.com/google/cloud-print-connector/cdd"
 )
 
 func TestFilterBlacklistPrinters(t *testing.T) {
@@ -58,3 +60,42 @@ func TestFilterWhitelistPrinters(t *testing.T) {
 		t.Fatalf("filtering result incorrect: %v", filteredPrinters)
 	}
 }
+
+func TestDiffPrintersCapsUnavailable(t *testing.T) {
+	registered := &cdd.PrinterDescriptionSection{Copies: &cdd.Copies{Default: 1, Max: 99}}
+	gcpPrinters := []Printer{{
+		GCPID:        "gcp-1",
+		Name:         "alpha",
+		Manufacturer: "Acme",
+		Model:        "Laser 9000",
+		Description:  registered,
+		CapsHash:     "registered",
+		State:        &cdd.PrinterStateSection{State: cdd.CloudDeviceStateIdle},
+	}}
+	nativePrinters := []Printer{{
+		Name:            "alpha",
+		Description:     &cdd.PrinterDescriptionSection{},
+		CapsHash:        "partial",
+		State:           &cdd.PrinterStateSection{State: cdd.CloudDeviceStateStopped},
+		CapsUnavailable: true,
+	}}
+
+	diffs := DiffPrinters(nativePrinters, gcpPrinters)
+	if len(diffs) != 1 || diffs[0].Operation != UpdatePrinter {
+		t.Fatalf("expected one update, got %+v", diffs)
+	}
+	d := diffs[0]
+	if d.Printer.Description != registered || d.Printer.CapsHash != "registered" ||
+		d.Printer.Manufacturer != "Acme" || d.Printer.Model != "Laser 9000" {
+		t.Logf("expected the registered capabilities to be kept, got %+v", d.Printer)
+		t.Fail()
+	}
+	if d.DescriptionChanged || d.CapsHashChanged || d.ManufacturerChanged || d.ModelChanged {
+		t.Logf("expected no capabilities change, got %+v", d)
+		t.Fail()
+	}
+	if !d.StateChanged {
+		t.Log("expected the state to change")
+		t.Fail()
+	}
+}
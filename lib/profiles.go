@@ -0,0 +1,131 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package lib
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/urfave/cli"
+)
+
+const (
+	// A desktop or laptop with a few directly attached printers.
+	ProfileWorkstation = "workstation"
+	// A dedicated server that shares many printers.
+	ProfilePrintServer = "print-server"
+	// An unattended machine with one printer, like a ticket or label kiosk.
+	ProfileKiosk = "kiosk"
+	// A container, where local discovery (mDNS) usually doesn't work.
+	ProfileContainer = "container"
+)
+
+// configProfile is a bundle of defaults for one deployment shape.
+type configProfile struct {
+	localPrintingEnable       bool
+	nativePrinterPollInterval string
+	nativeJobQueueSize        uint
+	gcpMaxConcurrentDownloads uint
+	gcpMaxConcurrentFetches   uint
+	// CUPS only: connections to the CUPS server.
+	cupsMaxConnections uint
+}
+
+var configProfiles = map[string]configProfile{
+	ProfileWorkstation: {
+		localPrintingEnable:       true,
+		nativePrinterPollInterval: "1m",
+		nativeJobQueueSize:        3,
+		gcpMaxConcurrentDownloads: 2,
+		gcpMaxConcurrentFetches:   2,
+		cupsMaxConnections:        10,
+	},
+	ProfilePrintServer: {
+		localPrintingEnable:       false,
+		nativePrinterPollInterval: "1m",
+		nativeJobQueueSize:        10,
+		gcpMaxConcurrentDownloads: 20,
+		gcpMaxConcurrentFetches:   20,
+		cupsMaxConnections:        100,
+	},
+	ProfileKiosk: {
+		localPrintingEnable:       true,
+		nativePrinterPollInterval: "30s",
+		nativeJobQueueSize:        1,
+		gcpMaxConcurrentDownloads: 1,
+		gcpMaxConcurrentFetches:   1,
+		cupsMaxConnections:        5,
+	},
+	ProfileContainer: {
+		localPrintingEnable:       false,
+		nativePrinterPollInterval: "1m",
+		nativeJobQueueSize:        3,
+		gcpMaxConcurrentDownloads: 5,
+		gcpMaxConcurrentFetches:   5,
+		cupsMaxConnections:        20,
+	},
+}
+
+// ConfigProfiles gets the names of the config profiles, sorted.
+func ConfigProfiles() []string {
+	names := make([]string, 0, len(configProfiles))
+	for name := range configProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ProfileDefaults gets the default values of a config profile. The empty
+// profile is DefaultConfig.
+func ProfileDefaults(profile string) (Config, error) {
+	d := DefaultConfig
+	if profile == "" {
+		return d, nil
+	}
+	p, exists := configProfiles[profile]
+	if !exists {
+		return d, fmt.Errorf("Unknown config profile %q; choose one of %s",
+			profile, strings.Join(ConfigProfiles(), ", "))
+	}
+	p.apply(&d, func(string) bool { return false })
+	d.LocalPrintingEnable = p.localPrintingEnable
+	d.Profile = profile
+	return d, nil
+}
+
+// UseProfileDefaults returns a copy of this config with the values of its
+// profile, except those set by flags. The flags of the util default to
+// DefaultConfig, so this applies the profile to a config made from flags.
+// Local printing is left alone, since the util always asks for it.
+func (c *Config) UseProfileDefaults(context *cli.Context) *Config {
+	u := *c
+	if p, exists := configProfiles[c.Profile]; exists {
+		p.apply(&u, context.IsSet)
+	}
+	return &u
+}
+
+// applyCommon sets the values of this profile that aren't set by flags, on
+// every platform.
+func (p *configProfile) applyCommon(c *Config, isSet func(string) bool) {
+	if !isSet("native-printer-poll-interval") {
+		c.NativePrinterPollInterval = p.nativePrinterPollInterval
+	}
+	if !isSet("native-job-queue-size") {
+		c.NativeJobQueueSize = p.nativeJobQueueSize
+	}
+	if !isSet("gcp-max-concurrent-downloads") {
+		c.GCPMaxConcurrentDownloads = p.gcpMaxConcurrentDownloads
+	}
+	if !isSet("gcp-max-concurrent-fetches") {
+		c.GCPMaxConcurrentFetches = p.gcpMaxConcurrentFetches
+	}
+}
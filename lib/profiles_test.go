@@ -0,0 +1,75 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package lib
+
+import (
+	"flag"
+	"testing"
+
+	"github.com/urfave/cli"
+)
+
+func TestProfileDefaults(t *testing.T) {
+	if _, err := ProfileDefaults("laptop"); err == nil {
+		t.Log("expected an error for an unknown profile")
+		t.Fail()
+	}
+	if d, err := ProfileDefaults(""); err != nil || d.GCPMaxConcurrentDownloads != DefaultConfig.GCPMaxConcurrentDownloads {
+		t.Logf("expected the empty profile to be DefaultConfig, got %+v, %s", d, err)
+		t.Fail()
+	}
+
+	for _, name := range ConfigProfiles() {
+		d, err := ProfileDefaults(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d.Profile != name || d.GCPMaxConcurrentDownloads == 0 || d.NativeJobQueueSize == 0 || d.NativePrinterPollInterval == "" {
+			t.Logf("expected profile %s to set its defaults, got %+v", name, d)
+			t.Fail()
+		}
+	}
+}
+
+func TestProfileBackfillAndSparse(t *testing.T) {
+	p := configProfiles[ProfilePrintServer]
+	c := Config{Profile: ProfilePrintServer, NativeJobQueueSize: 4}
+	b := c.Backfill(map[string]interface{}{"profile": ProfilePrintServer, "native_job_queue_size": 4})
+	if b.GCPMaxConcurrentDownloads != p.gcpMaxConcurrentDownloads || b.LocalPrintingEnable != p.localPrintingEnable {
+		t.Logf("expected missing keys from the profile, got %+v", b)
+		t.Fail()
+	}
+	if b.NativeJobQueueSize != 4 {
+		t.Logf("expected the config file to override the profile, got queue size %d", b.NativeJobQueueSize)
+		t.Fail()
+	}
+	if b.XMPPServer != DefaultConfig.XMPPServer {
+		t.Logf("expected keys that the profile doesn't set from DefaultConfig, got %s", b.XMPPServer)
+		t.Fail()
+	}
+
+	context := cli.NewContext(nil, flag.NewFlagSet("test", flag.ContinueOnError), nil)
+	s := b.Sparse(context)
+	if s.GCPMaxConcurrentDownloads != 0 || s.NativePrinterPollInterval != "" {
+		t.Logf("expected values of the profile to be removed, got %+v", s)
+		t.Fail()
+	}
+	if s.NativeJobQueueSize != 4 {
+		t.Logf("expected a value that differs from the profile to stay, got %d", s.NativeJobQueueSize)
+		t.Fail()
+	}
+
+	// A config made from flags, which default to DefaultConfig.
+	u := DefaultConfig
+	u.Profile = ProfileKiosk
+	if kiosk := u.UseProfileDefaults(context); kiosk.GCPMaxConcurrentFetches != configProfiles[ProfileKiosk].gcpMaxConcurrentFetches {
+		t.Logf("expected unset flags to take the profile's values, got %+v", kiosk)
+		t.Fail()
+	}
+}
@@ -0,0 +1,250 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/cloud-print-connector/cdd"
+)
+
+const quarantineWebhookTimeout = 10 * time.Second
+
+// QuarantineAlert is a printer that has been quarantined or released.
+type QuarantineAlert struct {
+	Printer     string `json:"printer"`
+	Failures    uint   `json:"failures"`
+	Quarantined bool   `json:"quarantined"`
+	Reason      string `json:"reason"`
+}
+
+// QuarantinedPrinter is a printer in quarantine, and since when.
+type QuarantinedPrinter struct {
+	Printer string    `json:"printer"`
+	Since   time.Time `json:"since"`
+}
+
+// PrinterQuarantine counts the consecutive failed jobs of each printer, and
+// quarantines a printer once they reach a threshold, so that a broken
+// printer doesn't fail job after job. A quarantined printer is reported
+// STOPPED, and its jobs wait in the cloud, until an administrator clears
+// it, or a probe of its native state finds it healthy.
+//
+// A nil PrinterQuarantine never quarantines.
+type PrinterQuarantine struct {
+	threshold     uint
+	probeInterval time.Duration
+	webhookURL    string
+	client        *http.Client
+
+	mutex       sync.Mutex
+	failures    map[string]uint
+	quarantined map[string]time.Time
+	probed      map[string]time.Time
+}
+
+// NewPrinterQuarantine quarantines printers, by native printer name, after
+// threshold consecutive failed jobs; zero threshold never quarantines, and
+// returns nil. A quarantined printer is probed every probeInterval; zero
+// probeInterval leaves it quarantined until cleared. Alerts are posted as
+// JSON to webhookURL, unless it is empty.
+func NewPrinterQuarantine(threshold uint, probeInterval time.Duration, webhookURL string) *PrinterQuarantine {
+	if threshold == 0 {
+		return nil
+	}
+	return &PrinterQuarantine{
+		threshold:     threshold,
+		probeInterval: probeInterval,
+		webhookURL:    webhookURL,
+		client:        &http.Client{Timeout: quarantineWebhookTimeout},
+		failures:      make(map[string]uint),
+		quarantined:   make(map[string]time.Time),
+		probed:        make(map[string]time.Time),
+	}
+}
+
+// JobFailed counts a failed job of a printer. Returns true when the failure
+// quarantines the printer.
+func (q *PrinterQuarantine) JobFailed(printerName string, now time.Time) bool {
+	if q == nil {
+		return false
+	}
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	q.failures[printerName]++
+	if _, exists := q.quarantined[printerName]; exists || q.failures[printerName] < q.threshold {
+		return false
+	}
+	q.quarantined[printerName] = now
+	q.probed[printerName] = now
+	return true
+}
+
+// JobSucceeded resets the failures of a printer.
+func (q *PrinterQuarantine) JobSucceeded(printerName string) {
+	if q == nil {
+		return
+	}
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	delete(q.failures, printerName)
+}
+
+// IsQuarantined tells whether a printer is quarantined.
+func (q *PrinterQuarantine) IsQuarantined(printerName string) bool {
+	if q == nil {
+		return false
+	}
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	_, exists := q.quarantined[printerName]
+	return exists
+}
+
+// Failures gets the consecutive failed jobs of a printer.
+func (q *PrinterQuarantine) Failures(printerName string) uint {
+	if q == nil {
+		return 0
+	}
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	return q.failures[printerName]
+}
+
+// Clear releases a printer from quarantine, and resets its failures.
+// Returns false when it wasn't quarantined.
+func (q *PrinterQuarantine) Clear(printerName string) bool {
+	if q == nil {
+		return false
+	}
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	_, exists := q.quarantined[printerName]
+	q.release(printerName)
+	delete(q.failures, printerName)
+	return exists
+}
+
+// release takes a printer out of quarantine. The caller holds the mutex.
+func (q *PrinterQuarantine) release(printerName string) {
+	delete(q.quarantined, printerName)
+	delete(q.probed, printerName)
+}
+
+// List gets the quarantined printers, by name.
+func (q *PrinterQuarantine) List() []QuarantinedPrinter {
+	printers := []QuarantinedPrinter{}
+	if q == nil {
+		return printers
+	}
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	for name, since := range q.quarantined {
+		printers = append(printers, QuarantinedPrinter{name, since})
+	}
+	sort.Slice(printers, func(i, j int) bool { return printers[i].Printer < printers[j].Printer })
+	return printers
+}
+
+// Apply marks a quarantined printer STOPPED, and explains why in its vendor
+// state. Every probeInterval, a quarantined printer whose native state is
+// healthy is released instead, on probation: one more failed job
+// quarantines it again. Returns true when the printer is released.
+//
+// The printer's state is copied before it is changed, so it may be shared.
+func (q *PrinterQuarantine) Apply(printer *Printer, now time.Time) bool {
+	if q == nil {
+		return false
+	}
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if _, exists := q.quarantined[printer.Name]; !exists {
+		return false
+	}
+
+	if q.probeInterval > 0 && now.Sub(q.probed[printer.Name]) >= q.probeInterval {
+		q.probed[printer.Name] = now
+		if printerIsHealthy(printer) {
+			q.release(printer.Name)
+			q.failures[printer.Name] = q.threshold - 1
+			return true
+		}
+	}
+
+	state := cdd.PrinterStateSection{}
+	if printer.State != nil {
+		state = *printer.State
+	}
+	state.State = cdd.CloudDeviceStateStopped
+	vendorState := cdd.VendorState{}
+	if state.VendorState != nil {
+		vendorState.Item = append(vendorState.Item, state.VendorState.Item...)
+	}
+	vendorState.Item = append(vendorState.Item, cdd.VendorStateItem{
+		State: cdd.VendorStateError,
+		Description: fmt.Sprintf("Quarantined after %d consecutive failed jobs; clear with gcp-connector-util clear-quarantine",
+			q.failures[printer.Name]),
+	})
+	state.VendorState = &vendorState
+	printer.State = &state
+
+	return false
+}
+
+// printerIsHealthy is true when the native state of a printer is neither
+// stopped nor in error.
+func printerIsHealthy(printer *Printer) bool {
+	if printer.State == nil {
+		return true
+	}
+	if printer.State.State == cdd.CloudDeviceStateStopped {
+		return false
+	}
+	if printer.State.VendorState != nil {
+		for _, item := range printer.State.VendorState.Item {
+			if item.State == cdd.VendorStateError {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Notify posts an alert to the webhook, if there is one.
+func (q *PrinterQuarantine) Notify(alert QuarantineAlert) error {
+	if q == nil || q.webhookURL == "" {
+		return nil
+	}
+	b, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	response, err := q.client.Post(q.webhookURL, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("Quarantine webhook responded %s", response.Status)
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package lib
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/cloud-print-connector/cdd"
+)
+
+func TestPrinterQuarantine(t *testing.T) {
+	if q := NewPrinterQuarantine(0, time.Minute, ""); q != nil || q.JobFailed("a", time.Now()) || q.Apply(&Printer{Name: "a"}, time.Now()) {
+		t.Log("expected zero threshold to never quarantine")
+		t.Fail()
+	}
+
+	q := NewPrinterQuarantine(3, time.Minute, "")
+	start := time.Now()
+
+	q.JobFailed("a", start)
+	q.JobSucceeded("a")
+	if q.JobFailed("a", start) || q.JobFailed("a", start) {
+		t.Log("expected a success to reset the failures")
+		t.Fail()
+	}
+	if !q.JobFailed("a", start) || !q.IsQuarantined("a") {
+		t.Log("expected the third consecutive failure to quarantine")
+		t.Fail()
+	}
+	if q.JobFailed("a", start) {
+		t.Log("expected a quarantined printer to be quarantined only once")
+		t.Fail()
+	}
+
+	idle := &cdd.PrinterStateSection{State: cdd.CloudDeviceStateIdle}
+	printer := Printer{Name: "a", State: idle}
+	if q.Apply(&printer, start.Add(time.Second)) {
+		t.Log("expected no probe before the probe interval")
+		t.Fail()
+	}
+	if printer.State.State != cdd.CloudDeviceStateStopped || printer.State.VendorState == nil ||
+		len(printer.State.VendorState.Item) != 1 || printer.State.VendorState.Item[0].State != cdd.VendorStateError {
+		t.Logf("expected a stopped printer with an error, got %+v", printer.State)
+		t.Fail()
+	}
+	if idle.State != cdd.CloudDeviceStateIdle || idle.VendorState != nil {
+		t.Log("expected the printer's original state to be left alone")
+		t.Fail()
+	}
+
+	broken := Printer{Name: "a", State: &cdd.PrinterStateSection{State: cdd.CloudDeviceStateStopped}}
+	if q.Apply(&broken, start.Add(time.Minute)) || !q.IsQuarantined("a") {
+		t.Log("expected a stopped printer to fail its probe")
+		t.Fail()
+	}
+	printer = Printer{Name: "a", State: idle}
+	if q.Apply(&printer, start.Add(time.Minute+time.Second)) {
+		t.Log("expected no probe until another probe interval")
+		t.Fail()
+	}
+	printer = Printer{Name: "a", State: idle}
+	if !q.Apply(&printer, start.Add(2*time.Minute)) || q.IsQuarantined("a") || printer.State != idle {
+		t.Log("expected a healthy printer to pass its probe, and be released")
+		t.Fail()
+	}
+	if !q.JobFailed("a", start) {
+		t.Log("expected a released printer to be quarantined by its next failure")
+		t.Fail()
+	}
+
+	if list := q.List(); len(list) != 1 || list[0].Printer != "a" {
+		t.Logf("expected printer a to be listed, got %+v", list)
+		t.Fail()
+	}
+	if !q.Clear("a") || q.IsQuarantined("a") || q.Failures("a") != 0 {
+		t.Log("expected clearing to release the printer and reset its failures")
+		t.Fail()
+	}
+	if q.Clear("a") {
+		t.Log("expected clearing a printer that isn't quarantined to return false")
+		t.Fail()
+	}
+}
@@ -0,0 +1,159 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package lib
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// throughputWindow is how many of the last seconds a ThroughputMeter
+// averages.
+const throughputWindow = 10
+
+// RateLimiter is a token bucket that limits bytes per second, shared by
+// every reader or writer that waits on it. Bursts of up to one second's
+// worth pass at once. A zero rate, or a nil RateLimiter, limits nothing.
+type RateLimiter struct {
+	mutex  sync.Mutex
+	rate   int64
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter creates a RateLimiter of bytesPerSecond.
+func NewRateLimiter(bytesPerSecond int64) *RateLimiter {
+	return &RateLimiter{rate: bytesPerSecond, tokens: float64(bytesPerSecond), last: time.Now()}
+}
+
+// Rate gets the bytes per second; zero is no limit.
+func (l *RateLimiter) Rate() int64 {
+	if l == nil {
+		return 0
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.rate
+}
+
+// SetRate changes the bytes per second; zero is no limit.
+func (l *RateLimiter) SetRate(bytesPerSecond int64) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.rate = bytesPerSecond
+	l.tokens = float64(bytesPerSecond)
+	l.last = time.Now()
+}
+
+// WaitN waits until n bytes may pass, or until ctx is done. Bytes that
+// pass before the bucket has them are owed by the next callers, so that
+// n may be more than a second's worth.
+func (l *RateLimiter) WaitN(ctx context.Context, n int) error {
+	if l == nil {
+		return nil
+	}
+
+	l.mutex.Lock()
+	if l.rate <= 0 {
+		l.mutex.Unlock()
+		return nil
+	}
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * float64(l.rate)
+	if l.tokens > float64(l.rate) {
+		l.tokens = float64(l.rate)
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	var wait time.Duration
+	if l.tokens < 0 {
+		wait = time.Duration(-l.tokens / float64(l.rate) * float64(time.Second))
+	}
+	l.mutex.Unlock()
+
+	if wait == 0 {
+		return nil
+	}
+	t := time.NewTimer(wait)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+type rateLimitedWriter struct {
+	ctx      context.Context
+	w        io.Writer
+	limiters []*RateLimiter
+}
+
+// NewRateLimitedWriter creates a writer that writes to w no faster than
+// every one of limiters allows, until ctx is done. A download copied to
+// it is read no faster, either.
+func NewRateLimitedWriter(ctx context.Context, w io.Writer, limiters ...*RateLimiter) io.Writer {
+	return &rateLimitedWriter{ctx, w, limiters}
+}
+
+func (w *rateLimitedWriter) Write(p []byte) (int, error) {
+	for _, l := range w.limiters {
+		if err := l.WaitN(w.ctx, len(p)); err != nil {
+			return 0, err
+		}
+	}
+	return w.w.Write(p)
+}
+
+// ThroughputMeter measures bytes per second, averaged over the last ten
+// seconds. Bytes are counted by writing them to it.
+type ThroughputMeter struct {
+	mutex sync.Mutex
+	// buckets are the bytes of each of the last seconds, by Unix time
+	// modulo throughputWindow; seconds says which second each is of.
+	buckets [throughputWindow]int64
+	seconds [throughputWindow]int64
+	total   int64
+}
+
+// Write counts the bytes of p.
+func (m *ThroughputMeter) Write(p []byte) (int, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	now := time.Now().Unix()
+	i := now % throughputWindow
+	if m.seconds[i] != now {
+		m.seconds[i] = now
+		m.buckets[i] = 0
+	}
+	m.buckets[i] += int64(len(p))
+	m.total += int64(len(p))
+	return len(p), nil
+}
+
+// Throughput gets the bytes per second of the last ten seconds, and the
+// bytes counted since the meter was created.
+func (m *ThroughputMeter) Throughput() (int64, int64) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	now := time.Now().Unix()
+	var sum int64
+	for i := range m.buckets {
+		if now-m.seconds[i] < throughputWindow {
+			sum += m.buckets[i]
+		}
+	}
+	return sum / throughputWindow, m.total
+}
@@ -0,0 +1,61 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package lib
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestRateLimitedWriter(t *testing.T) {
+	// One second's worth passes at once; the next second's worth waits.
+	l := NewRateLimiter(10000)
+	var meter ThroughputMeter
+	var b bytes.Buffer
+	w := NewRateLimitedWriter(context.Background(), io.MultiWriter(&b, &meter), l, nil)
+
+	start := time.Now()
+	for i := 0; i < 20; i++ {
+		if _, err := w.Write(make([]byte, 1000)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if d := time.Since(start); d < 900*time.Millisecond || d > 3*time.Second {
+		t.Logf("expected 20000 bytes at 10000 bytes per second to take about a second, took %s", d)
+		t.Fail()
+	}
+	if b.Len() != 20000 {
+		t.Logf("expected 20000 bytes written, got %d", b.Len())
+		t.Fail()
+	}
+	if rate, total := meter.Throughput(); rate != 2000 || total != 20000 {
+		t.Logf("expected 2000 bytes per second over ten seconds and 20000 in all, got %d and %d", rate, total)
+		t.Fail()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	w = NewRateLimitedWriter(ctx, &b, l)
+	if _, err := w.Write(make([]byte, 100000)); err != context.Canceled {
+		t.Logf("expected a canceled write to fail, got %v", err)
+		t.Fail()
+	}
+
+	l.SetRate(0)
+	start = time.Now()
+	w = NewRateLimitedWriter(context.Background(), &b, l)
+	w.Write(make([]byte, 1000000))
+	if d := time.Since(start); d > 100*time.Millisecond {
+		t.Logf("expected no limit at rate 0, took %s", d)
+		t.Fail()
+	}
+}
@@ -0,0 +1,199 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// Subsystems whose goroutines and connections are counted, to find the
+// slow leaks that show after weeks of uptime.
+const (
+	SubsystemCUPS    = "cups"
+	SubsystemGCP     = "gcp"
+	SubsystemManager = "manager"
+	SubsystemMonitor = "monitor"
+	SubsystemPrivet  = "privet"
+	SubsystemXMPP    = "xmpp"
+)
+
+// ResourceCount is the quantity of goroutines and open connections of one
+// subsystem.
+type ResourceCount struct {
+	Goroutines  int
+	Connections int
+}
+
+// resourceAccounting counts goroutines and connections by subsystem, and
+// remembers the subsystem of each counted goroutine by goroutine ID.
+type resourceAccounting struct {
+	mutex  sync.Mutex
+	counts map[string]*ResourceCount
+	labels map[uint64]string
+}
+
+var resources = resourceAccounting{
+	counts: make(map[string]*ResourceCount),
+	labels: make(map[uint64]string),
+}
+
+func (ra *resourceAccounting) count(subsystem string) *ResourceCount {
+	c, exists := ra.counts[subsystem]
+	if !exists {
+		c = &ResourceCount{}
+		ra.counts[subsystem] = c
+	}
+	return c
+}
+
+// Go calls f in a new goroutine, which is counted as one of subsystem's
+// until f returns.
+func Go(subsystem string, f func()) {
+	go func() {
+		id := goroutineID()
+		resources.mutex.Lock()
+		resources.count(subsystem).Goroutines++
+		resources.labels[id] = subsystem
+		resources.mutex.Unlock()
+
+		defer func() {
+			resources.mutex.Lock()
+			resources.count(subsystem).Goroutines--
+			delete(resources.labels, id)
+			resources.mutex.Unlock()
+		}()
+
+		f()
+	}()
+}
+
+// trackedConn is a connection that is counted until it is closed.
+type trackedConn struct {
+	net.Conn
+	subsystem string
+	once      sync.Once
+}
+
+func (c *trackedConn) Close() error {
+	c.once.Do(func() {
+		resources.mutex.Lock()
+		resources.count(c.subsystem).Connections--
+		resources.mutex.Unlock()
+	})
+	return c.Conn.Close()
+}
+
+// TrackConn counts conn as one of subsystem's connections until it is
+// closed.
+func TrackConn(subsystem string, conn net.Conn) net.Conn {
+	resources.mutex.Lock()
+	resources.count(subsystem).Connections++
+	resources.mutex.Unlock()
+
+	return &trackedConn{Conn: conn, subsystem: subsystem}
+}
+
+// ResourceCounts gets the goroutines and connections of each subsystem.
+func ResourceCounts() map[string]ResourceCount {
+	resources.mutex.Lock()
+	defer resources.mutex.Unlock()
+
+	counts := make(map[string]ResourceCount, len(resources.counts))
+	for subsystem, c := range resources.counts {
+		counts[subsystem] = *c
+	}
+	return counts
+}
+
+// WriteGoroutineStacks writes the stacks of all goroutines, like a panic
+// does, with the subsystem of each counted goroutine after its header.
+// A summary of the counts comes first.
+func WriteGoroutineStacks(w io.Writer) error {
+	counts := ResourceCounts()
+	subsystems := make([]string, 0, len(counts))
+	for subsystem := range counts {
+		subsystems = append(subsystems, subsystem)
+	}
+	sort.Strings(subsystems)
+
+	fmt.Fprintf(w, "goroutines=%d\n", runtime.NumGoroutine())
+	for _, subsystem := range subsystems {
+		fmt.Fprintf(w, "%s: goroutines=%d connections=%d\n",
+			subsystem, counts[subsystem].Goroutines, counts[subsystem].Connections)
+	}
+
+	buf := make([]byte, 1<<16)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+
+	resources.mutex.Lock()
+	labels := make(map[uint64]string, len(resources.labels))
+	for id, subsystem := range resources.labels {
+		labels[id] = subsystem
+	}
+	resources.mutex.Unlock()
+
+	for _, stack := range bytes.Split(buf, []byte("\n\n")) {
+		header := stack
+		if i := bytes.IndexByte(stack, '\n'); i >= 0 {
+			header, stack = stack[:i], stack[i:]
+		} else {
+			stack = nil
+		}
+		subsystem := "unlabeled"
+		if id, ok := parseGoroutineHeader(header); ok {
+			if s, exists := labels[id]; exists {
+				subsystem = s
+			}
+		}
+		if _, err := fmt.Fprintf(w, "\n%s subsystem=%s%s\n", header, subsystem, stack); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ServeGoroutineStacks is an http.HandlerFunc that writes
+// WriteGoroutineStacks.
+func ServeGoroutineStacks(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	WriteGoroutineStacks(w)
+}
+
+// goroutineID gets the ID of the calling goroutine, from the header of its
+// stack, like "goroutine 18 [running]:".
+func goroutineID() uint64 {
+	buf := make([]byte, 64)
+	buf = buf[:runtime.Stack(buf, false)]
+	id, _ := parseGoroutineHeader(buf)
+	return id
+}
+
+func parseGoroutineHeader(header []byte) (uint64, bool) {
+	header = bytes.TrimPrefix(header, []byte("goroutine "))
+	if i := bytes.IndexByte(header, ' '); i >= 0 {
+		header = header[:i]
+	}
+	id, err := strconv.ParseUint(string(header), 10, 64)
+	return id, err == nil
+}
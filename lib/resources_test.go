@@ -0,0 +1,68 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package lib
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGoCountsGoroutines(t *testing.T) {
+	started, stop, stopped := make(chan struct{}), make(chan struct{}), make(chan struct{})
+	Go("test-go", func() {
+		close(started)
+		<-stop
+	})
+	<-started
+
+	if c := ResourceCounts()["test-go"]; c.Goroutines != 1 {
+		t.Logf("expected 1 goroutine, got %d", c.Goroutines)
+		t.Fail()
+	}
+
+	var b bytes.Buffer
+	if err := WriteGoroutineStacks(&b); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(b.String(), "subsystem=test-go\n") {
+		t.Logf("expected a goroutine of subsystem test-go in\n%s", b.String())
+		t.Fail()
+	}
+
+	Go("test-go", func() {
+		close(stop)
+		close(stopped)
+	})
+	<-stopped
+	for ResourceCounts()["test-go"].Goroutines > 0 {
+		// Wait for both goroutines to finish.
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestTrackConn(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+
+	conn := TrackConn("test-conn", a)
+	if c := ResourceCounts()["test-conn"]; c.Connections != 1 {
+		t.Logf("expected 1 connection, got %d", c.Connections)
+		t.Fail()
+	}
+
+	conn.Close()
+	conn.Close()
+	if c := ResourceCounts()["test-conn"]; c.Connections != 0 {
+		t.Logf("expected 0 connections after close, got %d", c.Connections)
+		t.Fail()
+	}
+}
@@ -0,0 +1,70 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package lib
+
+import "github.com/google/cloud-print-connector/cdd"
+
+// RetargetTicket copies a ticket that was written for one printer, so that
+// it prints on another printer of description. Ticket items that name an
+// option by the vendor ID of the first printer name the same option of the
+// other printer instead, or are dropped, so that the other printer's
+// default applies, when it has no such option. Other ticket items are
+// checked against description when the ticket is translated to print.
+func RetargetTicket(ticket *cdd.CloudJobTicket, description *cdd.PrinterDescriptionSection) *cdd.CloudJobTicket {
+	if ticket == nil {
+		return nil
+	}
+	t := *ticket
+	if description == nil {
+		description = &cdd.PrinterDescriptionSection{}
+	}
+
+	if t.Print.Color != nil {
+		t.Print.Color = retargetColor(*t.Print.Color, description.Color)
+	}
+	if t.Print.MediaSize != nil {
+		t.Print.MediaSize = retargetMediaSize(*t.Print.MediaSize, description.MediaSize)
+	}
+	return &t
+}
+
+// retargetColor finds the option of capability with the type of item.
+func retargetColor(item cdd.ColorTicketItem, capability *cdd.Color) *cdd.ColorTicketItem {
+	if capability == nil {
+		return nil
+	}
+	for _, o := range capability.Option {
+		if o.Type == item.Type {
+			return &cdd.ColorTicketItem{VendorID: o.VendorID, Type: o.Type}
+		}
+	}
+	return nil
+}
+
+// retargetMediaSize finds the option of capability with the size of item,
+// or a custom size, when capability allows it.
+func retargetMediaSize(item cdd.MediaSizeTicketItem, capability *cdd.MediaSize) *cdd.MediaSizeTicketItem {
+	if capability == nil {
+		return nil
+	}
+	for _, o := range capability.Option {
+		if o.WidthMicrons == item.WidthMicrons && o.HeightMicrons == item.HeightMicrons &&
+			o.IsContinuousFeed == item.IsContinuousFeed {
+			item.VendorID = o.VendorID
+			return &item
+		}
+	}
+	if capability.MaxWidthMicrons > 0 && capability.MaxHeightMicrons > 0 &&
+		item.WidthMicrons >= capability.MinWidthMicrons && item.WidthMicrons <= capability.MaxWidthMicrons &&
+		item.HeightMicrons >= capability.MinHeightMicrons && item.HeightMicrons <= capability.MaxHeightMicrons {
+		item.VendorID = ""
+		return &item
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package lib
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/google/cloud-print-connector/cdd"
+)
+
+func TestRetargetTicket(t *testing.T) {
+	description := &cdd.PrinterDescriptionSection{
+		Color: &cdd.Color{Option: []cdd.ColorOption{
+			{VendorID: "ColorModel:Gray", Type: cdd.ColorTypeStandardMonochrome},
+		}},
+		MediaSize: &cdd.MediaSize{
+			Option: []cdd.MediaSizeOption{
+				{Name: cdd.MediaSizeISOA4, WidthMicrons: 210000, HeightMicrons: 297000, VendorID: "A4"},
+			},
+			MinWidthMicrons: 100000, MaxWidthMicrons: 300000,
+			MinHeightMicrons: 100000, MaxHeightMicrons: 400000,
+		},
+	}
+	newTicket := func(color cdd.ColorTicketItem, mediaSize cdd.MediaSizeTicketItem) *cdd.CloudJobTicket {
+		var ticket cdd.CloudJobTicket
+		ticket.Print.Color = &color
+		ticket.Print.MediaSize = &mediaSize
+		return &ticket
+	}
+
+	ticket := newTicket(
+		cdd.ColorTicketItem{VendorID: "Mono", Type: cdd.ColorTypeStandardMonochrome},
+		cdd.MediaSizeTicketItem{WidthMicrons: 210000, HeightMicrons: 297000, VendorID: "iso_a4"})
+	retargeted := RetargetTicket(ticket, description)
+	expected := newTicket(
+		cdd.ColorTicketItem{VendorID: "ColorModel:Gray", Type: cdd.ColorTypeStandardMonochrome},
+		cdd.MediaSizeTicketItem{WidthMicrons: 210000, HeightMicrons: 297000, VendorID: "A4"})
+	if !reflect.DeepEqual(retargeted, expected) {
+		t.Logf("expected %+v, got %+v", expected.Print, retargeted.Print)
+		t.Fail()
+	}
+	if ticket.Print.Color.VendorID != "Mono" {
+		t.Log("expected the original ticket to be left alone")
+		t.Fail()
+	}
+
+	ticket = newTicket(
+		cdd.ColorTicketItem{VendorID: "RGB", Type: cdd.ColorTypeStandardColor},
+		cdd.MediaSizeTicketItem{WidthMicrons: 200000, HeightMicrons: 200000, VendorID: "Square"})
+	retargeted = RetargetTicket(ticket, description)
+	if retargeted.Print.Color != nil {
+		t.Logf("expected color to be dropped, got %+v", retargeted.Print.Color)
+		t.Fail()
+	}
+	if ms := retargeted.Print.MediaSize; ms == nil || ms.VendorID != "" || ms.WidthMicrons != 200000 {
+		t.Logf("expected a custom media size, got %+v", ms)
+		t.Fail()
+	}
+
+	ticket.Print.MediaSize.WidthMicrons = 500000
+	if retargeted = RetargetTicket(ticket, description); retargeted.Print.MediaSize != nil {
+		t.Logf("expected a media size too wide to be dropped, got %+v", retargeted.Print.MediaSize)
+		t.Fail()
+	}
+}
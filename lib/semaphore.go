@@ -8,48 +8,93 @@ https://developers.google.com/open-source/licenses/bsd
 
 package lib
 
+import "sync"
+
+// Semaphore limits concurrency. Its size can change while it is in use.
 type Semaphore struct {
-	ch chan struct{}
+	mutex sync.Mutex
+	cond  *sync.Cond
+	count uint
+	size  uint
+	// waiting is the quantity of callers blocked in Acquire.
+	waiting uint
 }
 
 func NewSemaphore(size uint) *Semaphore {
-	s := make(chan struct{}, size)
-	return &Semaphore{s}
+	s := Semaphore{size: size}
+	s.cond = sync.NewCond(&s.mutex)
+	return &s
 }
 
 // Acquire increments the semaphore, blocking if necessary.
 func (s *Semaphore) Acquire() {
-	s.ch <- struct{}{}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for s.count >= s.size {
+		s.waiting++
+		s.cond.Wait()
+		s.waiting--
+	}
+	s.count++
 }
 
 // TryAcquire increments the semaphore without blocking.
 // Returns false if the semaphore was not acquired.
 func (s *Semaphore) TryAcquire() bool {
-	select {
-	case s.ch <- struct{}{}:
-		return true
-	default:
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.count >= s.size {
 		return false
 	}
+	s.count++
+	return true
 }
 
 // Release decrements the semaphore. If this operation causes
 // the semaphore value to be negative, then panics.
 func (s *Semaphore) Release() {
-	select {
-	case _ = <-s.ch:
-		return
-	default:
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.count == 0 {
 		panic("Semaphore was released without being acquired")
 	}
+	s.count--
+	s.cond.Signal()
 }
 
 // Count returns the current value of the semaphore.
 func (s *Semaphore) Count() uint {
-	return uint(len(s.ch))
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.count
+}
+
+// Waiting returns the quantity of callers blocked in Acquire.
+func (s *Semaphore) Waiting() uint {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.waiting
 }
 
 // Size returns the maximum semaphore value.
 func (s *Semaphore) Size() uint {
-	return uint(cap(s.ch))
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.size
+}
+
+// SetSize changes the maximum semaphore value. When it shrinks below the
+// current value, Acquire blocks until enough holders release.
+func (s *Semaphore) SetSize(size uint) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.size = size
+	s.cond.Broadcast()
 }
@@ -0,0 +1,49 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package lib
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSemaphoreSetSize(t *testing.T) {
+	s := NewSemaphore(2)
+	s.Acquire()
+	s.Acquire()
+	if s.TryAcquire() {
+		t.Fatal("expected a full semaphore to refuse")
+	}
+
+	acquired := make(chan struct{})
+	go func() {
+		s.Acquire()
+		close(acquired)
+	}()
+
+	s.SetSize(3)
+	select {
+	case <-acquired:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected growing the semaphore to wake a waiter")
+	}
+
+	s.SetSize(1)
+	s.Release()
+	if s.TryAcquire() {
+		t.Logf("expected a shrunk semaphore to refuse while %d are held", s.Count())
+		t.Fail()
+	}
+	s.Release()
+	s.Release()
+	if !s.TryAcquire() || s.Size() != 1 {
+		t.Logf("expected to acquire once the holders released, size %d", s.Size())
+		t.Fail()
+	}
+}
@@ -0,0 +1,86 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package lib
+
+import (
+	"fmt"
+	"hash/fnv"
+)
+
+// PrinterShard is the part of a printer system's printers that one
+// connector instance owns, so that several instances can share a very
+// large printer system.
+//
+// A nil PrinterShard owns every printer.
+type PrinterShard struct {
+	count    uint32
+	index    uint32
+	printers map[string]struct{}
+}
+
+// NewPrinterShard creates a PrinterShard that owns the printers named in
+// printers, or, when printers is empty, the printers whose names hash to
+// index out of count shards. Returns nil when there is only one shard.
+func NewPrinterShard(count, index uint, printers []string) (*PrinterShard, error) {
+	if len(printers) > 0 {
+		s := PrinterShard{printers: make(map[string]struct{}, len(printers))}
+		for _, printer := range printers {
+			s.printers[printer] = struct{}{}
+		}
+		return &s, nil
+	}
+
+	if count <= 1 {
+		return nil, nil
+	}
+	if index >= count {
+		return nil, fmt.Errorf("Shard index %d is not less than shard count %d", index, count)
+	}
+	return &PrinterShard{count: uint32(count), index: uint32(index)}, nil
+}
+
+// Owns returns true when the printer named printerName is in this shard.
+func (s *PrinterShard) Owns(printerName string) bool {
+	if s == nil {
+		return true
+	}
+	if s.printers != nil {
+		_, exists := s.printers[printerName]
+		return exists
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(printerName))
+	return h.Sum32()%s.count == s.index
+}
+
+// Filter gets the printers that are in this shard.
+func (s *PrinterShard) Filter(printers []Printer) []Printer {
+	if s == nil {
+		return printers
+	}
+
+	result := make([]Printer, 0, len(printers))
+	for i := range printers {
+		if s.Owns(printers[i].Name) {
+			result = append(result, printers[i])
+		}
+	}
+	return result
+}
+
+func (s *PrinterShard) String() string {
+	if s == nil {
+		return "all printers"
+	}
+	if s.printers != nil {
+		return fmt.Sprintf("%d listed printers", len(s.printers))
+	}
+	return fmt.Sprintf("shard %d of %d", s.index, s.count)
+}
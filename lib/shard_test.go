@@ -0,0 +1,71 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package lib
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestPrinterShardHash(t *testing.T) {
+	if s, err := NewPrinterShard(1, 0, nil); err != nil || s != nil {
+		t.Logf("expected no shard when there is one, got %v %v", s, err)
+		t.Fail()
+	}
+	if _, err := NewPrinterShard(3, 3, nil); err == nil {
+		t.Log("expected an error for a shard index out of range")
+		t.Fail()
+	}
+
+	printers := make([]Printer, 100)
+	for i := range printers {
+		printers[i].Name = fmt.Sprintf("printer-%d", i)
+	}
+
+	owners := make(map[string]int)
+	for index := uint(0); index < 3; index++ {
+		s, err := NewPrinterShard(3, index, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		filtered := s.Filter(printers)
+		if len(filtered) == 0 {
+			t.Logf("expected shard %d to own some of %d printers", index, len(printers))
+			t.Fail()
+		}
+		for _, p := range filtered {
+			owners[p.Name]++
+		}
+	}
+
+	for _, p := range printers {
+		if owners[p.Name] != 1 {
+			t.Logf("expected printer %s to be in one shard, found it in %d", p.Name, owners[p.Name])
+			t.Fail()
+		}
+	}
+}
+
+func TestPrinterShardList(t *testing.T) {
+	s, err := NewPrinterShard(3, 0, []string{"a", "c"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	filtered := s.Filter([]Printer{{Name: "a"}, {Name: "b"}, {Name: "c"}})
+	if len(filtered) != 2 || filtered[0].Name != "a" || filtered[1].Name != "c" {
+		t.Logf("expected printers a and c, got %+v", filtered)
+		t.Fail()
+	}
+
+	var all *PrinterShard
+	if !all.Owns("b") {
+		t.Log("expected no shard to own every printer")
+		t.Fail()
+	}
+}
@@ -0,0 +1,130 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package lib
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+const (
+	// PreviousStateSuffix names the version of a state file that the last
+	// write replaced.
+	PreviousStateSuffix = ".prev"
+	// stateChecksumSuffix names the SHA-256 checksum of a state file, as
+	// written by WriteStateFile.
+	stateChecksumSuffix = ".sha256"
+)
+
+// WriteStateFile replaces filename with data, such that a crash leaves
+// either the old or the new version, never part of one: data is written to
+// a temporary file in the same directory, synced, then renamed over
+// filename. The checksum of data is kept next to filename, and the version
+// that is replaced, when it matches its checksum, is kept as filename.prev.
+func WriteStateFile(filename string, data []byte, perm os.FileMode) error {
+	if current, err := readStateVersion(filename, nil); err == nil {
+		if err = writeFileSynced(filename+PreviousStateSuffix, current, perm); err != nil {
+			return err
+		}
+		if err = writeFileSynced(filename+PreviousStateSuffix+stateChecksumSuffix, checksum(current), perm); err != nil {
+			return err
+		}
+	}
+
+	if err := writeFileSynced(filename, data, perm); err != nil {
+		return err
+	}
+	return writeFileSynced(filename+stateChecksumSuffix, checksum(data), perm)
+}
+
+// ReadStateFile reads filename, or, when filename is missing or corrupt,
+// the previous version of it. A file is intact when it matches its
+// checksum, or, for files that were edited by hand or written without a
+// checksum, when valid accepts it; valid may be nil to accept anything.
+//
+// Returns the name of the file that was read, which ends with
+// PreviousStateSuffix when the previous version was read. Returns an error
+// that satisfies os.IsNotExist when there is no version at all.
+func ReadStateFile(filename string, valid func([]byte) error) ([]byte, string, error) {
+	data, err := readStateVersion(filename, valid)
+	if err == nil {
+		return data, filename, nil
+	}
+
+	previous, prevErr := readStateVersion(filename+PreviousStateSuffix, valid)
+	if prevErr == nil {
+		return previous, filename + PreviousStateSuffix, nil
+	}
+	return nil, "", err
+}
+
+// readStateVersion reads one version of a state file, and checks that it is
+// intact. Without valid, only a file that matches its checksum, or has no
+// checksum, is intact.
+func readStateVersion(filename string, valid func([]byte) error) ([]byte, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	sum, err := ioutil.ReadFile(filename + stateChecksumSuffix)
+	if err == nil && bytes.Equal(bytes.TrimSpace(sum), checksum(data)) {
+		return data, nil
+	}
+	if valid == nil {
+		if err == nil || len(data) == 0 {
+			return nil, fmt.Errorf("State file %s doesn't match its checksum", filename)
+		}
+		// Written before checksums were.
+		return data, nil
+	}
+	if err = valid(data); err != nil {
+		return nil, fmt.Errorf("State file %s is corrupt: %s", filename, err)
+	}
+	return data, nil
+}
+
+// writeFileSynced writes data to a temporary file, syncs it, then renames
+// it to filename.
+func writeFileSynced(filename string, data []byte, perm os.FileMode) error {
+	f, err := ioutil.TempFile(filepath.Dir(filename), filepath.Base(filename)+".tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp, perm)
+	}
+	if err == nil {
+		err = os.Rename(tmp, filename)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+func checksum(data []byte) []byte {
+	sum := sha256.Sum256(data)
+	return []byte(hex.EncodeToString(sum[:]))
+}
@@ -0,0 +1,73 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package lib
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStateFileRecovery(t *testing.T) {
+	dir, err := ioutil.TempDir("", "statefile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "state.json")
+
+	if _, _, err = ReadStateFile(filename, validJSON); !os.IsNotExist(err) {
+		t.Logf("expected a not-exist error for a missing file, got %v", err)
+		t.Fail()
+	}
+
+	if err = WriteStateFile(filename, []byte(`{"version": 1}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = WriteStateFile(filename, []byte(`{"version": 2}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	b, read, err := ReadStateFile(filename, validJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != `{"version": 2}` || read != filename {
+		t.Logf("expected version 2 from %s, got %s from %s", filename, b, read)
+		t.Fail()
+	}
+
+	// Edited by hand: the checksum no longer matches, but it is valid.
+	ioutil.WriteFile(filename, []byte(`{"version": 3}`), 0600)
+	if b, _, err = ReadStateFile(filename, validJSON); err != nil || string(b) != `{"version": 3}` {
+		t.Logf("expected the edited version 3, got %s, %v", b, err)
+		t.Fail()
+	}
+
+	// Cut short by a crash.
+	ioutil.WriteFile(filename, []byte(`{"vers`), 0600)
+	b, read, err = ReadStateFile(filename, validJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != `{"version": 1}` || read != filename+PreviousStateSuffix {
+		t.Logf("expected the previous version 1, got %s from %s", b, read)
+		t.Fail()
+	}
+
+	// The corrupt version doesn't replace the intact previous version.
+	if err = WriteStateFile(filename, []byte(`{"version": 4}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if b, err = ioutil.ReadFile(filename + PreviousStateSuffix); err != nil || string(b) != `{"version": 1}` {
+		t.Logf("expected previous version 1 to be kept, got %s, %v", b, err)
+		t.Fail()
+	}
+}
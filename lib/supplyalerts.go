@@ -0,0 +1,171 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/cloud-print-connector/cdd"
+)
+
+const (
+	// DefaultSupplyCriticalPercent is the level at or below which a marker
+	// is exhausted, unless configured otherwise.
+	DefaultSupplyCriticalPercent = 10
+
+	supplyAlertWebhookTimeout = 10 * time.Second
+)
+
+// SupplyThresholds are the marker levels, in percent, at or below which a
+// supply is low (warning) or exhausted (critical). Zero warning is no
+// warning; zero critical is DefaultSupplyCriticalPercent.
+type SupplyThresholds struct {
+	WarningPercent  int32 `json:"warning_percent,omitempty"`
+	CriticalPercent int32 `json:"critical_percent,omitempty"`
+}
+
+// SupplyAlert is a marker that has crossed a threshold.
+type SupplyAlert struct {
+	Printer      string              `json:"printer"`
+	Marker       string              `json:"marker"`
+	LevelPercent int32               `json:"level_percent"`
+	Severity     cdd.VendorStateType `json:"severity"`
+}
+
+// SupplyAlerts grades printer markers by configurable thresholds, and
+// notices when they get worse.
+type SupplyAlerts struct {
+	defaults   SupplyThresholds
+	printers   map[string]SupplyThresholds
+	webhookURL string
+	client     *http.Client
+
+	severities map[markerKey]cdd.VendorStateType
+	mutex      sync.Mutex
+}
+
+// NewSupplyAlerts creates a SupplyAlerts that applies defaults to every
+// printer, except where printers, by native printer name, says otherwise.
+// Alerts are posted as JSON to webhookURL, unless it is empty.
+func NewSupplyAlerts(defaults SupplyThresholds, printers map[string]SupplyThresholds, webhookURL string) *SupplyAlerts {
+	return &SupplyAlerts{
+		defaults:   defaults,
+		printers:   printers,
+		webhookURL: webhookURL,
+		client:     &http.Client{Timeout: supplyAlertWebhookTimeout},
+		severities: make(map[markerKey]cdd.VendorStateType),
+	}
+}
+
+// Thresholds gets the thresholds of one printer.
+func (a *SupplyAlerts) Thresholds(printerName string) SupplyThresholds {
+	thresholds := a.defaults
+	if p, exists := a.printers[printerName]; exists {
+		if p.WarningPercent != 0 {
+			thresholds.WarningPercent = p.WarningPercent
+		}
+		if p.CriticalPercent != 0 {
+			thresholds.CriticalPercent = p.CriticalPercent
+		}
+	}
+	if thresholds.CriticalPercent == 0 {
+		thresholds.CriticalPercent = DefaultSupplyCriticalPercent
+	}
+	return thresholds
+}
+
+// Apply sets the states of a printer's markers by its thresholds, and
+// describes low markers in its vendor state. Returns the markers that got
+// worse since the last time.
+func (a *SupplyAlerts) Apply(printer *Printer) []SupplyAlert {
+	if printer.State == nil || printer.State.MarkerState == nil {
+		return nil
+	}
+	thresholds := a.Thresholds(printer.Name)
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	var alerts []SupplyAlert
+	for i := range printer.State.MarkerState.Item {
+		item := &printer.State.MarkerState.Item[i]
+		if item.LevelPercent == nil ||
+			(item.State != cdd.MarkerStateOK && item.State != cdd.MarkerStateExhausted) {
+			continue
+		}
+		level := *item.LevelPercent
+
+		var severity cdd.VendorStateType
+		var description string
+		if level <= thresholds.CriticalPercent {
+			item.State = cdd.MarkerStateExhausted
+			severity = cdd.VendorStateError
+			description = fmt.Sprintf("%s is very low: %d%%", item.VendorID, level)
+		} else {
+			item.State = cdd.MarkerStateOK
+			if level <= thresholds.WarningPercent {
+				severity = cdd.VendorStateWarning
+				description = fmt.Sprintf("%s is low: %d%%", item.VendorID, level)
+			}
+		}
+
+		key := markerKey{printer.Name, item.VendorID}
+		if supplySeverityRank(severity) > supplySeverityRank(a.severities[key]) {
+			alerts = append(alerts, SupplyAlert{printer.Name, item.VendorID, level, severity})
+		}
+		a.severities[key] = severity
+
+		if severity == "" {
+			continue
+		}
+		if printer.State.VendorState == nil {
+			printer.State.VendorState = &cdd.VendorState{}
+		}
+		printer.State.VendorState.Item = append(printer.State.VendorState.Item,
+			cdd.VendorStateItem{State: severity, Description: description})
+	}
+
+	return alerts
+}
+
+// Notify posts an alert to the webhook, if there is one.
+func (a *SupplyAlerts) Notify(alert SupplyAlert) error {
+	if a.webhookURL == "" {
+		return nil
+	}
+	b, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	response, err := a.client.Post(a.webhookURL, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("Supply alert webhook responded %s", response.Status)
+	}
+	return nil
+}
+
+func supplySeverityRank(severity cdd.VendorStateType) int {
+	switch severity {
+	case cdd.VendorStateError:
+		return 2
+	case cdd.VendorStateWarning:
+		return 1
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,89 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package lib
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/cloud-print-connector/cdd"
+)
+
+func printerWithMarker(name string, level int32) *Printer {
+	return &Printer{
+		Name:  name,
+		State: &cdd.PrinterStateSection{MarkerState: markerState("black", level)},
+	}
+}
+
+func TestSupplyAlertsApply(t *testing.T) {
+	a := NewSupplyAlerts(SupplyThresholds{WarningPercent: 20},
+		map[string]SupplyThresholds{"strict": {CriticalPercent: 5}}, "")
+
+	if th := a.Thresholds("other"); th.WarningPercent != 20 || th.CriticalPercent != DefaultSupplyCriticalPercent {
+		t.Logf("expected default thresholds, got %+v", th)
+		t.Fail()
+	}
+
+	testCases := []struct {
+		printerName string
+		level       int32
+		markerState cdd.MarkerStateType
+		severity    cdd.VendorStateType
+		alert       bool
+	}{
+		{"p", 50, cdd.MarkerStateOK, "", false},
+		{"p", 20, cdd.MarkerStateOK, cdd.VendorStateWarning, true},
+		{"p", 15, cdd.MarkerStateOK, cdd.VendorStateWarning, false},
+		{"p", 10, cdd.MarkerStateExhausted, cdd.VendorStateError, true},
+		{"p", 100, cdd.MarkerStateOK, "", false},
+		{"strict", 8, cdd.MarkerStateOK, cdd.VendorStateWarning, true},
+		{"strict", 5, cdd.MarkerStateExhausted, cdd.VendorStateError, true},
+	}
+	for _, tc := range testCases {
+		p := printerWithMarker(tc.printerName, tc.level)
+		alerts := a.Apply(p)
+
+		if s := p.State.MarkerState.Item[0].State; s != tc.markerState {
+			t.Logf("%s at %d%%: expected marker state %s, got %s", tc.printerName, tc.level, tc.markerState, s)
+			t.Fail()
+		}
+		var severity cdd.VendorStateType
+		if p.State.VendorState != nil {
+			severity = p.State.VendorState.Item[0].State
+		}
+		if severity != tc.severity {
+			t.Logf("%s at %d%%: expected vendor state %q, got %q", tc.printerName, tc.level, tc.severity, severity)
+			t.Fail()
+		}
+		if (len(alerts) > 0) != tc.alert {
+			t.Logf("%s at %d%%: expected alert %v, got %+v", tc.printerName, tc.level, tc.alert, alerts)
+			t.Fail()
+		}
+	}
+}
+
+func TestSupplyAlertsNotify(t *testing.T) {
+	var received SupplyAlert
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&received)
+	}))
+	defer s.Close()
+
+	alert := SupplyAlert{"p", "black", 5, cdd.VendorStateError}
+	if err := NewSupplyAlerts(SupplyThresholds{}, nil, s.URL).Notify(alert); err != nil {
+		t.Fatal(err)
+	}
+	if received != alert {
+		t.Logf("expected webhook to receive %+v, got %+v", alert, received)
+		t.Fail()
+	}
+}
@@ -0,0 +1,88 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package lib
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// The placeholders of support URL templates.
+const (
+	supportURLName     = "{name}"
+	supportURLHostname = "{hostname}"
+)
+
+// SupportURLs publishes where the users of each printer get help, like the
+// printer's embedded web server or the site's helpdesk page, as the
+// printer's support URL. A nil SupportURLs leaves the support URLs alone.
+type SupportURLs struct {
+	template string
+	printers map[string]string
+}
+
+// NewSupportURLs checks the support URL template of every printer, and
+// those of some printers, by native name, which replace it. In templates,
+// {name} is replaced with the printer's native name, and {hostname} with
+// the host of its device URI. Returns nil when there are no templates.
+func NewSupportURLs(template string, printers map[string]string) (*SupportURLs, error) {
+	if template == "" && len(printers) == 0 {
+		return nil, nil
+	}
+	if err := checkSupportURL(template); err != nil {
+		return nil, err
+	}
+	for name, t := range printers {
+		if err := checkSupportURL(t); err != nil {
+			return nil, fmt.Errorf("Printer %s: %s", name, err)
+		}
+	}
+	return &SupportURLs{template, printers}, nil
+}
+
+// checkSupportURL checks that a template, with its placeholders filled in,
+// is an absolute http or https URL. The empty template is allowed.
+func checkSupportURL(template string) error {
+	if template == "" {
+		return nil
+	}
+	r := strings.NewReplacer(supportURLName, "printer", supportURLHostname, "printer.example.com")
+	u, err := url.Parse(r.Replace(template))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("Support URL %q is not an http or https URL", template)
+	}
+	return nil
+}
+
+// Apply sets the support URL of a printer, from its template. A printer
+// without a template, or whose template needs a hostname that the printer
+// doesn't have, keeps its support URL.
+func (s *SupportURLs) Apply(printer *Printer) {
+	if s == nil {
+		return
+	}
+	template, exists := s.printers[printer.Name]
+	if !exists {
+		template = s.template
+	}
+	if template == "" {
+		return
+	}
+
+	var hostname string
+	if strings.Contains(template, supportURLHostname) {
+		var ok bool
+		if hostname, ok = printer.GetHostname(); !ok {
+			return
+		}
+	}
+	r := strings.NewReplacer(supportURLName, url.PathEscape(printer.Name), supportURLHostname, hostname)
+	printer.SupportURL = r.Replace(template)
+}
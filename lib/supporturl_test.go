@@ -0,0 +1,53 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package lib
+
+import "testing"
+
+func TestSupportURLs(t *testing.T) {
+	if s, err := NewSupportURLs("", nil); s != nil || err != nil {
+		t.Logf("expected no templates to be nil, got %v, %v", s, err)
+		t.Fail()
+	}
+	if _, err := NewSupportURLs("helpdesk", nil); err == nil {
+		t.Log("expected a template that isn't a URL to be rejected")
+		t.Fail()
+	}
+	if _, err := NewSupportURLs("", map[string]string{"a": "ftp://{hostname}/"}); err == nil {
+		t.Log("expected a printer template that isn't http to be rejected")
+		t.Fail()
+	}
+
+	s, err := NewSupportURLs("https://help.example.com/printers/{name}",
+		map[string]string{"lobby": "http://{hostname}/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := Printer{Name: "floor 2", SupportURL: ConnectorHomeURL}
+	s.Apply(&p)
+	if p.SupportURL != "https://help.example.com/printers/floor%202" {
+		t.Logf("expected the template with the printer's name, got %s", p.SupportURL)
+		t.Fail()
+	}
+
+	p = Printer{Name: "lobby", SupportURL: ConnectorHomeURL, Tags: map[string]string{"device-uri": "ipp://lobby.example.com/ipp/print"}}
+	s.Apply(&p)
+	if p.SupportURL != "http://lobby.example.com/" {
+		t.Logf("expected the printer's template with its hostname, got %s", p.SupportURL)
+		t.Fail()
+	}
+
+	p = Printer{Name: "lobby", SupportURL: ConnectorHomeURL, Tags: map[string]string{"device-uri": "usb://Acme/Laser"}}
+	s.Apply(&p)
+	if p.SupportURL != ConnectorHomeURL {
+		t.Logf("expected a printer without a hostname to keep its support URL, got %s", p.SupportURL)
+		t.Fail()
+	}
+}
@@ -0,0 +1,192 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/cloud-print-connector/cdd"
+)
+
+const (
+	// A test page is this size, in points, when its printer has no default
+	// media size.
+	testPageDefaultWidth  = 612
+	testPageDefaultHeight = 792
+
+	// testPageMargin is how far in from the edges of a test page, in points,
+	// its border is drawn.
+	testPageMargin = 36
+	// testPageMaxSizes is how many paper sizes a test page lists.
+	testPageMaxSizes = 8
+)
+
+// testPageSwatches are the CMYK colors that a test page prints a swatch of,
+// to show which inks or toners print.
+var testPageSwatches = []struct {
+	name       string
+	c, m, y, k float64
+}{
+	{"Cyan", 1, 0, 0, 0},
+	{"Magenta", 0, 1, 0, 0},
+	{"Yellow", 0, 0, 1, 0},
+	{"Black", 0, 0, 0, 1},
+	{"Gray", 0, 0, 0, 0.5},
+}
+
+// WriteTestPage writes a test page for a printer, so that an administrator can
+// check that the printer works, and how it's set up, without its front
+// panel. The page has the connector's version, the printer's name and a
+// summary of its capabilities, color swatches, and marks at the margins
+// and center to check alignment.
+func WriteTestPage(printer *Printer, now time.Time) []byte {
+	width, height := testPageDefaultWidth, testPageDefaultHeight
+	if d := printer.Description; d != nil && d.MediaSize != nil {
+		for _, o := range d.MediaSize.Option {
+			if o.IsDefault && o.WidthMicrons > 0 && o.HeightMicrons > 0 {
+				width, height = micronsToPoints(o.WidthMicrons), micronsToPoints(o.HeightMicrons)
+				break
+			}
+		}
+	}
+
+	var content bytes.Buffer
+
+	// Border, center crosshair, and corner marks.
+	m := testPageMargin
+	fmt.Fprintf(&content, "0 0 0 1 K 1 w %d %d %d %d re S\n", m, m, width-2*m, height-2*m)
+	fmt.Fprintf(&content, "%d %d m %d %d l S %d %d m %d %d l S\n",
+		width/2-18, height/2, width/2+18, height/2, width/2, height/2-18, width/2, height/2+18)
+	for _, corner := range [][2]int{{0, 0}, {width, 0}, {0, height}, {width, height}} {
+		x, y := corner[0], corner[1]
+		dx, dy := m/2, m/2
+		if x > 0 {
+			dx = -dx
+		}
+		if y > 0 {
+			dy = -dy
+		}
+		fmt.Fprintf(&content, "%d %d m %d %d l S %d %d m %d %d l S\n",
+			x+dx, y, x+dx, y+dy, x, y+dy, x+dx, y+dy)
+	}
+
+	// Text, from the top left.
+	y := height - m - 36
+	for i, line := range testPageLines(printer, now) {
+		size := 10
+		if i == 0 {
+			size = 20
+		}
+		y -= size + 6
+		content.WriteString(PDFText(m+36, y, size, line))
+	}
+
+	// Color swatches, along the bottom.
+	swatch := (width - 2*m - 72) / len(testPageSwatches)
+	for i, s := range testPageSwatches {
+		x := m + 36 + i*swatch
+		fmt.Fprintf(&content, "%g %g %g %g k %d %d %d %d re f\n", s.c, s.m, s.y, s.k, x, m+48, swatch-12, swatch/2)
+		content.WriteString("0 0 0 1 k " + PDFText(x, m+36, 8, s.name))
+	}
+
+	return WritePDF([]PDFPage{{Width: width, Height: height, Content: content.String()}})
+}
+
+// testPageLines gets the lines of text on the test page of a printer.
+func testPageLines(printer *Printer, now time.Time) []string {
+	lines := []string{
+		"Cloud Print Connector test page",
+		"",
+		"Printer: " + printer.Name,
+	}
+	if printer.DefaultDisplayName != "" {
+		lines = append(lines, "Display name: "+printer.DefaultDisplayName)
+	}
+	if printer.Manufacturer != "" || printer.Model != "" {
+		lines = append(lines, "Make and model: "+strings.TrimSpace(printer.Manufacturer+" "+printer.Model))
+	}
+	lines = append(lines,
+		"Connector: "+ShortName,
+		"Printed: "+now.Format("2006-01-02 15:04:05 MST"),
+		"")
+
+	d := printer.Description
+	if d == nil {
+		return append(lines, "Capabilities: unknown")
+	}
+
+	if d.MediaSize != nil && len(d.MediaSize.Option) > 0 {
+		var sizes []string
+		for i, o := range d.MediaSize.Option {
+			if i == testPageMaxSizes {
+				sizes = append(sizes, fmt.Sprintf("and %d more", len(d.MediaSize.Option)-i))
+				break
+			}
+			size := o.CustomDisplayName
+			if size == "" {
+				size = string(o.Name)
+			}
+			if o.IsDefault {
+				size += " (default)"
+			}
+			sizes = append(sizes, size)
+		}
+		lines = append(lines, "Paper sizes: "+strings.Join(sizes, ", "))
+	}
+
+	color := "no"
+	if d.Color != nil {
+		for _, o := range d.Color.Option {
+			if o.Type == cdd.ColorTypeStandardColor || o.Type == cdd.ColorTypeCustomColor {
+				color = "yes"
+				break
+			}
+		}
+	}
+	lines = append(lines, "Color: "+color)
+
+	duplex := "no"
+	if d.Duplex != nil {
+		for _, o := range d.Duplex.Option {
+			if o.Type != cdd.DuplexNoDuplex {
+				duplex = "yes"
+				break
+			}
+		}
+	}
+	lines = append(lines, "Duplex: "+duplex)
+
+	if d.DPI != nil && len(d.DPI.Option) > 0 {
+		var resolutions []string
+		for _, o := range d.DPI.Option {
+			resolutions = append(resolutions, fmt.Sprintf("%dx%d", o.HorizontalDPI, o.VerticalDPI))
+		}
+		lines = append(lines, "Resolutions: "+strings.Join(resolutions, ", "))
+	}
+	if d.Copies != nil {
+		lines = append(lines, fmt.Sprintf("Copies: up to %d", d.Copies.Max))
+	}
+	if d.SupportedContentType != nil && len(*d.SupportedContentType) > 0 {
+		var formats []string
+		for _, t := range *d.SupportedContentType {
+			formats = append(formats, t.ContentType)
+		}
+		lines = append(lines, "Formats: "+strings.Join(formats, ", "))
+	}
+
+	return lines
+}
+
+// micronsToPoints converts microns to the nearest point.
+func micronsToPoints(microns int32) int {
+	return int(float64(microns)*72/25400 + 0.5)
+}
@@ -0,0 +1,67 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package lib
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/cloud-print-connector/cdd"
+)
+
+func TestWriteTestPage(t *testing.T) {
+	printer := &Printer{
+		Name:         "lobby",
+		Manufacturer: "Acme",
+		Model:        "LaserWriter",
+		Description: &cdd.PrinterDescriptionSection{
+			MediaSize: &cdd.MediaSize{Option: []cdd.MediaSizeOption{
+				{Name: cdd.MediaSizeNALetter, WidthMicrons: 215900, HeightMicrons: 279400},
+				{Name: cdd.MediaSizeISOA4, WidthMicrons: 210000, HeightMicrons: 297000, IsDefault: true},
+			}},
+			Color: &cdd.Color{Option: []cdd.ColorOption{
+				{Type: cdd.ColorTypeStandardMonochrome, IsDefault: true},
+				{Type: cdd.ColorTypeStandardColor},
+			}},
+			Duplex: &cdd.Duplex{Option: []cdd.DuplexOption{{Type: cdd.DuplexNoDuplex, IsDefault: true}}},
+			DPI:    &cdd.DPI{Option: []cdd.DPIOption{{HorizontalDPI: 600, VerticalDPI: 600, IsDefault: true}}},
+			Copies: &cdd.Copies{Default: 1, Max: 99},
+		},
+	}
+	now := time.Date(2016, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	expected := []string{
+		"Cloud Print Connector test page",
+		"",
+		"Printer: lobby",
+		"Make and model: Acme LaserWriter",
+		"Connector: " + ShortName,
+		"Printed: 2016-01-02 03:04:05 UTC",
+		"",
+		"Paper sizes: NA_LETTER, ISO_A4 (default)",
+		"Color: yes",
+		"Duplex: no",
+		"Resolutions: 600x600",
+		"Copies: up to 99",
+	}
+	if lines := testPageLines(printer, now); !reflect.DeepEqual(lines, expected) {
+		t.Logf("expected %q, got %q", expected, lines)
+		t.Fail()
+	}
+
+	pdf := WriteTestPage(printer, now)
+	for _, s := range []string{"/MediaBox [0 0 595 842]", "(Printer: lobby) Tj", "(Magenta) Tj"} {
+		if !bytes.Contains(pdf, []byte(s)) {
+			t.Logf("expected %q in test page", s)
+			t.Fail()
+		}
+	}
+}
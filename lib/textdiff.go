@@ -0,0 +1,150 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// diffContextLines is how many unchanged lines DiffText shows around each
+// change.
+const diffContextLines = 3
+
+// diffLine is one line of a diff: ' ' unchanged, '-' removed or '+' added.
+type diffLine struct {
+	op   byte
+	text string
+}
+
+// DiffText compares two texts by line, like diff -u. Returns the empty
+// string when they are the same.
+func DiffText(from, to, fromName, toName string) string {
+	if from == to {
+		return ""
+	}
+	a := strings.Split(strings.TrimSuffix(from, "\n"), "\n")
+	b := strings.Split(strings.TrimSuffix(to, "\n"), "\n")
+	lines := diffLines(a, b)
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", fromName, toName)
+	// aLine and bLine are the line numbers, from 1, of lines[i].
+	for i, aLine, bLine := 0, 1, 1; i < len(lines); {
+		if lines[i].op == ' ' {
+			i, aLine, bLine = i+1, aLine+1, bLine+1
+			continue
+		}
+
+		// A hunk starts a few lines before this change, and ends a few
+		// lines after the last change that is close enough to join it.
+		start := i - diffContextLines
+		if start < 0 {
+			start = 0
+		}
+		end := i
+		for unchanged := 0; end < len(lines) && unchanged <= 2*diffContextLines; end++ {
+			if lines[end].op == ' ' {
+				unchanged++
+			} else {
+				unchanged = 0
+			}
+		}
+		for end > i && lines[end-1].op == ' ' {
+			end--
+		}
+		if end += diffContextLines; end > len(lines) {
+			end = len(lines)
+		}
+
+		hunkA, hunkB := aLine-(i-start), bLine-(i-start)
+		var aCount, bCount int
+		var hunk bytes.Buffer
+		for _, l := range lines[start:end] {
+			if l.op != '+' {
+				aCount++
+			}
+			if l.op != '-' {
+				bCount++
+			}
+			hunk.WriteByte(l.op)
+			hunk.WriteString(l.text)
+			hunk.WriteByte('\n')
+		}
+		fmt.Fprintf(&out, "@@ -%d,%d +%d,%d @@\n", hunkA, aCount, hunkB, bCount)
+		out.Write(hunk.Bytes())
+
+		for _, l := range lines[i:end] {
+			if l.op != '+' {
+				aLine++
+			}
+			if l.op != '-' {
+				bLine++
+			}
+		}
+		i = end
+	}
+	return out.String()
+}
+
+// diffLines finds the fewest lines to remove from a and add to b, by their
+// longest common subsequence.
+func diffLines(a, b []string) []diffLine {
+	// Common lines at either end don't need the table.
+	var prefix, suffix int
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	ma, mb := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+
+	// lcs[i][j] is the length of the longest common subsequence of ma[i:]
+	// and mb[j:].
+	lcs := make([][]int32, len(ma)+1)
+	for i := range lcs {
+		lcs[i] = make([]int32, len(mb)+1)
+	}
+	for i := len(ma) - 1; i >= 0; i-- {
+		for j := len(mb) - 1; j >= 0; j-- {
+			if ma[i] == mb[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	lines := make([]diffLine, 0, len(a)+len(b)-prefix-suffix)
+	for _, text := range a[:prefix] {
+		lines = append(lines, diffLine{' ', text})
+	}
+	i, j := 0, 0
+	for i < len(ma) || j < len(mb) {
+		switch {
+		case i < len(ma) && j < len(mb) && ma[i] == mb[j]:
+			lines = append(lines, diffLine{' ', ma[i]})
+			i, j = i+1, j+1
+		case j == len(mb) || (i < len(ma) && lcs[i+1][j] >= lcs[i][j+1]):
+			lines = append(lines, diffLine{'-', ma[i]})
+			i++
+		default:
+			lines = append(lines, diffLine{'+', mb[j]})
+			j++
+		}
+	}
+	for _, text := range a[len(a)-suffix:] {
+		lines = append(lines, diffLine{' ', text})
+	}
+	return lines
+}
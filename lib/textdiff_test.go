@@ -0,0 +1,62 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package lib
+
+import "testing"
+
+func TestDiffText(t *testing.T) {
+	if d := DiffText("a\nb\n", "a\nb\n", "from", "to"); d != "" {
+		t.Logf("expected no diff of the same texts, got\n%s", d)
+		t.Fail()
+	}
+
+	from := "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n13\n14\n15\n"
+	to := "1\n2\n3\nfour\n5\n6\n7\n8\n9\n10\n11\n12\n13\n14\n15\n16\n"
+	expected := `--- from
++++ to
+@@ -1,7 +1,7 @@
+ 1
+ 2
+ 3
+-4
++four
+ 5
+ 6
+ 7
+@@ -13,3 +13,4 @@
+ 13
+ 14
+ 15
++16
+`
+	if d := DiffText(from, to, "from", "to"); d != expected {
+		t.Logf("expected\n%s\ngot\n%s", expected, d)
+		t.Fail()
+	}
+
+	// Changes close together share a hunk.
+	from = "a\nb\nc\nd\ne\nf\n"
+	to = "a\nB\nc\nd\ne\nF\n"
+	expected = `--- from
++++ to
+@@ -1,6 +1,6 @@
+ a
+-b
++B
+ c
+ d
+ e
+-f
++F
+`
+	if d := DiffText(from, to, "from", "to"); d != expected {
+		t.Logf("expected\n%s\ngot\n%s", expected, d)
+		t.Fail()
+	}
+}
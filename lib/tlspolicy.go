@@ -0,0 +1,272 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package lib
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// TLSProfileFIPS limits TLS to the versions, cipher suites and curves that
+// FIPS 140 approves. That is TLS 1.2 only, because Go's TLS 1.3 cipher
+// suites, which include ChaCha20-Poly1305, can't be limited. It doesn't
+// make Go's crypto a validated module.
+const TLSProfileFIPS = "fips"
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+var tlsCurves = map[string]tls.CurveID{
+	"P256":   tls.CurveP256,
+	"P384":   tls.CurveP384,
+	"P521":   tls.CurveP521,
+	"X25519": tls.X25519,
+}
+
+// The TLS 1.2 cipher suites and curves of TLSProfileFIPS, in order of
+// preference, and its versions.
+var (
+	fipsCipherSuites = []uint16{
+		tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+		tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	}
+	fipsCurves     = []tls.CurveID{tls.CurveP256, tls.CurveP384, tls.CurveP521}
+	fipsMinVersion = uint16(tls.VersionTLS12)
+	fipsMaxVersion = uint16(tls.VersionTLS12)
+)
+
+var tlsPolicy struct {
+	mutex        sync.RWMutex
+	profile      string
+	minVersion   uint16
+	maxVersion   uint16
+	cipherSuites []uint16
+	curves       []tls.CurveID
+}
+
+// SetTLSPolicy limits the TLS of every Go client and server of the
+// connector: the lowest and highest versions, like 1.2, the TLS 1.2 cipher
+// suites, by their Go names, and the curves, like P256. Empty values are
+// Go's defaults, or, with TLSProfileFIPS, TLS 1.2 and those that FIPS 140
+// approves; that profile refuses the others. Call it before any TLS
+// connections are made.
+//
+// The policy doesn't reach TLS that isn't Go's: the connection to the CUPS
+// server, which libcups encrypts as cupsEncryption says, and LDAP group
+// lookups, which run ldapsearch. Those follow the CUPS client.conf and the
+// OpenLDAP ldap.conf.
+func SetTLSPolicy(profile, minVersion, maxVersion string, cipherSuites, curves []string) error {
+	if profile != "" && profile != TLSProfileFIPS {
+		return fmt.Errorf("TLS profile %q is not %s", profile, TLSProfileFIPS)
+	}
+	fips := profile == TLSProfileFIPS
+
+	min, err := parseTLSVersion(minVersion)
+	if err != nil {
+		return err
+	}
+	max, err := parseTLSVersion(maxVersion)
+	if err != nil {
+		return err
+	}
+	if fips {
+		if min == 0 {
+			min = fipsMinVersion
+		} else if min < fipsMinVersion {
+			return fmt.Errorf("TLS %s is not allowed by the %s profile", minVersion, profile)
+		}
+		if max == 0 {
+			max = fipsMaxVersion
+		} else if max > fipsMaxVersion {
+			return fmt.Errorf("TLS %s is not allowed by the %s profile, because its cipher suites can't be limited", maxVersion, profile)
+		}
+	}
+	if min != 0 && max != 0 && min > max {
+		return fmt.Errorf("The lowest TLS version %s is above the highest, %s", minVersion, maxVersion)
+	}
+
+	suites, err := parseCipherSuites(cipherSuites, fips)
+	if err != nil {
+		return err
+	}
+	if fips && len(suites) == 0 {
+		suites = fipsCipherSuites
+	}
+	ids, err := parseCurves(curves, fips)
+	if err != nil {
+		return err
+	}
+	if fips && len(ids) == 0 {
+		ids = fipsCurves
+	}
+
+	tlsPolicy.mutex.Lock()
+	tlsPolicy.profile = profile
+	tlsPolicy.minVersion, tlsPolicy.maxVersion = min, max
+	tlsPolicy.cipherSuites, tlsPolicy.curves = suites, ids
+	tlsPolicy.mutex.Unlock()
+
+	// The default transport is used by webhooks, update checks, OAuth token
+	// refreshes and XMPP.
+	if t, ok := http.DefaultTransport.(*http.Transport); ok {
+		if profile == "" && min == 0 && max == 0 && len(suites) == 0 && len(ids) == 0 {
+			t.TLSClientConfig = nil
+		} else {
+			t.TLSClientConfig = TLSConfig()
+		}
+	}
+	return nil
+}
+
+func parseTLSVersion(version string) (uint16, error) {
+	if version == "" {
+		return 0, nil
+	}
+	v, exists := tlsVersions[version]
+	if !exists {
+		return 0, fmt.Errorf("TLS version %q is not 1.0, 1.1, 1.2 or 1.3", version)
+	}
+	return v, nil
+}
+
+func parseCipherSuites(names []string, fips bool) ([]uint16, error) {
+	ids := make(map[string]uint16)
+	for _, s := range tls.CipherSuites() {
+		ids[s.Name] = s.ID
+	}
+	insecure := make(map[string]bool)
+	for _, s := range tls.InsecureCipherSuites() {
+		insecure[s.Name] = true
+	}
+
+	var suites []uint16
+	for _, name := range names {
+		id, exists := ids[name]
+		switch {
+		case insecure[name]:
+			return nil, fmt.Errorf("TLS cipher suite %s is insecure", name)
+		case !exists:
+			return nil, fmt.Errorf("TLS cipher suite %s is unknown", name)
+		case fips && !containsUint16(fipsCipherSuites, id):
+			return nil, fmt.Errorf("TLS cipher suite %s is not allowed by the %s profile", name, TLSProfileFIPS)
+		}
+		suites = append(suites, id)
+	}
+	return suites, nil
+}
+
+func parseCurves(names []string, fips bool) ([]tls.CurveID, error) {
+	var curves []tls.CurveID
+	for _, name := range names {
+		id, exists := tlsCurves[name]
+		if !exists {
+			return nil, fmt.Errorf("TLS curve %q is not P256, P384, P521 or X25519", name)
+		}
+		if fips && !containsCurve(fipsCurves, id) {
+			return nil, fmt.Errorf("TLS curve %s is not allowed by the %s profile", name, TLSProfileFIPS)
+		}
+		curves = append(curves, id)
+	}
+	return curves, nil
+}
+
+func containsUint16(values []uint16, v uint16) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+func containsCurve(curves []tls.CurveID, c tls.CurveID) bool {
+	for _, curve := range curves {
+		if curve == c {
+			return true
+		}
+	}
+	return false
+}
+
+// TLSConfig creates a TLS config, for a client or a server, limited by the
+// policy set by SetTLSPolicy.
+func TLSConfig() *tls.Config {
+	tlsPolicy.mutex.RLock()
+	defer tlsPolicy.mutex.RUnlock()
+
+	return &tls.Config{
+		MinVersion:       tlsPolicy.minVersion,
+		MaxVersion:       tlsPolicy.maxVersion,
+		CipherSuites:     append([]uint16(nil), tlsPolicy.cipherSuites...),
+		CurvePreferences: append([]tls.CurveID(nil), tlsPolicy.curves...),
+	}
+}
+
+// TLSPolicyReport describes the TLS policy set by SetTLSPolicy, for the
+// log at startup.
+func TLSPolicyReport() string {
+	tlsPolicy.mutex.RLock()
+	defer tlsPolicy.mutex.RUnlock()
+
+	profile := tlsPolicy.profile
+	if profile == "" {
+		profile = "default"
+	}
+	versions := tlsVersionName(tlsPolicy.minVersion, "Go's lowest") + " to " + tlsVersionName(tlsPolicy.maxVersion, "Go's highest")
+
+	suites := "Go's defaults"
+	if len(tlsPolicy.cipherSuites) > 0 {
+		names := make([]string, len(tlsPolicy.cipherSuites))
+		for i, id := range tlsPolicy.cipherSuites {
+			names[i] = tls.CipherSuiteName(id)
+		}
+		suites = strings.Join(names, ", ")
+	}
+
+	curves := "Go's defaults"
+	if len(tlsPolicy.curves) > 0 {
+		names := make([]string, len(tlsPolicy.curves))
+		for i, id := range tlsPolicy.curves {
+			for name, curve := range tlsCurves {
+				if curve == id {
+					names[i] = name
+				}
+			}
+		}
+		curves = strings.Join(names, ", ")
+	}
+
+	report := fmt.Sprintf("TLS policy %s: versions %s; TLS 1.2 cipher suites %s; curves %s", profile, versions, suites, curves)
+	if tlsPolicy.maxVersion == 0 || tlsPolicy.maxVersion >= tls.VersionTLS13 {
+		report += "; TLS 1.3 cipher suites aren't configurable, and include TLS_CHACHA20_POLY1305_SHA256"
+	}
+	if runtime.GOOS != "windows" {
+		report += "; the policy doesn't apply to the CUPS server connection (libcups) or to LDAP group lookups (ldapsearch)"
+	}
+	return report
+}
+
+func tlsVersionName(version uint16, unset string) string {
+	for name, v := range tlsVersions {
+		if v == version {
+			return name
+		}
+	}
+	return unset
+}
@@ -0,0 +1,70 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package lib
+
+import (
+	"crypto/tls"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSetTLSPolicy(t *testing.T) {
+	defer SetTLSPolicy("", "", "", nil, nil)
+
+	for _, p := range []struct {
+		profile, min, max string
+		suites, curves    []string
+	}{
+		{profile: "nsa"},
+		{min: "1.4"},
+		{min: "1.3", max: "1.2"},
+		{suites: []string{"TLS_RSA_WITH_RC4_128_SHA"}},
+		{suites: []string{"TLS_NOT_A_SUITE"}},
+		{curves: []string{"P224"}},
+		{profile: TLSProfileFIPS, min: "1.1"},
+		{profile: TLSProfileFIPS, max: "1.3"},
+		{profile: TLSProfileFIPS, min: "1.3"},
+		{profile: TLSProfileFIPS, suites: []string{"TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256"}},
+		{profile: TLSProfileFIPS, curves: []string{"X25519"}},
+	} {
+		if err := SetTLSPolicy(p.profile, p.min, p.max, p.suites, p.curves); err == nil {
+			t.Logf("expected policy %+v to be refused", p)
+			t.Fail()
+		}
+	}
+
+	if err := SetTLSPolicy(TLSProfileFIPS, "", "", nil, []string{"P384"}); err != nil {
+		t.Fatal(err)
+	}
+	c := TLSConfig()
+	if c.MinVersion != tls.VersionTLS12 || c.MaxVersion != tls.VersionTLS12 ||
+		!reflect.DeepEqual(c.CipherSuites, fipsCipherSuites) || !reflect.DeepEqual(c.CurvePreferences, []tls.CurveID{tls.CurveP384}) {
+		t.Logf("expected the FIPS defaults, TLS 1.2 only, with the configured curves, got %+v", c)
+		t.Fail()
+	}
+	if http.DefaultTransport.(*http.Transport).TLSClientConfig.MinVersion != tls.VersionTLS12 {
+		t.Log("expected the default transport to have the policy")
+		t.Fail()
+	}
+	report := TLSPolicyReport()
+	if !strings.Contains(report, "fips") || !strings.Contains(report, "1.2 to 1.2") || !strings.Contains(report, "P384") || strings.Contains(report, "TLS 1.3") {
+		t.Logf("expected the report to describe the policy, got %s", report)
+		t.Fail()
+	}
+
+	if err := SetTLSPolicy("", "", "", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if c := TLSConfig(); c.MinVersion != 0 || c.CipherSuites != nil || http.DefaultTransport.(*http.Transport).TLSClientConfig != nil {
+		t.Log("expected the default policy to leave Go's defaults")
+		t.Fail()
+	}
+}
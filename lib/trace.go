@@ -0,0 +1,240 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+)
+
+const (
+	// TraceProtocolHTTP and TraceProtocolIPP are the protocols of trace
+	// records.
+	TraceProtocolHTTP = "http"
+	TraceProtocolIPP  = "ipp"
+
+	// MaxTraceBody is how much of each request or response body is traced.
+	MaxTraceBody = 64 << 10
+
+	traceRedacted = "REDACTED"
+)
+
+var (
+	// rTraceSecretForm matches the values of secrets in form-encoded bodies
+	// and URL queries.
+	rTraceSecretForm = regexp.MustCompile(`((?:^|[?&])(?:access_token|refresh_token|client_secret|code|password|xsrf)=)[^&\s]*`)
+	// rTraceSecretJSON matches the values of secrets in JSON bodies.
+	rTraceSecretJSON = regexp.MustCompile(`("(?:access_token|refresh_token|client_secret|xmpp_jid|password|xsrf_token)"\s*:\s*)"(?:[^"\\]|\\.)*"`)
+	// rTraceUserinfo matches the user and password of URIs, like those of
+	// direct printers.
+	rTraceUserinfo = regexp.MustCompile(`(://)[^/@\s]+@`)
+)
+
+// TraceRecord is one exchange with a printer or with GCP. Request and
+// Response are redacted text dumps.
+type TraceRecord struct {
+	Time     time.Time `json:"time"`
+	Protocol string    `json:"protocol"`
+	Printer  string    `json:"printer,omitempty"`
+	JobID    string    `json:"job_id,omitempty"`
+	Request  string    `json:"request"`
+	Response string    `json:"response,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// traceHeader is the first line of a trace bundle; it says which binary
+// recorded the bundle, and what was traced.
+type traceHeader struct {
+	Started   time.Time         `json:"started"`
+	Printer   string            `json:"printer,omitempty"`
+	JobID     string            `json:"job_id,omitempty"`
+	BuildDate string            `json:"build_date"`
+	BuildInfo map[string]string `json:"build_info"`
+}
+
+// traceSession writes the records of one printer, one job, or everything,
+// to a bundle file.
+type traceSession struct {
+	filename string
+	printer  string
+	jobID    string
+	// jobPrinter is the printer of the traced job, once a record of the
+	// job says; its exchanges that aren't tied to a job are traced too.
+	jobPrinter string
+	f          *os.File
+	encoder    *json.Encoder
+	records    uint
+}
+
+var trace struct {
+	mutex   sync.Mutex
+	session *traceSession
+}
+
+// StartTrace starts writing the exchanges of printer, of the job jobID, or
+// of everything when both are empty, to a new bundle file, one JSON object
+// per line.
+func StartTrace(filename, printer, jobID string) error {
+	trace.mutex.Lock()
+	defer trace.mutex.Unlock()
+
+	if trace.session != nil {
+		return fmt.Errorf("Already tracing to %s", trace.session.filename)
+	}
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	s := traceSession{
+		filename: filename,
+		printer:  printer,
+		jobID:    jobID,
+		f:        f,
+		encoder:  json.NewEncoder(f),
+	}
+	header := traceHeader{time.Now(), printer, jobID, BuildDate, BuildInfoTags()}
+	if err = s.encoder.Encode(&header); err != nil {
+		f.Close()
+		os.Remove(filename)
+		return err
+	}
+	trace.session = &s
+	return nil
+}
+
+// StopTrace stops tracing, and closes the bundle file. Returns the name of
+// the file and the quantity of records written to it.
+func StopTrace() (string, uint, error) {
+	trace.mutex.Lock()
+	defer trace.mutex.Unlock()
+
+	s := trace.session
+	if s == nil {
+		return "", 0, errors.New("Not tracing")
+	}
+	trace.session = nil
+	return s.filename, s.records, s.f.Close()
+}
+
+// TraceStatus describes the trace in progress, or says there is none.
+func TraceStatus() string {
+	trace.mutex.Lock()
+	defer trace.mutex.Unlock()
+
+	s := trace.session
+	if s == nil {
+		return "not tracing"
+	}
+	scope := "everything"
+	switch {
+	case s.printer != "" && s.jobID != "":
+		scope = fmt.Sprintf("job %s of printer %s", s.jobID, s.printer)
+	case s.jobID != "":
+		scope = "job " + s.jobID
+	case s.printer != "":
+		scope = "printer " + s.printer
+	}
+	return fmt.Sprintf("tracing %s to %s, %d records", scope, s.filename, s.records)
+}
+
+// Tracing tells whether the exchanges of printer and jobID, either of
+// which may be empty, are being traced. Callers check it before they take
+// the trouble to dump an exchange.
+func Tracing(printer, jobID string) bool {
+	trace.mutex.Lock()
+	defer trace.mutex.Unlock()
+
+	return trace.session != nil && trace.session.matches(printer, jobID)
+}
+
+func (s *traceSession) matches(printer, jobID string) bool {
+	if s.printer != "" && printer != s.printer {
+		return false
+	}
+	if s.jobID == "" || jobID == s.jobID {
+		return true
+	}
+	return jobID == "" && printer != "" && printer == s.jobPrinter
+}
+
+// Trace writes one exchange to the bundle file, when it is being traced.
+func Trace(record TraceRecord) {
+	trace.mutex.Lock()
+	defer trace.mutex.Unlock()
+
+	s := trace.session
+	if s == nil || !s.matches(record.Printer, record.JobID) {
+		return
+	}
+	if s.jobID != "" && record.JobID == s.jobID && record.Printer != "" {
+		s.jobPrinter = record.Printer
+	}
+	if record.Time.IsZero() {
+		record.Time = time.Now()
+	}
+	record.Request = RedactTrace(record.Request)
+	record.Response = RedactTrace(record.Response)
+	record.Error = RedactTrace(record.Error)
+
+	if err := s.encoder.Encode(&record); err != nil {
+		// Nothing else would be written; don't keep trying.
+		s.f.Close()
+		trace.session = nil
+		return
+	}
+	s.records++
+}
+
+// RedactTrace replaces tokens, secrets and passwords in a dump with
+// REDACTED.
+func RedactTrace(dump string) string {
+	dump = rTraceSecretForm.ReplaceAllString(dump, "${1}"+traceRedacted)
+	dump = rTraceSecretJSON.ReplaceAllString(dump, `${1}"`+traceRedacted+`"`)
+	return rTraceUserinfo.ReplaceAllString(dump, "${1}"+traceRedacted+"@")
+}
+
+// TruncateTrace formats a body for a dump, cut to MaxTraceBody bytes.
+func TruncateTrace(body []byte) string {
+	if len(body) <= MaxTraceBody {
+		return string(body)
+	}
+	return fmt.Sprintf("%s\n... (truncated)", body[:MaxTraceBody])
+}
+
+type traceScopeKey struct{}
+
+type traceScope struct {
+	printer, jobID string
+}
+
+// WithTraceScope gets a context whose exchanges are traced as those of
+// printer and jobID. Empty values are inherited from ctx.
+func WithTraceScope(ctx context.Context, printer, jobID string) context.Context {
+	parent, _ := ctx.Value(traceScopeKey{}).(traceScope)
+	if printer == "" {
+		printer = parent.printer
+	}
+	if jobID == "" {
+		jobID = parent.jobID
+	}
+	return context.WithValue(ctx, traceScopeKey{}, traceScope{printer, jobID})
+}
+
+// TraceScope gets the printer and job whose exchanges ctx is for; either
+// may be empty.
+func TraceScope(ctx context.Context) (string, string) {
+	scope, _ := ctx.Value(traceScopeKey{}).(traceScope)
+	return scope.printer, scope.jobID
+}
@@ -0,0 +1,217 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package lib
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// UpdateAvailableTag publishes a newer connector version, when there is
+	// one.
+	UpdateAvailableTag = "connector-update-available"
+
+	// DefaultUpdateCheckInterval is how often the release feed is checked,
+	// unless configured otherwise.
+	DefaultUpdateCheckInterval = 24 * time.Hour
+
+	updateFeedTimeout     = 30 * time.Second
+	updateDownloadTimeout = 10 * time.Minute
+)
+
+// rVersionNumber matches the numbers of a version, like 2016, 01 and 02 of
+// 2016.01.02.
+var rVersionNumber = regexp.MustCompile(`\d+`)
+
+// Release is the newest release in a release feed, like
+// {"version": "2016.01.02", "downloads": {"linux-amd64": {"url": "https://...", "sha256": "..."}}}.
+// Downloads are by GOOS-GOARCH.
+type Release struct {
+	Version   string                     `json:"version"`
+	Downloads map[string]ReleaseDownload `json:"downloads,omitempty"`
+}
+
+// ReleaseDownload is the binary of a release for one platform.
+type ReleaseDownload struct {
+	URL    string `json:"url"`
+	SHA256 string `json:"sha256"`
+}
+
+// UpdateChecker checks a release feed for versions newer than BuildDate,
+// and optionally downloads them to a staging file for the admin to
+// install. A nil UpdateChecker checks nothing.
+type UpdateChecker struct {
+	feedURL         string
+	interval        time.Duration
+	stagingFilename string
+	client          *http.Client
+
+	mutex     sync.Mutex
+	available string
+	staged    string
+}
+
+// NewUpdateChecker creates an UpdateChecker of feedURL, or returns nil when
+// feedURL is empty. Newer binaries are downloaded to stagingFilename, unless
+// it is empty.
+func NewUpdateChecker(feedURL string, interval time.Duration, stagingFilename string) *UpdateChecker {
+	if feedURL == "" {
+		return nil
+	}
+	if interval <= 0 {
+		interval = DefaultUpdateCheckInterval
+	}
+	return &UpdateChecker{
+		feedURL:         feedURL,
+		interval:        interval,
+		stagingFilename: stagingFilename,
+		client:          &http.Client{Transport: &UserAgentTransport{}, Timeout: updateFeedTimeout},
+	}
+}
+
+// Interval is how often to call Check.
+func (u *UpdateChecker) Interval() time.Duration {
+	return u.interval
+}
+
+// Check gets the newest release from the feed. When it is newer than the
+// running version, it becomes available, and its binary for this platform
+// is downloaded to the staging file, once. Returns the newer release, or
+// nil when the running version is current.
+func (u *UpdateChecker) Check() (*Release, error) {
+	if u == nil {
+		return nil, nil
+	}
+
+	response, err := u.client.Get(u.feedURL)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Release feed %s answered %s", u.feedURL, response.Status)
+	}
+	var release Release
+	if err = json.NewDecoder(response.Body).Decode(&release); err != nil {
+		return nil, fmt.Errorf("Failed to parse release feed %s: %s", u.feedURL, err)
+	}
+
+	if !NewerVersion(release.Version, BuildDate) {
+		u.mutex.Lock()
+		u.available = ""
+		u.mutex.Unlock()
+		return nil, nil
+	}
+
+	u.mutex.Lock()
+	u.available = release.Version
+	staged := u.staged
+	u.mutex.Unlock()
+
+	if u.stagingFilename == "" || staged == release.Version {
+		return &release, nil
+	}
+	download, exists := release.Downloads[runtime.GOOS+"-"+runtime.GOARCH]
+	if !exists {
+		return &release, fmt.Errorf("Release %s has no download for %s-%s", release.Version, runtime.GOOS, runtime.GOARCH)
+	}
+	if err = u.stage(download); err != nil {
+		return &release, fmt.Errorf("Failed to download release %s: %s", release.Version, err)
+	}
+
+	u.mutex.Lock()
+	u.staged = release.Version
+	u.mutex.Unlock()
+	return &release, nil
+}
+
+// stage downloads a binary to the staging file, which is replaced only
+// when the download is complete and matches its checksum.
+func (u *UpdateChecker) stage(download ReleaseDownload) error {
+	client := http.Client{Transport: &UserAgentTransport{}, Timeout: updateDownloadTimeout}
+	response, err := client.Get(download.URL)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s answered %s", download.URL, response.Status)
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(u.stagingFilename), filepath.Base(u.stagingFilename)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(f, h), response.Body)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	if sum := hex.EncodeToString(h.Sum(nil)); !strings.EqualFold(sum, download.SHA256) {
+		return fmt.Errorf("checksum is %s, not %s", sum, download.SHA256)
+	}
+	if err = os.Chmod(f.Name(), 0755); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), u.stagingFilename)
+}
+
+// Available gets the newer version that the last check found, and the
+// version that is in the staging file; either may be empty.
+func (u *UpdateChecker) Available() (string, string) {
+	if u == nil {
+		return "", ""
+	}
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	return u.available, u.staged
+}
+
+// NewerVersion tells whether version is newer than current, by comparing
+// their numbers in order, like 2016.01.02 > 2015.12.30. Development builds,
+// whose versions have no numbers, are never older.
+func NewerVersion(version, current string) bool {
+	v := rVersionNumber.FindAllString(version, -1)
+	c := rVersionNumber.FindAllString(current, -1)
+	if len(v) == 0 || len(c) == 0 {
+		return false
+	}
+	for i := 0; i < len(v) && i < len(c); i++ {
+		vn, _ := strconv.ParseUint(v[i], 10, 64)
+		cn, _ := strconv.ParseUint(c[i], 10, 64)
+		if vn != cn {
+			return vn > cn
+		}
+	}
+	return len(v) > len(c)
+}
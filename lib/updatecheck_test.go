@@ -0,0 +1,92 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package lib
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestNewerVersion(t *testing.T) {
+	testCases := []struct {
+		version, current string
+		newer            bool
+	}{
+		{"2016.01.02", "2015.12.30", true},
+		{"2015.12.30", "2016.01.02", false},
+		{"2016.01.02", "2016.01.02", false},
+		{"2016.01.10", "2016.01.9", true},
+		{"2016.01.02.1", "2016.01.02", true},
+		{"2016.01.02", "DEV", false},
+	}
+	for _, tc := range testCases {
+		if newer := NewerVersion(tc.version, tc.current); newer != tc.newer {
+			t.Logf("expected %s newer than %s to be %v", tc.version, tc.current, tc.newer)
+			t.Fail()
+		}
+	}
+}
+
+func TestUpdateCheckerStages(t *testing.T) {
+	binary := []byte("new connector")
+	sum := sha256.Sum256(binary)
+
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/binary" {
+			w.Write(binary)
+			return
+		}
+		fmt.Fprintf(w, `{"version": "9999.01.01", "downloads": {"%s-%s": {"url": "%s/binary", "sha256": "%s"}}}`,
+			runtime.GOOS, runtime.GOARCH, server.URL, hex.EncodeToString(sum[:]))
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "updatecheck")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	staging := filepath.Join(dir, "connector.new")
+
+	buildDate := BuildDate
+	BuildDate = "2016.01.02"
+	defer func() { BuildDate = buildDate }()
+
+	u := NewUpdateChecker(server.URL+"/feed", 0, staging)
+	release, err := u.Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if release == nil || release.Version != "9999.01.01" {
+		t.Fatalf("expected release 9999.01.01, got %+v", release)
+	}
+	if available, staged := u.Available(); available != "9999.01.01" || staged != "9999.01.01" {
+		t.Logf("expected 9999.01.01 available and staged, got %q and %q", available, staged)
+		t.Fail()
+	}
+	if b, err := ioutil.ReadFile(staging); err != nil || string(b) != string(binary) {
+		t.Logf("expected the staged binary, got %q, %v", b, err)
+		t.Fail()
+	}
+
+	var nilChecker *UpdateChecker
+	if available, _ := nilChecker.Available(); available != "" {
+		t.Log("expected nothing from a nil UpdateChecker")
+		t.Fail()
+	}
+}
@@ -0,0 +1,114 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package lib
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Tags that publish a printer's usage since the connector started.
+const (
+	UsageTagSince     = "usage-since"
+	UsageTagJobs      = "usage-jobs"
+	UsageTagFailures  = "usage-failures"
+	UsageTagPages     = "usage-pages"
+	UsageTagMeanJobMS = "usage-mean-job-ms"
+)
+
+type printerUsage struct {
+	jobs     uint64
+	failures uint64
+	pages    uint64
+	duration time.Duration
+	// printedPages and printedDuration are the pages and durations of the
+	// successful jobs whose pages were counted, to measure speed by.
+	printedPages    uint64
+	printedDuration time.Duration
+}
+
+// UsageStats counts the jobs, failures and pages of each printer, so that
+// fleet owners can see how much each printer is used.
+type UsageStats struct {
+	since    time.Time
+	printers map[string]*printerUsage
+	mutex    sync.Mutex
+}
+
+// NewUsageStats creates a UsageStats that counts from now.
+func NewUsageStats() *UsageStats {
+	return &UsageStats{
+		since:    time.Now(),
+		printers: make(map[string]*printerUsage),
+	}
+}
+
+// Record counts one job, which took duration from receipt to completion.
+func (u *UsageStats) Record(printerName string, success bool, pages int32, duration time.Duration) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	p, exists := u.printers[printerName]
+	if !exists {
+		p = &printerUsage{}
+		u.printers[printerName] = p
+	}
+
+	p.jobs++
+	if !success {
+		p.failures++
+	}
+	if pages > 0 {
+		p.pages += uint64(pages)
+	}
+	p.duration += duration
+	if success && pages > 0 {
+		p.printedPages += uint64(pages)
+		p.printedDuration += duration
+	}
+}
+
+// PagesPerMinute gets how fast a printer has printed the pages of its
+// successful jobs, from receipt to completion; zero when it hasn't printed
+// any counted pages yet.
+func (u *UsageStats) PagesPerMinute(printerName string) float64 {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	p, exists := u.printers[printerName]
+	if !exists || p.printedPages == 0 || p.printedDuration <= 0 {
+		return 0
+	}
+	return float64(p.printedPages) / p.printedDuration.Minutes()
+}
+
+// Tags gets the usage of one printer, as printer tags.
+func (u *UsageStats) Tags(printerName string) map[string]string {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	var p printerUsage
+	if pu, exists := u.printers[printerName]; exists {
+		p = *pu
+	}
+
+	var mean time.Duration
+	if p.jobs > 0 {
+		mean = p.duration / time.Duration(p.jobs)
+	}
+
+	return map[string]string{
+		UsageTagSince:     u.since.UTC().Format(time.RFC3339),
+		UsageTagJobs:      strconv.FormatUint(p.jobs, 10),
+		UsageTagFailures:  strconv.FormatUint(p.failures, 10),
+		UsageTagPages:     strconv.FormatUint(p.pages, 10),
+		UsageTagMeanJobMS: strconv.FormatInt(int64(mean/time.Millisecond), 10),
+	}
+}
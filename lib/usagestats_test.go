@@ -0,0 +1,55 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package lib
+
+import (
+	"testing"
+	"time"
+)
+
+func TestUsageStats(t *testing.T) {
+	u := NewUsageStats()
+	u.Record("p", true, 3, 2*time.Second)
+	u.Record("p", false, 0, 4*time.Second)
+	u.Record("other", true, 1, time.Second)
+
+	expected := map[string]string{
+		UsageTagJobs:      "2",
+		UsageTagFailures:  "1",
+		UsageTagPages:     "3",
+		UsageTagMeanJobMS: "3000",
+	}
+	tags := u.Tags("p")
+	for k, v := range expected {
+		if tags[k] != v {
+			t.Logf("expected %s=%s, got %s", k, v, tags[k])
+			t.Fail()
+		}
+	}
+	if _, err := time.Parse(time.RFC3339, tags[UsageTagSince]); err != nil {
+		t.Logf("expected %s to be a time, got %s", UsageTagSince, err)
+		t.Fail()
+	}
+
+	u.Record("p", true, 0, time.Hour)
+	if ppm := u.PagesPerMinute("p"); ppm != 90 {
+		t.Logf("expected 3 pages in 2 seconds to be 90 pages per minute, got %f", ppm)
+		t.Fail()
+	}
+	if ppm := u.PagesPerMinute("idle"); ppm != 0 {
+		t.Logf("expected an idle printer to have no speed, got %f", ppm)
+		t.Fail()
+	}
+
+	tags = u.Tags("idle")
+	if tags[UsageTagJobs] != "0" || tags[UsageTagMeanJobMS] != "0" {
+		t.Logf("expected no usage of an idle printer, got %v", tags)
+		t.Fail()
+	}
+}
@@ -0,0 +1,85 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package lib
+
+import (
+	"fmt"
+	"net/http"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// userAgentProduct is the product of the User-Agent of outbound requests.
+const userAgentProduct = "Google-Cloud-Print-Connector"
+
+var userAgent struct {
+	mutex sync.Mutex
+	site  string
+}
+
+// SetUserAgentSite records the identifier of this deployment, like a site
+// name, to add to the User-Agent of outbound requests; empty adds none.
+func SetUserAgentSite(site string) {
+	userAgent.mutex.Lock()
+	defer userAgent.mutex.Unlock()
+
+	userAgent.site = site
+}
+
+// UserAgent identifies this connector to GCP, XMPP and printers, like
+// Google-Cloud-Print-Connector/2017.01.01 (linux amd64; CUPS; commit abc1234; site hq-3rd-floor).
+func UserAgent() string {
+	userAgent.mutex.Lock()
+	site := userAgent.site
+	userAgent.mutex.Unlock()
+
+	ua := fmt.Sprintf("%s/%s (%s %s; %s; commit %s", userAgentProduct, userAgentToken(BuildDate),
+		runtime.GOOS, runtime.GOARCH, platformName, userAgentToken(BuildCommit))
+	if site != "" {
+		ua += "; site " + userAgentToken(site)
+	}
+	return ua + ")"
+}
+
+// userAgentToken replaces the characters of s that would end a User-Agent
+// comment, or its header, with '_'.
+func userAgentToken(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r < ' ' || r > '~' || strings.ContainsRune("();\\", r) {
+			return '_'
+		}
+		return r
+	}, s)
+}
+
+// UserAgentTransport sets the User-Agent of requests without one to
+// UserAgent(). A nil Base is http.DefaultTransport.
+type UserAgentTransport struct {
+	Base http.RoundTripper
+}
+
+func (t *UserAgentTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if request.Header.Get("User-Agent") != "" {
+		return base.RoundTrip(request)
+	}
+
+	// A RoundTripper must not change the request.
+	r := *request
+	r.Header = make(http.Header, len(request.Header)+1)
+	for name, values := range request.Header {
+		r.Header[name] = values
+	}
+	r.Header.Set("User-Agent", UserAgent())
+	return base.RoundTrip(&r)
+}
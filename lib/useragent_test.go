@@ -0,0 +1,66 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package lib
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestUserAgent(t *testing.T) {
+	SetUserAgentSite("hq (3rd floor); b\n")
+	defer SetUserAgentSite("")
+
+	ua := UserAgent()
+	if !strings.HasPrefix(ua, userAgentProduct+"/") || !strings.HasSuffix(ua, "; site hq _3rd floor__ b_)") {
+		t.Logf("expected a User-Agent with the site sanitized, got %q", ua)
+		t.Fail()
+	}
+	if strings.Count(ua, "(") != 1 || strings.Count(ua, ")") != 1 {
+		t.Logf("expected one comment in the User-Agent, got %q", ua)
+		t.Fail()
+	}
+}
+
+type headerRecorder struct {
+	header http.Header
+}
+
+func (r *headerRecorder) RoundTrip(request *http.Request) (*http.Response, error) {
+	r.header = request.Header
+	return &http.Response{StatusCode: http.StatusOK, Request: request}, nil
+}
+
+func TestUserAgentTransport(t *testing.T) {
+	recorder := &headerRecorder{}
+	transport := &UserAgentTransport{Base: recorder}
+
+	request, _ := http.NewRequest("GET", "http://printer/", nil)
+	if _, err := transport.RoundTrip(request); err != nil {
+		t.Fatal(err)
+	}
+	if recorder.header.Get("User-Agent") != UserAgent() {
+		t.Logf("expected User-Agent %q, got %q", UserAgent(), recorder.header.Get("User-Agent"))
+		t.Fail()
+	}
+	if request.Header.Get("User-Agent") != "" {
+		t.Log("expected the request to be left as it was")
+		t.Fail()
+	}
+
+	request.Header.Set("User-Agent", "custom")
+	if _, err := transport.RoundTrip(request); err != nil {
+		t.Fatal(err)
+	}
+	if recorder.header.Get("User-Agent") != "custom" {
+		t.Logf("expected the request's own User-Agent, got %q", recorder.header.Get("User-Agent"))
+		t.Fail()
+	}
+}
@@ -0,0 +1,75 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package lib
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/google/cloud-print-connector/cdd"
+)
+
+var vendorStateSeverity = map[cdd.VendorStateType]int{
+	cdd.VendorStateError:   0,
+	cdd.VendorStateWarning: 1,
+	cdd.VendorStateInfo:    2,
+}
+
+// vendorStateDescription gets the text of a vendor state item.
+func vendorStateDescription(item *cdd.VendorStateItem) string {
+	if item.DescriptionLocalized != nil && len(*item.DescriptionLocalized) > 0 {
+		return (*item.DescriptionLocalized)[0].Value
+	}
+	return item.Description
+}
+
+// CompactVendorState removes repeated items from a vendor state, and sorts
+// the rest by severity, errors first. When maxItems is not zero, keeps the
+// first maxItems items, and summarizes the others in one more item.
+func CompactVendorState(vs *cdd.VendorState, maxItems uint) {
+	if vs == nil || len(vs.Item) == 0 {
+		return
+	}
+
+	type key struct {
+		state       cdd.VendorStateType
+		description string
+	}
+	seen := make(map[key]struct{}, len(vs.Item))
+	items := make([]cdd.VendorStateItem, 0, len(vs.Item))
+	for _, item := range vs.Item {
+		k := key{item.State, vendorStateDescription(&item)}
+		if _, exists := seen[k]; exists {
+			continue
+		}
+		seen[k] = struct{}{}
+		items = append(items, item)
+	}
+
+	sort.Stable(bySeverity(items))
+
+	if maxItems > 0 && uint(len(items)) > maxItems {
+		more := items[maxItems:]
+		items = append(items[:maxItems:maxItems], cdd.VendorStateItem{
+			// The most severe of the others.
+			State:                more[0].State,
+			DescriptionLocalized: cdd.NewLocalizedString(fmt.Sprintf("and %d more", len(more))),
+		})
+	}
+
+	vs.Item = items
+}
+
+type bySeverity []cdd.VendorStateItem
+
+func (b bySeverity) Len() int      { return len(b) }
+func (b bySeverity) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b bySeverity) Less(i, j int) bool {
+	return vendorStateSeverity[b[i].State] < vendorStateSeverity[b[j].State]
+}
@@ -0,0 +1,56 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package lib
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/google/cloud-print-connector/cdd"
+)
+
+func vendorStateItem(state cdd.VendorStateType, description string) cdd.VendorStateItem {
+	return cdd.VendorStateItem{State: state, DescriptionLocalized: cdd.NewLocalizedString(description)}
+}
+
+func TestCompactVendorState(t *testing.T) {
+	vs := &cdd.VendorState{Item: []cdd.VendorStateItem{
+		vendorStateItem(cdd.VendorStateInfo, "toner-low-report"),
+		vendorStateItem(cdd.VendorStateWarning, "media-low-warning"),
+		vendorStateItem(cdd.VendorStateInfo, "toner-low-report"),
+		vendorStateItem(cdd.VendorStateError, "door-open-error"),
+		vendorStateItem(cdd.VendorStateWarning, "media-low-warning"),
+		vendorStateItem(cdd.VendorStateInfo, "sleep-report"),
+	}}
+
+	CompactVendorState(vs, 0)
+	expected := []cdd.VendorStateItem{
+		vendorStateItem(cdd.VendorStateError, "door-open-error"),
+		vendorStateItem(cdd.VendorStateWarning, "media-low-warning"),
+		vendorStateItem(cdd.VendorStateInfo, "toner-low-report"),
+		vendorStateItem(cdd.VendorStateInfo, "sleep-report"),
+	}
+	if !reflect.DeepEqual(vs.Item, expected) {
+		t.Logf("expected unique items by severity %+v, got %+v", expected, vs.Item)
+		t.Fail()
+	}
+
+	CompactVendorState(vs, 2)
+	expected = []cdd.VendorStateItem{
+		vendorStateItem(cdd.VendorStateError, "door-open-error"),
+		vendorStateItem(cdd.VendorStateWarning, "media-low-warning"),
+		vendorStateItem(cdd.VendorStateInfo, "and 2 more"),
+	}
+	if !reflect.DeepEqual(vs.Item, expected) {
+		t.Logf("expected 2 items and a summary %+v, got %+v", expected, vs.Item)
+		t.Fail()
+	}
+
+	CompactVendorState(nil, 2)
+}
@@ -38,6 +38,10 @@ var (
 	}
 )
 
+func (l LogLevel) String() string {
+	return stringByLevel[l]
+}
+
 func LevelFromString(level string) (LogLevel, bool) {
 	v, ok := levelByString[strings.ToUpper(level)]
 	if !ok {
@@ -15,6 +15,7 @@ import (
 	"os"
 	"runtime"
 	"strconv"
+	"sync/atomic"
 	"time"
 
 	"github.com/coreos/go-systemd/journal"
@@ -41,8 +42,9 @@ var (
 	}
 
 	logger struct {
-		writer         io.Writer
-		level          LogLevel
+		writer io.Writer
+		// level is a LogLevel, accessed atomically.
+		level          uint32
 		journalEnabled bool
 	}
 )
@@ -66,7 +68,7 @@ func (l LogLevel) priority() journal.Priority {
 
 func init() {
 	logger.writer = os.Stderr
-	logger.level = INFO
+	logger.level = uint32(INFO)
 }
 
 // SetWriter sets the io.Writer to log to. Default is os.Stderr.
@@ -76,7 +78,12 @@ func SetWriter(w io.Writer) {
 
 // SetLevel sets the minimum severity level to log. Default is INFO.
 func SetLevel(l LogLevel) {
-	logger.level = l
+	atomic.StoreUint32(&logger.level, uint32(l))
+}
+
+// GetLevel gets the minimum severity level to log.
+func GetLevel() LogLevel {
+	return LogLevel(atomic.LoadUint32(&logger.level))
 }
 
 // SetJournalEnabled enables or disables writing to the systemd journal. Default is false.
@@ -85,7 +92,7 @@ func SetJournalEnabled(b bool) {
 }
 
 func log(level LogLevel, printerID, jobID, format string, args ...interface{}) {
-	if level > logger.level {
+	if level > GetLevel() {
 		return
 	}
 
@@ -11,6 +11,7 @@ package log
 
 import (
 	"fmt"
+	"sync/atomic"
 
 	"github.com/google/cloud-print-connector/lib"
 	"golang.org/x/sys/windows/svc/debug"
@@ -25,17 +26,23 @@ const (
 )
 
 var logger struct {
-	level LogLevel
+	// level is a LogLevel, accessed atomically.
+	level uint32
 	elog  debug.Log
 }
 
 func init() {
-	logger.level = INFO
+	logger.level = uint32(INFO)
 }
 
 // SetLevel sets the minimum severity level to log. Default is INFO.
 func SetLevel(l LogLevel) {
-	logger.level = l
+	atomic.StoreUint32(&logger.level, uint32(l))
+}
+
+// GetLevel gets the minimum severity level to log.
+func GetLevel() LogLevel {
+	return LogLevel(atomic.LoadUint32(&logger.level))
 }
 
 func Start(logToConsole bool) error {
@@ -65,7 +72,7 @@ func log(level LogLevel, printerID, jobID, format string, args ...interface{}) {
 		panic("Attempted to log without first calling Start()")
 	}
 
-	if level > logger.level {
+	if level > GetLevel() {
 		return
 	}
 
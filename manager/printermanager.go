@@ -9,12 +9,20 @@ https://developers.google.com/open-source/licenses/bsd
 package manager
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"hash/adler32"
+	"io/ioutil"
 	"os"
 	"reflect"
+	"runtime/debug"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/cloud-print-connector/cdd"
@@ -30,11 +38,31 @@ type NativePrintSystem interface {
 	GetJobState(printerName string, jobID uint32) (*cdd.PrintJobStateDiff, error)
 	Print(printer *lib.Printer, fileName, title, user, gcpJobID string, ticket *cdd.CloudJobTicket) (uint32, error)
 	ReleaseJob(printerName string, jobID uint32) error
+	CancelJob(printerName string, jobID uint32) error
 	RemoveCachedPPD(printerName string)
 }
 
+// InstanceCoordinator decides which of the connector instances that share a
+// native print system owns the printers.
+type InstanceCoordinator interface {
+	// Renew claims or keeps the printers for this instance, if it may.
+	// Returns true when this instance owns the printers.
+	Renew(now time.Time) (bool, error)
+	// IsHolder returns true when this instance owns the printers.
+	IsHolder() bool
+	// Holder gets the ID of the instance that owns the printers.
+	Holder() string
+	// Release gives up the printers, for another instance to take.
+	Release() error
+}
+
 // Manages state and interactions between the native print system and Google Cloud Print.
 type PrinterManager struct {
+	// printerPollInterval is the time.Duration between printer syncs; it
+	// is accessed atomically, since it can be tuned at runtime. First, to
+	// be 64-bit aligned on 32-bit platforms.
+	printerPollInterval int64
+
 	native NativePrintSystem
 	gcp    *gcp.GoogleCloudPrint
 	xmpp   *xmpp.XMPP
@@ -47,30 +75,94 @@ type PrinterManager struct {
 	jobsDone      uint
 	jobsError     uint
 
+	// Timings of printer syncs and native job submissions, for monitoring.
+	syncDurations   lib.DurationStats
+	submitDurations lib.DurationStats
+
 	// Jobs in flight are jobs that have been received, and are not
-	// finished printing yet. Key is Job ID.
+	// finished printing yet. Key is Job ID; value cancels the job.
 	jobsInFlightMutex sync.Mutex
-	jobsInFlight      map[string]struct{}
+	jobsInFlight      map[string]context.CancelFunc
+	// jobETAs are the estimated completion times of the jobs in flight
+	// that have them.
+	jobETAs map[string]lib.JobETA
+
+	// Job fetches are coalesced per printer. Key is GCP ID; value is true
+	// when another fetch was requested while the current one is running.
+	jobFetchMutex     sync.Mutex
+	jobFetches        map[string]bool
+	jobFetchSemaphore *lib.Semaphore
+
+	// pollIntervalChanged wakes the sync loop when the interval changes.
+	pollIntervalChanged chan struct{}
 
 	nativeJobQueueSize uint
 	jobFullUsername    bool
 	shareScope         string
 
-	quit chan struct{}
+	// duplicates rejects repeated jobs; nil when they are allowed.
+	duplicates *lib.DuplicateJobDetector
+	// jobCache keeps the files of printed jobs to reprint them; nil keeps
+	// none.
+	jobCache *lib.JobCache
+	// reprints numbers reprinted jobs, to give each a job ID.
+	reprints uint32
+	// testPages numbers test pages, to give each a job ID.
+	testPages uint32
+
+	// usage counts the jobs of each printer, published as printer tags.
+	usage *lib.UsageStats
+	// supplyAlerts grades marker levels, and reports supplies that run low.
+	supplyAlerts *lib.SupplyAlerts
+	// markers remembers marker levels, to estimate when supplies run out.
+	markers *lib.MarkerTrends
+	// quarantine stops printers that fail job after job; nil never
+	// quarantines.
+	quarantine *lib.PrinterQuarantine
+	// jobErrors tells users why their jobs failed, and keeps the details.
+	jobErrors *lib.JobErrorLog
+	// supportURLs sets where the users of each printer get help; nil
+	// leaves the native print system's support URLs.
+	supportURLs *lib.SupportURLs
+
+	// instanceID is published in printer tags, to show which connector
+	// instance owns the printers.
+	instanceID string
+	// coordinator decides which connector instance owns the printers; nil
+	// when this instance is the only one.
+	coordinator InstanceCoordinator
+	// shard is the printers that this instance manages; nil is all of them.
+	shard *lib.PrinterShard
+	// vendorStateMaxItems caps the vendor state items of each printer; zero
+	// is no cap.
+	vendorStateMaxItems uint
+
+	// updates checks for newer connector versions; nil never checks.
+	updates *lib.UpdateChecker
+
+	// ctx is done when the manager quits, which stops its goroutines and
+	// cancels the requests and jobs in progress.
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
-func NewPrinterManager(native NativePrintSystem, gcp *gcp.GoogleCloudPrint, privet *privet.Privet, printerPollInterval time.Duration, nativeJobQueueSize uint, jobFullUsername bool, shareScope string, jobs <-chan *lib.Job, xmppNotifications <-chan xmpp.PrinterNotification) (*PrinterManager, error) {
+func NewPrinterManager(native NativePrintSystem, gcp *gcp.GoogleCloudPrint, privet *privet.Privet, printerPollInterval time.Duration, nativeJobQueueSize uint, jobFullUsername bool, shareScope string, maxConcurrentFetches uint, jobs <-chan *lib.Job, xmppNotifications <-chan xmpp.PrinterNotification, duplicates *lib.DuplicateJobDetector, jobCache *lib.JobCache, supplyAlerts *lib.SupplyAlerts, quarantine *lib.PrinterQuarantine, jobErrors *lib.JobErrorLog, supportURLs *lib.SupportURLs, instanceID string, coordinator InstanceCoordinator, shard *lib.PrinterShard, vendorStateMaxItems uint, updates *lib.UpdateChecker) (*PrinterManager, error) {
 	var printers *lib.ConcurrentPrinterMap
 	var queuedJobsCount map[string]uint
 
+	ctx, cancel := context.WithCancel(context.Background())
+
 	var err error
 	if gcp != nil {
 		// Get all GCP printers.
 		var gcpPrinters []lib.Printer
-		gcpPrinters, queuedJobsCount, err = gcp.ListPrinters()
+		gcpPrinters, queuedJobsCount, err = gcp.ListPrinters(ctx)
 		if err != nil {
+			cancel()
 			return nil, err
 		}
+		// Printers of other shards belong to other connector instances.
+		gcpPrinters = shard.Filter(gcpPrinters)
 		// Organize the GCP printers into a map.
 		for i := range gcpPrinters {
 			gcpPrinters[i].NativeJobSemaphore = lib.NewSemaphore(nativeJobQueueSize)
@@ -93,19 +185,56 @@ func NewPrinterManager(native NativePrintSystem, gcp *gcp.GoogleCloudPrint, priv
 		jobsError:     0,
 
 		jobsInFlightMutex: sync.Mutex{},
-		jobsInFlight:      make(map[string]struct{}),
+		jobsInFlight:      make(map[string]context.CancelFunc),
+		jobETAs:           make(map[string]lib.JobETA),
+
+		jobFetches:        make(map[string]bool),
+		jobFetchSemaphore: lib.NewSemaphore(maxConcurrentFetches),
+
+		printerPollInterval: int64(printerPollInterval),
+		pollIntervalChanged: make(chan struct{}, 1),
 
 		nativeJobQueueSize: nativeJobQueueSize,
 		jobFullUsername:    jobFullUsername,
 		shareScope:         shareScope,
 
-		quit: make(chan struct{}),
+		duplicates: duplicates,
+		jobCache:   jobCache,
+		usage:      lib.NewUsageStats(),
+		markers:    lib.NewMarkerTrends(),
+
+		supplyAlerts: supplyAlerts,
+		quarantine:   quarantine,
+		jobErrors:    jobErrors,
+		supportURLs:  supportURLs,
+
+		instanceID: instanceID,
+		coordinator: coordinator,
+		shard:       shard,
+
+		vendorStateMaxItems: vendorStateMaxItems,
+		updates:             updates,
+
+		ctx:    ctx,
+		cancel: cancel,
+	}
+
+	if coordinator != nil {
+		if active, err := coordinator.Renew(time.Now()); err != nil {
+			log.Warningf("Failed to coordinate with other connector instances: %s", err)
+		} else if active {
+			log.Infof("Connector instance %s owns the printers", instanceID)
+		} else {
+			log.Infof("Connector instance %s stands by while %s owns the printers", instanceID, coordinator.Holder())
+		}
+		pm.renewOwnershipPeriodically()
 	}
 
 	// Sync once before returning, to make sure things are working.
 	// Ignore privet updates this first time because Privet always starts
 	// with zero printers.
 	if err = pm.syncPrinters(true); err != nil {
+		cancel()
 		return nil, err
 	}
 
@@ -121,13 +250,13 @@ func NewPrinterManager(native NativePrintSystem, gcp *gcp.GoogleCloudPrint, priv
 		}
 	}
 
-	pm.syncPrintersPeriodically(printerPollInterval)
+	pm.syncPrintersPeriodically()
+	pm.checkUpdatesPeriodically()
 	pm.listenNotifications(jobs, xmppNotifications)
 
 	if gcp != nil {
 		for gcpPrinterID := range queuedJobsCount {
-			p, _ := printers.GetByGCPID(gcpPrinterID)
-			go gcp.HandleJobs(&p, func() { pm.incrementJobsProcessed(false) })
+			pm.requestJobFetch(gcpPrinterID)
 		}
 	}
 
@@ -135,12 +264,96 @@ func NewPrinterManager(native NativePrintSystem, gcp *gcp.GoogleCloudPrint, priv
 }
 
 func (pm *PrinterManager) Quit() {
-	close(pm.quit)
+	pm.cancel()
+	pm.jobCache.Clear()
+	if pm.coordinator != nil {
+		if err := pm.coordinator.Release(); err != nil {
+			log.Warningf("Failed to give up the printers to other connector instances: %s", err)
+		}
+	}
+}
+
+// IsActive returns true when this connector instance owns the printers.
+func (pm *PrinterManager) IsActive() bool {
+	return pm.coordinator == nil || pm.coordinator.IsHolder()
 }
 
-func (pm *PrinterManager) syncPrintersPeriodically(interval time.Duration) {
-	go func() {
-		t := time.NewTimer(interval)
+// renewOwnership claims or keeps the printers, and catches up on jobs when
+// this instance takes them over.
+func (pm *PrinterManager) renewOwnership() {
+	wasActive := pm.coordinator.IsHolder()
+	active, err := pm.coordinator.Renew(time.Now())
+	if err != nil {
+		log.Warningf("Failed to coordinate with other connector instances: %s", err)
+	}
+
+	switch {
+	case active && !wasActive:
+		log.Infof("Connector instance %s owns the printers", pm.instanceID)
+		lib.Go(lib.SubsystemManager, pm.reconcileJobs)
+	case !active && wasActive:
+		log.Warningf("Connector instance %s no longer owns the printers; %s does", pm.instanceID, pm.coordinator.Holder())
+	}
+}
+
+func (pm *PrinterManager) renewOwnershipPeriodically() {
+	lib.Go(lib.SubsystemManager, func() {
+		t := time.NewTicker(lib.InstanceLeaseDuration / 3)
+		defer t.Stop()
+
+		for {
+			select {
+			case <-t.C:
+				pm.renewOwnership()
+			case <-pm.ctx.Done():
+				return
+			}
+		}
+	})
+}
+
+func (pm *PrinterManager) checkUpdatesPeriodically() {
+	if pm.updates == nil {
+		return
+	}
+
+	lib.Go(lib.SubsystemManager, func() {
+		t := time.NewTimer(0)
+		defer t.Stop()
+
+		for {
+			select {
+			case <-t.C:
+				release, err := pm.updates.Check()
+				if err != nil {
+					log.Warningf("Failed to check for a newer connector version: %s", err)
+				}
+				if release != nil {
+					available, staged := pm.updates.Available()
+					if staged == available {
+						log.Infof("Connector version %s is available, and ready to install", available)
+					} else {
+						log.Infof("Connector version %s is available", available)
+					}
+				}
+				t.Reset(pm.updates.Interval())
+
+			case <-pm.ctx.Done():
+				return
+			}
+		}
+	})
+}
+
+// AvailableUpdate gets the newer connector version, if any, that the last
+// update check found, and the version that is ready to install.
+func (pm *PrinterManager) AvailableUpdate() (string, string) {
+	return pm.updates.Available()
+}
+
+func (pm *PrinterManager) syncPrintersPeriodically() {
+	lib.Go(lib.SubsystemManager, func() {
+		t := time.NewTimer(pm.PrinterPollInterval())
 		defer t.Stop()
 
 		for {
@@ -149,37 +362,108 @@ func (pm *PrinterManager) syncPrintersPeriodically(interval time.Duration) {
 				if err := pm.syncPrinters(false); err != nil {
 					log.Error(err)
 				}
-				t.Reset(interval)
+				t.Reset(pm.PrinterPollInterval())
 
-			case <-pm.quit:
+			case <-pm.pollIntervalChanged:
+				// Wait the new interval from now, rather than the rest of
+				// the old one.
+				if !t.Stop() {
+					<-t.C
+				}
+				t.Reset(pm.PrinterPollInterval())
+
+			case <-pm.ctx.Done():
 				return
 			}
 		}
-	}()
+	})
+}
+
+// PrinterPollInterval gets the time between printer syncs.
+func (pm *PrinterManager) PrinterPollInterval() time.Duration {
+	return time.Duration(atomic.LoadInt64(&pm.printerPollInterval))
+}
+
+// SetPrinterPollInterval changes the time between printer syncs. The next
+// sync is interval from now.
+func (pm *PrinterManager) SetPrinterPollInterval(interval time.Duration) {
+	atomic.StoreInt64(&pm.printerPollInterval, int64(interval))
+	select {
+	case pm.pollIntervalChanged <- struct{}{}:
+	default:
+	}
+}
+
+// MaxConcurrentFetches gets the most job fetches that run at once.
+func (pm *PrinterManager) MaxConcurrentFetches() uint {
+	return pm.jobFetchSemaphore.Size()
+}
+
+// SetMaxConcurrentFetches changes the most job fetches that run at once.
+// Fetches in progress finish.
+func (pm *PrinterManager) SetMaxConcurrentFetches(max uint) {
+	pm.jobFetchSemaphore.SetSize(max)
 }
 
 func (pm *PrinterManager) syncPrinters(ignorePrivet bool) error {
+	if !pm.IsActive() {
+		log.Infof("Not synchronizing printers, which connector instance %s owns", pm.coordinator.Holder())
+		return nil
+	}
+
 	log.Info("Synchronizing printers, stand by")
+	defer pm.syncDurations.Since(time.Now())
 
 	// Get current snapshot of native printers.
 	nativePrinters, err := pm.native.GetPrinters()
 	if err != nil {
 		return fmt.Errorf("Sync failed while calling GetPrinters(): %s", err)
 	}
+	nativePrinters = pm.shard.Filter(nativePrinters)
 
 	// Set CapsHash on all printers.
+	th := lib.NewTagsHasher()
+	var released []string
 	for i := range nativePrinters {
-		h := adler32.New()
-		lib.DeepHash(nativePrinters[i].Tags, h)
-		nativePrinters[i].Tags["tagshash"] = fmt.Sprintf("%x", h.Sum(nil))
+		if pm.duplicates != nil {
+			addAllowDuplicateCapability(&nativePrinters[i])
+		}
+		for k, v := range pm.usage.Tags(nativePrinters[i].Name) {
+			nativePrinters[i].Tags[k] = v
+		}
+		nativePrinters[i].Tags[lib.InstanceIDTag] = pm.instanceID
+		if available, _ := pm.updates.Available(); available != "" {
+			nativePrinters[i].Tags[lib.UpdateAvailableTag] = available
+		}
+		pm.supportURLs.Apply(&nativePrinters[i])
+		if pm.quarantine.Apply(&nativePrinters[i], time.Now()) {
+			released = append(released, nativePrinters[i].Name)
+		}
+		for _, alert := range pm.supplyAlerts.Apply(&nativePrinters[i]) {
+			alert := alert
+			lib.Go(lib.SubsystemManager, func() { pm.notifySupplyAlert(alert) })
+		}
+		if state := nativePrinters[i].State; state != nil && state.MarkerState != nil {
+			pm.markers.Observe(nativePrinters[i].Name, state.MarkerState, time.Now())
+			if items := pm.markers.VendorStateItems(nativePrinters[i].Name); len(items) > 0 {
+				if state.VendorState == nil {
+					state.VendorState = &cdd.VendorState{}
+				}
+				state.VendorState.Item = append(state.VendorState.Item, items...)
+			}
+		}
+		if state := nativePrinters[i].State; state != nil {
+			lib.CompactVendorState(state.VendorState, pm.vendorStateMaxItems)
+		}
 
-		h = adler32.New()
-		lib.DeepHash(nativePrinters[i].Description, h)
-		nativePrinters[i].CapsHash = fmt.Sprintf("%x", h.Sum(nil))
+		nativePrinters[i].Tags["tagshash"] = th.Hash(nativePrinters[i].Tags)
+		nativePrinters[i].CapsHash = capsHash(nativePrinters[i].Description)
 	}
 
 	// Compare the snapshot to what we know currently.
-	diffs := lib.DiffPrinters(nativePrinters, pm.printers.GetAll())
+	knownPrinters := pm.printers.GetAll()
+	diffs := lib.DiffPrinters(nativePrinters, knownPrinters)
+	defer pm.releaseQuarantine(released)
 	if diffs == nil {
 		log.Infof("Printers are already in sync; there are %d", len(nativePrinters))
 		return nil
@@ -188,7 +472,8 @@ func (pm *PrinterManager) syncPrinters(ignorePrivet bool) error {
 	// Update GCP.
 	ch := make(chan lib.Printer, len(diffs))
 	for i := range diffs {
-		go pm.applyDiff(&diffs[i], ch, ignorePrivet)
+		diff := &diffs[i]
+		lib.Go(lib.SubsystemManager, func() { pm.applyDiff(diff, ch, ignorePrivet) })
 	}
 	currentPrinters := make([]lib.Printer, 0, len(diffs))
 	for _ = range diffs {
@@ -202,21 +487,107 @@ func (pm *PrinterManager) syncPrinters(ignorePrivet bool) error {
 	pm.printers.Refresh(currentPrinters)
 	log.Infof("Finished synchronizing %d printers", len(currentPrinters))
 
+	pm.fetchHeldJobs(knownPrinters, currentPrinters)
+
 	return nil
 }
 
+// capsHash hashes a printer's capabilities, to tell when they change.
+func capsHash(description *cdd.PrinterDescriptionSection) string {
+	h := adler32.New()
+	lib.DeepHash(description, h)
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+// CapabilitiesDiff describes how the capabilities that GCP has registered
+// for a printer differ from those that the connector generates from the
+// native printer now, and whether the next sync updates them, and why.
+func (pm *PrinterManager) CapabilitiesDiff(nativePrinterName string) (string, error) {
+	if pm.gcp == nil {
+		return "", errors.New("Capabilities can't be compared without cloud printing")
+	}
+	known, exists := pm.printers.GetByNativeName(nativePrinterName)
+	if !exists || known.GCPID == "" {
+		return "", fmt.Errorf("Printer %s is not a cloud printer", nativePrinterName)
+	}
+
+	nativePrinters, err := pm.native.GetPrinters()
+	if err != nil {
+		return "", err
+	}
+	var generated *lib.Printer
+	for i := range nativePrinters {
+		if nativePrinters[i].Name == nativePrinterName {
+			generated = &nativePrinters[i]
+			break
+		}
+	}
+	if generated == nil {
+		return fmt.Sprintf("Printer %s is gone from the native print system; the next sync deletes it\n", nativePrinterName), nil
+	}
+	if pm.duplicates != nil {
+		addAllowDuplicateCapability(generated)
+	}
+	generated.CapsHash = capsHash(generated.Description)
+
+	registered, _, err := pm.gcp.Printer(pm.ctx, known.GCPID)
+	if err != nil {
+		return "", err
+	}
+	registeredCDD, err := json.MarshalIndent(cdd.CloudDeviceDescription{Version: "1.0", Printer: registered.Description}, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	generatedCDD, err := json.MarshalIndent(cdd.CloudDeviceDescription{Version: "1.0", Printer: generated.Description}, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "Printer %s, GCP ID %s\n", nativePrinterName, known.GCPID)
+	fmt.Fprintf(&b, "Registered capabilities hash: %s\n", registered.CapsHash)
+	fmt.Fprintf(&b, "Last synced capabilities hash: %s\n", known.CapsHash)
+	fmt.Fprintf(&b, "Generated capabilities hash: %s\n", generated.CapsHash)
+
+	// The next sync compares the generated printer to the last synced one,
+	// not to the registered one.
+	var update *lib.PrinterDiff
+	if diffs := lib.DiffPrinters([]lib.Printer{*generated}, []lib.Printer{known}); len(diffs) > 0 {
+		update = &diffs[0]
+	}
+	switch {
+	case update != nil && update.CapsHashChanged:
+		fmt.Fprintln(&b, "The next sync updates the capabilities, because their hash changed since the last sync")
+	case update != nil && update.DescriptionChanged:
+		fmt.Fprintln(&b, "The next sync updates the capabilities, because they changed since the last sync")
+	case update != nil && update.GCPVersionChanged:
+		fmt.Fprintln(&b, "The next sync updates the capabilities, because the GCP version changed since the last sync")
+	default:
+		fmt.Fprintln(&b, "The next sync doesn't update the capabilities, which haven't changed since the last sync")
+	}
+
+	if d := lib.DiffText(string(registeredCDD), string(generatedCDD), "registered", "generated"); d == "" {
+		fmt.Fprintln(&b, "The registered capabilities are the generated capabilities")
+	} else {
+		b.WriteString(d)
+	}
+	return b.String(), nil
+}
+
 func (pm *PrinterManager) applyDiff(diff *lib.PrinterDiff, ch chan<- lib.Printer, ignorePrivet bool) {
+	ctx := lib.WithTraceScope(pm.ctx, diff.Printer.Name, "")
+
 	switch diff.Operation {
 	case lib.RegisterPrinter:
 		if pm.gcp != nil {
-			if err := pm.gcp.Register(&diff.Printer); err != nil {
+			if err := pm.gcp.Register(ctx, &diff.Printer); err != nil {
 				log.ErrorPrinterf(diff.Printer.Name, "Failed to register: %s", err)
 				break
 			}
 			log.InfoPrinterf(diff.Printer.Name+" "+diff.Printer.GCPID, "Registered in the cloud")
 
 			if pm.gcp.CanShare() {
-				if err := pm.gcp.Share(diff.Printer.GCPID, pm.shareScope, gcp.User, true, false); err != nil {
+				if err := pm.gcp.Share(ctx, diff.Printer.GCPID, pm.shareScope, gcp.User, true, false); err != nil {
 					log.ErrorPrinterf(diff.Printer.Name, "Failed to share: %s", err)
 				} else {
 					log.InfoPrinterf(diff.Printer.Name, "Shared")
@@ -240,7 +611,7 @@ func (pm *PrinterManager) applyDiff(diff *lib.PrinterDiff, ch chan<- lib.Printer
 
 	case lib.UpdatePrinter:
 		if pm.gcp != nil {
-			if err := pm.gcp.Update(diff); err != nil {
+			if err := pm.gcp.Update(ctx, diff); err != nil {
 				log.ErrorPrinterf(diff.Printer.Name+" "+diff.Printer.GCPID, "Failed to update: %s", err)
 			} else {
 				log.InfoPrinterf(diff.Printer.Name+" "+diff.Printer.GCPID, "Updated in the cloud")
@@ -263,7 +634,7 @@ func (pm *PrinterManager) applyDiff(diff *lib.PrinterDiff, ch chan<- lib.Printer
 		pm.native.RemoveCachedPPD(diff.Printer.Name)
 
 		if pm.gcp != nil {
-			if err := pm.gcp.Delete(diff.Printer.GCPID); err != nil {
+			if err := pm.gcp.Delete(ctx, diff.Printer.GCPID); err != nil {
 				log.ErrorPrinterf(diff.Printer.Name+" "+diff.Printer.GCPID, "Failed to delete from the cloud: %s", err)
 				break
 			}
@@ -287,28 +658,121 @@ func (pm *PrinterManager) applyDiff(diff *lib.PrinterDiff, ch chan<- lib.Printer
 	ch <- lib.Printer{}
 }
 
+// fetchHeldJobs fetches the jobs of printers whose queues accept jobs
+// again.
+func (pm *PrinterManager) fetchHeldJobs(before, after []lib.Printer) {
+	if pm.gcp == nil {
+		return
+	}
+	rejecting := make(map[string]struct{})
+	for _, p := range before {
+		if lib.PrinterIsRejectingJobs(p) {
+			rejecting[p.Name] = struct{}{}
+		}
+	}
+	for _, p := range after {
+		if _, exists := rejecting[p.Name]; exists && !lib.PrinterIsRejectingJobs(p) {
+			log.InfoPrinterf(p.Name, "Fetching held jobs now that the queue accepts jobs")
+			pm.requestJobFetch(p.GCPID)
+		}
+	}
+}
+
 // listenNotifications handles the messages found on the channels.
 func (pm *PrinterManager) listenNotifications(jobs <-chan *lib.Job, xmppMessages <-chan xmpp.PrinterNotification) {
-	go func() {
+	lib.Go(lib.SubsystemManager, func() {
 		for {
 			select {
-			case <-pm.quit:
+			case <-pm.ctx.Done():
 				return
 
 			case job := <-jobs:
 				log.DebugJobf(job.JobID, "Received job: %+v", job)
-				go pm.printJob(job.NativePrinterName, job.Filename, job.Title, job.User, job.JobID, job.Ticket, job.UpdateJob)
+				pm.jobCache.Add(job)
+				lib.Go(lib.SubsystemManager, func() {
+					pm.printJob(job.NativePrinterName, job.Filename, job.Title, job.User, job.JobID, job.Ticket, job.Deadline, job.UpdateJob)
+				})
 
 			case notification := <-xmppMessages:
 				log.Debugf("Received XMPP message: %+v", notification)
-				if notification.Type == xmpp.PrinterNewJobs {
-					if p, exists := pm.printers.GetByGCPID(notification.GCPID); exists {
-						go pm.gcp.HandleJobs(&p, func() { pm.incrementJobsProcessed(false) })
-					}
+				switch notification.Type {
+				case xmpp.PrinterNewJobs:
+					pm.requestJobFetch(notification.GCPID)
+				case xmpp.Reconnected:
+					lib.Go(lib.SubsystemManager, pm.reconcileJobs)
 				}
 			}
 		}
-	}()
+	})
+}
+
+// reconcileJobs fetches the jobs of every printer that has queued jobs, to
+// catch jobs whose notifications were missed while XMPP was down.
+func (pm *PrinterManager) reconcileJobs() {
+	if pm.gcp == nil {
+		return
+	}
+	_, queuedJobsCount, err := pm.gcp.ListPrinters(pm.ctx)
+	if err != nil {
+		log.Errorf("Failed to check for jobs queued while XMPP was down: %s", err)
+		return
+	}
+	for gcpPrinterID := range queuedJobsCount {
+		pm.requestJobFetch(gcpPrinterID)
+	}
+	log.Infof("Fetching the jobs of %d printers after XMPP reconnected", len(queuedJobsCount))
+}
+
+// requestJobFetch fetches jobs for a printer, without blocking.
+//
+// A burst of requests for one printer results in at most one fetch in
+// progress plus one more after it, and no more than jobFetchSemaphore
+// printers are fetched concurrently.
+func (pm *PrinterManager) requestJobFetch(gcpID string) {
+	if !pm.IsActive() {
+		// The instance that owns the printers fetches their jobs.
+		return
+	}
+
+	pm.jobFetchMutex.Lock()
+	defer pm.jobFetchMutex.Unlock()
+
+	if _, exists := pm.jobFetches[gcpID]; exists {
+		pm.jobFetches[gcpID] = true
+		return
+	}
+	pm.jobFetches[gcpID] = false
+
+	lib.Go(lib.SubsystemManager, func() { pm.fetchJobs(gcpID) })
+}
+
+// fetchJobs fetches jobs for a printer until no more fetches are requested.
+// Jobs of a printer whose queue rejects jobs, or that is quarantined, stay
+// queued in the cloud, rather than be submitted and rejected or failed.
+func (pm *PrinterManager) fetchJobs(gcpID string) {
+	pm.jobFetchSemaphore.Acquire()
+	defer pm.jobFetchSemaphore.Release()
+
+	for {
+		if p, exists := pm.printers.GetByGCPID(gcpID); exists {
+			if lib.PrinterIsRejectingJobs(p) {
+				log.InfoPrinterf(p.Name, "Holding jobs in the cloud while the queue rejects jobs")
+			} else if pm.quarantine.IsQuarantined(p.Name) {
+				log.InfoPrinterf(p.Name, "Holding jobs in the cloud while the printer is quarantined")
+			} else {
+				pm.gcp.HandleJobs(pm.ctx, &p, func() { pm.incrementJobsProcessed(false) })
+			}
+		}
+
+		pm.jobFetchMutex.Lock()
+		if !pm.jobFetches[gcpID] {
+			delete(pm.jobFetches, gcpID)
+			pm.jobFetchMutex.Unlock()
+			return
+		}
+		pm.jobFetches[gcpID] = false
+		pm.jobFetchMutex.Unlock()
+	}
 }
 
 func (pm *PrinterManager) incrementJobsProcessed(success bool) {
@@ -322,10 +786,11 @@ func (pm *PrinterManager) incrementJobsProcessed(success bool) {
 	}
 }
 
-// addInFlightJob adds a job ID to the in flight set.
+// addInFlightJob adds a job ID to the in flight set, with the function that
+// cancels the job.
 //
 // Returns true if the job ID was added, false if it already exists.
-func (pm *PrinterManager) addInFlightJob(jobID string) bool {
+func (pm *PrinterManager) addInFlightJob(jobID string, cancel context.CancelFunc) bool {
 	pm.jobsInFlightMutex.Lock()
 	defer pm.jobsInFlightMutex.Unlock()
 
@@ -333,27 +798,224 @@ func (pm *PrinterManager) addInFlightJob(jobID string) bool {
 		return false
 	}
 
-	pm.jobsInFlight[jobID] = struct{}{}
+	pm.jobsInFlight[jobID] = cancel
 
 	return true
 }
 
+// CancelJob cancels a job in flight, in the native print system and in the
+// cloud. Returns false when the job isn't in flight.
+func (pm *PrinterManager) CancelJob(jobID string) bool {
+	pm.jobsInFlightMutex.Lock()
+	defer pm.jobsInFlightMutex.Unlock()
+
+	cancel, exists := pm.jobsInFlight[jobID]
+	if exists {
+		cancel()
+	}
+	return exists
+}
+
 // deleteInFlightJob deletes a job from the in flight set.
 func (pm *PrinterManager) deleteInFlightJob(jobID string) {
 	pm.jobsInFlightMutex.Lock()
 	defer pm.jobsInFlightMutex.Unlock()
 
 	delete(pm.jobsInFlight, jobID)
+	delete(pm.jobETAs, jobID)
+}
+
+// estimateJob estimates when a job completes, by the pages per minute that
+// its printer has printed so far, and keeps the estimate for
+// administrators. Returns the progress message for the job's user, with
+// the time left when there is an estimate.
+func (pm *PrinterManager) estimateJob(jobID, printerName, message string, pages, printed int32) string {
+	now := time.Now()
+	pagesPerMinute := pm.usage.PagesPerMinute(printerName)
+	eta, ok := lib.EstimateJobETA(now, pages, printed, pagesPerMinute)
+	if !ok {
+		return message
+	}
+
+	pm.jobsInFlightMutex.Lock()
+	defer pm.jobsInFlightMutex.Unlock()
+
+	if _, exists := pm.jobsInFlight[jobID]; exists {
+		pm.jobETAs[jobID] = lib.JobETA{
+			JobID:          jobID,
+			PrinterName:    printerName,
+			Pages:          pages,
+			PagesPrinted:   printed,
+			PagesPerMinute: pagesPerMinute,
+			ETA:            eta,
+		}
+	}
+	return lib.JobProgressETA(message, eta.Sub(now))
+}
+
+// JobETAs gets the estimated completion time of one job, or of every job
+// in flight when jobID is empty, soonest first.
+func (pm *PrinterManager) JobETAs(jobID string) []lib.JobETA {
+	pm.jobsInFlightMutex.Lock()
+	defer pm.jobsInFlightMutex.Unlock()
+
+	etas := []lib.JobETA{}
+	for id, eta := range pm.jobETAs {
+		if jobID == "" || id == jobID {
+			etas = append(etas, eta)
+		}
+	}
+	sort.Slice(etas, func(i, j int) bool { return etas[i].ETA.Before(etas[j].ETA) })
+	return etas
+}
+
+// CachedJobs gets the jobs whose files are cached, to be reprinted.
+func (pm *PrinterManager) CachedJobs() []lib.CachedJob {
+	return pm.jobCache.Jobs()
+}
+
+// Reprint prints the cached file of a job again, to the printer whose
+// native name is nativePrinterName, or to the job's printer when it is
+// empty, without downloading the job again. The reprint is a local job,
+// which isn't reported to the cloud. Returns the reprint's job ID.
+func (pm *PrinterManager) Reprint(jobID, nativePrinterName string) (string, error) {
+	job, exists := pm.jobCache.Hold(jobID)
+	if !exists {
+		return "", fmt.Errorf("Job %s is not cached", jobID)
+	}
+	if nativePrinterName == "" {
+		nativePrinterName = job.NativePrinterName
+	}
+	if _, exists := pm.printers.GetByNativeName(nativePrinterName); !exists {
+		pm.jobCache.Release(job.Filename)
+		return "", fmt.Errorf("Printer %s does not exist", nativePrinterName)
+	}
+
+	reprintID := fmt.Sprintf("%s-reprint-%d", jobID, atomic.AddUint32(&pm.reprints, 1))
+	// A reprint is a duplicate on purpose.
+	ticket := allowDuplicate(job.Ticket)
+	log.InfoJobf(reprintID, "Reprinting job %s to %s", jobID, nativePrinterName)
+	lib.Go(lib.SubsystemManager, func() {
+		pm.printJob(nativePrinterName, job.Filename, job.Title, job.User, reprintID, ticket, time.Time{},
+			func(context.Context, string, *cdd.PrintJobStateDiff, string) error { return nil })
+	})
+	return reprintID, nil
+}
+
+// PrintTestPage prints a test page, with the connector's version and a
+// summary of the printer's capabilities, on the printer whose native name
+// is nativePrinterName. Returns the test page's job ID.
+func (pm *PrinterManager) PrintTestPage(nativePrinterName string) (string, error) {
+	printer, exists := pm.printers.GetByNativeName(nativePrinterName)
+	if !exists {
+		return "", fmt.Errorf("Printer %s does not exist", nativePrinterName)
+	}
+
+	f, err := ioutil.TempFile("", "cloud-print-connector-test-page-")
+	if err != nil {
+		return "", fmt.Errorf("Failed to write a test page: %s", err)
+	}
+	_, err = f.Write(lib.WriteTestPage(&printer, time.Now()))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("Failed to write a test page: %s", err)
+	}
+
+	jobID := fmt.Sprintf("test-page-%d", atomic.AddUint32(&pm.testPages, 1))
+	log.InfoJobf(jobID, "Printing a test page to %s", nativePrinterName)
+	lib.Go(lib.SubsystemManager, func() {
+		pm.printJob(nativePrinterName, f.Name(), "Test page", lib.ShortName, jobID, allowDuplicate(nil), time.Time{},
+			func(context.Context, string, *cdd.PrintJobStateDiff, string) error { return nil })
+	})
+	return jobID, nil
+}
+
+// RedirectJob prints a queued or failed cloud job on the printer whose
+// native name is nativePrinterName, instead of its own printer, like when
+// its own printer died. Its ticket is translated for the other printer.
+func (pm *PrinterManager) RedirectJob(gcpJobID, nativePrinterName string) error {
+	if pm.gcp == nil {
+		return errors.New("Jobs can't be redirected without cloud printing")
+	}
+	printer, exists := pm.printers.GetByNativeName(nativePrinterName)
+	if !exists || printer.GCPID == "" {
+		return fmt.Errorf("Printer %s is not a cloud printer", nativePrinterName)
+	}
+	pm.jobsInFlightMutex.Lock()
+	_, printing := pm.jobsInFlight[gcpJobID]
+	pm.jobsInFlightMutex.Unlock()
+	if printing {
+		return fmt.Errorf("Job %s is printing; cancel it before redirecting it", gcpJobID)
+	}
+
+	for _, p := range pm.printers.GetAll() {
+		if p.GCPID == "" {
+			continue
+		}
+		jobs, err := pm.gcp.Jobs(pm.ctx, p.GCPID)
+		if err != nil {
+			return fmt.Errorf("Failed to get the jobs of printer %s: %s", p.Name, err)
+		}
+		for i := range jobs {
+			job := &jobs[i]
+			if job.GCPJobID != gcpJobID {
+				continue
+			}
+			if job.SemanticState != nil {
+				switch job.SemanticState.State.Type {
+				case cdd.JobStateQueued, cdd.JobStateAborted:
+				default:
+					return fmt.Errorf("Job %s is %s, not queued or failed", gcpJobID, job.SemanticState.State.Type)
+				}
+			}
+			return pm.gcp.RedirectJob(pm.ctx, job, &printer, func() { pm.incrementJobsProcessed(false) })
+		}
+	}
+	return fmt.Errorf("Job %s is not a job of any printer", gcpJobID)
+}
+
+// allowDuplicate copies a ticket, with the vendor ticket item that lets the
+// job print again within the duplicate job window.
+func allowDuplicate(ticket *cdd.CloudJobTicket) *cdd.CloudJobTicket {
+	var t cdd.CloudJobTicket
+	if ticket != nil {
+		t = *ticket
+	}
+	items := []cdd.VendorTicketItem{{ID: lib.AllowDuplicateVendorID, Value: "true"}}
+	for _, vti := range t.Print.VendorTicketItem {
+		if vti.ID != lib.AllowDuplicateVendorID {
+			items = append(items, vti)
+		}
+	}
+	t.Print.VendorTicketItem = items
+	return &t
 }
 
 // printJob prints a new job to a native printer, then polls the native job state
 // and updates the GCP/Privet job state. then returns when the job state is DONE
-// or ABORTED.
+// or ABORTED, or when the job is canceled or passes its deadline; the zero
+// deadline is none.
 //
 // All errors are reported and logged from inside this function.
-func (pm *PrinterManager) printJob(nativePrinterName, filename, title, user, jobID string, ticket *cdd.CloudJobTicket, updateJob func(string, *cdd.PrintJobStateDiff) error) {
-	defer os.Remove(filename)
-	if !pm.addInFlightJob(jobID) {
+func (pm *PrinterManager) printJob(nativePrinterName, filename, title, user, jobID string, ticket *cdd.CloudJobTicket, deadline time.Time, updateJob func(context.Context, string, *cdd.PrintJobStateDiff, string) error) {
+	defer pm.jobCache.Release(filename)
+
+	// Job states are updated with traceCtx, the manager's context, so that
+	// the states of canceled jobs are still reported.
+	traceCtx := lib.WithTraceScope(pm.ctx, nativePrinterName, jobID)
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if deadline.IsZero() {
+		ctx, cancel = context.WithCancel(traceCtx)
+	} else {
+		ctx, cancel = context.WithDeadline(traceCtx, deadline)
+	}
+	defer cancel()
+
+	if !pm.addInFlightJob(jobID, cancel) {
 		// This print job was already received. We probably received it
 		// again because the first instance is still QUEUED (ie not
 		// IN_PROGRESS). That's OK, just throw away the second instance.
@@ -361,6 +1023,35 @@ func (pm *PrinterManager) printJob(nativePrinterName, filename, title, user, job
 	}
 	defer pm.deleteInFlightJob(jobID)
 
+	// report reports a job's state, with a message for its user, which may
+	// be empty.
+	report := func(state *cdd.PrintJobStateDiff, userMessage string) {
+		if err := updateJob(traceCtx, jobID, state, userMessage); err != nil {
+			log.ErrorJob(jobID, err)
+		}
+	}
+	update := func(state *cdd.PrintJobStateDiff) {
+		report(state, "")
+	}
+	// fail reports a failed job with a concise message for its user, and
+	// keeps the details of the failure for administrators.
+	fail := func(state *cdd.PrintJobStateDiff, jobErr *lib.JobError) {
+		report(state, pm.jobErrors.Add(jobID, nativePrinterName, jobErr))
+	}
+
+	// A malformed job must not take down the other printers.
+	defer func() {
+		if r := recover(); r != nil {
+			log.ErrorJobf(jobID, "Aborted after a panic: %v\n%s", r, debug.Stack())
+			pm.incrementJobsProcessed(false)
+			fail(&cdd.PrintJobStateDiff{State: lib.PanicState()}, &lib.JobError{
+				Kind:  lib.JobErrorInternal,
+				Stage: lib.JobErrorStageSubmit,
+				Err:   fmt.Errorf("Aborted after a panic: %v", r),
+			})
+		}
+	}()
+
 	if !pm.jobFullUsername {
 		user = strings.Split(user, "@")[0]
 	}
@@ -368,43 +1059,90 @@ func (pm *PrinterManager) printJob(nativePrinterName, filename, title, user, job
 	printer, exists := pm.printers.GetByNativeName(nativePrinterName)
 	if !exists {
 		pm.incrementJobsProcessed(false)
-		state := cdd.PrintJobStateDiff{
+		fail(&cdd.PrintJobStateDiff{
 			State: &cdd.JobState{
 				Type:               cdd.JobStateAborted,
 				ServiceActionCause: &cdd.ServiceActionCause{ErrorCode: cdd.ServiceActionCausePrinterDeleted},
 			},
+		}, &lib.JobError{
+			Kind:  lib.JobErrorPrinterUnavailable,
+			Stage: lib.JobErrorStageSubmit,
+			Err:   fmt.Errorf("Printer %s does not exist", nativePrinterName),
+		})
+		return
+	}
+
+	if pm.duplicates != nil {
+		if duplicate, err := pm.duplicates.IsDuplicate(printer.Name, user, filename, ticket); err != nil {
+			log.WarningJobf(jobID, "Failed to check for a duplicate job: %s", err)
+		} else if duplicate {
+			pm.incrementJobsProcessed(false)
+			log.WarningJobf(jobID, "Rejected as a duplicate of a job that %s just sent to %s", user, printer.Name)
+			fail(&cdd.PrintJobStateDiff{
+				State: &cdd.JobState{
+					Type:               cdd.JobStateAborted,
+					ServiceActionCause: &cdd.ServiceActionCause{ErrorCode: cdd.ServiceActionCauseOther},
+				},
+			}, &lib.JobError{
+				Kind:  lib.JobErrorDuplicate,
+				Stage: lib.JobErrorStageSubmit,
+				Err:   fmt.Errorf("Rejected as a duplicate of a job that %s just sent to %s", user, printer.Name),
+			})
+			return
 		}
-		if err := updateJob(jobID, &state); err != nil {
-			log.ErrorJob(jobID, err)
-		}
+	}
+
+	var state cdd.PrintJobStateDiff
+	defer pm.recordUsage(printer.Name, &state, time.Now())
+
+	if ctx.Err() != nil {
+		// Canceled, or past its deadline, while it waited to be printed.
+		pm.stopJob(ctx, jobID, &state, update, fail)
 		return
 	}
 
+	pages := lib.JobPages(filename, ticket)
+	report(&cdd.PrintJobStateDiff{State: &cdd.JobState{Type: cdd.JobStateInProgress}},
+		pm.estimateJob(jobID, printer.Name, lib.JobProgressConverting, pages, 0))
+
+	submitStart := time.Now()
 	nativeJobID, err := pm.native.Print(&printer, filename, title, user, jobID, ticket)
+	pm.submitDurations.Since(submitStart)
 	if err != nil {
 		pm.incrementJobsProcessed(false)
+		jobErr := lib.AsJobError(err, lib.JobErrorPrintFailed, lib.JobErrorStageSubmit)
+		if jobErr.Kind.IsPrinterFault() {
+			pm.countJobResult(&printer, false)
+		}
 		log.ErrorJobf(jobID, "Failed to submit to native print system: %s", err)
-		state := cdd.PrintJobStateDiff{
+		fail(&cdd.PrintJobStateDiff{
 			State: &cdd.JobState{
 				Type:              cdd.JobStateAborted,
 				DeviceActionCause: &cdd.DeviceActionCause{ErrorCode: cdd.DeviceActionCausePrintFailure},
 			},
-		}
-		if err := updateJob(jobID, &state); err != nil {
-			log.ErrorJob(jobID, err)
-		}
+		}, jobErr)
 		return
 	}
 
 	log.InfoJobf(jobID, "Submitted as native job %d", nativeJobID)
 
-	var state cdd.PrintJobStateDiff
-
 	ticker := time.NewTicker(time.Second)
 	defer ticker.Stop()
 	defer pm.releaseJob(printer.Name, nativeJobID, jobID)
 
-	for _ = range ticker.C {
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			if pm.ctx.Err() == nil {
+				if err := pm.native.CancelJob(printer.Name, nativeJobID); err != nil {
+					log.WarningJobf(jobID, "Failed to cancel native job %d: %s", nativeJobID, err)
+				}
+			}
+			pm.stopJob(ctx, jobID, &state, update, fail)
+			return
+		}
+
 		nativeState, err := pm.native.GetJobState(printer.Name, nativeJobID)
 		if err != nil {
 			log.WarningJobf(jobID, "Failed to get state of native job %d: %s", nativeJobID, err)
@@ -416,17 +1154,32 @@ func (pm *PrinterManager) printJob(nativePrinterName, filename, title, user, job
 				},
 				PagesPrinted: state.PagesPrinted,
 			}
-			if err := updateJob(jobID, &state); err != nil {
-				log.ErrorJob(jobID, err)
-			}
+			fail(&state, &lib.JobError{
+				Kind:  lib.JobErrorStatusUnknown,
+				Stage: lib.JobErrorStagePrint,
+				Err:   fmt.Errorf("Failed to get state of native job %d: %s", nativeJobID, err),
+			})
 			pm.incrementJobsProcessed(false)
+			pm.countJobResult(&printer, false)
 			return
 		}
 
 		if !reflect.DeepEqual(*nativeState, state) {
 			state = *nativeState
-			if err = updateJob(jobID, &state); err != nil {
-				log.ErrorJob(jobID, err)
+			if state.State.Type == cdd.JobStateAborted && state.State.UserActionCause == nil {
+				fail(&state, &lib.JobError{
+					Kind:  lib.JobErrorPrintFailed,
+					Stage: lib.JobErrorStagePrint,
+					Err:   fmt.Errorf("Native job %d was aborted: %s", nativeJobID, describeJobState(state.State)),
+				})
+			} else if state.State.Type == cdd.JobStateInProgress {
+				var printed int32
+				if state.PagesPrinted != nil {
+					printed = *state.PagesPrinted
+				}
+				report(&state, pm.estimateJob(jobID, printer.Name, jobProgress(printed, pages), pages, printed))
+			} else {
+				update(&state)
 			}
 			log.InfoJobf(jobID, "State: %s", state.State.Type)
 		}
@@ -434,20 +1187,218 @@ func (pm *PrinterManager) printJob(nativePrinterName, filename, title, user, job
 		if state.State.Type != cdd.JobStateInProgress && state.State.Type != cdd.JobStateStopped {
 			if state.State.Type == cdd.JobStateDone {
 				pm.incrementJobsProcessed(true)
+				pm.countJobResult(&printer, true)
 			} else {
 				pm.incrementJobsProcessed(false)
+				if state.State.UserActionCause == nil {
+					// Jobs that users cancel don't count against the printer.
+					pm.countJobResult(&printer, false)
+				}
 			}
 			return
 		}
 	}
 }
 
+// stopJob reports a job whose context is done: aborted when it was canceled
+// or passed its deadline. At shutdown the job is left to finish printing,
+// and its state is left alone.
+func (pm *PrinterManager) stopJob(ctx context.Context, jobID string, state *cdd.PrintJobStateDiff, update func(*cdd.PrintJobStateDiff), fail func(*cdd.PrintJobStateDiff, *lib.JobError)) {
+	if pm.ctx.Err() != nil {
+		log.InfoJob(jobID, "Stopped following at shutdown")
+		return
+	}
+
+	pm.incrementJobsProcessed(false)
+	*state = cdd.PrintJobStateDiff{
+		State:        &cdd.JobState{Type: cdd.JobStateAborted},
+		PagesPrinted: state.PagesPrinted,
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		log.WarningJob(jobID, "Aborted after its deadline")
+		state.State.ServiceActionCause = &cdd.ServiceActionCause{ErrorCode: cdd.ServiceActionCauseRemoteJobTimeout}
+		fail(state, &lib.JobError{
+			Kind:  lib.JobErrorTimeout,
+			Stage: lib.JobErrorStagePrint,
+			Err:   errors.New("Aborted after its deadline"),
+		})
+		return
+	}
+	log.InfoJob(jobID, "Canceled")
+	state.State.UserActionCause = &cdd.UserActionCause{ActionCode: cdd.UserActionCauseCanceled}
+	update(state)
+}
+
+// jobProgress tells the user of an IN_PROGRESS job which page is printing,
+// by the pages printed, when the native print system counts them, out of
+// pages, when they are known.
+func jobProgress(printed, pages int32) string {
+	if printed <= 0 {
+		return lib.JobProgressSubmitted
+	}
+	// The page printing is the one after those printed, until the last.
+	page := printed + 1
+	if pages > 0 && page > pages {
+		page = pages
+	}
+	return lib.JobProgressPrinting(page, pages)
+}
+
+// describeJobState describes the cause of a job's state, for
+// administrators.
+func describeJobState(state *cdd.JobState) string {
+	switch {
+	case state.DeviceStateCause != nil:
+		return fmt.Sprintf("device state %s", state.DeviceStateCause.ErrorCode)
+	case state.DeviceActionCause != nil:
+		return fmt.Sprintf("device action %s", state.DeviceActionCause.ErrorCode)
+	case state.ServiceActionCause != nil:
+		return fmt.Sprintf("service action %s", state.ServiceActionCause.ErrorCode)
+	default:
+		return "no cause given"
+	}
+}
+
+// recordUsage counts a job in its printer's usage stats, given the job's
+// final state.
+func (pm *PrinterManager) recordUsage(printerName string, state *cdd.PrintJobStateDiff, start time.Time) {
+	success := state.State != nil && state.State.Type == cdd.JobStateDone
+	var pages int32
+	if state.PagesPrinted != nil {
+		pages = *state.PagesPrinted
+	}
+	pm.usage.Record(printerName, success, pages, time.Since(start))
+}
+
+// addAllowDuplicateCapability lets users print the same document again, on
+// purpose, when duplicate jobs are rejected.
+func addAllowDuplicateCapability(printer *lib.Printer) {
+	if printer.Description == nil {
+		return
+	}
+	if printer.Description.VendorCapability == nil {
+		printer.Description.VendorCapability = &[]cdd.VendorCapability{}
+	}
+	for _, vc := range *printer.Description.VendorCapability {
+		if vc.ID == lib.AllowDuplicateVendorID {
+			return
+		}
+	}
+	*printer.Description.VendorCapability = append(*printer.Description.VendorCapability, lib.AllowDuplicateCapability)
+}
+
 func (pm *PrinterManager)releaseJob(printerName string, nativeJobID uint32, jobID string) {
 	if err := pm.native.ReleaseJob(printerName, nativeJobID); err != nil {
 		log.ErrorJob(jobID, err)
 	}
 }
 
+// notifySupplyAlert logs a supply that has become low or exhausted, and
+// posts it to the webhook.
+func (pm *PrinterManager) notifySupplyAlert(alert lib.SupplyAlert) {
+	log.Warningf("Supply %s of printer %s is at %d%%", alert.Marker, alert.Printer, alert.LevelPercent)
+	if err := pm.supplyAlerts.Notify(alert); err != nil {
+		log.Warningf("Failed to post supply alert: %s", err)
+	}
+}
+
+// countJobResult counts a job that succeeded or failed against its
+// printer's quarantine, and quarantines the printer when it has failed too
+// many jobs in a row.
+func (pm *PrinterManager) countJobResult(printer *lib.Printer, success bool) {
+	if success {
+		pm.quarantine.JobSucceeded(printer.Name)
+		return
+	}
+	if !pm.quarantine.JobFailed(printer.Name, time.Now()) {
+		return
+	}
+
+	failures := pm.quarantine.Failures(printer.Name)
+	log.ErrorPrinterf(printer.Name, "Quarantined after %d consecutive failed jobs; holding its jobs in the cloud", failures)
+	lib.Go(lib.SubsystemManager, func() {
+		pm.notifyQuarantine(lib.QuarantineAlert{
+			Printer:     printer.Name,
+			Failures:    failures,
+			Quarantined: true,
+			Reason:      fmt.Sprintf("%d consecutive failed jobs", failures),
+		})
+	})
+
+	if pm.gcp == nil {
+		return
+	}
+	// Report the printer stopped now, rather than at the next sync.
+	p := *printer
+	pm.quarantine.Apply(&p, time.Now())
+	diff := lib.PrinterDiff{Operation: lib.UpdatePrinter, Printer: p, StateChanged: true}
+	lib.Go(lib.SubsystemManager, func() {
+		if err := pm.gcp.Update(lib.WithTraceScope(pm.ctx, p.Name, ""), &diff); err != nil {
+			log.ErrorPrinterf(p.Name+" "+p.GCPID, "Failed to report quarantine: %s", err)
+		}
+	})
+}
+
+// releaseQuarantine fetches the held jobs of printers that passed their
+// quarantine probes.
+func (pm *PrinterManager) releaseQuarantine(printerNames []string) {
+	for _, name := range printerNames {
+		name := name
+		log.InfoPrinterf(name, "Released from quarantine on probation, now that it looks healthy")
+		lib.Go(lib.SubsystemManager, func() {
+			pm.notifyQuarantine(lib.QuarantineAlert{Printer: name, Reason: "probe succeeded"})
+		})
+		if p, exists := pm.printers.GetByNativeName(name); exists && pm.gcp != nil {
+			pm.requestJobFetch(p.GCPID)
+		}
+	}
+}
+
+// ClearQuarantine releases a printer from quarantine, and fetches its held
+// jobs.
+func (pm *PrinterManager) ClearQuarantine(nativePrinterName string) error {
+	p, exists := pm.printers.GetByNativeName(nativePrinterName)
+	if !exists {
+		return fmt.Errorf("Printer %s not found", nativePrinterName)
+	}
+	if !pm.quarantine.Clear(nativePrinterName) {
+		return fmt.Errorf("Printer %s is not quarantined", nativePrinterName)
+	}
+
+	log.InfoPrinterf(nativePrinterName, "Released from quarantine by an administrator")
+	lib.Go(lib.SubsystemManager, func() {
+		pm.notifyQuarantine(lib.QuarantineAlert{Printer: nativePrinterName, Reason: "cleared"})
+	})
+	if pm.gcp != nil {
+		pm.requestJobFetch(p.GCPID)
+	}
+	return nil
+}
+
+// JobErrors gets the records of the failures of one job, or of every job
+// when jobID is empty.
+func (pm *PrinterManager) JobErrors(jobID string) []lib.JobErrorRecord {
+	return pm.jobErrors.Records(jobID)
+}
+
+// QuarantinedPrinters gets the printers in quarantine.
+func (pm *PrinterManager) QuarantinedPrinters() []lib.QuarantinedPrinter {
+	return pm.quarantine.List()
+}
+
+// notifyQuarantine posts a quarantine alert to the webhook.
+func (pm *PrinterManager) notifyQuarantine(alert lib.QuarantineAlert) {
+	if err := pm.quarantine.Notify(alert); err != nil {
+		log.Warningf("Failed to post quarantine alert: %s", err)
+	}
+}
+
+// SupplyEstimates gets the supply estimates of one printer, or of every
+// printer when printerName is empty.
+func (pm *PrinterManager) SupplyEstimates(printerName string) []lib.SupplyEstimate {
+	return pm.markers.Estimates(printerName)
+}
+
 // GetJobStats returns information that is useful for monitoring
 // the connector.
 func (pm *PrinterManager) GetJobStats() (uint, uint, uint, error) {
@@ -462,3 +1413,11 @@ func (pm *PrinterManager) GetJobStats() (uint, uint, uint, error) {
 
 	return pm.jobsDone, pm.jobsError, processing, nil
 }
+
+// GetTimingStats returns the quantity, last, mean and maximum durations of
+// printer syncs, and the mean and maximum durations of native job submissions.
+func (pm *PrinterManager) GetTimingStats() (uint, time.Duration, time.Duration, time.Duration, time.Duration, time.Duration) {
+	syncQty, syncLast, syncMean, syncMax := pm.syncDurations.Get()
+	_, _, submitMean, submitMax := pm.submitDurations.Get()
+	return syncQty, syncLast, syncMean, syncMax, submitMean, submitMax
+}
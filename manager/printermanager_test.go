@@ -0,0 +1,204 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package manager
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/cloud-print-connector/cdd"
+	"github.com/google/cloud-print-connector/gcp"
+	"github.com/google/cloud-print-connector/lib"
+)
+
+// fakeNativePrintSystem panics when it prints the job with panicJobID, and
+// prints every other job at once.
+type fakeNativePrintSystem struct {
+	panicJobID string
+	mutex      sync.Mutex
+	printed    []string
+}
+
+func (f *fakeNativePrintSystem) GetPrinters() ([]lib.Printer, error) { return nil, nil }
+
+func (f *fakeNativePrintSystem) GetJobState(printerName string, jobID uint32) (*cdd.PrintJobStateDiff, error) {
+	return &cdd.PrintJobStateDiff{State: &cdd.JobState{Type: cdd.JobStateDone}}, nil
+}
+
+func (f *fakeNativePrintSystem) Print(printer *lib.Printer, fileName, title, user, gcpJobID string, ticket *cdd.CloudJobTicket) (uint32, error) {
+	if gcpJobID == f.panicJobID {
+		panic("malformed job")
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.printed = append(f.printed, gcpJobID)
+	return uint32(len(f.printed)), nil
+}
+
+func (f *fakeNativePrintSystem) ReleaseJob(printerName string, jobID uint32) error { return nil }
+func (f *fakeNativePrintSystem) CancelJob(printerName string, jobID uint32) error  { return nil }
+func (f *fakeNativePrintSystem) RemoveCachedPPD(printerName string)                {}
+
+// jobStates records the states that jobs report, by job ID.
+type jobStates struct {
+	mutex  sync.Mutex
+	states map[string][]cdd.JobStateType
+}
+
+func (s *jobStates) updateJob(ctx context.Context, jobID string, state *cdd.PrintJobStateDiff, userMessage string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.states[jobID] = append(s.states[jobID], state.State.Type)
+	return nil
+}
+
+func (s *jobStates) last(jobID string) cdd.JobStateType {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	states := s.states[jobID]
+	if len(states) == 0 {
+		return ""
+	}
+	return states[len(states)-1]
+}
+
+func TestPrintJobPanic(t *testing.T) {
+	native := &fakeNativePrintSystem{panicJobID: "panic-job"}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pm := &PrinterManager{
+		native:       native,
+		printers:     lib.NewConcurrentPrinterMap([]lib.Printer{{Name: "printer", GCPID: "gcp-printer"}}),
+		jobsInFlight: make(map[string]context.CancelFunc),
+		jobETAs:      make(map[string]lib.JobETA),
+		usage:        lib.NewUsageStats(),
+		jobErrors:    lib.NewJobErrorLog(nil),
+		ctx:          ctx,
+		cancel:       cancel,
+	}
+	states := &jobStates{states: make(map[string][]cdd.JobStateType)}
+
+	pm.printJob("printer", "", "title", "user", "panic-job", &cdd.CloudJobTicket{}, time.Time{}, states.updateJob)
+	if state := states.last("panic-job"); state != cdd.JobStateAborted {
+		t.Logf("expected the panicking job to be aborted, got %q", state)
+		t.Fail()
+	}
+	records := pm.jobErrors.Records("panic-job")
+	if len(records) != 1 || records[0].Kind != lib.JobErrorInternal {
+		t.Logf("expected one internal error for the panicking job, got %+v", records)
+		t.Fail()
+	}
+	if pm.CancelJob("panic-job") {
+		t.Log("expected the panicking job to be out of flight")
+		t.Fail()
+	}
+
+	// The next job prints.
+	pm.printJob("printer", "", "title", "user", "next-job", &cdd.CloudJobTicket{}, time.Time{}, states.updateJob)
+	if state := states.last("next-job"); state != cdd.JobStateDone {
+		t.Logf("expected the next job to be done, got %q", state)
+		t.Fail()
+	}
+	if len(native.printed) != 1 || native.printed[0] != "next-job" {
+		t.Logf("expected only the next job to be printed, got %v", native.printed)
+		t.Fail()
+	}
+	if pm.jobsDone != 1 || pm.jobsError != 1 {
+		t.Logf("expected 1 job done and 1 failed, got %d and %d", pm.jobsDone, pm.jobsError)
+		t.Fail()
+	}
+}
+
+// fakeFetchServer answers GCP fetches with no jobs, and holds the first
+// fetch until release is closed.
+type fakeFetchServer struct {
+	started chan struct{}
+	release chan struct{}
+	mutex   sync.Mutex
+	fetches int
+}
+
+func (s *fakeFetchServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.URL.Path == "/token" {
+		fmt.Fprint(w, `{"access_token":"access","token_type":"Bearer","expires_in":3600}`)
+		return
+	}
+
+	s.mutex.Lock()
+	s.fetches++
+	first := s.fetches == 1
+	s.mutex.Unlock()
+	if first {
+		close(s.started)
+		<-s.release
+	}
+	fmt.Fprint(w, `{"success":false,"errorCode":413,"message":"No print job available on specified printer."}`)
+}
+
+func (s *fakeFetchServer) count() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.fetches
+}
+
+func TestRequestJobFetchCoalesces(t *testing.T) {
+	fetchServer := &fakeFetchServer{started: make(chan struct{}), release: make(chan struct{})}
+	server := httptest.NewServer(fetchServer)
+	defer server.Close()
+
+	g, err := gcp.NewGoogleCloudPrint(server.URL+"/", "robot-refresh-token", "", "proxy", "client-id", "client-secret",
+		server.URL+"/auth", server.URL+"/token", 1, nil, lib.NewJobLimiter(lib.JobLimits{}, nil), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pm := &PrinterManager{
+		gcp:               g,
+		printers:          lib.NewConcurrentPrinterMap([]lib.Printer{{Name: "printer", GCPID: "gcp-printer"}}),
+		jobFetches:        make(map[string]bool),
+		jobFetchSemaphore: lib.NewSemaphore(1),
+		ctx:               ctx,
+		cancel:            cancel,
+	}
+
+	pm.requestJobFetch("gcp-printer")
+	select {
+	case <-fetchServer.started:
+	case <-time.After(10 * time.Second):
+		t.Fatal("expected the first fetch to start")
+	}
+	// Every request during the fetch comes down to one more fetch.
+	for i := 0; i < 5; i++ {
+		pm.requestJobFetch("gcp-printer")
+	}
+	close(fetchServer.release)
+
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		pm.jobFetchMutex.Lock()
+		_, fetching := pm.jobFetches["gcp-printer"]
+		pm.jobFetchMutex.Unlock()
+		if !fetching {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected the fetches to finish")
+		}
+	}
+	if n := fetchServer.count(); n != 2 {
+		t.Logf("expected 2 fetches, got %d", n)
+		t.Fail()
+	}
+}
@@ -9,8 +9,18 @@
 package monitor
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
 	"net"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/google/cloud-print-connector/cups"
 	"github.com/google/cloud-print-connector/gcp"
@@ -23,41 +33,191 @@ import (
 const monitorFormat = `cups-printers=%d
 cups-raw-printers=%d
 gcp-printers=%d
+gcp-reauthorization-required=%d
 local-printers=%d
+instance-active=%d
 cups-conn-qty=%d
 cups-conn-max-qty=%d
+cups-version=%s
+cups-queues=%d
+cups-jobs-pending=%d
 jobs-done=%d
 jobs-error=%d
 jobs-in-progress=%d
+sync-qty=%d
+sync-last-ms=%d
+sync-mean-ms=%d
+sync-max-ms=%d
+ppd-mean-ms=%d
+ppd-max-ms=%d
+job-submit-mean-ms=%d
+job-submit-max-ms=%d
 `
 
+// monitorRequestTimeout is how long to wait for a request line. Clients that
+// send nothing get the stats.
+const monitorRequestTimeout = time.Second
+
+const (
+	monitorRequestStats      = "stats"
+	monitorRequestJobTickets = "job-tickets"
+	monitorRequestJobErrors  = "job-errors"
+	monitorRequestJobETAs    = "job-eta"
+	monitorRequestSupplies   = "supplies"
+	monitorRequestRefresh    = "refresh-printers"
+	// override-options <printer name> <jobs> [<option>=<value> ...]
+	monitorRequestOverrideOptions = "override-options"
+	// tune [<parameter>=<value> ...]
+	monitorRequestTune = "tune"
+	// cancel-job <job ID>
+	monitorRequestCancelJob = "cancel-job"
+	// redirect-job <job ID> <printer name>
+	monitorRequestRedirectJob = "redirect-job"
+	// reprint [<job ID> [<printer name>]] lists the cached jobs, or prints
+	// one again.
+	monitorRequestReprint = "reprint"
+	// capabilities-diff <printer name> compares the registered capabilities
+	// of a printer to those generated now.
+	monitorRequestCapabilitiesDiff = "capabilities-diff"
+	// faults [<name>=<value> ...] injects faults, when the connector is built
+	// with -tags faultinject.
+	monitorRequestFaults = "faults"
+	// identify-printer <printer name> [<action>,...] makes a printer show
+	// where it is.
+	monitorRequestIdentifyPrinter = "identify-printer"
+	// test-page <printer name> prints a test page.
+	monitorRequestTestPage = "test-page"
+	// goroutines dumps the stacks of all goroutines, by subsystem.
+	monitorRequestGoroutines = "goroutines"
+	// trace [start <filename> [printer=<name>] [job=<job ID>] | stop]
+	monitorRequestTrace = "trace"
+	// quarantine lists the quarantined printers.
+	monitorRequestQuarantine = "quarantine"
+	// clear-quarantine <printer name> releases a printer from quarantine.
+	monitorRequestClearQuarantine = "clear-quarantine"
+)
+
+// Parameters of the tune request, which take effect without a restart.
+const (
+	tuneLogLevel               = "log-level"
+	tunePrinterPollInterval    = "printer-poll-interval"
+	tuneMaxConcurrentFetches   = "gcp-max-concurrent-fetches"
+	tuneMaxConcurrentDownloads = "gcp-max-concurrent-downloads"
+	tuneDownloadBytesPerSecond = "gcp-download-bytes-per-second"
+	tuneRequestsPerMinute      = "gcp-requests-per-minute"
+)
+
+// readOnlyRequests are the only requests that the read-only socket
+// answers: those that neither change the connector nor reveal users, job
+// tickets or the connector's internals. It refuses every other request.
+var readOnlyRequests = map[string]bool{
+	monitorRequestStats:            true,
+	monitorRequestJobETAs:          true,
+	monitorRequestSupplies:         true,
+	monitorRequestQuarantine:       true,
+	monitorRequestCapabilitiesDiff: true,
+}
+
+// isReadOnly tells whether the read-only socket answers a request. The
+// empty request is stats.
+func isReadOnly(request string) bool {
+	fields := strings.Fields(request)
+	return len(fields) == 0 || readOnlyRequests[fields[0]]
+}
+
+// changingRequests are the requests that change the connector, by the
+// audit events that they are recorded as. Those listed by
+// listingWithoutArgs only change it when they have arguments.
+var (
+	changingRequests = map[string]lib.AuditEvent{
+		monitorRequestRefresh:         lib.AuditEventAdmin,
+		monitorRequestOverrideOptions: lib.AuditEventPolicy,
+		monitorRequestTune:            lib.AuditEventPolicy,
+		monitorRequestCancelJob:       lib.AuditEventAdmin,
+		monitorRequestRedirectJob:     lib.AuditEventAdmin,
+		monitorRequestReprint:         lib.AuditEventAdmin,
+		monitorRequestFaults:          lib.AuditEventAdmin,
+		monitorRequestIdentifyPrinter: lib.AuditEventAdmin,
+		monitorRequestTestPage:        lib.AuditEventAdmin,
+		monitorRequestTrace:           lib.AuditEventAdmin,
+		monitorRequestClearQuarantine: lib.AuditEventAdmin,
+	}
+	listingWithoutArgs = map[string]bool{
+		monitorRequestTune:    true,
+		monitorRequestReprint: true,
+		monitorRequestFaults:  true,
+	}
+)
+
+// changesConnector tells whether a request changes the connector, and which
+// audit event it is recorded as.
+func changesConnector(request string) (lib.AuditEvent, bool) {
+	fields := strings.Fields(request)
+	if len(fields) == 0 {
+		return "", false
+	}
+	event, changes := changingRequests[fields[0]]
+	if !changes || listingWithoutArgs[fields[0]] && len(fields) == 1 {
+		return "", false
+	}
+	return event, true
+}
+
 type Monitor struct {
-	cups         *cups.CUPS
-	gcp          *gcp.GoogleCloudPrint
-	p            *privet.Privet
-	pm           *manager.PrinterManager
-	listenerQuit chan bool
+	cups  *cups.CUPS
+	gcp   *gcp.GoogleCloudPrint
+	p     *privet.Privet
+	pm    *manager.PrinterManager
+	audit *lib.AuditLog
+	// listenerQuits stop the listener of each socket.
+	listenerQuits []chan bool
 }
 
-func NewMonitor(cups *cups.CUPS, gcp *gcp.GoogleCloudPrint, p *privet.Privet, pm *manager.PrinterManager, socketFilename string) (*Monitor, error) {
-	m := Monitor{cups, gcp, p, pm, make(chan bool)}
+// NewMonitor listens to socketFilename for every request, and, unless it
+// is empty, to readOnlySocketFilename for readOnlyRequests only, which any
+// local user may connect to, for dashboards.
+func NewMonitor(cups *cups.CUPS, gcp *gcp.GoogleCloudPrint, p *privet.Privet, pm *manager.PrinterManager, audit *lib.AuditLog, socketFilename, readOnlySocketFilename string) (*Monitor, error) {
+	m := Monitor{cups: cups, gcp: gcp, p: p, pm: pm, audit: audit}
 
 	listener, err := net.ListenUnix("unix", &net.UnixAddr{socketFilename, "unix"})
 	if err != nil {
 		return nil, err
 	}
+	var readOnlyListener *net.UnixListener
+	if readOnlySocketFilename != "" {
+		readOnlyListener, err = net.ListenUnix("unix", &net.UnixAddr{readOnlySocketFilename, "unix"})
+		if err == nil {
+			err = os.Chmod(readOnlySocketFilename, 0666)
+		}
+		if err != nil {
+			listener.Close()
+			if readOnlyListener != nil {
+				readOnlyListener.Close()
+			}
+			return nil, fmt.Errorf("Failed to listen to read-only monitor socket %s: %s", readOnlySocketFilename, err)
+		}
+	}
 
-	go m.listen(listener)
+	quit := make(chan bool)
+	m.listenerQuits = append(m.listenerQuits, quit)
+	lib.Go(lib.SubsystemMonitor, func() { m.listen(listener, false, quit) })
+	if readOnlyListener != nil {
+		readOnlyQuit := make(chan bool)
+		m.listenerQuits = append(m.listenerQuits, readOnlyQuit)
+		lib.Go(lib.SubsystemMonitor, func() { m.listen(readOnlyListener, true, readOnlyQuit) })
+	}
 
 	return &m, nil
 }
 
-func (m *Monitor) listen(listener net.Listener) {
+// listen answers the requests of a socket until quit. When readOnly, it
+// refuses the requests that aren't readOnlyRequests.
+func (m *Monitor) listen(listener net.Listener, readOnly bool, quit chan bool) {
 	ch := make(chan net.Conn)
 	quitReq := make(chan bool, 1)
 	quitAck := make(chan bool)
 
-	go func() {
+	lib.Go(lib.SubsystemMonitor, func() {
 		for {
 			conn, err := listener.Accept()
 			if err != nil {
@@ -68,43 +228,469 @@ func (m *Monitor) listen(listener net.Listener) {
 				}
 				log.Errorf("Error listening to monitor socket: %s", err)
 			} else {
-				ch <- conn
+				ch <- lib.TrackConn(lib.SubsystemMonitor, conn)
 			}
 		}
-	}()
+	})
 
 	for {
 		select {
 		case conn := <-ch:
-			log.Info("Received monitor request")
-			stats, err := m.getStats()
+			request := readRequest(conn)
+			log.Infof("Received monitor request %q", request)
+			if readOnly && !isReadOnly(request) {
+				log.Warningf("Refused monitor request %q on the read-only socket", request)
+				conn.Write([]byte("error"))
+				conn.Close()
+				continue
+			}
+			response, err := m.handleRequest(request)
+			m.recordAudit(request, err)
 			if err != nil {
 				log.Warningf("Monitor request failed: %s", err)
 				conn.Write([]byte("error"))
 			} else {
-				conn.Write([]byte(stats))
+				conn.Write([]byte(response))
 			}
 			conn.Close()
 
-		case <-m.listenerQuit:
+		case <-quit:
 			quitReq <- true
 			listener.Close()
 			<-quitAck
-			m.listenerQuit <- true
+			quit <- true
 			return
 		}
 	}
 }
 
+// recordAudit records a request that changes the connector, and whether it
+// failed, in the audit log.
+func (m *Monitor) recordAudit(request string, requestErr error) {
+	event, changes := changesConnector(request)
+	if !changes {
+		return
+	}
+
+	detail := fmt.Sprintf("Monitor request %q succeeded", request)
+	if requestErr != nil {
+		detail = fmt.Sprintf("Monitor request %q failed: %s", request, requestErr)
+	}
+	if err := m.audit.Record(event, detail); err != nil {
+		log.Errorf("Failed to record %s event in the audit log: %s", event, err)
+	}
+}
+
 func (m *Monitor) Quit() {
-	m.listenerQuit <- true
-	<-m.listenerQuit
+	for _, quit := range m.listenerQuits {
+		quit <- true
+		<-quit
+	}
+}
+
+// readRequest reads one request line, like "job-tickets 1234". Returns the
+// empty string when the client closes its side, or sends nothing in time.
+func readRequest(conn net.Conn) string {
+	conn.SetReadDeadline(time.Now().Add(monitorRequestTimeout))
+	line, _ := bufio.NewReader(conn).ReadString('\n')
+	conn.SetReadDeadline(time.Time{})
+	return strings.TrimSpace(line)
+}
+
+func (m *Monitor) handleRequest(request string) (string, error) {
+	fields := strings.Fields(request)
+	if len(fields) == 0 || fields[0] == monitorRequestStats {
+		return m.getStats()
+	}
+
+	switch fields[0] {
+	case monitorRequestJobTickets:
+		var gcpJobID string
+		if len(fields) > 1 {
+			gcpJobID = fields[1]
+		}
+		return m.getJobTickets(gcpJobID)
+	case monitorRequestJobErrors:
+		var jobID string
+		if len(fields) > 1 {
+			jobID = fields[1]
+		}
+		return m.getJobErrors(jobID)
+	case monitorRequestJobETAs:
+		var jobID string
+		if len(fields) > 1 {
+			jobID = fields[1]
+		}
+		return m.getJobETAs(jobID)
+	case monitorRequestSupplies:
+		var printerName string
+		if len(fields) > 1 {
+			printerName = fields[1]
+		}
+		return m.getSupplies(printerName)
+	case monitorRequestRefresh:
+		return m.refreshPrinters()
+	case monitorRequestOverrideOptions:
+		return m.overrideOptions(fields[1:])
+	case monitorRequestTune:
+		return m.tune(fields[1:])
+	case monitorRequestCancelJob:
+		if len(fields) != 2 {
+			return "", fmt.Errorf("%s needs a job ID", monitorRequestCancelJob)
+		}
+		return m.cancelJob(fields[1])
+	case monitorRequestRedirectJob:
+		if len(fields) != 3 {
+			return "", fmt.Errorf("%s needs a job ID and a printer name", monitorRequestRedirectJob)
+		}
+		return m.redirectJob(fields[1], fields[2])
+	case monitorRequestReprint:
+		return m.reprint(fields[1:])
+	case monitorRequestTrace:
+		return m.trace(fields[1:])
+	case monitorRequestCapabilitiesDiff:
+		if len(fields) != 2 {
+			return "", fmt.Errorf("%s needs a printer name", monitorRequestCapabilitiesDiff)
+		}
+		return m.pm.CapabilitiesDiff(fields[1])
+	case monitorRequestFaults:
+		return m.faults(fields[1:])
+	case monitorRequestIdentifyPrinter:
+		if len(fields) < 2 || len(fields) > 3 {
+			return "", fmt.Errorf("%s needs a printer name, and maybe actions", monitorRequestIdentifyPrinter)
+		}
+		var actions []string
+		if len(fields) == 3 {
+			actions = strings.Split(fields[2], ",")
+		}
+		if err := m.cups.IdentifyPrinter(fields[1], actions); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Asked printer %s to identify itself\n", fields[1]), nil
+	case monitorRequestTestPage:
+		if len(fields) != 2 {
+			return "", fmt.Errorf("%s needs a printer name", monitorRequestTestPage)
+		}
+		jobID, err := m.pm.PrintTestPage(fields[1])
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Printing a test page to printer %s as job %s\n", fields[1], jobID), nil
+	case monitorRequestQuarantine:
+		return m.getQuarantine()
+	case monitorRequestClearQuarantine:
+		if len(fields) != 2 {
+			return "", fmt.Errorf("%s needs a printer name", monitorRequestClearQuarantine)
+		}
+		if err := m.pm.ClearQuarantine(fields[1]); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Released printer %s from quarantine\n", fields[1]), nil
+	case monitorRequestGoroutines:
+		var b bytes.Buffer
+		if err := lib.WriteGoroutineStacks(&b); err != nil {
+			return "", err
+		}
+		return b.String(), nil
+	default:
+		return "", fmt.Errorf("unknown monitor request %q", request)
+	}
+}
+
+// getJobTickets gets the job ticket audit records of one GCP job, or of
+// every job when gcpJobID is empty, as JSON.
+func (m *Monitor) getJobTickets(gcpJobID string) (string, error) {
+	b, err := json.MarshalIndent(m.cups.JobTicketRecords(gcpJobID), "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(b) + "\n", nil
+}
+
+// getJobErrors gets the failure records of one job, or of every job when
+// jobID is empty, as JSON.
+func (m *Monitor) getJobErrors(jobID string) (string, error) {
+	b, err := json.MarshalIndent(m.pm.JobErrors(jobID), "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(b) + "\n", nil
+}
+
+// getJobETAs gets the estimated completion time of one job, or of every
+// job that is printing when jobID is empty, as JSON.
+func (m *Monitor) getJobETAs(jobID string) (string, error) {
+	b, err := json.MarshalIndent(m.pm.JobETAs(jobID), "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(b) + "\n", nil
+}
+
+// getSupplies gets the supply estimates of one printer, or of every printer
+// when printerName is empty, as JSON.
+func (m *Monitor) getSupplies(printerName string) (string, error) {
+	b, err := json.MarshalIndent(m.pm.SupplyEstimates(printerName), "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(b) + "\n", nil
+}
+
+func (m *Monitor) getQuarantine() (string, error) {
+	b, err := json.MarshalIndent(m.pm.QuarantinedPrinters(), "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(b) + "\n", nil
+}
+
+// cancelJob cancels a job that is printing, in CUPS and in the cloud.
+func (m *Monitor) cancelJob(jobID string) (string, error) {
+	if !m.pm.CancelJob(jobID) {
+		return "", fmt.Errorf("Job %s is not printing", jobID)
+	}
+	return fmt.Sprintf("Canceled job %s\n", jobID), nil
+}
+
+// redirectJob prints a queued or failed cloud job on another printer.
+func (m *Monitor) redirectJob(jobID, printerName string) (string, error) {
+	if err := m.pm.RedirectJob(jobID, printerName); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Redirected job %s to printer %s\n", jobID, printerName), nil
+}
+
+// reprint prints a cached job again, to its printer or to another one, or
+// lists the cached jobs as JSON.
+func (m *Monitor) reprint(args []string) (string, error) {
+	switch len(args) {
+	case 0:
+		b, err := json.MarshalIndent(m.pm.CachedJobs(), "", "  ")
+		if err != nil {
+			return "", err
+		}
+		return string(b) + "\n", nil
+	case 1, 2:
+		var printerName string
+		if len(args) == 2 {
+			printerName = args[1]
+		}
+		reprintID, err := m.pm.Reprint(args[0], printerName)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Reprinting job %s as job %s\n", args[0], reprintID), nil
+	default:
+		return "", fmt.Errorf("%s needs at most a job ID and a printer name", monitorRequestReprint)
+	}
+}
+
+// trace starts or stops tracing the IPP and GCP exchanges of a printer or
+// job, and gets the status of the trace.
+func (m *Monitor) trace(args []string) (string, error) {
+	if len(args) == 0 {
+		return lib.TraceStatus() + "\n", nil
+	}
+
+	switch args[0] {
+	case "start":
+		if len(args) < 2 {
+			return "", fmt.Errorf("%s start needs a filename", monitorRequestTrace)
+		}
+		var printer, jobID string
+		for _, arg := range args[2:] {
+			kv := strings.SplitN(arg, "=", 2)
+			switch {
+			case len(kv) == 2 && kv[0] == "printer":
+				printer = kv[1]
+			case len(kv) == 2 && kv[0] == "job":
+				jobID = kv[1]
+			default:
+				return "", fmt.Errorf("%s start argument %q is not printer=<name> or job=<job ID>", monitorRequestTrace, arg)
+			}
+		}
+		if err := lib.StartTrace(args[1], printer, jobID); err != nil {
+			return "", err
+		}
+		log.Infof("Started tracing to %s", args[1])
+		return lib.TraceStatus() + "\n", nil
+
+	case "stop":
+		filename, records, err := lib.StopTrace()
+		if err != nil {
+			return "", err
+		}
+		log.Infof("Stopped tracing to %s", filename)
+		return fmt.Sprintf("wrote %d records to %s\n", records, filename), nil
+
+	default:
+		return "", fmt.Errorf("%s %q is not start or stop", monitorRequestTrace, args[0])
+	}
+}
+
+// refreshPrinters gets the CUPS printers again, for the stats, rather than
+// wait for the cached ones to expire.
+func (m *Monitor) refreshPrinters() (string, error) {
+	printers, err := m.cups.RefreshCachedPrinters()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("cups-printers=%d\n", len(printers)), nil
+}
+
+// overrideOptions sets the options override of one printer, from the
+// arguments of an override-options request.
+func (m *Monitor) overrideOptions(args []string) (string, error) {
+	if len(args) < 2 {
+		return "", fmt.Errorf("%s needs a printer name and a quantity of jobs", monitorRequestOverrideOptions)
+	}
+	jobs, err := strconv.ParseUint(args[1], 10, 32)
+	if err != nil {
+		return "", fmt.Errorf("%s quantity of jobs %q is not valid: %s", monitorRequestOverrideOptions, args[1], err)
+	}
+
+	o := cups.OptionsOverride{Options: make(map[string]string, len(args)-2), Jobs: uint(jobs)}
+	for _, arg := range args[2:] {
+		kv := strings.SplitN(arg, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return "", fmt.Errorf("%s option %q is not like name=value", monitorRequestOverrideOptions, arg)
+		}
+		o.Options[kv[0]] = kv[1]
+	}
+
+	m.cups.OverrideJobOptions(args[0], o)
+	return fmt.Sprintf("printer %s will merge options %v into its next %d jobs\n", args[0], o.Options, o.Jobs), nil
+}
+
+// tune sets the parameters of a tune request, like log-level=DEBUG, and gets
+// the values of every parameter. Nothing is set unless every parameter is
+// valid.
+func (m *Monitor) tune(args []string) (string, error) {
+	var changes []func()
+	for _, arg := range args {
+		kv := strings.SplitN(arg, "=", 2)
+		if len(kv) != 2 {
+			return "", fmt.Errorf("%s parameter %q is not like name=value", monitorRequestTune, arg)
+		}
+		change, err := m.tuneParameter(kv[0], kv[1])
+		if err != nil {
+			return "", err
+		}
+		changes = append(changes, change)
+	}
+	for i, change := range changes {
+		change()
+		log.Infof("Tuned %s", args[i])
+	}
+
+	response := fmt.Sprintf("%s=%s\n%s=%s\n%s=%d\n",
+		tuneLogLevel, log.GetLevel(),
+		tunePrinterPollInterval, m.pm.PrinterPollInterval(),
+		tuneMaxConcurrentFetches, m.pm.MaxConcurrentFetches())
+	if m.gcp != nil {
+		response += fmt.Sprintf("%s=%d\n%s=%d\n%s=%d\n",
+			tuneMaxConcurrentDownloads, m.gcp.MaxConcurrentDownloads(),
+			tuneDownloadBytesPerSecond, m.gcp.DownloadRate(),
+			tuneRequestsPerMinute, m.gcp.RequestsPerMinute())
+	}
+	return response, nil
+}
+
+// tuneParameter validates one parameter of a tune request, and returns the
+// function that sets it.
+func (m *Monitor) tuneParameter(name, value string) (func(), error) {
+	switch name {
+	case tuneLogLevel:
+		level, ok := log.LevelFromString(value)
+		if !ok {
+			return nil, fmt.Errorf("%s %q is not a log level", name, value)
+		}
+		return func() { log.SetLevel(level) }, nil
+
+	case tunePrinterPollInterval:
+		interval, err := time.ParseDuration(value)
+		if err != nil || interval <= 0 {
+			return nil, fmt.Errorf("%s %q is not a positive duration", name, value)
+		}
+		return func() { m.pm.SetPrinterPollInterval(interval) }, nil
+
+	case tuneMaxConcurrentFetches, tuneMaxConcurrentDownloads:
+		max, err := strconv.ParseUint(value, 10, 32)
+		if err != nil || max == 0 {
+			return nil, fmt.Errorf("%s %q is not a positive number", name, value)
+		}
+		if name == tuneMaxConcurrentFetches {
+			return func() { m.pm.SetMaxConcurrentFetches(uint(max)) }, nil
+		}
+		if m.gcp == nil {
+			return nil, fmt.Errorf("%s can't be tuned without cloud printing", name)
+		}
+		return func() { m.gcp.SetMaxConcurrentDownloads(uint(max)) }, nil
+
+	case tuneDownloadBytesPerSecond:
+		rate, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || rate < 0 {
+			return nil, fmt.Errorf("%s %q is not zero or a positive number", name, value)
+		}
+		if m.gcp == nil {
+			return nil, fmt.Errorf("%s can't be tuned without cloud printing", name)
+		}
+		return func() { m.gcp.SetDownloadRate(rate) }, nil
+
+	case tuneRequestsPerMinute:
+		perMinute, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%s %q is not zero or a positive number", name, value)
+		}
+		if m.gcp == nil {
+			return nil, fmt.Errorf("%s can't be tuned without cloud printing", name)
+		}
+		return func() { m.gcp.SetRequestsPerMinute(uint(perMinute)) }, nil
+
+	default:
+		return nil, fmt.Errorf("%s parameter %q is not tunable", monitorRequestTune, name)
+	}
+}
+
+// faults injects the faults of a faults request, like cups-submit-fail=25,
+// and gets the values of every fault. Nothing is injected unless every
+// fault is valid.
+func (m *Monitor) faults(args []string) (string, error) {
+	if !lib.FaultInjection {
+		return "", fmt.Errorf("%s needs the connector to be built with -tags faultinject", monitorRequestFaults)
+	}
+	names := make([]string, len(args))
+	values := make([]string, len(args))
+	for i, arg := range args {
+		kv := strings.SplitN(arg, "=", 2)
+		if len(kv) != 2 {
+			return "", fmt.Errorf("%s fault %q is not like name=value", monitorRequestFaults, arg)
+		}
+		if err := lib.CheckFault(kv[0], kv[1]); err != nil {
+			return "", err
+		}
+		names[i], values[i] = kv[0], kv[1]
+	}
+	for i := range args {
+		if err := lib.SetFault(names[i], values[i]); err != nil {
+			return "", err
+		}
+		log.Warningf("Injected fault %s", args[i])
+	}
+
+	faults := lib.Faults()
+	var response string
+	for _, name := range lib.FaultNames {
+		response += fmt.Sprintf("%s=%s\n", name, faults[name])
+	}
+	return response, nil
 }
 
 func (m *Monitor) getStats() (string, error) {
 	var cupsPrinterQuantity, rawPrinterQuantity, gcpPrinterQuantity, privetPrinterQuantity int
 
-	if cupsPrinters, err := m.cups.GetPrinters(); err != nil {
+	if cupsPrinters, err := m.cups.GetCachedPrinters(); err != nil {
 		return "", err
 	} else {
 		cupsPrinterQuantity = len(cupsPrinters)
@@ -115,8 +701,22 @@ func (m *Monitor) getStats() (string, error) {
 	cupsConnOpen := m.cups.ConnQtyOpen()
 	cupsConnMax := m.cups.ConnQtyMax()
 
+	cupsVersion, cupsQueues, cupsJobsPending, err := m.cups.ServerStats()
+	if err != nil {
+		return "", err
+	}
+
+	var instanceActive int
+	if m.pm.IsActive() {
+		instanceActive = 1
+	}
+
+	var gcpReauthorizationRequired int
 	if m.gcp != nil {
-		if gcpPrinters, err := m.gcp.List(); err != nil {
+		if m.gcp.ReauthorizationRequired() {
+			// GCP can't be listed until then, but the stats should say why.
+			gcpReauthorizationRequired = 1
+		} else if gcpPrinters, err := m.gcp.List(context.Background()); err != nil {
 			return "", err
 		} else {
 			gcpPrinterQuantity = len(gcpPrinters)
@@ -132,11 +732,59 @@ func (m *Monitor) getStats() (string, error) {
 		return "", err
 	}
 
+	syncQty, syncLast, syncMean, syncMax, submitMean, submitMax := m.pm.GetTimingStats()
+	ppdMean, ppdMax := m.cups.PPDStats()
+
 	stats := fmt.Sprintf(
 		monitorFormat,
-		cupsPrinterQuantity, rawPrinterQuantity, gcpPrinterQuantity, privetPrinterQuantity,
+		cupsPrinterQuantity, rawPrinterQuantity, gcpPrinterQuantity, gcpReauthorizationRequired, privetPrinterQuantity,
+		instanceActive,
 		cupsConnOpen, cupsConnMax,
-		jobsDone, jobsError, jobsProcessing)
+		cupsVersion, cupsQueues, cupsJobsPending,
+		jobsDone, jobsError, jobsProcessing,
+		syncQty, milliseconds(syncLast), milliseconds(syncMean), milliseconds(syncMax),
+		milliseconds(ppdMean), milliseconds(ppdMax),
+		milliseconds(submitMean), milliseconds(submitMax))
+
+	if m.gcp != nil {
+		throughput, total := m.gcp.DownloadThroughput()
+		stats += fmt.Sprintf("gcp-download-bytes-per-second=%d\ngcp-download-bytes=%d\n", throughput, total)
+		hits, misses := m.gcp.PrinterCacheStats()
+		stats += fmt.Sprintf("gcp-printer-cache-hits=%d\ngcp-printer-cache-misses=%d\n", hits, misses)
+		remaining, deferred := m.gcp.RequestQuotaStats()
+		stats += fmt.Sprintf("gcp-requests-per-minute=%d\ngcp-requests-remaining=%d\ngcp-requests-deferred=%d\n",
+			m.gcp.RequestsPerMinute(), remaining, deferred)
+	}
+
+	grows, shrinks := m.cups.ConnPoolResizes()
+	stats += fmt.Sprintf("cups-conn-pool-grows=%d\ncups-conn-pool-shrinks=%d\n", grows, shrinks)
+	opened, reused := m.cups.ConnReuse()
+	stats += fmt.Sprintf("cups-conn-opened=%d\ncups-conn-reused=%d\n", opened, reused)
+
+	available, staged := m.pm.AvailableUpdate()
+	stats += fmt.Sprintf("connector-version=%s\nupdate-available=%s\nupdate-staged=%s\n", lib.BuildDate, available, staged)
+
+	return stats + resourceStats(), nil
+}
+
+// resourceStats formats the goroutines and connections of each subsystem,
+// like goroutines-gcp=3 and connections-gcp=1.
+func resourceStats() string {
+	counts := lib.ResourceCounts()
+	subsystems := make([]string, 0, len(counts))
+	for subsystem := range counts {
+		subsystems = append(subsystems, subsystem)
+	}
+	sort.Strings(subsystems)
+
+	stats := fmt.Sprintf("goroutines=%d\n", runtime.NumGoroutine())
+	for _, subsystem := range subsystems {
+		stats += fmt.Sprintf("goroutines-%s=%d\nconnections-%s=%d\n",
+			subsystem, counts[subsystem].Goroutines, subsystem, counts[subsystem].Connections)
+	}
+	return stats
+}
 
-	return stats, nil
+func milliseconds(d time.Duration) int64 {
+	return int64(d / time.Millisecond)
 }
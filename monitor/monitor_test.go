@@ -0,0 +1,71 @@
+// Copyright 2015 Google Inc. All rights reserved.
+
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+// +build linux darwin freebsd
+
+package monitor
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// request sends a request to a monitor socket, and reads the response.
+func request(t *testing.T, socketFilename, request string) string {
+	conn, err := net.Dial("unix", socketFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err = conn.Write([]byte(request + "\n")); err != nil {
+		t.Fatal(err)
+	}
+	conn.(*net.UnixConn).CloseWrite()
+	b, err := ioutil.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestReadOnlySocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "monitor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socketFilename, readOnlySocketFilename := filepath.Join(dir, "monitor.sock"), filepath.Join(dir, "monitor-ro.sock")
+
+	// Without a connector to ask, the monitor would crash on any request
+	// that it didn't refuse.
+	m, err := NewMonitor(nil, nil, nil, nil, nil, socketFilename, readOnlySocketFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Quit()
+
+	var refused []string
+	for r := range changingRequests {
+		refused = append(refused, r+" printer job", r)
+	}
+	refused = append(refused, monitorRequestJobTickets, monitorRequestJobErrors, monitorRequestGoroutines, "some-future-request")
+	for _, r := range refused {
+		if response := request(t, readOnlySocketFilename, r); response != "error" {
+			t.Logf("expected the read-only socket to refuse %q, got %q", r, response)
+			t.Fail()
+		}
+	}
+
+	for _, r := range []string{"", monitorRequestStats, monitorRequestJobETAs + " 1234", monitorRequestSupplies, monitorRequestQuarantine, monitorRequestCapabilitiesDiff + " printer"} {
+		if !isReadOnly(r) {
+			t.Logf("expected the read-only socket to answer %q", r)
+			t.Fail()
+		}
+	}
+}
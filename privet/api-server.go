@@ -9,6 +9,7 @@ https://developers.google.com/open-source/licenses/bsd
 package privet
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"io"
@@ -76,15 +77,16 @@ type privetAPI struct {
 	online     bool
 	jc         *jobCache
 	jobs       chan<- *lib.Job
+	jobLimiter *lib.JobLimiter
 
 	getPrinter        func(string) (lib.Printer, bool)
-	getProximityToken func(string, string) ([]byte, int, error)
+	getProximityToken func(context.Context, string, string) ([]byte, int, error)
 
 	listener  *quittableListener
 	startTime time.Time
 }
 
-func newPrivetAPI(gcpID, name, gcpBaseURL string, xsrf xsrfSecret, online bool, jc *jobCache, jobs chan<- *lib.Job, getPrinter func(string) (lib.Printer, bool), getProximityToken func(string, string) ([]byte, int, error), listener *quittableListener) (*privetAPI, error) {
+func newPrivetAPI(gcpID, name, gcpBaseURL string, xsrf xsrfSecret, online bool, jc *jobCache, jobs chan<- *lib.Job, jobLimiter *lib.JobLimiter, getPrinter func(string) (lib.Printer, bool), getProximityToken func(context.Context, string, string) ([]byte, int, error), listener *quittableListener) (*privetAPI, error) {
 	api := &privetAPI{
 		gcpID:      gcpID,
 		name:       name,
@@ -93,6 +95,7 @@ func newPrivetAPI(gcpID, name, gcpBaseURL string, xsrf xsrfSecret, online bool,
 		online:     online,
 		jc:         jc,
 		jobs:       jobs,
+		jobLimiter: jobLimiter,
 
 		getPrinter:        getPrinter,
 		getProximityToken: getProximityToken,
@@ -100,7 +103,7 @@ func newPrivetAPI(gcpID, name, gcpBaseURL string, xsrf xsrfSecret, online bool,
 		listener:  listener,
 		startTime: time.Now(),
 	}
-	go api.serve()
+	lib.Go(lib.SubsystemPrivet, api.serve)
 
 	return api, nil
 }
@@ -254,7 +257,7 @@ func (api *privetAPI) accesstoken(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	responseBody, httpStatusCode, err := api.getProximityToken(api.gcpID, user)
+	responseBody, httpStatusCode, err := api.getProximityToken(r.Context(), api.gcpID, user)
 	if err != nil {
 		log.Errorf("Failed to get proximity token: %s", err)
 	}
@@ -381,6 +384,11 @@ func (api *privetAPI) submitdoc(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := api.jobLimiter.CheckBytes(api.name, r.ContentLength); err != nil {
+		writeError(w, "document_too_large", err.Error())
+		return
+	}
+
 	file, err := ioutil.TempFile("", "cloud-print-connector-privet-")
 	if err != nil {
 		log.Errorf("Failed to create file for new Privet job: %s", err)
@@ -389,7 +397,11 @@ func (api *privetAPI) submitdoc(w http.ResponseWriter, r *http.Request) {
 	}
 	defer file.Close()
 
-	jobSize, err := io.Copy(file, r.Body)
+	body := io.Reader(r.Body)
+	if max := api.jobLimiter.Limits(api.name).MaxBytes; max > 0 {
+		body = io.LimitReader(r.Body, max+1)
+	}
+	jobSize, err := io.Copy(file, body)
 	if err != nil {
 		log.Errorf("Failed to copy new print job file: %s", err)
 		w.WriteHeader(http.StatusInternalServerError)
@@ -441,6 +453,26 @@ func (api *privetAPI) submitdoc(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	file.Close()
+	if err = lib.PreparePDF(file.Name(), ticket); err != nil {
+		log.WarningJobf(jobID, "Failed to decrypt data: %s", err)
+		api.jc.updateJob(jobID, &cdd.PrintJobStateDiff{State: lib.PDFErrorState(err)})
+		writeError(w, "invalid_document", err.Error())
+		os.Remove(file.Name())
+		return
+	}
+	if err = api.jobLimiter.CheckPages(api.name, file.Name()); err != nil {
+		api.jc.updateJob(jobID, &cdd.PrintJobStateDiff{
+			State: &cdd.JobState{
+				Type:               cdd.JobStateAborted,
+				ServiceActionCause: &cdd.ServiceActionCause{ErrorCode: cdd.ServiceActionCauseConversionFileTooBig},
+			},
+		})
+		writeError(w, "document_too_large", err.Error())
+		os.Remove(file.Name())
+		return
+	}
+
 	api.jobs <- &lib.Job{
 		NativePrinterName: api.name,
 		Filename:          file.Name(),
@@ -448,7 +480,10 @@ func (api *privetAPI) submitdoc(w http.ResponseWriter, r *http.Request) {
 		User:              userName,
 		JobID:             jobID,
 		Ticket:            ticket,
-		UpdateJob:         api.jc.updateJob,
+		Deadline:          api.jobLimiter.Deadline(api.name, time.Now()),
+		UpdateJob: func(_ context.Context, jobID string, stateDiff *cdd.PrintJobStateDiff, _ string) error {
+			return api.jc.updateJob(jobID, stateDiff)
+		},
 	}
 
 	var response struct {
@@ -19,6 +19,7 @@ import (
 	"sync"
 	"unsafe"
 
+	"github.com/google/cloud-print-connector/lib"
 	"github.com/google/cloud-print-connector/log"
 )
 
@@ -49,6 +50,10 @@ type record struct {
 	group  *C.AvahiEntryGroup
 }
 
+func init() {
+	lib.AddBuildFeature("privet-avahi")
+}
+
 type zeroconf struct {
 	threadedPoll *C.AvahiThreadedPoll
 	client       *C.AvahiClient
@@ -78,7 +83,7 @@ func newZeroconf() (*zeroconf, error) {
 		return nil, err
 	}
 
-	go z.restartAndQuit()
+	lib.Go(lib.SubsystemPrivet, z.restartAndQuit)
 
 	return &z, nil
 }
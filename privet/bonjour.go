@@ -17,12 +17,17 @@ import (
 	"sync"
 	"unsafe"
 
+	"github.com/google/cloud-print-connector/lib"
 	"github.com/google/cloud-print-connector/log"
 )
 
 // TODO: How to add the _printer subtype?
 const serviceType = "_privet._tcp"
 
+func init() {
+	lib.AddBuildFeature("privet-bonjour")
+}
+
 type zeroconf struct {
 	printers map[string]C.CFNetServiceRef
 	pMutex   sync.RWMutex // Protects printers.
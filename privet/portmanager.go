@@ -15,6 +15,8 @@ import (
 	"sync"
 	"syscall"
 	"time"
+
+	"github.com/google/cloud-print-connector/lib"
 )
 
 var NoPortsAvailable = errors.New("No ports available")
@@ -99,7 +101,7 @@ type quittableListener struct {
 }
 
 func newQuittableListener(port uint16, pm *portManager) (*quittableListener, error) {
-	l, err := net.ListenTCP("tcp", &net.TCPAddr{Port: int(port)})
+	l, err := net.ListenTCP(lib.ListenNetwork("tcp"), &net.TCPAddr{Port: int(port)})
 	if err != nil {
 		return nil, err
 	}
@@ -120,11 +122,15 @@ func (l *quittableListener) Accept() (net.Conn, error) {
 	default:
 	}
 
+	if err != nil {
+		return nil, err
+	}
+
 	// Clean up zombie connections.
 	conn.SetKeepAlive(true)
 	conn.SetKeepAlivePeriod(time.Minute)
 
-	return conn, err
+	return lib.TrackConn(lib.SubsystemPrivet, conn), nil
 }
 
 func (l *quittableListener) Close() error {
@@ -9,6 +9,7 @@ https://developers.google.com/open-source/licenses/bsd
 package privet
 
 import (
+	"context"
 	"fmt"
 	"sync"
 
@@ -23,17 +24,18 @@ type Privet struct {
 	zc        *zeroconf
 	pm        *portManager
 
-	jobs chan<- *lib.Job
-	jc   jobCache
+	jobs       chan<- *lib.Job
+	jc         jobCache
+	jobLimiter *lib.JobLimiter
 
 	gcpBaseURL        string
-	getProximityToken func(string, string) ([]byte, int, error)
+	getProximityToken func(context.Context, string, string) ([]byte, int, error)
 }
 
 // NewPrivet constructs a new Privet object.
 //
 // getProximityToken should be GoogleCloudPrint.ProximityToken()
-func NewPrivet(jobs chan<- *lib.Job, portLow, portHigh uint16, gcpBaseURL string, getProximityToken func(string, string) ([]byte, int, error)) (*Privet, error) {
+func NewPrivet(jobs chan<- *lib.Job, portLow, portHigh uint16, gcpBaseURL string, getProximityToken func(context.Context, string, string) ([]byte, int, error), jobLimiter *lib.JobLimiter) (*Privet, error) {
 	zc, err := newZeroconf()
 	if err != nil {
 		return nil, err
@@ -45,8 +47,9 @@ func NewPrivet(jobs chan<- *lib.Job, portLow, portHigh uint16, gcpBaseURL string
 		zc:   zc,
 		pm:   newPortManager(portLow, portHigh),
 
-		jobs: jobs,
-		jc:   *newJobCache(),
+		jobs:       jobs,
+		jc:         *newJobCache(),
+		jobLimiter: jobLimiter,
 
 		gcpBaseURL:        gcpBaseURL,
 		getProximityToken: getProximityToken,
@@ -67,7 +70,7 @@ func (p *Privet) AddPrinter(printer lib.Printer, getPrinter func(string) (lib.Pr
 		return err
 	}
 
-	api, err := newPrivetAPI(printer.GCPID, printer.Name, p.gcpBaseURL, p.xsrf, online, &p.jc, p.jobs, getPrinter, p.getProximityToken, listener)
+	api, err := newPrivetAPI(printer.GCPID, printer.Name, p.gcpBaseURL, p.xsrf, online, &p.jc, p.jobs, p.jobLimiter, getPrinter, p.getProximityToken, listener)
 	if err != nil {
 		return err
 	}
@@ -39,6 +39,10 @@ var winspoolPDS = cdd.PrinterDescriptionSection{
 	},
 }
 
+func init() {
+	lib.AddBuildFeature("winspool")
+}
+
 // Interface between Go and the Windows API.
 type WinSpool struct {
 	prefixJobIDToJobTitle bool
@@ -85,6 +89,9 @@ func getSystemTags() (map[string]string, error) {
 	tags["system-arch"] = runtime.GOARCH
 	tags["system-golang-version"] = runtime.Version()
 	tags["system-windows-version"] = GetWindowsVersion()
+	for k, v := range lib.BuildInfoTags() {
+		tags[k] = v
+	}
 
 	return tags, nil
 }
@@ -595,6 +602,9 @@ func (ws *WinSpool) GetJobState(printerName string, jobID uint32) (*cdd.PrintJob
 	jobState := cdd.PrintJobStateDiff{
 		State: convertJobState(ji1.GetStatus()),
 	}
+	if pages := int32(ji1.GetPagesPrinted()); pages > 0 {
+		jobState.PagesPrinted = &pages
+	}
 	return &jobState, nil
 }
 
@@ -918,6 +928,17 @@ func (ws *WinSpool) ReleaseJob(printerName string, jobID uint32) error {
 	return nil
 }
 
+// CancelJob cancels a job that is printing.
+func (ws *WinSpool) CancelJob(printerName string, jobID uint32) error {
+	hPrinter, err := OpenPrinter(printerName)
+	if err != nil {
+		return err
+	}
+
+	// JOB_CONTROL_CANCEL is deprecated in favor of JOB_CONTROL_DELETE.
+	return hPrinter.SetJobCommand(int32(jobID), JOB_CONTROL_DELETE)
+}
+
 // The following functions are not relevant to Windows printing, but are required by the NativePrintSystem interface.
 
 func (ws *WinSpool) RemoveCachedPPD(printerName string) {}
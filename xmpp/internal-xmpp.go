@@ -11,6 +11,7 @@ package xmpp
 
 import (
 	"bufio"
+	"context"
 	"crypto/tls"
 	"encoding/base64"
 	"encoding/xml"
@@ -24,6 +25,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/google/cloud-print-connector/lib"
 	"github.com/google/cloud-print-connector/log"
 )
 
@@ -106,7 +108,7 @@ func newInternalXMPP(jid, accessToken, proxyName, server string, port uint16, pi
 
 	// dispatchIncoming signals pingPeriodically to return via dying.
 	dying := make(chan struct{})
-	go x.dispatchIncoming(dying)
+	lib.Go(lib.SubsystemXMPP, func() { x.dispatchIncoming(dying) })
 
 	// Check by ping
 	if success, err := x.ping(pingTimeout); !success {
@@ -115,7 +117,7 @@ func newInternalXMPP(jid, accessToken, proxyName, server string, port uint16, pi
 		return nil, fmt.Errorf("XMPP conversation started, but initial ping failed: %s", err)
 	}
 
-	go x.pingPeriodically(pingTimeout, pingInterval, dying)
+	lib.Go(lib.SubsystemXMPP, func() { x.pingPeriodically(pingTimeout, pingInterval, dying) })
 
 	return &x, nil
 }
@@ -251,6 +253,9 @@ func (x *internalXMPP) ping(timeout time.Duration) (bool, error) {
 	ping.Type = "get"
 	ping.Ping.XMLNS = "urn:xmpp:ping"
 
+	if lib.FaultPercent(lib.FaultXMPPDrop) {
+		return false, fmt.Errorf("XMPP ping dropped by injected fault %s", lib.FaultXMPPDrop)
+	}
 	if err := x.xmlEncoder.Encode(&ping); err != nil {
 		return false, fmt.Errorf("XMPP ping request failed: %s", err)
 	}
@@ -269,7 +274,7 @@ func (x *internalXMPP) ping(timeout time.Duration) (bool, error) {
 }
 
 func dialViaHTTPProxy(server string, port uint16) (*tls.Conn, error) {
-	xmppHost := fmt.Sprintf("%s:%d", server, port)
+	xmppHost := net.JoinHostPort(server, strconv.Itoa(int(port)))
 	fakeRequest := http.Request{
 		URL: &url.URL{
 			Scheme: "https",
@@ -293,10 +298,11 @@ func dialViaHTTPProxy(server string, port uint16) (*tls.Conn, error) {
 		KeepAlive: netKeepAlive,
 		Timeout:   netTimeout,
 	}
-	conn, err := dialer.Dial("tcp", proxyURL.Host)
+	conn, err := lib.DialHappyEyeballs(context.Background(), &dialer, "tcp", proxyURL.Host)
 	if err != nil {
 		return nil, fmt.Errorf("Failed to connect to HTTP proxy server: %s", err)
 	}
+	conn = lib.TrackConn(lib.SubsystemXMPP, conn)
 
 	proxyAuth := ""
 	if u := proxyURL.User; u != nil {
@@ -306,7 +312,7 @@ func dialViaHTTPProxy(server string, port uint16) (*tls.Conn, error) {
 		proxyAuth = "Proxy-Authorization: Basic " + basicAuth + "\r\n"
 	}
 
-	fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n%s\r\n", xmppHost, xmppHost, proxyAuth)
+	fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\nUser-Agent: %s\r\n%s\r\n", xmppHost, xmppHost, lib.UserAgent(), proxyAuth)
 
 	response, err := http.ReadResponse(bufio.NewReader(conn), &http.Request{Method: "CONNECT"})
 	if err != nil {
@@ -324,10 +330,11 @@ func dial(server string, port uint16) (*tls.Conn, error) {
 		KeepAlive: netKeepAlive,
 		Timeout:   netTimeout,
 	}
-	conn, err := dialer.Dial("tcp", fmt.Sprintf("%s:%d", server, port))
+	conn, err := lib.DialHappyEyeballs(context.Background(), &dialer, "tcp", net.JoinHostPort(server, strconv.Itoa(int(port))))
 	if err != nil {
 		return nil, fmt.Errorf("Failed to connect to XMPP server: %s", err)
 	}
+	conn = lib.TrackConn(lib.SubsystemXMPP, conn)
 
 	return addTLS(server, conn)
 }
@@ -358,6 +365,9 @@ func saslHandshake(xmlEncoder *xml.Encoder, xmlDecoder *xml.Decoder, domain, use
 			xml.Attr{xml.Name{Local: "xml:lang"}, "en"},
 			xml.Attr{xml.Name{Local: "version"}, "1.0"},
 			xml.Attr{xml.Name{Local: "xmlns:stream"}, "http://etherx.jabber.org/streams"},
+			// Servers ignore attributes they don't know; this one tells
+			// their logs which connector opened the stream.
+			xml.Attr{xml.Name{Local: "user-agent"}, lib.UserAgent()},
 		},
 	}
 	if err := xmlEncoder.EncodeToken(handshake); err != nil {
@@ -425,6 +435,9 @@ func xmppHandshake(xmlEncoder *xml.Encoder, xmlDecoder *xml.Decoder, domain, pro
 			xml.Attr{xml.Name{Local: "xml:lang"}, "en"},
 			xml.Attr{xml.Name{Local: "version"}, "1.0"},
 			xml.Attr{xml.Name{Local: "xmlns:stream"}, "http://etherx.jabber.org/streams"},
+			// Servers ignore attributes they don't know; this one tells
+			// their logs which connector opened the stream.
+			xml.Attr{xml.Name{Local: "user-agent"}, lib.UserAgent()},
 		},
 	}
 	if err := xmlEncoder.EncodeToken(handshake); err != nil {
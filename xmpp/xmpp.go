@@ -12,6 +12,7 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/google/cloud-print-connector/lib"
 	"github.com/google/cloud-print-connector/log"
 )
 
@@ -20,6 +21,9 @@ type PrinterNotificationType uint8
 const (
 	PrinterNewJobs PrinterNotificationType = iota
 	PrinterDelete
+	// Reconnected is sent, without a GCPID, when XMPP restarts, because
+	// notifications may have been missed while it was down.
+	Reconnected
 )
 
 type PrinterNotification struct {
@@ -62,7 +66,7 @@ func NewXMPP(jid, proxyName, server string, port uint16, pingTimeout, pingInterv
 	if err != nil {
 		return nil, err
 	}
-	go x.keepXMPPAlive()
+	lib.Go(lib.SubsystemXMPP, x.keepXMPPAlive)
 
 	return &x, nil
 }
@@ -84,7 +88,7 @@ func (x *XMPP) Quit() {
 // Tries multiple times before returning an error.
 func (x *XMPP) startXMPP() error {
 	if x.ix != nil {
-		go x.ix.Quit()
+		lib.Go(lib.SubsystemXMPP, x.ix.Quit)
 		x.ix = nil
 	}
 
@@ -117,6 +121,12 @@ func (x *XMPP) keepXMPPAlive() {
 				}
 				log.Error("XMPP conversation restarted successfully")
 			}
+			select {
+			case x.notifications <- PrinterNotification{"", Reconnected}:
+			case <-x.quit:
+				x.ix.Quit()
+				return
+			}
 
 		case <-x.quit:
 			// Close XMPP.